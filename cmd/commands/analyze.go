@@ -0,0 +1,193 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var AnalyzeCommand = cli.Command{
+	Name:  "analyze",
+	Usage: "analyze container filesystems",
+	Subcommands: cli.Commands{
+		analyzeIntegrity,
+		analyzeEgress,
+		analyzeHooks,
+		analyzeMesh,
+		analyzeProfileDrift,
+		analyzeRun,
+	},
+}
+
+var analyzeIntegrity = cli.Command{
+	Name:  "integrity",
+	Usage: "verify the files of the installed packages against the package manager digests",
+	Description: `verify the files owned by the installed dpkg and rpm packages against
+   the digests recorded by the package manager inside the container i.e. a
+   trojaned /bin/ps. No external baseline is required.
+
+   The dpkg digests are read from /var/lib/dpkg/info/*.md5sums and do not
+   cover the configuration files. The rpm digests are read from the rpm
+   database. The sqlite rpm database requires the sqlite3 command.
+
+   LAYER is the layer index of the file where 0 is the container's writable
+   layer i.e. a file modified after the container started.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "container ID",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "include the verified files",
+		},
+		cli.BoolFlag{
+			Name:  "include-config",
+			Usage: "include the modified configuration files",
+		},
+		explainFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerIntegrity); err != nil {
+			return err
+		}
+
+		containerid := clictx.String("id")
+		if containerid == "" {
+			return fmt.Errorf("container id is required")
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctx = namespaces.WithNamespace(ctx, clictx.GlobalString("namespace"))
+		upperdir, lowerdirs, err := exp.ContainerLayers(ctx, containerid)
+		if err != nil {
+			return err
+		}
+
+		results, err := explorers.VerifyPackageFiles(append([]string{upperdir}, lowerdirs...))
+		if err != nil {
+			return err
+		}
+
+		if len(results) == 0 {
+			log.WithField("containerid", containerid).Warn("no dpkg or rpm package digests found")
+			return nil
+		}
+
+		counts := make(map[string]int)
+		var findings []explorers.FileIntegrity
+		for _, r := range results {
+			counts[r.Status]++
+			if r.Status == explorers.IntegrityOK && !clictx.Bool("all") {
+				continue
+			}
+			if r.Config && r.Status != explorers.IntegrityOK && !clictx.Bool("include-config") {
+				continue
+			}
+			findings = append(findings, r)
+		}
+		log.WithFields(log.Fields{
+			"containerid": containerid,
+			"files":       len(results),
+		}).Info("verified package files: ", countString(counts))
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, f := range findings {
+				explanations = append(explanations, explainIntegrity(f))
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, f := range findings {
+				printObject(output, f)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("STATUS", "TYPE", "PACKAGE", "PATH", "LAYER", "ALGORITHM", "EXPECTED", "ACTUAL")
+		for _, f := range findings {
+			layer := ""
+			if f.Layer >= 0 {
+				layer = fmt.Sprint(f.Layer)
+			}
+			rw.Write(
+				f.Status,
+				f.Type,
+				f.Package,
+				f.Path,
+				layer,
+				f.Algorithm,
+				f.Digest,
+				f.Actual,
+			)
+		}
+		return nil
+	},
+}
+
+// explainIntegrity returns the explanation of a package file verification.
+func explainIntegrity(f explorers.FileIntegrity) explanation {
+	e := explanation{
+		Finding: fmt.Sprintf("%s %s of %s package %s", f.Status, f.Path, f.Type, f.Package),
+		Evidence: []evidence{
+			{Source: f.Source, Field: f.Path, Value: fmt.Sprintf("%s %s", f.Algorithm, f.Digest)},
+		},
+	}
+
+	switch f.Status {
+	case explorers.IntegrityMissing:
+		e.Rule = "the file is recorded by the package manager but not found in any container layer"
+	case explorers.IntegrityReplaced:
+		e.Rule = "the file is recorded as a regular file by the package manager but is not a regular file in the container"
+	case explorers.IntegrityModified:
+		e.Rule = fmt.Sprintf("the %s digest of the file differs from the digest recorded by the package manager", f.Algorithm)
+	default:
+		e.Rule = fmt.Sprintf("the %s digest of the file matches the digest recorded by the package manager", f.Algorithm)
+	}
+	if f.Config {
+		e.Rule += "; the file is a configuration file expected to change"
+	}
+
+	if f.DiskPath != "" {
+		layer := fmt.Sprintf("layer %d", f.Layer)
+		if f.Layer == 0 {
+			layer = "layer 0 (writable layer, changed after the container started)"
+		}
+		e.Evidence = append(e.Evidence, evidence{Source: f.DiskPath, Field: "layer", Value: layer})
+		e.Evidence = append(e.Evidence, evidence{Source: f.DiskPath, Field: "mtime", Value: "last modified", Time: f.ModTime})
+	}
+	if f.Actual != "" {
+		e.Evidence = append(e.Evidence, evidence{Source: f.DiskPath, Field: f.Algorithm, Value: f.Actual})
+	}
+	return e
+}
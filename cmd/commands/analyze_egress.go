@@ -0,0 +1,231 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// egressSourceSpec is the source of the endpoints named in the environment
+// variables of the container spec.
+const egressSourceSpec = "container spec"
+
+// egressFinding is an endpoint a container is expected to connect to.
+type egressFinding struct {
+	Namespace   string `json:"namespace,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	explorers.EgressEndpoint
+}
+
+var analyzeEgress = cli.Command{
+	Name:  "egress",
+	Usage: "infer the expected network egress of containers from the application configuration",
+	Description: `list the endpoints named in the configuration files of each container
+   i.e. nginx upstream servers and proxy_pass addresses, the URLs and the
+   *_HOST variables of the application .env files and the container
+   environment, the kubeconfig cluster servers, and the apt, yum, apk, pip,
+   and npm package registries.
+
+   Compare the expected egress with the network telemetry of the node i.e.
+   flow logs or DNS logs. A connection to an endpoint that is not expected is
+   worth a closer look.
+
+   The loopback endpoints, the endpoints using variables, and the service
+   link variables injected by kubelet or docker are omitted. A URL without a
+   port is reported with the default port of the scheme.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "analyze only the specified container ID",
+		},
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "mounted container filesystem directory",
+		},
+		cli.StringFlag{
+			Name:  "kind",
+			Usage: "report only the endpoints of the kind nginx, env, kubeconfig, or registry",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		explainFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerEgress); err != nil {
+			return err
+		}
+
+		kind := clictx.String("kind")
+		switch kind {
+		case "", explorers.EgressNginx, explorers.EgressEnv, explorers.EgressKubeconfig, explorers.EgressRegistry:
+		default:
+			return fmt.Errorf("unsupported kind %s", kind)
+		}
+
+		var findings []egressFinding
+		add := func(ctr explorers.Container, endpoints []explorers.EgressEndpoint) {
+			for _, e := range endpoints {
+				if kind != "" && e.Kind != kind {
+					continue
+				}
+				findings = append(findings, egressFinding{
+					Namespace:      ctr.Namespace,
+					ContainerID:    ctr.ID,
+					EgressEndpoint: e,
+				})
+			}
+		}
+
+		if dir := clictx.String("path"); dir != "" {
+			endpoints, err := explorers.InferEgress([]string{dir})
+			if err != nil {
+				return err
+			}
+			add(explorers.Container{}, endpoints)
+		} else {
+			ctx, exp, cancel, err := explorerEnvironment(clictx)
+			if err != nil {
+				return err
+			}
+			defer cancel()
+
+			ctrs, err := exp.ListContainers(ctx)
+			if err != nil {
+				return err
+			}
+			for _, ctr := range ctrs {
+				if id := clictx.String("id"); id != "" && ctr.ID != id {
+					continue
+				}
+				if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+					continue
+				}
+
+				layers, err := containerLayers(ctx, exp, ctr)
+				if err != nil {
+					log.WithField("containerid", ctr.ID).Warn("getting container layers: ", err)
+				} else if endpoints, err := explorers.InferEgress(layers); err != nil {
+					log.WithField("containerid", ctr.ID).Warn("reading container configuration: ", err)
+				} else {
+					add(ctr, endpoints)
+				}
+
+				nsctx := namespaces.WithNamespace(ctx, ctr.Namespace)
+				spec, err := containerSpecJSON(nsctx, clictx, exp, ctr)
+				if err != nil {
+					log.WithField("containerid", ctr.ID).Warn("reading container spec: ", err)
+					continue
+				}
+				endpoints := explorers.EgressFromEnv(specEnv(spec))
+				for i := range endpoints {
+					endpoints[i].Source = egressSourceSpec
+					endpoints[i].Layer = -1
+				}
+				add(ctr, endpoints)
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, f := range findings {
+				explanations = append(explanations, explainEgress(f))
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, f := range findings {
+				printObject(output, f)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("NAMESPACE", "CONTAINER ID", "HOST", "PORT", "SCHEME", "KIND", "SOURCE", "FIELD")
+		for _, f := range findings {
+			port := ""
+			if f.Port != 0 {
+				port = fmt.Sprint(f.Port)
+			}
+			rw.Write(
+				f.Namespace,
+				f.ContainerID,
+				f.Host,
+				port,
+				f.Scheme,
+				f.Kind,
+				f.Source,
+				f.Field,
+			)
+		}
+		return nil
+	},
+}
+
+// specEnv returns the environment variables of the container spec or the
+// docker container configuration i.e. process.env or Config.Env.
+func specEnv(spec []byte) []string {
+	var env []string
+	for _, v := range jsonStrings(spec) {
+		if p := strings.ToLower(v[0]); strings.HasSuffix(p, "]") && strings.Contains(p, "env[") {
+			env = append(env, v[1])
+		}
+	}
+	return env
+}
+
+// explainEgress returns the explanation of an expected egress endpoint.
+func explainEgress(f egressFinding) explanation {
+	address := f.Host
+	if f.Port != 0 {
+		address = fmt.Sprintf("%s:%d", f.Host, f.Port)
+	}
+
+	e := explanation{
+		Namespace:   f.Namespace,
+		ContainerID: f.ContainerID,
+		Finding:     fmt.Sprintf("container is expected to connect to %s", address),
+		Evidence: []evidence{
+			{Source: f.Source, Field: f.Field, Value: address},
+		},
+	}
+	switch f.Kind {
+	case explorers.EgressNginx:
+		e.Rule = "nginx proxies requests to the upstream server or the proxied address"
+	case explorers.EgressKubeconfig:
+		e.Rule = "the kubeconfig names the API server of a cluster the container can manage"
+	case explorers.EgressRegistry:
+		e.Rule = "the package manager downloads packages from the configured registry"
+	default:
+		e.Rule = "the application reads the endpoint from the environment variable"
+	}
+	if f.Layer >= 0 {
+		e.Evidence = append(e.Evidence, evidence{Source: f.Source, Field: "layer", Value: fmt.Sprint(f.Layer)})
+	}
+	return e
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// hookFinding is an OCI hook of a container or of a hook configuration file
+// of the host with its executable.
+type hookFinding struct {
+	Namespace   string `json:"namespace,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	explorers.Hook
+	File      explorers.HookFile `json:"file"`
+	Flagged   bool               `json:"flagged"`
+	Extracted string             `json:"extracted,omitempty"`
+}
+
+var analyzeHooks = cli.Command{
+	Name:  "hooks",
+	Usage: "extract the OCI hooks of the containers and verify the hook executables",
+	Description: `list the OCI hooks i.e. prestart, createRuntime, and poststop of the
+   container specs and of the hook configuration files of the host in
+   /usr/share/containers/oci/hooks.d and /etc/containers/oci/hooks.d, locate
+   the hook executables, hash them, and verify them against the dpkg and rpm
+   databases.
+
+   The hooks run with the privileges of the runtime and are a persistence
+   and escape mechanism. A hook executable not owned by a package
+   (unpackaged), differing from the package digest (modified), or not found
+   in the evidence (missing) is flagged.
+
+   The hook executables are located in the image root except the
+   startContainer hooks located in the container filesystem. Docker does not
+   store the OCI spec of the containers, so only the hook configuration
+   files are read for docker.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "report only the specified container ID",
+		},
+		cli.BoolFlag{
+			Name:  "flagged",
+			Usage: "report only the flagged hooks",
+		},
+		cli.StringFlag{
+			Name:  "extract-dir",
+			Usage: "copy the hook executables and scripts to the directory",
+		},
+		explainFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerHooks); err != nil {
+			return err
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		imageroot := clictx.GlobalString("image-root")
+		var hostlayers []string
+		if imageroot != "" {
+			hostlayers = []string{imageroot}
+		} else {
+			log.Warn("image-root is empty. The hook executables of the host are not located")
+		}
+		hostindex := explorers.ReadPackageIndex(hostlayers)
+
+		var findings []hookFinding
+		inspect := func(f hookFinding, layers []string, index explorers.PackageIndex) {
+			if len(layers) > 0 {
+				f.File = explorers.InspectHookFile(layers, f.Path, index)
+				f.Flagged = f.File.Status != explorers.HookPackaged
+			}
+			findings = append(findings, f)
+		}
+
+		if clictx.String("id") == "" {
+			for _, h := range explorers.ReadHookConfigs(imageroot) {
+				inspect(hookFinding{Hook: h}, hostlayers, hostindex)
+			}
+		}
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+		docker := isDockerRuntime(selectedRuntime(clictx))
+		found := false
+		for _, ctr := range ctrs {
+			if id := clictx.String("id"); id != "" && ctr.ID != id {
+				continue
+			}
+			found = true
+			if docker {
+				continue
+			}
+
+			spec, err := containerOCISpec(ctx, exp, ctr)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("reading container spec: ", err)
+				continue
+			}
+
+			var (
+				layers []string
+				index  explorers.PackageIndex
+			)
+			for _, h := range explorers.SpecHooks(spec) {
+				f := hookFinding{Namespace: ctr.Namespace, ContainerID: ctr.ID, Hook: h}
+				if !h.ContainerNamespace() {
+					inspect(f, hostlayers, hostindex)
+					continue
+				}
+				if layers == nil {
+					if layers, err = containerLayers(ctx, exp, ctr); err != nil {
+						log.WithField("containerid", ctr.ID).Warn("getting container layers: ", err)
+					}
+					index = explorers.ReadPackageIndex(layers)
+				}
+				inspect(f, layers, index)
+			}
+		}
+		if id := clictx.String("id"); id != "" && !found {
+			return fmt.Errorf("container %s not found", id)
+		}
+
+		if clictx.Bool("flagged") || clictx.Bool("explain") {
+			var flagged []hookFinding
+			for _, f := range findings {
+				if f.Flagged {
+					flagged = append(flagged, f)
+				}
+			}
+			findings = flagged
+		}
+
+		if dir := clictx.String("extract-dir"); dir != "" {
+			if err := extractHookFiles(dir, findings); err != nil {
+				return err
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, f := range findings {
+				explanations = append(explanations, explainHook(f))
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, f := range findings {
+				printObject(output, f)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("NAMESPACE", "CONTAINER ID", "STAGE", "STATUS", "PACKAGE", "SHA256", "PATH", "ARGS", "SOURCE")
+		for _, f := range findings {
+			path := f.Path
+			if f.File.Path != "" && f.File.Path != f.Path {
+				path = fmt.Sprintf("%s -> %s", f.Path, f.File.Path)
+			}
+			rw.Write(
+				f.Namespace,
+				f.ContainerID,
+				f.Stage,
+				f.File.Status,
+				f.File.Package,
+				f.File.SHA256,
+				path,
+				strings.Join(f.Args, " "),
+				f.Source,
+			)
+		}
+		return nil
+	},
+}
+
+// containerOCISpec returns the OCI runtime spec of a container.
+func containerOCISpec(ctx context.Context, exp explorers.ContainerExplorer, ctr explorers.Container) (*specs.Spec, error) {
+	v, err := exp.InfoContainer(namespaces.WithNamespace(ctx, ctr.Namespace), ctr.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("container %s has no spec", ctr.ID)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var spec specs.Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("unmarshalling container spec: %w", err)
+	}
+	return &spec, nil
+}
+
+// extractHookFiles copies the hook executables to dir. A file is named
+// using the prefix of its digest and its name, so the executable shared by
+// several containers is copied once.
+func extractHookFiles(dir string, findings []hookFinding) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating extract directory %s: %w", dir, err)
+	}
+
+	extracted := 0
+	for i := range findings {
+		f := &findings[i]
+		if f.File.DiskPath == "" || f.File.SHA256 == "" {
+			continue
+		}
+		dst := filepath.Join(dir, fmt.Sprintf("%s-%s", f.File.SHA256[:12], filepath.Base(f.File.Path)))
+		if !explorers.PathExists(dst, true) {
+			info, err := os.Stat(f.File.DiskPath)
+			if err == nil {
+				err = explorers.CopyFile(f.File.DiskPath, dst, info)
+			}
+			if err != nil {
+				log.WithField("path", f.File.DiskPath).Warn("extracting hook file: ", err)
+				continue
+			}
+			extracted++
+		}
+		f.Extracted = dst
+	}
+	log.WithFields(log.Fields{
+		"dir":   dir,
+		"files": extracted,
+	}).Info("extracted hook files")
+	return nil
+}
+
+// explainHook returns the explanation of a flagged hook.
+func explainHook(f hookFinding) explanation {
+	e := explanation{
+		Namespace:   f.Namespace,
+		ContainerID: f.ContainerID,
+		Finding:     fmt.Sprintf("%s %s hook %s", f.File.Status, f.Stage, f.Path),
+	}
+
+	switch f.File.Status {
+	case explorers.HookUnpackaged:
+		e.Rule = "the hook executable is not owned by a dpkg or rpm package, so it was installed outside the distribution"
+	case explorers.HookModified:
+		e.Rule = fmt.Sprintf("the hook executable differs from the digest recorded by the package %s", f.File.Package)
+	case explorers.HookMissing:
+		e.Rule = "the hook executable is not found in the evidence i.e. deleted after use or on a volatile file system"
+	}
+
+	e.Evidence = append(e.Evidence, evidence{Source: f.Source, Field: f.Field + ".path", Value: f.Path})
+	if len(f.Args) > 0 {
+		e.Evidence = append(e.Evidence, evidence{Source: f.Source, Field: f.Field + ".args", Value: strings.Join(f.Args, " ")})
+	}
+	if f.When != "" {
+		e.Evidence = append(e.Evidence, evidence{Source: f.Source, Field: "when", Value: f.When})
+	}
+	if f.File.DiskPath != "" {
+		e.Evidence = append(e.Evidence, evidence{Source: f.File.DiskPath, Field: "sha256", Value: f.File.SHA256})
+		e.Evidence = append(e.Evidence, evidence{Source: f.File.DiskPath, Field: "mtime", Value: "last modified", Time: f.File.ModTime})
+	}
+	if f.File.Interpreter != "" {
+		e.Evidence = append(e.Evidence, evidence{Source: f.File.DiskPath, Field: "interpreter", Value: f.File.Interpreter})
+	}
+	return e
+}
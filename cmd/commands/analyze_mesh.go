@@ -0,0 +1,232 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// The Istio proxy configuration directory holding the envoy bootstrap and
+// the emptyDir volume of the pod mounted on the directory.
+const (
+	istioProxyDir    = "etc/istio/proxy"
+	istioProxyVolume = "istio-envoy"
+)
+
+var analyzeMesh = cli.Command{
+	Name:  "mesh",
+	Usage: "pair service mesh sidecars with their application containers and extract the proxy configuration",
+	Description: `list the Istio and Linkerd sidecar proxies, the application containers of
+   their pod, and the traffic configuration of each proxy. The mesh
+   configuration defines the network paths the workload had.
+
+   The traffic redirected to the proxy is read from the arguments of the
+   istio-init or linkerd-init container. The control plane, the workload
+   identity, and the mTLS state are read from the environment of the proxy
+   container. The static listeners and clusters of the Istio proxy are read
+   from the envoy bootstrap envoy-rev.json in the proxy layers or in the
+   istio-envoy emptyDir volume of the pod.
+
+   The routes received from the control plane and the PeerAuthentication
+   policies are not stored on the node. An mTLS state of enabled means the
+   proxy is issued a workload certificate, and required means the proxy
+   accepts only authenticated peers.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "pod-uid",
+			Usage: "analyze only the sidecars of the specified Kubernetes pod UID",
+		},
+		cli.StringFlag{
+			Name:  "mesh",
+			Usage: "report only the sidecars of the mesh istio or linkerd",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		mesh := clictx.String("mesh")
+		switch mesh {
+		case "", explorers.MeshIstio, explorers.MeshLinkerd:
+		default:
+			return fmt.Errorf("unsupported mesh %s", mesh)
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		byid := make(map[string]explorers.Container)
+		for _, ctr := range ctrs {
+			byid[ctr.Namespace+"/"+ctr.ID] = ctr
+		}
+
+		imageroot := clictx.GlobalString("image-root")
+		kubelet := selectedPack(clictx).Kubelet()
+
+		var sidecars []explorers.MeshSidecar
+		for _, s := range explorers.MeshSidecars(ctrs) {
+			if mesh != "" && s.Mesh != mesh {
+				continue
+			}
+			if uid := clictx.String("pod-uid"); uid != "" && s.PodUID != uid {
+				continue
+			}
+
+			nsctx := namespaces.WithNamespace(ctx, s.Namespace)
+			if spec, err := containerSpecJSON(nsctx, clictx, exp, byid[s.Namespace+"/"+s.ContainerID]); err != nil {
+				log.WithField("containerid", s.ContainerID).Warn("reading sidecar spec: ", err)
+			} else {
+				s.ApplyMeshSettings(specEnv(spec))
+			}
+
+			if s.InitContainer != "" {
+				if spec, err := containerSpecJSON(nsctx, clictx, exp, byid[s.Namespace+"/"+s.InitContainer]); err != nil {
+					log.WithField("containerid", s.InitContainer).Warn("reading init container spec: ", err)
+				} else {
+					s.Interception = explorers.ParseMeshInterception(s.Mesh, specArgs(spec))
+				}
+			}
+
+			if s.Mesh == explorers.MeshIstio {
+				var dirs []string
+				if layers, err := containerLayers(ctx, exp, byid[s.Namespace+"/"+s.ContainerID]); err != nil {
+					log.WithField("containerid", s.ContainerID).Warn("getting sidecar layers: ", err)
+				} else {
+					for _, layer := range layers {
+						dirs = append(dirs, filepath.Join(layer, istioProxyDir))
+					}
+				}
+				if imageroot != "" && s.PodUID != "" {
+					dirs = append(dirs, filepath.Join(imageroot, kubelet, "pods", s.PodUID, "volumes", "kubernetes.io~empty-dir", istioProxyVolume))
+				}
+				for _, path := range explorers.FindEnvoyBootstrap(dirs) {
+					b, err := explorers.ReadEnvoyBootstrap(path)
+					if err != nil {
+						log.WithField("containerid", s.ContainerID).Warn("reading envoy bootstrap: ", err)
+						continue
+					}
+					s.ApplyEnvoyBootstrap(b)
+				}
+			}
+
+			sidecars = append(sidecars, s)
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, s := range sidecars {
+				printObject(output, s)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("NAMESPACE", "POD", "MESH", "SIDECAR", "APPLICATIONS", "INTERCEPTION", "MTLS", "IDENTITY", "CONTROL PLANE", "LISTENERS", "CLUSTERS")
+		for _, s := range sidecars {
+			var apps []string
+			for _, app := range s.Applications {
+				name := app.Name
+				if name == "" {
+					name = app.ContainerID
+				}
+				apps = append(apps, name)
+			}
+
+			var listeners, clusters int
+			for _, b := range s.Bootstrap {
+				listeners += len(b.Listeners)
+				clusters += len(b.Clusters)
+			}
+
+			pod := s.PodName
+			if s.PodNamespace != "" {
+				pod = s.PodNamespace + "/" + s.PodName
+			}
+			rw.Write(
+				s.Namespace,
+				pod,
+				s.Mesh,
+				s.ContainerID,
+				strings.Join(apps, ","),
+				interceptionString(s.Interception),
+				s.MTLS,
+				s.Identity,
+				s.ControlPlane,
+				fmt.Sprint(listeners),
+				fmt.Sprint(clusters),
+			)
+		}
+		return nil
+	},
+}
+
+// interceptionString returns a summary of the traffic redirected to the
+// sidecar proxy i.e. inbound=*->15006 outbound=15001.
+func interceptionString(ic *explorers.MeshInterception) string {
+	if ic == nil {
+		return ""
+	}
+
+	inbound := ic.InboundPorts
+	if inbound == "" {
+		inbound = "*"
+	}
+	s := fmt.Sprintf("inbound=%s->%s outbound=%s", inbound, ic.InboundPort, ic.OutboundPort)
+	if ic.ExcludeInboundPorts != "" {
+		s += " exclude-inbound=" + ic.ExcludeInboundPorts
+	}
+	if ic.ExcludeOutboundPorts != "" {
+		s += " exclude-outbound=" + ic.ExcludeOutboundPorts
+	}
+	return s
+}
+
+// specArgs returns the process arguments of the container spec or the
+// docker container configuration i.e. process.args or Path and Args.
+func specArgs(spec []byte) []string {
+	var v struct {
+		Process struct {
+			Args []string `json:"args"`
+		} `json:"process"`
+		Path string   `json:"Path"`
+		Args []string `json:"Args"`
+	}
+	if err := json.Unmarshal(spec, &v); err != nil {
+		return nil
+	}
+	if len(v.Process.Args) > 0 {
+		return v.Process.Args
+	}
+	if v.Path != "" {
+		return append([]string{v.Path}, v.Args...)
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/docker"
+	"github.com/google/container-explorer/explorers/knowledge"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Default security profiles shipped by the runtimes.
+const (
+	// builtinProfile is the reference of the seccomp profile compiled into
+	// the runtime binaries.
+	builtinProfile = "built-in"
+
+	criContainerdAppArmorProfile = "cri-containerd.apparmor.d"
+	crioAppArmorProfile          = "crio-default"
+	podmanAppArmorProfile        = "containers-default"
+
+	// containersSeccompProfile is the default seccomp profile shipped by
+	// containers-common and used by CRI-O and podman.
+	containersSeccompProfile = "/usr/share/containers/seccomp.json"
+)
+
+// profileDriftFinding is a security profile setting of a container or of
+// the runtime configuration loosened from the runtime default.
+type profileDriftFinding struct {
+	Namespace   string `json:"namespace,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	Image       string `json:"image,omitempty"`
+	explorers.ProfileDrift
+	Source    string `json:"source"`    // file or record holding the value
+	Reference string `json:"reference"` // source of the default
+}
+
+// runtimeProfiles holds the default security profiles of the runtime and
+// the runtime configuration overriding the defaults.
+type runtimeProfiles struct {
+	Seccomp       string // shipped seccomp profile file on the evidence or built-in
+	SeccompConfig string // configured default seccomp profile of docker
+	AppArmor      string // default AppArmor profile or empty if AppArmor is not used
+
+	// Overrides are the runtime configuration settings changing the
+	// defaults.
+	Overrides []profileDriftFinding
+}
+
+var analyzeProfileDrift = cli.Command{
+	Name:  "profile-drift",
+	Usage: "compare the seccomp and AppArmor profiles of containers with the runtime defaults",
+	Description: `compare the effective seccomp and AppArmor profile of each container with
+   the default profiles shipped by the container runtime, and report the
+   containers whose profiles were loosened i.e. seccomp unconfined, a
+   permissive default action, syscalls allowed that the default profile
+   denies, argument filters removed, or AppArmor unconfined.
+
+   The default seccomp profile of docker and containerd is compiled into the
+   runtime binaries; the profile of containerd 1.5 is used. CRI-O and podman
+   use the profile shipped in /usr/share/containers/seccomp.json when it is
+   on the evidence. Use --seccomp-profile to compare with another profile
+   i.e. the default profile of the exact runtime version. The syscalls
+   conditioned on capabilities are resolved using the capabilities of each
+   container.
+
+   The runtime configuration changing the default profiles is also reported
+   i.e. seccomp-profile in /etc/docker/daemon.json or disable_apparmor in
+   /etc/containerd/config.toml.
+
+   The AppArmor profiles are compared when /etc/apparmor.d is on the
+   evidence. Kubernetes runs containers unconfined by seccomp unless the
+   pod requests RuntimeDefault or the kubelet enables SeccompDefault.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "analyze only the specified container ID",
+		},
+		cli.StringFlag{
+			Name:  "seccomp-profile",
+			Usage: "reference seccomp profile file on the analysis host",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		explainFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerProfileDrift); err != nil {
+			return err
+		}
+
+		runtime := selectedRuntime(clictx)
+		imageroot := clictx.GlobalString("image-root")
+		defaults := readRuntimeProfiles(imageroot, runtime)
+
+		var reference []byte
+		if path := clictx.String("seccomp-profile"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			reference = data
+			defaults.Seccomp = path
+		} else if defaults.Seccomp != builtinProfile {
+			data, err := os.ReadFile(filepath.Join(imageroot, defaults.Seccomp))
+			if err != nil {
+				return err
+			}
+			reference = data
+		}
+
+		// referenceProfile returns the reference seccomp profile for the
+		// capabilities of a container.
+		referenceProfile := func(caps []string) (*specs.LinuxSeccomp, error) {
+			if reference == nil {
+				return explorers.DefaultSeccompProfile(caps), nil
+			}
+			return explorers.ParseSeccompProfile(reference, caps)
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		findings := defaults.Overrides
+		for _, ctr := range ctrs {
+			if id := clictx.String("id"); id != "" && ctr.ID != id {
+				continue
+			}
+			if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+
+			nsctx := namespaces.WithNamespace(ctx, ctr.Namespace)
+			profile, err := containerSecurityProfile(nsctx, exp, ctr)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("reading container security profile: ", err)
+				continue
+			}
+
+			refprofile, err := referenceProfile(profile.Capabilities)
+			if err != nil {
+				return fmt.Errorf("reading reference seccomp profile %s: %w", defaults.Seccomp, err)
+			}
+
+			effective := profile.Seccomp
+			if profile.SeccompRuntimeDefault {
+				if effective, err = configuredSeccompProfile(imageroot, defaults.SeccompConfig, profile.Capabilities, refprofile); err != nil {
+					log.WithField("containerid", ctr.ID).Warn("reading configured seccomp profile: ", err)
+					continue
+				}
+			}
+
+			drifts := explorers.CompareSeccomp(effective, refprofile)
+			apparmor := defaults.AppArmor
+			if isContainerdRuntime(runtime) && ctr.Namespace != "k8s.io" {
+				// containerd applies an AppArmor profile to the CRI
+				// containers only.
+				apparmor = ""
+			}
+			drifts = append(drifts, explorers.CompareAppArmor(profile.AppArmor, apparmor)...)
+
+			for _, d := range drifts {
+				f := profileDriftFinding{
+					Namespace:    ctr.Namespace,
+					ContainerID:  ctr.ID,
+					Image:        ctr.Image,
+					ProfileDrift: d,
+					Source:       profile.Source,
+					Reference:    defaults.Seccomp,
+				}
+				if d.Profile == explorers.ProfileAppArmor {
+					f.Reference = "runtime default"
+				}
+				findings = append(findings, f)
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, f := range findings {
+				explanations = append(explanations, explainProfileDrift(f))
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, f := range findings {
+				printObject(output, f)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("NAMESPACE", "CONTAINER ID", "IMAGE", "PROFILE", "SETTING", "VALUE", "DEFAULT", "REFERENCE")
+		for _, f := range findings {
+			rw.Write(f.Namespace, f.ContainerID, f.Image, f.Profile, f.Setting, f.Value, f.Default, f.Reference)
+		}
+		return nil
+	},
+}
+
+// containerSecurityProfile returns the security profile of a container from
+// the explorer or from the OCI runtime spec.
+func containerSecurityProfile(ctx context.Context, exp explorers.ContainerExplorer, ctr explorers.Container) (explorers.SecurityProfile, error) {
+	if se, ok := exp.(explorers.SecurityProfileExplorer); ok {
+		return se.ContainerSecurityProfile(ctx, ctr.ID)
+	}
+
+	v, err := exp.InfoContainer(ctx, ctr.ID, true)
+	if err != nil {
+		return explorers.SecurityProfile{}, err
+	}
+	if v == nil {
+		return explorers.SecurityProfile{}, fmt.Errorf("container %s has no spec", ctr.ID)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return explorers.SecurityProfile{}, err
+	}
+	var spec specs.Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return explorers.SecurityProfile{}, fmt.Errorf("unmarshalling container spec: %w", err)
+	}
+
+	profile := explorers.SpecSecurityProfile(&spec)
+	profile.Source = "container spec"
+	return profile, nil
+}
+
+// configuredSeccompProfile returns the seccomp profile configured as the
+// docker daemon default i.e. unconfined or a profile file on the evidence.
+func configuredSeccompProfile(imageroot string, config string, caps []string, reference *specs.LinuxSeccomp) (*specs.LinuxSeccomp, error) {
+	switch config {
+	case "", "builtin":
+		return reference, nil
+	case explorers.ProfileUnconfined:
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(imageroot, config))
+	if err != nil {
+		return nil, err
+	}
+	return explorers.ParseSeccompProfile(data, caps)
+}
+
+// readRuntimeProfiles returns the default security profiles of a runtime
+// and the runtime configuration overriding them.
+func readRuntimeProfiles(imageroot string, runtime string) runtimeProfiles {
+	profiles := runtimeProfiles{Seccomp: builtinProfile}
+	apparmor := imageroot != "" && explorers.PathExists(filepath.Join(imageroot, "etc", "apparmor.d"), false)
+
+	override := func(profile string, setting string, value string, def string, source string) {
+		profiles.Overrides = append(profiles.Overrides, profileDriftFinding{
+			ProfileDrift: explorers.ProfileDrift{
+				Profile: profile,
+				Setting: setting,
+				Value:   value,
+				Default: def,
+			},
+			Source:    source,
+			Reference: "runtime default",
+		})
+	}
+
+	switch {
+	case isDockerRuntime(runtime):
+		if apparmor {
+			profiles.AppArmor = docker.DefaultAppArmorProfile
+		}
+
+		path := explorers.DockerConfigPath(imageroot)
+		var daemon struct {
+			SeccompProfile string `json:"seccomp-profile"`
+		}
+		if data, err := os.ReadFile(path); err == nil {
+			if err := json.Unmarshal(data, &daemon); err != nil {
+				log.WithField("path", path).Warn("unmarshalling docker daemon configuration: ", err)
+			}
+		}
+		if daemon.SeccompProfile != "" && daemon.SeccompProfile != "builtin" {
+			profiles.SeccompConfig = daemon.SeccompProfile
+			override(explorers.ProfileSeccomp, "seccomp-profile", daemon.SeccompProfile, builtinProfile, path)
+		}
+
+	case runtime == runtimeCrio || runtime == runtimePodman:
+		if explorers.PathExists(filepath.Join(imageroot, containersSeccompProfile), true) {
+			profiles.Seccomp = containersSeccompProfile
+		}
+
+		def := crioAppArmorProfile
+		configs := []string{"/etc/crio/crio.conf"}
+		dropins, _ := filepath.Glob(filepath.Join(imageroot, "etc", "crio", "crio.conf.d", "*.conf"))
+		if runtime == runtimePodman {
+			def = podmanAppArmorProfile
+			configs = []string{"/etc/containers/containers.conf"}
+			dropins, _ = filepath.Glob(filepath.Join(imageroot, "etc", "containers", "containers.conf.d", "*.conf"))
+		}
+		if apparmor {
+			profiles.AppArmor = def
+		}
+
+		paths := []string{filepath.Join(imageroot, configs[0])}
+		paths = append(paths, dropins...)
+		for _, path := range paths {
+			if value, found := readTOMLString(path, "seccomp_profile"); found && value != "" && value != profiles.Seccomp {
+				override(explorers.ProfileSeccomp, "seccomp_profile", value, profiles.Seccomp, path)
+			}
+			if value, found := readTOMLString(path, "apparmor_profile"); found && value != "" && !strings.HasPrefix(value, def) {
+				override(explorers.ProfileAppArmor, "apparmor_profile", value, def, path)
+			}
+		}
+
+	case isContainerdRuntime(runtime):
+		if apparmor {
+			profiles.AppArmor = criContainerdAppArmorProfile
+		}
+
+		// The containerd of a Kubernetes distribution is configured in
+		// the config.toml of the knowledge pack.
+		paths := []string{explorers.ContainerdConfigPath(imageroot)}
+		if p, found := knowledge.Get(runtime); found {
+			for _, path := range p.ConfigPaths {
+				if filepath.Base(path) == "config.toml" {
+					paths = append(paths, filepath.Join(imageroot, path))
+				}
+			}
+		}
+		for _, path := range paths {
+			if value, found := readTOMLString(path, "disable_apparmor"); found && value == "true" {
+				override(explorers.ProfileAppArmor, "disable_apparmor", value, "false", path)
+			}
+			if value, found := readTOMLString(path, "unset_seccomp_profile"); found && value != "" {
+				override(explorers.ProfileSeccomp, "unset_seccomp_profile", value, "", path)
+			}
+		}
+	}
+	return profiles
+}
+
+// readTOMLString returns the value of the first key found in a TOML file.
+// The sections are ignored.
+func readTOMLString(path string, key string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	re := regexp.MustCompile(`(?m)^\s*` + regexp.QuoteMeta(key) + `\s*=\s*(.*?)\s*$`)
+	m := re.FindSubmatch(data)
+	if m == nil {
+		return "", false
+	}
+	value, err := strconv.Unquote(string(m[1]))
+	if err != nil {
+		value = strings.Trim(string(m[1]), `'`)
+	}
+	return value, true
+}
+
+// explainProfileDrift returns the explanation of a security profile drift.
+func explainProfileDrift(f profileDriftFinding) explanation {
+	rule := "the setting differs from the default security profile of the runtime"
+	switch f.Setting {
+	case "profile":
+		rule = "the container is not confined by a profile although the runtime applies a default profile"
+	case "default action":
+		rule = "the default action of the profile allows the syscalls that no rule matches"
+	case "allowed syscalls":
+		rule = "the profile allows syscalls that the default profile denies for the capabilities of the container"
+	case "argument filters removed":
+		rule = "the profile allows syscalls without the argument filters of the default profile"
+	case "custom profile":
+		rule = "the container uses a custom AppArmor profile that may be looser than the default profile"
+	}
+	if f.ContainerID == "" {
+		rule = "the runtime configuration changes the default profile applied to the containers"
+	}
+
+	return explanation{
+		Namespace:   f.Namespace,
+		ContainerID: f.ContainerID,
+		Finding:     fmt.Sprintf("%s %s is %s", f.Profile, f.Setting, f.Value),
+		Rule:        rule,
+		Evidence: []evidence{
+			{Source: f.Source, Field: f.Profile, Value: f.Value},
+			{Source: f.Reference, Field: f.Setting, Value: f.Default},
+		},
+	}
+}
@@ -0,0 +1,435 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
+)
+
+// Analyzer names used in the analyzer configuration and --analyzers.
+const (
+	analyzerDrift         = "drift"
+	analyzerIntegrity     = "integrity"
+	analyzerEgress        = "egress"
+	analyzerProfileDrift  = "profile-drift"
+	analyzerEncoded       = "encoded"
+	analyzerHashes        = "hashes"
+	analyzerStaleMetadata = "stale-metadata"
+	analyzerVolatile      = "volatile"
+	analyzerPinning       = "pinning"
+	analyzerSBOM          = "sbom"
+	analyzerLicenses      = "licenses"
+	analyzerTagHistory    = "tag-history"
+	analyzerHooks         = "hooks"
+	analyzerYara          = "yara"
+)
+
+// analyzer is a command reporting findings that can be selected using
+// analyze run --analyzers and tuned in the analyzer configuration.
+type analyzer struct {
+	name         string
+	path         string // command line of the analyzer command
+	command      *cli.Command
+	enabled      bool // run by analyze run unless disabled in the configuration
+	perContainer bool // the command requires --id
+}
+
+// analyzers are the analyzers in the order run by analyze run.
+//
+// The analyzers requiring an external input or reporting inventory rather
+// than suspicious findings are disabled by default. The analyzers are
+// registered in init as the commands read the analyzer configuration.
+var analyzers []analyzer
+
+func init() {
+	analyzers = []analyzer{
+		{analyzerDrift, "drift", &DriftCommand, true, false},
+		{analyzerIntegrity, "analyze integrity", &analyzeIntegrity, true, true},
+		{analyzerProfileDrift, "analyze profile-drift", &analyzeProfileDrift, true, false},
+		{analyzerEncoded, "scan encoded", &scanEncoded, true, false},
+		{analyzerStaleMetadata, "stale-metadata", &StaleMetadataCommand, true, false},
+		{analyzerVolatile, "report volatile", &reportVolatile, true, false},
+		{analyzerPinning, "report pinning", &reportPinning, true, false},
+		{analyzerHooks, "analyze hooks", &analyzeHooks, true, false},
+		{analyzerEgress, "analyze egress", &analyzeEgress, false, false},
+		{analyzerHashes, "scan hashes", &scanHashes, false, false},
+		{analyzerYara, "scan yara", &scanYara, false, false},
+		{analyzerSBOM, "report sbom", &reportSBOM, false, false},
+		{analyzerLicenses, "report licenses", &reportLicenses, false, false},
+		{analyzerTagHistory, "report tag-history", &reportTagHistory, false, false},
+	}
+}
+
+// analyzerSettings holds the configuration of an analyzer. The settings
+// other than enabled are the values of the command flags.
+type analyzerSettings map[string]interface{}
+
+// analyzerConfig is the analyzer configuration file i.e.
+//
+//	analyzers:
+//	  encoded:
+//	    enabled: true
+//	    min-length: 60
+//	  hashes:
+//	    enabled: true
+//	    known-bad: [/cases/hashsets/malware.csv]
+type analyzerConfig struct {
+	Analyzers map[string]analyzerSettings `yaml:"analyzers"`
+}
+
+var (
+	// loadedAnalyzerConfig is the analyzer configuration read from the
+	// file specified using --analyzer-config.
+	loadedAnalyzerConfig *analyzerConfig
+
+	// runningAnalyzer is the name of the analyzer run by analyze run. It is
+	// added to the printed explanations.
+	runningAnalyzer string
+
+	// explanationsPrinted is true if the explanations of an analyzer run
+	// by analyze run were printed as text.
+	explanationsPrinted bool
+)
+
+var analyzeRun = cli.Command{
+	Name:  "run",
+	Usage: "run the enabled analyzers and print the findings with their evidence",
+	Description: `run the analyzers enabled in the analyzer configuration, or the analyzers
+   selected using --analyzers, and print the findings of each analyzer with
+   the evidence and the rule as --explain does.
+
+   The analyzers are configured in the YAML file specified using the global
+   flag --analyzer-config. An analyzer is enabled or disabled using enabled,
+   and the other settings are the flags of the analyzer command i.e.
+
+     analyzers:
+       encoded:
+         min-length: 60
+         min-printable: 0.95
+       hashes:
+         enabled: true
+         known-bad: [/cases/hashsets/malware.csv]
+       pinning:
+         enabled: false
+
+   The settings also apply when an analyzer command is run on its own. A
+   flag specified on the command line takes precedence over the setting.
+   Use --list to print the analyzers and their settings.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "analyzers",
+			Usage: "comma separated analyzers to run regardless of the configuration i.e. drift,integrity",
+		},
+		cli.BoolFlag{
+			Name:  "list",
+			Usage: "list the analyzers, their commands, and their settings",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		config, err := readAnalyzerConfig(clictx)
+		if err != nil {
+			return err
+		}
+
+		var selected []analyzer
+		if names := clictx.String("analyzers"); names != "" {
+			if selected, err = selectAnalyzers(names); err != nil {
+				return err
+			}
+		} else {
+			for _, a := range analyzers {
+				if config.enabled(a) {
+					selected = append(selected, a)
+				}
+			}
+		}
+
+		if clictx.Bool("list") {
+			chosen := make(map[string]bool)
+			for _, a := range selected {
+				chosen[a.name] = true
+			}
+
+			rw := newRowWriter(clictx.GlobalString("output"))
+			defer rw.Flush()
+			rw.Write("ANALYZER", "ENABLED", "COMMAND", "SETTINGS")
+			for _, a := range analyzers {
+				rw.Write(a.name, fmt.Sprint(chosen[a.name]), a.path, config.Analyzers[a.name].String())
+			}
+			return nil
+		}
+
+		if len(selected) == 0 {
+			return fmt.Errorf("no analyzer enabled")
+		}
+
+		defer func() {
+			runningAnalyzer = ""
+			explanationsPrinted = false
+		}()
+
+		failed := 0
+		for _, a := range selected {
+			runningAnalyzer = a.name
+			log.WithField("analyzer", a.name).Info("running analyzer")
+
+			values := map[string]string{"explain": "true"}
+			if clictx.Bool("show-support-containers") {
+				values["show-support-containers"] = "true"
+			}
+
+			if !a.perContainer {
+				if err := runAnalyzer(clictx, a, values); err != nil {
+					log.WithField("analyzer", a.name).Warn("running analyzer: ", err)
+					failed++
+				}
+				continue
+			}
+
+			if err := runContainerAnalyzer(clictx, a, values); err != nil {
+				log.WithField("analyzer", a.name).Warn("running analyzer: ", err)
+				failed++
+			}
+		}
+
+		if failed == len(selected) {
+			return fmt.Errorf("no analyzer completed")
+		}
+		return nil
+	},
+}
+
+// runContainerAnalyzer runs an analyzer requiring --id for each container.
+func runContainerAnalyzer(clictx *cli.Context, a analyzer, values map[string]string) error {
+	ctx, exp, cancel, err := explorerEnvironment(clictx)
+	if err != nil {
+		return err
+	}
+	ctrs, err := exp.ListContainers(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	// The container namespace is read from the global flag.
+	namespace := clictx.GlobalString("namespace")
+	defer clictx.GlobalSet("namespace", namespace)
+
+	for _, ctr := range ctrs {
+		if ctr.SupportContainer && values["show-support-containers"] == "" {
+			continue
+		}
+		if err := clictx.GlobalSet("namespace", ctr.Namespace); err != nil {
+			return err
+		}
+
+		values["id"] = ctr.ID
+		if err := runAnalyzer(clictx, a, values); err != nil {
+			log.WithFields(log.Fields{
+				"analyzer":    a.name,
+				"containerid": ctr.ID,
+			}).Warn("running analyzer: ", err)
+		}
+	}
+	return nil
+}
+
+// runAnalyzer runs the action of an analyzer command with the flag values.
+// The values of the flags not defined by the command are ignored.
+func runAnalyzer(clictx *cli.Context, a analyzer, values map[string]string) error {
+	action, ok := a.command.Action.(func(*cli.Context) error)
+	if !ok {
+		return fmt.Errorf("analyzer %s has no action", a.name)
+	}
+
+	set := flag.NewFlagSet(a.command.Name, flag.ContinueOnError)
+	for _, f := range a.command.Flags {
+		f.Apply(set)
+	}
+	for name, value := range values {
+		if set.Lookup(name) == nil {
+			continue
+		}
+		if err := set.Set(name, value); err != nil {
+			return fmt.Errorf("setting --%s: %w", name, err)
+		}
+	}
+
+	ctx := cli.NewContext(clictx.App, set, clictx)
+	ctx.Command = *a.command
+	return action(ctx)
+}
+
+// selectAnalyzers returns the analyzers of a comma separated list.
+func selectAnalyzers(names string) ([]analyzer, error) {
+	byname := make(map[string]analyzer)
+	var known []string
+	for _, a := range analyzers {
+		byname[a.name] = a
+		known = append(known, a.name)
+	}
+
+	var selected []analyzer
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		a, found := byname[name]
+		if !found {
+			return nil, fmt.Errorf("unknown analyzer %s. Use one of %s", name, strings.Join(known, ", "))
+		}
+		selected = append(selected, a)
+	}
+	return selected, nil
+}
+
+// readAnalyzerConfig returns the analyzer configuration of the file specified
+// using --analyzer-config. The configuration is empty if the flag is not
+// specified.
+func readAnalyzerConfig(clictx *cli.Context) (*analyzerConfig, error) {
+	if loadedAnalyzerConfig != nil {
+		return loadedAnalyzerConfig, nil
+	}
+
+	config := &analyzerConfig{}
+	path := clictx.GlobalString("analyzer-config")
+	if path == "" {
+		loadedAnalyzerConfig = config
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading analyzer configuration: %w", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("parsing analyzer configuration %s: %w", path, err)
+	}
+
+	for name, settings := range config.Analyzers {
+		a, found := findAnalyzer(name)
+		if !found {
+			return nil, fmt.Errorf("unknown analyzer %s in analyzer configuration %s", name, path)
+		}
+		for setting := range settings {
+			if setting != "enabled" && !commandHasFlag(a.command, setting) {
+				return nil, fmt.Errorf("unknown setting %s of analyzer %s in analyzer configuration %s", setting, name, path)
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"path":      path,
+		"analyzers": len(config.Analyzers),
+	}).Debug("read analyzer configuration")
+
+	loadedAnalyzerConfig = config
+	return config, nil
+}
+
+// applyAnalyzerConfig sets the flags of an analyzer command to the values
+// of the analyzer configuration. The flags specified on the command line
+// are not changed.
+func applyAnalyzerConfig(clictx *cli.Context, name string) error {
+	config, err := readAnalyzerConfig(clictx)
+	if err != nil {
+		return err
+	}
+
+	settings := config.Analyzers[name]
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "enabled" || clictx.IsSet(key) {
+			continue
+		}
+
+		values := []interface{}{settings[key]}
+		if list, ok := settings[key].([]interface{}); ok {
+			values = list
+		}
+		for _, v := range values {
+			if err := clictx.Set(key, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("setting %s of analyzer %s: %w", key, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// enabled returns true if the analyzer is enabled in the configuration or
+// enabled by default.
+func (c *analyzerConfig) enabled(a analyzer) bool {
+	if v, found := c.Analyzers[a.name]["enabled"]; found {
+		enabled, ok := v.(bool)
+		return ok && enabled
+	}
+	return a.enabled
+}
+
+// String returns the settings as comma separated key=value pairs sorted by
+// key.
+func (s analyzerSettings) String() string {
+	var pairs []string
+	for key, value := range s {
+		if key != "enabled" {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", key, value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// findAnalyzer returns the analyzer with the name.
+func findAnalyzer(name string) (analyzer, bool) {
+	for _, a := range analyzers {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return analyzer{}, false
+}
+
+// commandHasFlag returns true if the command defines the flag name or
+// alias.
+func commandHasFlag(cmd *cli.Command, name string) bool {
+	for _, f := range cmd.Flags {
+		for _, n := range strings.Split(f.GetName(), ",") {
+			if strings.TrimSpace(n) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
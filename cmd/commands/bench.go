@@ -0,0 +1,303 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/selftest"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// benchPipelines are the pipelines measured by bench in order.
+var benchPipelines = []string{"detect", "list", "layers", "walk", "hash", "drift"}
+
+// benchResult is the measurement of a pipeline over the synthetic evidence.
+type benchResult struct {
+	Pipeline string            `json:"pipeline"`
+	Workload selftest.Workload `json:"workload"`
+	Items    int               `json:"items"` // containers or files processed
+	Duration time.Duration     `json:"duration_ns"`
+	PerItem  time.Duration     `json:"per_item_ns"`
+	Alloc    uint64            `json:"alloc_bytes"` // bytes allocated on the heap
+	Error    string            `json:"error,omitempty"`
+}
+
+var BenchCommand = cli.Command{
+	Name:  "bench",
+	Usage: "measure the enumeration and scan pipelines on synthetic evidence",
+	Description: `build synthetic containerd evidence with N containers of an image of
+   M layers in a temporary directory and measure the duration and the heap
+   allocation of the pipelines:
+
+     detect   detect the container runtime
+     list     list the containers
+     layers   resolve the layers of each container
+     walk     walk the merged filesystem of each container
+     hash     hash the files of each container
+     drift    compute the drift of each container
+
+   Each layer and each container upper layer holds --files files. Use
+   --cpu-profile and --mem-profile to write the profiles of the pipelines
+   for go tool pprof, and --output json to record the results and track
+   regressions across builds.`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "containers",
+			Usage: "number of containers",
+			Value: 100,
+		},
+		cli.IntFlag{
+			Name:  "layers",
+			Usage: "number of image layers",
+			Value: 5,
+		},
+		cli.IntFlag{
+			Name:  "files",
+			Usage: "number of files in each layer",
+			Value: 100,
+		},
+		cli.IntFlag{
+			Name:  "file-size",
+			Usage: "file size in bytes",
+			Value: 4096,
+		},
+		cli.StringFlag{
+			Name:  "pipelines",
+			Usage: "comma separated pipelines to measure. Default is all",
+		},
+		cli.StringFlag{
+			Name:  "cpu-profile",
+			Usage: "write the CPU profile of the pipelines to the file",
+		},
+		cli.StringFlag{
+			Name:  "mem-profile",
+			Usage: "write the heap profile after the pipelines to the file",
+		},
+		cli.BoolFlag{
+			Name:  "keep",
+			Usage: "keep the synthetic evidence directory",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		for _, name := range []string{"image-root", "image-file", "containerd-root", "docker-root", "crio-root", "podman-root", "metadata-file", "snapshot-metadata-file"} {
+			if clictx.GlobalString(name) != "" {
+				return fmt.Errorf("--%s cannot be used with bench", name)
+			}
+		}
+
+		workload := selftest.Workload{
+			Containers: clictx.Int("containers"),
+			Layers:     clictx.Int("layers"),
+			Files:      clictx.Int("files"),
+			FileSize:   clictx.Int("file-size"),
+		}
+
+		pipelines := benchPipelines
+		if list := clictx.String("pipelines"); list != "" {
+			pipelines = nil
+			for _, p := range strings.Split(list, ",") {
+				p = strings.TrimSpace(p)
+				if indexOf(benchPipelines, p) < 0 {
+					return fmt.Errorf("unsupported pipeline %s. Use one of %s", p, strings.Join(benchPipelines, ", "))
+				}
+				pipelines = append(pipelines, p)
+			}
+		}
+
+		dir, err := os.MkdirTemp("", "container-explorer-bench-")
+		if err != nil {
+			return fmt.Errorf("creating synthetic evidence directory: %w", err)
+		}
+		if !clictx.Bool("keep") {
+			defer os.RemoveAll(dir)
+		}
+
+		start := time.Now()
+		if err := selftest.BuildWorkload(dir, workload); err != nil {
+			return fmt.Errorf("building synthetic evidence: %w", err)
+		}
+		log.WithFields(log.Fields{
+			"dir":      dir,
+			"duration": time.Since(start),
+		}).Info("built synthetic evidence")
+
+		if err := clictx.GlobalSet("image-root", dir); err != nil {
+			return err
+		}
+		defer func() {
+			clictx.GlobalSet("image-root", "")
+			detectedRuntime = ""
+		}()
+		detectedRuntime = ""
+
+		stop, err := startCPUProfile(clictx.String("cpu-profile"))
+		if err != nil {
+			return err
+		}
+		results, err := runBench(clictx, workload, pipelines)
+		stop()
+		if err != nil {
+			return err
+		}
+		if path := clictx.String("mem-profile"); path != "" {
+			if err := writeHeapProfile(path); err != nil {
+				return err
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, r := range results {
+				printObject(output, r)
+			}
+		} else {
+			rw := newRowWriter(output)
+			rw.Write("PIPELINE", "ITEMS", "DURATION", "PER ITEM", "ALLOCATED", "ERROR")
+			for _, r := range results {
+				rw.Write(r.Pipeline, fmt.Sprint(r.Items), r.Duration.String(), r.PerItem.String(), fmt.Sprintf("%d KiB", r.Alloc/1024), r.Error)
+			}
+			rw.Flush()
+			fmt.Printf("\nworkload: %d containers, %d layers, %d files per layer of %d bytes\n", workload.Containers, workload.Layers, workload.Files, workload.FileSize)
+			if clictx.Bool("keep") {
+				fmt.Printf("synthetic evidence kept in %s\n", dir)
+			}
+		}
+
+		for _, r := range results {
+			if r.Error != "" {
+				return fmt.Errorf("pipeline %s failed: %s", r.Pipeline, r.Error)
+			}
+		}
+		return nil
+	},
+}
+
+// runBench measures the pipelines over the image root of the global flags.
+func runBench(clictx *cli.Context, workload selftest.Workload, pipelines []string) ([]benchResult, error) {
+	ctx, exp, cancel, err := explorerEnvironment(clictx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	defer exp.Close()
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []benchResult
+	for _, pipeline := range pipelines {
+		r := benchResult{Pipeline: pipeline, Workload: workload}
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+
+		r.Items, err = runBenchPipeline(ctx, clictx, exp, ctrs, pipeline)
+
+		r.Duration = time.Since(start)
+		runtime.ReadMemStats(&after)
+		r.Alloc = after.TotalAlloc - before.TotalAlloc
+		if r.Items > 0 {
+			r.PerItem = r.Duration / time.Duration(r.Items)
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+
+		log.WithFields(log.Fields{
+			"pipeline": pipeline,
+			"items":    r.Items,
+			"duration": r.Duration,
+		}).Debug("measured pipeline")
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// runBenchPipeline runs a pipeline and returns the number of processed
+// containers or files.
+func runBenchPipeline(ctx context.Context, clictx *cli.Context, exp explorers.ContainerExplorer, ctrs []explorers.Container, pipeline string) (int, error) {
+	switch pipeline {
+	case "detect":
+		detectedRuntime = ""
+		if r := selectedRuntime(clictx); r != runtimeContainerd {
+			return 1, fmt.Errorf("detected %s, expected %s", r, runtimeContainerd)
+		}
+		return 1, nil
+
+	case "list":
+		listed, err := exp.ListContainers(ctx)
+		return len(listed), err
+
+	case "layers":
+		for _, ctr := range ctrs {
+			if _, err := containerLayers(ctx, exp, ctr); err != nil {
+				return 0, err
+			}
+		}
+		return len(ctrs), nil
+
+	case "walk", "hash":
+		files := 0
+		for _, ctr := range ctrs {
+			layers, err := containerLayers(ctx, exp, ctr)
+			if err != nil {
+				return files, err
+			}
+			err = explorers.WalkLayers(layers, func(f explorers.LayerFile) error {
+				if !f.Info.Mode().IsRegular() {
+					return nil
+				}
+				files++
+				if pipeline == "hash" {
+					_, err := hashManifestFile(f, false, false)
+					return err
+				}
+				return nil
+			})
+			if err != nil {
+				return files, err
+			}
+		}
+		return files, nil
+
+	case "drift":
+		for _, ctr := range ctrs {
+			upperdir, lowerdirs, err := exp.ContainerLayers(namespaces.WithNamespace(ctx, ctr.Namespace), ctr.ID)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := explorers.ContainerDrift(upperdir, lowerdirs); err != nil {
+				return 0, err
+			}
+		}
+		return len(ctrs), nil
+	}
+	return 0, fmt.Errorf("unsupported pipeline %s", pipeline)
+}
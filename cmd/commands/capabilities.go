@@ -0,0 +1,440 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/containerd"
+	"github.com/google/container-explorer/explorers/knowledge"
+	"github.com/google/container-explorer/explorers/storage"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Capability status values.
+const (
+	capabilityAvailable   = "available"
+	capabilityPartial     = "partial"
+	capabilityUnavailable = "unavailable"
+)
+
+// capability describes whether a feature is available for the evidence.
+type capability struct {
+	Feature string `json:"feature"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+}
+
+// capabilityReport holds the detected evidence properties and the
+// capabilities.
+type capabilityReport struct {
+	Runtime        string       `json:"runtime"`
+	Runtimes       []string     `json:"detected_runtimes"`
+	StorageDrivers []string     `json:"storage_drivers"`
+	Capabilities   []capability `json:"capabilities"`
+}
+
+// hostFeatures holds the analysis host features used to mount containers.
+type hostFeatures struct {
+	Linux         bool
+	Root          bool
+	Overlay       bool // kernel overlay filesystem
+	FuseOverlayfs bool // fuse-overlayfs command
+	Fuse          bool // FUSE mount selected using --fuse
+}
+
+// evidenceContainers holds the containers of the evidence and whether their
+// layers are on disk.
+type evidenceContainers struct {
+	Total       int
+	LayersFound int
+	Logs        int
+	Drivers     map[string]int
+	Err         error
+}
+
+var CapabilitiesCommand = cli.Command{
+	Name:  "capabilities",
+	Usage: "show the features available for the evidence",
+	Description: `detect the container runtimes, storage drivers, and analysis host features
+   and show which features i.e. mount, export, logs, recovery, and live
+   collection are available for the evidence and why other features are
+   unavailable.
+
+   The capabilities are checked before running the commands to avoid
+   failures in the middle of an operation.`,
+	Action: func(clictx *cli.Context) error {
+		imageroot := clictx.GlobalString("image-root")
+
+		report := capabilityReport{
+			Runtime:  selectedRuntime(clictx),
+			Runtimes: detectRuntimes(imageroot),
+		}
+
+		ctrs := readEvidenceContainers(clictx)
+		for driver := range ctrs.Drivers {
+			report.StorageDrivers = append(report.StorageDrivers, driver)
+		}
+		sort.Strings(report.StorageDrivers)
+
+		host := readHostFeatures()
+		report.Capabilities = []capability{
+			listCapability(report.Runtime, ctrs),
+			mountCapability(host, ctrs),
+			exportCapability(ctrs),
+			deletedFilesCapability(ctrs),
+			logsCapability(imageroot, selectedPack(clictx), ctrs),
+			metadataRecoveryCapability(clictx, report.Runtime),
+			clusterRecoveryCapability(imageroot),
+			liveCollectionCapability(imageroot),
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			printObject(output, report)
+			return nil
+		}
+
+		if strings.ToLower(output) != outputCSV {
+			fmt.Printf("RUNTIME: %s (detected: %s)\n", report.Runtime, strings.Join(report.Runtimes, ","))
+			fmt.Printf("STORAGE DRIVERS: %s\n\n", strings.Join(report.StorageDrivers, ","))
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("FEATURE", "STATUS", "REASON")
+		for _, c := range report.Capabilities {
+			rw.Write(c.Feature, c.Status, c.Reason)
+		}
+		return nil
+	},
+}
+
+// readEvidenceContainers returns the containers of the evidence and checks
+// whether their layers and logs are on disk.
+func readEvidenceContainers(clictx *cli.Context) evidenceContainers {
+	result := evidenceContainers{
+		Drivers: make(map[string]int),
+	}
+
+	ctx, exp, cancel, err := explorerEnvironment(clictx)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer cancel()
+	defer exp.Close()
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	for _, ctr := range ctrs {
+		result.Total++
+		if ctr.Snapshotter != "" {
+			result.Drivers[ctr.Snapshotter]++
+		}
+		if ctr.LogPath != "" && explorers.PathExists(ctr.LogPath, true) {
+			result.Logs++
+		}
+
+		layers, err := containerLayers(ctx, exp, ctr)
+		if err != nil {
+			log.WithField("containerid", ctr.ID).Debug("resolving container layers: ", err)
+			continue
+		}
+		found := true
+		for _, layer := range layers {
+			if layer != "" && !explorers.PathExists(layer, false) {
+				found = false
+			}
+		}
+		if found {
+			result.LayersFound++
+		}
+	}
+	return result
+}
+
+// readHostFeatures returns the analysis host features used to mount
+// containers.
+func readHostFeatures() hostFeatures {
+	host := hostFeatures{
+		Linux: runtime.GOOS == "linux",
+		Root:  os.Geteuid() == 0,
+		Fuse:  explorers.FuseMountEnabled(),
+	}
+	if data, err := os.ReadFile("/proc/filesystems"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) > 0 && fields[len(fields)-1] == "overlay" {
+				host.Overlay = true
+			}
+		}
+	}
+	if _, err := exec.LookPath("fuse-overlayfs"); err == nil {
+		host.FuseOverlayfs = true
+	}
+	return host
+}
+
+// listCapability returns whether the containers, images, and snapshots can
+// be listed and inspected.
+func listCapability(rt string, ctrs evidenceContainers) capability {
+	c := capability{Feature: "list and inspect"}
+	switch {
+	case ctrs.Err != nil:
+		c.Status = capabilityUnavailable
+		c.Reason = fmt.Sprintf("reading %s metadata: %v", rt, ctrs.Err)
+	case ctrs.Total == 0:
+		c.Status = capabilityPartial
+		c.Reason = fmt.Sprintf("no %s containers found in the namespace. Use --runtime or --namespace", rt)
+	default:
+		c.Status = capabilityAvailable
+		c.Reason = fmt.Sprintf("%d %s containers found", ctrs.Total, rt)
+	}
+	return c
+}
+
+// mountCapability returns whether the containers can be mounted on the
+// analysis host.
+func mountCapability(host hostFeatures, ctrs evidenceContainers) capability {
+	c := capability{Feature: "mount"}
+
+	layered := false
+	for driver := range ctrs.Drivers {
+		if !storage.IsFullCopy(driver) {
+			layered = true
+		}
+	}
+
+	var reasons []string
+	switch {
+	case !host.Linux:
+		c.Status = capabilityUnavailable
+		c.Reason = fmt.Sprintf("mounting containers is not supported on %s", runtime.GOOS)
+		return c
+	case ctrs.Total == 0:
+		c.Status = capabilityUnavailable
+		c.Reason = "no containers found"
+		return c
+	case host.Fuse && !host.FuseOverlayfs:
+		c.Status = capabilityUnavailable
+		c.Reason = "--fuse is specified and fuse-overlayfs is not installed"
+		return c
+	case host.Fuse:
+		reasons = append(reasons, "using fuse-overlayfs (--fuse)")
+	case !host.Root && !host.FuseOverlayfs:
+		c.Status = capabilityUnavailable
+		c.Reason = "not running as root and fuse-overlayfs is not installed"
+		return c
+	case layered && !host.Overlay && !host.FuseOverlayfs:
+		c.Status = capabilityUnavailable
+		c.Reason = "the kernel overlay filesystem is not registered and fuse-overlayfs is not installed"
+		return c
+	case !host.Root || (layered && !host.Overlay):
+		reasons = append(reasons, "using fuse-overlayfs")
+	}
+
+	for driver := range ctrs.Drivers {
+		switch driver {
+		case "devmapper", "devicemapper":
+			reasons = append(reasons, fmt.Sprintf("%s requires the thin pool activated on the analysis host", driver))
+		case "zfs":
+			reasons = append(reasons, "zfs requires the pool imported on the analysis host")
+		}
+	}
+	sort.Strings(reasons)
+
+	c.Status = capabilityAvailable
+	if ctrs.LayersFound < ctrs.Total {
+		c.Status = capabilityPartial
+		reasons = append([]string{fmt.Sprintf("layers of %d of %d containers found", ctrs.LayersFound, ctrs.Total)}, reasons...)
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "all container layers found")
+	}
+	c.Reason = strings.Join(reasons, "; ")
+	return c
+}
+
+// exportCapability returns whether the container files can be exported,
+// scanned, and timelined without mounting.
+func exportCapability(ctrs evidenceContainers) capability {
+	c := capability{Feature: "export, scan, and timeline"}
+	switch {
+	case ctrs.Total == 0:
+		c.Status = capabilityUnavailable
+		c.Reason = "no containers found"
+	case ctrs.LayersFound == 0:
+		c.Status = capabilityUnavailable
+		c.Reason = "no container layers found on disk"
+	case ctrs.LayersFound < ctrs.Total:
+		c.Status = capabilityPartial
+		c.Reason = fmt.Sprintf("layers of %d of %d containers found", ctrs.LayersFound, ctrs.Total)
+	default:
+		c.Status = capabilityAvailable
+		c.Reason = "the container layers are read directly without mounting"
+	}
+	return c
+}
+
+// deletedFilesCapability returns whether the files deleted in the container
+// layer can be recovered from the image layers.
+func deletedFilesCapability(ctrs evidenceContainers) capability {
+	c := capability{Feature: "deleted file recovery"}
+	if ctrs.LayersFound == 0 {
+		c.Status = capabilityUnavailable
+		c.Reason = "no container layers found on disk"
+		return c
+	}
+
+	var fullcopy, layered []string
+	for driver := range ctrs.Drivers {
+		if storage.IsFullCopy(driver) {
+			fullcopy = append(fullcopy, driver)
+		} else {
+			layered = append(layered, driver)
+		}
+	}
+	sort.Strings(fullcopy)
+
+	switch {
+	case len(layered) == 0:
+		c.Status = capabilityUnavailable
+		c.Reason = fmt.Sprintf("%s keeps a full copy in each layer and does not record whiteouts", strings.Join(fullcopy, ","))
+	case len(fullcopy) > 0:
+		c.Status = capabilityPartial
+		c.Reason = fmt.Sprintf("not available for the containers using %s", strings.Join(fullcopy, ","))
+	default:
+		c.Status = capabilityAvailable
+		c.Reason = "deleted files are recovered from the image layers using the whiteouts"
+	}
+	return c
+}
+
+// logsCapability returns whether the container logs are available.
+//
+// The kubelet pod log directory and the runtime log files are read from the
+// knowledge pack of the distribution.
+func logsCapability(imageroot string, p knowledge.Pack, ctrs evidenceContainers) capability {
+	c := capability{Feature: "container logs"}
+
+	podlogs := imageroot != "" && explorers.PathExists(filepath.Join(imageroot, p.PodLogs()), false)
+	var runtimelogs []string
+	for _, path := range p.LogPaths {
+		if imageroot == "" {
+			break
+		}
+		if _, err := os.Stat(filepath.Join(imageroot, path)); err == nil {
+			runtimelogs = append(runtimelogs, path)
+		}
+	}
+
+	switch {
+	case ctrs.Logs > 0 && ctrs.Logs == ctrs.Total:
+		c.Status = capabilityAvailable
+		c.Reason = fmt.Sprintf("log files of %d containers found", ctrs.Logs)
+	case ctrs.Logs > 0:
+		c.Status = capabilityPartial
+		c.Reason = fmt.Sprintf("log files of %d of %d containers found", ctrs.Logs, ctrs.Total)
+	case podlogs:
+		c.Status = capabilityAvailable
+		c.Reason = fmt.Sprintf("kubelet pod logs found in %s", p.PodLogs())
+	case len(runtimelogs) > 0:
+		c.Status = capabilityPartial
+		c.Reason = fmt.Sprintf("no container logs found. %s logs found in %s", p.Name, strings.Join(runtimelogs, ","))
+	default:
+		c.Status = capabilityUnavailable
+		c.Reason = "no container log files or kubelet pod logs found"
+	}
+	return c
+}
+
+// metadataRecoveryCapability returns whether leftover copies of the
+// containerd databases are available for comparison.
+func metadataRecoveryCapability(clictx *cli.Context, rt string) capability {
+	c := capability{Feature: "metadata recovery"}
+	if !isContainerdRuntime(rt) {
+		c.Status = capabilityUnavailable
+		c.Reason = fmt.Sprintf("leftover database copies are only analyzed for containerd, not %s", rt)
+		return c
+	}
+
+	containerdroot, _, _ := resolveContainerdPaths(
+		clictx.GlobalString("image-root"),
+		containerdRoot(clictx),
+		clictx.GlobalString("metadata-file"),
+		clictx.GlobalString("snapshot-metadata-file"),
+	)
+	artifacts := containerd.FindMetadataArtifacts(containerdroot)
+	if len(artifacts) == 0 {
+		c.Status = capabilityUnavailable
+		c.Reason = "no leftover copies of meta.db or metadata.db found"
+		return c
+	}
+	c.Status = capabilityAvailable
+	c.Reason = fmt.Sprintf("%d leftover database copies found. Use stale-metadata", len(artifacts))
+	return c
+}
+
+// clusterRecoveryCapability returns whether the etcd database of a
+// control-plane node is available.
+func clusterRecoveryCapability(imageroot string) capability {
+	c := capability{Feature: "cluster recovery"}
+	dbpath := filepath.Join(imageroot, strings.Replace(etcdDataDir, "/", "", 1), "member", "snap", "db")
+	if imageroot == "" || !explorers.PathExists(dbpath, true) {
+		c.Status = capabilityUnavailable
+		c.Reason = "no etcd database found. The evidence is not a control-plane node or use cluster --etcd-dir"
+		return c
+	}
+	c.Status = capabilityAvailable
+	c.Reason = fmt.Sprintf("etcd database found at %s", dbpath)
+	return c
+}
+
+// liveCollectionCapability returns whether the evidence is a live host that
+// can be watched.
+func liveCollectionCapability(imageroot string) capability {
+	c := capability{Feature: "live collection"}
+	if imageroot != "" && imageroot != "/" {
+		c.Status = capabilityUnavailable
+		c.Reason = "the evidence is a mounted image. watch requires a live host"
+		return c
+	}
+
+	for _, socket := range []string{"/run/containerd/containerd.sock", "/var/run/docker.sock", "/run/k3s/containerd/containerd.sock"} {
+		if _, err := os.Stat(socket); err == nil {
+			c.Status = capabilityAvailable
+			c.Reason = fmt.Sprintf("container runtime socket %s found", socket)
+			return c
+		}
+	}
+	c.Status = capabilityPartial
+	c.Reason = "no container runtime socket found. The runtime may not be running"
+	return c
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/etcd"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const etcdDataDir = "/var/lib/etcd"
+
+// etcdFlags are the flags shared by the cluster subcommands.
+var etcdFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "etcd-dir",
+		Usage: "etcd data directory or database file. Default is /var/lib/etcd within the image root",
+	},
+	cli.BoolFlag{
+		Name:  "include-deleted",
+		Usage: "include objects deleted from etcd but not yet compacted",
+	},
+}
+
+// podContainer holds a pod container from etcd and the matching node-local
+// container.
+type podContainer struct {
+	Namespace   string `json:"namespace"`
+	Pod         string `json:"pod"`
+	PodUID      string `json:"pod_uid"`
+	Node        string `json:"node,omitempty"`
+	Phase       string `json:"phase,omitempty"`
+	Deleted     bool   `json:"deleted,omitempty"`
+	Container   string `json:"container"`
+	Image       string `json:"image"`
+	ContainerID string `json:"container_id,omitempty"`
+	Local       bool   `json:"local"`
+	LocalStatus string `json:"local_status,omitempty"`
+}
+
+var ClusterCommand = cli.Command{
+	Name:  "cluster",
+	Usage: "recover cluster objects from a control-plane node",
+	Description: `recover Kubernetes objects from the etcd database of a control-plane
+   node. The database is opened read-only.`,
+	Subcommands: cli.Commands{
+		clusterObjects,
+		clusterPods,
+	},
+}
+
+var clusterObjects = cli.Command{
+	Name:  "objects",
+	Usage: "list Kubernetes objects stored in etcd",
+	Description: `list the metadata of Kubernetes objects stored in etcd.
+
+   Only the metadata of secrets is reported. The secret data is not decoded.
+   Objects encrypted at rest are reported with the encryption provider.`,
+	Flags: append([]cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "resource",
+			Usage: "list only the specified resource i.e. pods, deployments, secrets",
+		},
+	}, etcdFlags...),
+	Action: func(clictx *cli.Context) error {
+		objects, err := readClusterObjects(clictx)
+		if err != nil {
+			return err
+		}
+
+		resources := make(map[string]bool)
+		for _, r := range clictx.StringSlice("resource") {
+			resources[r] = true
+		}
+
+		var selected []etcd.Object
+		for _, o := range objects {
+			if len(resources) > 0 && !resources[o.Resource] {
+				continue
+			}
+			selected = append(selected, o)
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, o := range selected {
+				printObject(output, o)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+
+		rw.Write("RESOURCE", "NAMESPACE", "NAME", "KIND", "UID", "CREATED AT", "OWNER", "DETAILS", "DELETED")
+		for _, o := range selected {
+			var owners []string
+			for _, owner := range o.Owners {
+				owners = append(owners, owner.Kind+"/"+owner.Name)
+			}
+			created := ""
+			if !o.CreatedAt.IsZero() {
+				created = formatTime(o.CreatedAt)
+			}
+			rw.Write(
+				o.Resource,
+				o.Namespace,
+				o.Name,
+				o.Kind,
+				o.UID,
+				created,
+				strings.Join(owners, ","),
+				objectDetails(o),
+				fmt.Sprint(o.Deleted),
+			)
+		}
+		return nil
+	},
+}
+
+var clusterPods = cli.Command{
+	Name:  "pods",
+	Usage: "list pod containers and correlate them with node-local containers",
+	Description: `list the containers of the pods stored in etcd.
+
+   If --image-root, --containerd-root, --docker-root, or --crio-root is
+   specified, the container IDs are matched with the containers on the node
+   and the LOCAL column shows the status of the node-local container.`,
+	Flags: etcdFlags,
+	Action: func(clictx *cli.Context) error {
+		objects, err := readClusterObjects(clictx)
+		if err != nil {
+			return err
+		}
+
+		local := make(map[string]explorers.Container)
+		if hasRuntimeRoot(clictx) {
+			ctx, exp, cancel, err := explorerEnvironment(clictx)
+			if err != nil {
+				log.Warn("skipping node-local container correlation: ", err)
+			} else {
+				defer cancel()
+
+				ctrs, err := exp.ListContainers(ctx)
+				if err != nil {
+					log.Warn("listing node-local containers: ", err)
+				}
+				for _, ctr := range ctrs {
+					local[ctr.ID] = ctr
+				}
+			}
+		}
+
+		var entries []podContainer
+		for _, o := range objects {
+			if o.Resource != "pods" {
+				continue
+			}
+
+			statuses := o.Containers
+			if len(statuses) == 0 {
+				// pods not yet scheduled or encrypted at rest
+				statuses = []etcd.ContainerStatus{{}}
+			}
+			for _, cs := range statuses {
+				entry := podContainer{
+					Namespace:   o.Namespace,
+					Pod:         o.Name,
+					PodUID:      o.UID,
+					Node:        o.NodeName,
+					Phase:       o.Phase,
+					Deleted:     o.Deleted,
+					Container:   cs.Name,
+					Image:       cs.Image,
+					ContainerID: cs.RuntimeContainerID(),
+				}
+				if ctr, found := local[entry.ContainerID]; found && entry.ContainerID != "" {
+					entry.Local = true
+					entry.LocalStatus = ctr.Status
+				}
+				entries = append(entries, entry)
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, entry := range entries {
+				printObject(output, entry)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+
+		rw.Write("NAMESPACE", "POD", "NODE", "PHASE", "CONTAINER", "IMAGE", "CONTAINER ID", "LOCAL", "DELETED")
+		for _, e := range entries {
+			rw.Write(
+				e.Namespace,
+				e.Pod,
+				e.Node,
+				e.Phase,
+				e.Container,
+				e.Image,
+				e.ContainerID,
+				e.LocalStatus,
+				fmt.Sprint(e.Deleted),
+			)
+		}
+		return nil
+	},
+}
+
+// readClusterObjects returns the objects in the etcd database specified
+// using --etcd-dir or found within the image root.
+func readClusterObjects(clictx *cli.Context) ([]etcd.Object, error) {
+	etcddir := clictx.String("etcd-dir")
+	if etcddir == "" {
+		imageroot := clictx.GlobalString("image-root")
+		if imageroot == "" {
+			return nil, fmt.Errorf("missing required argument. Use --image-root or --etcd-dir")
+		}
+		etcddir = filepath.Join(imageroot, strings.Replace(etcdDataDir, "/", "", 1))
+	}
+
+	dbpath := etcd.ResolveDBPath(etcddir)
+	if !explorers.PathExists(dbpath, true) {
+		return nil, fmt.Errorf("etcd database %s does not exist", dbpath)
+	}
+	return etcd.ReadObjects(dbpath, clictx.Bool("include-deleted"))
+}
+
+// hasRuntimeRoot returns true if the container runtime can be explored.
+func hasRuntimeRoot(clictx *cli.Context) bool {
+	for _, name := range []string{"image-root", "containerd-root", "docker-root", "crio-root"} {
+		if clictx.GlobalString(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// objectDetails returns a summary of the resource specific attributes of an
+// object.
+func objectDetails(o etcd.Object) string {
+	var details []string
+	if o.NodeName != "" {
+		details = append(details, "node="+o.NodeName)
+	}
+	if o.Phase != "" {
+		details = append(details, "phase="+o.Phase)
+	}
+	if o.ServiceAccount != "" {
+		details = append(details, "serviceaccount="+o.ServiceAccount)
+	}
+	if len(o.Images) > 0 {
+		details = append(details, "images="+strings.Join(o.Images, ","))
+	}
+	if len(o.PullSecrets) > 0 {
+		details = append(details, "imagepullsecrets="+strings.Join(o.PullSecrets, ","))
+	}
+	if o.SecretType != "" {
+		details = append(details, "type="+o.SecretType)
+	}
+	if o.Encrypted != "" {
+		details = append(details, "encrypted="+o.Encrypted)
+	}
+	return strings.Join(details, " ")
+}
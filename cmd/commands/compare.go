@@ -0,0 +1,342 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// comparedImage holds the image attributes of a host.
+type comparedImage struct {
+	Host       string          `json:"host"`
+	Runtime    string          `json:"runtime"`
+	Error      string          `json:"error,omitempty"`
+	Namespace  string          `json:"namespace,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	Manifest   string          `json:"manifest,omitempty"`
+	Config     string          `json:"config,omitempty"`
+	ConfigHash string          `json:"config_hash,omitempty"` // digest of the configuration file on disk
+	Layers     []comparedLayer `json:"layers,omitempty"`
+}
+
+// comparedLayer holds the layer attributes of a host.
+type comparedLayer struct {
+	explorers.ImageLayer
+	BlobHash string `json:"blob_hash,omitempty"` // digest of the layer blob on disk
+	TreeHash string `json:"tree_hash,omitempty"` // digest of the unpacked layer files
+}
+
+// imageDifference is an image attribute of a host differing from the other
+// hosts or from the declared digest.
+type imageDifference struct {
+	Attribute string `json:"attribute"`
+	Host      string `json:"host"`
+	Value     string `json:"value"`
+	Expected  string `json:"expected"`
+}
+
+// imageComparison is the result of compare image.
+type imageComparison struct {
+	Image       string            `json:"image"`
+	Hosts       []comparedImage   `json:"hosts"`
+	Differences []imageDifference `json:"differences"`
+}
+
+var CompareCommand = cli.Command{
+	Name:  "compare",
+	Usage: "compare objects across multiple hosts",
+	Subcommands: cli.Commands{
+		compareImage,
+	},
+}
+
+var compareImage = cli.Command{
+	Name:      "image",
+	Usage:     "compare an image across multiple hosts",
+	ArgsUsage: "IMAGE",
+	Description: `compare the configuration, layer digests, and on-disk layer hashes of
+   the same image across the mounted evidence of multiple hosts.
+
+   Each host is an image root i.e. the mount point of a disk image. The
+   container runtime of each host is detected unless --runtime is specified.
+
+   The configuration and layer blobs are hashed and verified against their
+   digests, and the files of the unpacked layers are hashed. A host with a
+   value differing from the majority of the hosts is reported.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "hosts",
+			Usage: "comma separated image roots of the hosts",
+		},
+		cli.BoolFlag{
+			Name:  "skip-layer-hash",
+			Usage: "skip hashing the files of the unpacked layers",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() != 1 {
+			return fmt.Errorf("image name is required")
+		}
+		ref := clictx.Args().First()
+
+		var hosts []string
+		for _, host := range strings.Split(clictx.String("hosts"), ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+		if len(hosts) < 2 {
+			return fmt.Errorf("at least two hosts are required. Use --hosts")
+		}
+		for _, name := range []string{"containerd-root", "docker-root", "crio-root", "podman-root"} {
+			if clictx.GlobalString(name) != "" {
+				return fmt.Errorf("--%s cannot be used with --hosts", name)
+			}
+		}
+
+		comparison := imageComparison{Image: ref}
+		for _, host := range hosts {
+			comparison.Hosts = append(comparison.Hosts, inspectHostImage(clictx, host, ref, !clictx.Bool("skip-layer-hash")))
+		}
+		comparison.Differences = compareImages(comparison.Hosts)
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			printObject(output, comparison)
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		rw.Write("HOST", "RUNTIME", "NAME", "CONFIG", "LAYERS", "DIFFERENCES", "ERROR")
+		for _, h := range comparison.Hosts {
+			count := 0
+			for _, d := range comparison.Differences {
+				if d.Host == h.Host {
+					count++
+				}
+			}
+			rw.Write(h.Host, h.Runtime, h.Name, h.Config, fmt.Sprint(len(h.Layers)), fmt.Sprint(count), h.Error)
+		}
+		rw.Flush()
+
+		if len(comparison.Differences) == 0 {
+			return nil
+		}
+
+		fmt.Println()
+		rw = newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("HOST", "ATTRIBUTE", "VALUE", "EXPECTED")
+		for _, d := range comparison.Differences {
+			rw.Write(d.Host, d.Attribute, d.Value, d.Expected)
+		}
+		return nil
+	},
+}
+
+// inspectHostImage returns the image attributes of a host.
+func inspectHostImage(clictx *cli.Context, host string, ref string, treehash bool) comparedImage {
+	result := comparedImage{Host: host}
+
+	// The explorer environment is computed from the global flags.
+	imageroot := clictx.GlobalString("image-root")
+	defer func() {
+		clictx.GlobalSet("image-root", imageroot)
+		detectedRuntime = ""
+	}()
+	if err := clictx.GlobalSet("image-root", host); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	detectedRuntime = ""
+	result.Runtime = selectedRuntime(clictx)
+
+	ctx, exp, cancel, err := explorerEnvironment(clictx)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer cancel()
+	defer exp.Close()
+
+	detail, err := exp.InspectImage(ctx, ref)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Namespace = detail.Namespace
+	result.Name = detail.Name
+	result.Manifest = detail.Manifest
+	result.Config = detail.Config
+	if detail.ConfigPath != "" {
+		result.ConfigHash, _ = fileDigest(detail.ConfigPath)
+	}
+
+	for _, layer := range detail.Layers {
+		cl := comparedLayer{ImageLayer: layer}
+		if layer.BlobPath != "" {
+			cl.BlobHash, _ = fileDigest(layer.BlobPath)
+		}
+		if treehash && layer.Dir != "" {
+			if cl.TreeHash, err = treeDigest(layer.Dir); err != nil {
+				log.WithFields(log.Fields{
+					"host": host,
+					"dir":  layer.Dir,
+				}).Warn("hashing layer files: ", err)
+			}
+		}
+		result.Layers = append(result.Layers, cl)
+	}
+
+	log.WithFields(log.Fields{
+		"host":   host,
+		"image":  detail.Name,
+		"layers": len(result.Layers),
+	}).Debug("inspected host image")
+
+	return result
+}
+
+// compareImages returns the attributes differing across the hosts and the
+// blobs not matching their digests.
+func compareImages(hosts []comparedImage) []imageDifference {
+	var (
+		differences []imageDifference
+		attributes  []string
+		values      = make(map[string]map[string]string) // attribute -> host -> value
+	)
+
+	add := func(attribute string, host string, value string) {
+		if value == "" {
+			return
+		}
+		if _, found := values[attribute]; !found {
+			attributes = append(attributes, attribute)
+			values[attribute] = make(map[string]string)
+		}
+		values[attribute][host] = value
+	}
+
+	for _, h := range hosts {
+		if h.Error != "" {
+			continue
+		}
+
+		// The blobs on disk must match their digests.
+		if h.ConfigHash != "" && h.ConfigHash != h.Config {
+			differences = append(differences, imageDifference{"config integrity", h.Host, h.ConfigHash, h.Config})
+		}
+		for i, layer := range h.Layers {
+			if layer.BlobHash != "" && layer.BlobHash != layer.Digest {
+				differences = append(differences, imageDifference{fmt.Sprintf("layer %d blob integrity", i), h.Host, layer.BlobHash, layer.Digest})
+			}
+		}
+
+		add("config", h.Host, h.Config)
+		add("layer count", h.Host, fmt.Sprint(len(h.Layers)))
+		for i, layer := range h.Layers {
+			add(fmt.Sprintf("layer %d digest", i), h.Host, layer.Digest)
+			add(fmt.Sprintf("layer %d diff id", i), h.Host, layer.DiffID)
+			add(fmt.Sprintf("layer %d tree hash", i), h.Host, layer.TreeHash)
+		}
+	}
+
+	for _, attribute := range attributes {
+		expected := majorityValue(hosts, values[attribute])
+		for _, h := range hosts {
+			if value, found := values[attribute][h.Host]; found && value != expected {
+				differences = append(differences, imageDifference{attribute, h.Host, value, expected})
+			}
+		}
+	}
+	return differences
+}
+
+// majorityValue returns the most common value. A tie is resolved in the
+// order of the hosts.
+func majorityValue(hosts []comparedImage, values map[string]string) string {
+	counts := make(map[string]int)
+	for _, v := range values {
+		counts[v]++
+	}
+
+	var majority string
+	for _, h := range hosts {
+		v, found := values[h.Host]
+		if found && counts[v] > counts[majority] {
+			majority = v
+		}
+	}
+	return majority
+}
+
+// fileDigest returns the SHA256 digest of a file.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// treeDigest returns the SHA256 digest of the files in a directory.
+//
+// The digest covers the relative path, mode, symbolic link target, and
+// content of each file in lexical order.
+func treeDigest(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(h, "%s\x00%s\x00", rel, info.Mode())
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, _ := os.Readlink(path)
+			fmt.Fprintf(h, "%s\x00", target)
+		case info.Mode().IsRegular():
+			digest, err := fileDigest(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00", digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
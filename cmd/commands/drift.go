@@ -0,0 +1,257 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// containerFileDrift is a file of a container differing from the image.
+type containerFileDrift struct {
+	Namespace   string `json:"namespace"`
+	ContainerID string `json:"container_id"`
+	Image       string `json:"image"`
+	explorers.FileDrift
+}
+
+var DriftCommand = cli.Command{
+	Name:    "drift",
+	Aliases: []string{"diff"},
+	Usage:   "report the files changed in containers relative to the image",
+	Description: `compare the upper (writable) layer of each container with the image layers
+   and report the files added, modified, and deleted in the container.
+
+   A modified file is a file of the image copied to the upper layer with a
+   different content, type, mode, owner, or modification time. DETAILS lists
+   the changed attributes. A deleted file is a file of the image hidden by a
+   whiteout or an opaque directory of the upper layer; its MODIFIED time is
+   the time of the whiteout i.e. close to the deletion time. The SHA256
+   digests of the regular files are computed in the container and in the
+   image.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "report only the specified container ID",
+		},
+		cli.StringFlag{
+			Name:  "change",
+			Usage: "comma separated changes to report i.e. added,modified,deleted",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		explainFlag,
+	},
+	Subcommands: cli.Commands{
+		driftSnapshot,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerDrift); err != nil {
+			return err
+		}
+
+		changes := make(map[string]bool)
+		for _, change := range strings.Split(clictx.String("change"), ",") {
+			switch change = strings.TrimSpace(change); change {
+			case "":
+			case explorers.DriftAdded, explorers.DriftModified, explorers.DriftDeleted:
+				changes[change] = true
+			default:
+				return fmt.Errorf("unsupported change %q. Use added, modified, or deleted", change)
+			}
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		var (
+			drifts   []containerFileDrift
+			compared int
+		)
+		for _, ctr := range ctrs {
+			if id := clictx.String("id"); id != "" && ctr.ID != id {
+				continue
+			}
+			if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+
+			layers, err := containerLayers(ctx, exp, ctr)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("skipping container: ", err)
+				continue
+			}
+
+			// The snapshot of a full copy storage driver i.e. native holds
+			// the image files and is compared with the image snapshot it
+			// was copied from.
+			var files []explorers.FileDrift
+			if len(layers) == 1 {
+				ic, ok := exp.(explorers.ImageCopyExplorer)
+				if !ok {
+					log.WithField("containerid", ctr.ID).Warn("skipping container. The container storage keeps a full copy of the image files")
+					continue
+				}
+				imagedir, err := ic.ContainerImageCopy(namespaces.WithNamespace(ctx, ctr.Namespace), ctr.ID)
+				if err != nil {
+					log.WithField("containerid", ctr.ID).Warn("skipping container. Getting the image snapshot of the full copy: ", err)
+					continue
+				}
+				files, err = explorers.FullCopyDrift(layers[0], imagedir)
+			} else {
+				files, err = explorers.ContainerDrift(layers[0], layers[1:])
+			}
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("comparing container with image: ", err)
+				continue
+			}
+			compared++
+
+			counts := make(map[string]int)
+			for _, f := range files {
+				counts[f.Change]++
+				if len(changes) > 0 && !changes[f.Change] {
+					continue
+				}
+				drifts = append(drifts, containerFileDrift{
+					Namespace:   ctr.Namespace,
+					ContainerID: ctr.ID,
+					Image:       ctr.Image,
+					FileDrift:   f,
+				})
+			}
+			log.WithFields(log.Fields{
+				"containerid": ctr.ID,
+				"upperdir":    layers[0],
+			}).Debug("compared container with image: ", countString(counts))
+		}
+
+		if compared == 0 {
+			if id := clictx.String("id"); id != "" {
+				return fmt.Errorf("container %s not found or has no layers", id)
+			}
+			return fmt.Errorf("no container compared")
+		}
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, d := range drifts {
+				explanations = append(explanations, explainDrift(d))
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, d := range drifts {
+				printObject(output, d)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("NAMESPACE", "CONTAINER ID", "CHANGE", "TYPE", "MODE", "SIZE", "MODIFIED", "DETAILS", "SHA256", "IMAGE SHA256", "PATH")
+		for _, d := range drifts {
+			path := d.Path
+			if d.Target != "" {
+				path = fmt.Sprintf("%s -> %s", d.Path, d.Target)
+			}
+			size := ""
+			if d.Change != explorers.DriftDeleted {
+				size = fmt.Sprint(d.Size)
+			}
+			rw.Write(
+				d.Namespace,
+				d.ContainerID,
+				d.Change,
+				d.Type,
+				d.Mode,
+				size,
+				formatTime(d.ModTime),
+				strings.Join(d.Details, ","),
+				d.SHA256,
+				d.ImageSHA256,
+				path,
+			)
+		}
+		return nil
+	},
+}
+
+// explainDrift returns the explanation of a file changed in a container.
+func explainDrift(d containerFileDrift) explanation {
+	e := explanation{
+		Namespace:   d.Namespace,
+		ContainerID: d.ContainerID,
+		Finding:     fmt.Sprintf("%s %s %s", d.Change, d.Type, d.Path),
+	}
+
+	switch d.Change {
+	case explorers.DriftAdded:
+		e.Rule = "the file exists in the writable layer of the container and in none of the image layers"
+	case explorers.DriftModified:
+		e.Rule = fmt.Sprintf("the file of the image was copied to the writable layer of the container and its %s changed", strings.Join(d.Details, ", "))
+	case explorers.DriftDeleted:
+		e.Rule = "the file of the image is hidden by a whiteout or an opaque directory in the writable layer of the container"
+	}
+
+	switch {
+	case d.Change == explorers.DriftDeleted && len(d.Details) > 0 && d.Details[0] == explorers.DriftMissing:
+		e.Rule = "the file of the image snapshot is missing in the full copy snapshot of the container"
+		e.Evidence = append(e.Evidence, evidence{Source: d.DiskPath, Field: "mtime", Value: "directory last modified", Time: d.ModTime})
+	case d.Change == explorers.DriftDeleted:
+		e.Evidence = append(e.Evidence, evidence{Source: d.DiskPath, Field: "mtime", Value: "whiteout created", Time: d.ModTime})
+	default:
+		e.Evidence = append(e.Evidence, evidence{Source: d.DiskPath, Field: "mode", Value: d.Mode})
+		e.Evidence = append(e.Evidence, evidence{Source: d.DiskPath, Field: "mtime", Value: "last modified", Time: d.ModTime})
+		if d.SHA256 != "" {
+			e.Evidence = append(e.Evidence, evidence{Source: d.DiskPath, Field: "sha256", Value: d.SHA256})
+		}
+		if d.Target != "" {
+			e.Evidence = append(e.Evidence, evidence{Source: d.DiskPath, Field: "target", Value: d.Target})
+		}
+	}
+
+	if d.ImagePath != "" {
+		e.Evidence = append(e.Evidence, evidence{Source: d.ImagePath, Field: "layer", Value: fmt.Sprintf("image layer %d", d.ImageLayer)})
+		e.Evidence = append(e.Evidence, evidence{Source: d.ImagePath, Field: "mode", Value: d.ImageMode})
+		if d.ImageSHA256 != "" {
+			e.Evidence = append(e.Evidence, evidence{Source: d.ImagePath, Field: "sha256", Value: d.ImageSHA256})
+		}
+		if d.ImageTarget != "" {
+			e.Evidence = append(e.Evidence, evidence{Source: d.ImagePath, Field: "target", Value: d.ImageTarget})
+		}
+	}
+	return e
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/storage"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// snapshotFileChange is a file introduced by a snapshot of a chain. The
+// image fields of the file describe the file in the parent snapshots.
+type snapshotFileChange struct {
+	Snapshotter string `json:"snapshotter"`
+	Snapshot    string `json:"snapshot"`
+	Position    int    `json:"position"` // position of the snapshot after the base snapshot starting at 1
+	explorers.FileDrift
+}
+
+var driftSnapshot = cli.Command{
+	Name:      "snapshot",
+	Usage:     "report the files introduced by each snapshot between two snapshots of a chain",
+	ArgsUsage: "SNAPSHOTTER/KEY-A SNAPSHOTTER/KEY-B",
+	Description: `compare each snapshot of the chain from snapshot A (excluded) to
+   snapshot B (included) with the merged view of its parent snapshots and
+   report the files the snapshot adds, modifies, and deletes.
+
+   A snapshot must be a parent of the other snapshot. The snapshots are
+   ordered from the oldest, so the first row of a file is the snapshot i.e.
+   the image layer where the file first appears. The parent chain is read
+   from the snapshot database metadata.db as with mount snapshot.
+
+   The snapshot is specified using the snapshot key listed by list
+   snapshots, the snapshot name in metadata.db i.e. k8s.io/12/<key>, or the
+   key part of a snapshot name. The snapshots of a full copy snapshotter
+   i.e. native cannot be compared.`,
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "path",
+			Usage: "container path or pattern to report i.e. '/usr/bin/**'. Can be repeated",
+		},
+		cli.StringFlag{
+			Name:  "change",
+			Usage: "comma separated changes to report i.e. added,modified,deleted",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() != 2 {
+			return fmt.Errorf("two snapshots are required")
+		}
+		snapshotterA, keyA, err := parseSnapshotRef(clictx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		snapshotterB, keyB, err := parseSnapshotRef(clictx.Args().Get(1))
+		if err != nil {
+			return err
+		}
+		if snapshotterA != snapshotterB {
+			return fmt.Errorf("snapshots of different snapshotters %s and %s cannot be compared", snapshotterA, snapshotterB)
+		}
+		snapshotter := snapshotterA
+		if storage.IsFullCopy(snapshotter) {
+			return fmt.Errorf("the %s snapshotter keeps a full copy of the files in each snapshot. Use a layered snapshotter i.e. overlayfs", snapshotter)
+		}
+
+		changes := make(map[string]bool)
+		for _, change := range strings.Split(clictx.String("change"), ",") {
+			switch change = strings.TrimSpace(change); change {
+			case "":
+			case explorers.DriftAdded, explorers.DriftModified, explorers.DriftDeleted:
+				changes[change] = true
+			default:
+				return fmt.Errorf("unsupported change %q. Use added, modified, or deleted", change)
+			}
+		}
+		var scope *explorers.PathList
+		if len(clictx.StringSlice("path")) > 0 {
+			if scope, err = explorers.NewPathList(clictx.StringSlice("path")); err != nil {
+				return err
+			}
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		se, ok := exp.(explorers.SnapshotLayerExplorer)
+		if !ok {
+			return fmt.Errorf("comparing snapshots is only supported for containerd")
+		}
+		if clictx.GlobalIsSet("namespace") {
+			ctx = namespaces.WithNamespace(ctx, clictx.GlobalString("namespace"))
+		}
+
+		dirA, parentsA, err := se.SnapshotLayers(ctx, snapshotter, keyA)
+		if err != nil {
+			return err
+		}
+		dirB, parentsB, err := se.SnapshotLayers(ctx, snapshotter, keyB)
+		if err != nil {
+			return err
+		}
+
+		// chain holds the snapshot directories from the newest snapshot
+		// and base is the index of the base snapshot in the chain.
+		var (
+			chain []string
+			base  int
+		)
+		switch {
+		case dirA == dirB:
+			return fmt.Errorf("snapshots %s and %s are the same snapshot", keyA, keyB)
+		case indexOf(parentsB, dirA) >= 0:
+			chain, base = append([]string{dirB}, parentsB...), indexOf(parentsB, dirA)+1
+		case indexOf(parentsA, dirB) >= 0:
+			log.WithFields(log.Fields{
+				"parent": keyB,
+				"child":  keyA,
+			}).Info("snapshot B is a parent of snapshot A. Comparing from snapshot B")
+			chain, base = append([]string{dirA}, parentsA...), indexOf(parentsA, dirB)+1
+		default:
+			return fmt.Errorf("snapshots %s and %s are not in the same chain", keyA, keyB)
+		}
+
+		keys := snapshotDirKeys(ctx, exp, snapshotter, chain)
+
+		var files []snapshotFileChange
+		for i := base - 1; i >= 0; i-- {
+			drifts, err := explorers.ContainerDrift(chain[i], chain[i+1:])
+			if err != nil {
+				return fmt.Errorf("comparing snapshot %s with its parents: %w", keys[chain[i]], err)
+			}
+
+			counts := make(map[string]int)
+			for _, d := range drifts {
+				counts[d.Change]++
+				if len(changes) > 0 && !changes[d.Change] {
+					continue
+				}
+				if scope != nil && !scope.Contains(d.Path) {
+					continue
+				}
+				files = append(files, snapshotFileChange{
+					Snapshotter: snapshotter,
+					Snapshot:    keys[chain[i]],
+					Position:    base - i,
+					FileDrift:   d,
+				})
+			}
+			log.WithFields(log.Fields{
+				"snapshot": keys[chain[i]],
+				"dir":      chain[i],
+			}).Debug("compared snapshot with parents: ", countString(counts))
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, f := range files {
+				printObject(output, f)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("POSITION", "SNAPSHOT", "CHANGE", "TYPE", "MODE", "SIZE", "MODIFIED", "DETAILS", "SHA256", "PATH")
+		for _, f := range files {
+			path := f.Path
+			if f.Target != "" {
+				path = fmt.Sprintf("%s -> %s", f.Path, f.Target)
+			}
+			size := ""
+			if f.Change != explorers.DriftDeleted {
+				size = fmt.Sprint(f.Size)
+			}
+			rw.Write(
+				fmt.Sprint(f.Position),
+				f.Snapshot,
+				f.Change,
+				f.Type,
+				f.Mode,
+				size,
+				formatTime(f.ModTime),
+				strings.Join(f.Details, ","),
+				f.SHA256,
+				path,
+			)
+		}
+		return nil
+	},
+}
+
+// snapshotDirKeys returns the snapshot keys of the snapshot directories. A
+// directory without a snapshot record in meta.db is keyed by the directory.
+func snapshotDirKeys(ctx context.Context, exp explorers.ContainerExplorer, snapshotter string, dirs []string) map[string]string {
+	keys := make(map[string]string)
+	for _, dir := range dirs {
+		keys[dir] = dir
+	}
+
+	snapshots, err := exp.ListSnapshots(ctx)
+	if err != nil {
+		log.Warn("listing snapshots: ", err)
+		return keys
+	}
+	for _, s := range snapshots {
+		if s.Snapshotter != snapshotter || s.OverlayPath == "" {
+			continue
+		}
+		for _, dir := range dirs {
+			if strings.HasSuffix(dir, string(filepath.Separator)+filepath.Clean(s.OverlayPath)) {
+				keys[dir] = s.Key
+			}
+		}
+	}
+	return keys
+}
+
+// indexOf returns the index of a value in a list or -1.
+func indexOf(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -25,7 +25,10 @@ import (
 
 	"github.com/google/container-explorer/explorers"
 	"github.com/google/container-explorer/explorers/containerd"
+	"github.com/google/container-explorer/explorers/crio"
 	"github.com/google/container-explorer/explorers/docker"
+	"github.com/google/container-explorer/explorers/lxd"
+	"github.com/google/container-explorer/explorers/podman"
 	"github.com/urfave/cli"
 
 	log "github.com/sirupsen/logrus"
@@ -34,6 +37,11 @@ import (
 const (
 	containerdRootDir = "/var/lib/containerd"
 	dockerRootDir     = "/var/lib/docker"
+	crioRootDir       = "/var/lib/containers/storage"
+
+	// windowsContainerdRootDir is the containerd root directory of a
+	// Windows node i.e. C:\ProgramData\containerd\root.
+	windowsContainerdRootDir = "/ProgramData/containerd/root"
 )
 
 // explorerEnvironment returns a ContainerExplorer interface.
@@ -43,38 +51,28 @@ func explorerEnvironment(clictx *cli.Context) (context.Context, explorers.Contai
 	ctx, cancel := context.WithCancel(context.Background())
 
 	imageroot := clictx.GlobalString("image-root")
-	containerdroot := clictx.GlobalString("containerd-root")
+	containerdroot := containerdRoot(clictx)
 	dockerroot := clictx.GlobalString("docker-root")
 	metadatafile := clictx.GlobalString("metadata-file")
 	snapshotfile := clictx.GlobalString("snapshot-metadata-file")
 
 	// Read support container data if provided using global switch.
-	var sc *explorers.SupportContainer
-	if clictx.GlobalString("support-container-data") != "" {
-		var err error
-		sc, err = explorers.NewSupportContainer(clictx.GlobalString("support-container-data"))
-		if err != nil {
-			log.Errorf("getting new support container: %v", err)
-		}
-	}
+	sc := supportContainerData(clictx)
+
+	runtime := selectedRuntime(clictx)
 
 	// Handle docker managed containers.
 	//
-	// Use the global flag --docker-managed to specify container
-	// managed using docker. This includes Kubernetes containers
-	// managed using docker.
-	if clictx.GlobalBool("docker-managed") {
+	// Use the global flag --runtime docker or --docker-managed to specify
+	// container managed using docker. This includes Kubernetes containers
+	// managed using docker and the docker forks i.e. balena-engine.
+	if isDockerRuntime(runtime) {
 		if dockerroot == "" && imageroot == "" {
 			fmt.Printf("Missing required argument. Use --image-root or --docker-root\n")
 			os.Exit(1)
 		}
 
-		if imageroot != "" && dockerroot == "" {
-			dockerroot = filepath.Join(
-				imageroot,
-				strings.Replace(dockerRootDir, "/", "", 1),
-			)
-		}
+		dockerroot = dockerRoot(clictx)
 
 		log.WithFields(log.Fields{
 			"imageroot":      imageroot,
@@ -91,28 +89,110 @@ func explorerEnvironment(clictx *cli.Context) (context.Context, explorers.Contai
 		}, nil
 	}
 
+	// Handle CRI-O managed containers.
+	//
+	// Use the global flag --runtime crio or --crio-managed to specify
+	// Kubernetes containers managed using CRI-O and stored in containers
+	// storage.
+	if runtime == runtimeCrio {
+		crioroot := clictx.GlobalString("crio-root")
+		if crioroot == "" && imageroot == "" {
+			fmt.Printf("Missing required argument. Use --image-root or --crio-root\n")
+			os.Exit(1)
+		}
+
+		crioroot = resolveCrioRoot(imageroot, crioroot)
+
+		log.WithFields(log.Fields{
+			"imageroot": imageroot,
+			"crioroot":  crioroot,
+		}).Debug("CRI-O container environment")
+
+		ce, err := crio.NewExplorer(imageroot, crioroot, sc)
+		if err != nil {
+			return ctx, nil, func() { cancel() }, err
+		}
+		return ctx, ce, func() {
+			cancel()
+		}, nil
+	}
+
+	// Handle Podman managed containers.
+	//
+	// Use the global flag --runtime podman or --podman-managed to specify
+	// containers managed using Podman. The rootful and rootless stores
+	// within the image root are explored unless --podman-root is specified.
+	if runtime == runtimePodman {
+		podmanroot := clictx.GlobalString("podman-root")
+		if podmanroot == "" && imageroot == "" {
+			fmt.Printf("Missing required argument. Use --image-root or --podman-root\n")
+			os.Exit(1)
+		}
+
+		stores := []podman.Store{{Root: podmanroot}}
+		if podmanroot == "" {
+			stores = podman.FindStores(imageroot)
+		}
+
+		log.WithFields(log.Fields{
+			"imageroot": imageroot,
+			"stores":    stores,
+		}).Debug("podman container environment")
+
+		pe, err := podman.NewExplorer(imageroot, stores, sc)
+		if err != nil {
+			return ctx, nil, func() { cancel() }, err
+		}
+		return ctx, pe, func() {
+			cancel()
+		}, nil
+	}
+
+	// Handle LXD and LXC managed containers.
+	//
+	// Use the global flag --runtime lxd to specify system containers
+	// managed using LXD or the legacy LXC tools. The deb and snap LXD roots
+	// within the image root are explored unless --lxd-root is specified.
+	if runtime == runtimeLXD {
+		lxdroot := clictx.GlobalString("lxd-root")
+		if lxdroot == "" && imageroot == "" {
+			fmt.Printf("Missing required argument. Use --image-root or --lxd-root\n")
+			os.Exit(1)
+		}
+
+		roots := []string{lxdroot}
+		if lxdroot == "" {
+			roots = lxd.FindRoots(imageroot)
+		}
+
+		log.WithFields(log.Fields{
+			"imageroot": imageroot,
+			"roots":     roots,
+		}).Debug("LXD container environment")
+
+		le, err := lxd.NewExplorer(imageroot, roots, sc)
+		if err != nil {
+			return ctx, nil, func() { cancel() }, err
+		}
+		return ctx, le, func() {
+			cancel()
+		}, nil
+	}
+
 	// Handle containerd managed containers.
 	//
 	// The default is containerd managed containers. This includes
-	// Kubernetes managed containers.
+	// Kubernetes managed containers and k3s or RKE2 using the embedded
+	// containerd.
+	if !isContainerdRuntime(runtime) {
+		return ctx, nil, func() { cancel() }, fmt.Errorf("unsupported runtime %s", runtime)
+	}
 	if containerdroot == "" && imageroot == "" {
 		fmt.Printf("Missing required arguments. Use --image-root or --containerd-root\n")
 		os.Exit(1)
 	}
 
-	if imageroot != "" && containerdroot == "" {
-		containerdroot = filepath.Join(
-			imageroot,
-			strings.Replace(containerdRootDir, "/", "", 1),
-		)
-	}
-
-	if metadatafile == "" {
-		metadatafile = filepath.Join(containerdroot, "io.containerd.metadata.v1.bolt", "meta.db")
-	}
-	if snapshotfile == "" {
-		snapshotfile = filepath.Join(containerdroot, "io.containerd.snapshotter.v1.overlayfs", "metadata.db")
-	}
+	containerdroot, metadatafile, snapshotfile = resolveContainerdPaths(imageroot, containerdroot, metadatafile, snapshotfile)
 
 	log.WithFields(log.Fields{
 		"imageroot":      imageroot,
@@ -120,8 +200,21 @@ func explorerEnvironment(clictx *cli.Context) (context.Context, explorers.Contai
 		"dockerroot":     dockerroot,
 		"manifestfile":   metadatafile,
 		"snapshotfile":   snapshotfile,
+		"runtime":        runtime,
 	}).Debug("containerd container environment")
 
+	// Distributions running several containerd instances i.e. the
+	// Bottlerocket host-containerd.
+	if instances := containerdInstances(clictx, containerdroot, metadatafile, snapshotfile); len(instances) > 1 {
+		ie, err := containerd.NewInstancesExplorer(imageroot, instances, sc)
+		if err != nil {
+			return ctx, nil, func() { cancel() }, err
+		}
+		return ctx, ie, func() {
+			cancel()
+		}, nil
+	}
+
 	cde, err := containerd.NewExplorer(imageroot, containerdroot, metadatafile, snapshotfile, sc)
 	if err != nil {
 		return ctx, nil, func() { cancel() }, err
@@ -130,3 +223,154 @@ func explorerEnvironment(clictx *cli.Context) (context.Context, explorers.Contai
 		cancel()
 	}, nil
 }
+
+// SetupSnapshotDataDirs sets the snapshot data directories specified using
+// the global flag --snapshot-data-dir.
+//
+// The snapshots tree may be captured onto a different evidence volume than
+// the containerd metadata. The directories are searched for the snapshot
+// directories before the containerd root.
+func SetupSnapshotDataDirs(clictx *cli.Context) error {
+	dirs := clictx.GlobalStringSlice("snapshot-data-dir")
+	if len(dirs) == 0 {
+		return nil
+	}
+	return containerd.SetSnapshotDataDirs(dirs)
+}
+
+// SetupContentDirs sets the content store directories specified using the
+// global flag --content-dir.
+//
+// The content store may be relocated to a data disk or captured onto a
+// different evidence volume. The directories are searched for the blobs
+// before the content stores configured in config.toml and the content store
+// of the containerd root.
+func SetupContentDirs(clictx *cli.Context) error {
+	dirs := clictx.GlobalStringSlice("content-dir")
+	if len(dirs) == 0 {
+		return nil
+	}
+	return containerd.SetContentDirs(dirs)
+}
+
+// SetupFuseMount selects the FUSE mount backend specified using the global
+// flag --fuse.
+//
+// The container layers are mounted in user space using fuse-overlayfs, so
+// an analyst without root privileges can mount the containers i.e. on a
+// locked-down workstation.
+func SetupFuseMount(clictx *cli.Context) error {
+	if !clictx.GlobalBool("fuse") {
+		return nil
+	}
+	explorers.EnableFuseMount()
+	log.Debug("mounting containers using fuse-overlayfs")
+	return nil
+}
+
+// resolveDockerRoot returns the docker root directory.
+//
+// The docker root directory is computed from the image root when the
+// docker root is not specified. The rootful docker root is preferred. A
+// rootless docker root i.e. /home/<user>/.local/share/docker is used when
+// only the rootless docker root has containers.
+func resolveDockerRoot(imageroot string, dockerroot string) string {
+	if imageroot == "" || dockerroot != "" {
+		return dockerroot
+	}
+
+	dockerroot = filepath.Join(
+		imageroot,
+		strings.Replace(dockerRootDir, "/", "", 1),
+	)
+	if configured := explorers.ConfiguredDockerRoot(imageroot, dockerRootDir); configured != "" {
+		log.WithField("root", configured).Info("using docker data root configured on the evidence host")
+		return configured
+	}
+
+	roots := docker.FindRoots(imageroot)
+	for _, r := range roots {
+		if !r.HasContainers() {
+			continue
+		}
+		if r.Root != dockerroot {
+			log.WithFields(log.Fields{
+				"user": r.User,
+				"root": r.Root,
+			}).Info("using rootless docker root")
+		}
+		dockerroot = r.Root
+		break
+	}
+
+	for _, r := range roots {
+		if r.Root != dockerroot && r.HasContainers() {
+			log.WithFields(log.Fields{
+				"user": r.User,
+				"root": r.Root,
+			}).Info("found other docker root. Use --docker-root to explore")
+		}
+	}
+	return dockerroot
+}
+
+// resolveCrioRoot returns the containers storage root directory.
+//
+// The containers storage root directory is computed from the image root when
+// the root is not specified.
+func resolveCrioRoot(imageroot string, crioroot string) string {
+	if imageroot != "" && crioroot == "" {
+		crioroot = filepath.Join(
+			imageroot,
+			strings.Replace(crioRootDir, "/", "", 1),
+		)
+	}
+	return crioroot
+}
+
+// resolveContainerdPaths returns the containerd root directory, metadata file
+// (meta.db), and snapshot metadata file (metadata.db).
+//
+// The default values are computed from the image root and containerd root
+// when the values are not specified. The containerd root directory of a
+// Windows node is used when the image root does not have the Linux
+// containerd root directory, and the snapshot metadata file of the windows
+// snapshotter is used when the overlayfs snapshot metadata file does not
+// exist.
+func resolveContainerdPaths(imageroot string, containerdroot string, metadatafile string, snapshotfile string) (string, string, string) {
+	if imageroot != "" && containerdroot == "" {
+		containerdroot = filepath.Join(
+			imageroot,
+			strings.Replace(containerdRootDir, "/", "", 1),
+		)
+		if windowsroot := filepath.Join(imageroot, windowsContainerdRootDir); !explorers.PathExists(containerdroot, false) && explorers.PathExists(windowsroot, false) {
+			containerdroot = windowsroot
+		}
+	}
+
+	if metadatafile == "" {
+		metadatafile = filepath.Join(containerdroot, "io.containerd.metadata.v1.bolt", "meta.db")
+	}
+	if snapshotfile == "" {
+		snapshotfile = filepath.Join(containerdroot, "io.containerd.snapshotter.v1.overlayfs", "metadata.db")
+		if windowsfile := filepath.Join(containerdroot, "io.containerd.snapshotter.v1.windows", "metadata.db"); !explorers.PathExists(snapshotfile, true) && explorers.PathExists(windowsfile, true) {
+			snapshotfile = windowsfile
+		}
+	}
+	return containerdroot, metadatafile, snapshotfile
+}
+
+// supportContainerData returns the support container data specified using
+// the global flag --support-container-data and the support containers of the
+// knowledge pack of the selected distribution.
+func supportContainerData(clictx *cli.Context) *explorers.SupportContainer {
+	var sc *explorers.SupportContainer
+	if clictx.GlobalString("support-container-data") != "" {
+		var err error
+		sc, err = explorers.NewSupportContainer(clictx.GlobalString("support-container-data"))
+		if err != nil {
+			log.Errorf("getting new support container: %v", err)
+		}
+	}
+	return mergeSupportContainers(sc, selectedPack(clictx))
+}
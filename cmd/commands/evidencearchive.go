@@ -0,0 +1,138 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/container-explorer/explorers/archive"
+	log "github.com/sirupsen/logrus"
+)
+
+// archiveRuntimeMarkers are the entries relative to /var/lib identifying the
+// data of a container runtime in an evidence archive.
+var archiveRuntimeMarkers = []string{
+	"containerd/io.containerd.metadata.v1.bolt/meta.db",
+	"docker/containers/",
+	"containers/storage/",
+}
+
+// archiveLayout is the location of the container runtime data within an
+// evidence archive.
+type archiveLayout struct {
+	Prefix string // archive directory holding the data
+	Mount  string // mount point of the directory in the OS root i.e. var/lib. Empty for an OS root
+}
+
+// archiveImageRoot returns the OS root of a tar or zip evidence archive
+// i.e. a triage collection of /var/lib.
+//
+// The archive entries are indexed to locate the container runtime data
+// without extracting the archive. The directory holding the data is then
+// extracted to the temporary directory, so a collection of a whole root
+// filesystem is not extracted except for the OS root holding the runtime.
+// A copy of /var or /var/lib is composed into an OS root at its mount
+// point.
+func archiveImageRoot(path string) (imageRootLayout, error) {
+	idx, err := archive.OpenIndex(path)
+	if err != nil {
+		return imageRootLayout{}, err
+	}
+	defer idx.Close()
+
+	var layout archiveLayout
+	layouts := archiveLayouts(idx)
+	switch len(layouts) {
+	case 0:
+		log.WithField("archive", path).Warn("no container runtime found in archive. Extracting the archive")
+	case 1:
+		layout = layouts[0]
+	default:
+		log.WithFields(log.Fields{
+			"archive": path,
+			"layouts": layouts,
+		}).Debug("several container runtime locations found in archive. Extracting the archive")
+	}
+
+	dir, err := imageRootTempDir("archive-")
+	if err != nil {
+		return imageRootLayout{}, err
+	}
+	count, err := idx.Extract(dir, layout.Prefix)
+	if err != nil {
+		return imageRootLayout{}, err
+	}
+	log.WithFields(log.Fields{
+		"archive": path,
+		"format":  idx.Format,
+		"prefix":  layout.Prefix,
+		"mount":   layout.Mount,
+		"entries": count,
+		"dir":     dir,
+	}).Info("extracted evidence archive")
+
+	if layout.Mount != "" {
+		root, err := composeImageRoot(nil, map[string]string{layout.Mount: dir})
+		if err != nil {
+			return imageRootLayout{}, err
+		}
+		return imageRootLayout{Root: root}, nil
+	}
+	return locateImageRoot(dir)
+}
+
+// archiveLayouts returns the distinct locations of the container runtime
+// data in an archive.
+//
+// The data is found below var/lib in a collection of a root filesystem,
+// below lib in a collection of /var, and at the top of a collection of
+// /var/lib. The archive may have a top directory i.e. node01/var/lib.
+func archiveLayouts(idx *archive.Index) []archiveLayout {
+	found := make(map[archiveLayout]bool)
+	for _, e := range idx.Entries {
+		name := e.Name
+		if e.Mode.IsDir() {
+			name += "/"
+		}
+		for _, marker := range archiveRuntimeMarkers {
+			i := strings.Index(name, marker)
+			if i < 0 || (i > 0 && name[i-1] != '/') {
+				continue
+			}
+
+			dir := strings.TrimSuffix(name[:i], "/")
+			layout := archiveLayout{Prefix: dir, Mount: "var/lib"}
+			switch {
+			case dir == "var/lib" || strings.HasSuffix(dir, "/var/lib"):
+				layout = archiveLayout{Prefix: strings.TrimSuffix(strings.TrimSuffix(dir, "var/lib"), "/")}
+			case dir == "lib" || strings.HasSuffix(dir, "/lib"):
+				layout = archiveLayout{Prefix: strings.TrimSuffix(strings.TrimSuffix(dir, "lib"), "/"), Mount: "var"}
+			}
+			found[layout] = true
+		}
+	}
+
+	layouts := make([]archiveLayout, 0, len(found))
+	for layout := range found {
+		layouts = append(layouts, layout)
+	}
+	sort.Slice(layouts, func(i, j int) bool {
+		return layouts[i].Prefix < layouts[j].Prefix
+	})
+	return layouts
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/urfave/cli"
+)
+
+// exitedGroup summarizes the exited containers of an image in a namespace.
+type exitedGroup struct {
+	Namespace      string
+	Image          string
+	Count          int
+	Pods           int
+	FirstCreatedAt time.Time
+	LastCreatedAt  time.Time
+	LastFinishedAt time.Time
+	ExitCodes      map[int]int
+	LastID         string
+
+	pods map[string]bool
+}
+
+var listExited = cli.Command{
+	Name:    "exited",
+	Aliases: []string{"exited-groups"},
+	Usage:   "summarize exited containers by image",
+	Description: `group the exited containers by namespace and image.
+
+   A Kubernetes node may keep tens of thousands of exited container records
+   i.e. a crash looping pod. The containers are read without decoding the
+   container spec where supported. Use list containers --limit to page the
+   individual containers.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include supporting containers created by Kubernetes",
+		},
+	},
+	Action: fleetAction(func(clictx *cli.Context, out *listOutput) error {
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := listContainerRecords(ctx, exp)
+		if err != nil {
+			return err
+		}
+
+		groups := exitedGroups(ctrs, clictx.Bool("show-support-containers"))
+
+		output := out.Format()
+		if isStructuredOutput(output) {
+			for _, g := range groups {
+				out.Object(g)
+			}
+			return nil
+		}
+
+		rw := out.Rows()
+		defer rw.Flush()
+		rw.Write("NAMESPACE", "IMAGE", "EXITED", "PODS", "FIRST CREATED AT", "LAST CREATED AT", "LAST FINISHED AT", "EXIT CODES", "LAST CONTAINER ID")
+		for _, g := range groups {
+			rw.Write(
+				g.Namespace,
+				g.Image,
+				fmt.Sprint(g.Count),
+				fmt.Sprint(g.Pods),
+				formatTime(g.FirstCreatedAt),
+				formatTime(g.LastCreatedAt),
+				staleTime(g.LastFinishedAt),
+				exitCodeString(g.ExitCodes),
+				g.LastID,
+			)
+		}
+		return nil
+	}),
+}
+
+// listContainerRecords returns the containers without the container spec
+// details where supported by the explorer.
+func listContainerRecords(ctx context.Context, exp explorers.ContainerExplorer) ([]explorers.Container, error) {
+	if lister, ok := exp.(explorers.ContainerRecordLister); ok {
+		return lister.ListContainerRecords(ctx)
+	}
+	return exp.ListContainers(ctx)
+}
+
+// exitedGroups groups the exited containers by namespace and image. The
+// groups are ordered by the number of exited containers.
+func exitedGroups(ctrs []explorers.Container, supportcontainers bool) []*exitedGroup {
+	index := make(map[string]*exitedGroup)
+	var groups []*exitedGroup
+
+	for _, ctr := range ctrs {
+		if ctr.Status != "STOPPED" && ctr.FinishedAt.IsZero() {
+			continue
+		}
+		if ctr.SupportContainer && !supportcontainers {
+			continue
+		}
+
+		key := ctr.Namespace + "\x00" + ctr.Image
+		g, found := index[key]
+		if !found {
+			g = &exitedGroup{
+				Namespace: ctr.Namespace,
+				Image:     ctr.Image,
+				ExitCodes: make(map[int]int),
+				pods:      make(map[string]bool),
+			}
+			index[key] = g
+			groups = append(groups, g)
+		}
+
+		g.Count++
+		if g.FirstCreatedAt.IsZero() || ctr.CreatedAt.Before(g.FirstCreatedAt) {
+			g.FirstCreatedAt = ctr.CreatedAt
+		}
+		if ctr.CreatedAt.After(g.LastCreatedAt) {
+			g.LastCreatedAt = ctr.CreatedAt
+			g.LastID = ctr.ID
+		}
+		if ctr.FinishedAt.After(g.LastFinishedAt) {
+			g.LastFinishedAt = ctr.FinishedAt
+		}
+		if !ctr.FinishedAt.IsZero() {
+			g.ExitCodes[ctr.ExitCode]++
+		}
+		if uid := ctr.Labels[explorers.LabelPodUID]; uid != "" {
+			g.pods[uid] = true
+		}
+		g.Pods = len(g.pods)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Namespace+groups[i].Image < groups[j].Namespace+groups[j].Image
+	})
+	return groups
+}
+
+// exitCodeString returns the exit codes and the number of containers
+// i.e. 1:120,137:3
+func exitCodeString(codes map[int]int) string {
+	keys := make([]int, 0, len(codes))
+	for code := range codes {
+		keys = append(keys, code)
+	}
+	sort.Ints(keys)
+
+	var values []string
+	for _, code := range keys {
+		values = append(values, fmt.Sprintf("%d:%d", code, codes[code]))
+	}
+	return strings.Join(values, ",")
+}
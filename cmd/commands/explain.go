@@ -0,0 +1,114 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/urfave/cli"
+)
+
+// explainFlag prints the evidence and the rule of each finding instead of
+// the finding rows.
+var explainFlag = cli.BoolFlag{
+	Name:  "explain",
+	Usage: "print the evidence and the rule that fired for each finding",
+}
+
+// explanation describes why a finding was reported.
+//
+// Rule is the logic that fired, and Evidence lists the fields, files, and
+// timestamps the rule was evaluated on, so the finding can be validated
+// independently of container-explorer.
+type explanation struct {
+	Analyzer    string     `json:"analyzer,omitempty"`
+	Namespace   string     `json:"namespace,omitempty"`
+	ContainerID string     `json:"container_id,omitempty"`
+	Finding     string     `json:"finding"`
+	Rule        string     `json:"rule"`
+	Evidence    []evidence `json:"evidence"`
+}
+
+// evidence is a value a rule was evaluated on.
+//
+// Source is the file or record holding the value and Field is the field
+// within the source i.e. the JSON path in a container spec.
+type evidence struct {
+	Source string    `json:"source"`
+	Field  string    `json:"field,omitempty"`
+	Value  string    `json:"value"`
+	Time   time.Time `json:"time"` // zero if the value has no time
+}
+
+// MarshalJSON omits the time of a value without a time.
+func (e evidence) MarshalJSON() ([]byte, error) {
+	type value evidence
+	return json.Marshal(struct {
+		value
+		Time *time.Time `json:"time,omitempty"`
+	}{
+		value: value(e),
+		Time:  explorers.OptionalTime(e.Time),
+	})
+}
+
+// printExplanations prints the explanations as JSON objects or as text
+// blocks separated by a blank line.
+func printExplanations(output string, explanations []explanation) {
+	for i := range explanations {
+		explanations[i].Analyzer = runningAnalyzer
+	}
+
+	if isStructuredOutput(output) {
+		for _, e := range explanations {
+			printObject(output, e)
+		}
+		return
+	}
+
+	for i, e := range explanations {
+		if i > 0 || explanationsPrinted {
+			fmt.Println()
+		}
+		if e.Analyzer != "" {
+			fmt.Printf("ANALYZER:  %s\n", e.Analyzer)
+		}
+		if e.ContainerID != "" {
+			fmt.Printf("CONTAINER: %s/%s\n", e.Namespace, e.ContainerID)
+		}
+		fmt.Printf("FINDING:   %s\n", e.Finding)
+		fmt.Printf("RULE:      %s\n", e.Rule)
+		fmt.Println("EVIDENCE:")
+		for _, ev := range e.Evidence {
+			line := fmt.Sprintf("  - %s", ev.Source)
+			if ev.Field != "" {
+				line = fmt.Sprintf("%s [%s]", line, ev.Field)
+			}
+			line = fmt.Sprintf("%s: %s", line, ev.Value)
+			if !ev.Time.IsZero() {
+				line = fmt.Sprintf("%s (%s)", line, formatTime(ev.Time))
+			}
+			fmt.Println(line)
+		}
+	}
+
+	// analyze run prints the explanations of several analyzers.
+	explanationsPrinted = runningAnalyzer != "" && len(explanations) > 0
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var ExportCommand = cli.Command{
+	Name:  "export",
+	Usage: "export container data",
+	Subcommands: cli.Commands{
+		exportRecentFiles,
+		exportPod,
+		exportNamespace,
+		exportImage,
+		exportAutopsy,
+		exportSQLite,
+		exportTar,
+		exportOCIImage,
+		exportDockerArchive,
+		exportLayer,
+	},
+}
+
+// exportedFile holds information about an exported file.
+type exportedFile struct {
+	Path       string     `json:"path"`
+	Layer      string     `json:"layer"`
+	Status     string     `json:"status"`
+	ModifiedAt time.Time  `json:"modified_at"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"` // nil if the file is not deleted
+	Size       int64      `json:"size"`
+}
+
+var exportRecentFiles = cli.Command{
+	Name:        "recent-files",
+	Usage:       "export files modified within a time window",
+	Description: "export container files modified within a time window. Files in the upper (writable) layer take precedence over the image layers. A deleted file is exported if it was deleted within the time window, i.e. the whiteout or the opaque directory hiding the file was modified within the time window.",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "container ID",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "time window i.e. 7d, 12h, or RFC3339 timestamp",
+			Value: "7d",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "output directory",
+		},
+		cli.BoolFlag{
+			Name:  "upper-only",
+			Usage: "export files from the upper (writable) layer only",
+		},
+		cli.BoolFlag{
+			Name:  "include-deleted",
+			Usage: "export files deleted within the time window in the upper layer that are recoverable from the lower layers",
+		},
+		pathsFromFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		containerid := clictx.String("id")
+		if containerid == "" {
+			return fmt.Errorf("container id is required")
+		}
+
+		outputdir := clictx.String("output")
+		if outputdir == "" {
+			return fmt.Errorf("output directory is required")
+		}
+
+		since, err := parseSince(clictx.String("since"), time.Now())
+		if err != nil {
+			return err
+		}
+
+		scope, err := pathScope(clictx)
+		if err != nil {
+			return err
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctx = namespaces.WithNamespace(ctx, clictx.GlobalString("namespace"))
+
+		upperdir, lowerdirs, err := exp.ContainerLayers(ctx, containerid)
+		if err != nil {
+			return err
+		}
+
+		layers := []string{upperdir}
+		if !clictx.Bool("upper-only") {
+			layers = append(layers, lowerdirs...)
+		}
+
+		walk := explorers.WalkLayers
+		if clictx.Bool("include-deleted") {
+			walk = explorers.WalkLayersWithDeleted
+		}
+
+		var exported []exportedFile
+		err = walk(layers, scope.Filter(func(f explorers.LayerFile) error {
+			// The modification time of a deleted file is the time of the
+			// image file, so a deleted file is selected using the deletion
+			// time.
+			changed := f.Info.ModTime()
+			if f.Deleted {
+				changed = f.DeletedAt
+			}
+			if !f.Info.Mode().IsRegular() || changed.Before(since) {
+				return nil
+			}
+
+			dst := exportPath(outputdir, f)
+			if err := explorers.CopyFile(f.LayerPath, dst, f.Info); err != nil {
+				log.WithFields(log.Fields{
+					"path":  f.LayerPath,
+					"error": err,
+				}).Warn("skipping file export")
+				return nil
+			}
+
+			ef := exportedFile{
+				Path:       f.Path,
+				Layer:      layerName(f.Layer),
+				Status:     f.Status(),
+				ModifiedAt: f.Info.ModTime().UTC(),
+				Size:       f.Info.Size(),
+			}
+			if f.Deleted {
+				ef.DeletedAt = explorers.OptionalTime(f.DeletedAt.UTC())
+			}
+			exported = append(exported, ef)
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
+
+		if output := clictx.GlobalString("output"); isStructuredOutput(output) {
+			for _, ef := range exported {
+				printObject(output, ef)
+			}
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
+		defer tw.Flush()
+
+		fmt.Fprintf(tw, "LAYER\tSTATUS\tMODIFIED AT\tDELETED AT\tSIZE\tPATH\n")
+		for _, f := range exported {
+			deletedat := ""
+			if f.DeletedAt != nil {
+				deletedat = formatTime(*f.DeletedAt)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n",
+				f.Layer,
+				f.Status,
+				formatTime(f.ModifiedAt),
+				deletedat,
+				f.Size,
+				f.Path,
+			)
+		}
+		return nil
+	},
+}
+
+// layerName returns a friendly name for a layer index returned by
+// explorers.WalkLayers.
+func layerName(layer int) string {
+	if layer == 0 {
+		return "upper"
+	}
+	return fmt.Sprintf("lower-%d", layer)
+}
+
+// exportPath returns the destination path of an exported file.
+//
+// Files deleted in the upper layer are exported within the
+// deleted-but-recoverable directory so they are not mistaken for files
+// present in the container.
+func exportPath(outputdir string, f explorers.LayerFile) string {
+	if f.Deleted {
+		return filepath.Join(outputdir, explorers.FileStatusDeleted, f.Path)
+	}
+	return filepath.Join(outputdir, f.Path)
+}
+
+// parseSince returns the start of a time window.
+//
+// The value can be a number of days (7d), weeks (2w), a Go duration (12h),
+// or a RFC3339 timestamp.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if ts, err := time.Parse(time.RFC3339, value); err == nil {
+		return ts, nil
+	}
+
+	for suffix, unit := range map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	} {
+		if strings.HasSuffix(value, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(value, suffix))
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid time window %s", value)
+			}
+			return now.Add(-time.Duration(n) * unit), nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time window %s", value)
+	}
+	return now.Add(-d), nil
+}
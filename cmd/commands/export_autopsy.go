@@ -0,0 +1,256 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Files and directories of an Autopsy bundle.
+const (
+	autopsyLogicalDir     = "logical"
+	autopsyContainersFile = "containers.csv"
+	autopsyAttributesFile = "attributes.csv"
+)
+
+var autopsyContainerFields = []string{
+	"logical_path",
+	"namespace",
+	"container_id",
+	"hostname",
+	"image",
+	"container_type",
+	"status",
+	"created_at",
+	"pod_name",
+	"pod_namespace",
+}
+
+var autopsyAttributeFields = []string{
+	"logical_path",
+	"namespace",
+	"container_id",
+	"image",
+	"container_path",
+	"layer",
+	"status",
+	"size",
+	"mode",
+	"uid",
+	"gid",
+	"mtime",
+	"atime",
+	"ctime",
+	"md5",
+	"sha256",
+}
+
+var exportAutopsy = cli.Command{
+	Name:  "autopsy",
+	Usage: "export containers as an Autopsy logical files bundle",
+	Description: `export container filesystems as logical files with derived attribute CSV
+   files for The Sleuth Kit and Autopsy.
+
+   Add the logical directory as a "Logical Files" data source in Autopsy.
+   The files are mapped back to the containers using the logical_path column
+   in containers.csv and attributes.csv.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "export only the specified container ID",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "bundle output directory",
+		},
+		cli.BoolFlag{
+			Name:  "include-deleted",
+			Usage: "export files deleted in the upper layer that are recoverable from the lower layers",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		pathsFromFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		outputdir := clictx.String("output")
+		if outputdir == "" {
+			return fmt.Errorf("output directory is required")
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Join(outputdir, autopsyLogicalDir), 0755); err != nil {
+			return fmt.Errorf("creating bundle directory: %w", err)
+		}
+
+		ctrfile, err := os.Create(filepath.Join(outputdir, autopsyContainersFile))
+		if err != nil {
+			return err
+		}
+		defer ctrfile.Close()
+		ctrcsv := newCSVWriter(ctrfile)
+		defer ctrcsv.Flush()
+
+		attrfile, err := os.Create(filepath.Join(outputdir, autopsyAttributesFile))
+		if err != nil {
+			return err
+		}
+		defer attrfile.Close()
+		attrcsv := newCSVWriter(attrfile)
+		defer attrcsv.Flush()
+
+		ctrcsv.Write(autopsyContainerFields)
+		attrcsv.Write(autopsyAttributeFields)
+
+		walk := explorers.WalkLayers
+		if clictx.Bool("include-deleted") {
+			walk = explorers.WalkLayersWithDeleted
+		}
+		scope, err := pathScope(clictx)
+		if err != nil {
+			return err
+		}
+
+		namer := explorers.NewMountNamer()
+		exported := 0
+
+		for _, ctr := range ctrs {
+			if id := clictx.String("id"); id != "" && ctr.ID != id {
+				continue
+			}
+			if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+
+			layers, err := containerLayers(ctx, exp, ctr)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("skipping container: ", err)
+				continue
+			}
+
+			dirname := namer.Name(ctr)
+			ctrdir := filepath.Join(outputdir, autopsyLogicalDir, dirname)
+			log.WithFields(log.Fields{
+				"containerid": ctr.ID,
+				"directory":   ctrdir,
+			}).Info("exporting container files")
+
+			ctrcsv.Write([]string{
+				filepath.Join(autopsyLogicalDir, dirname),
+				ctr.Namespace,
+				ctr.ID,
+				ctr.Hostname,
+				ctr.Image,
+				ctr.ContainerType,
+				ctr.Status,
+				ctr.CreatedAt.UTC().Format(time.RFC3339),
+				ctr.Labels[explorers.LabelPodName],
+				ctr.Labels[explorers.LabelPodNamespace],
+			})
+
+			err = walk(layers, scope.Filter(func(f explorers.LayerFile) error {
+				if !f.Info.Mode().IsRegular() {
+					return nil
+				}
+
+				dst := exportPath(ctrdir, f)
+				if err := explorers.CopyFile(f.LayerPath, dst, f.Info); err != nil {
+					log.WithFields(log.Fields{
+						"path":  f.LayerPath,
+						"error": err,
+					}).Warn("skipping file export")
+					return nil
+				}
+
+				_, md5sum, sha256sum, err := hashFile(dst)
+				if err != nil {
+					log.WithField("path", dst).Warn("hashing file: ", err)
+				}
+
+				logicalpath, err := filepath.Rel(filepath.Join(outputdir, autopsyLogicalDir), dst)
+				if err != nil {
+					return err
+				}
+
+				stat := explorers.StatInfo(f.Info)
+				attrcsv.Write([]string{
+					filepath.Join(autopsyLogicalDir, logicalpath),
+					ctr.Namespace,
+					ctr.ID,
+					ctr.Image,
+					f.Path,
+					layerName(f.Layer),
+					f.Status(),
+					fmt.Sprint(f.Info.Size()),
+					unixMode(f.Info.Mode()),
+					fmt.Sprint(stat.UID),
+					fmt.Sprint(stat.GID),
+					f.Info.ModTime().UTC().Format(time.RFC3339Nano),
+					stat.Atime.UTC().Format(time.RFC3339Nano),
+					stat.Ctime.UTC().Format(time.RFC3339Nano),
+					md5sum,
+					sha256sum,
+				})
+				return nil
+			}))
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("exporting container files: ", err)
+				continue
+			}
+			exported++
+		}
+
+		if exported == 0 {
+			return fmt.Errorf("no container exported")
+		}
+		return nil
+	},
+}
+
+// unixMode returns the permission bits and the setuid, setgid, and sticky
+// bits of a file mode as an octal Unix mode i.e. 04755.
+func unixMode(mode os.FileMode) string {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return fmt.Sprintf("%#o", m)
+}
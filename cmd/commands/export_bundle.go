@@ -0,0 +1,324 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/knowledge"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const bundleManifestFilename = "manifest.json"
+
+// bundleManifest describes the content of an export bundle.
+type bundleManifest struct {
+	CreatedAt  time.Time         `json:"created_at"`
+	Selector   map[string]string `json:"selector"`
+	Containers []bundleContainer `json:"containers"`
+	Pods       []bundlePod       `json:"pods,omitempty"`
+}
+
+// bundleContainer describes a container in an export bundle.
+type bundleContainer struct {
+	Directory    string `json:"directory"`
+	Namespace    string `json:"namespace"`
+	ContainerID  string `json:"container_id"`
+	Hostname     string `json:"hostname,omitempty"`
+	Image        string `json:"image,omitempty"`
+	PodUID       string `json:"pod_uid,omitempty"`
+	PodName      string `json:"pod_name,omitempty"`
+	PodNamespace string `json:"pod_namespace,omitempty"`
+	Spec         bool   `json:"spec"`
+	UpperLayer   bool   `json:"upper_layer"`
+	Log          bool   `json:"log"`
+
+	// VolatileMounts lists the mounts that existed only in memory and are
+	// not part of the bundle.
+	VolatileMounts []explorers.VolatileMount `json:"volatile_mounts,omitempty"`
+}
+
+// bundlePod describes the kubelet data of a pod in an export bundle.
+type bundlePod struct {
+	UID     string   `json:"uid"`
+	Logs    []string `json:"logs,omitempty"`
+	Kubelet string   `json:"kubelet,omitempty"`
+	Tokens  []string `json:"tokens,omitempty"`
+}
+
+var exportPod = cli.Command{
+	Name:        "pod",
+	Usage:       "export a pod bundle",
+	Description: "export metadata, specs, logs, upper layers, volumes, and tokens of all containers in a pod to a bundle directory",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "pod-uid",
+			Usage: "Kubernetes pod UID",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "bundle output directory",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		poduid := clictx.String("pod-uid")
+		if poduid == "" {
+			return fmt.Errorf("pod uid is required")
+		}
+
+		selector := map[string]string{
+			"pod_uid": poduid,
+		}
+		return exportBundle(clictx, selector, func(ctr explorers.Container) bool {
+			return ctr.Labels[explorers.LabelPodUID] == poduid
+		})
+	},
+}
+
+var exportNamespace = cli.Command{
+	Name:        "namespace",
+	Usage:       "export a namespace bundle",
+	Description: "export metadata, specs, logs, upper layers, volumes, and tokens of all containers in a containerd or Kubernetes namespace to a bundle directory",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "containerd namespace",
+		},
+		cli.StringFlag{
+			Name:  "pod-namespace",
+			Usage: "Kubernetes pod namespace",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "bundle output directory",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		name := clictx.String("name")
+		podnamespace := clictx.String("pod-namespace")
+		if name == "" && podnamespace == "" {
+			return fmt.Errorf("namespace name or pod namespace is required")
+		}
+
+		selector := make(map[string]string)
+		if name != "" {
+			selector["namespace"] = name
+		}
+		if podnamespace != "" {
+			selector["pod_namespace"] = podnamespace
+		}
+		return exportBundle(clictx, selector, func(ctr explorers.Container) bool {
+			if name != "" && ctr.Namespace != name {
+				return false
+			}
+			if podnamespace != "" && ctr.Labels[explorers.LabelPodNamespace] != podnamespace {
+				return false
+			}
+			return true
+		})
+	},
+}
+
+// exportBundle exports the containers matching the filter to a bundle
+// directory.
+func exportBundle(clictx *cli.Context, selector map[string]string, filter func(explorers.Container) bool) error {
+	outputdir := clictx.String("output")
+	if outputdir == "" {
+		return fmt.Errorf("output directory is required")
+	}
+
+	ctx, exp, cancel, err := explorerEnvironment(clictx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err != nil {
+		return err
+	}
+
+	manifest := bundleManifest{
+		CreatedAt: time.Now().UTC(),
+		Selector:  selector,
+	}
+
+	namer := explorers.NewMountNamer()
+	poduids := make(map[string]bool)
+
+	for _, ctr := range ctrs {
+		if !filter(ctr) {
+			continue
+		}
+
+		dirname := namer.Name(ctr)
+		ctrdir := filepath.Join(outputdir, "containers", dirname)
+		if err := os.MkdirAll(ctrdir, 0755); err != nil {
+			return fmt.Errorf("creating bundle directory %s: %w", ctrdir, err)
+		}
+
+		nsctx := namespaces.WithNamespace(ctx, ctr.Namespace)
+		manifest.Containers = append(manifest.Containers, exportBundleContainer(nsctx, exp, ctr, dirname, ctrdir))
+
+		if uid := ctr.Labels[explorers.LabelPodUID]; uid != "" {
+			poduids[uid] = true
+		}
+	}
+
+	if len(manifest.Containers) == 0 {
+		return fmt.Errorf("no container matched %v", selector)
+	}
+
+	imageroot := clictx.GlobalString("image-root")
+	pack := selectedPack(clictx)
+	for uid := range poduids {
+		manifest.Pods = append(manifest.Pods, exportBundlePod(imageroot, pack, uid, filepath.Join(outputdir, "pods", uid)))
+	}
+
+	if err := writeJSONFile(filepath.Join(outputdir, bundleManifestFilename), manifest); err != nil {
+		return err
+	}
+
+	fmt.Printf("exported %d containers and %d pods to %s\n", len(manifest.Containers), len(manifest.Pods), outputdir)
+	return nil
+}
+
+// exportBundleContainer exports the container metadata, spec, upper layer,
+// and docker log to ctrdir.
+func exportBundleContainer(ctx context.Context, exp explorers.ContainerExplorer, ctr explorers.Container, dirname string, ctrdir string) bundleContainer {
+	bc := bundleContainer{
+		Directory:    filepath.Join("containers", dirname),
+		Namespace:    ctr.Namespace,
+		ContainerID:  ctr.ID,
+		Hostname:     ctr.Hostname,
+		Image:        ctr.Image,
+		PodUID:       ctr.Labels[explorers.LabelPodUID],
+		PodName:      ctr.Labels[explorers.LabelPodName],
+		PodNamespace: ctr.Labels[explorers.LabelPodNamespace],
+
+		VolatileMounts: ctr.VolatileMounts,
+	}
+
+	if err := writeJSONFile(filepath.Join(ctrdir, "container.json"), ctr); err != nil {
+		log.WithField("containerid", ctr.ID).Warn("writing container metadata: ", err)
+	}
+
+	spec, err := exp.InfoContainer(ctx, ctr.ID, true)
+	if err != nil {
+		log.WithField("containerid", ctr.ID).Warn("getting container spec: ", err)
+	} else if spec != nil {
+		if err := writeJSONFile(filepath.Join(ctrdir, "spec.json"), spec); err != nil {
+			log.WithField("containerid", ctr.ID).Warn("writing container spec: ", err)
+		} else {
+			bc.Spec = true
+		}
+	}
+
+	upperdir, _, err := exp.ContainerLayers(ctx, ctr.ID)
+	if err != nil {
+		log.WithField("containerid", ctr.ID).Warn("getting container layers: ", err)
+	} else if explorers.PathExists(upperdir, false) {
+		if err := explorers.CopyDir(upperdir, filepath.Join(ctrdir, "upper")); err != nil {
+			log.WithField("containerid", ctr.ID).Warn("copying upper layer: ", err)
+		} else {
+			bc.UpperLayer = true
+		}
+	}
+
+	if ctr.LogPath != "" && explorers.PathExists(ctr.LogPath, true) {
+		info, err := os.Stat(ctr.LogPath)
+		if err == nil {
+			err = explorers.CopyFile(ctr.LogPath, filepath.Join(ctrdir, "logs", filepath.Base(ctr.LogPath)), info)
+		}
+		if err != nil {
+			log.WithField("containerid", ctr.ID).Warn("copying container log: ", err)
+		} else {
+			bc.Log = true
+		}
+	}
+
+	return bc
+}
+
+// exportBundlePod exports kubelet pod logs, volumes, and service account
+// tokens to poddir.
+//
+// Kubelet stores pod logs in /var/log/pods/<namespace>_<name>_<uid> and pod
+// volumes in /var/lib/kubelet/pods/<uid>. The directories of a distribution
+// are read from the knowledge pack.
+func exportBundlePod(imageroot string, p knowledge.Pack, uid string, poddir string) bundlePod {
+	bp := bundlePod{
+		UID: uid,
+	}
+
+	if imageroot == "" {
+		log.WithField("poduid", uid).Warn("image-root is empty. Skipping kubelet pod data")
+		return bp
+	}
+
+	logdirs, _ := filepath.Glob(filepath.Join(imageroot, p.PodLogs(), fmt.Sprintf("*_%s", uid)))
+	for _, logdir := range logdirs {
+		dst := filepath.Join(poddir, "logs", filepath.Base(logdir))
+		if err := explorers.CopyDir(logdir, dst); err != nil {
+			log.WithField("poduid", uid).Warn("copying pod logs: ", err)
+			continue
+		}
+		bp.Logs = append(bp.Logs, filepath.Join("pods", uid, "logs", filepath.Base(logdir)))
+	}
+
+	kubeletdir := filepath.Join(imageroot, p.Kubelet(), "pods", uid)
+	if !explorers.PathExists(kubeletdir, false) {
+		return bp
+	}
+
+	if err := explorers.CopyDir(kubeletdir, filepath.Join(poddir, "kubelet")); err != nil {
+		log.WithField("poduid", uid).Warn("copying kubelet pod directory: ", err)
+		return bp
+	}
+	bp.Kubelet = filepath.Join("pods", uid, "kubelet")
+
+	// Service account tokens are stored in projected or secret volumes.
+	tokens, _ := filepath.Glob(filepath.Join(poddir, "kubelet", "volumes", "kubernetes.io~*", "*", "token"))
+	for _, token := range tokens {
+		rel, err := filepath.Rel(filepath.Dir(filepath.Dir(poddir)), token)
+		if err == nil {
+			bp.Tokens = append(bp.Tokens, rel)
+		}
+	}
+
+	return bp
+}
+
+// writeJSONFile writes v as indented JSON to path.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return fmt.Errorf("marshalling %s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
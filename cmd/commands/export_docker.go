@@ -0,0 +1,306 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/archive"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// dockerArchiveExport is the result of export docker-archive.
+type dockerArchiveExport struct {
+	Image    string   `json:"image"`
+	Output   string   `json:"output"`
+	RepoTags []string `json:"repo_tags,omitempty"`
+	Config   string   `json:"config"` // configuration digest i.e. the image ID after docker load
+	Layers   int      `json:"layers"`
+	Rebuilt  int      `json:"rebuilt"` // layers rebuilt from the unpacked layer directories
+}
+
+var exportDockerArchive = cli.Command{
+	Name:      "docker-archive",
+	Usage:     "export an image to a docker save archive",
+	ArgsUsage: "IMAGE TAR",
+	Description: `export the configuration and layers of an image to a tar archive in the
+   docker save format, so the captured image can be run in an isolated lab
+   using docker load or podman load.
+
+   The layers are read from the layer blobs of the content store. The blobs
+   are decompressed and verified against the diff IDs of the image
+   configuration, so the loaded image has the same image ID.
+
+   The layer blobs are not kept by docker, CRI-O, and podman, and may have
+   been discarded by containerd after they were unpacked. Use
+   --rebuild-layers to rebuild the missing layers from the unpacked layer
+   directories. The rebuilt layers have different diff IDs, and the diff
+   IDs of the configuration are updated, so the loaded image has a
+   different image ID.
+
+   The image is tagged with the image name unless --tag is specified. The
+   archive is compressed when the name ends with .tar.gz, .tgz, or
+   .tar.zst. Use - to write to stdout.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "tag",
+			Usage: "repository and tag of the loaded image i.e. evidence/nginx:case-42",
+		},
+		cli.BoolFlag{
+			Name:  "rebuild-layers",
+			Usage: "rebuild the missing layer blobs from the unpacked layer directories",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() != 2 {
+			return fmt.Errorf("image name and tar archive are required")
+		}
+		name := clictx.Args().Get(0)
+		output := clictx.Args().Get(1)
+
+		compress, istar := tarOutput(output)
+		if !istar {
+			compress = archive.None
+		}
+		if output == "-" {
+			// The log messages must not be mixed with the archive.
+			log.SetOutput(os.Stderr)
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctx = namespaces.WithNamespace(ctx, clictx.GlobalString("namespace"))
+		detail, err := exp.InspectImage(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		tag := clictx.String("tag")
+		if tag == "" {
+			tag = detail.Name
+		}
+		result := dockerArchiveExport{
+			Image:  detail.Name,
+			Output: output,
+			Layers: len(detail.Layers),
+		}
+		if repotag, ok := explorers.DockerRepoTag(tag); ok {
+			result.RepoTags = []string{repotag}
+		} else {
+			log.WithField("image", tag).Warn("image name has no tag. The loaded image is untagged. Use --tag")
+		}
+
+		config, err := readImageConfig(detail)
+		if err != nil {
+			return err
+		}
+
+		tmpdir, err := os.MkdirTemp("", "container-explorer-docker-archive-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpdir)
+
+		var (
+			layers  []explorers.DockerArchiveLayer
+			diffids []digest.Digest
+		)
+		for i, layer := range detail.Layers {
+			path := filepath.Join(tmpdir, fmt.Sprintf("%d.tar", i))
+			diffid, err := uncompressLayerBlob(layer, path)
+			if err != nil && layer.BlobPath != "" {
+				return err
+			}
+			if err != nil {
+				if !clictx.Bool("rebuild-layers") || layer.Dir == "" {
+					return fmt.Errorf("layer %s of image %s: %w. Use --rebuild-layers to rebuild the layer from the unpacked layer directory", layer.DiffID, detail.Name, err)
+				}
+				if diffid, err = rebuildLayer(layer.Dir, path); err != nil {
+					return fmt.Errorf("rebuilding layer %s: %w", layer.DiffID, err)
+				}
+				if diffid.String() != layer.DiffID {
+					result.Rebuilt++
+				}
+				log.WithFields(log.Fields{
+					"diffid":  layer.DiffID,
+					"rebuilt": diffid,
+					"dir":     layer.Dir,
+				}).Debug("rebuilt layer from unpacked layer directory")
+			}
+			layers = append(layers, explorers.DockerArchiveLayer{Path: path, DiffID: diffid})
+			diffids = append(diffids, diffid)
+		}
+
+		if result.Rebuilt > 0 {
+			if config, err = setConfigDiffIDs(config, diffids); err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"image":   detail.Name,
+				"rebuilt": result.Rebuilt,
+			}).Warn("layers rebuilt from the unpacked layer directories. The loaded image has a different image ID")
+		}
+		result.Config = digest.FromBytes(config).String()
+
+		write := func(w io.Writer) error {
+			return explorers.WriteDockerArchive(w, config, result.RepoTags, layers)
+		}
+		if output == "-" {
+			return writeCompressed(os.Stdout, compress, write)
+		}
+
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		if err := writeCompressed(f, compress, write); err != nil {
+			f.Close()
+			return fmt.Errorf("exporting image: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		if isStructuredOutput(clictx.GlobalString("output")) {
+			printObject(clictx.GlobalString("output"), result)
+			return nil
+		}
+
+		fmt.Printf("exported image %s (%s) to %s: %d layers, %d rebuilt\n",
+			detail.Name, result.Config, output, result.Layers, result.Rebuilt)
+		return nil
+	},
+}
+
+// readImageConfig returns the image configuration verified against its
+// digest.
+func readImageConfig(detail explorers.ImageDetail) ([]byte, error) {
+	if detail.ConfigPath == "" {
+		return nil, fmt.Errorf("configuration %s of image %s not found", detail.Config, detail.Name)
+	}
+	data, err := os.ReadFile(detail.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if d := digest.FromBytes(data); d.String() != detail.Config {
+		return nil, fmt.Errorf("configuration %s does not match its digest %s", detail.ConfigPath, detail.Config)
+	}
+	return data, nil
+}
+
+// uncompressLayerBlob writes the uncompressed layer blob to path and returns
+// its digest.
+//
+// The blob is verified against the layer digest and the uncompressed blob
+// against the diff ID.
+func uncompressLayerBlob(layer explorers.ImageLayer, path string) (digest.Digest, error) {
+	if layer.BlobPath == "" {
+		return "", fmt.Errorf("layer blob not found")
+	}
+	in, err := os.Open(layer.BlobPath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	blobdigester := digest.Canonical.Digester()
+	r, err := archive.NewReader(io.TeeReader(in, blobdigester.Hash()))
+	if err != nil {
+		return "", fmt.Errorf("reading layer blob %s: %w", layer.BlobPath, err)
+	}
+	defer r.Close()
+
+	diffid, err := writeDigestedFile(path, r)
+	if err != nil {
+		return "", fmt.Errorf("uncompressing layer blob %s: %w", layer.BlobPath, err)
+	}
+	// Read the trailing data of the compressed stream.
+	if _, err := io.Copy(io.Discard, io.TeeReader(in, blobdigester.Hash())); err != nil {
+		return "", err
+	}
+
+	if layer.Digest != "" && blobdigester.Digest().String() != layer.Digest {
+		return "", fmt.Errorf("layer blob %s does not match its digest %s", layer.BlobPath, layer.Digest)
+	}
+	if diffid.String() != layer.DiffID {
+		return "", fmt.Errorf("uncompressed layer blob %s does not match its diff id %s", layer.BlobPath, layer.DiffID)
+	}
+	return diffid, nil
+}
+
+// rebuildLayer writes a layer tar file of an unpacked layer directory to path
+// and returns its digest.
+func rebuildLayer(dir string, path string) (digest.Digest, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(explorers.WriteLayerDiffTar(pw, dir))
+	}()
+	defer pr.Close()
+	return writeDigestedFile(path, pr)
+}
+
+// writeDigestedFile writes the content of r to path and returns its digest.
+func writeDigestedFile(path string, r io.Reader) (digest.Digest, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	digester := digest.Canonical.Digester()
+	if _, err := io.Copy(io.MultiWriter(f, digester.Hash()), r); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return digester.Digest(), nil
+}
+
+// setConfigDiffIDs returns the image configuration with the diff IDs
+// replaced. The other fields are preserved.
+func setConfigDiffIDs(config []byte, diffids []digest.Digest) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(config, &fields); err != nil {
+		return nil, fmt.Errorf("unmarshalling image configuration: %w", err)
+	}
+
+	rootfs := struct {
+		Type    string          `json:"type"`
+		DiffIDs []digest.Digest `json:"diff_ids"`
+	}{
+		Type:    "layers",
+		DiffIDs: diffids,
+	}
+	data, err := json.Marshal(rootfs)
+	if err != nil {
+		return nil, err
+	}
+	fields["rootfs"] = data
+	return json.Marshal(fields)
+}
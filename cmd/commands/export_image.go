@@ -0,0 +1,295 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Forensic image formats supported by export image.
+const (
+	imageFormatRaw  = "raw-dd"
+	imageFormatEWF  = "ewf"
+	imageFormatAFF4 = "aff4"
+)
+
+// imageMetadata describes an exported forensic image. The metadata is
+// written next to the image as <output>.json.
+type imageMetadata struct {
+	Format      string    `json:"format"`
+	Path        string    `json:"path"`
+	CreatedAt   time.Time `json:"created_at"`
+	Namespace   string    `json:"namespace"`
+	ContainerID string    `json:"container_id"`
+	Hostname    string    `json:"hostname,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	Layers      []string  `json:"layers"`
+	CaseNumber  string    `json:"case_number,omitempty"`
+	Examiner    string    `json:"examiner,omitempty"`
+	RawSize     int64     `json:"raw_size"`
+	RawMD5      string    `json:"raw_md5"`
+	RawSHA256   string    `json:"raw_sha256"`
+}
+
+var exportImage = cli.Command{
+	Name:  "image",
+	Usage: "export a container filesystem as a forensic image",
+	Description: `export the reconstructed container filesystem as a mountable ext4 filesystem
+   image in raw-dd, ewf, or aff4 format.
+
+   The ext4 filesystem is created using mkfs.ext4. The ewf format requires
+   ewfacquire (libewf) and the aff4 format requires aff4imager. The image
+   metadata and the hashes of the raw image are written to <output>.json.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "container ID",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "forensic image format i.e. raw-dd, ewf, or aff4",
+			Value: imageFormatRaw,
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "output image path. The ewf format appends the .E01 extension",
+		},
+		cli.StringFlag{
+			Name:  "case-number",
+			Usage: "case number embedded in the image metadata",
+		},
+		cli.StringFlag{
+			Name:  "examiner",
+			Usage: "examiner name embedded in the image metadata",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		containerid := clictx.String("id")
+		if containerid == "" {
+			return fmt.Errorf("container id is required")
+		}
+
+		output := clictx.String("output")
+		if output == "" {
+			return fmt.Errorf("output path is required")
+		}
+
+		format := strings.ToLower(clictx.String("format"))
+		var tools []string
+		switch format {
+		case imageFormatRaw:
+			tools = []string{"mkfs.ext4"}
+		case imageFormatEWF:
+			tools = []string{"mkfs.ext4", "ewfacquire"}
+		case imageFormatAFF4:
+			tools = []string{"mkfs.ext4", "aff4imager"}
+		default:
+			return fmt.Errorf("unsupported image format %s", format)
+		}
+
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("exporting a forensic image is only supported on Linux")
+		}
+		for _, tool := range tools {
+			if _, err := exec.LookPath(tool); err != nil {
+				return fmt.Errorf("%s is required for %s format: %w", tool, format, err)
+			}
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		namespace := clictx.GlobalString("namespace")
+		ctx = namespaces.WithNamespace(ctx, namespace)
+
+		upperdir, lowerdirs, err := exp.ContainerLayers(ctx, containerid)
+		if err != nil {
+			return err
+		}
+		layers := append([]string{upperdir}, lowerdirs...)
+
+		metadata := imageMetadata{
+			Format:      format,
+			Path:        output,
+			CreatedAt:   time.Now().UTC(),
+			Namespace:   namespace,
+			ContainerID: containerid,
+			Layers:      layers,
+			CaseNumber:  clictx.String("case-number"),
+			Examiner:    clictx.String("examiner"),
+		}
+		if ctrs, err := exp.ListContainers(ctx); err == nil {
+			for _, ctr := range ctrs {
+				if ctr.Namespace == namespace && ctr.ID == containerid {
+					metadata.Hostname = ctr.Hostname
+					metadata.Image = ctr.Image
+					break
+				}
+			}
+		}
+
+		stagingdir, err := os.MkdirTemp("", "container-explorer-image-")
+		if err != nil {
+			return fmt.Errorf("creating staging directory: %w", err)
+		}
+		defer os.RemoveAll(stagingdir)
+
+		log.WithField("stagingdir", stagingdir).Info("reconstructing container filesystem")
+		rootfs := filepath.Join(stagingdir, "rootfs")
+		if err := explorers.FlattenLayers(layers, rootfs); err != nil {
+			return fmt.Errorf("reconstructing container filesystem: %w", err)
+		}
+
+		rawfile := output
+		if format != imageFormatRaw {
+			rawfile = filepath.Join(stagingdir, "rootfs.raw")
+		}
+		if err := createExt4Image(rootfs, rawfile, containerid); err != nil {
+			return err
+		}
+
+		metadata.RawSize, metadata.RawMD5, metadata.RawSHA256, err = hashFile(rawfile)
+		if err != nil {
+			return fmt.Errorf("hashing raw image: %w", err)
+		}
+
+		switch format {
+		case imageFormatEWF:
+			description := fmt.Sprintf("container %s/%s image %s", namespace, containerid, metadata.Image)
+			args := []string{
+				"-u",
+				"-t", output,
+				"-f", "encase6",
+				"-d", "sha256",
+				"-C", metadata.CaseNumber,
+				"-D", description,
+				"-e", metadata.Examiner,
+				"-E", containerid,
+				"-N", fmt.Sprintf("raw image sha256 %s", metadata.RawSHA256),
+				rawfile,
+			}
+			if err := runImageTool("ewfacquire", args...); err != nil {
+				return err
+			}
+			metadata.Path = output + ".E01"
+		case imageFormatAFF4:
+			if err := runImageTool("aff4imager", "--input", rawfile, "--output", output); err != nil {
+				return err
+			}
+		}
+
+		if err := writeJSONFile(output+".json", metadata); err != nil {
+			return fmt.Errorf("writing image metadata: %w", err)
+		}
+
+		log.WithFields(log.Fields{
+			"format": format,
+			"path":   metadata.Path,
+			"sha256": metadata.RawSHA256,
+		}).Info("exported forensic image")
+		return nil
+	},
+}
+
+// createExt4Image creates an ext4 filesystem image at path populated with the
+// content of dir.
+//
+// The image size is the size of the content with 25% headroom for the
+// filesystem metadata plus 64 MiB.
+func createExt4Image(dir string, path string, label string) error {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Account a filesystem block for each inode
+		size += info.Size() + 4096
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("computing image size: %w", err)
+	}
+
+	const mib = 1024 * 1024
+	size = ((size+size/4)/mib + 64) * mib
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("creating image file: %w", err)
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return fmt.Errorf("allocating image file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// Use the short container ID as the ext4 volume label is limited to 16
+	// characters
+	if len(label) > 12 {
+		label = label[:12]
+	}
+	return runImageTool("mkfs.ext4", "-q", "-F", "-L", label, "-d", dir, path)
+}
+
+// runImageTool runs an external imaging tool and returns the tool output on
+// failure.
+func runImageTool(name string, args ...string) error {
+	log.Debug("running ", name, " ", args)
+
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running %s: %v %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// hashFile returns the size, MD5, and SHA256 of a file.
+func hashFile(path string) (int64, string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer f.Close()
+
+	md5hash := md5.New()
+	sha256hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5hash, sha256hash), f)
+	if err != nil {
+		return 0, "", "", err
+	}
+	return size, hex.EncodeToString(md5hash.Sum(nil)), hex.EncodeToString(sha256hash.Sum(nil)), nil
+}
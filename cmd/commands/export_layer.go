@@ -0,0 +1,253 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/archive"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// layerExport is the result of export layer.
+type layerExport struct {
+	Digest       string   `json:"digest"`            // layer blob digest
+	DiffID       string   `json:"diff_id,omitempty"` // uncompressed layer digest
+	Blob         string   `json:"blob"`
+	Output       string   `json:"output"`
+	Size         int64    `json:"size"`
+	Decompressed bool     `json:"decompressed"`
+	Images       []string `json:"images,omitempty"` // images referencing the layer
+}
+
+var exportLayer = cli.Command{
+	Name:      "layer",
+	Usage:     "export a layer blob from the content store",
+	ArgsUsage: "DIGEST PATH",
+	Description: `export a single layer blob from the containerd content store for
+   targeted layer analysis.
+
+   The digest is the digest of the layer blob or the diff ID of the layer
+   i.e. the digest of the uncompressed layer in the image configuration.
+   The layer blobs not referenced by any image are exported too.
+
+   The blob is verified against its digest while it is copied, and the
+   output file is only created when the blob is verified. Use --decompress
+   to export the uncompressed layer tar file. The uncompressed layer is
+   verified against the diff ID when the layer is referenced by an image.
+
+   Use - to write to stdout. The verification error is then reported after
+   the blob is written.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "decompress",
+			Usage: "decompress the layer blob i.e. gzip or zstd",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() != 2 {
+			return fmt.Errorf("layer digest and output path are required")
+		}
+		dgst, err := parseLayerDigest(clictx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		output := clictx.Args().Get(1)
+		if output == "-" {
+			// The log messages must not be mixed with the layer.
+			log.SetOutput(os.Stderr)
+		} else if explorers.PathExists(output, true) {
+			return fmt.Errorf("output %s already exists", output)
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctx = namespaces.WithNamespace(ctx, clictx.GlobalString("namespace"))
+		result, err := findLayerBlob(ctx, exp, dgst)
+		if err != nil {
+			return err
+		}
+		result.Output = output
+		result.Decompressed = clictx.Bool("decompress")
+
+		if output == "-" {
+			result.Size, err = copyLayerBlob(os.Stdout, result)
+			return err
+		}
+
+		// The layer is written to a temporary file renamed after the layer
+		// is verified, so the output never holds an unverified layer.
+		f, err := os.CreateTemp(filepath.Dir(output), ".tmp-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+
+		if result.Size, err = copyLayerBlob(f, result); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(f.Name(), 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(f.Name(), output); err != nil {
+			return err
+		}
+
+		if isStructuredOutput(clictx.GlobalString("output")) {
+			printObject(clictx.GlobalString("output"), result)
+			return nil
+		}
+
+		fmt.Printf("exported layer %s to %s: %d bytes\n", result.Digest, output, result.Size)
+		return nil
+	},
+}
+
+// parseLayerDigest returns the digest of a layer. A hexadecimal digest
+// without algorithm is a SHA256 digest.
+func parseLayerDigest(s string) (digest.Digest, error) {
+	if !strings.Contains(s, ":") {
+		s = digest.Canonical.String() + ":" + s
+	}
+	dgst, err := digest.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid layer digest %s: %w", s, err)
+	}
+	return dgst, nil
+}
+
+// findLayerBlob returns the blob of a layer by blob digest or diff ID.
+//
+// The blob is looked up in the content store, then in the layers of the
+// images. The images referencing the layer are recorded, and the diff ID
+// is read from the image configuration.
+func findLayerBlob(ctx context.Context, exp explorers.ContainerExplorer, dgst digest.Digest) (layerExport, error) {
+	result := layerExport{
+		Digest: dgst.String(),
+	}
+	if be, ok := exp.(explorers.ImageBlobExplorer); ok {
+		if path, err := be.ContentBlob(ctx, dgst); err == nil {
+			result.Blob = path
+		}
+	}
+
+	images, err := exp.ListImages(ctx)
+	if err != nil {
+		return result, err
+	}
+	ns, _ := namespaces.Namespace(ctx)
+	for _, image := range images {
+		if ns != "" && image.Namespace != "" && image.Namespace != ns {
+			continue
+		}
+		imagectx := ctx
+		if image.Namespace != "" {
+			imagectx = namespaces.WithNamespace(ctx, image.Namespace)
+		}
+		detail, err := exp.InspectImage(imagectx, image.Name)
+		if err != nil {
+			log.WithField("image", image.Name).Debug("inspecting image: ", err)
+			continue
+		}
+		for _, layer := range detail.Layers {
+			if layer.Digest != dgst.String() && layer.DiffID != dgst.String() {
+				continue
+			}
+			result.Images = append(result.Images, image.Name)
+			if result.DiffID == "" {
+				result.DiffID = layer.DiffID
+			}
+			if result.Blob == "" && layer.BlobPath != "" {
+				result.Blob = layer.BlobPath
+				result.Digest = layer.Digest
+			}
+			break
+		}
+	}
+
+	if result.Blob == "" {
+		if len(result.Images) > 0 {
+			return result, fmt.Errorf("layer %s of image %s has no blob in the content store. Use export docker-archive --rebuild-layers to rebuild the layers from the unpacked layer directories", dgst, result.Images[0])
+		}
+		return result, fmt.Errorf("layer %s not found", dgst)
+	}
+	if result.Digest == "" {
+		result.Digest = result.DiffID
+	}
+	return result, nil
+}
+
+// copyLayerBlob writes the layer blob to w and returns the number of bytes
+// written.
+//
+// The blob is verified against its digest, and the uncompressed blob is
+// verified against the diff ID.
+func copyLayerBlob(w io.Writer, layer layerExport) (int64, error) {
+	in, err := os.Open(layer.Blob)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	blobverifier := digest.Digest(layer.Digest).Verifier()
+	r := io.TeeReader(in, blobverifier)
+
+	var n int64
+	if layer.Decompressed {
+		zr, err := archive.NewReader(r)
+		if err != nil {
+			return 0, fmt.Errorf("reading layer blob %s: %w", layer.Blob, err)
+		}
+		defer zr.Close()
+
+		diffdigester := digest.Canonical.Digester()
+		if n, err = io.Copy(io.MultiWriter(w, diffdigester.Hash()), zr); err != nil {
+			return n, fmt.Errorf("decompressing layer blob %s: %w", layer.Blob, err)
+		}
+		// Read the trailing data of the compressed stream.
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return n, err
+		}
+		if layer.DiffID != "" && diffdigester.Digest().String() != layer.DiffID {
+			return n, fmt.Errorf("decompressed layer blob %s does not match its diff id %s", layer.Blob, layer.DiffID)
+		}
+	} else if n, err = io.Copy(w, r); err != nil {
+		return n, fmt.Errorf("copying layer blob %s: %w", layer.Blob, err)
+	}
+
+	if !blobverifier.Verified() {
+		return n, fmt.Errorf("layer blob %s does not match its digest %s. The content store may have been tampered with", layer.Blob, layer.Digest)
+	}
+	return n, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// ociExport is the result of export oci-image.
+type ociExport struct {
+	Image     string                `json:"image"`
+	Target    string                `json:"target"`
+	Directory string                `json:"directory"`
+	Blobs     int                   `json:"blobs"`
+	Size      int64                 `json:"size"`
+	Missing   []explorers.ImageBlob `json:"missing,omitempty"`
+}
+
+var exportOCIImage = cli.Command{
+	Name:      "oci-image",
+	Usage:     "export an image to an OCI image layout",
+	ArgsUsage: "IMAGE DIR",
+	Description: `export the index, manifests, configuration, and layer blobs of an image
+   from the containerd content store to an OCI image layout directory, so
+   the image can be loaded in a sandbox or pushed to a registry for
+   analysis e.g. using ctr image import, skopeo, or crane.
+
+   The blobs are verified against their digests while they are copied. The
+   image is added to the index.json of the layout, so several images can be
+   exported to the same directory.
+
+   The manifests of other platforms are usually not in the content store and
+   are skipped. The layers may have been discarded after they were unpacked
+   e.g. discard_unpacked_layers of the CRI plugin. Use --allow-missing to
+   export an incomplete layout.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "allow-missing",
+			Usage: "export the image when configuration or layer blobs are missing",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() != 2 {
+			return fmt.Errorf("image name and output directory are required")
+		}
+		name := clictx.Args().Get(0)
+		outputdir := clictx.Args().Get(1)
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		be, ok := exp.(explorers.ImageBlobExplorer)
+		if !ok {
+			return fmt.Errorf("runtime %s does not keep image blobs in a content store", selectedRuntime(clictx))
+		}
+
+		ctx = namespaces.WithNamespace(ctx, clictx.GlobalString("namespace"))
+		imagename, target, blobs, err := be.ImageBlobs(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		result := ociExport{
+			Image:     imagename,
+			Target:    target.Digest.String(),
+			Directory: outputdir,
+		}
+		for _, blob := range blobs {
+			if blob.Path == "" {
+				result.Missing = append(result.Missing, blob)
+				continue
+			}
+			result.Blobs++
+			result.Size += blob.Size
+		}
+
+		for _, blob := range result.Missing {
+			log.WithFields(log.Fields{
+				"image":     imagename,
+				"digest":    blob.Digest,
+				"mediatype": blob.MediaType,
+			}).Warn("blob not in content store")
+		}
+		if len(result.Missing) > 0 && !clictx.Bool("allow-missing") {
+			return fmt.Errorf("%d blobs of image %s are missing from the content store. Use --allow-missing to export an incomplete layout", len(result.Missing), imagename)
+		}
+
+		if err := explorers.WriteOCILayout(outputdir, imagename, target, blobs); err != nil {
+			return err
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			printObject(output, result)
+			return nil
+		}
+
+		fmt.Printf("exported image %s (%s) to %s: %d blobs, %d bytes, %d missing\n",
+			imagename, result.Target, outputdir, result.Blobs, result.Size, len(result.Missing))
+		return nil
+	},
+}
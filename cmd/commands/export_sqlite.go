@@ -0,0 +1,343 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// sqliteSchema creates the normalized tables of the metadata store.
+//
+// The containers are joined to images using containers.image = images.name
+// and to snapshots using containers.snapshot_key = snapshots.key.
+const sqliteSchema = `CREATE TABLE namespaces (
+  name TEXT PRIMARY KEY
+);
+CREATE TABLE containers (
+  namespace TEXT NOT NULL REFERENCES namespaces(name),
+  id TEXT NOT NULL,
+  hostname TEXT,
+  image TEXT,
+  image_base TEXT,
+  container_type TEXT,
+  status TEXT,
+  pid INTEGER,
+  support_container INTEGER,
+  runtime TEXT,
+  snapshotter TEXT,
+  snapshot_key TEXT,
+  created_at TEXT,
+  updated_at TEXT,
+  labels TEXT,
+  PRIMARY KEY (namespace, id)
+);
+CREATE TABLE images (
+  namespace TEXT NOT NULL REFERENCES namespaces(name),
+  name TEXT NOT NULL,
+  digest TEXT,
+  media_type TEXT,
+  size INTEGER,
+  support_container_image INTEGER,
+  created_at TEXT,
+  updated_at TEXT,
+  labels TEXT,
+  PRIMARY KEY (namespace, name)
+);
+CREATE TABLE content (
+  namespace TEXT NOT NULL REFERENCES namespaces(name),
+  digest TEXT NOT NULL,
+  size INTEGER,
+  created_at TEXT,
+  updated_at TEXT,
+  labels TEXT,
+  PRIMARY KEY (namespace, digest)
+);
+CREATE TABLE snapshots (
+  namespace TEXT NOT NULL REFERENCES namespaces(name),
+  snapshotter TEXT NOT NULL,
+  key TEXT NOT NULL,
+  name TEXT,
+  parent TEXT,
+  kind TEXT,
+  overlay_path TEXT,
+  created_at TEXT,
+  updated_at TEXT,
+  labels TEXT,
+  PRIMARY KEY (namespace, snapshotter, key)
+);
+CREATE TABLE tasks (
+  namespace TEXT NOT NULL REFERENCES namespaces(name),
+  container_id TEXT NOT NULL,
+  container_type TEXT,
+  pid INTEGER,
+  status TEXT,
+  PRIMARY KEY (namespace, container_id)
+);
+CREATE INDEX containers_image ON containers(namespace, image);
+CREATE INDEX containers_snapshot ON containers(namespace, snapshotter, snapshot_key);
+CREATE INDEX snapshots_parent ON snapshots(namespace, snapshotter, parent);
+`
+
+var exportSQLite = cli.Command{
+	Name:      "sqlite",
+	Usage:     "export the metadata store to a SQLite database",
+	ArgsUsage: "<path>",
+	Description: `export namespaces, containers, images, content, snapshots, and tasks to
+   normalized SQLite tables for ad-hoc SQL queries.
+
+   The database is created using the sqlite3 command. Use --sql to write the
+   SQL statements to path instead.
+
+   Example:
+     SELECT c.id, i.digest, s.overlay_path
+     FROM containers c
+     LEFT JOIN images i ON i.namespace = c.namespace AND i.name = c.image
+     LEFT JOIN snapshots s ON s.namespace = c.namespace AND s.key = c.snapshot_key`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "sql",
+			Usage: "write SQL statements rather than a SQLite database",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() < 1 {
+			return fmt.Errorf("database path is required")
+		}
+		path := clictx.Args().First()
+
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		}
+		if !clictx.Bool("sql") {
+			if _, err := exec.LookPath("sqlite3"); err != nil {
+				return fmt.Errorf("sqlite3 is required to create the database. Use --sql to write SQL statements: %w", err)
+			}
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		sqlfile := path
+		if !clictx.Bool("sql") {
+			tmpdir, err := os.MkdirTemp("", "container-explorer-sqlite-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmpdir)
+			sqlfile = filepath.Join(tmpdir, "export.sql")
+		}
+
+		f, err := os.Create(sqlfile)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+
+		if err := writeSQLiteExport(ctx, exp, w); err != nil {
+			f.Close()
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		if clictx.Bool("sql") {
+			return nil
+		}
+
+		in, err := os.Open(sqlfile)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		cmd := exec.Command("sqlite3", "-bail", path)
+		cmd.Stdin = in
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("running sqlite3: %v %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	},
+}
+
+// writeSQLiteExport writes the schema and the metadata store as SQL
+// statements.
+func writeSQLiteExport(ctx context.Context, exp explorers.ContainerExplorer, w io.Writer) error {
+	fmt.Fprintln(w, "BEGIN TRANSACTION;")
+	fmt.Fprint(w, sqliteSchema)
+
+	nss, err := exp.ListNamespaces(ctx)
+	if err != nil {
+		return fmt.Errorf("listing namespaces: %w", err)
+	}
+	for _, ns := range nss {
+		writeSQLInsert(w, "namespaces", ns)
+	}
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err != nil {
+		return fmt.Errorf("listing containers: %w", err)
+	}
+	for _, ctr := range ctrs {
+		writeSQLInsert(w, "containers",
+			ctr.Namespace,
+			ctr.ID,
+			ctr.Hostname,
+			ctr.Image,
+			ctr.ImageBase,
+			ctr.ContainerType,
+			ctr.Status,
+			ctr.ProcessID,
+			ctr.SupportContainer,
+			ctr.Runtime.Name,
+			ctr.Snapshotter,
+			ctr.SnapshotKey,
+			ctr.CreatedAt,
+			ctr.UpdatedAt,
+			ctr.Labels,
+		)
+	}
+
+	images, err := exp.ListImages(ctx)
+	if err != nil {
+		log.Warn("listing images: ", err)
+	}
+	for _, image := range images {
+		writeSQLInsert(w, "images",
+			image.Namespace,
+			image.Name,
+			string(image.Target.Digest),
+			image.Target.MediaType,
+			image.Target.Size,
+			image.SupportContainerImage,
+			image.CreatedAt,
+			image.UpdatedAt,
+			image.Labels,
+		)
+	}
+
+	content, err := exp.ListContent(ctx)
+	if err != nil {
+		log.Warn("listing content: ", err)
+	}
+	for _, c := range content {
+		writeSQLInsert(w, "content",
+			c.Namespace,
+			string(c.Digest),
+			c.Size,
+			c.CreatedAt,
+			c.UpdatedAt,
+			c.Labels,
+		)
+	}
+
+	ss, err := exp.ListSnapshots(ctx)
+	if err != nil {
+		log.Warn("listing snapshots: ", err)
+	}
+	for _, s := range ss {
+		writeSQLInsert(w, "snapshots",
+			s.Namespace,
+			s.Snapshotter,
+			s.Key,
+			s.Name,
+			s.Parent,
+			s.Kind.String(),
+			filepath.Join(exp.SnapshotRoot(s.Snapshotter), s.OverlayPath),
+			s.CreatedAt,
+			s.UpdatedAt,
+			s.Labels,
+		)
+	}
+
+	tasks, err := exp.ListTasks(ctx)
+	if err != nil {
+		log.Warn("listing tasks: ", err)
+	}
+	for _, t := range tasks {
+		writeSQLInsert(w, "tasks",
+			t.Namespace,
+			t.Name,
+			t.ContainerType,
+			t.PID,
+			t.Status,
+		)
+	}
+
+	_, err = fmt.Fprintln(w, "COMMIT;")
+	return err
+}
+
+// writeSQLInsert writes an INSERT statement for a table row.
+func writeSQLInsert(w io.Writer, table string, values ...interface{}) {
+	literals := make([]string, len(values))
+	for i, v := range values {
+		literals[i] = sqlLiteral(v)
+	}
+	fmt.Fprintf(w, "INSERT OR REPLACE INTO %s VALUES (%s);\n", table, strings.Join(literals, ", "))
+}
+
+// sqlLiteral returns a SQL literal of a value. Timestamps are stored as
+// RFC3339 text and labels as JSON text.
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int, int64, uint64:
+		return fmt.Sprint(v)
+	case time.Time:
+		if v.IsZero() {
+			return "NULL"
+		}
+		return sqlLiteral(v.UTC().Format(time.RFC3339Nano))
+	case map[string]string:
+		if len(v) == 0 {
+			return "NULL"
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "NULL"
+		}
+		return sqlLiteral(string(b))
+	}
+	return sqlLiteral(fmt.Sprint(v))
+}
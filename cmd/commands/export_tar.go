@@ -0,0 +1,133 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/archive"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var exportTar = cli.Command{
+	Name:      "tar",
+	Usage:     "export the container filesystem to a tar archive",
+	ArgsUsage: "CONTAINER TAR",
+	Description: `export the reconstructed container filesystem to a deterministic tar
+   archive, so the filesystem can be archived as evidence or loaded on
+   another host.
+
+   The archive uses the PAX format with numeric owners. The extended
+   attributes i.e. security.selinux and security.capability are preserved,
+   and the access and change times are omitted, so the same container
+   always produces the same archive.
+
+   Use --upper-only to export the upper (writable) layer only. The overlayfs
+   whiteouts and opaque directories are translated to the .wh. files of the
+   OCI image layers, so the archive records the files deleted in the
+   container.
+
+   The archive is compressed when the name ends with .tar.gz, .tgz, or
+   .tar.zst. Use - to write to stdout.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "upper-only",
+			Usage: "export the upper (writable) layer only with the whiteouts translated",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() != 2 {
+			return fmt.Errorf("container id and tar archive are required")
+		}
+		containerid := clictx.Args().Get(0)
+		output := clictx.Args().Get(1)
+
+		compress, istar := tarOutput(output)
+		if !istar {
+			compress = archive.None
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctx = namespaces.WithNamespace(ctx, clictx.GlobalString("namespace"))
+
+		upperdir, lowerdirs, err := exp.ContainerLayers(ctx, containerid)
+		if err != nil {
+			return err
+		}
+
+		write := func(w io.Writer) error {
+			return explorers.WriteEvidenceTar(w, append([]string{upperdir}, lowerdirs...))
+		}
+		if clictx.Bool("upper-only") {
+			write = func(w io.Writer) error {
+				return explorers.WriteLayerDiffTar(w, upperdir)
+			}
+		}
+
+		if output == "-" {
+			return writeCompressed(os.Stdout, compress, write)
+		}
+
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		if err := writeCompressed(io.MultiWriter(f, h), compress, write); err != nil {
+			f.Close()
+			return fmt.Errorf("exporting container filesystem: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		digest := fmt.Sprintf("sha256:%x", h.Sum(nil))
+		log.WithFields(log.Fields{
+			"containerid": containerid,
+			"output":      output,
+			"digest":      digest,
+		}).Debug("exported container filesystem")
+
+		fmt.Printf("exported container %s to %s %s\n", containerid, output, digest)
+		return nil
+	},
+}
+
+// writeCompressed calls write with a writer compressing to out using the
+// compression format.
+func writeCompressed(out io.Writer, compress string, write func(io.Writer) error) error {
+	w, err := archive.NewWriter(out, compress)
+	if err != nil {
+		return err
+	}
+	if err := write(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
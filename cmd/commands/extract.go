@@ -0,0 +1,418 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/archive"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// tarSuffixes maps the file name suffixes of a tar output to the
+// compression format.
+var tarSuffixes = []struct {
+	suffix   string
+	compress string
+}{
+	{".tar", archive.None},
+	{".tar.gz", archive.Gzip},
+	{".tgz", archive.Gzip},
+	{".tar.zst", archive.Zstd},
+}
+
+var ExtractCommand = cli.Command{
+	Name:  "extract",
+	Usage: "extract container data without mounting",
+	Subcommands: cli.Commands{
+		extractRootfs,
+		extractFiles,
+	},
+}
+
+// extractedFile describes a file written by extract files.
+type extractedFile struct {
+	Namespace   string    `json:"namespace"`
+	ContainerID string    `json:"container_id"`
+	Path        string    `json:"path"`
+	Output      string    `json:"output"`
+	Layer       string    `json:"layer"`
+	Mode        string    `json:"mode"`
+	Size        int64     `json:"size"`
+	ModifiedAt  time.Time `json:"modified_at"`
+	Target      string    `json:"target,omitempty"` // symbolic link target
+	SHA256      string    `json:"sha256,omitempty"`
+}
+
+var extractRootfs = cli.Command{
+	Name:      "rootfs",
+	Usage:     "extract the flattened filesystem of a container",
+	ArgsUsage: "CONTAINER DIR|TAR",
+	Description: `write a flattened copy of the container filesystem to a directory or a
+   tar archive without mounting.
+
+   The overlay upper directory and lower directories are merged in user
+   space. The whiteouts and opaque directories hide the files deleted in the
+   upper layers as overlayfs does. Use in environments where mounting is
+   prohibited or unavailable.
+
+   The output is a tar archive when the name ends with .tar, .tar.gz, .tgz,
+   or .tar.zst, or is - for stdout. Otherwise the output is a directory that
+   must not exist or be empty. The device files and fifos are only written
+   to a tar archive.`,
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() != 2 {
+			return fmt.Errorf("container id and output are required")
+		}
+		containerid := clictx.Args().Get(0)
+		output := clictx.Args().Get(1)
+
+		compress, istar := tarOutput(output)
+		if !istar {
+			if entries, err := os.ReadDir(output); err == nil && len(entries) > 0 {
+				return fmt.Errorf("output directory %s is not empty", output)
+			}
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctx = namespaces.WithNamespace(ctx, clictx.GlobalString("namespace"))
+
+		upperdir, lowerdirs, err := exp.ContainerLayers(ctx, containerid)
+		if err != nil {
+			return err
+		}
+		layers := append([]string{upperdir}, lowerdirs...)
+
+		log.WithFields(log.Fields{
+			"containerid": containerid,
+			"layers":      layers,
+			"output":      output,
+		}).Debug("extracting container filesystem")
+
+		if !istar {
+			if err := explorers.FlattenLayers(layers, output); err != nil {
+				return fmt.Errorf("extracting container filesystem: %w", err)
+			}
+		} else if err := writeRootfsTar(layers, output, compress); err != nil {
+			return fmt.Errorf("extracting container filesystem: %w", err)
+		}
+
+		if output != "-" {
+			fmt.Printf("extracted container %s to %s\n", containerid, output)
+		}
+		return nil
+	},
+}
+
+// tarOutput returns the compression format of a tar output and true if the
+// output is a tar archive.
+func tarOutput(output string) (string, bool) {
+	if output == "-" {
+		return archive.None, true
+	}
+	name := strings.ToLower(output)
+	for _, t := range tarSuffixes {
+		if strings.HasSuffix(name, t.suffix) {
+			return t.compress, true
+		}
+	}
+	return "", false
+}
+
+// writeRootfsTar writes the merged view of the layers to a tar archive file
+// or stdout.
+func writeRootfsTar(layers []string, output string, compress string) error {
+	write := func(w io.Writer) error {
+		return explorers.FlattenLayersTar(layers, w)
+	}
+	if output == "-" {
+		return writeCompressed(os.Stdout, compress, write)
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err := writeCompressed(f, compress, write); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var extractFiles = cli.Command{
+	Name:      "files",
+	Usage:     "extract the files matching path patterns from containers",
+	ArgsUsage: "[CONTAINER] DIR|TAR",
+	Description: `copy the files matching --path from the merged container filesystem to a
+   directory or a tar archive without mounting or extracting the container.
+
+   A path is a container path, a directory ending with /, or a path.Match
+   pattern where a ** element matches zero or more directories i.e.
+   '/etc/**' or '/var/log/**/*.log'. Only the directories that may contain
+   a match are read. Regular files and symbolic links are extracted with
+   their parent directories, and the SHA256 digest of each regular file is
+   printed.
+
+   With --all, the files of all containers are extracted to a directory per
+   container named like the mount-all mount points.
+
+   The output is a tar archive when the name ends with .tar, .tar.gz, .tgz,
+   or .tar.zst, or is - for stdout. Otherwise the output is a directory that
+   must not exist or be empty.`,
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "path",
+			Usage: "container path or pattern to extract i.e. '/etc/**'. Can be repeated",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "extract the files of all containers",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers with --all",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		all := clictx.Bool("all")
+		switch {
+		case all && clictx.NArg() != 1:
+			return fmt.Errorf("output is required and container id cannot be used with --all")
+		case !all && clictx.NArg() != 2:
+			return fmt.Errorf("container id and output are required")
+		}
+		output := clictx.Args().Get(clictx.NArg() - 1)
+
+		if len(clictx.StringSlice("path")) == 0 {
+			return fmt.Errorf("at least one path is required. Use --path")
+		}
+		scope, err := explorers.NewPathList(clictx.StringSlice("path"))
+		if err != nil {
+			return err
+		}
+
+		compress, istar := tarOutput(output)
+		if !istar {
+			if entries, err := os.ReadDir(output); err == nil && len(entries) > 0 {
+				return fmt.Errorf("output directory %s is not empty", output)
+			}
+		}
+		if output == "-" {
+			log.SetOutput(os.Stderr)
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		var ctrs []explorers.Container
+		if all {
+			if ctrs, err = exp.ListContainers(ctx); err != nil {
+				return err
+			}
+		} else {
+			ctr := explorers.Container{Namespace: clictx.GlobalString("namespace")}
+			ctr.ID = clictx.Args().First()
+			ctrs = []explorers.Container{ctr}
+		}
+
+		var (
+			extracted []extractedFile
+			count     int
+		)
+		write := func(ew extractWriter) error {
+			namer := explorers.NewMountNamer()
+			for _, ctr := range ctrs {
+				if all && !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+					continue
+				}
+
+				layers, err := containerLayers(ctx, exp, ctr)
+				if err != nil {
+					if !all {
+						return err
+					}
+					log.WithField("containerid", ctr.ID).Warn("skipping container: ", err)
+					continue
+				}
+
+				prefix := ""
+				if all {
+					prefix = namer.Name(ctr)
+				}
+				files, err := extractContainerFiles(ew, layers, scope, ctr, prefix)
+				extracted = append(extracted, files...)
+				if err != nil {
+					return fmt.Errorf("extracting files of container %s: %w", ctr.ID, err)
+				}
+				count++
+			}
+			return nil
+		}
+
+		if istar {
+			err = writeExtractTar(output, compress, write)
+		} else {
+			err = write(dirExtractWriter(output))
+		}
+		if err != nil {
+			return err
+		}
+
+		if output == "-" {
+			return nil
+		}
+
+		outputformat := clictx.GlobalString("output")
+		if isStructuredOutput(outputformat) {
+			for _, f := range extracted {
+				printObject(outputformat, f)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(outputformat)
+		rw.Write("CONTAINER", "LAYER", "MODE", "SIZE", "SHA256", "PATH")
+		for _, f := range extracted {
+			path := f.Path
+			if f.Target != "" {
+				path = fmt.Sprintf("%s -> %s", f.Path, f.Target)
+			}
+			rw.Write(f.ContainerID, f.Layer, f.Mode, fmt.Sprint(f.Size), f.SHA256, path)
+		}
+		rw.Flush()
+
+		fmt.Printf("\nextracted %d files from %d containers to %s\n", len(extracted), count, output)
+		return nil
+	},
+}
+
+// extractWriter writes an extracted file at a relative output path.
+type extractWriter func(name string, f explorers.LayerFile) error
+
+// dirExtractWriter returns an extractWriter copying the files to a
+// directory.
+func dirExtractWriter(dir string) extractWriter {
+	return func(name string, f explorers.LayerFile) error {
+		target := filepath.Join(dir, name)
+		if f.Info.Mode()&os.ModeSymlink == 0 {
+			return explorers.CopyFile(f.LayerPath, target, f.Info)
+		}
+		link, err := os.Readlink(f.LayerPath)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	}
+}
+
+// writeExtractTar calls write with an extractWriter adding the files to a
+// tar archive file or stdout.
+func writeExtractTar(output string, compress string, write func(extractWriter) error) error {
+	writetar := func(w io.Writer) error {
+		tw := tar.NewWriter(w)
+		err := write(func(name string, f explorers.LayerFile) error {
+			return archive.WriteTarFile(tw, filepath.ToSlash(name), f.LayerPath, f.Info)
+		})
+		if err != nil {
+			return err
+		}
+		return tw.Close()
+	}
+	if output == "-" {
+		return writeCompressed(os.Stdout, compress, writetar)
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err := writeCompressed(f, compress, writetar); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// extractContainerFiles writes the regular files and symbolic links of the
+// layers matching the scope below prefix.
+//
+// A file that cannot be read is skipped. The other errors leave the output
+// incomplete and are returned.
+func extractContainerFiles(ew extractWriter, layers []string, scope *explorers.PathList, ctr explorers.Container, prefix string) ([]extractedFile, error) {
+	var extracted []extractedFile
+	err := explorers.WalkLayers(layers, scope.Filter(func(f explorers.LayerFile) error {
+		symlink := f.Info.Mode()&os.ModeSymlink != 0
+		if !f.Info.Mode().IsRegular() && !symlink {
+			return nil
+		}
+
+		ef := extractedFile{
+			Namespace:   ctr.Namespace,
+			ContainerID: ctr.ID,
+			Path:        f.Path,
+			Output:      filepath.Join(prefix, strings.TrimPrefix(f.Path, "/")),
+			Layer:       layerName(f.Layer),
+			Mode:        f.Info.Mode().String(),
+			Size:        f.Info.Size(),
+			ModifiedAt:  f.Info.ModTime().UTC(),
+		}
+		if symlink {
+			ef.Target, _ = os.Readlink(f.LayerPath)
+		}
+
+		err := ew(ef.Output, f)
+		var perr *os.PathError
+		if errors.As(err, &perr) && (perr.Op == "open" || perr.Op == "readlink") {
+			log.WithField("path", f.LayerPath).Warn("skipping file: ", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !symlink {
+			if ef.SHA256, err = fileDigest(f.LayerPath); err != nil {
+				log.WithField("path", f.LayerPath).Warn("hashing file: ", err)
+			}
+		}
+		extracted = append(extracted, ef)
+		return nil
+	}))
+	return extracted, err
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// imageRootSources are the image roots of the hosts explored in one
+// invocation i.e. the mount points of the disk images of a fleet.
+var imageRootSources []string
+
+// ImageRoots is the value of the repeatable global flag --image-root.
+//
+// String returns the last image root set, so the image root of the host
+// being explored is read using GlobalString and changed using GlobalSet.
+type ImageRoots struct {
+	roots []string
+}
+
+// Set adds an image root.
+func (r *ImageRoots) Set(value string) error {
+	r.roots = append(r.roots, value)
+	return nil
+}
+
+// String returns the last image root set.
+func (r *ImageRoots) String() string {
+	if len(r.roots) == 0 {
+		return ""
+	}
+	return r.roots[len(r.roots)-1]
+}
+
+// SetupImageRoots reads the image roots specified using several
+// --image-root values and the manifest file specified using the global flag
+// --image-roots-from.
+//
+// The list commands explore each image root in turn. The other commands
+// explore a single image root.
+func SetupImageRoots(clictx *cli.Context) error {
+	var roots []string
+	if r, ok := clictx.GlobalGeneric("image-root").(*ImageRoots); ok {
+		roots = append(roots, r.roots...)
+	}
+	if manifest := clictx.GlobalString("image-roots-from"); manifest != "" {
+		listed, err := readImageRootManifest(manifest)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, listed...)
+	}
+
+	// The flag alias -i is copied to --image-root after parsing, so the
+	// last image root is set twice.
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		if !seen[root] {
+			seen[root] = true
+			imageRootSources = append(imageRootSources, root)
+		}
+	}
+
+	if err := locateImageRoots(clictx); err != nil {
+		return err
+	}
+
+	switch len(imageRootSources) {
+	case 0:
+		return nil
+	case 1:
+		return clictx.GlobalSet("image-root", imageRootSources[0])
+	}
+	if command := clictx.Args().First(); command != ListCommand.Name && !ListCommand.HasName(command) {
+		return fmt.Errorf("several image roots are supported only by the list commands")
+	}
+	if clictx.GlobalString("image-file") != "" {
+		return fmt.Errorf("--image-file cannot be used with several image roots")
+	}
+	for _, name := range []string{"containerd-root", "docker-root", "crio-root", "podman-root"} {
+		if clictx.GlobalString(name) != "" {
+			return fmt.Errorf("--%s cannot be used with several image roots", name)
+		}
+	}
+
+	// The global setup uses the first image root.
+	return clictx.GlobalSet("image-root", imageRootSources[0])
+}
+
+// readImageRootManifest returns the image roots listed in a manifest file,
+// one per line. A relative path is relative to the directory of the
+// manifest. Blank lines and lines starting with # are ignored.
+func readImageRootManifest(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening image root manifest: %w", err)
+	}
+	defer f.Close()
+
+	var roots []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		roots = append(roots, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading image root manifest: %w", err)
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no image roots found in %s", path)
+	}
+	return roots, nil
+}
+
+// listOutput writes the rows, objects, and templates of a list action.
+//
+// The output of a host explored by a fleet action has the image root of the
+// host: the rows are prefixed with the HOST column, the objects have the
+// source field, and the template output is prefixed with the image root and
+// a tab.
+type listOutput struct {
+	format string
+	source string // image root of the host of a fleet action
+
+	// rows is the row writer shared by the hosts of a fleet action.
+	rows *rowWriter
+}
+
+// Format returns the output format specified using --output.
+func (o *listOutput) Format() string {
+	return o.format
+}
+
+// Rows returns the row writer of the action. The writer is flushed by the
+// action; the writer shared by the hosts of a fleet action is flushed after
+// the last host.
+func (o *listOutput) Rows() *rowWriter {
+	if o.rows != nil {
+		return o.rows
+	}
+	return newRowWriter(o.format)
+}
+
+// Object prints v using printObject.
+func (o *listOutput) Object(v interface{}) {
+	if o.source != "" {
+		v = sourceObject(o.source, v)
+	}
+	printObject(o.format, v)
+}
+
+// Template prints v using the template.
+func (o *listOutput) Template(tmpl *template.Template, v interface{}) {
+	if o.source != "" {
+		fmt.Printf("%s\t", o.source)
+	}
+	printTemplate(tmpl, v)
+}
+
+// fleetAction returns the action running a list action for each image root
+// when several image roots are specified. The action writes its output
+// using the listOutput of the host. An image root that cannot be explored
+// is reported and skipped.
+func fleetAction(action func(*cli.Context, *listOutput) error) func(*cli.Context) error {
+	return func(clictx *cli.Context) error {
+		format := clictx.GlobalString("output")
+		if len(imageRootSources) < 2 {
+			return action(clictx, &listOutput{format: format})
+		}
+
+		rows := newRowWriter(format)
+		rows.fleet = true
+		defer rows.flush()
+
+		failed := 0
+		for _, root := range imageRootSources {
+			if err := clictx.GlobalSet("image-root", root); err != nil {
+				return err
+			}
+			// The runtime is detected for each host.
+			detectedRuntime = ""
+			rows.setSource(root)
+
+			if err := action(clictx, &listOutput{format: format, source: root, rows: rows}); err != nil {
+				log.WithField("source", root).Error("exploring image root: ", err)
+				failed++
+			}
+		}
+		if failed == len(imageRootSources) {
+			return fmt.Errorf("no image root could be explored")
+		}
+		return nil
+	}
+}
+
+// sourceObject returns the JSON object of v with the source field holding
+// the image root of a host. A value that is not a JSON object i.e. a
+// namespace name is returned as the value field.
+func sourceObject(root string, v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	if len(b) < 2 || b[0] != '{' {
+		return struct {
+			Source string      `json:"source"`
+			Value  interface{} `json:"value"`
+		}{root, v}
+	}
+	source, _ := json.Marshal(root)
+	object := append([]byte(`{"source":`), source...)
+	if len(b) > 2 {
+		object = append(object, ',')
+	}
+	return json.RawMessage(append(object, b[1:]...))
+}
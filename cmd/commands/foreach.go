@@ -0,0 +1,315 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// foreachResult holds the result of running a command for a container.
+type foreachResult struct {
+	Namespace   string  `json:"namespace"`
+	ContainerID string  `json:"container_id"`
+	Image       string  `json:"image"`
+	Command     string  `json:"command"`
+	ExitCode    int     `json:"exit_code"`
+	Duration    float64 `json:"duration_seconds"`
+	Output      string  `json:"output,omitempty"`
+	OutputFile  string  `json:"output_file,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+var ForeachCommand = cli.Command{
+	Name:  "foreach",
+	Usage: "run a command for each container",
+	Description: `run a user supplied command for each container and aggregate the results.
+
+   The command supports the following template variables:
+     {id}        container ID
+     {namespace} container namespace
+     {image}     container image
+     {hostname}  container hostname
+     {upper}     container upper (writable) layer directory
+     {mount}     container mount point. Requires --mount
+
+   The values are shell quoted before substitution.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "exec",
+			Usage: "command to run for each container i.e. 'clamscan -r {mount}'",
+		},
+		cli.BoolFlag{
+			Name:  "mount",
+			Usage: "mount each container before running the command",
+		},
+		cli.StringFlag{
+			Name:  "mount-root",
+			Usage: "directory used to create container mount points. Default is a temporary directory",
+		},
+		cli.StringFlag{
+			Name:  "output-dir",
+			Usage: "directory to save the command output for each container",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "maximum duration of the command for each container. Zero means no timeout",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		command := clictx.String("exec")
+		if command == "" {
+			return fmt.Errorf("command is required")
+		}
+
+		mount := clictx.Bool("mount")
+		if mount && runtime.GOOS != "linux" {
+			return fmt.Errorf("mounting a container is only supported on Linux")
+		}
+		if !mount && strings.Contains(command, "{mount}") {
+			return fmt.Errorf("{mount} requires --mount")
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		mountroot := clictx.String("mount-root")
+		if mount && mountroot == "" {
+			mountroot, err = os.MkdirTemp("", "container-explorer-")
+			if err != nil {
+				return fmt.Errorf("creating mount root: %w", err)
+			}
+			defer os.Remove(mountroot)
+		}
+
+		outputdir := clictx.String("output-dir")
+		if outputdir != "" {
+			if err := os.MkdirAll(outputdir, 0755); err != nil {
+				return fmt.Errorf("creating output directory: %w", err)
+			}
+		}
+
+		namer := explorers.NewMountNamer()
+		var results []foreachResult
+
+		for _, ctr := range ctrs {
+			if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+
+			nsctx := namespaces.WithNamespace(ctx, ctr.Namespace)
+			dirname := namer.Name(ctr)
+
+			vars := map[string]string{
+				"id":        ctr.ID,
+				"namespace": ctr.Namespace,
+				"image":     ctr.Image,
+				"hostname":  ctr.Hostname,
+			}
+			if strings.Contains(command, "{upper}") {
+				upperdir, _, err := exp.ContainerLayers(nsctx, ctr.ID)
+				if err != nil {
+					log.WithField("containerid", ctr.ID).Warn("skipping container. Resolving the upper layer failed: ", err)
+					results = append(results, foreachResult{
+						Namespace:   ctr.Namespace,
+						ContainerID: ctr.ID,
+						Image:       ctr.Image,
+						Command:     command,
+						ExitCode:    -1,
+						Error:       fmt.Sprintf("resolving upper layer: %v", err),
+					})
+					continue
+				}
+				vars["upper"] = upperdir
+			}
+
+			var ctrmountpoint string
+			if mount {
+				ctrmountpoint = filepath.Join(mountroot, dirname)
+				if err := os.MkdirAll(ctrmountpoint, 0755); err != nil {
+					log.WithField("containerid", ctr.ID).Warn("creating mount point: ", err)
+					continue
+				}
+				if err := exp.MountContainer(nsctx, ctr.ID, ctrmountpoint); err != nil {
+					log.WithField("containerid", ctr.ID).Warn("skipping container. Mounting failed: ", err)
+					os.Remove(ctrmountpoint)
+					continue
+				}
+				vars["mount"] = ctrmountpoint
+			}
+
+			result := runForeachCommand(ctx, expandTemplate(command, vars), clictx.Duration("timeout"))
+			result.Namespace = ctr.Namespace
+			result.ContainerID = ctr.ID
+			result.Image = ctr.Image
+
+			if outputdir != "" {
+				result.OutputFile = filepath.Join(outputdir, fmt.Sprintf("%s.log", dirname))
+				if err := os.WriteFile(result.OutputFile, []byte(result.Output), 0644); err != nil {
+					log.WithField("containerid", ctr.ID).Warn("writing command output: ", err)
+				}
+				result.Output = ""
+			}
+
+			if mount {
+				if err := explorers.Unmount(ctrmountpoint); err != nil {
+					log.WithField("containerid", ctr.ID).Warn(err)
+				} else {
+					os.Remove(ctrmountpoint)
+				}
+			}
+
+			results = append(results, result)
+		}
+
+		if output := clictx.GlobalString("output"); isStructuredOutput(output) {
+			for _, r := range results {
+				printObject(output, r)
+			}
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
+		defer tw.Flush()
+
+		fmt.Fprintf(tw, "NAMESPACE\tCONTAINER ID\tIMAGE\tEXIT CODE\tDURATION\tOUTPUT\n")
+		for _, r := range results {
+			output := r.OutputFile
+			if output == "" {
+				output = firstLine(r.Output)
+			}
+			if r.Error != "" {
+				output = r.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%.2fs\t%s\n",
+				r.Namespace,
+				r.ContainerID,
+				r.Image,
+				r.ExitCode,
+				r.Duration,
+				output,
+			)
+		}
+		return nil
+	},
+}
+
+// runForeachCommand runs the command using the shell and returns the
+// combined output and exit code.
+//
+// The command runs in its own process group. When the timeout expires the
+// whole group is killed so that children of the shell holding the output
+// pipe do not keep the command running.
+func runForeachCommand(ctx context.Context, command string, timeout time.Duration) foreachResult {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	setProcessGroup(cmd)
+
+	start := time.Now()
+	err := cmd.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killProcessGroup(cmd)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+
+	result := foreachResult{
+		Command:  command,
+		Duration: time.Since(start).Seconds(),
+		Output:   out.String(),
+	}
+
+	var exiterr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exiterr):
+		result.ExitCode = exiterr.ExitCode()
+		if ctx.Err() != nil {
+			result.Error = fmt.Sprintf("command killed: %v", ctx.Err())
+		}
+	default:
+		result.ExitCode = -1
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// expandTemplate replaces {name} variables in the command with the shell
+// quoted values.
+func expandTemplate(command string, vars map[string]string) string {
+	var oldnew []string
+	for k, v := range vars {
+		oldnew = append(oldnew, fmt.Sprintf("{%s}", k), shellQuote(v))
+	}
+	return strings.NewReplacer(oldnew...).Replace(command)
+}
+
+// shellQuote returns a single quoted string safe to use in a shell command.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// firstLine returns the first non-empty line of s.
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
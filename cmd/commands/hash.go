@@ -0,0 +1,409 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/hashset"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Hash manifest formats.
+const (
+	manifestCSV   = "csv"
+	manifestJSONL = "jsonl"
+)
+
+// Hash set match filters of a hash manifest.
+const (
+	matchHits    = "hits"
+	matchUnknown = "unknown"
+)
+
+// manifestEntry is a hashed container file of a hash manifest.
+type manifestEntry struct {
+	Namespace   string    `json:"namespace,omitempty"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Path        string    `json:"path"`
+	Layer       int       `json:"layer"`
+	Deleted     bool      `json:"deleted,omitempty"`
+	Size        int64     `json:"size"`
+	Mode        string    `json:"mode"`
+	Modified    time.Time `json:"modified"`
+	SHA256      string    `json:"sha256"`
+	SHA1        string    `json:"sha1,omitempty"`
+	MD5         string    `json:"md5,omitempty"`
+	Verdict     string    `json:"verdict,omitempty"`
+	HashSet     string    `json:"hash_set,omitempty"`
+}
+
+var HashCommand = cli.Command{
+	Name:  "hash",
+	Usage: "write a hash manifest of container filesystems",
+	Description: `walk the merged filesystem of containers and write the SHA-256 and
+   optionally the SHA-1 and MD5 of each regular file to a manifest.
+
+   The manifest is CSV or JSON lines with one record per file, suitable for
+   matching against known-bad hash sets and the NSRL RDS. A file is hashed
+   once per container from the layer it is visible in, so a file modified in
+   the writable layer is hashed from the writable layer.
+
+   The files are matched during the walk against the hash sets specified
+   using --known-bad or --hashset, --known-good, and --nsrl, and the verdict
+   and the matching hash set are added to the manifest. Use --only hits to
+   write only the files in a hash set, or --only unknown to write only the
+   files in no hash set i.e. the files not in the NSRL. The SHA-1 and MD5 are
+   computed for matching when a hash set is specified.
+
+   Use --path to hash a container filesystem that is already mounted.`,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "container ID",
+		},
+		cli.BoolFlag{
+			Name:  "all-containers",
+			Usage: "hash the filesystems of all containers",
+		},
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "mounted container filesystem directory",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "manifest path. Default is stdout",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "manifest format csv or jsonl",
+			Value: manifestCSV,
+		},
+		cli.BoolFlag{
+			Name:  "sha1",
+			Usage: "compute SHA-1 of regular files",
+		},
+		cli.BoolFlag{
+			Name:  "md5",
+			Usage: "compute MD5 of regular files",
+		},
+		cli.BoolFlag{
+			Name:  "upper-only",
+			Usage: "hash only the files in the container writable layer",
+		},
+		cli.BoolFlag{
+			Name:  "include-deleted",
+			Usage: "include files deleted in the upper layer that are recoverable from the lower layers",
+		},
+		cli.Int64Flag{
+			Name:  "max-size",
+			Usage: "skip files larger than the size in bytes. 0 is unlimited",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		cli.StringFlag{
+			Name:  "only",
+			Usage: "write only the files in a hash set (hits) or in no hash set (unknown)",
+		},
+		pathsFromFlag,
+	}, hashSetFlags...),
+	Action: func(clictx *cli.Context) error {
+		containerid := clictx.String("id")
+		dir := clictx.String("path")
+		if containerid == "" && dir == "" && !clictx.Bool("all-containers") {
+			return fmt.Errorf("container id, path, or --all-containers is required")
+		}
+
+		format := clictx.String("format")
+		if format != manifestCSV && format != manifestJSONL {
+			return fmt.Errorf("unsupported manifest format %s", format)
+		}
+
+		matcher, err := hashSetMatcher(clictx)
+		if err != nil {
+			return err
+		}
+		only := clictx.String("only")
+		switch only {
+		case "", matchHits, matchUnknown:
+		default:
+			return fmt.Errorf("unsupported match filter %s", only)
+		}
+		if only != "" && matcher.Empty() {
+			return fmt.Errorf("--only requires a hash set. Use --known-bad, --known-good, or --nsrl")
+		}
+
+		out := os.Stdout
+		if output := clictx.String("output"); output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		w := bufio.NewWriter(out)
+		defer w.Flush()
+
+		mw := newManifestWriter(w, format, clictx.Bool("sha1"), clictx.Bool("md5"), !matcher.Empty())
+		defer mw.Flush()
+
+		walk := explorers.WalkLayers
+		if clictx.Bool("include-deleted") {
+			walk = explorers.WalkLayersWithDeleted
+		}
+		scope, err := pathScope(clictx)
+		if err != nil {
+			return err
+		}
+		maxsize := clictx.Int64("max-size")
+
+		counts := make(map[string]int)
+		hash := func(ctr explorers.Container, layers []string) error {
+			return walk(layers, scope.Filter(func(f explorers.LayerFile) error {
+				if !f.Info.Mode().IsRegular() || (maxsize > 0 && f.Info.Size() > maxsize) {
+					return nil
+				}
+				entry, err := hashManifestFile(f, mw.sha1 || mw.verdicts, mw.md5 || mw.verdicts)
+				if err != nil {
+					log.WithField("path", f.LayerPath).Warn("hashing file: ", err)
+					return nil
+				}
+				entry.Namespace = ctr.Namespace
+				entry.ContainerID = ctr.ID
+
+				if mw.verdicts {
+					entry.Verdict, entry.HashSet = matcher.Classify(entry.SHA256, entry.SHA1, entry.MD5)
+					counts[entry.Verdict]++
+					if (only == matchHits && entry.Verdict == hashset.Unknown) || (only == matchUnknown && entry.Verdict != hashset.Unknown) {
+						return nil
+					}
+					// The digests computed only for matching are not written.
+					if !mw.sha1 {
+						entry.SHA1 = ""
+					}
+					if !mw.md5 {
+						entry.MD5 = ""
+					}
+				} else {
+					counts[hashset.Unknown]++
+				}
+				return mw.Write(entry)
+			}))
+		}
+
+		if dir != "" {
+			return hash(explorers.Container{}, []string{dir})
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for _, ctr := range ctrs {
+			if containerid != "" && ctr.ID != containerid {
+				continue
+			}
+			if containerid == "" && !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+			found = true
+
+			layers, err := containerLayers(ctx, exp, ctr)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("skipping container: ", err)
+				continue
+			}
+			if clictx.Bool("upper-only") {
+				layers = layers[:1]
+			}
+			if err := hash(ctr, layers); err != nil {
+				log.WithField("containerid", ctr.ID).Warn("hashing container filesystem: ", err)
+			}
+		}
+
+		if !found && containerid != "" {
+			return fmt.Errorf("container %s not found", containerid)
+		}
+		log.WithFields(log.Fields{
+			hashset.KnownGood: counts[hashset.KnownGood],
+			hashset.KnownBad:  counts[hashset.KnownBad],
+			hashset.Unknown:   counts[hashset.Unknown],
+		}).Info("hashed container files")
+		return nil
+	},
+}
+
+// hashManifestFile returns the manifest entry of a container file with the
+// SHA-256 and optionally the SHA-1 and MD5 of the file.
+func hashManifestFile(f explorers.LayerFile, sha1sum bool, md5sum bool) (manifestEntry, error) {
+	file, err := os.Open(f.LayerPath)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	defer file.Close()
+
+	sha256hash := sha256.New()
+	hashes := []hash.Hash{sha256hash}
+	var sha1hash, md5hash hash.Hash
+	if sha1sum {
+		sha1hash = sha1.New()
+		hashes = append(hashes, sha1hash)
+	}
+	if md5sum {
+		md5hash = md5.New()
+		hashes = append(hashes, md5hash)
+	}
+
+	writers := make([]io.Writer, len(hashes))
+	for i, h := range hashes {
+		writers[i] = h
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return manifestEntry{}, err
+	}
+
+	entry := manifestEntry{
+		Path:     f.Path,
+		Layer:    f.Layer,
+		Deleted:  f.Deleted,
+		Size:     f.Info.Size(),
+		Mode:     f.Info.Mode().String(),
+		Modified: f.Info.ModTime().UTC(),
+		SHA256:   hex.EncodeToString(sha256hash.Sum(nil)),
+	}
+	if sha1hash != nil {
+		entry.SHA1 = hex.EncodeToString(sha1hash.Sum(nil))
+	}
+	if md5hash != nil {
+		entry.MD5 = hex.EncodeToString(md5hash.Sum(nil))
+	}
+	return entry, nil
+}
+
+// manifestWriter writes the records of a hash manifest as CSV or JSON
+// lines. The CSV header has the SHA-1 and MD5 columns only when requested,
+// and the verdict columns only when the files are matched against hash
+// sets.
+type manifestWriter struct {
+	cw       *csv.Writer
+	enc      *json.Encoder
+	sha1     bool
+	md5      bool
+	verdicts bool
+	started  bool
+}
+
+// newManifestWriter returns a manifest writer of the format.
+func newManifestWriter(w io.Writer, format string, sha1sum bool, md5sum bool, verdicts bool) *manifestWriter {
+	mw := &manifestWriter{
+		sha1:     sha1sum,
+		md5:      md5sum,
+		verdicts: verdicts,
+	}
+	if format == manifestJSONL {
+		mw.enc = json.NewEncoder(w)
+	} else {
+		mw.cw = newCSVWriter(w)
+	}
+	return mw
+}
+
+// Write writes a manifest record.
+func (mw *manifestWriter) Write(e manifestEntry) error {
+	if mw.enc != nil {
+		return mw.enc.Encode(e)
+	}
+
+	if err := mw.writeHeader(); err != nil {
+		return err
+	}
+
+	record := []string{
+		e.Namespace,
+		e.ContainerID,
+		e.Path,
+		fmt.Sprint(e.Layer),
+		fmt.Sprint(e.Deleted),
+		fmt.Sprint(e.Size),
+		e.Mode,
+		e.Modified.Format(time.RFC3339),
+		e.SHA256,
+	}
+	if mw.sha1 {
+		record = append(record, e.SHA1)
+	}
+	if mw.md5 {
+		record = append(record, e.MD5)
+	}
+	if mw.verdicts {
+		record = append(record, e.Verdict, e.HashSet)
+	}
+	return mw.cw.Write(record)
+}
+
+// writeHeader writes the CSV header once.
+func (mw *manifestWriter) writeHeader() error {
+	if mw.started {
+		return nil
+	}
+	mw.started = true
+
+	header := []string{"namespace", "container_id", "path", "layer", "deleted", "size", "mode", "modified", "sha256"}
+	if mw.sha1 {
+		header = append(header, "sha1")
+	}
+	if mw.md5 {
+		header = append(header, "md5")
+	}
+	if mw.verdicts {
+		header = append(header, "verdict", "hash_set")
+	}
+	return mw.cw.Write(header)
+}
+
+// Flush writes the buffered CSV records. The header of a CSV manifest is
+// written even if no file is written, so an empty manifest is valid CSV.
+func (mw *manifestWriter) Flush() {
+	if mw.cw != nil {
+		mw.writeHeader()
+		mw.cw.Flush()
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/ewf"
+	"github.com/google/container-explorer/explorers/vdisk"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var (
+	// imageFileDir is the temporary directory holding the mount points of
+	// the partitions of the disk image specified using --image-file.
+	imageFileDir string
+
+	// imageFileMounts are the mounted partitions of the disk image.
+	imageFileMounts []string
+)
+
+// SetupImageFile mounts the Linux root filesystem of the disk image
+// specified using the global flag --image-file and uses the mount point as
+// the image root. The disk image is a raw image, an EWF image, or a virtual
+// disk i.e. qcow2, VMDK, VHDX, or VHD.
+//
+// The partitions with a Linux filesystem are mounted read-only in turn
+// until a partition with a container runtime is found. Use
+// --image-partition to select the partition. The LUKS volumes are unlocked
+// using --luks-key-file or --luks-passphrase.
+func SetupImageFile(clictx *cli.Context) error {
+	image := clictx.GlobalString("image-file")
+	if image == "" {
+		return nil
+	}
+	if clictx.GlobalString("image-root") != "" {
+		return fmt.Errorf("--image-file and --image-root cannot be used together")
+	}
+
+	dir, err := os.MkdirTemp("", "container-explorer-image-")
+	if err != nil {
+		return fmt.Errorf("creating image mount directory: %w", err)
+	}
+	imageFileDir = dir
+
+	// Many commands exit using log.Fatal without running app.After.
+	log.RegisterExitHandler(func() {
+		FinishImageFile(clictx)
+	})
+
+	// media is the media data of the disk image. The partitions are read
+	// in place from flat when the kernel can read the image format.
+	var (
+		media      vdisk.Disk
+		flat       string
+		flatOffset int64
+	)
+	if format := ewf.Format(image); format != 0 {
+		raw, img, err := openEWF(image, dir, format)
+		if err != nil {
+			return err
+		}
+		if raw != "" {
+			image = raw
+		} else {
+			media = img
+		}
+	} else if format := vdisk.Detect(image); format != "" {
+		disk, err := vdisk.Open(image)
+		if err != nil {
+			return fmt.Errorf("opening %s virtual disk: %w", format, err)
+		}
+		log.WithFields(log.Fields{
+			"image":  image,
+			"format": format,
+			"size":   disk.Size(),
+		}).Debug("opened virtual disk")
+		media = disk
+
+		// The media data of a fixed VHD or a flat VMDK extent is read in
+		// place.
+		if d, ok := disk.(vdisk.FlatDisk); ok {
+			flat, flatOffset = d.Flat()
+		}
+	}
+	if media == nil {
+		if media, err = vdisk.OpenRaw(image); err != nil {
+			return err
+		}
+		flat = image
+	}
+	defer media.Close()
+
+	partitions, err := explorers.ReadPartitionsFrom(media, media.Size())
+	if err != nil {
+		return fmt.Errorf("reading partition table of %s: %w", image, err)
+	}
+	volumes, err := explorers.ReadLogicalVolumes(media, partitions)
+	if err != nil {
+		return fmt.Errorf("reading LVM logical volumes of %s: %w", image, err)
+	}
+	partitions = append(partitions, volumes...)
+
+	// The LUKS volumes are decrypted while reading and may hold LVM
+	// physical volumes.
+	keys, err := luksKeys(clictx)
+	if err != nil {
+		return err
+	}
+	unlocked, err := explorers.UnlockVolumes(media, partitions, keys)
+	if err != nil {
+		return fmt.Errorf("unlocking LUKS volumes of %s: %w", image, err)
+	}
+	volumes, err = explorers.ReadLogicalVolumes(media, unlocked)
+	if err != nil {
+		return fmt.Errorf("reading LVM logical volumes of %s: %w", image, err)
+	}
+	partitions = append(partitions, unlocked...)
+	partitions = append(partitions, volumes...)
+
+	mountPartition := func(p explorers.Partition, mountpoint string) error {
+		if flat != "" && p.Contiguous() {
+			return explorers.MountImagePartition(flat, flatOffset+p.Offset, p.Size, p.Filesystem, mountpoint)
+		}
+
+		// The kernel cannot read the image format, the logical volume
+		// segments, or the encrypted volume, so the partition is extracted
+		// to a raw file.
+		raw := mountpoint + ".raw"
+		if err := explorers.ExtractPartition(media, p, raw); err != nil {
+			return err
+		}
+		return explorers.MountImagePartition(raw, 0, p.Size, p.Filesystem, mountpoint)
+	}
+
+	selected := clictx.GlobalInt("image-partition")
+	for _, p := range partitions {
+		if selected != 0 && p.Index != selected {
+			continue
+		}
+		if !p.Mountable() {
+			log.WithFields(log.Fields{
+				"partition":  p.Index,
+				"filesystem": p.Filesystem,
+			}).Debug("skipping partition without a Linux filesystem")
+			continue
+		}
+
+		mountpoint := filepath.Join(dir, fmt.Sprintf("p%d", p.Index))
+		if err := os.Mkdir(mountpoint, 0755); err != nil {
+			return err
+		}
+		if err := mountPartition(p, mountpoint); err != nil {
+			log.WithField("partition", p.Index).Warn("mounting partition: ", err)
+			continue
+		}
+
+		root := varImageRoot(mountpoint)
+		if selected == 0 && len(detectRuntimes(root)) == 0 {
+			log.WithField("partition", p.Index).Debug("no container runtime found in partition")
+			if err := explorers.Unmount(mountpoint); err != nil {
+				imageFileMounts = append(imageFileMounts, mountpoint)
+				return err
+			}
+			// The partition extracted from the image is not needed.
+			os.Remove(mountpoint + ".raw")
+			continue
+		}
+		imageFileMounts = append(imageFileMounts, mountpoint)
+
+		log.WithFields(log.Fields{
+			"image":      image,
+			"partition":  p.Index,
+			"filesystem": p.Filesystem,
+			"mountpoint": mountpoint,
+		}).Info("mounted disk image partition as image root")
+		return clictx.GlobalSet("image-root", root)
+	}
+
+	var found []string
+	for _, p := range partitions {
+		ptype := p.Type
+		if ptype == "" {
+			ptype = "no partition table"
+		}
+		if p.Name != "" {
+			ptype = fmt.Sprintf("%s %s", ptype, p.Name)
+		}
+		found = append(found, fmt.Sprintf("%d (%s, offset %d, %s)", p.Index, ptype, p.Offset, filesystemName(p.Filesystem)))
+	}
+	if selected != 0 {
+		return fmt.Errorf("partition %d of %s cannot be mounted. Partitions: %s", selected, image, strings.Join(found, "; "))
+	}
+	return fmt.Errorf("no Linux root filesystem with a container runtime found in %s. Use --image-partition to select a partition: %s", image, strings.Join(found, "; "))
+}
+
+// varImageRoot returns the image root of a mounted partition. A separate
+// /var filesystem i.e. the var logical volume of a server image holds
+// lib/containerd without the var directory, so the image root is a
+// directory linking var to the mount point.
+func varImageRoot(mountpoint string) string {
+	if len(detectRuntimes(mountpoint)) > 0 {
+		return mountpoint
+	}
+
+	root := mountpoint + ".root"
+	if err := os.Mkdir(root, 0755); err != nil {
+		return mountpoint
+	}
+	if err := os.Symlink(mountpoint, filepath.Join(root, "var")); err == nil && len(detectRuntimes(root)) > 0 {
+		log.WithField("mountpoint", mountpoint).Debug("partition is a separate /var filesystem")
+		return root
+	}
+	os.RemoveAll(root)
+	return mountpoint
+}
+
+// luksKeys returns the keys unlocking the LUKS volumes specified using
+// --luks-key-file and --luks-passphrase. The content of the key file is the
+// key.
+func luksKeys(clictx *cli.Context) ([][]byte, error) {
+	var keys [][]byte
+	if path := clictx.GlobalString("luks-key-file"); path != "" {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading LUKS key file: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if passphrase := clictx.GlobalString("luks-passphrase"); passphrase != "" {
+		keys = append(keys, []byte(passphrase))
+	}
+	return keys, nil
+}
+
+// openEWF exposes the media data of an EWF image as a raw file using
+// ewfmount and returns the path of the raw file. The EWF version 1 image is
+// opened using the pure Go reader if ewfmount is not installed.
+func openEWF(image string, dir string, format int) (string, *ewf.Image, error) {
+	mountpoint := filepath.Join(dir, "ewf")
+	if err := os.Mkdir(mountpoint, 0755); err != nil {
+		return "", nil, err
+	}
+
+	raw, err := ewf.Mount(image, mountpoint)
+	if err == nil {
+		// The EWF mount point is unmounted after the partitions.
+		imageFileMounts = append(imageFileMounts, mountpoint)
+		log.WithField("raw", raw).Debug("exposed EWF media data using ewfmount")
+		return raw, nil, nil
+	}
+	if format != 1 {
+		return "", nil, fmt.Errorf("reading EWF version %d image %s requires ewfmount of libewf: %w", format, image, err)
+	}
+	log.Info("install ewfmount to read the EWF image in place: ", err)
+
+	img, err := ewf.Open(image)
+	if err != nil {
+		return "", nil, fmt.Errorf("opening EWF image %s: %w", image, err)
+	}
+	return "", img, nil
+}
+
+// FinishImageFile unmounts the partitions of the disk image mounted by
+// SetupImageFile.
+//
+// The databases opened by the explorers are still open, so the partitions
+// are detached lazily and released when container-explorer exits.
+func FinishImageFile(clictx *cli.Context) error {
+	if imageFileDir == "" {
+		return nil
+	}
+	for i := len(imageFileMounts) - 1; i >= 0; i-- {
+		if err := explorers.DetachMount(imageFileMounts[i]); err != nil {
+			log.WithField("mountpoint", imageFileMounts[i]).Error("unmounting disk image partition: ", err)
+			// The mounted partition must not be removed.
+			return err
+		}
+	}
+	imageFileMounts = nil
+	err := os.RemoveAll(imageFileDir)
+	imageFileDir = ""
+	return err
+}
+
+// filesystemName returns the filesystem name for display.
+func filesystemName(fstype string) string {
+	if fstype == "" {
+		return "unknown filesystem"
+	}
+	return fstype
+}
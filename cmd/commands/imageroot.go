@@ -0,0 +1,503 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/archive"
+	"github.com/google/container-explorer/explorers/ewf"
+	"github.com/google/container-explorer/explorers/vdisk"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// imageRootSearchDepth is the depth of the directories searched for the OS
+// root within an image root i.e. case/host/p2 for an extracted evidence
+// archive.
+const imageRootSearchDepth = 3
+
+// rawImageExtensions are the file extensions of the raw disk images. The
+// other disk image formats are detected by their signature.
+var rawImageExtensions = map[string]bool{
+	".raw": true,
+	".img": true,
+	".dd":  true,
+}
+
+// imageRootLayoutDir is the temporary directory holding the OS roots composed
+// of a root partition and a separate /var partition.
+var imageRootLayoutDir string
+
+// imageRootLayout is the OS root or the disk image found within an image
+// root.
+type imageRootLayout struct {
+	Root  string // OS root directory
+	Image string // disk image file mounted using --image-file
+}
+
+// locateImageRoot returns the OS root or the disk image file within an
+// image root that is not itself the root of a Linux filesystem.
+//
+// An image root pointing at an evidence directory i.e. an extracted archive
+// or the mount points of several partitions p1/ and p2/ is searched for:
+//   - a directory with a container runtime
+//   - a root partition and a separate /var partition with a container
+//     runtime, composed into one OS root
+//   - an ostree sysroot i.e. the root partition of Fedora CoreOS, composed
+//     of the deployment and the /var directory of the stateroot
+//   - a Bottlerocket root partition and data partition, composed into one
+//     OS root
+//   - a Talos EPHEMERAL partition and STATE partition, composed into one OS
+//     root
+//   - a single disk image file
+//
+// An image root pointing at a tar or zip evidence archive is extracted to
+// the temporary directory and searched in turn.
+//
+// The image root is returned unchanged if it is a Linux root filesystem or
+// if no layout is found.
+func locateImageRoot(imageroot string) (imageRootLayout, error) {
+	if isOSRoot(imageroot) || len(detectRuntimes(imageroot)) > 0 {
+		return imageRootLayout{Root: imageroot}, nil
+	}
+	info, err := os.Stat(imageroot)
+	if err == nil && !info.IsDir() {
+		if format, _ := archive.DetectEvidence(imageroot); format != "" {
+			return archiveImageRoot(imageroot)
+		}
+	}
+	if err != nil || !info.IsDir() {
+		return imageRootLayout{Root: imageroot}, nil
+	}
+	if isOstreeSysroot(imageroot) {
+		return ostreeImageRoot(imageroot)
+	}
+	if isVarRoot(imageroot) {
+		root, err := composeImageRoot(nil, map[string]string{"var": imageroot})
+		if err != nil {
+			return imageRootLayout{}, err
+		}
+		return imageRootLayout{Root: root}, nil
+	}
+
+	var (
+		runtimeRoots []string
+		osRoots      []string
+		varRoots     []string
+		sysroots     []string
+		states       []string
+		images       []string
+	)
+	walkImageRoot(imageroot, 0, func(path string, isdir bool) bool {
+		if !isdir {
+			if isDiskImageFile(path) {
+				images = append(images, path)
+			}
+			return false
+		}
+		switch {
+		case isOstreeSysroot(path):
+			sysroots = append(sysroots, path)
+			return false
+		case isTalosState(path):
+			states = append(states, path)
+			return false
+		case len(detectRuntimes(path)) > 0:
+			runtimeRoots = append(runtimeRoots, path)
+			return false
+		case isOSRoot(path):
+			osRoots = append(osRoots, path)
+			return false
+		case isVarRoot(path):
+			varRoots = append(varRoots, path)
+			return false
+		}
+		return true
+	})
+
+	log.WithFields(log.Fields{
+		"imageroot": imageroot,
+		"runtimes":  runtimeRoots,
+		"osroots":   osRoots,
+		"varroots":  varRoots,
+		"sysroots":  sysroots,
+		"states":    states,
+		"images":    images,
+	}).Debug("searched image root layout")
+
+	switch {
+	case len(runtimeRoots) == 1 && len(osRoots) > 0 && isBottlerocketData(runtimeRoots[0]):
+		return bottlerocketImageRoot(osRoots, runtimeRoots[0])
+	case len(runtimeRoots) == 1:
+		return imageRootLayout{Root: runtimeRoots[0]}, nil
+	case len(runtimeRoots) > 1:
+		return imageRootLayout{}, fmt.Errorf("several OS roots with a container runtime found in %s: %s. Use --image-root for each root", imageroot, strings.Join(runtimeRoots, ", "))
+	case len(varRoots) == 1 && len(osRoots) <= 1:
+		mounts := map[string]string{"var": varRoots[0]}
+		if len(states) == 1 {
+			mounts["system/state"] = states[0]
+		}
+		root, err := composeImageRoot(osRoots, mounts)
+		if err != nil {
+			return imageRootLayout{}, err
+		}
+		return imageRootLayout{Root: root}, nil
+	case len(varRoots) > 1:
+		return imageRootLayout{}, fmt.Errorf("several /var partitions with a container runtime found in %s: %s. Use --image-root to select the root", imageroot, strings.Join(varRoots, ", "))
+	case len(sysroots) == 1:
+		return ostreeImageRoot(sysroots[0])
+	case len(sysroots) > 1:
+		return imageRootLayout{}, fmt.Errorf("several ostree sysroots found in %s: %s. Use --image-root to select the sysroot", imageroot, strings.Join(sysroots, ", "))
+	case len(images) == 1:
+		return imageRootLayout{Image: images[0]}, nil
+	case len(images) > 1:
+		return imageRootLayout{}, fmt.Errorf("several disk images found in %s: %s. Use --image-file to select the disk image", imageroot, strings.Join(images, ", "))
+	}
+	return imageRootLayout{Root: imageroot}, nil
+}
+
+// walkImageRoot calls fn for the entries of dir in lexical order up to
+// imageRootSearchDepth. The subdirectories of a directory are searched if fn
+// returns true. Symbolic links are not followed.
+func walkImageRoot(dir string, depth int, fn func(path string, isdir bool) bool) {
+	if depth >= imageRootSearchDepth {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.WithField("dir", dir).Debug("reading image root directory: ", err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || e.Type()&os.ModeSymlink != 0 {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if fn(path, e.IsDir()) && e.IsDir() {
+			walkImageRoot(path, depth+1, fn)
+		}
+	}
+}
+
+// isOSRoot returns true if the directory is the root of a Linux filesystem.
+//
+// The os-release file is resolved within the directory because NixOS links
+// /etc/os-release to /nix/store.
+func isOSRoot(dir string) bool {
+	for _, p := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		if path, err := explorers.ResolveHostPath(dir, p); err == nil && explorers.PathExists(path, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVarRoot returns true if the directory is a separate /var filesystem
+// with a container runtime i.e. lib/containerd without the var directory.
+func isVarRoot(dir string) bool {
+	for _, p := range []string{containerdRootDir, dockerRootDir, crioRootDir} {
+		if explorers.PathExists(filepath.Join(dir, strings.TrimPrefix(p, "/var/")), false) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOstreeSysroot returns true if the directory is an ostree sysroot with
+// deployments i.e. the root partition of Fedora CoreOS or the /sysroot of a
+// running system.
+func isOstreeSysroot(dir string) bool {
+	deployments, _ := filepath.Glob(filepath.Join(dir, "ostree", "deploy", "*", "deploy", "*", "usr"))
+	return len(deployments) > 0
+}
+
+// ostreeImageRoot returns the OS root composed of the deployment of an
+// ostree sysroot and the /var directory of its stateroot.
+//
+// An ostree sysroot holds the deployments in
+// /ostree/deploy/<stateroot>/deploy/<checksum>.<serial> and the /var
+// directory shared by the deployments of a stateroot in
+// /ostree/deploy/<stateroot>/var. The deployment of the default boot loader
+// entry is used. Otherwise the most recent deployment is used.
+func ostreeImageRoot(sysroot string) (imageRootLayout, error) {
+	deployment := ostreeBootDeployment(sysroot)
+	if deployment == "" {
+		deployments, _ := filepath.Glob(filepath.Join(sysroot, "ostree", "deploy", "*", "deploy", "*", "usr"))
+		var latest time.Time
+		for _, usr := range deployments {
+			dir := filepath.Dir(usr)
+			info, err := os.Stat(dir)
+			if err != nil || info.ModTime().Before(latest) {
+				continue
+			}
+			deployment, latest = dir, info.ModTime()
+		}
+		if len(deployments) > 1 {
+			log.WithFields(log.Fields{
+				"sysroot":     sysroot,
+				"deployments": len(deployments),
+				"deployment":  deployment,
+			}).Warn("boot loader entries not found. Using the most recent ostree deployment")
+		}
+	}
+	if deployment == "" {
+		return imageRootLayout{}, fmt.Errorf("no ostree deployment found in %s", sysroot)
+	}
+
+	// <sysroot>/ostree/deploy/<stateroot>/deploy/<deployment>
+	varroot := filepath.Join(filepath.Dir(filepath.Dir(deployment)), "var")
+	log.WithFields(log.Fields{
+		"sysroot":    sysroot,
+		"deployment": filepath.Base(deployment),
+		"stateroot":  filepath.Base(filepath.Dir(filepath.Dir(deployment))),
+	}).Info("located ostree deployment")
+
+	root, err := composeImageRoot([]string{deployment}, map[string]string{"var": varroot})
+	if err != nil {
+		return imageRootLayout{}, err
+	}
+	return imageRootLayout{Root: root}, nil
+}
+
+// ostreeBootDeployment returns the deployment of the default boot loader
+// entry of an ostree sysroot or an empty string.
+//
+// The boot loader entries in /boot/loader/entries reference the deployment
+// using the ostree= kernel argument i.e.
+// ostree=/ostree/boot.1/fedora-coreos/<bootcsum>/0, a symbolic link to the
+// deployment. The entry with the highest version is the default entry.
+func ostreeBootDeployment(sysroot string) string {
+	entries, _ := filepath.Glob(filepath.Join(sysroot, "boot", "loader", "entries", "*.conf"))
+
+	var (
+		deployment string
+		latest     = -1
+	)
+	for _, entry := range entries {
+		data, err := os.ReadFile(entry)
+		if err != nil {
+			continue
+		}
+		version, bootpath := 0, ""
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			switch fields[0] {
+			case "version":
+				version, _ = strconv.Atoi(fields[1])
+			case "options":
+				for _, option := range fields[1:] {
+					if strings.HasPrefix(option, "ostree=") {
+						bootpath = strings.TrimPrefix(option, "ostree=")
+					}
+				}
+			}
+		}
+		if bootpath == "" || version <= latest {
+			continue
+		}
+		dir, err := explorers.ResolveHostPath(sysroot, bootpath)
+		if err != nil {
+			log.WithField("entry", entry).Debug("resolving ostree deployment: ", err)
+			continue
+		}
+		deployment, latest = dir, version
+	}
+	return deployment
+}
+
+// isDiskImageFile returns true if the file is a raw disk image, an EWF
+// image, or a virtual disk.
+func isDiskImageFile(path string) bool {
+	if rawImageExtensions[strings.ToLower(filepath.Ext(path))] {
+		return true
+	}
+	return ewf.Format(path) != 0 || vdisk.Detect(path) != ""
+}
+
+// composeImageRoot returns an OS root linking the top directories of the
+// root partition and the mount points to the separate partitions, i.e. var
+// to the /var partition. The OS root is created in a temporary directory, so
+// the evidence is not modified.
+func composeImageRoot(osroots []string, mounts map[string]string) (string, error) {
+	root, err := imageRootTempDir("root-")
+	if err != nil {
+		return "", err
+	}
+
+	osroot := ""
+	if len(osroots) == 1 {
+		osroot = osroots[0]
+	}
+	if err := linkImageRoot(root, osroot, "", mounts); err != nil {
+		return "", err
+	}
+
+	log.WithFields(log.Fields{
+		"osroot": osroots,
+		"mounts": mounts,
+		"root":   root,
+	}).Info("composed image root of a root partition and separate partitions")
+	return root, nil
+}
+
+// imageRootTempDir returns a new directory in the temporary directory
+// holding the OS roots. The directory is removed by FinishImageRoots.
+func imageRootTempDir(pattern string) (string, error) {
+	if imageRootLayoutDir == "" {
+		dir, err := os.MkdirTemp("", "container-explorer-root-")
+		if err != nil {
+			return "", fmt.Errorf("creating image root directory: %w", err)
+		}
+		imageRootLayoutDir = dir
+
+		// Many commands exit using log.Fatal without running app.After.
+		log.RegisterExitHandler(func() {
+			FinishImageRoots(nil)
+		})
+	}
+	return os.MkdirTemp(imageRootLayoutDir, pattern)
+}
+
+// linkImageRoot links the entries of the directory src of the root
+// partition and the mount points below prefix into dst.
+//
+// A directory containing a mount point i.e. system for system/state is
+// created in dst and its entries are linked, so the evidence is not
+// modified.
+func linkImageRoot(dst string, src string, prefix string, mounts map[string]string) error {
+	names := make(map[string]bool)
+	if src != "" {
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			names[e.Name()] = true
+		}
+	}
+	for dir := range mounts {
+		rel := dir
+		if prefix != "" {
+			if !strings.HasPrefix(dir, prefix+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(dir, prefix+"/")
+		}
+		names[strings.SplitN(rel, "/", 2)[0]] = true
+	}
+
+	for name := range names {
+		rel := filepath.Join(prefix, name)
+		target := filepath.Join(dst, name)
+		if partition, found := mounts[rel]; found {
+			if err := os.Symlink(partition, target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		below := false
+		for dir := range mounts {
+			if strings.HasPrefix(dir, rel+"/") {
+				below = true
+			}
+		}
+		if !below {
+			if err := os.Symlink(filepath.Join(src, name), target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.Mkdir(target, 0755); err != nil {
+			return err
+		}
+		subdir := ""
+		if src != "" && explorers.PathExists(filepath.Join(src, name), false) {
+			subdir = filepath.Join(src, name)
+		}
+		if err := linkImageRoot(target, subdir, rel, mounts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FinishImageRoots removes the OS roots composed by locateImageRoot.
+func FinishImageRoots(clictx *cli.Context) error {
+	if imageRootLayoutDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(imageRootLayoutDir)
+	imageRootLayoutDir = ""
+	return err
+}
+
+// locateImageRoots replaces the image roots with the OS roots located within
+// them. A single image root containing a single disk image is replaced with
+// the global flag --image-file, so the disk image is mounted by
+// SetupImageFile.
+func locateImageRoots(clictx *cli.Context) error {
+	// The image root is the mount point of the disk image.
+	if clictx.GlobalString("image-file") != "" {
+		return nil
+	}
+
+	for i, root := range imageRootSources {
+		layout, err := locateImageRoot(root)
+		if err != nil {
+			return err
+		}
+
+		if layout.Image != "" {
+			if len(imageRootSources) > 1 {
+				return fmt.Errorf("disk image %s found in image root %s cannot be used with several image roots. Use --image-file", layout.Image, root)
+			}
+			log.WithFields(log.Fields{
+				"imageroot": root,
+				"image":     layout.Image,
+			}).Info("located disk image within image root")
+
+			imageRootSources = nil
+			if err := clictx.GlobalSet("image-root", ""); err != nil {
+				return err
+			}
+			return clictx.GlobalSet("image-file", layout.Image)
+		}
+
+		if layout.Root != root {
+			log.WithFields(log.Fields{
+				"imageroot": root,
+				"osroot":    layout.Root,
+			}).Info("located OS root within image root")
+			imageRootSources[i] = layout.Root
+		}
+	}
+	return nil
+}
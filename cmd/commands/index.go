@@ -0,0 +1,336 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/knowledge"
+	"github.com/google/container-explorer/explorers/search"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var IndexCommand = cli.Command{
+	Name:  "index",
+	Usage: "build and search a full-text index of the container metadata, specs, and logs",
+	Subcommands: cli.Commands{
+		indexBuild,
+		indexSearch,
+	},
+}
+
+var indexBuild = cli.Command{
+	Name:      "build",
+	Usage:     "index the container metadata, specs, environment, annotations, and logs",
+	ArgsUsage: "<path>",
+	Description: `build a full-text index of every container of the host in a local
+   index file.
+
+   Each container metadata field, spec field, environment variable, and
+   annotation is a document. Each line of the docker json log and the
+   kubelet pod logs of the container is a document. Use index search to find
+   the documents containing words i.e. a host name, an image, or an error
+   message without loading the metadata again.
+
+   An existing index file is not overwritten.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-logs",
+			Usage: "do not index the container logs",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		path := clictx.Args().First()
+		if path == "" {
+			return fmt.Errorf("index path is required")
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		imageroot := clictx.GlobalString("image-root")
+		w, err := search.Create(path, imageroot)
+		if err != nil {
+			return err
+		}
+
+		var (
+			count int
+			seen  = make(map[string]bool)
+		)
+		for _, ctr := range ctrs {
+			if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+			count++
+
+			nsctx := namespaces.WithNamespace(ctx, ctr.Namespace)
+			err := indexContainer(nsctx, clictx, exp, w, ctr)
+			if err == nil && !clictx.Bool("no-logs") {
+				for _, logfile := range containerLogFiles(imageroot, selectedPack(clictx), ctr) {
+					if seen[logfile] {
+						continue
+					}
+					seen[logfile] = true
+					if err = indexLogFile(w, ctr, logfile, imageroot); err != nil {
+						break
+					}
+				}
+			}
+			if err != nil {
+				w.Close()
+				os.Remove(path)
+				return fmt.Errorf("indexing container %s: %w", ctr.ID, err)
+			}
+		}
+
+		documents := w.Documents()
+		if err := w.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("indexed %d documents of %d containers to %s\n", documents, count, path)
+		return nil
+	},
+}
+
+var indexSearch = cli.Command{
+	Name:      "search",
+	Usage:     "search an index for documents containing words",
+	ArgsUsage: "<path> <term> [<term>...]",
+	Description: `list the documents of the index built using index build containing
+   every term.
+
+   The terms are matched case-insensitively against the words of the
+   documents. A dotted or dashed word i.e. api.example.com matches as a whole
+   and each part i.e. example matches as well. A term ending with * matches
+   the words starting with the term. Quote a term of several words to match
+   the words in this order.
+
+   Example:
+     index search ce.idx "connection refused" 10.0.0.*`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "source",
+			Usage: "list only the documents of the source metadata, spec, env, annotation, or log",
+		},
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "list only the documents of the specified container ID",
+		},
+		cli.IntFlag{
+			Name:  "limit",
+			Usage: "maximum number of documents listed. Use 0 to list every document",
+			Value: 100,
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() < 2 {
+			return fmt.Errorf("index path and search terms are required")
+		}
+		source := clictx.String("source")
+		switch source {
+		case "", search.SourceMetadata, search.SourceSpec, search.SourceEnv, search.SourceAnnotation, search.SourceLog:
+		default:
+			return fmt.Errorf("unsupported source %s", source)
+		}
+
+		idx, err := search.Open(clictx.Args().First())
+		if err != nil {
+			return fmt.Errorf("opening index: %w", err)
+		}
+		defer idx.Close()
+
+		docs, err := idx.Search(search.Query{
+			Terms:       clictx.Args().Tail(),
+			Source:      source,
+			ContainerID: clictx.String("id"),
+			Limit:       clictx.Int("limit"),
+		})
+		if err != nil {
+			return err
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, doc := range docs {
+				printObject(output, doc)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("NAMESPACE", "CONTAINER ID", "SOURCE", "FIELD", "TEXT")
+		for _, doc := range docs {
+			rw.Write(doc.Namespace, doc.ContainerID, doc.Source, doc.Field, indexSnippet(doc.Text))
+		}
+		return nil
+	},
+}
+
+// indexContainer adds the metadata and the spec fields of a container to
+// the index. The environment variables and the annotations of the spec are
+// added with their own source.
+func indexContainer(ctx context.Context, clictx *cli.Context, exp explorers.ContainerExplorer, w *search.Writer, ctr explorers.Container) error {
+	add := func(source string, fields [][2]string) error {
+		for _, f := range fields {
+			doc := search.Document{
+				Namespace:   ctr.Namespace,
+				ContainerID: ctr.ID,
+				Source:      source,
+				Field:       f[0],
+				Text:        f[1],
+			}
+			if source == search.SourceSpec {
+				doc.Source = specFieldSource(f[0])
+			}
+			if err := w.Add(doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(ctr)
+	if err != nil {
+		return err
+	}
+	if err := add(search.SourceMetadata, jsonStrings(data)); err != nil {
+		return err
+	}
+
+	spec, err := containerSpecJSON(ctx, clictx, exp, ctr)
+	if err != nil {
+		log.WithField("containerid", ctr.ID).Warn("reading container spec: ", err)
+		return nil
+	}
+	return add(search.SourceSpec, jsonStrings(spec))
+}
+
+// specFieldSource returns the document source of a spec field i.e.
+// process.env[0] or annotations.io.kubernetes.cri.sandbox-id.
+func specFieldSource(path string) string {
+	p := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(p, "]") && strings.Contains(p, "env["):
+		return search.SourceEnv
+	case strings.HasPrefix(p, "annotations.") || strings.Contains(p, ".annotations."):
+		return search.SourceAnnotation
+	default:
+		return search.SourceSpec
+	}
+}
+
+// containerLogFiles returns the log files of a container i.e. the docker
+// json log and the kubelet pod logs in
+// /var/log/pods/<namespace>_<name>_<uid>/<container name>. The compressed
+// rotated pod logs are not returned.
+func containerLogFiles(imageroot string, p knowledge.Pack, ctr explorers.Container) []string {
+	var files []string
+	if ctr.LogPath != "" && explorers.PathExists(ctr.LogPath, true) {
+		files = append(files, ctr.LogPath)
+	}
+
+	uid, name := ctr.Labels[explorers.LabelPodUID], ctr.Labels[explorers.LabelContainerName]
+	if imageroot == "" || uid == "" || name == "" {
+		return files
+	}
+	matches, _ := filepath.Glob(filepath.Join(imageroot, p.PodLogs(), fmt.Sprintf("*_%s", uid), name, "*.log*"))
+	for _, match := range matches {
+		if strings.HasSuffix(match, ".gz") || !explorers.PathExists(match, true) {
+			continue
+		}
+		files = append(files, match)
+	}
+	return files
+}
+
+// indexLogFile adds each line of a log file to the index. The field is the
+// path of the log file within the image root and the line number.
+func indexLogFile(w *search.Writer, ctr explorers.Container, path string, imageroot string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		log.WithField("path", path).Warn("opening container log: ", err)
+		return nil
+	}
+	defer f.Close()
+
+	name := path
+	if imageroot != "" {
+		if rel, err := filepath.Rel(imageroot, path); err == nil && !strings.HasPrefix(rel, "..") {
+			name = "/" + rel
+		}
+	}
+
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadSlice('\n')
+		text := strings.TrimRight(string(line), "\r\n")
+		for err == bufio.ErrBufferFull {
+			// The remainder of a long line is not indexed.
+			_, err = r.ReadSlice('\n')
+		}
+		if text != "" {
+			if aerr := w.Add(search.Document{
+				Namespace:   ctr.Namespace,
+				ContainerID: ctr.ID,
+				Source:      search.SourceLog,
+				Field:       fmt.Sprintf("%s:%d", name, n),
+				Text:        text,
+			}); aerr != nil {
+				return aerr
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.WithField("path", path).Warn("reading container log: ", err)
+			}
+			return nil
+		}
+	}
+}
+
+// indexSnippet returns the document text on one line for display.
+func indexSnippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) > 120 {
+		text = text[:117] + "..."
+	}
+	return text
+}
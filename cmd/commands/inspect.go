@@ -0,0 +1,453 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// inspectKind describes how the objects of a kind are read and matched
+// with the identifiers specified on the command line.
+type inspectKind struct {
+	name    string
+	aliases []string
+	usage   string
+	list    func(ctx context.Context, exp explorers.ContainerExplorer) ([]interface{}, error)
+	match   func(v interface{}, id string) bool
+
+	// detail optionally adds the information that is expensive to read
+	// to a matched object.
+	detail func(ctx context.Context, exp explorers.ContainerExplorer, v interface{}) interface{}
+}
+
+var InspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "show detailed information about objects",
+	Description: `show detailed information about one or more objects.
+
+	The objects are printed as a JSON array. Use --format to print each
+	object using a Go template i.e. --format '{{.ID}} {{.Image}}'.`,
+	Subcommands: cli.Commands{
+		newInspectCommand(inspectContainerKind),
+		newInspectCommand(inspectImageKind),
+		newInspectCommand(inspectSnapshotKind),
+		newInspectCommand(inspectContentKind),
+		newInspectCommand(inspectTaskKind),
+		newInspectCommand(inspectPodKind),
+		newInspectCommand(inspectLeaseKind),
+		newInspectCommand(inspectNamespaceKind),
+	},
+}
+
+// newInspectCommand returns the inspect subcommand of a kind.
+//
+// The subcommands share the same output engine. The objects matching any
+// of the identifiers are printed as a JSON array, as one JSON object per line
+// for the jsonl output, or using the Go template specified with --format.
+func newInspectCommand(kind inspectKind) cli.Command {
+	return cli.Command{
+		Name:        kind.name,
+		Aliases:     kind.aliases,
+		Usage:       kind.usage,
+		Description: kind.usage,
+		ArgsUsage:   fmt.Sprintf("<%s id> [<%s id>...]", kind.name, kind.name),
+		Flags: []cli.Flag{
+			formatFlag,
+		},
+		Action: func(clictx *cli.Context) error {
+			if clictx.NArg() < 1 {
+				return fmt.Errorf("%s id is required", kind.name)
+			}
+
+			ctx, exp, cancel, err := explorerEnvironment(clictx)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer cancel()
+
+			objects, err := kind.list(ctx, exp)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			var (
+				matched  []interface{}
+				notfound []string
+			)
+			for _, id := range clictx.Args() {
+				found := false
+				for _, v := range objects {
+					if kind.match(v, id) {
+						if kind.detail != nil {
+							v = kind.detail(ctx, exp, v)
+						}
+						matched = append(matched, v)
+						found = true
+					}
+				}
+				if !found {
+					notfound = append(notfound, id)
+				}
+			}
+
+			if err := printInspect(clictx, matched); err != nil {
+				return err
+			}
+
+			if len(notfound) > 0 {
+				return fmt.Errorf("no such %s: %s", kind.name, strings.Join(notfound, ", "))
+			}
+			return nil
+		},
+	}
+}
+
+// printInspect prints the inspected objects.
+func printInspect(clictx *cli.Context, objects []interface{}) error {
+	tmpl, err := formatTemplate(clictx)
+	if err != nil {
+		return err
+	}
+	if tmpl != nil {
+		for _, v := range objects {
+			printTemplate(tmpl, v)
+		}
+		return nil
+	}
+
+	output := clictx.GlobalString("output")
+	if strings.ToLower(output) == outputJSONL {
+		for _, v := range objects {
+			printObject(output, v)
+		}
+		return nil
+	}
+
+	if objects == nil {
+		objects = []interface{}{}
+	}
+	printAsJSON(objects)
+	return nil
+}
+
+// matchID returns true if the id is equal to or a prefix of the object ID.
+func matchID(objectid string, id string) bool {
+	return objectid != "" && strings.HasPrefix(objectid, id)
+}
+
+// inspectContainer holds the container information and runtime spec.
+type inspectContainer struct {
+	explorers.Container
+	Spec interface{} `json:"Spec,omitempty"`
+}
+
+var inspectContainerKind = inspectKind{
+	name:    "container",
+	aliases: []string{"containers"},
+	usage:   "show detailed information about containers",
+	list: func(ctx context.Context, exp explorers.ContainerExplorer) ([]interface{}, error) {
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var objects []interface{}
+		for _, ctr := range ctrs {
+			objects = append(objects, inspectContainer{Container: ctr})
+		}
+		return objects, nil
+	},
+	match: func(v interface{}, id string) bool {
+		ctr := v.(inspectContainer)
+		return matchID(ctr.ID, id) || (ctr.Hostname != "" && ctr.Hostname == id)
+	},
+	detail: func(ctx context.Context, exp explorers.ContainerExplorer, v interface{}) interface{} {
+		ctr := v.(inspectContainer)
+
+		ctx = namespaces.WithNamespace(ctx, ctr.Namespace)
+		spec, err := exp.InfoContainer(ctx, ctr.ID, true)
+		if err != nil {
+			log.WithField("containerid", ctr.ID).Debug("reading container spec: ", err)
+			return ctr
+		}
+		ctr.Spec = spec
+		return ctr
+	},
+}
+
+var inspectImageKind = inspectKind{
+	name:    "image",
+	aliases: []string{"images"},
+	usage:   "show detailed information about images",
+	list: func(ctx context.Context, exp explorers.ContainerExplorer) ([]interface{}, error) {
+		images, err := exp.ListImages(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var objects []interface{}
+		for _, image := range images {
+			objects = append(objects, image)
+		}
+		return objects, nil
+	},
+	match: func(v interface{}, id string) bool {
+		image := v.(explorers.Image)
+		digest := string(image.Target.Digest)
+		return image.Name == id || digest == id || matchID(strings.TrimPrefix(digest, "sha256:"), id)
+	},
+}
+
+var inspectSnapshotKind = inspectKind{
+	name:    "snapshot",
+	aliases: []string{"snapshots"},
+	usage:   "show detailed information about snapshots",
+	list: func(ctx context.Context, exp explorers.ContainerExplorer) ([]interface{}, error) {
+		snapshots, err := exp.ListSnapshots(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var objects []interface{}
+		for _, s := range snapshots {
+			objects = append(objects, s)
+		}
+		return objects, nil
+	},
+	match: func(v interface{}, id string) bool {
+		s := v.(explorers.SnapshotKeyInfo)
+		return s.Key == id || s.Name == id || (s.ID != 0 && fmt.Sprintf("%d", s.ID) == id)
+	},
+}
+
+var inspectContentKind = inspectKind{
+	name:  "content",
+	usage: "show detailed information about content",
+	list: func(ctx context.Context, exp explorers.ContainerExplorer) ([]interface{}, error) {
+		content, err := exp.ListContent(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var objects []interface{}
+		for _, c := range content {
+			objects = append(objects, c)
+		}
+		return objects, nil
+	},
+	match: func(v interface{}, id string) bool {
+		digest := string(v.(explorers.Content).Digest)
+		return digest == id || matchID(strings.TrimPrefix(digest, "sha256:"), id)
+	},
+}
+
+var inspectTaskKind = inspectKind{
+	name:    "task",
+	aliases: []string{"tasks"},
+	usage:   "show detailed information about container tasks",
+	list: func(ctx context.Context, exp explorers.ContainerExplorer) ([]interface{}, error) {
+		tasks, err := exp.ListTasks(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var objects []interface{}
+		for _, t := range tasks {
+			objects = append(objects, t)
+		}
+		return objects, nil
+	},
+	match: func(v interface{}, id string) bool {
+		return matchID(v.(explorers.Task).Name, id)
+	},
+}
+
+// inspectPod holds the information about a pod and its containers.
+//
+// The pods are computed from the Kubernetes labels of the containers, or
+// the pod of Podman containers.
+type inspectPod struct {
+	UID        string
+	Name       string
+	Namespace  string // Kubernetes namespace
+	Sandbox    string // pod sandbox (pause) container ID
+	StartedAt  string
+	Containers []explorers.Container
+}
+
+var inspectPodKind = inspectKind{
+	name:    "pod",
+	aliases: []string{"pods"},
+	usage:   "show detailed information about pods",
+	list: func(ctx context.Context, exp explorers.ContainerExplorer) ([]interface{}, error) {
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		pods := make(map[string]*inspectPod)
+		var uids []string
+		for _, ctr := range ctrs {
+			uid, name, namespace := ctr.Labels[explorers.LabelPodUID], ctr.Labels[explorers.LabelPodName], ctr.Labels[explorers.LabelPodNamespace]
+			if uid == "" {
+				uid, name, namespace = ctr.PodID, ctr.PodName, ctr.Namespace
+			}
+			if uid == "" {
+				continue
+			}
+
+			pod, found := pods[uid]
+			if !found {
+				pod = &inspectPod{
+					UID:       uid,
+					Name:      name,
+					Namespace: namespace,
+				}
+				pods[uid] = pod
+				uids = append(uids, uid)
+			}
+			if ctr.ContainerType == "sandbox" {
+				pod.Sandbox = ctr.ID
+				if !ctr.StartedAt.IsZero() {
+					pod.StartedAt = ctr.StartedAt.Format(tsLayout)
+				}
+			}
+			pod.Containers = append(pod.Containers, ctr)
+		}
+		sort.Strings(uids)
+
+		var objects []interface{}
+		for _, uid := range uids {
+			objects = append(objects, *pods[uid])
+		}
+		return objects, nil
+	},
+	match: func(v interface{}, id string) bool {
+		pod := v.(inspectPod)
+		return matchID(pod.UID, id) || pod.Name == id
+	},
+}
+
+var inspectLeaseKind = inspectKind{
+	name:    "lease",
+	aliases: []string{"leases"},
+	usage:   "show detailed information about leases",
+	list: func(ctx context.Context, exp explorers.ContainerExplorer) ([]interface{}, error) {
+		leases, err := exp.ListLeases(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var objects []interface{}
+		for _, l := range leases {
+			objects = append(objects, l)
+		}
+		return objects, nil
+	},
+	match: func(v interface{}, id string) bool {
+		return v.(explorers.Lease).ID == id
+	},
+}
+
+// inspectNamespace holds the identifiers of the objects in a namespace.
+type inspectNamespace struct {
+	Name       string
+	Containers []string
+	Images     []string
+	Snapshots  []string
+	Content    []string
+	Leases     []string
+}
+
+var inspectNamespaceKind = inspectKind{
+	name:    "namespace",
+	aliases: []string{"namespaces", "ns"},
+	usage:   "show detailed information about namespaces",
+	list: func(ctx context.Context, exp explorers.ContainerExplorer) ([]interface{}, error) {
+		nss, err := exp.ListNamespaces(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		nsinfo := make(map[string]*inspectNamespace)
+		get := func(ns string) *inspectNamespace {
+			if _, found := nsinfo[ns]; !found {
+				nsinfo[ns] = &inspectNamespace{Name: ns}
+			}
+			return nsinfo[ns]
+		}
+		for _, ns := range nss {
+			get(ns)
+		}
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, ctr := range ctrs {
+			ns := get(ctr.Namespace)
+			ns.Containers = append(ns.Containers, ctr.ID)
+		}
+
+		images, _ := exp.ListImages(ctx)
+		for _, image := range images {
+			ns := get(image.Namespace)
+			ns.Images = append(ns.Images, image.Name)
+		}
+
+		snapshots, _ := exp.ListSnapshots(ctx)
+		for _, s := range snapshots {
+			ns := get(s.Namespace)
+			ns.Snapshots = append(ns.Snapshots, s.Key)
+		}
+
+		content, _ := exp.ListContent(ctx)
+		for _, c := range content {
+			ns := get(c.Namespace)
+			ns.Content = append(ns.Content, string(c.Digest))
+		}
+
+		leases, _ := exp.ListLeases(ctx)
+		for _, l := range leases {
+			ns := get(l.Namespace)
+			ns.Leases = append(ns.Leases, l.ID)
+		}
+
+		var names []string
+		for name := range nsinfo {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var objects []interface{}
+		for _, name := range names {
+			objects = append(objects, *nsinfo[name])
+		}
+		return objects, nil
+	},
+	match: func(v interface{}, id string) bool {
+		return v.(inspectNamespace).Name == id
+	},
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/containerd"
+	"github.com/google/container-explorer/explorers/knowledge"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// LoadKnowledgePacks registers the knowledge packs specified using the
+// global flag --knowledge-pack. A pack replaces the built-in pack with the
+// same name.
+func LoadKnowledgePacks(clictx *cli.Context) error {
+	for _, path := range clictx.GlobalStringSlice("knowledge-pack") {
+		if err := knowledge.Load(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectedPack returns the knowledge pack of the selected distribution. The
+// default pack is returned for the runtimes without a knowledge pack.
+func selectedPack(clictx *cli.Context) knowledge.Pack {
+	if p, found := knowledge.Get(selectedRuntime(clictx)); found {
+		return p
+	}
+	return knowledge.Pack{Name: selectedRuntime(clictx)}
+}
+
+// mergeSupportContainers returns the support containers of the user
+// specified data and the knowledge pack.
+func mergeSupportContainers(sc *explorers.SupportContainer, p knowledge.Pack) *explorers.SupportContainer {
+	psc := p.SupportContainers
+	if len(psc.ContainerNames) == 0 && len(psc.ImageNames) == 0 && len(psc.Labels) == 0 {
+		return sc
+	}
+	if sc == nil {
+		return &psc
+	}
+
+	return &explorers.SupportContainer{
+		ContainerNames: append(append([]string{}, sc.ContainerNames...), psc.ContainerNames...),
+		ImageNames:     append(append([]string{}, sc.ImageNames...), psc.ImageNames...),
+		Labels:         append(append([]string{}, sc.Labels...), psc.Labels...),
+	}
+}
+
+// containerdInstances returns the containerd instances of the selected
+// distribution. The first instance is the containerd of the orchestrated
+// containers.
+//
+// The other instances are not used when the containerd root is specified
+// using --containerd-root.
+func containerdInstances(clictx *cli.Context, root string, metadatafile string, snapshotfile string) []containerd.Instance {
+	instances := []containerd.Instance{{
+		Root:     root,
+		Metadata: metadatafile,
+		Snapshot: snapshotfile,
+	}}
+
+	imageroot := clictx.GlobalString("image-root")
+	if imageroot == "" || clictx.GlobalString("containerd-root") != "" {
+		return instances
+	}
+
+	for _, pi := range selectedPack(clictx).ContainerdInstances {
+		dir := pi.Root(imageroot)
+		if dir == "" {
+			log.WithField("instance", pi.Name).Debug("containerd instance not found")
+			continue
+		}
+
+		r, m, s := resolveContainerdPaths(imageroot, dir, "", "")
+		instances = append(instances, containerd.Instance{
+			Name:     pi.Name,
+			Root:     r,
+			Metadata: m,
+			Snapshot: s,
+		})
+	}
+	return instances
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/containerd"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// criNamespace is the containerd namespace of the containers created by
+// kubelet using the CRI plugin.
+const criNamespace = "k8s.io"
+
+// removedKubeletContainers returns the containers referenced in the kubelet
+// state but removed from the container runtime.
+//
+// A container with an ID is reported if the container runtime does not have
+// the ID. A container known only by pod and container name is reported if
+// the container runtime has no container of the same pod and name.
+func removedKubeletContainers(clictx *cli.Context, ctrs []explorers.Container) []explorers.Container {
+	imageroot := clictx.GlobalString("image-root")
+	if imageroot == "" {
+		log.Warn("image-root is empty. Skipping kubelet history")
+		return nil
+	}
+
+	pack := selectedPack(clictx)
+	history := explorers.KubeletContainers(imageroot, pack.Kubelet(), pack.PodLogs())
+
+	runtime := selectedRuntime(clictx)
+	if isContainerdRuntime(runtime) {
+		containerdroot, _, _ := resolveContainerdPaths(imageroot, containerdRoot(clictx), "", "")
+		history = mergeCRIStatuses(history, containerd.CRIStatusContainers(containerdroot))
+	}
+
+	ids := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, ctr := range ctrs {
+		ids[ctr.ID] = true
+		if uid, name := ctr.Labels[explorers.LabelPodUID], ctr.Labels[explorers.LabelContainerName]; uid != "" && name != "" {
+			names[uid+"/"+name] = true
+		}
+	}
+
+	var removed []explorers.Container
+	for _, k := range history {
+		if k.ID != "" && ids[k.ID] {
+			continue
+		}
+		if k.ID == "" && names[k.PodUID+"/"+k.Name] {
+			continue
+		}
+
+		ctr := k.Container()
+		if isContainerdRuntime(runtime) {
+			ctr.Namespace = criNamespace
+		}
+		log.WithFields(log.Fields{
+			"containerid": k.ID,
+			"pod":         k.PodNamespace + "/" + k.PodName,
+			"container":   k.Name,
+			"sources":     k.Sources,
+		}).Debug("container recovered from kubelet state")
+		removed = append(removed, ctr)
+	}
+	return removed
+}
+
+// mergeCRIStatuses merges the containers of the CRI status files into the
+// kubelet history. The CRI status times are preferred over the times
+// estimated from the container log.
+func mergeCRIStatuses(history []explorers.KubeletContainer, statuses []explorers.KubeletContainer) []explorers.KubeletContainer {
+	for _, s := range statuses {
+		merged := false
+		for i := range history {
+			if history[i].ID != s.ID {
+				continue
+			}
+			if !s.CreatedAt.IsZero() {
+				history[i].CreatedAt = s.CreatedAt
+			}
+			if !s.StartedAt.IsZero() {
+				history[i].StartedAt = s.StartedAt
+			}
+			if !s.FinishedAt.IsZero() {
+				history[i].FinishedAt = s.FinishedAt
+				history[i].ExitCode = s.ExitCode
+			}
+			history[i].AddSource(explorers.KubeletSourceCRIStatus)
+			merged = true
+		}
+		if !merged {
+			history = append(history, s)
+		}
+	}
+	return history
+}
@@ -17,12 +17,15 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
-	"text/tabwriter"
+	"text/template"
 
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/noise"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/urfave/cli"
@@ -37,10 +40,14 @@ var ListCommand = cli.Command{
 	Subcommands: cli.Commands{
 		listNamespaces,
 		listContainers,
+		listExited,
 		listContent,
 		listImages,
 		listSnapshots,
 		listTasks,
+		listLeases,
+		listStoragePools,
+		listNoiseProfiles,
 	},
 }
 
@@ -49,26 +56,51 @@ var listNamespaces = cli.Command{
 	Aliases:     []string{"namespace", "ns"},
 	Usage:       "list all namespaces",
 	Description: "list all namespaces",
-	Action: func(clictx *cli.Context) error {
+	Flags: []cli.Flag{
+		formatFlag,
+	},
+	Action: fleetAction(func(clictx *cli.Context, out *listOutput) error {
 
 		ctx, exp, cancel, err := explorerEnvironment(clictx)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		defer cancel()
 
 		nss, err := exp.ListNamespaces(ctx)
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+		if tmpl != nil {
+			for _, ns := range nss {
+				out.Template(tmpl, ns)
+			}
+			return nil
 		}
 
-		fmt.Println("NAMESPACE")
+		output := out.Format()
+		if isStructuredOutput(output) {
+			for _, ns := range nss {
+				out.Object(ns)
+			}
+			return nil
+		}
+
+		rw := out.Rows()
+		defer rw.Flush()
+
+		rw.Write("NAMESPACE")
 		for _, ns := range nss {
-			fmt.Println(ns)
+			rw.Write(ns)
 		}
 
 		return nil
-	},
+	}),
 }
 
 var listContainers = cli.Command{
@@ -77,10 +109,19 @@ var listContainers = cli.Command{
 	Usage:       "list containers for all namespaces",
 	Description: "list containers for all namespaces",
 	Flags: []cli.Flag{
+		formatFlag,
 		cli.BoolFlag{
 			Name:  "show-support-containers",
 			Usage: "show supporting containers created by Kubernetes",
 		},
+		cli.BoolFlag{
+			Name:  "workloads-only",
+			Usage: "hide support containers and the containers of the noise profiles i.e. monitoring agents, service meshes, and log shippers",
+		},
+		cli.StringFlag{
+			Name:  "noise-profiles",
+			Usage: "comma separated noise profiles used by --workloads-only. Default is all profiles",
+		},
 		cli.BoolFlag{
 			Name:  "no-labels",
 			Usage: "hide container labels",
@@ -97,49 +138,110 @@ var listContainers = cli.Command{
 			Name:  "running",
 			Usage: "show running docker managed containers",
 		},
+		cli.IntFlag{
+			Name:  "limit",
+			Usage: "list up to limit containers and print the token of the next page",
+		},
+		cli.StringFlag{
+			Name:  "page-token",
+			Usage: "list the containers following the page token printed by the previous page",
+		},
+		cli.BoolFlag{
+			Name:  "kubelet-history",
+			Usage: "include the containers removed from the container runtime but referenced in the kubelet logs and checkpoints",
+		},
+		cli.BoolFlag{
+			Name:  "collapse-restarts",
+			Usage: "group the restart attempts of the Kubernetes containers by pod and container name and show the latest attempt",
+		},
+		cli.BoolFlag{
+			Name:  "attempts",
+			Usage: "show the prior restart attempts below each container. Used with --collapse-restarts",
+		},
 	},
-	Action: func(clictx *cli.Context) error {
+	Action: fleetAction(func(clictx *cli.Context, out *listOutput) error {
 
 		ctx, exp, cancel, err := explorerEnvironment(clictx)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		defer cancel()
 
-		containers, err := exp.ListContainers(ctx)
+		var containers []explorers.Container
+		var nexttoken string
+		if clictx.Int("limit") > 0 || clictx.String("page-token") != "" {
+			if clictx.Bool("kubelet-history") {
+				return fmt.Errorf("--kubelet-history cannot be used with --limit or --page-token")
+			}
+			containers, nexttoken, err = listContainersPage(ctx, exp, clictx.Int("limit"), clictx.String("page-token"))
+		} else {
+			containers, err = exp.ListContainers(ctx)
+		}
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
-		defer tw.Flush()
+		// The containers garbage collected by kubelet follow the containers
+		// of the container runtime.
+		if clictx.Bool("kubelet-history") {
+			containers = append(containers, removedKubeletContainers(clictx, containers)...)
+		}
 
-		output := clictx.GlobalString("output")
-		if output == "table" {
-			//tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
-			//defer tw.Flush()
+		// The next page token is printed to stderr to keep the structured
+		// output parsable.
+		if nexttoken != "" {
+			defer fmt.Fprintf(os.Stderr, "Next page token: %s\n", nexttoken)
+		}
+
+		output := out.Format()
 
-			displayFields := "NAMESPACE\tTYPE\tCONTAINER ID\tCONTAINER HOSTNAME\tIMAGE\tCREATED AT\tPID\tSTATUS"
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+
+		var profiles []noise.Profile
+		if clictx.Bool("workloads-only") {
+			if profiles, err = selectedNoiseProfiles(clictx); err != nil {
+				return err
+			}
+		}
+
+		rw := out.Rows()
+		defer rw.Flush()
+
+		collapse := clictx.Bool("collapse-restarts")
+		var listed []explorers.Container
+
+		if tmpl == nil && !isStructuredOutput(output) && !collapse {
+			displayFields := []string{"NAMESPACE", "TYPE", "CONTAINER ID", "CONTAINER HOSTNAME", "IMAGE", "CREATED AT", "PID", "STATUS"}
 			// show updated timestamp
 			if clictx.Bool("updated") {
-				displayFields = fmt.Sprintf("%v\tUPDATED AT", displayFields)
+				displayFields = append(displayFields, "UPDATED AT")
 			}
 			// show exposed ports
 			if clictx.Bool("ports") {
-				displayFields = fmt.Sprintf("%v\tEXPOSED PORTS", displayFields)
+				displayFields = append(displayFields, "EXPOSED PORTS")
 			}
 			// display docker container name
-			if clictx.GlobalBool("docker-managed") {
-				displayFields = fmt.Sprintf("%v\tNAME", displayFields)
+			if isDockerRuntime(selectedRuntime(clictx)) {
+				displayFields = append(displayFields, "NAME")
 			}
 			// show labels
 			if !clictx.Bool("no-labels") {
-				displayFields = fmt.Sprintf("%v\tLABELS", displayFields)
+				displayFields = append(displayFields, "LABELS")
 			}
-			fmt.Fprintf(tw, "%v\n", displayFields)
+			rw.Write(displayFields...)
 		}
 
 		for _, container := range containers {
+			// Show only the application containers.
+			if clictx.Bool("workloads-only") {
+				if skipNoisyWorkload(profiles, container) {
+					continue
+				}
+			}
+
 			// Show Kubernetes support containers created
 			// by GKE, EKS, and AKS
 			if !clictx.Bool("show-support-containers") && container.SupportContainer {
@@ -155,7 +257,7 @@ var listContainers = cli.Command{
 			// Show only running containers.
 			//
 			// This is currently supported only on a docker managed containers.
-			if clictx.GlobalBool("docker-managed") && clictx.Bool("running") {
+			if isDockerRuntime(selectedRuntime(clictx)) && clictx.Bool("running") {
 				if !container.Running {
 					log.WithFields(log.Fields{
 						"containerid": container.ID,
@@ -166,43 +268,108 @@ var listContainers = cli.Command{
 				}
 			}
 
-			switch strings.ToLower(output) {
-			case "json":
-				printAsJSON(container)
-			default:
-				displayValues := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s",
-					container.Namespace,
-					container.ContainerType,
-					container.ID,
-					container.Hostname,
-					container.Image,
-					container.CreatedAt.Format(tsLayout),
-					container.ProcessID,
-					container.Status,
-				)
-				// show updated timestamp value
-				if clictx.Bool("updated") {
-					displayValues = fmt.Sprintf("%v\t%s", displayValues, container.UpdatedAt.Format(tsLayout))
-				}
-				// show exposed ports value
-				if clictx.Bool("ports") {
-					displayValues = fmt.Sprintf("%v\t%s", displayValues, arrayToString(container.ExposedPorts))
-				}
-				// show docker container name
-				if clictx.GlobalBool("docker-managed") {
-					displayValues = fmt.Sprintf("%v\t%s", displayValues, strings.Replace(container.Runtime.Name, "/", "", 1))
-				}
-				// show labels values
-				if !clictx.Bool("no-labels") {
-					displayValues = fmt.Sprintf("%v\t%v", displayValues, labelString(container.Labels))
-				}
-				fmt.Fprintf(tw, "%v\n", displayValues)
+			if collapse {
+				listed = append(listed, container)
+				continue
+			}
+
+			if tmpl != nil {
+				out.Template(tmpl, container)
+				continue
+			}
+
+			if isStructuredOutput(output) {
+				out.Object(container)
+				continue
 			}
 
+			displayValues := []string{
+				container.Namespace,
+				container.ContainerType,
+				container.ID,
+				container.Hostname,
+				container.Image,
+				formatTime(container.CreatedAt),
+				fmt.Sprintf("%d", container.ProcessID),
+				container.Status,
+			}
+			// show updated timestamp value
+			if clictx.Bool("updated") {
+				displayValues = append(displayValues, formatTime(container.UpdatedAt))
+			}
+			// show exposed ports value
+			if clictx.Bool("ports") {
+				displayValues = append(displayValues, arrayToString(container.ExposedPorts))
+			}
+			// show docker container name
+			if isDockerRuntime(selectedRuntime(clictx)) {
+				displayValues = append(displayValues, strings.Replace(container.Runtime.Name, "/", "", 1))
+			}
+			// show labels values
+			if !clictx.Bool("no-labels") {
+				displayValues = append(displayValues, labelString(container.Labels))
+			}
+			rw.Write(displayValues...)
 		}
 
+		if collapse {
+			printRestartGroups(out, rw, tmpl, explorers.CollapseRestarts(listed), clictx.Bool("attempts"))
+		}
 		return nil
-	},
+	}),
+}
+
+// printRestartGroups prints the logical containers and their prior restart
+// attempts.
+func printRestartGroups(out *listOutput, rw *rowWriter, tmpl *template.Template, groups []explorers.RestartGroup, attempts bool) {
+	output := out.Format()
+	if tmpl == nil && !isStructuredOutput(output) {
+		rw.Write("NAMESPACE", "POD NAMESPACE", "POD", "CONTAINER", "RESTARTS", "ATTEMPT", "CONTAINER ID", "IMAGE", "CREATED AT", "STATUS")
+	}
+
+	attemptString := func(ctr explorers.Container) string {
+		if attempt := explorers.ContainerAttempt(ctr); attempt >= 0 {
+			return fmt.Sprint(attempt)
+		}
+		return "-"
+	}
+
+	for _, g := range groups {
+		if tmpl != nil {
+			out.Template(tmpl, g)
+			continue
+		}
+		if isStructuredOutput(output) {
+			out.Object(g)
+			continue
+		}
+
+		rw.Write(g.Namespace, g.PodNamespace, g.PodName, g.ContainerName, fmt.Sprint(g.Restarts), attemptString(g.Latest),
+			g.Latest.ID, g.Latest.Image, formatTime(g.Latest.CreatedAt), g.Latest.Status)
+		if !attempts {
+			continue
+		}
+		for _, ctr := range g.Attempts {
+			rw.Write("", "", "", "", "", attemptString(ctr), ctr.ID, ctr.Image, formatTime(ctr.CreatedAt), ctr.Status)
+		}
+	}
+}
+
+// listContainersPage returns a page of containers and the token of the next
+// page.
+//
+// The containers of the explorers that do not implement ContainerPager are
+// paged after listing all the containers.
+func listContainersPage(ctx context.Context, exp explorers.ContainerExplorer, limit int, token string) ([]explorers.Container, string, error) {
+	if pager, ok := exp.(explorers.ContainerPager); ok {
+		return pager.ListContainersPage(ctx, limit, token)
+	}
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return explorers.PageContainers(ctrs, limit, token)
 }
 
 var listImages = cli.Command{
@@ -211,6 +378,7 @@ var listImages = cli.Command{
 	Usage:       "list images for all namespaces",
 	Description: "list images for all namespaces",
 	Flags: []cli.Flag{
+		formatFlag,
 		cli.BoolFlag{
 			Name:  "show-support-containers",
 			Usage: "show Kubernetes support container images",
@@ -224,35 +392,40 @@ var listImages = cli.Command{
 			Usage: "hide image labels",
 		},
 	},
-	Action: func(clictx *cli.Context) error {
+	Action: fleetAction(func(clictx *cli.Context, out *listOutput) error {
 
 		ctx, exp, cancel, err := explorerEnvironment(clictx)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		defer cancel()
 
 		images, err := exp.ListImages(ctx)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
-		defer tw.Flush()
+		output := out.Format()
 
-		output := clictx.GlobalString("output")
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+
+		rw := out.Rows()
+		defer rw.Flush()
 
 		// Setting table output
-		if strings.ToLower(output) == "table" {
-			displayFields := "NAMESPACE\tNAME\tCREATED AT\tDIGEST\tTYPE"
+		if tmpl == nil && !isStructuredOutput(output) {
+			displayFields := []string{"NAMESPACE", "NAME", "CREATED AT", "DIGEST", "TYPE"}
 			if clictx.Bool("updated") {
-				displayFields = fmt.Sprintf("%v\tUPDATED AT", displayFields)
+				displayFields = append(displayFields, "UPDATED AT")
 			}
 			if !clictx.Bool("no-labels") {
-				displayFields = fmt.Sprintf("%v\tLABELS", displayFields)
+				displayFields = append(displayFields, "LABELS")
 			}
 
-			fmt.Fprintf(tw, "%v\n", displayFields)
+			rw.Write(displayFields...)
 		}
 
 		for _, image := range images {
@@ -264,28 +437,33 @@ var listImages = cli.Command{
 				continue
 			}
 
-			switch strings.ToLower(output) {
-			case "json":
-				printAsJSON(image)
-			default:
-				displayValues := fmt.Sprintf("%s\t%s\t%s\t%s\t%s",
-					image.Namespace,
-					image.Name,
-					image.CreatedAt.Format(tsLayout),
-					string(image.Target.Digest),
-					image.Target.MediaType,
-				)
-				if clictx.Bool("updated") {
-					displayValues = fmt.Sprintf("%v\t%s", displayValues, image.UpdatedAt.Format(tsLayout))
-				}
-				if !clictx.Bool("no-labels") {
-					displayValues = fmt.Sprintf("%v\t%s", displayValues, labelString(image.Labels))
-				}
-				fmt.Fprintf(tw, "%v\n", displayValues)
+			if tmpl != nil {
+				out.Template(tmpl, image)
+				continue
 			}
+
+			if isStructuredOutput(output) {
+				out.Object(image)
+				continue
+			}
+
+			displayValues := []string{
+				image.Namespace,
+				image.Name,
+				formatTime(image.CreatedAt),
+				string(image.Target.Digest),
+				image.Target.MediaType,
+			}
+			if clictx.Bool("updated") {
+				displayValues = append(displayValues, formatTime(image.UpdatedAt))
+			}
+			if !clictx.Bool("no-labels") {
+				displayValues = append(displayValues, labelString(image.Labels))
+			}
+			rw.Write(displayValues...)
 		}
 		return nil
-	},
+	}),
 }
 
 var listContent = cli.Command{
@@ -293,46 +471,59 @@ var listContent = cli.Command{
 	Aliases:     []string{"content"},
 	Usage:       "list content for all namespaces",
 	Description: "list content for all namespaces",
-	Action: func(clictx *cli.Context) error {
+	Flags: []cli.Flag{
+		formatFlag,
+	},
+	Action: fleetAction(func(clictx *cli.Context, out *listOutput) error {
 
 		ctx, exp, cancel, err := explorerEnvironment(clictx)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		defer cancel()
 
 		content, err := exp.ListContent(ctx)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
-		defer tw.Flush()
+		output := out.Format()
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
 
-		output := clictx.GlobalString("output")
+		rw := out.Rows()
+		defer rw.Flush()
 
-		if strings.ToLower(output) == "table" {
-			fmt.Fprintf(tw, "NAMESPACE\tDIGEST\tSIZE\tCREATED AT\tUPDATED AT\tLABELS\n")
+		if tmpl == nil && !isStructuredOutput(output) {
+			rw.Write("NAMESPACE", "DIGEST", "SIZE", "CREATED AT", "UPDATED AT", "LABELS")
 		}
 
 		for _, c := range content {
-			switch strings.ToLower(output) {
-			case "json":
-				printAsJSON(c)
-			default:
-				fmt.Fprintf(tw, "%s\t%s\t%v\t%v\t%v\t%s\n",
-					c.Namespace,
-					c.Digest,
-					c.Size,
-					c.CreatedAt.Format(tsLayout),
-					c.UpdatedAt.Format(tsLayout),
-					labelString(c.Labels),
-				)
+			if tmpl != nil {
+				out.Template(tmpl, c)
+				continue
 			}
+
+			if isStructuredOutput(output) {
+				out.Object(c)
+				continue
+			}
+
+			rw.Write(
+				c.Namespace,
+				string(c.Digest),
+				fmt.Sprintf("%v", c.Size),
+				formatTime(c.CreatedAt),
+				formatTime(c.UpdatedAt),
+				labelString(c.Labels),
+			)
 		}
 
 		return nil
-	},
+	}),
 }
 
 var listSnapshots = cli.Command{
@@ -341,6 +532,7 @@ var listSnapshots = cli.Command{
 	Usage:       "list snapshots for all namespaces",
 	Description: "list snapshots for all namespaces",
 	Flags: []cli.Flag{
+		formatFlag,
 		cli.BoolFlag{
 			Name:  "no-labels",
 			Usage: "hide snapshot labels",
@@ -350,65 +542,99 @@ var listSnapshots = cli.Command{
 			Usage: "show overlay full path",
 		},
 	},
-	Action: func(clictx *cli.Context) error {
+	Action: fleetAction(func(clictx *cli.Context, out *listOutput) error {
 
 		ctx, exp, cancel, err := explorerEnvironment(clictx)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		defer cancel()
 
 		ss, err := exp.ListSnapshots(ctx)
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+
+		output := out.Format()
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
 		}
 
-		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
-		defer tw.Flush()
+		rw := out.Rows()
+		defer rw.Flush()
 
-		output := clictx.GlobalString("output")
+		// The remote column is shown only for the lazily pulled snapshots
+		// i.e. stargz.
+		remote := false
+		for _, s := range ss {
+			if s.Remote != "" {
+				remote = true
+			}
+		}
 
 		// Setting table output header
-		if strings.ToLower(output) == "table" {
-			displayFields := "NAMESPACE\tSNAPSHOTTER\tCREATED AT\tUPDATED AT\tKIND\tNAME\tPARENT\tLAYER PATH"
+		if tmpl == nil && !isStructuredOutput(output) {
+			displayFields := []string{"NAMESPACE", "SNAPSHOTTER", "CREATED AT", "UPDATED AT", "KIND", "NAME", "PARENT", "LAYER PATH"}
+			if remote {
+				displayFields = append(displayFields, "REMOTE")
+			}
 			if !clictx.Bool("no-labels") {
-				displayFields = fmt.Sprintf("%s\tLABELS", displayFields)
+				displayFields = append(displayFields, "LABELS")
 			}
-			fmt.Fprintf(tw, "%v\n", displayFields)
+			rw.Write(displayFields...)
 		}
 
 		for _, s := range ss {
 			ssfilepath := filepath.Join(exp.SnapshotRoot(s.Snapshotter), s.OverlayPath)
+			if s.Device != "" {
+				s.OverlayPath = s.Device
+				ssfilepath = s.Device
+			}
+			if s.Dataset != "" {
+				s.OverlayPath = s.Dataset
+				ssfilepath = s.Dataset
+			}
 
-			switch strings.ToLower(output) {
-			case "json":
+			if tmpl != nil {
 				s.OverlayPath = ssfilepath
-				printAsJSON(s)
-			default:
-				if clictx.Bool("full-overlay-path") {
-					s.OverlayPath = ssfilepath
-				}
+				out.Template(tmpl, s)
+				continue
+			}
 
-				displayValue := fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v",
-					s.Namespace,
-					s.Snapshotter,
-					s.CreatedAt.Format(tsLayout),
-					s.UpdatedAt.Format(tsLayout),
-					s.Kind,
-					s.Key,
-					s.Parent,
-					s.OverlayPath,
-				)
-
-				if !clictx.Bool("no-labels") {
-					displayValue = fmt.Sprintf("%v\t%v", displayValue, labelString(s.Labels))
-				}
-				fmt.Fprintf(tw, "%v\n", displayValue)
+			if isStructuredOutput(output) {
+				s.OverlayPath = ssfilepath
+				out.Object(s)
+				continue
 			}
+
+			if clictx.Bool("full-overlay-path") {
+				s.OverlayPath = ssfilepath
+			}
+
+			displayValues := []string{
+				s.Namespace,
+				s.Snapshotter,
+				formatTime(s.CreatedAt),
+				formatTime(s.UpdatedAt),
+				s.Kind.String(),
+				s.Key,
+				s.Parent,
+				s.OverlayPath,
+			}
+			if remote {
+				displayValues = append(displayValues, s.Remote)
+			}
+
+			if !clictx.Bool("no-labels") {
+				displayValues = append(displayValues, labelString(s.Labels))
+			}
+			rw.Write(displayValues...)
 		}
 
 		return nil
-	},
+	}),
 }
 
 var listTasks = cli.Command{
@@ -416,36 +642,177 @@ var listTasks = cli.Command{
 	Aliases:     []string{"task"},
 	Usage:       "list tasks",
 	Description: "list container tasks",
-	Action: func(clictx *cli.Context) error {
+	Flags: []cli.Flag{
+		formatFlag,
+	},
+	Action: fleetAction(func(clictx *cli.Context, out *listOutput) error {
 		ctx, exp, cancel, err := explorerEnvironment(clictx)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		defer cancel()
 
 		tasks, err := exp.ListTasks(ctx)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
-		defer tw.Flush()
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+		if tmpl != nil {
+			for _, t := range tasks {
+				out.Template(tmpl, t)
+			}
+			return nil
+		}
+
+		output := out.Format()
+		if isStructuredOutput(output) {
+			for _, t := range tasks {
+				out.Object(t)
+			}
+			return nil
+		}
+
+		rw := out.Rows()
+		defer rw.Flush()
 
-		displayFields := "NAMESPACE\tCONTAINER ID\tCONTAINER TYPE\tPID\tSTATUS"
-		fmt.Fprintf(tw, "%v\n", displayFields)
+		rw.Write("NAMESPACE", "CONTAINER ID", "CONTAINER TYPE", "PID", "STATUS")
 
 		for _, t := range tasks {
-			displayValues := fmt.Sprintf("%v\t%v\t%v\t%v\t%v",
+			rw.Write(
 				t.Namespace,
 				t.Name,
 				t.ContainerType,
-				t.PID,
+				fmt.Sprintf("%v", t.PID),
 				t.Status,
 			)
-			fmt.Fprintf(tw, "%v\n", displayValues)
 		}
 		return nil
+	}),
+}
+
+var listLeases = cli.Command{
+	Name:        "leases",
+	Aliases:     []string{"lease"},
+	Usage:       "list leases for all namespaces",
+	Description: "list leases for all namespaces",
+	Flags: []cli.Flag{
+		formatFlag,
+	},
+	Action: fleetAction(func(clictx *cli.Context, out *listOutput) error {
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		leases, err := exp.ListLeases(ctx)
+		if err != nil {
+			return err
+		}
+
+		output := out.Format()
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+
+		rw := out.Rows()
+		defer rw.Flush()
+
+		if tmpl == nil && !isStructuredOutput(output) {
+			rw.Write("NAMESPACE", "LEASE ID", "CREATED AT", "RESOURCES", "LABELS")
+		}
+
+		for _, l := range leases {
+			if tmpl != nil {
+				out.Template(tmpl, l)
+				continue
+			}
+
+			if isStructuredOutput(output) {
+				out.Object(l)
+				continue
+			}
+
+			rw.Write(
+				l.Namespace,
+				l.ID,
+				formatTime(l.CreatedAt),
+				fmt.Sprintf("%d", len(l.Resources)),
+				labelString(l.Labels),
+			)
+		}
+
+		return nil
+	}),
+}
+
+var listStoragePools = cli.Command{
+	Name:        "storage-pools",
+	Aliases:     []string{"pools"},
+	Usage:       "list storage pools",
+	Description: "list the storage pools of the runtimes keeping the containers in storage pools i.e. LXD",
+	Flags: []cli.Flag{
+		formatFlag,
 	},
+	Action: fleetAction(func(clictx *cli.Context, out *listOutput) error {
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		lister, ok := exp.(explorers.StoragePoolLister)
+		if !ok {
+			return fmt.Errorf("listing storage pools is not supported for %s", selectedRuntime(clictx))
+		}
+		pools, err := lister.ListStoragePools(ctx)
+		if err != nil {
+			return err
+		}
+
+		output := out.Format()
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+
+		rw := out.Rows()
+		defer rw.Flush()
+
+		if tmpl == nil && !isStructuredOutput(output) {
+			rw.Write("NAME", "DRIVER", "SOURCE", "INSTANCES", "IMAGES", "PATH")
+		}
+
+		for _, p := range pools {
+			if tmpl != nil {
+				out.Template(tmpl, p)
+				continue
+			}
+
+			if isStructuredOutput(output) {
+				out.Object(p)
+				continue
+			}
+
+			rw.Write(
+				p.Name,
+				p.Driver,
+				p.Source,
+				fmt.Sprint(p.Instances),
+				fmt.Sprint(p.Images),
+				p.Path,
+			)
+		}
+		return nil
+	}),
 }
 
 // labelString retruns a string of comma separated key-value pairs.
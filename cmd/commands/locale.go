@@ -0,0 +1,210 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/urfave/cli"
+)
+
+// CSV encodings supported by the global flag --csv-encoding.
+const (
+	encodingUTF8    = "utf-8"
+	encodingUTF8BOM = "utf-8-bom"
+	encodingUTF16LE = "utf-16le"
+	encodingUTF16BE = "utf-16be"
+)
+
+// reportLocale holds the date format and CSV settings of the reports.
+type reportLocale struct {
+	TimeFormat   string
+	Location     *time.Location
+	CSVEncoding  string
+	CSVDelimiter rune
+}
+
+// locale is the report locale configured using the global flags.
+var locale = reportLocale{
+	TimeFormat:   tsLayout,
+	Location:     time.UTC,
+	CSVEncoding:  encodingUTF8,
+	CSVDelimiter: ',',
+}
+
+// localeDefaults are the date formats and CSV delimiters of the locales
+// selected using --locale.
+//
+// The locales using a decimal comma use a semicolon as the CSV delimiter.
+var localeDefaults = map[string]struct {
+	timeFormat string
+	delimiter  rune
+}{
+	"en-US": {"01/02/2006 15:04:05", ','},
+	"en-GB": {"02/01/2006 15:04:05", ','},
+	"de-DE": {"02.01.2006 15:04:05", ';'},
+	"es-ES": {"02/01/2006 15:04:05", ';'},
+	"fr-FR": {"02/01/2006 15:04:05", ';'},
+	"it-IT": {"02/01/2006 15:04:05", ';'},
+	"nl-NL": {"02-01-2006 15:04:05", ';'},
+	"pt-BR": {"02/01/2006 15:04:05", ';'},
+	"ru-RU": {"02.01.2006 15:04:05", ';'},
+	"ja-JP": {"2006/01/02 15:04:05", ','},
+	"ko-KR": {"2006. 01. 02. 15:04:05", ','},
+	"zh-CN": {"2006-01-02 15:04:05", ','},
+}
+
+// namedTimeFormats are the time formats selected by name using
+// --time-format.
+var namedTimeFormats = map[string]string{
+	"default": tsLayout,
+	"rfc3339": time.RFC3339,
+	"rfc1123": time.RFC1123,
+	"iso8601": "2006-01-02T15:04:05-07:00",
+}
+
+// SetupLocale configures the report locale using the global flags
+// --locale, --time-format, --timezone, --csv-encoding, and --csv-delimiter.
+func SetupLocale(clictx *cli.Context) error {
+	if name := clictx.GlobalString("locale"); name != "" {
+		defaults, found := localeDefaults[name]
+		if !found {
+			return fmt.Errorf("unsupported locale %s", name)
+		}
+		locale.TimeFormat = defaults.timeFormat
+		locale.CSVDelimiter = defaults.delimiter
+	}
+
+	if format := clictx.GlobalString("time-format"); format != "" {
+		if layout, found := namedTimeFormats[strings.ToLower(format)]; found {
+			format = layout
+		}
+		locale.TimeFormat = format
+	}
+
+	if tz := clictx.GlobalString("timezone"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return fmt.Errorf("loading timezone %s: %w", tz, err)
+		}
+		locale.Location = loc
+	}
+
+	switch encoding := strings.ToLower(clictx.GlobalString("csv-encoding")); encoding {
+	case "":
+	case encodingUTF8, encodingUTF8BOM, encodingUTF16LE, encodingUTF16BE:
+		locale.CSVEncoding = encoding
+	default:
+		return fmt.Errorf("unsupported CSV encoding %s", encoding)
+	}
+
+	if delimiter := clictx.GlobalString("csv-delimiter"); delimiter != "" {
+		if delimiter == `\t` {
+			delimiter = "\t"
+		}
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' {
+			return fmt.Errorf("invalid CSV delimiter %q", delimiter)
+		}
+		locale.CSVDelimiter = r
+	}
+	return nil
+}
+
+// formatTime returns the time in the report time format and timezone.
+func formatTime(t time.Time) string {
+	return t.In(locale.Location).Format(locale.TimeFormat)
+}
+
+// newCSVWriter returns a CSV writer using the report CSV encoding and
+// delimiter.
+func newCSVWriter(w io.Writer) *csv.Writer {
+	cw := csv.NewWriter(newEncodingWriter(w, locale.CSVEncoding))
+	cw.Comma = locale.CSVDelimiter
+	return cw
+}
+
+// encodingWriter converts UTF-8 to the report CSV encoding. The byte order
+// mark is written before the first write.
+type encodingWriter struct {
+	w        io.Writer
+	encoding string
+	started  bool
+	pending  []byte // incomplete UTF-8 sequence of the previous write
+}
+
+// newEncodingWriter returns a writer converting UTF-8 to the encoding.
+func newEncodingWriter(w io.Writer, encoding string) io.Writer {
+	if encoding == encodingUTF8 {
+		return w
+	}
+	return &encodingWriter{w: w, encoding: encoding}
+}
+
+func (e *encodingWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		var bom []byte
+		switch e.encoding {
+		case encodingUTF8BOM:
+			bom = []byte{0xef, 0xbb, 0xbf}
+		case encodingUTF16LE:
+			bom = []byte{0xff, 0xfe}
+		case encodingUTF16BE:
+			bom = []byte{0xfe, 0xff}
+		}
+		if _, err := e.w.Write(bom); err != nil {
+			return 0, err
+		}
+	}
+
+	if e.encoding == encodingUTF8BOM {
+		return e.w.Write(p)
+	}
+
+	data := append(e.pending, p...)
+	e.pending = nil
+
+	var out []byte
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			e.pending = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+
+		for _, u := range utf16.Encode([]rune{r}) {
+			if e.encoding == encodingUTF16LE {
+				out = append(out, byte(u), byte(u>>8))
+			} else {
+				out = append(out, byte(u>>8), byte(u))
+			}
+		}
+	}
+
+	if _, err := e.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -17,19 +17,40 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"fmt"
 	"runtime"
+	"strings"
 
 	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
 var MountCommand = cli.Command{
-	Name:        "mount",
-	Usage:       "mount a container to a mount point",
-	Description: "mount a container to a mount point",
-	ArgsUsage:   "ID MOUNTPOINT",
+	Name:      "mount",
+	Usage:     "mount a container to a mount point",
+	ArgsUsage: "ID|NAME|PREFIX MOUNTPOINT",
+	Description: `mount a container to a mount point.
+
+   The container is specified using the container ID, the docker container
+   name, or the container hostname, or a unique prefix of the ID or name.
+   The containers of all namespaces are searched unless --namespace is
+   specified. An ambiguous prefix is reported with the matching containers.
+
+   Use mount image to mount the layers of an image without a container, and
+   mount snapshot to mount a snapshot and its parent snapshots.`,
+	Subcommands: cli.Commands{
+		mountImage,
+		mountSnapshot,
+	},
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "pristine",
+			Usage: "mount only the image layers and exclude the container's writable layer",
+		},
+	},
 	Action: func(clictx *cli.Context) error {
 
 		// Mounting a container is only supported on a Linux operating system.
@@ -57,8 +78,22 @@ var MountCommand = cli.Command{
 		}
 		defer cancel()
 
+		namespace, containerid, err = resolveContainer(ctx, clictx, exp, containerid)
+		if err != nil {
+			return err
+		}
 		ctx = namespaces.WithNamespace(ctx, namespace)
 
+		// Mount the pristine image view that excludes the container's
+		// writable (upper) layer.
+		if clictx.Bool("pristine") {
+			_, lowerdirs, err := exp.ContainerLayers(ctx, containerid)
+			if err != nil {
+				return err
+			}
+			return explorers.MountOverlay(lowerdirs, mountpoint)
+		}
+
 		if err := exp.MountContainer(ctx, containerid, mountpoint); err != nil {
 			return err
 		}
@@ -67,3 +102,289 @@ var MountCommand = cli.Command{
 		return nil
 	},
 }
+
+// resolveContainer returns the namespace and ID of the container specified
+// by an ID, a name, or a prefix of an ID or name.
+//
+// An exact ID is preferred over an exact docker container name or hostname,
+// and an exact name is preferred over a prefix. The reference is used as the
+// container ID when the containers cannot be listed.
+func resolveContainer(ctx context.Context, clictx *cli.Context, exp explorers.ContainerExplorer, ref string) (string, string, error) {
+	namespace := clictx.GlobalString("namespace")
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err != nil {
+		log.WithField("containerid", ref).Warn("listing containers to resolve the container ID: ", err)
+		return namespace, ref, nil
+	}
+
+	if clictx.GlobalIsSet("namespace") {
+		var scoped []explorers.Container
+		for _, ctr := range ctrs {
+			if ctr.Namespace == "" || ctr.Namespace == namespace {
+				scoped = append(scoped, ctr)
+			}
+		}
+		ctrs = scoped
+	}
+
+	var matches []explorers.Container
+	for _, match := range []func(explorers.Container) bool{
+		func(ctr explorers.Container) bool {
+			return ctr.ID == ref
+		},
+		func(ctr explorers.Container) bool {
+			for _, name := range containerNames(ctr) {
+				if name == ref {
+					return true
+				}
+			}
+			return false
+		},
+		func(ctr explorers.Container) bool {
+			if matchID(ctr.ID, ref) {
+				return true
+			}
+			for _, name := range containerNames(ctr) {
+				if strings.HasPrefix(name, ref) {
+					return true
+				}
+			}
+			return false
+		},
+	} {
+		for _, ctr := range ctrs {
+			if match(ctr) {
+				matches = append(matches, ctr)
+			}
+		}
+		if len(matches) > 0 {
+			break
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", "", fmt.Errorf("no container matches %s. Use list containers", ref)
+	case 1:
+		ctr := matches[0]
+		if ctr.ID != ref {
+			log.WithFields(log.Fields{
+				"reference":   ref,
+				"namespace":   ctr.Namespace,
+				"containerid": ctr.ID,
+			}).Info("resolved container")
+		}
+		if ctr.Namespace != "" {
+			namespace = ctr.Namespace
+		}
+		return namespace, ctr.ID, nil
+	}
+
+	var candidates []string
+	for _, ctr := range matches {
+		candidate := fmt.Sprintf("%s/%s", ctr.Namespace, ctr.ID)
+		if names := containerNames(ctr); len(names) > 0 {
+			candidate = fmt.Sprintf("%s (%s)", candidate, strings.Join(names, ", "))
+		}
+		candidates = append(candidates, candidate)
+	}
+	return "", "", fmt.Errorf("%s matches %d containers: %s. Use a longer prefix or --namespace", ref, len(matches), strings.Join(candidates, "; "))
+}
+
+// containerNames returns the docker container name and the hostname of a
+// container.
+func containerNames(ctr explorers.Container) []string {
+	var names []string
+	if ctr.ContainerType == "docker" {
+		if name := strings.TrimPrefix(ctr.Runtime.Name, "/"); name != "" {
+			names = append(names, name)
+		}
+	}
+	if ctr.Hostname != "" {
+		names = append(names, ctr.Hostname)
+	}
+	return names
+}
+
+var mountImage = cli.Command{
+	Name:      "image",
+	Usage:     "mount the layers of an image to a mount point",
+	ArgsUsage: "NAME|DIGEST MOUNTPOINT",
+	Description: `mount the layer chain of an image read-only to a mount point without
+   the writable layer of any container. The pristine image can be compared
+   against the root filesystem of a running container.
+
+   The image is specified using the image name, the image target digest, or
+   a unique prefix of the digest. The layers must be unpacked i.e. used by
+   a container or pulled by the container runtime.`,
+	Action: func(clictx *cli.Context) error {
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("mounting an image is only supported on Linux")
+		}
+
+		if clictx.NArg() < 2 {
+			return fmt.Errorf("image name or digest and mount point are required")
+		}
+		ref := clictx.Args().First()
+		mountpoint := clictx.Args().Get(1)
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		image, err := resolveImage(ctx, exp, ref)
+		if err != nil {
+			return err
+		}
+
+		detail, err := exp.InspectImage(namespaces.WithNamespace(ctx, image.Namespace), image.Name)
+		if err != nil {
+			return err
+		}
+		if len(detail.Layers) == 0 {
+			return fmt.Errorf("image %s has no layers", detail.Name)
+		}
+
+		// The image layers are ordered from bottom to top.
+		var layers []string
+		for i := len(detail.Layers) - 1; i >= 0; i-- {
+			layer := detail.Layers[i]
+			if layer.Dir == "" || !explorers.PathExists(layer.Dir, false) {
+				return fmt.Errorf("layer %d (%s) of image %s is not unpacked", i, layer.DiffID, detail.Name)
+			}
+			layers = append(layers, layer.Dir)
+		}
+
+		log.WithFields(log.Fields{
+			"image":      detail.Name,
+			"target":     detail.Target,
+			"layers":     len(layers),
+			"mountpoint": mountpoint,
+		}).Debug("mounting image layers")
+
+		return explorers.MountOverlay(layers, mountpoint)
+	},
+}
+
+var mountSnapshot = cli.Command{
+	Name:      "snapshot",
+	Usage:     "mount a snapshot and its parent snapshots to a mount point",
+	ArgsUsage: "SNAPSHOTTER/KEY MOUNTPOINT",
+	Description: `mount an active or committed snapshot and the chain of its parent
+   snapshots read-only to a mount point i.e. overlayfs/<key>.
+
+   The parent chain is read from the snapshot database metadata.db, so the
+   snapshot is mounted when the container records are damaged but the
+   snapshots survive. A committed snapshot mounts the image layers up to an
+   intermediate layer.
+
+   The snapshot is specified using the snapshot key listed by list
+   snapshots, the snapshot name in metadata.db i.e. k8s.io/12/<key>, or the
+   key part of a snapshot name.`,
+	Action: func(clictx *cli.Context) error {
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("mounting a snapshot is only supported on Linux")
+		}
+
+		if clictx.NArg() < 2 {
+			return fmt.Errorf("snapshotter/key and mount point are required")
+		}
+		ref := clictx.Args().First()
+		mountpoint := clictx.Args().Get(1)
+
+		snapshotter, key, err := parseSnapshotRef(ref)
+		if err != nil {
+			return err
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		se, ok := exp.(explorers.SnapshotLayerExplorer)
+		if !ok {
+			return fmt.Errorf("mounting a snapshot is only supported for containerd")
+		}
+		if clictx.GlobalIsSet("namespace") {
+			ctx = namespaces.WithNamespace(ctx, clictx.GlobalString("namespace"))
+		}
+
+		dir, parents, err := se.SnapshotLayers(ctx, snapshotter, key)
+		if err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"snapshotter": snapshotter,
+			"key":         key,
+			"layers":      len(parents) + 1,
+			"mountpoint":  mountpoint,
+		}).Debug("mounting snapshot layers")
+
+		return explorers.MountOverlay(append([]string{dir}, parents...), mountpoint)
+	},
+}
+
+// parseSnapshotRef returns the snapshotter and the key of a snapshot
+// specified as <snapshotter>/<key>.
+func parseSnapshotRef(ref string) (string, string, error) {
+	i := strings.Index(ref, "/")
+	if i <= 0 || i == len(ref)-1 {
+		return "", "", fmt.Errorf("snapshot %s must be specified as <snapshotter>/<key>", ref)
+	}
+	return ref[:i], ref[i+1:], nil
+}
+
+// resolveImage returns the image specified by a name, a target digest, or a
+// prefix of the target digest.
+//
+// The names tagging the same target digest are the same image. An ambiguous
+// digest prefix is reported with the matching images.
+func resolveImage(ctx context.Context, exp explorers.ContainerExplorer, ref string) (explorers.Image, error) {
+	images, err := exp.ListImages(ctx)
+	if err != nil {
+		return explorers.Image{}, err
+	}
+
+	var matches []explorers.Image
+	for _, match := range []func(explorers.Image) bool{
+		func(image explorers.Image) bool {
+			return explorers.MatchImageName(image.Name, ref)
+		},
+		func(image explorers.Image) bool {
+			digest := string(image.Target.Digest)
+			return digest == ref || matchID(strings.TrimPrefix(digest, "sha256:"), strings.TrimPrefix(ref, "sha256:"))
+		},
+	} {
+		for _, image := range images {
+			if match(image) {
+				matches = append(matches, image)
+			}
+		}
+		if len(matches) > 0 {
+			break
+		}
+	}
+
+	if len(matches) == 0 {
+		return explorers.Image{}, fmt.Errorf("no image matches %s. Use list images", ref)
+	}
+
+	targets := make(map[string]bool)
+	var candidates []string
+	for _, image := range matches {
+		if !targets[string(image.Target.Digest)] {
+			targets[string(image.Target.Digest)] = true
+			candidates = append(candidates, fmt.Sprintf("%s (%s)", image.Name, image.Target.Digest))
+		}
+	}
+	if len(targets) > 1 {
+		return explorers.Image{}, fmt.Errorf("%s matches %d images: %s. Use a longer digest prefix", ref, len(targets), strings.Join(candidates, "; "))
+	}
+	return matches[0], nil
+}
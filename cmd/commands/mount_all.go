@@ -20,20 +20,33 @@ import (
 	"fmt"
 	"runtime"
 
+	"github.com/google/container-explorer/explorers"
 	"github.com/urfave/cli"
 )
 
 var MountAllCommand = cli.Command{
-	Name:        "mount-all",
-	Aliases:     []string{"mount_all"},
-	Usage:       "mount all containers",
-	Description: "mount all containers to subdirectories with the specified mount point",
-	ArgsUsage:   "[flag] MOUNT_POINT",
+	Name:    "mount-all",
+	Aliases: []string{"mount_all"},
+	Usage:   "mount all containers",
+	Description: `mount all containers to subdirectories with the specified mount point.
+
+   A container failing to mount does not stop the run. The result of each
+   container, and the reason of a failed or skipped mount, is recorded in
+   index.json of the mount point and printed at the end of the run.
+
+   Use --retry-failed to resume a previous run using its index.json. The
+   containers still mounted are kept, and only the containers that failed
+   or were not attempted are mounted.`,
+	ArgsUsage: "[flag] MOUNT_POINT",
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "mount-support-containers",
 			Usage: "mount Kubernetes supporting containers",
 		},
+		cli.BoolFlag{
+			Name:  "retry-failed",
+			Usage: "mount only the containers that failed or were not attempted by the previous run",
+		},
 	},
 	Action: func(clictx *cli.Context) error {
 		// Mounting a container is only supported on a Linux operating system.
@@ -53,10 +66,45 @@ var MountAllCommand = cli.Command{
 		}
 		defer cancel()
 
-		if err := exp.MountAllContainers(ctx, mountpoint, !clictx.Bool("mount-support-containers")); err != nil {
+		entries, err := exp.MountAllContainers(ctx, mountpoint, explorers.MountAllOptions{
+			SkipSupportContainers: !clictx.Bool("mount-support-containers"),
+			RetryFailed:           clictx.Bool("retry-failed"),
+		})
+		if err != nil {
 			return err
 		}
-		// default
+
+		failed := 0
+		for _, entry := range entries {
+			if entry.Status == explorers.MountStatusFailed {
+				failed++
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, entry := range entries {
+				printObject(output, entry)
+			}
+		} else {
+			rw := newRowWriter(output)
+			rw.Write("DIRECTORY", "NAMESPACE", "CONTAINER ID", "STATUS", "ATTEMPTS", "REASON")
+			for _, entry := range entries {
+				rw.Write(
+					entry.Directory,
+					entry.Namespace,
+					entry.ContainerID,
+					entry.Status,
+					fmt.Sprint(entry.Attempts),
+					entry.Reason,
+				)
+			}
+			rw.Flush()
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d containers failed to mount. Use --retry-failed to retry them", failed, len(entries))
+		}
 		return nil
 	},
 }
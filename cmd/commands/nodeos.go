@@ -0,0 +1,144 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+)
+
+// bottlerocketDataMounts are the directories of the Bottlerocket data
+// partition bind mounted on the root file system.
+var bottlerocketDataMounts = []string{"var", "opt", "mnt"}
+
+// isBottlerocketRoot returns true if the directory is a Bottlerocket root
+// partition i.e. BOTTLEROCKET-ROOT-A.
+func isBottlerocketRoot(dir string) bool {
+	return osReleaseValue(dir, "ID") == "bottlerocket"
+}
+
+// isBottlerocketData returns true if the directory is the Bottlerocket data
+// partition i.e. BOTTLEROCKET-DATA holding the API datastore.
+func isBottlerocketData(dir string) bool {
+	return explorers.PathExists(filepath.Join(dir, "var", "lib", "bottlerocket"), false) && !isOSRoot(dir)
+}
+
+// bottlerocketImageRoot returns the OS root composed of a Bottlerocket root
+// partition and the data partition.
+//
+// The root partition is a read-only dm-verity file system. The data
+// partition is mounted on /local and its var, opt, and mnt directories are
+// bind mounted on the root file system. /etc is a volatile file system
+// rendered from the API datastore at boot.
+//
+// Bottlerocket updates the inactive root partition of the A/B partitions. The
+// partition flags selecting the active partition are not part of a mounted
+// file system, so the root partition with the most recent version is used.
+func bottlerocketImageRoot(osroots []string, data string) (imageRootLayout, error) {
+	var (
+		root    string
+		version string
+	)
+	for _, dir := range osroots {
+		if !isBottlerocketRoot(dir) {
+			continue
+		}
+		if v := osReleaseValue(dir, "VERSION_ID"); root == "" || compareVersions(v, version) > 0 {
+			root, version = dir, v
+		}
+	}
+	if root == "" {
+		return imageRootLayout{}, fmt.Errorf("no Bottlerocket root partition found for data partition %s", data)
+	}
+	if len(osroots) > 1 {
+		log.WithFields(log.Fields{
+			"roots":   osroots,
+			"root":    root,
+			"version": version,
+		}).Warn("several Bottlerocket root partitions found. Using the most recent version")
+	}
+
+	mounts := map[string]string{"local": data}
+	for _, dir := range bottlerocketDataMounts {
+		if path := filepath.Join(data, dir); explorers.PathExists(path, false) {
+			mounts[dir] = path
+		}
+	}
+
+	composed, err := composeImageRoot([]string{root}, mounts)
+	if err != nil {
+		return imageRootLayout{}, err
+	}
+	return imageRootLayout{Root: composed}, nil
+}
+
+// isTalosState returns true if the directory is the Talos STATE partition
+// holding the machine configuration config.yaml.
+//
+// Talos runs from a read-only SquashFS root file system loaded from the boot
+// partition. The STATE partition is mounted on /system/state and the
+// EPHEMERAL partition holding the containerd root is mounted on /var.
+func isTalosState(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(data, []byte("version: v1alpha1")) && bytes.Contains(data, []byte("machine:"))
+}
+
+// osReleaseValue returns the value of a key of the os-release file within
+// the OS root or an empty string.
+func osReleaseValue(dir string, key string) string {
+	for _, p := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		data, _, err := explorers.ReadHostFile(dir, p)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, key+"=") {
+				return strings.Trim(strings.TrimPrefix(line, key+"="), `"'`)
+			}
+		}
+	}
+	return ""
+}
+
+// compareVersions compares two dotted versions numerically i.e. 1.20.3 and
+// 1.9.0. It returns a negative number, zero, or a positive number.
+func compareVersions(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
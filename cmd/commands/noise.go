@@ -0,0 +1,116 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/noise"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var listNoiseProfiles = cli.Command{
+	Name:        "noise-profiles",
+	Usage:       "list noise suppression profiles",
+	Description: "list the built-in and loaded noise profiles used by list containers --workloads-only",
+	Action: func(clictx *cli.Context) error {
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, p := range noise.Profiles() {
+				printObject(output, p)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+
+		rw.Write("NAME", "IMAGES", "NAMES", "LABELS", "DESCRIPTION")
+		for _, p := range noise.Profiles() {
+			rw.Write(
+				p.Name,
+				fmt.Sprint(len(p.ImageNames)),
+				fmt.Sprint(len(p.ContainerNames)),
+				fmt.Sprint(len(p.Labels)),
+				p.Description,
+			)
+		}
+		return nil
+	},
+}
+
+// LoadNoiseProfiles registers the noise profiles specified using the global
+// flag --noise-profile-file. A profile replaces the built-in profile with
+// the same name.
+func LoadNoiseProfiles(clictx *cli.Context) error {
+	for _, path := range clictx.GlobalStringSlice("noise-profile-file") {
+		if err := noise.Load(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectedNoiseProfiles returns the noise profiles specified using the flag
+// --noise-profiles. All the registered profiles are returned when the flag
+// is not specified.
+func selectedNoiseProfiles(clictx *cli.Context) ([]noise.Profile, error) {
+	if clictx.String("noise-profiles") == "" {
+		return noise.Profiles(), nil
+	}
+
+	var list []noise.Profile
+	for _, name := range strings.Split(clictx.String("noise-profiles"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p, found := noise.Get(name)
+		if !found {
+			return nil, fmt.Errorf("unknown noise profile %s. Use list noise-profiles", name)
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// skipNoisyWorkload returns true if the container is a support container or
+// belongs to one of the noise profiles.
+func skipNoisyWorkload(profiles []noise.Profile, ctr explorers.Container) bool {
+	if ctr.SupportContainer {
+		log.WithFields(log.Fields{
+			"namespace":   ctr.Namespace,
+			"containerid": ctr.ID,
+		}).Info("skip support container")
+		return true
+	}
+
+	p, found := noise.Match(profiles, ctr)
+	if !found {
+		return false
+	}
+	log.WithFields(log.Fields{
+		"namespace":   ctr.Namespace,
+		"containerid": ctr.ID,
+		"image":       ctr.Image,
+		"profile":     p.Name,
+	}).Info("skip noisy workload")
+	return true
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Output formats supported by the global flag --output.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputCSV   = "csv"
+)
+
+// rowWriter writes the header and rows as a tab separated table or as
+// RFC4180 CSV.
+type rowWriter struct {
+	tw *tabwriter.Writer
+	cw *csv.Writer
+
+	// fleet is true for the writer shared by the hosts of a fleet action.
+	// The rows are prefixed with the image root of the host. The first row
+	// written for a host is the header and is written only if it differs
+	// from the header of the previous host.
+	fleet      bool
+	source     string
+	header     bool
+	lastHeader string
+}
+
+// newRowWriter returns a rowWriter for the output format. CSV is used for
+// the csv format and table is used for other formats. The CSV encoding and
+// delimiter are configured using the report locale.
+func newRowWriter(format string) *rowWriter {
+	if strings.ToLower(format) == outputCSV {
+		return &rowWriter{
+			cw: newCSVWriter(os.Stdout),
+		}
+	}
+	return &rowWriter{
+		tw: tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0),
+	}
+}
+
+// Write writes a header or a row.
+func (w *rowWriter) Write(fields ...string) {
+	if w.fleet {
+		if w.header {
+			w.header = false
+			header := strings.Join(fields, "\t")
+			if header == w.lastHeader {
+				return
+			}
+			w.lastHeader = header
+			fields = append([]string{"HOST"}, fields...)
+		} else {
+			fields = append([]string{w.source}, fields...)
+		}
+	}
+
+	if w.cw != nil {
+		if err := w.cw.Write(fields); err != nil {
+			log.Error("writing CSV row: ", err)
+		}
+		return
+	}
+	fmt.Fprintf(w.tw, "%v\n", strings.Join(fields, "\t"))
+}
+
+// setSource sets the image root of the next host of a fleet action. The
+// next row written is the header of the host.
+func (w *rowWriter) setSource(root string) {
+	w.source = root
+	w.header = true
+}
+
+// Flush writes the buffered data to stdout. The writer shared by the hosts
+// of a fleet action is flushed after the last host.
+func (w *rowWriter) Flush() {
+	if w.fleet {
+		return
+	}
+	w.flush()
+}
+
+// flush writes the buffered data to stdout.
+func (w *rowWriter) flush() {
+	if w.cw != nil {
+		w.cw.Flush()
+		return
+	}
+	w.tw.Flush()
+}
+
+// isStructuredOutput returns true if the output format prints each object
+// rather than rows of fields.
+func isStructuredOutput(format string) bool {
+	switch strings.ToLower(format) {
+	case outputJSON, outputJSONL:
+		return true
+	}
+	return false
+}
+
+// printObject prints v as a compact single line JSON for the jsonl format
+// and as indented JSON for other formats.
+func printObject(format string, v interface{}) {
+	if strings.ToLower(format) != outputJSONL {
+		printAsJSON(v)
+		return
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Error("error marshalling to JSON", err)
+		return
+	}
+	fmt.Println(string(b))
+}
+
+// templateFuncs are the functions available to the Go templates specified
+// using --format.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	},
+	"join":  strings.Join,
+	"split": strings.Split,
+	"date":  formatTime,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// formatFlag formats the output of a command using a Go template.
+var formatFlag = cli.StringFlag{
+	Name:  "format, f",
+	Usage: "format the output using the given Go template i.e. '{{.ID}} {{.Image}}'",
+}
+
+// formatTemplate returns the Go template specified using --format. The
+// returned template is nil if the flag is not specified.
+func formatTemplate(clictx *cli.Context) (*template.Template, error) {
+	format := clictx.String("format")
+	if format == "" {
+		return nil, nil
+	}
+	return newTemplate(format)
+}
+
+// newTemplate returns the Go template specified using --format i.e.
+// '{{.ID}} {{.Image}}'.
+func newTemplate(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("parsing format template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// printTemplate prints v using the template followed by a new line.
+func printTemplate(tmpl *template.Template, v interface{}) {
+	if err := tmpl.Execute(os.Stdout, v); err != nil {
+		log.Error("executing format template: ", err)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// pathsFromFlag scopes the commands walking the container filesystems to
+// the paths of a file list.
+var pathsFromFlag = cli.StringFlag{
+	Name:  "paths-from",
+	Usage: "process only the container paths listed in the file i.e. find, fls, or bodyfile output. A path ending with / includes the directory content",
+}
+
+// pathScope returns the file list specified using --paths-from or nil if
+// the flag is not specified.
+func pathScope(clictx *cli.Context) (*explorers.PathList, error) {
+	listfile := clictx.String("paths-from")
+	if listfile == "" {
+		return nil, nil
+	}
+	scope, err := explorers.ReadPathList(listfile)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"file":    listfile,
+		"entries": scope.Len(),
+	}).Info("scoping container filesystem walk to file list")
+	return scope, nil
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	previewText = "text"
+	previewHex  = "hex"
+)
+
+// peekedFile is a file listed by peek.
+type peekedFile struct {
+	Path       string    `json:"path"`
+	Mode       string    `json:"mode"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Layer      int       `json:"layer"`
+	Target     string    `json:"target,omitempty"` // symbolic link target
+
+	Preview       string `json:"preview,omitempty"`
+	PreviewFormat string `json:"preview_format,omitempty"` // text or hex
+	PreviewBytes  int    `json:"preview_bytes,omitempty"`
+	Truncated     bool   `json:"truncated,omitempty"`
+}
+
+var PeekCommand = cli.Command{
+	Name:  "peek",
+	Usage: "preview the files of a container without mounting",
+	Description: `list a directory or a file of a container and print bounded previews of
+   the files without mounting or exporting the container filesystem.
+
+   Only the directories on the path and at most --max-bytes of each
+   previewed file are read, so peek is fast on slow evidence storage. The
+   symbolic links of the parent directories are resolved within the
+   container.
+
+   A file is previewed as text when it is valid UTF-8 without control
+   characters and as a hexdump otherwise, so the escape sequences of a
+   file are never written to the terminal. The regular files of a directory
+   are previewed up to --max-files.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "container ID",
+		},
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "directory or file path within the container",
+			Value: "/",
+		},
+		cli.StringFlag{
+			Name:  "max-bytes",
+			Usage: "maximum bytes previewed per file i.e. 512, 4k, or 1m",
+			Value: "4k",
+		},
+		cli.IntFlag{
+			Name:  "max-files",
+			Usage: "maximum files previewed in a directory. 0 lists the directory only",
+			Value: 10,
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		containerid := clictx.String("id")
+		if containerid == "" {
+			return fmt.Errorf("container id is required")
+		}
+		maxbytes, err := parseByteSize(clictx.String("max-bytes"))
+		if err != nil {
+			return fmt.Errorf("invalid --max-bytes: %w", err)
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctx = namespaces.WithNamespace(ctx, clictx.GlobalString("namespace"))
+
+		upperdir, lowerdirs, err := exp.ContainerLayers(ctx, containerid)
+		if err != nil {
+			return err
+		}
+		layers := append([]string{upperdir}, lowerdirs...)
+
+		f, err := explorers.LookupLayerPath(layers, clictx.String("path"))
+		if err != nil {
+			return err
+		}
+
+		files := []explorers.LayerFile{f}
+		maxfiles := 1
+		if f.Info.IsDir() {
+			if files, err = explorers.ReadLayerDir(layers, f.Path); err != nil {
+				return err
+			}
+			maxfiles = clictx.Int("max-files")
+		}
+
+		var peeked []peekedFile
+		previewed := 0
+		for _, lf := range files {
+			pf := peekedFile{
+				Path:       lf.Path,
+				Mode:       lf.Info.Mode().String(),
+				Size:       lf.Info.Size(),
+				ModifiedAt: lf.Info.ModTime().UTC(),
+				Layer:      lf.Layer,
+			}
+			if lf.Info.Mode()&os.ModeSymlink != 0 {
+				pf.Target, _ = os.Readlink(lf.LayerPath)
+			}
+			if lf.Info.Mode().IsRegular() && previewed < maxfiles && maxbytes > 0 {
+				previewed++
+				if err := previewFile(&pf, lf.LayerPath, maxbytes); err != nil {
+					log.WithField("path", lf.Path).Warn("previewing file: ", err)
+				}
+			}
+			peeked = append(peeked, pf)
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, pf := range peeked {
+				printObject(output, pf)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		rw.Write("MODE", "SIZE", "MODIFIED", "LAYER", "PATH")
+		for _, pf := range peeked {
+			path := pf.Path
+			if pf.Target != "" {
+				path = fmt.Sprintf("%s -> %s", pf.Path, pf.Target)
+			}
+			rw.Write(pf.Mode, fmt.Sprint(pf.Size), formatTime(pf.ModifiedAt), fmt.Sprint(pf.Layer), path)
+		}
+		rw.Flush()
+
+		for _, pf := range peeked {
+			if pf.PreviewFormat == "" {
+				continue
+			}
+			suffix := ""
+			if pf.Truncated {
+				suffix = ", truncated"
+			}
+			fmt.Printf("\n==> %s (%d of %d bytes, %s%s) <==\n", pf.Path, pf.PreviewBytes, pf.Size, pf.PreviewFormat, suffix)
+			fmt.Print(pf.Preview)
+			if !strings.HasSuffix(pf.Preview, "\n") {
+				fmt.Println()
+			}
+		}
+		return nil
+	},
+}
+
+// previewFile reads at most maxbytes of a file and sets the preview.
+func previewFile(pf *peekedFile, path string, maxbytes int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxbytes))
+	if err != nil {
+		return err
+	}
+
+	pf.PreviewBytes = len(data)
+	pf.Truncated = int64(len(data)) < pf.Size
+	if isPrintableText(data, pf.Truncated) {
+		pf.Preview = string(data)
+		pf.PreviewFormat = previewText
+	} else {
+		pf.Preview = hex.Dump(data)
+		pf.PreviewFormat = previewHex
+	}
+	return nil
+}
+
+// isPrintableText returns true if data is valid UTF-8 without control
+// characters other than tabs and newlines. The last rune of truncated data
+// may be incomplete.
+func isPrintableText(data []byte, truncated bool) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 {
+			return truncated && !utf8.FullRune(data)
+		}
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
+
+// parseByteSize parses a size in bytes with an optional k, m, or g binary
+// suffix i.e. 64k.
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "b"), "i")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,430 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/knowledge"
+	"github.com/urfave/cli"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+)
+
+// preflightCheck holds the result of a single pre-flight check.
+type preflightCheck struct {
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Detail      string `json:"detail"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// preflightReport holds the results of all pre-flight checks.
+type preflightReport struct {
+	Ready  bool             `json:"ready"`
+	Checks []preflightCheck `json:"checks"`
+}
+
+var PreflightCommand = cli.Command{
+	Name:        "preflight",
+	Usage:       "validate the evidence layout before analysis",
+	Description: "validate required paths, database files, snapshotter directories, free disk space, and kernel features before analysis",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output-dir",
+			Usage: "directory where exported or mounted data will be written",
+		},
+		cli.Uint64Flag{
+			Name:  "min-free-space",
+			Usage: "minimum free space in MiB required in output directory",
+			Value: 1024,
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		imageroot := clictx.GlobalString("image-root")
+
+		var checks []preflightCheck
+
+		if isDockerRuntime(selectedRuntime(clictx)) {
+			dockerroot := dockerRoot(clictx)
+			checks = append(checks, checkDockerLayout(dockerroot)...)
+		} else {
+			containerdroot, metadatafile, snapshotfile := resolveContainerdPaths(
+				imageroot,
+				containerdRoot(clictx),
+				clictx.GlobalString("metadata-file"),
+				clictx.GlobalString("snapshot-metadata-file"),
+			)
+			checks = append(checks, checkContainerdLayout(containerdroot, metadatafile, snapshotfile)...)
+		}
+		checks = append(checks, checkDistroArtifacts(imageroot, selectedPack(clictx))...)
+
+		if outputdir := clictx.String("output-dir"); outputdir != "" {
+			checks = append(checks, checkFreeSpace(outputdir, clictx.Uint64("min-free-space")))
+		}
+		checks = append(checks, checkKernelFeatures(clictx.GlobalBool("fuse"))...)
+
+		report := preflightReport{
+			Ready:  true,
+			Checks: checks,
+		}
+		for _, c := range checks {
+			if c.Status == checkFail {
+				report.Ready = false
+			}
+		}
+
+		if output := clictx.GlobalString("output"); isStructuredOutput(output) {
+			printObject(output, report)
+		} else {
+			printPreflightReport(report)
+		}
+
+		if !report.Ready {
+			return fmt.Errorf("pre-flight checks failed")
+		}
+		return nil
+	},
+}
+
+// printPreflightReport prints the pre-flight checks as a table followed by a
+// go/no-go summary.
+func printPreflightReport(report preflightReport) {
+	tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, '\t', 0)
+	fmt.Fprintf(tw, "STATUS\tCHECK\tDETAIL\n")
+	for _, c := range report.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Status, c.Name, c.Detail)
+	}
+	tw.Flush()
+
+	var hints []string
+	for _, c := range report.Checks {
+		if c.Status != checkPass && c.Remediation != "" {
+			hints = append(hints, fmt.Sprintf("  - %s: %s", c.Name, c.Remediation))
+		}
+	}
+	if len(hints) > 0 {
+		fmt.Printf("\nREMEDIATION\n%s\n", strings.Join(hints, "\n"))
+	}
+
+	if report.Ready {
+		fmt.Printf("\nSUMMARY: GO\n")
+	} else {
+		fmt.Printf("\nSUMMARY: NO-GO\n")
+	}
+}
+
+// checkDistroArtifacts checks the configuration and log files described by
+// the knowledge pack of the distribution i.e. k3s. A missing file is a
+// warning.
+func checkDistroArtifacts(imageroot string, p knowledge.Pack) []preflightCheck {
+	if imageroot == "" {
+		return nil
+	}
+
+	var checks []preflightCheck
+	for _, path := range append(append([]string{}, p.ConfigPaths...), p.LogPaths...) {
+		c := preflightCheck{
+			Name:   fmt.Sprintf("%s artifact", p.Name),
+			Status: checkPass,
+			Detail: path,
+		}
+		if _, err := os.Stat(filepath.Join(imageroot, path)); err != nil {
+			c.Status = checkWarn
+			c.Detail = fmt.Sprintf("%s does not exist", path)
+			c.Remediation = fmt.Sprintf("the %s configuration and logs are incomplete. Collect %s if available", p.Name, path)
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// checkContainerdLayout validates containerd root directory, metadata
+// database, snapshot database, and snapshotter directories.
+func checkContainerdLayout(containerdroot string, metadatafile string, snapshotfile string) []preflightCheck {
+	var checks []preflightCheck
+
+	if !explorers.PathExists(containerdroot, false) {
+		return append(checks, preflightCheck{
+			Name:        "containerd root",
+			Status:      checkFail,
+			Detail:      fmt.Sprintf("%s does not exist", containerdroot),
+			Remediation: "use --image-root or --containerd-root to specify the containerd root directory",
+		})
+	}
+	checks = append(checks, preflightCheck{
+		Name:   "containerd root",
+		Status: checkPass,
+		Detail: containerdroot,
+	})
+
+	checks = append(checks, checkBoltDatabase("metadata database", metadatafile, "use --metadata-file to specify the path to meta.db"))
+	checks = append(checks, checkBoltDatabase("snapshot database", snapshotfile, "use --snapshot-metadata-file to specify the path to metadata.db"))
+
+	snapshotterdirs, _ := filepath.Glob(filepath.Join(containerdroot, "io.containerd.snapshotter.v1.*"))
+	if len(snapshotterdirs) == 0 {
+		checks = append(checks, preflightCheck{
+			Name:        "snapshotter directories",
+			Status:      checkFail,
+			Detail:      fmt.Sprintf("no snapshotter directory found in %s", containerdroot),
+			Remediation: "verify the evidence contains the containerd snapshotter directories",
+		})
+	}
+	for _, dir := range snapshotterdirs {
+		checks = append(checks, checkReadableDir(fmt.Sprintf("snapshotter %s", filepath.Base(dir)), dir))
+	}
+
+	return checks
+}
+
+// checkDockerLayout validates docker root directory and the directories
+// required to explore docker managed containers.
+func checkDockerLayout(dockerroot string) []preflightCheck {
+	var checks []preflightCheck
+
+	if !explorers.PathExists(dockerroot, false) {
+		return append(checks, preflightCheck{
+			Name:        "docker root",
+			Status:      checkFail,
+			Detail:      fmt.Sprintf("%s does not exist", dockerroot),
+			Remediation: "use --image-root or --docker-root to specify the docker root directory",
+		})
+	}
+	checks = append(checks, preflightCheck{
+		Name:   "docker root",
+		Status: checkPass,
+		Detail: dockerroot,
+	})
+
+	checks = append(checks, checkReadableDir("docker containers", filepath.Join(dockerroot, "containers")))
+	checks = append(checks, checkReadableDir("docker images", filepath.Join(dockerroot, "image")))
+
+	storagedirs, _ := filepath.Glob(filepath.Join(dockerroot, "image", "*"))
+	for _, storagedir := range storagedirs {
+		driver := filepath.Base(storagedir)
+		checks = append(checks, checkReadableDir(fmt.Sprintf("storage driver %s", driver), filepath.Join(dockerroot, driver)))
+	}
+
+	return checks
+}
+
+// checkBoltDatabase verifies a bolt database can be opened and parsed.
+func checkBoltDatabase(name string, path string, remediation string) preflightCheck {
+	if !explorers.PathExists(path, true) {
+		return preflightCheck{
+			Name:        name,
+			Status:      checkFail,
+			Detail:      fmt.Sprintf("%s does not exist", path),
+			Remediation: remediation,
+		}
+	}
+
+	db, err := explorers.OpenBolt(path, 5*time.Second)
+	if err != nil {
+		return preflightCheck{
+			Name:        name,
+			Status:      checkFail,
+			Detail:      fmt.Sprintf("opening %s: %v", path, err),
+			Remediation: "copy the database file to a writable location or verify it is not locked or corrupted",
+		}
+	}
+	defer db.Close()
+
+	var buckets int
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			buckets++
+			return nil
+		})
+	})
+	if err != nil {
+		return preflightCheck{
+			Name:        name,
+			Status:      checkFail,
+			Detail:      fmt.Sprintf("parsing %s: %v", path, err),
+			Remediation: "the database may be corrupted; recover a copy from a backup or snapshot",
+		}
+	}
+	if buckets == 0 {
+		return preflightCheck{
+			Name:        name,
+			Status:      checkWarn,
+			Detail:      fmt.Sprintf("%s contains no buckets", path),
+			Remediation: "verify the database file belongs to the evidence",
+		}
+	}
+
+	return preflightCheck{
+		Name:   name,
+		Status: checkPass,
+		Detail: fmt.Sprintf("%s (%d top-level buckets)", path, buckets),
+	}
+}
+
+// checkReadableDir verifies a directory exists and can be listed.
+func checkReadableDir(name string, path string) preflightCheck {
+	if !explorers.PathExists(path, false) {
+		return preflightCheck{
+			Name:        name,
+			Status:      checkFail,
+			Detail:      fmt.Sprintf("%s does not exist", path),
+			Remediation: "verify the evidence was acquired completely",
+		}
+	}
+
+	if _, err := os.ReadDir(path); err != nil {
+		return preflightCheck{
+			Name:        name,
+			Status:      checkFail,
+			Detail:      fmt.Sprintf("reading %s: %v", path, err),
+			Remediation: "run container-explorer as root or fix the directory permissions",
+		}
+	}
+
+	return preflightCheck{
+		Name:   name,
+		Status: checkPass,
+		Detail: path,
+	}
+}
+
+// checkFreeSpace verifies the output directory has the minimum free space.
+func checkFreeSpace(outputdir string, minfreemb uint64) preflightCheck {
+	name := "output free space"
+
+	free, err := freeSpace(outputdir)
+	if err != nil {
+		return preflightCheck{
+			Name:        name,
+			Status:      checkWarn,
+			Detail:      fmt.Sprintf("computing free space for %s: %v", outputdir, err),
+			Remediation: "verify the output directory exists",
+		}
+	}
+
+	freemb := free / (1024 * 1024)
+	if freemb < minfreemb {
+		return preflightCheck{
+			Name:        name,
+			Status:      checkFail,
+			Detail:      fmt.Sprintf("%s has %d MiB free, %d MiB required", outputdir, freemb, minfreemb),
+			Remediation: "free disk space or use a different output directory",
+		}
+	}
+
+	return preflightCheck{
+		Name:   name,
+		Status: checkPass,
+		Detail: fmt.Sprintf("%s has %d MiB free", outputdir, freemb),
+	}
+}
+
+// checkKernelFeatures verifies the kernel features required to mount
+// containers are available on the analysis host. The FUSE mount requires
+// fuse-overlayfs rather than root privileges.
+func checkKernelFeatures(fuse bool) []preflightCheck {
+	if runtime.GOOS != "linux" {
+		return []preflightCheck{
+			{
+				Name:        "kernel features",
+				Status:      checkWarn,
+				Detail:      fmt.Sprintf("mounting containers is not supported on %s", runtime.GOOS),
+				Remediation: "use a Linux analysis host to mount containers",
+			},
+		}
+	}
+
+	data, err := os.ReadFile("/proc/filesystems")
+	if err != nil {
+		return []preflightCheck{
+			{
+				Name:        "kernel features",
+				Status:      checkWarn,
+				Detail:      fmt.Sprintf("reading /proc/filesystems: %v", err),
+				Remediation: "verify /proc is mounted",
+			},
+		}
+	}
+
+	supported := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			supported[fields[len(fields)-1]] = true
+		}
+	}
+
+	var checks []preflightCheck
+	for _, fs := range []string{"overlay", "fuse"} {
+		if supported[fs] {
+			checks = append(checks, preflightCheck{
+				Name:   fmt.Sprintf("kernel %s", fs),
+				Status: checkPass,
+				Detail: fmt.Sprintf("%s filesystem is supported", fs),
+			})
+			continue
+		}
+		checks = append(checks, preflightCheck{
+			Name:        fmt.Sprintf("kernel %s", fs),
+			Status:      checkWarn,
+			Detail:      fmt.Sprintf("%s filesystem is not registered", fs),
+			Remediation: fmt.Sprintf("load the kernel module using modprobe %s", fs),
+		})
+	}
+
+	if fuse {
+		if _, err := exec.LookPath("fuse-overlayfs"); err != nil {
+			checks = append(checks, preflightCheck{
+				Name:        "fuse-overlayfs",
+				Status:      checkFail,
+				Detail:      "--fuse is specified and fuse-overlayfs is not installed",
+				Remediation: "install fuse-overlayfs i.e. apt install fuse-overlayfs",
+			})
+		} else {
+			checks = append(checks, preflightCheck{
+				Name:   "fuse-overlayfs",
+				Status: checkPass,
+				Detail: "containers are mounted in user space",
+			})
+		}
+		return checks
+	}
+
+	if os.Geteuid() != 0 {
+		checks = append(checks, preflightCheck{
+			Name:        "privileges",
+			Status:      checkWarn,
+			Detail:      "not running as root",
+			Remediation: "mounting containers requires root privileges. Use --fuse to mount in user space",
+		})
+	}
+
+	return checks
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // registers the /debug/pprof handlers
+	"os"
+	"runtime"
+	"runtime/pprof"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// SetupProfiling serves the runtime profiles of the process over HTTP at
+// the address specified using the global flag --pprof i.e. localhost:6060.
+//
+// The profiles are served at /debug/pprof/ while the command runs, so a
+// slow command is profiled using go tool pprof.
+func SetupProfiling(clictx *cli.Context) error {
+	addr := clictx.GlobalString("pprof")
+	if addr == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening for profiling requests: %w", err)
+	}
+	go func() {
+		if err := http.Serve(ln, nil); err != nil {
+			log.Debug("serving profiles: ", err)
+		}
+	}()
+
+	log.WithField("url", fmt.Sprintf("http://%s/debug/pprof/", ln.Addr())).Info("serving runtime profiles")
+	return nil
+}
+
+// startCPUProfile writes the CPU profile to path until the returned
+// function is called. An empty path does not profile.
+func startCPUProfile(path string) (func(), error) {
+	if path == "" {
+		return func() {}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("starting CPU profile: %w", err)
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+	}, nil
+}
+
+// writeHeapProfile writes the heap profile to path after a garbage
+// collection, so the profile holds the live objects.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return fmt.Errorf("writing heap profile: %w", err)
+	}
+	return nil
+}
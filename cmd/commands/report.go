@@ -0,0 +1,705 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var ReportCommand = cli.Command{
+	Name:  "report",
+	Usage: "generate reports",
+	Description: `generate the case report or one of the reports of the subcommands.
+
+   Use --format docx or pdf to write the case report i.e. the host summary,
+   the container and image inventories, the findings, the timeline, and an
+   appendix with the hashes of the evidence files, so the report can go
+   straight into the case file. The findings are read from the output of
+   the analyze, scan, and report commands run with --explain --output json.
+
+   The case report is produced by a Go template writing a Markdown subset
+   i.e. headings, paragraphs, lists, tables, and page breaks (---). Use
+   --format markdown to write the output of the template, and --template
+   to use a custom template.`,
+	Flags:  reportCaseFlags,
+	Action: reportCase,
+	Subcommands: cli.Commands{
+		reportLicenses,
+		reportPinning,
+		reportVolatile,
+		reportStartOrder,
+		reportSBOM,
+		reportTagHistory,
+		reportPullPolicy,
+	},
+}
+
+// licenseSummary holds the license summary of a container.
+type licenseSummary struct {
+	Namespace   string              `json:"namespace"`
+	ContainerID string              `json:"container_id"`
+	Image       string              `json:"image"`
+	Packages    int                 `json:"packages"`
+	Licenses    map[string]int      `json:"licenses"`
+	Copyleft    []explorers.Package `json:"copyleft,omitempty"`
+	Unknown     []explorers.Package `json:"unknown,omitempty"`
+}
+
+var reportLicenses = cli.Command{
+	Name:        "licenses",
+	Usage:       "summarize open source licenses per container",
+	Description: "summarize open source licenses of the packages installed in containers and flag copyleft and unknown licenses",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "report only the specified container ID",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		cli.BoolFlag{
+			Name:  "details",
+			Usage: "show copyleft and unknown license packages",
+		},
+		explainFlag,
+		formatFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerLicenses); err != nil {
+			return err
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		var summaries []licenseSummary
+		for _, ctr := range ctrs {
+			if id := clictx.String("id"); id != "" && ctr.ID != id {
+				continue
+			}
+			if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+
+			layers, err := containerLayers(ctx, exp, ctr)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("skipping container: ", err)
+				continue
+			}
+
+			pkgs, err := explorers.ListPackages(layers)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("listing packages: ", err)
+				continue
+			}
+
+			summary := licenseSummary{
+				Namespace:   ctr.Namespace,
+				ContainerID: ctr.ID,
+				Image:       ctr.Image,
+				Packages:    len(pkgs),
+				Licenses:    make(map[string]int),
+			}
+			for _, pkg := range pkgs {
+				for _, license := range pkg.Licenses {
+					summary.Licenses[license]++
+				}
+				switch pkg.Category {
+				case explorers.LicenseCopyleft:
+					summary.Copyleft = append(summary.Copyleft, pkg)
+				case explorers.LicenseUnknown:
+					summary.Unknown = append(summary.Unknown, pkg)
+				}
+			}
+			summaries = append(summaries, summary)
+		}
+
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, s := range summaries {
+				for _, pkg := range append(s.Copyleft, s.Unknown...) {
+					explanations = append(explanations, explainLicense(s, pkg))
+				}
+			}
+			printExplanations(clictx.GlobalString("output"), explanations)
+			return nil
+		}
+
+		if output := clictx.GlobalString("output"); isStructuredOutput(output) {
+			for _, s := range summaries {
+				printObject(output, s)
+			}
+			return nil
+		}
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+		if tmpl != nil {
+			for _, s := range summaries {
+				printTemplate(tmpl, s)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(clictx.GlobalString("output"))
+		defer rw.Flush()
+
+		if clictx.Bool("details") {
+			rw.Write("NAMESPACE", "CONTAINER ID", "CATEGORY", "TYPE", "PACKAGE", "VERSION", "LICENSES")
+			for _, s := range summaries {
+				for _, pkg := range append(s.Copyleft, s.Unknown...) {
+					rw.Write(
+						s.Namespace,
+						s.ContainerID,
+						pkg.Category,
+						pkg.Type,
+						pkg.Name,
+						pkg.Version,
+						strings.Join(pkg.Licenses, ","),
+					)
+				}
+			}
+			return nil
+		}
+
+		rw.Write("NAMESPACE", "CONTAINER ID", "IMAGE", "PACKAGES", "COPYLEFT", "UNKNOWN", "LICENSES")
+		for _, s := range summaries {
+			rw.Write(
+				s.Namespace,
+				s.ContainerID,
+				s.Image,
+				fmt.Sprint(s.Packages),
+				fmt.Sprint(len(s.Copyleft)),
+				fmt.Sprint(len(s.Unknown)),
+				countString(s.Licenses),
+			)
+		}
+		return nil
+	},
+}
+
+// pinningEntry holds the image reference of a workload and the digest the
+// reference resolved to.
+type pinningEntry struct {
+	Namespace      string `json:"namespace"`
+	ContainerID    string `json:"container_id"`
+	Pod            string `json:"pod,omitempty"`
+	Image          string `json:"image"`
+	Tag            string `json:"tag,omitempty"`
+	Pinned         bool   `json:"pinned"`
+	ResolvedDigest string `json:"resolved_digest,omitempty"`
+	PinnedImage    string `json:"pinned_image,omitempty"`
+
+	// PinNote is the reason the pinned image reference is not known.
+	PinNote string `json:"pin_note,omitempty"`
+}
+
+// pinNoteDocker explains a docker image without a repository digest. The
+// target digest of a docker image record is the image ID i.e. the image
+// configuration digest, which is not a pullable reference.
+const pinNoteDocker = "docker recorded no repository digest for the image i.e. the image was built or loaded locally, and the image ID is not a pullable digest"
+
+var reportPinning = cli.Command{
+	Name:        "pinning",
+	Usage:       "list workloads referencing images by mutable tags",
+	Description: "list workloads referencing images by mutable tags rather than digests with the digest the tag resolved to",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "include workloads referencing images by digest",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		explainFlag,
+		formatFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerPinning); err != nil {
+			return err
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		images, err := exp.ListImages(ctx)
+		if err != nil {
+			log.Warn("listing images: ", err)
+		}
+
+		// digests maps <namespace>/<image name> to the image target digest
+		// and repodigests maps <namespace>/<target digest>/<repository> to
+		// the <repository>@<digest> name of the same image.
+		digests := make(map[string]string)
+		repodigests := make(map[string]string)
+		for _, image := range images {
+			digests[fmt.Sprintf("%s/%s", image.Namespace, image.Name)] = string(image.Target.Digest)
+			if strings.Contains(image.Name, "@") {
+				repodigests[fmt.Sprintf("%s/%s/%s", image.Namespace, image.Target.Digest, imageRepository(image.Name))] = image.Name
+			}
+		}
+		docker := isDockerRuntime(selectedRuntime(clictx))
+
+		var entries []pinningEntry
+		for _, ctr := range ctrs {
+			if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+
+			tag, pinned := imageTag(ctr.Image)
+			if pinned && !clictx.Bool("all") {
+				continue
+			}
+
+			entry := pinningEntry{
+				Namespace:   ctr.Namespace,
+				ContainerID: ctr.ID,
+				Pod:         ctr.Labels[explorers.LabelPodName],
+				Image:       ctr.Image,
+				Tag:         tag,
+				Pinned:      pinned,
+			}
+			if digest, found := digests[fmt.Sprintf("%s/%s", ctr.Namespace, ctr.Image)]; found {
+				entry.ResolvedDigest = digest
+				if !pinned {
+					// A repository digest recorded for the image is
+					// preferred. The target digest of a docker image is
+					// the image ID and is not used.
+					repository := imageRepository(ctr.Image)
+					switch name, found := repodigests[fmt.Sprintf("%s/%s/%s", ctr.Namespace, digest, repository)]; {
+					case found:
+						entry.PinnedImage = name
+					case docker:
+						entry.PinNote = pinNoteDocker
+					default:
+						entry.PinnedImage = fmt.Sprintf("%s@%s", repository, digest)
+					}
+				}
+			}
+			entries = append(entries, entry)
+		}
+
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, e := range entries {
+				explanations = append(explanations, explainPinning(e))
+			}
+			printExplanations(clictx.GlobalString("output"), explanations)
+			return nil
+		}
+
+		if output := clictx.GlobalString("output"); isStructuredOutput(output) {
+			for _, e := range entries {
+				printObject(output, e)
+			}
+			return nil
+		}
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+		if tmpl != nil {
+			for _, e := range entries {
+				printTemplate(tmpl, e)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(clictx.GlobalString("output"))
+		defer rw.Flush()
+
+		rw.Write("NAMESPACE", "CONTAINER ID", "POD", "IMAGE", "TAG", "PINNED", "RESOLVED DIGEST")
+		for _, e := range entries {
+			rw.Write(
+				e.Namespace,
+				e.ContainerID,
+				e.Pod,
+				e.Image,
+				e.Tag,
+				fmt.Sprint(e.Pinned),
+				e.ResolvedDigest,
+			)
+		}
+		return nil
+	},
+}
+
+var reportVolatile = cli.Command{
+	Name:  "volatile",
+	Usage: "list container mounts that existed only in memory",
+	Description: `list tmpfs mounts, Kubernetes secret and projected volumes, and emptyDir
+   volumes with medium Memory.
+
+   The content of these mounts existed only in RAM and is not recoverable
+   from disk. Use a memory image to analyze these paths.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		explainFlag,
+		formatFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerVolatile); err != nil {
+			return err
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		var entries []volatileEntry
+		for _, ctr := range ctrs {
+			if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+			for _, m := range ctr.VolatileMounts {
+				entries = append(entries, volatileEntry{
+					Namespace:     ctr.Namespace,
+					ContainerID:   ctr.ID,
+					VolatileMount: m,
+				})
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, e := range entries {
+				explanations = append(explanations, explainVolatile(e))
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, e := range entries {
+				printObject(output, e)
+			}
+			return nil
+		}
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+		if tmpl != nil {
+			for _, e := range entries {
+				printTemplate(tmpl, e)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+
+		rw.Write("NAMESPACE", "CONTAINER ID", "TYPE", "DESTINATION", "SOURCE", "NOTE")
+		for _, e := range entries {
+			rw.Write(e.Namespace, e.ContainerID, e.Type, e.Destination, e.Source, e.Note)
+		}
+		return nil
+	},
+}
+
+var reportStartOrder = cli.Command{
+	Name:  "start-order",
+	Usage: "reconstruct the start order of containers",
+	Description: `reconstruct the approximate order in which containers started and render
+   it as a sequence.
+
+   The start time recorded by the runtime is used if available, otherwise the
+   created time is used. Kubernetes containers depend on the pod sandbox and
+   Docker Compose services depend on the services in the depends_on label.
+   The DEPENDS ON column lists the sequence numbers of the dependencies. A
+   dependency marked with ! started after the container.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		formatFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		// The dependencies are resolved before filtering support containers
+		// so that the pod sandbox of a workload is not lost.
+		support := make(map[string]bool)
+		for _, ctr := range ctrs {
+			support[ctr.Namespace+"/"+ctr.ID] = ctr.SupportContainer
+		}
+
+		sequence := make(map[string]int)
+		var events []explorers.StartEvent
+		for _, event := range explorers.StartOrder(ctrs) {
+			sequence[event.Namespace+"/"+event.ContainerID] = event.Sequence
+			if !clictx.Bool("show-support-containers") && support[event.Namespace+"/"+event.ContainerID] {
+				continue
+			}
+			events = append(events, event)
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, event := range events {
+				printObject(output, event)
+			}
+			return nil
+		}
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+		if tmpl != nil {
+			for _, event := range events {
+				printTemplate(tmpl, event)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+
+		rw.Write("SEQ", "TIME", "DELTA", "SOURCE", "NAMESPACE", "GROUP", "ROLE", "NAME", "CONTAINER ID", "DEPENDS ON")
+		var previous time.Time
+		for _, event := range events {
+			delta := ""
+			if !previous.IsZero() && !event.Time.IsZero() {
+				delta = "+" + event.Time.Sub(previous).Round(time.Second).String()
+			}
+			if !event.Time.IsZero() {
+				previous = event.Time
+			}
+
+			outoforder := make(map[string]bool)
+			for _, id := range event.OutOfOrder {
+				outoforder[id] = true
+			}
+			var deps []string
+			for _, id := range event.DependsOn {
+				dep := fmt.Sprintf("#%d", sequence[event.Namespace+"/"+id])
+				if outoforder[id] {
+					dep += "!"
+				}
+				deps = append(deps, dep)
+			}
+
+			rw.Write(
+				fmt.Sprintf("#%d", event.Sequence),
+				formatTime(event.Time),
+				delta,
+				event.TimeSource,
+				event.Namespace,
+				event.Group,
+				event.Role,
+				event.Name,
+				event.ContainerID,
+				strings.Join(deps, ","),
+			)
+		}
+		return nil
+	},
+}
+
+// volatileEntry holds a volatile mount of a container.
+type volatileEntry struct {
+	Namespace   string `json:"namespace"`
+	ContainerID string `json:"container_id"`
+	explorers.VolatileMount
+}
+
+// explainLicense returns the explanation of a copyleft or unknown license
+// package.
+func explainLicense(s licenseSummary, pkg explorers.Package) explanation {
+	rule := "a license of the package starts with a copyleft identifier i.e. GPL, LGPL, AGPL, MPL, EPL, EUPL, CDDL, OSL, CC-BY-SA, or GNU"
+	if pkg.Category == explorers.LicenseUnknown {
+		rule = "the package declares no license, or a license of the package is empty, UNKNOWN, NONE, NOASSERTION, custom, or not a known permissive license i.e. MIT, BSD, Apache, or ISC, and no license is copyleft"
+	}
+	licenses := strings.Join(pkg.Licenses, ",")
+	if licenses == "" {
+		licenses = "(none)"
+	}
+	return explanation{
+		Namespace:   s.Namespace,
+		ContainerID: s.ContainerID,
+		Finding:     fmt.Sprintf("%s license for %s package %s %s", pkg.Category, pkg.Type, pkg.Name, pkg.Version),
+		Rule:        rule,
+		Evidence: []evidence{
+			{Source: pkg.Path, Field: "licenses", Value: licenses},
+		},
+	}
+}
+
+// explainPinning returns the explanation of a workload image reference.
+func explainPinning(e pinningEntry) explanation {
+	x := explanation{
+		Namespace:   e.Namespace,
+		ContainerID: e.ContainerID,
+		Finding:     fmt.Sprintf("image %s referenced by tag %s", e.Image, e.Tag),
+		Rule:        "the container image reference has no @<digest> and uses a mutable tag; a reference without a tag uses latest",
+		Evidence: []evidence{
+			{Source: "container record", Field: "image", Value: e.Image},
+		},
+	}
+	if e.Pinned {
+		x.Finding = fmt.Sprintf("image %s pinned to a digest", e.Image)
+		x.Rule = "the container image reference includes @<digest>"
+	}
+	if e.ResolvedDigest != "" {
+		x.Evidence = append(x.Evidence, evidence{
+			Source: "image record " + e.Image,
+			Field:  "target.digest",
+			Value:  e.ResolvedDigest,
+		})
+	}
+	if e.PinnedImage != "" {
+		x.Evidence = append(x.Evidence, evidence{Source: "image record " + e.PinnedImage, Field: "name", Value: e.PinnedImage})
+	}
+	if e.PinNote != "" {
+		x.Evidence = append(x.Evidence, evidence{Source: "image record " + e.Image, Field: "repository digest", Value: "(none) " + e.PinNote})
+	}
+	return x
+}
+
+// explainVolatile returns the explanation of a volatile mount.
+func explainVolatile(e volatileEntry) explanation {
+	x := explanation{
+		Namespace:   e.Namespace,
+		ContainerID: e.ContainerID,
+		Finding:     fmt.Sprintf("%s mount at %s", e.Type, e.Destination),
+		Evidence: []evidence{
+			{Source: "container spec", Field: "mounts.destination", Value: e.Destination},
+			{Source: "container spec", Field: "mounts.source", Value: e.Source},
+		},
+	}
+	switch e.Type {
+	case explorers.VolatileTmpfs:
+		x.Rule = "the mount type is tmpfs and the destination is not /dev"
+		x.Evidence = append(x.Evidence, evidence{Source: "container spec", Field: "mounts.type", Value: "tmpfs"})
+	case explorers.VolatileKubeletTmpfs:
+		x.Rule = "the mount source is a kubelet secret or projected volume, which kubelet backs by tmpfs"
+	case explorers.VolatileEmptyDirMemory:
+		x.Rule = "the mount source is a kubelet emptyDir volume and the volume directory is empty or missing on disk"
+		x.Evidence = append(x.Evidence, evidence{Source: e.Source, Value: "empty or missing"})
+	}
+	return x
+}
+
+// imageTag returns the tag of an image reference and whether the reference
+// is pinned to a digest.
+//
+// A reference without a tag or digest uses the mutable tag latest.
+func imageTag(ref string) (string, bool) {
+	if strings.Contains(ref, "@") || strings.HasPrefix(ref, "sha256:") {
+		return "", true
+	}
+
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return name[i+1:], false
+	}
+	return "latest", false
+}
+
+// imageRepository returns the image reference without the tag or digest.
+func imageRepository(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[:i]
+	}
+
+	slash := strings.LastIndex(ref, "/")
+	if i := strings.LastIndex(ref, ":"); i > slash {
+		return ref[:i]
+	}
+	return ref
+}
+
+// containerLayers returns the container's upper and lower layer directories
+// ordered from top to bottom.
+func containerLayers(ctx context.Context, exp explorers.ContainerExplorer, ctr explorers.Container) ([]string, error) {
+	ctx = namespaces.WithNamespace(ctx, ctr.Namespace)
+
+	upperdir, lowerdirs, err := exp.ContainerLayers(ctx, ctr.ID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{upperdir}, lowerdirs...), nil
+}
+
+// countString returns a string of comma separated key=count pairs sorted by
+// key.
+func countString(counts map[string]int) string {
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", k, counts[k]))
+	}
+	return strings.Join(pairs, ",")
+}
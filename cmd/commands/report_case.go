@@ -0,0 +1,445 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/document"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Document formats of the case report.
+const (
+	reportFormatDOCX     = "docx"
+	reportFormatPDF      = "pdf"
+	reportFormatMarkdown = "markdown"
+)
+
+// defaultReportTemplate is the template of the case report used unless
+// --template is specified.
+//
+//go:embed report_case.tmpl
+var defaultReportTemplate string
+
+// caseReport is the data of the case report template.
+type caseReport struct {
+	Title         string
+	CaseNumber    string
+	Examiner      string
+	GeneratedAt   time.Time
+	Version       string
+	Host          reportHost
+	Containers    []explorers.Container
+	Images        []explorers.Image
+	Findings      []explanation
+	Timeline      []timelineEvent
+	EvidenceFiles []evidenceFile
+}
+
+// reportHost summarizes the host of the evidence.
+type reportHost struct {
+	Hostname   string
+	OS         string // PRETTY_NAME of /etc/os-release
+	ImageRoot  string
+	Runtime    string
+	Namespaces []string
+	Running    int // running containers
+}
+
+// timelineEvent is a timestamped event of the case report timeline.
+type timelineEvent struct {
+	Time        time.Time
+	Event       string
+	Namespace   string
+	ContainerID string
+	Detail      string
+}
+
+// evidenceFile is a file the case report was generated from.
+type evidenceFile struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// reportCaseFlags are the flags of report generating the case report.
+var reportCaseFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "format",
+		Usage: "case report format i.e. docx, pdf, or markdown",
+	},
+	// The case report file is not named output, which would hide the
+	// global --output flag from the report subcommands.
+	cli.StringFlag{
+		Name:  "output-file, o",
+		Usage: "case report file",
+	},
+	cli.StringFlag{
+		Name:  "template",
+		Usage: "Go template producing the case report in the Markdown subset. Default is the built-in template",
+	},
+	cli.StringSliceFlag{
+		Name:  "findings",
+		Usage: "findings file written by a command run with --explain --output json. May be repeated",
+	},
+	cli.StringFlag{
+		Name:  "title",
+		Usage: "case report title",
+		Value: "Container Investigation Report",
+	},
+	cli.StringFlag{
+		Name:  "case-number",
+		Usage: "case number",
+	},
+	cli.StringFlag{
+		Name:  "examiner",
+		Usage: "examiner name",
+	},
+	cli.BoolFlag{
+		Name:  "show-support-containers",
+		Usage: "include Kubernetes support containers",
+	},
+}
+
+// reportCase writes the case report i.e. the host summary, the container
+// and image inventories, the findings, the timeline, and the hashes of the
+// evidence files, formatted using a template.
+func reportCase(clictx *cli.Context) error {
+	format := strings.ToLower(clictx.String("format"))
+	if format == "" {
+		return cli.ShowSubcommandHelp(clictx)
+	}
+	switch format {
+	case reportFormatDOCX, reportFormatPDF, reportFormatMarkdown:
+	default:
+		return fmt.Errorf("unsupported report format %s. Use docx, pdf, or markdown", format)
+	}
+	output := clictx.String("output-file")
+	if output == "" {
+		return fmt.Errorf("report output file is required")
+	}
+
+	text := defaultReportTemplate
+	if path := clictx.String("template"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading report template: %w", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("report").Funcs(templateFuncs).Funcs(reportTemplateFuncs).Parse(text)
+	if err != nil {
+		return fmt.Errorf("parsing report template: %w", err)
+	}
+
+	report, err := collectCaseReport(clictx)
+	if err != nil {
+		return err
+	}
+
+	var markup bytes.Buffer
+	if err := tmpl.Execute(&markup, report); err != nil {
+		return fmt.Errorf("executing report template: %w", err)
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err := writeCaseReport(f, format, markup.Bytes(), report.GeneratedAt); err != nil {
+		f.Close()
+		os.Remove(output)
+		return fmt.Errorf("writing case report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote %s report %s: %d containers, %d images, %d findings, %d events\n",
+		format, output, len(report.Containers), len(report.Images), len(report.Findings), len(report.Timeline))
+	return nil
+}
+
+// reportTemplateFuncs are the functions of the case report templates in
+// addition to the --format template functions.
+var reportTemplateFuncs = template.FuncMap{
+	"cell": document.EscapeCell,
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return formatTime(t)
+	},
+	"add": func(a int, b int) int {
+		return a + b
+	},
+	"pod": func(ctr explorers.Container) string {
+		if name := ctr.Labels[explorers.LabelPodName]; name != "" {
+			return ctr.Labels[explorers.LabelPodNamespace] + "/" + name
+		}
+		return ctr.PodName
+	},
+}
+
+// writeCaseReport writes the report markup in the document format.
+func writeCaseReport(w io.Writer, format string, markup []byte, created time.Time) error {
+	if format == reportFormatMarkdown {
+		_, err := w.Write(markup)
+		return err
+	}
+
+	doc, err := document.Parse(bytes.NewReader(markup))
+	if err != nil {
+		return err
+	}
+	if format == reportFormatDOCX {
+		return document.WriteDOCX(w, doc, created)
+	}
+	return document.WritePDF(w, doc, created)
+}
+
+// collectCaseReport returns the data of the case report.
+func collectCaseReport(clictx *cli.Context) (caseReport, error) {
+	imageroot := clictx.GlobalString("image-root")
+	report := caseReport{
+		Title:       clictx.String("title"),
+		CaseNumber:  clictx.String("case-number"),
+		Examiner:    clictx.String("examiner"),
+		GeneratedAt: time.Now().UTC(),
+		Version:     clictx.App.Version,
+		Host: reportHost{
+			Hostname:  nodeHostname(imageroot),
+			OS:        osPrettyName(imageroot),
+			ImageRoot: imageroot,
+			Runtime:   selectedRuntime(clictx),
+		},
+	}
+
+	for _, path := range clictx.StringSlice("findings") {
+		findings, err := readFindings(path)
+		if err != nil {
+			return report, err
+		}
+		report.Findings = append(report.Findings, findings...)
+		report.EvidenceFiles = append(report.EvidenceFiles, hashEvidenceFiles([]string{path})...)
+	}
+
+	ctx, exp, cancel, err := explorerEnvironment(clictx)
+	if err != nil {
+		return report, err
+	}
+	defer cancel()
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err != nil {
+		return report, err
+	}
+	namespaces := make(map[string]bool)
+	for _, ctr := range ctrs {
+		if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+			continue
+		}
+		report.Containers = append(report.Containers, ctr)
+		if ctr.Namespace != "" {
+			namespaces[ctr.Namespace] = true
+		}
+		if ctr.Status == "RUNNING" || ctr.Running {
+			report.Host.Running++
+		}
+	}
+
+	images, err := exp.ListImages(ctx)
+	if err != nil {
+		log.Warn("listing images: ", err)
+	}
+	for _, image := range images {
+		if !clictx.Bool("show-support-containers") && image.SupportContainerImage {
+			continue
+		}
+		report.Images = append(report.Images, image)
+		if image.Namespace != "" {
+			namespaces[image.Namespace] = true
+		}
+	}
+	for ns := range namespaces {
+		report.Host.Namespaces = append(report.Host.Namespaces, ns)
+	}
+	sort.Strings(report.Host.Namespaces)
+
+	report.Timeline = reportTimeline(report.Containers, report.Images, report.Findings)
+	report.EvidenceFiles = append(hashEvidenceFiles(runtimeMetadataFiles(clictx)), report.EvidenceFiles...)
+	return report, nil
+}
+
+// readFindings reads the explanations written by a command run with
+// --explain and --output json or jsonl.
+func readFindings(path string) ([]explanation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var findings []explanation
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading findings %s: %w", path, err)
+		}
+
+		var decoded []explanation
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			err = json.Unmarshal(raw, &decoded)
+		} else {
+			var e explanation
+			err = json.Unmarshal(raw, &e)
+			decoded = append(decoded, e)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading findings %s: %w", path, err)
+		}
+
+		for _, e := range decoded {
+			if e.Finding == "" {
+				log.WithField("path", path).Warn("skipping object without finding. Use --explain to write the findings")
+				continue
+			}
+			findings = append(findings, e)
+		}
+	}
+	return findings, nil
+}
+
+// reportTimeline returns the container lifecycle events, the image events,
+// and the timestamped evidence of the findings in chronological order.
+func reportTimeline(ctrs []explorers.Container, images []explorers.Image, findings []explanation) []timelineEvent {
+	var events []timelineEvent
+	add := func(t time.Time, event string, namespace string, containerid string, detail string) {
+		if !t.IsZero() {
+			events = append(events, timelineEvent{t.UTC(), event, namespace, containerid, detail})
+		}
+	}
+
+	for _, ctr := range ctrs {
+		add(ctr.CreatedAt, "container created", ctr.Namespace, ctr.ID, ctr.Image)
+		add(ctr.StartedAt, "container started", ctr.Namespace, ctr.ID, ctr.Image)
+		add(ctr.FinishedAt, "container exited", ctr.Namespace, ctr.ID, fmt.Sprintf("exit code %d", ctr.ExitCode))
+	}
+	for _, image := range images {
+		add(image.CreatedAt, "image recorded", image.Namespace, "", image.Name)
+	}
+	for _, f := range findings {
+		for _, ev := range f.Evidence {
+			add(ev.Time, "finding evidence", f.Namespace, f.ContainerID, fmt.Sprintf("%s: %s", f.Finding, ev.Source))
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+// runtimeMetadataFiles returns the metadata files of the container runtime
+// the report is generated from.
+func runtimeMetadataFiles(clictx *cli.Context) []string {
+	imageroot := clictx.GlobalString("image-root")
+	runtime := selectedRuntime(clictx)
+
+	var patterns []string
+	switch {
+	case isDockerRuntime(runtime):
+		root := dockerRoot(clictx)
+		patterns = []string{
+			filepath.Join(root, "image", "*", "repositories.json"),
+			filepath.Join(root, "containers", "*", "config.v2.json"),
+		}
+	case runtime == runtimeCrio || runtime == runtimePodman:
+		root := resolveCrioRoot(imageroot, clictx.GlobalString("crio-root"))
+		if runtime == runtimePodman && clictx.GlobalString("podman-root") != "" {
+			root = clictx.GlobalString("podman-root")
+		}
+		patterns = []string{
+			filepath.Join(root, "*-containers", "containers.json"),
+			filepath.Join(root, "*-images", "images.json"),
+			filepath.Join(root, "*-layers", "layers.json"),
+		}
+	case isContainerdRuntime(runtime):
+		_, metadatafile, snapshotfile := resolveContainerdPaths(
+			imageroot,
+			containerdRoot(clictx),
+			clictx.GlobalString("metadata-file"),
+			clictx.GlobalString("snapshot-metadata-file"),
+		)
+		patterns = []string{metadatafile, snapshotfile}
+	}
+
+	var files []string
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		files = append(files, matches...)
+	}
+	return files
+}
+
+// hashEvidenceFiles returns the size and SHA256 digest of the files.
+func hashEvidenceFiles(paths []string) []evidenceFile {
+	var files []evidenceFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		digest, err := fileDigest(path)
+		if err != nil {
+			log.WithField("path", path).Warn("hashing evidence file: ", err)
+			continue
+		}
+		files = append(files, evidenceFile{
+			Path:   path,
+			Size:   info.Size(),
+			SHA256: strings.TrimPrefix(digest, "sha256:"),
+		})
+	}
+	return files
+}
+
+// osPrettyName returns the PRETTY_NAME of /etc/os-release within the image
+// root.
+func osPrettyName(imageroot string) string {
+	if imageroot == "" {
+		return ""
+	}
+	return osReleaseValue(imageroot, "PRETTY_NAME")
+}
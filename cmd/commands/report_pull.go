@@ -0,0 +1,351 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/etcd"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Flags of the image pull exposure of a pod container.
+const (
+	pullFlagNodeCredential     = "node-credential"
+	pullFlagWildcardCredential = "wildcard-credential"
+	pullFlagServiceAccount     = "service-account-secret"
+	pullFlagDefaultSA          = "default-service-account-secret"
+	pullFlagCachedPrivateImage = "cached-private-image"
+)
+
+// defaultServiceAccountName is the service account of a pod without
+// serviceAccountName.
+const defaultServiceAccountName = "default"
+
+// pullExposure holds the image pull policy and the registry credentials
+// used to pull the image of a pod container.
+type pullExposure struct {
+	Namespace       string                         `json:"namespace"`
+	Pod             string                         `json:"pod"`
+	PodUID          string                         `json:"pod_uid,omitempty"`
+	Node            string                         `json:"node,omitempty"`
+	Source          string                         `json:"source"`
+	Container       string                         `json:"container"`
+	Image           string                         `json:"image"`
+	Registry        string                         `json:"registry,omitempty"`
+	PullPolicy      string                         `json:"image_pull_policy,omitempty"`
+	DefaultPolicy   bool                           `json:"default_policy,omitempty"` // policy set by the API server, not the manifest
+	ServiceAccount  string                         `json:"service_account,omitempty"`
+	PullSecrets     []string                       `json:"image_pull_secrets,omitempty"`
+	InheritedFromSA bool                           `json:"inherited_from_service_account,omitempty"`
+	NodeCredentials []explorers.RegistryCredential `json:"node_credentials,omitempty"`
+	Flags           []string                       `json:"flags,omitempty"`
+}
+
+var reportPullPolicy = cli.Command{
+	Name:  "pull-policy",
+	Usage: "report image pull policies and the registry credentials used by pods",
+	Description: `report the image pull policy of each pod container and the pull secrets
+   and node registry credentials used to pull its image.
+
+   The pods are read from etcd when available, the kubelet static pod
+   manifests, and the node-local CRI sandboxes. A pod created using kubectl
+   apply has its manifest in the last-applied-configuration annotation of
+   the sandbox. Otherwise, the pull policy of a node-local pod is unknown.
+
+   The node registry credentials are read from the docker configuration
+   files of kubelet and the container runtime, the containerd registry
+   configuration, and the kubelet image credential providers. The secret
+   values are never read or reported.
+
+   The following flags are reported:
+     node-credential                 the image is pulled using a node credential
+                                     available to every pod on the node
+     wildcard-credential             the node credential matches several or all
+                                     registries
+     service-account-secret          the pull secret is inherited from the
+                                     service account
+     default-service-account-secret  the pull secret is inherited from the
+                                     default service account of the namespace
+     cached-private-image            the private image is not pulled with
+                                     Always and is usable by any pod on the node`,
+	Flags: append([]cli.Flag{
+		cli.BoolFlag{
+			Name:  "flagged",
+			Usage: "report only the flagged pod containers",
+		},
+	}, etcdFlags...),
+	Action: func(clictx *cli.Context) error {
+		imageroot := clictx.GlobalString("image-root")
+		pack := selectedPack(clictx)
+		hostname := nodeHostname(imageroot)
+
+		var (
+			pods      []explorers.PodPullSpec
+			saSecrets = make(map[string][]string) // namespace/name -> pull secrets
+		)
+
+		objects, err := readClusterObjects(clictx)
+		if err != nil {
+			if clictx.String("etcd-dir") != "" {
+				return err
+			}
+			log.Debug("skipping etcd pods: ", err)
+		}
+		for _, o := range objects {
+			switch o.Resource {
+			case "serviceaccounts":
+				saSecrets[o.Namespace+"/"+o.Name] = o.PullSecrets
+			case "pods":
+				pods = append(pods, etcdPodPullSpec(o))
+			}
+		}
+
+		var local []explorers.PodPullSpec
+		if hasRuntimeRoot(clictx) {
+			ctx, exp, cancel, err := explorerEnvironment(clictx)
+			if err != nil {
+				log.Warn("skipping node-local pods: ", err)
+			} else {
+				defer cancel()
+
+				ctrs, err := exp.ListContainers(ctx)
+				if err != nil {
+					log.Warn("listing node-local containers: ", err)
+				}
+				local = explorers.NodePods(ctrs)
+			}
+		}
+		if imageroot != "" {
+			static := explorers.StaticPods(imageroot)
+			for i := range static {
+				// The mirror pod name has the node name suffix.
+				if hostname != "" {
+					static[i].Name += "-" + hostname
+				}
+				static[i].Node = hostname
+			}
+			local = append(static, local...)
+		}
+		pods = mergePodPullSpecs(pods, local, hostname)
+
+		var creds []explorers.RegistryCredential
+		if imageroot != "" {
+			creds = explorers.NodeRegistryCredentials(imageroot, pack.Kubelet())
+		}
+
+		var exposures []pullExposure
+		for _, pod := range pods {
+			var nodecreds []explorers.RegistryCredential
+			if pod.Node == "" || pod.Node == hostname {
+				nodecreds = creds
+			}
+			for _, c := range pod.Containers {
+				e := newPullExposure(pod, c, saSecrets, nodecreds)
+				if clictx.Bool("flagged") && len(e.Flags) == 0 {
+					continue
+				}
+				exposures = append(exposures, e)
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, e := range exposures {
+				printObject(output, e)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		rw.Write("NAMESPACE", "POD", "NODE", "CONTAINER", "IMAGE", "PULL POLICY", "PULL SECRETS", "SOURCE", "FLAGS")
+		for _, e := range exposures {
+			policy := e.PullPolicy
+			switch {
+			case policy == "":
+				policy = "unknown"
+			case e.DefaultPolicy:
+				policy += " (default)"
+			}
+			secrets := strings.Join(e.PullSecrets, ",")
+			if e.InheritedFromSA {
+				secrets += " (sa " + e.ServiceAccount + ")"
+			}
+			rw.Write(e.Namespace, e.Pod, e.Node, e.Container, e.Image, policy, secrets, e.Source, strings.Join(e.Flags, ","))
+		}
+		rw.Flush()
+
+		if len(creds) == 0 {
+			return nil
+		}
+		fmt.Println()
+		rw = newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("NODE CREDENTIAL SOURCE", "REGISTRY", "TYPE", "HELPER")
+		for _, c := range creds {
+			rw.Write(c.Source, c.Registry, c.Type, c.Helper)
+		}
+		return nil
+	},
+}
+
+// etcdPodPullSpec returns the image pull configuration of a pod stored in
+// etcd.
+func etcdPodPullSpec(o etcd.Object) explorers.PodPullSpec {
+	spec := explorers.PodPullSpec{
+		Namespace:      o.Namespace,
+		Name:           o.Name,
+		UID:            o.UID,
+		Node:           o.NodeName,
+		ServiceAccount: o.ServiceAccount,
+		PullSecrets:    o.PullSecrets,
+		Containers:     []explorers.PullContainer{},
+		Source:         "etcd",
+	}
+	for _, c := range o.ContainerSpecs {
+		spec.Containers = append(spec.Containers, explorers.PullContainer{
+			Name:       c.Name,
+			Image:      c.Image,
+			PullPolicy: c.ImagePullPolicy,
+		})
+	}
+	return spec
+}
+
+// mergePodPullSpecs returns the etcd pods followed by the node-local pods
+// not stored in etcd. The node-local pods run on the node.
+func mergePodPullSpecs(pods []explorers.PodPullSpec, local []explorers.PodPullSpec, hostname string) []explorers.PodPullSpec {
+	seen := make(map[string]bool)
+	for _, p := range pods {
+		seen[p.Namespace+"/"+p.Name] = true
+		if p.UID != "" {
+			seen[p.UID] = true
+		}
+	}
+	for _, p := range local {
+		if seen[p.Namespace+"/"+p.Name] || (p.UID != "" && seen[p.UID]) {
+			continue
+		}
+		seen[p.Namespace+"/"+p.Name] = true
+		if p.UID != "" {
+			seen[p.UID] = true
+		}
+		if p.Node == "" {
+			p.Node = hostname
+		}
+		pods = append(pods, p)
+	}
+	return pods
+}
+
+// newPullExposure returns the image pull exposure of a pod container.
+func newPullExposure(pod explorers.PodPullSpec, c explorers.PullContainer, saSecrets map[string][]string, creds []explorers.RegistryCredential) pullExposure {
+	e := pullExposure{
+		Namespace:      pod.Namespace,
+		Pod:            pod.Name,
+		PodUID:         pod.UID,
+		Node:           pod.Node,
+		Source:         pod.Source,
+		Container:      c.Name,
+		Image:          c.Image,
+		Registry:       explorers.ImageRegistry(c.Image),
+		PullPolicy:     c.PullPolicy,
+		ServiceAccount: pod.ServiceAccount,
+		PullSecrets:    pod.PullSecrets,
+	}
+	if e.ServiceAccount == "" && pod.Source != "runtime labels" {
+		e.ServiceAccount = defaultServiceAccountName
+	}
+
+	// The API server sets the default pull policy. The pull policy of a
+	// pod known only from the runtime labels is unknown.
+	if e.PullPolicy == "" && pod.Source != "runtime labels" {
+		e.PullPolicy = explorers.DefaultPullPolicy(c.Image)
+		e.DefaultPolicy = true
+	}
+
+	// The service account admission controller copies the pull secrets
+	// of the service account to a pod without pull secrets.
+	if sa, found := saSecrets[pod.Namespace+"/"+e.ServiceAccount]; found && len(sa) > 0 {
+		if len(e.PullSecrets) == 0 {
+			e.PullSecrets = sa
+		}
+		if containsAll(sa, e.PullSecrets) {
+			e.InheritedFromSA = true
+			if e.ServiceAccount == defaultServiceAccountName {
+				e.Flags = append(e.Flags, pullFlagDefaultSA)
+			} else {
+				e.Flags = append(e.Flags, pullFlagServiceAccount)
+			}
+		}
+	}
+
+	for _, cred := range creds {
+		if !cred.Matches(c.Image) {
+			continue
+		}
+		e.NodeCredentials = append(e.NodeCredentials, cred)
+		if !hasString(e.Flags, pullFlagNodeCredential) {
+			e.Flags = append(e.Flags, pullFlagNodeCredential)
+		}
+		if cred.Wildcard() && !hasString(e.Flags, pullFlagWildcardCredential) {
+			e.Flags = append(e.Flags, pullFlagWildcardCredential)
+		}
+	}
+
+	// A private image pulled once is used by any pod on the node unless the
+	// image is pulled with Always and the credentials are verified.
+	if (len(e.PullSecrets) > 0 || len(e.NodeCredentials) > 0) && e.PullPolicy != "" && e.PullPolicy != explorers.PullAlways {
+		e.Flags = append(e.Flags, pullFlagCachedPrivateImage)
+	}
+	return e
+}
+
+// nodeHostname returns the hostname in /etc/hostname within the image root.
+func nodeHostname(imageroot string) string {
+	if imageroot == "" {
+		return ""
+	}
+	data, _, err := explorers.ReadHostFile(imageroot, "/etc/hostname")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// containsAll returns true if every value is in the list.
+func containsAll(list []string, values []string) bool {
+	for _, v := range values {
+		if !hasString(list, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasString returns true if the list has the value.
+func hasString(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
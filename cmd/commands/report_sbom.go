@@ -0,0 +1,283 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// SBOM sources of a container.
+const (
+	sbomAttested  = "attested"  // in-toto SBOM attestation attached to the image
+	sbomAttached  = "attached"  // SBOM attached to the image without attestation
+	sbomGenerated = "generated" // packages found in the container filesystem
+)
+
+// sbomSummary holds the SBOM of a container and the discrepancies between
+// the attached SBOM and the container filesystem.
+type sbomSummary struct {
+	Namespace       string                      `json:"namespace"`
+	ContainerID     string                      `json:"container_id"`
+	Image           string                      `json:"image"`
+	Source          string                      `json:"source"`
+	Format          string                      `json:"format,omitempty"`
+	SBOMDigest      string                      `json:"sbom_digest,omitempty"`
+	Packages        []explorers.Package         `json:"packages"`
+	Generated       int                         `json:"generated"`
+	Discrepancies   []explorers.SBOMDiscrepancy `json:"discrepancies,omitempty"`
+	Vulnerabilities []explorers.Referrer        `json:"vulnerability_attestations,omitempty"`
+	Scanners        []string                    `json:"scanners,omitempty"`
+	Referrers       []explorers.Referrer        `json:"referrers,omitempty"`
+}
+
+var reportSBOM = cli.Command{
+	Name:  "sbom",
+	Usage: "report the SBOM and vulnerability attestations attached to container images",
+	Description: `locate the SBOM and vulnerability attestations attached to the container
+   images and cached in the content store i.e. pulled using the OCI referrers
+   API, Docker BuildKit attestation manifests, or cosign attachments.
+
+   An attested SBOM is preferred over an SBOM attached without attestation,
+   and an attached SBOM is preferred over the packages generated from the
+   container filesystem. The discrepancies between the attached SBOM and the
+   generated packages are recorded.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "report only the specified container ID",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		cli.BoolFlag{
+			Name:  "details",
+			Usage: "show the discrepancies between the attached SBOM and the generated packages",
+		},
+		explainFlag,
+		formatFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerSBOM); err != nil {
+			return err
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		var summaries []sbomSummary
+		for _, ctr := range ctrs {
+			if id := clictx.String("id"); id != "" && ctr.ID != id {
+				continue
+			}
+			if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+			summaries = append(summaries, containerSBOM(ctx, exp, ctr))
+		}
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, s := range summaries {
+				for _, d := range s.Discrepancies {
+					explanations = append(explanations, explainSBOMDiscrepancy(s, d))
+				}
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, s := range summaries {
+				printObject(output, s)
+			}
+			return nil
+		}
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+		if tmpl != nil {
+			for _, s := range summaries {
+				printTemplate(tmpl, s)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+
+		if clictx.Bool("details") {
+			rw.Write("NAMESPACE", "CONTAINER ID", "CHANGE", "TYPE", "PACKAGE", "SBOM VERSION", "FILESYSTEM VERSION")
+			for _, s := range summaries {
+				for _, d := range s.Discrepancies {
+					rw.Write(
+						s.Namespace,
+						s.ContainerID,
+						d.Change,
+						d.Type,
+						d.Name,
+						d.SBOMVersion,
+						d.FilesystemVersion,
+					)
+				}
+			}
+			return nil
+		}
+
+		rw.Write("NAMESPACE", "CONTAINER ID", "IMAGE", "SOURCE", "FORMAT", "PACKAGES", "GENERATED", "DISCREPANCIES", "VULN ATTESTATIONS", "SCANNERS")
+		for _, s := range summaries {
+			rw.Write(
+				s.Namespace,
+				s.ContainerID,
+				s.Image,
+				s.Source,
+				s.Format,
+				fmt.Sprint(len(s.Packages)),
+				fmt.Sprint(s.Generated),
+				fmt.Sprint(len(s.Discrepancies)),
+				fmt.Sprint(len(s.Vulnerabilities)),
+				strings.Join(s.Scanners, ","),
+			)
+		}
+		return nil
+	},
+}
+
+// containerSBOM returns the SBOM of a container.
+//
+// The packages are generated from the container filesystem when the image
+// has no SBOM attached or the attached SBOMs cannot be decoded.
+func containerSBOM(ctx context.Context, exp explorers.ContainerExplorer, ctr explorers.Container) sbomSummary {
+	summary := sbomSummary{
+		Namespace:   ctr.Namespace,
+		ContainerID: ctr.ID,
+		Image:       ctr.Image,
+		Source:      sbomGenerated,
+	}
+
+	var generated []explorers.Package
+	layers, err := containerLayers(ctx, exp, ctr)
+	if err == nil {
+		generated, err = explorers.ListPackages(layers)
+	}
+	if err != nil {
+		log.WithField("containerid", ctr.ID).Warn("listing packages: ", err)
+	}
+	summary.Generated = len(generated)
+	summary.Packages = generated
+
+	re, ok := exp.(explorers.ReferrerExplorer)
+	if !ok {
+		log.Debug("explorer does not keep image referrers")
+		return summary
+	}
+	referrers, err := re.ImageReferrers(namespaces.WithNamespace(ctx, ctr.Namespace), ctr.Image)
+	if err != nil {
+		log.WithField("containerid", ctr.ID).Warn("reading image referrers: ", err)
+		return summary
+	}
+	summary.Referrers = referrers
+
+	// Attested SBOMs are decoded first.
+	var sboms []explorers.Referrer
+	for _, r := range referrers {
+		switch r.Kind {
+		case explorers.ReferrerSBOM:
+			if r.Attested {
+				sboms = append([]explorers.Referrer{r}, sboms...)
+			} else {
+				sboms = append(sboms, r)
+			}
+		case explorers.ReferrerVulnerability:
+			summary.Vulnerabilities = append(summary.Vulnerabilities, r)
+			if doc, err := explorers.ReadReferrerDocument(r); err == nil && doc.Scanner != "" {
+				summary.Scanners = append(summary.Scanners, doc.Scanner)
+			}
+		}
+	}
+
+	for _, r := range sboms {
+		doc, err := explorers.ReadReferrerDocument(r)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"containerid": ctr.ID,
+				"digest":      r.Digest,
+			}).Warn("reading SBOM: ", err)
+			continue
+		}
+		if len(doc.Packages) == 0 {
+			continue
+		}
+
+		summary.Source = sbomAttached
+		if r.Attested {
+			summary.Source = sbomAttested
+		}
+		summary.Format = doc.Format
+		summary.SBOMDigest = r.Digest
+		summary.Packages = doc.Packages
+		if len(generated) > 0 {
+			summary.Discrepancies = explorers.CompareSBOM(doc.Packages, generated)
+		}
+		break
+	}
+	return summary
+}
+
+// explainSBOMDiscrepancy returns the explanation of a discrepancy between
+// the attached SBOM and the container filesystem.
+func explainSBOMDiscrepancy(s sbomSummary, d explorers.SBOMDiscrepancy) explanation {
+	sbom := fmt.Sprintf("%s %s SBOM %s", s.Source, s.Format, s.SBOMDigest)
+	e := explanation{
+		Namespace:   s.Namespace,
+		ContainerID: s.ContainerID,
+		Finding:     fmt.Sprintf("%s package %s %s", d.Type, d.Name, d.Change),
+	}
+	switch d.Change {
+	case "missing":
+		e.Rule = "the package is listed in the SBOM but not found in the package databases of the container filesystem"
+		e.Evidence = append(e.Evidence, evidence{Source: sbom, Field: "version", Value: d.SBOMVersion})
+	case "unlisted":
+		e.Rule = "the package is found in the container filesystem but not listed in the SBOM"
+		e.Evidence = append(e.Evidence, evidence{Source: d.FilesystemPath, Field: "version", Value: d.FilesystemVersion})
+	default:
+		e.Rule = "the package version in the SBOM differs from the version found in the container filesystem"
+		e.Evidence = append(e.Evidence,
+			evidence{Source: sbom, Field: "version", Value: d.SBOMVersion},
+			evidence{Source: d.FilesystemPath, Field: "version", Value: d.FilesystemVersion},
+		)
+	}
+	return e
+}
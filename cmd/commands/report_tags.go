@@ -0,0 +1,161 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/container-explorer/explorers/containerd"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var reportTagHistory = cli.Command{
+	Name:  "tag-history",
+	Usage: "recover previous image tag to digest mappings from metadata remnants",
+	Description: `carve the previous image records from the free and unallocated pages of
+   meta.db and from the leftover database copies, and report the image names
+   that now resolve to a different digest or no longer exist.
+
+   A tag repointed to a different digest shortly before an incident is strong
+   evidence of an image substitution. The live and carved leases referencing
+   the previous or current digest tell when the digests were pulled.
+
+   The remnants are overwritten when bolt reuses the pages, so the history is
+   incomplete.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "repointed",
+			Usage: "report only the names that now resolve to a different digest",
+		},
+		explainFlag,
+		formatFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerTagHistory); err != nil {
+			return err
+		}
+
+		containerdroot, metadatafile, _ := resolveContainerdPaths(
+			clictx.GlobalString("image-root"),
+			containerdRoot(clictx),
+			clictx.GlobalString("metadata-file"),
+			clictx.GlobalString("snapshot-metadata-file"),
+		)
+
+		var copies []string
+		for _, a := range containerd.FindMetadataArtifacts(containerdroot) {
+			if a.Bolt && !a.SnapshotDB() {
+				copies = append(copies, a.Path)
+			}
+		}
+
+		histories, err := containerd.RecoverTagHistory(metadatafile, copies)
+		if err != nil {
+			return err
+		}
+		if clictx.Bool("repointed") {
+			var repointed []containerd.TagHistory
+			for _, h := range histories {
+				if h.CurrentDigest != "" {
+					repointed = append(repointed, h)
+				}
+			}
+			histories = repointed
+		}
+		if len(histories) == 0 {
+			log.WithField("metadatafile", metadatafile).Info("no previous image tag mappings recovered")
+			return nil
+		}
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, h := range histories {
+				explanations = append(explanations, explainTagHistory(h))
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, h := range histories {
+				printObject(output, h)
+			}
+			return nil
+		}
+
+		tmpl, err := formatTemplate(clictx)
+		if err != nil {
+			return err
+		}
+		if tmpl != nil {
+			for _, h := range histories {
+				printTemplate(tmpl, h)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("NAMESPACE", "NAME", "CHANGE", "PREVIOUS DIGEST", "PREVIOUS UPDATED AT", "CURRENT DIGEST", "CURRENT UPDATED AT", "SOURCES", "LEASES")
+		for _, h := range histories {
+			var leases []string
+			for _, l := range h.Leases {
+				leases = append(leases, l.ID)
+			}
+			rw.Write(
+				h.Namespace,
+				h.Name,
+				h.Change,
+				h.Digest,
+				staleTime(h.UpdatedAt),
+				h.CurrentDigest,
+				staleTime(h.CurrentUpdatedAt),
+				fmt.Sprint(len(h.Sources)),
+				strings.Join(leases, ","),
+			)
+		}
+		return nil
+	},
+}
+
+// explainTagHistory returns the explanation of a recovered image tag
+// mapping.
+func explainTagHistory(h containerd.TagHistory) explanation {
+	e := explanation{
+		Namespace: h.Namespace,
+		Finding:   fmt.Sprintf("image %s previously resolved to %s", h.Name, h.Digest),
+		Rule:      "an image record carved from a free or unallocated bolt page or a leftover copy has a digest that differs from the live image record",
+	}
+	if h.Change == "deleted" {
+		e.Finding = fmt.Sprintf("image %s resolving to %s was deleted", h.Name, h.Digest)
+		e.Rule = "an image record carved from a free or unallocated bolt page or a leftover copy has no live image record"
+	}
+
+	for _, source := range h.Sources {
+		e.Evidence = append(e.Evidence, evidence{Source: source, Field: "target/digest", Value: h.Digest, Time: h.UpdatedAt})
+	}
+	if h.CurrentDigest != "" {
+		e.Evidence = append(e.Evidence, evidence{Source: "meta.db", Field: "target/digest", Value: h.CurrentDigest, Time: h.CurrentUpdatedAt})
+	}
+	for _, l := range h.Leases {
+		e.Evidence = append(e.Evidence, evidence{Source: l.Source, Field: "leases/" + l.ID + "/content", Value: l.Digest, Time: l.CreatedAt})
+	}
+	return e
+}
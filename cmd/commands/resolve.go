@@ -0,0 +1,231 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// hostLayer is a snapshot or layer directory on the host.
+type hostLayer struct {
+	Snapshotter string
+	Key         string
+	ID          uint64
+	Kind        string
+	Dir         string
+}
+
+// resolvedPath is a host path resolved to a layer and the containers using
+// the layer.
+type resolvedPath struct {
+	HostPath    string       `json:"host_path"`
+	Inode       uint64       `json:"inode,omitempty"`
+	Snapshotter string       `json:"snapshotter,omitempty"`
+	SnapshotKey string       `json:"snapshot_key,omitempty"`
+	SnapshotID  uint64       `json:"snapshot_id,omitempty"`
+	Kind        string       `json:"kind,omitempty"`
+	LayerDir    string       `json:"layer_dir,omitempty"`
+	LayerPath   string       `json:"layer_path,omitempty"` // path within the layer
+	Containers  []layerOwner `json:"containers"`
+}
+
+var ResolveCommand = cli.Command{
+	Name:  "resolve",
+	Usage: "resolve a host path or inode to a layer and containers",
+	Description: `resolve a host path or an inode number reported by a host-side alert
+   i.e. EDR to the snapshot or layer containing the file, the containers
+   sharing the layer, and the path within each container.
+
+   The host path may be specified with or without the image root prefix.
+   An inode number is searched in all the snapshot and layer directories.
+   The device number of the alert is not used because the evidence
+   filesystem has a different device number on the analysis host.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "host-path",
+			Usage: "host path of the file",
+		},
+		cli.Uint64Flag{
+			Name:  "inode",
+			Usage: "inode number of the file",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		hostpath := clictx.String("host-path")
+		inode := clictx.Uint64("inode")
+		if (hostpath == "") == (inode == 0) {
+			return fmt.Errorf("specify either --host-path or --inode")
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		imageroot := clictx.GlobalString("image-root")
+		layers := hostLayers(ctx, exp)
+
+		var results []resolvedPath
+		if hostpath != "" {
+			result, err := resolveHostPath(ctx, exp, imageroot, layers, hostpath)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		} else {
+			for _, path := range findInode(layers, inode) {
+				result, err := resolveHostPath(ctx, exp, imageroot, layers, path)
+				if err != nil {
+					return err
+				}
+				result.Inode = inode
+				results = append(results, result)
+			}
+			if len(results) == 0 {
+				return fmt.Errorf("inode %d not found in the snapshot and layer directories", inode)
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, r := range results {
+				printObject(output, r)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("HOST PATH", "SNAPSHOTTER", "SNAPSHOT KEY", "KIND", "NAMESPACE", "CONTAINER ID", "POD", "LAYER", "PATH")
+		for _, r := range results {
+			if len(r.Containers) == 0 {
+				rw.Write(r.HostPath, r.Snapshotter, r.SnapshotKey, r.Kind, "", "", "", "", "")
+				continue
+			}
+			for _, o := range r.Containers {
+				rw.Write(r.HostPath, r.Snapshotter, r.SnapshotKey, r.Kind, o.Namespace, o.ContainerID, o.Pod, o.Layer, o.Path)
+			}
+		}
+		return nil
+	},
+}
+
+// hostLayers returns the snapshot directories. The container layer
+// directories are returned if the runtime does not report snapshots.
+func hostLayers(ctx context.Context, exp explorers.ContainerExplorer) []hostLayer {
+	var layers []hostLayer
+
+	ss, err := exp.ListSnapshots(ctx)
+	if err != nil {
+		log.Debug("listing snapshots: ", err)
+	}
+	for _, s := range ss {
+		if s.OverlayPath == "" || s.Device != "" || s.Dataset != "" {
+			continue
+		}
+		layers = append(layers, hostLayer{
+			Snapshotter: s.Snapshotter,
+			Key:         s.Key,
+			ID:          s.ID,
+			Kind:        s.Kind.String(),
+			Dir:         filepath.Join(exp.SnapshotRoot(s.Snapshotter), s.OverlayPath),
+		})
+	}
+	if len(layers) > 0 {
+		return layers
+	}
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err != nil {
+		log.Debug("listing containers: ", err)
+	}
+	seen := make(map[string]bool)
+	for _, ctr := range ctrs {
+		dirs, err := containerLayers(ctx, exp, ctr)
+		if err != nil {
+			continue
+		}
+		for _, dir := range dirs {
+			if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+				dir = resolved
+			}
+			if !seen[dir] {
+				seen[dir] = true
+				layers = append(layers, hostLayer{Snapshotter: ctr.Snapshotter, Dir: dir})
+			}
+		}
+	}
+	return layers
+}
+
+// resolveHostPath returns the layer containing the host path and the
+// containers using the layer.
+func resolveHostPath(ctx context.Context, exp explorers.ContainerExplorer, imageroot string, layers []hostLayer, hostpath string) (resolvedPath, error) {
+	result := resolvedPath{HostPath: hostpath}
+
+	candidates := hostPathCandidates(imageroot, hostpath)
+	for _, layer := range layers {
+		rel, found := pathInDir(candidates, layer.Dir)
+		if !found {
+			continue
+		}
+		result.Snapshotter = layer.Snapshotter
+		result.SnapshotKey = layer.Key
+		result.SnapshotID = layer.ID
+		result.Kind = layer.Kind
+		result.LayerDir = layer.Dir
+		result.LayerPath = filepath.Join("/", rel)
+		break
+	}
+
+	owners, err := findLayerOwners(ctx, exp, imageroot, hostpath)
+	if err != nil {
+		return result, err
+	}
+	result.Containers = owners
+
+	if result.LayerDir == "" && len(owners) == 0 {
+		return result, fmt.Errorf("%s is not within a snapshot or layer directory", hostpath)
+	}
+	return result, nil
+}
+
+// findInode returns the paths of the files with the inode number in the
+// layer directories.
+func findInode(layers []hostLayer, inode uint64) []string {
+	var paths []string
+	for _, layer := range layers {
+		filepath.Walk(layer.Dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if ino, ok := fileInode(info); ok && ino == inode {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+	}
+	return paths
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"path/filepath"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/docker"
+	"github.com/google/container-explorer/explorers/knowledge"
+	"github.com/google/container-explorer/explorers/lxd"
+	"github.com/google/container-explorer/explorers/podman"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Container runtimes selected using --runtime.
+const (
+	runtimeAuto       = "auto"
+	runtimeContainerd = "containerd"
+	runtimeDocker     = "docker"
+	runtimeCrio       = "crio"
+	runtimePodman     = "podman"
+	runtimeLXD        = "lxd"
+	runtimeK3s        = "k3s"
+)
+
+// detectedRuntime caches the runtime detected from the image root.
+var detectedRuntime string
+
+// selectedRuntime returns the container runtime of the evidence.
+//
+// The runtime is selected using --runtime, --distro, the legacy flags i.e.
+// --docker-managed, or the runtime specific root flags. Otherwise the
+// runtime is detected from the image root. The default is containerd.
+func selectedRuntime(clictx *cli.Context) string {
+	if r := clictx.GlobalString("runtime"); r != "" && r != runtimeAuto {
+		return r
+	}
+	if d := clictx.GlobalString("distro"); d != "" {
+		return d
+	}
+
+	switch {
+	case clictx.GlobalBool("docker-managed"):
+		return runtimeDocker
+	case clictx.GlobalBool("crio-managed"):
+		return runtimeCrio
+	case clictx.GlobalBool("podman-managed"):
+		return runtimePodman
+	case clictx.GlobalString("containerd-root") != "":
+		return runtimeContainerd
+	case clictx.GlobalString("docker-root") != "":
+		return runtimeDocker
+	case clictx.GlobalString("crio-root") != "":
+		return runtimeCrio
+	case clictx.GlobalString("podman-root") != "":
+		return runtimePodman
+	case clictx.GlobalString("lxd-root") != "":
+		return runtimeLXD
+	}
+
+	imageroot := clictx.GlobalString("image-root")
+	if imageroot == "" {
+		return runtimeContainerd
+	}
+
+	if detectedRuntime == "" {
+		detected := detectRuntimes(imageroot)
+		detectedRuntime = runtimeContainerd
+		if len(detected) > 0 {
+			detectedRuntime = detected[0]
+		}
+
+		log.WithFields(log.Fields{
+			"imageroot": imageroot,
+			"detected":  detected,
+			"selected":  detectedRuntime,
+		}).Info("detected container runtime. Use --runtime to override")
+	}
+	return detectedRuntime
+}
+
+// detectRuntimes returns the container runtimes found in the image root
+// ordered by preference.
+//
+// The distributions described by the knowledge packs i.e. k3s and RKE2 are
+// preferred over the system containerd. Docker
+// is preferred over containerd if docker has containers because docker uses
+// containerd internally. The rootless docker roots in the user home
+// directories are also checked. Podman and CRI-O share containers storage.
+// Only Podman creates the libpod database.
+func detectRuntimes(imageroot string) []string {
+	var runtimes []string
+
+	path := func(p string) string {
+		return filepath.Join(imageroot, p)
+	}
+
+	for _, p := range knowledge.Detect(imageroot) {
+		runtimes = append(runtimes, p.Name)
+	}
+
+	// RKE2 is based on k3s and also uses /run/k3s.
+	if len(runtimes) == 0 && explorers.PathExists(path("run/k3s"), false) {
+		runtimes = append(runtimes, runtimeK3s)
+	}
+
+	for _, r := range docker.FindRoots(imageroot) {
+		if r.HasContainers() {
+			runtimes = append(runtimes, runtimeDocker)
+			break
+		}
+	}
+
+	storage := path(crioRootDir)
+	libpod := explorers.PathExists(filepath.Join(storage, "libpod"), false) || explorers.PathExists(filepath.Join(storage, "db.sql"), true)
+	containersfile := func(driver string) string {
+		return filepath.Join(storage, driver+"-containers", "containers.json")
+	}
+	if (explorers.PathExists(containersfile("overlay"), true) || explorers.PathExists(containersfile("vfs"), true)) && !libpod {
+		runtimes = append(runtimes, runtimeCrio)
+	}
+
+	// The containerd root directory of an immutable OS may be relocated
+	// using the containerd service or configuration file.
+	configured := explorers.ConfiguredContainerdRoot(imageroot, containerdRootDir)
+	if explorers.PathExists(path(filepath.Join(containerdRootDir, "io.containerd.metadata.v1.bolt", "meta.db")), true) ||
+		explorers.PathExists(path(filepath.Join(windowsContainerdRootDir, "io.containerd.metadata.v1.bolt", "meta.db")), true) ||
+		(configured != "" && explorers.PathExists(filepath.Join(configured, "io.containerd.metadata.v1.bolt", "meta.db"), true)) {
+		runtimes = append(runtimes, runtimeContainerd)
+	}
+
+	rootless := false
+	for _, s := range podman.FindStores(imageroot) {
+		if s.Namespace != "" {
+			rootless = true
+		}
+	}
+	if libpod || rootless {
+		runtimes = append(runtimes, runtimePodman)
+	}
+
+	// LXD often runs besides docker. The application containers are
+	// preferred over the system containers.
+	if len(lxd.FindRoots(imageroot)) > 0 || lxd.HasLXCContainers(imageroot) {
+		runtimes = append(runtimes, runtimeLXD)
+	}
+
+	if len(runtimes) == 0 && explorers.PathExists(path(dockerRootDir), false) {
+		runtimes = append(runtimes, runtimeDocker)
+	}
+	return runtimes
+}
+
+// isContainerdRuntime returns true if the runtime is containerd or the
+// containerd embedded in a Kubernetes distribution i.e. k3s.
+func isContainerdRuntime(runtime string) bool {
+	if runtime == runtimeContainerd {
+		return true
+	}
+	p, found := knowledge.Get(runtime)
+	return found && p.Runtime == knowledge.RuntimeContainerd
+}
+
+// isDockerRuntime returns true if the runtime is docker or a docker fork
+// described by a knowledge pack i.e. balena-engine.
+func isDockerRuntime(runtime string) bool {
+	if runtime == runtimeDocker {
+		return true
+	}
+	p, found := knowledge.Get(runtime)
+	return found && p.Runtime == knowledge.RuntimeDocker
+}
+
+// containerdRoot returns the containerd root directory specified using
+// --containerd-root, the containerd root directory of the Kubernetes
+// distribution, or the containerd root directory configured on the evidence
+// host within the image root. An empty string is returned for the default
+// containerd root directory.
+func containerdRoot(clictx *cli.Context) string {
+	if root := clictx.GlobalString("containerd-root"); root != "" {
+		return root
+	}
+
+	imageroot := clictx.GlobalString("image-root")
+	if p, found := knowledge.Get(selectedRuntime(clictx)); found && p.ContainerdRoot != "" && imageroot != "" {
+		return filepath.Join(imageroot, p.ContainerdRoot)
+	}
+	return explorers.ConfiguredContainerdRoot(imageroot, containerdRootDir)
+}
+
+// dockerRoot returns the docker root directory specified using --docker-root
+// or the data root of the docker fork within the image root i.e.
+// /var/lib/balena-engine. Otherwise the docker root directory is resolved
+// from the image root.
+func dockerRoot(clictx *cli.Context) string {
+	if root := clictx.GlobalString("docker-root"); root != "" {
+		return root
+	}
+
+	imageroot := clictx.GlobalString("image-root")
+	if p, found := knowledge.Get(selectedRuntime(clictx)); found && p.DockerRoot != "" && imageroot != "" {
+		return filepath.Join(imageroot, p.DockerRoot)
+	}
+	return resolveDockerRoot(imageroot, "")
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// safeModeDir holds the database copies made in safe mode.
+var safeModeDir string
+
+// SetupSafeMode enables the evidence access mode for failing source media
+// specified using the global flag --safe-mode.
+func SetupSafeMode(clictx *cli.Context) error {
+	if !clictx.GlobalBool("safe-mode") {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", "container-explorer-safe-")
+	if err != nil {
+		return fmt.Errorf("creating safe mode directory: %w", err)
+	}
+	safeModeDir = dir
+
+	opts := explorers.SafeReadOptions{
+		BlockSize: clictx.GlobalInt("read-block-size"),
+		Retries:   clictx.GlobalInt("read-retries"),
+		Backoff:   clictx.GlobalDuration("read-backoff"),
+		RateLimit: int64(clictx.GlobalInt("read-rate")) * 1024,
+		TempDir:   dir,
+	}
+	explorers.EnableSafeRead(opts)
+
+	log.WithFields(log.Fields{
+		"blocksize": opts.BlockSize,
+		"retries":   opts.Retries,
+		"backoff":   opts.Backoff,
+		"ratelimit": opts.RateLimit,
+		"dir":       dir,
+	}).Info("safe mode enabled")
+	return nil
+}
+
+// FinishSafeMode removes the database copies and reports the unreadable
+// extents found in safe mode.
+//
+// The report is written as JSON to the file specified using the global flag
+// --unreadable-report, otherwise a summary is printed.
+func FinishSafeMode(clictx *cli.Context) error {
+	if safeModeDir == "" {
+		return nil
+	}
+	defer os.RemoveAll(safeModeDir)
+
+	extents := explorers.UnreadableExtents()
+
+	if path := clictx.GlobalString("unreadable-report"); path != "" {
+		if extents == nil {
+			extents = []explorers.UnreadableExtent{}
+		}
+		data, err := json.MarshalIndent(extents, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("writing unreadable extent report: %w", err)
+		}
+		log.WithField("path", path).Info("unreadable extent report written")
+		return nil
+	}
+
+	if len(extents) == 0 {
+		return nil
+	}
+
+	var total int64
+	fmt.Fprintf(os.Stderr, "\nUnreadable extents (zero filled):\n")
+	for _, extent := range extents {
+		fmt.Fprintf(os.Stderr, "  %s offset %d length %d: %s\n", extent.Path, extent.Offset, extent.Length, extent.Error)
+		total += extent.Length
+	}
+	fmt.Fprintf(os.Stderr, "Total unreadable bytes: %d\n", total)
+	return nil
+}
@@ -0,0 +1,380 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Sources scanned for encoded blobs.
+const (
+	scanSourceSpec      = "spec"
+	scanSourceConfigMap = "configmap"
+	scanSourceScript    = "script"
+)
+
+// maxScanFileSize is the maximum size of a file scanned for encoded blobs.
+const maxScanFileSize = 1024 * 1024
+
+// encodedFinding describes a decoded blob and where it was found.
+type encodedFinding struct {
+	Namespace   string  `json:"namespace"`
+	ContainerID string  `json:"container_id"`
+	Source      string  `json:"source"`
+	Location    string  `json:"location"`
+	Offset      int     `json:"offset"`
+	Encoding    string  `json:"encoding"`
+	Length      int     `json:"length"`
+	Printable   float64 `json:"printable"`
+	Preview     string  `json:"preview"`
+}
+
+var ScanCommand = cli.Command{
+	Name:  "scan",
+	Usage: "scan containers for suspicious content",
+	Subcommands: cli.Commands{
+		scanEncoded,
+		scanHashes,
+		scanYara,
+	},
+}
+
+var scanEncoded = cli.Command{
+	Name:  "encoded",
+	Usage: "find and decode base64 and hex blobs",
+	Description: `find long base64 and hex blobs in container specs and environment
+   variables, Kubernetes configmap volumes, and shell scripts within the
+   container filesystem. The blobs are decoded one level and a preview of the
+   decoded value is reported with the provenance.
+
+   By default only the blobs decoding to mostly printable text are reported.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "scan only the specified container ID",
+		},
+		cli.IntFlag{
+			Name:  "min-length",
+			Usage: "minimum encoded blob length",
+			Value: 40,
+		},
+		cli.Float64Flag{
+			Name:  "min-printable",
+			Usage: "minimum ratio of printable characters in the decoded value. Use 0 to report all blobs",
+			Value: 0.9,
+		},
+		cli.IntFlag{
+			Name:  "preview-length",
+			Usage: "maximum length of the decoded preview",
+			Value: 120,
+		},
+		cli.BoolFlag{
+			Name:  "upper-only",
+			Usage: "scan shell scripts in the upper (writable) layer only",
+		},
+		pathsFromFlag,
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		explainFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerEncoded); err != nil {
+			return err
+		}
+
+		scope, err := pathScope(clictx)
+		if err != nil {
+			return err
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		imageroot := clictx.GlobalString("image-root")
+		minlength := clictx.Int("min-length")
+		minprintable := clictx.Float64("min-printable")
+		previewlength := clictx.Int("preview-length")
+
+		var findings []encodedFinding
+		for _, ctr := range ctrs {
+			if id := clictx.String("id"); id != "" && ctr.ID != id {
+				continue
+			}
+			if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+
+			// report adds the blobs found in data
+			report := func(source string, location string, data []byte) {
+				for _, blob := range explorers.FindEncodedBlobs(data, minlength) {
+					if blob.Printable < minprintable {
+						continue
+					}
+					findings = append(findings, encodedFinding{
+						Namespace:   ctr.Namespace,
+						ContainerID: ctr.ID,
+						Source:      source,
+						Location:    location,
+						Offset:      blob.Offset,
+						Encoding:    blob.Encoding,
+						Length:      blob.Length,
+						Printable:   blob.Printable,
+						Preview:     decodedPreview(blob.Decoded, previewlength),
+					})
+				}
+			}
+
+			nsctx := namespaces.WithNamespace(ctx, ctr.Namespace)
+
+			// container spec including environment variables and labels
+			if spec, err := containerSpecJSON(nsctx, clictx, exp, ctr); err != nil {
+				log.WithField("containerid", ctr.ID).Warn("reading container spec: ", err)
+			} else {
+				for _, v := range jsonStrings(spec) {
+					report(scanSourceSpec, v[0], []byte(v[1]))
+				}
+			}
+			var labels []string
+			for k := range ctr.Labels {
+				labels = append(labels, k)
+			}
+			sort.Strings(labels)
+			for _, k := range labels {
+				report(scanSourceSpec, fmt.Sprintf("labels.%s", k), []byte(ctr.Labels[k]))
+			}
+
+			// Kubernetes configmap volumes
+			if uid := ctr.Labels[explorers.LabelPodUID]; uid != "" && imageroot != "" {
+				dir := filepath.Join(imageroot, "var", "lib", "kubelet", "pods", uid, "volumes", "kubernetes.io~configmap")
+				scanFiles(dir, func(path string, data []byte) {
+					report(scanSourceConfigMap, path, data)
+				})
+			}
+
+			// shell scripts within the container filesystem
+			layers, err := containerLayers(ctx, exp, ctr)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("getting container layers: ", err)
+				continue
+			}
+			if clictx.Bool("upper-only") {
+				layers = layers[:1]
+			}
+			err = explorers.WalkLayers(layers, scope.Filter(func(f explorers.LayerFile) error {
+				if !f.Info.Mode().IsRegular() || f.Info.Size() > maxScanFileSize {
+					return nil
+				}
+				data, err := os.ReadFile(f.LayerPath)
+				if err != nil || !isShellScript(f.Path, data) {
+					return nil
+				}
+				report(scanSourceScript, f.Path, data)
+				return nil
+			}))
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("scanning container filesystem: ", err)
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, f := range findings {
+				explanations = append(explanations, explainEncoded(f, minlength, minprintable))
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, f := range findings {
+				printObject(output, f)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+
+		rw.Write("NAMESPACE", "CONTAINER ID", "SOURCE", "LOCATION", "OFFSET", "ENCODING", "LENGTH", "PREVIEW")
+		for _, f := range findings {
+			rw.Write(
+				f.Namespace,
+				f.ContainerID,
+				f.Source,
+				f.Location,
+				fmt.Sprint(f.Offset),
+				f.Encoding,
+				fmt.Sprint(f.Length),
+				f.Preview,
+			)
+		}
+		return nil
+	},
+}
+
+// explainEncoded returns the explanation of an encoded blob finding.
+func explainEncoded(f encodedFinding, minlength int, minprintable float64) explanation {
+	source := f.Location
+	field := ""
+	if f.Source == scanSourceSpec {
+		source = "container spec"
+		field = f.Location
+	}
+	return explanation{
+		Namespace:   f.Namespace,
+		ContainerID: f.ContainerID,
+		Finding:     fmt.Sprintf("%s blob of %d characters in %s %s", f.Encoding, f.Length, f.Source, f.Location),
+		Rule: fmt.Sprintf("a %s run of at least %d characters decodes to a value with at least %.0f%% printable characters",
+			f.Encoding, minlength, minprintable*100),
+		Evidence: []evidence{
+			{Source: source, Field: field, Value: fmt.Sprintf("%s run at offset %d, length %d", f.Encoding, f.Offset, f.Length)},
+			{Source: "decoded value", Field: "printable", Value: fmt.Sprintf("%.0f%%", f.Printable*100)},
+			{Source: "decoded value", Field: "preview", Value: f.Preview},
+		},
+	}
+}
+
+// containerSpecJSON returns the container spec as JSON.
+//
+// Docker does not store an OCI spec. The docker container configuration
+// config.v2.json is used instead.
+func containerSpecJSON(ctx context.Context, clictx *cli.Context, exp explorers.ContainerExplorer, ctr explorers.Container) ([]byte, error) {
+	if isDockerRuntime(selectedRuntime(clictx)) {
+		dockerroot := dockerRoot(clictx)
+		return os.ReadFile(filepath.Join(dockerroot, "containers", ctr.ID, "config.v2.json"))
+	}
+
+	spec, err := exp.InfoContainer(ctx, ctr.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(spec)
+}
+
+// jsonStrings returns the path and value of each string in a JSON document
+// i.e. ["process.env[3]", "TOKEN=..."]. The paths are sorted.
+func jsonStrings(data []byte) [][2]string {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+
+	var results [][2]string
+	var walk func(path string, v interface{})
+	walk = func(path string, v interface{}) {
+		switch v := v.(type) {
+		case string:
+			results = append(results, [2]string{path, v})
+		case []interface{}:
+			for i, item := range v {
+				walk(fmt.Sprintf("%s[%d]", path, i), item)
+			}
+		case map[string]interface{}:
+			for k, item := range v {
+				if path == "" {
+					walk(k, item)
+				} else {
+					walk(fmt.Sprintf("%s.%s", path, k), item)
+				}
+			}
+		}
+	}
+	walk("", v)
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i][0] < results[j][0]
+	})
+	return results
+}
+
+// scanFiles calls fn with the content of each regular file in dir.
+//
+// Kubernetes projects configmap keys as symbolic links to the files in a
+// timestamped directory. The symbolic links are not followed, so each key is
+// read once.
+func scanFiles(dir string, fn func(path string, data []byte)) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.Mode().IsRegular() || info.Size() > maxScanFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.WithField("path", path).Debug("reading file: ", err)
+			return nil
+		}
+		fn(path, data)
+		return nil
+	})
+}
+
+// isShellScript returns true if the file has a shell extension or a shell
+// interpreter directive.
+func isShellScript(path string, data []byte) bool {
+	switch filepath.Ext(path) {
+	case ".sh", ".bash", ".ksh", ".zsh":
+		return true
+	}
+	if !bytes.HasPrefix(data, []byte("#!")) {
+		return false
+	}
+	line := string(data)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	for _, shell := range []string{"sh", "bash", "ash", "dash", "ksh", "zsh"} {
+		if strings.HasSuffix(line, "/"+shell) || strings.Contains(line, "/"+shell+" ") || strings.HasSuffix(line, "env "+shell) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodedPreview returns the decoded value quoted and truncated to length.
+func decodedPreview(decoded []byte, length int) string {
+	if length > 0 && len(decoded) > length {
+		decoded = decoded[:length]
+	}
+	preview := strconv.Quote(string(decoded))
+	return preview[1 : len(preview)-1]
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/hashset"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// hashFinding is a hashed container file and the verdict of the hash sets.
+type hashFinding struct {
+	Namespace   string `json:"namespace,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	Path        string `json:"path"`
+	Layer       int    `json:"layer"`
+	Size        int64  `json:"size"`
+	MD5         string `json:"md5"`
+	SHA1        string `json:"sha1"`
+	SHA256      string `json:"sha256"`
+	Verdict     string `json:"verdict"`
+	HashSet     string `json:"hash_set,omitempty"`
+}
+
+// hashSetFlags are the hash set flags shared by the commands hashing files.
+var hashSetFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "known-good",
+		Usage: "known-good hash set i.e. NSRL NSRLFile.txt, RDSv3 database, CSV hash list, or Bloom filter. Use <backend>:<path> to specify the backend. Repeat to use multiple sets",
+	},
+	cli.StringSliceFlag{
+		Name:  "known-bad, hashset",
+		Usage: "known-bad hash set i.e. a CSV hash list exported from a threat intelligence platform or a Bloom filter. Repeat to use multiple sets",
+	},
+	cli.StringSliceFlag{
+		Name:  "nsrl",
+		Usage: "NSRL RDS known-good hash set i.e. NSRLFile.txt, the RDSv3 database, or a Bloom filter built from the RDS. Repeat to use multiple sets",
+	},
+}
+
+// hashSetMatcher returns the matcher of the hash sets specified using the
+// hash set flags. The NSRL sets are known-good sets.
+func hashSetMatcher(clictx *cli.Context) (*hashset.Matcher, error) {
+	good := append(clictx.StringSlice("known-good"), clictx.StringSlice("nsrl")...)
+	return hashset.NewMatcher(good, clictx.StringSlice("known-bad"))
+}
+
+var scanHashes = cli.Command{
+	Name:  "hashes",
+	Usage: "hash container files and triage them using known-good and known-bad hash sets",
+	Description: `compute the MD5, SHA-1, and SHA-256 of the regular files in the
+   container filesystems and classify each file as known-good, known-bad, or
+   unknown using the hash sets.
+
+   The hash set backends are nsrl for the NSRL RDS 2.x NSRLFile.txt and the
+   RDSv3 database, csv for hash lists, and bloom for Bloom filters built
+   using tools bloom. The backend is detected from the file content unless
+   specified as <backend>:<path>. A hash in both a known-bad and a known-good
+   set is known-bad.
+
+   The known-good files are omitted unless --show-known-good is specified.
+   Use --path to hash a container filesystem that is already mounted.`,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "hash only the specified container ID",
+		},
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "mounted container filesystem directory",
+		},
+		cli.BoolFlag{
+			Name:  "upper-only",
+			Usage: "hash only the files in the container writable layer",
+		},
+		cli.Int64Flag{
+			Name:  "max-size",
+			Usage: "skip files larger than the size in bytes. 0 is unlimited",
+		},
+		cli.BoolFlag{
+			Name:  "show-known-good",
+			Usage: "include the known-good files",
+		},
+		cli.StringFlag{
+			Name:  "verdict",
+			Usage: "report only the files with the verdict known-good, known-bad, or unknown",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		pathsFromFlag,
+		explainFlag,
+	}, hashSetFlags...),
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerHashes); err != nil {
+			return err
+		}
+
+		matcher, err := hashSetMatcher(clictx)
+		if err != nil {
+			return err
+		}
+		if matcher.Empty() {
+			log.Warn("no hash sets specified. Use --known-good or --known-bad to triage the files")
+		}
+
+		verdict := clictx.String("verdict")
+		switch verdict {
+		case "", hashset.KnownGood, hashset.KnownBad, hashset.Unknown:
+		default:
+			return fmt.Errorf("unsupported verdict %s", verdict)
+		}
+		showgood := clictx.Bool("show-known-good") || verdict == hashset.KnownGood
+		scope, err := pathScope(clictx)
+		if err != nil {
+			return err
+		}
+		maxsize := clictx.Int64("max-size")
+
+		var findings []hashFinding
+		counts := make(map[string]int)
+		hash := func(ctr explorers.Container, layers []string) error {
+			return explorers.WalkLayers(layers, scope.Filter(func(f explorers.LayerFile) error {
+				if !f.Info.Mode().IsRegular() || (maxsize > 0 && f.Info.Size() > maxsize) {
+					return nil
+				}
+				finding, err := hashLayerFile(f)
+				if err != nil {
+					log.WithField("path", f.LayerPath).Warn("hashing file: ", err)
+					return nil
+				}
+				finding.Namespace = ctr.Namespace
+				finding.ContainerID = ctr.ID
+				finding.Verdict, finding.HashSet = matcher.Classify(finding.SHA256, finding.SHA1, finding.MD5)
+				counts[finding.Verdict]++
+
+				if (verdict != "" && finding.Verdict != verdict) || (finding.Verdict == hashset.KnownGood && !showgood) {
+					return nil
+				}
+				findings = append(findings, finding)
+				return nil
+			}))
+		}
+
+		if dir := clictx.String("path"); dir != "" {
+			if err := hash(explorers.Container{}, []string{dir}); err != nil {
+				return err
+			}
+		} else {
+			ctx, exp, cancel, err := explorerEnvironment(clictx)
+			if err != nil {
+				return err
+			}
+			defer cancel()
+
+			ctrs, err := exp.ListContainers(ctx)
+			if err != nil {
+				return err
+			}
+			for _, ctr := range ctrs {
+				if id := clictx.String("id"); id != "" && ctr.ID != id {
+					continue
+				}
+				if !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+					continue
+				}
+
+				layers, err := containerLayers(ctx, exp, ctr)
+				if err != nil {
+					log.WithField("containerid", ctr.ID).Warn("getting container layers: ", err)
+					continue
+				}
+				if clictx.Bool("upper-only") {
+					layers = layers[:1]
+				}
+				if err := hash(ctr, layers); err != nil {
+					log.WithField("containerid", ctr.ID).Warn("hashing container filesystem: ", err)
+				}
+			}
+		}
+
+		log.WithFields(log.Fields{
+			hashset.KnownGood: counts[hashset.KnownGood],
+			hashset.KnownBad:  counts[hashset.KnownBad],
+			hashset.Unknown:   counts[hashset.Unknown],
+		}).Info("hashed files")
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, f := range findings {
+				if f.Verdict != hashset.Unknown {
+					explanations = append(explanations, explainHash(f))
+				}
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, f := range findings {
+				printObject(output, f)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+
+		rw.Write("NAMESPACE", "CONTAINER ID", "PATH", "LAYER", "SIZE", "SHA256", "VERDICT", "HASH SET")
+		for _, f := range findings {
+			rw.Write(
+				f.Namespace,
+				f.ContainerID,
+				f.Path,
+				fmt.Sprint(f.Layer),
+				fmt.Sprint(f.Size),
+				f.SHA256,
+				f.Verdict,
+				f.HashSet,
+			)
+		}
+		return nil
+	},
+}
+
+// hashLayerFile returns the MD5, SHA-1, and SHA-256 of a container file.
+func hashLayerFile(f explorers.LayerFile) (hashFinding, error) {
+	file, err := os.Open(f.LayerPath)
+	if err != nil {
+		return hashFinding{}, err
+	}
+	defer file.Close()
+
+	md5hash := md5.New()
+	sha1hash := sha1.New()
+	sha256hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5hash, sha1hash, sha256hash), file); err != nil {
+		return hashFinding{}, err
+	}
+
+	return hashFinding{
+		Path:   f.Path,
+		Layer:  f.Layer,
+		Size:   f.Info.Size(),
+		MD5:    hex.EncodeToString(md5hash.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1hash.Sum(nil)),
+		SHA256: hex.EncodeToString(sha256hash.Sum(nil)),
+	}, nil
+}
+
+// explainHash returns the explanation of a file found in a hash set.
+func explainHash(f hashFinding) explanation {
+	return explanation{
+		Namespace:   f.Namespace,
+		ContainerID: f.ContainerID,
+		Finding:     fmt.Sprintf("%s is %s", f.Path, f.Verdict),
+		Rule:        fmt.Sprintf("the MD5, SHA-1, or SHA-256 of the file is in the %s hash set %s", f.Verdict, f.HashSet),
+		Evidence: []evidence{
+			{Source: f.Path, Field: "md5", Value: f.MD5},
+			{Source: f.Path, Field: "sha1", Value: f.SHA1},
+			{Source: f.Path, Field: "sha256", Value: f.SHA256},
+		},
+	}
+}
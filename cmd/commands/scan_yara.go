@@ -0,0 +1,295 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Sources scanned with YARA rules.
+const (
+	yaraSourceContainer  = "container"
+	yaraSourceImageLayer = "image layer"
+)
+
+// yaraFinding is a YARA rule matching a container file.
+type yaraFinding struct {
+	Namespace   string                 `json:"namespace,omitempty"`
+	ContainerID string                 `json:"container_id,omitempty"`
+	Image       string                 `json:"image,omitempty"`
+	Source      string                 `json:"source"`
+	Path        string                 `json:"path"`
+	Layer       int                    `json:"layer"`
+	DiskPath    string                 `json:"disk_path"`
+	Rule        string                 `json:"rule"`
+	Strings     []explorers.YaraString `json:"strings,omitempty"`
+}
+
+var scanYara = cli.Command{
+	Name:  "yara",
+	Usage: "scan container filesystems with YARA rules",
+	Description: `scan the regular files of the merged container filesystems with YARA
+   rules and report the container, the path, the matching rule, and the
+   matched strings.
+
+   The rules are a source rule file, rules compiled using yarac, or a
+   directory searched recursively for .yar and .yara files. The files are
+   scanned using the yara command, version 4.0 or later, which must be
+   installed.
+
+   Use --image-layers to scan each image layer once as well, so the files
+   of the image deleted or replaced in the containers are scanned.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "rules",
+			Usage: "YARA rule file, compiled rules, or rules directory",
+		},
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "scan only the specified container ID",
+		},
+		cli.BoolFlag{
+			Name:  "upper-only",
+			Usage: "scan only the files in the container writable layer",
+		},
+		cli.BoolFlag{
+			Name:  "image-layers",
+			Usage: "scan the image layers of the containers once each",
+		},
+		cli.Int64Flag{
+			Name:  "max-size",
+			Usage: "skip files larger than the size in bytes. 0 is unlimited",
+		},
+		cli.IntFlag{
+			Name:  "timeout",
+			Usage: "scan timeout in seconds per file. 0 is the yara default",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		pathsFromFlag,
+		explainFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerYara); err != nil {
+			return err
+		}
+
+		rules := clictx.String("rules")
+		if rules == "" {
+			return fmt.Errorf("YARA rules are required. Use --rules")
+		}
+		scanner, err := explorers.NewYaraScanner(rules, clictx.Int("timeout"))
+		if err != nil {
+			return err
+		}
+		scope, err := pathScope(clictx)
+		if err != nil {
+			return err
+		}
+		maxsize := clictx.Int64("max-size")
+
+		// scan scans the files visible in the layers and returns the
+		// findings without the container attributes.
+		scan := func(source string, layers []string) ([]yaraFinding, error) {
+			files := make(map[string]explorers.LayerFile)
+			var paths []string
+			err := explorers.WalkLayers(layers, scope.Filter(func(f explorers.LayerFile) error {
+				if !f.Info.Mode().IsRegular() || (maxsize > 0 && f.Info.Size() > maxsize) {
+					return nil
+				}
+				files[f.LayerPath] = f
+				paths = append(paths, f.LayerPath)
+				return nil
+			}))
+			if err != nil {
+				return nil, err
+			}
+
+			matches, err := scanner.Scan(paths)
+			if err != nil {
+				return nil, err
+			}
+			var findings []yaraFinding
+			for _, m := range matches {
+				f, found := files[m.Path]
+				if !found {
+					log.WithField("path", m.Path).Debug("yara match of an unknown file")
+					continue
+				}
+				findings = append(findings, yaraFinding{
+					Source:   source,
+					Path:     f.Path,
+					Layer:    f.Layer,
+					DiskPath: m.Path,
+					Rule:     m.Rule,
+					Strings:  m.Strings,
+				})
+			}
+			log.WithFields(log.Fields{
+				"files":   len(paths),
+				"matches": len(findings),
+			}).Debug("scanned files with yara rules")
+			return findings, nil
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		var (
+			findings []yaraFinding
+			scanned  = make(map[string]bool) // scanned image layers
+			found    = false
+		)
+		for _, ctr := range ctrs {
+			if id := clictx.String("id"); id != "" && ctr.ID != id {
+				continue
+			}
+			if clictx.String("id") == "" && !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+			found = true
+
+			layers, err := containerLayers(ctx, exp, ctr)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("getting container layers: ", err)
+				continue
+			}
+
+			var sources []yaraFinding
+			ctrlayers := layers
+			if clictx.Bool("upper-only") {
+				ctrlayers = layers[:1]
+			}
+			results, err := scan(yaraSourceContainer, ctrlayers)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("scanning container filesystem: ", err)
+			}
+			sources = append(sources, results...)
+
+			if clictx.Bool("image-layers") {
+				for i, layer := range layers[1:] {
+					if scanned[layer] {
+						continue
+					}
+					scanned[layer] = true
+					results, err := scan(yaraSourceImageLayer, []string{layer})
+					if err != nil {
+						log.WithField("layer", layer).Warn("scanning image layer: ", err)
+					}
+					for j := range results {
+						results[j].Layer = i + 1
+					}
+					sources = append(sources, results...)
+				}
+			}
+
+			for _, f := range sources {
+				f.Namespace = ctr.Namespace
+				f.ContainerID = ctr.ID
+				f.Image = ctr.Image
+				findings = append(findings, f)
+			}
+		}
+		if id := clictx.String("id"); id != "" && !found {
+			return fmt.Errorf("container %s not found", id)
+		}
+
+		sort.SliceStable(findings, func(i, j int) bool {
+			if findings[i].ContainerID != findings[j].ContainerID {
+				return findings[i].ContainerID < findings[j].ContainerID
+			}
+			return findings[i].Path < findings[j].Path
+		})
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("explain") {
+			var explanations []explanation
+			for _, f := range findings {
+				explanations = append(explanations, explainYara(f, rules))
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, f := range findings {
+				printObject(output, f)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("NAMESPACE", "CONTAINER ID", "SOURCE", "LAYER", "PATH", "RULE", "STRINGS", "FIRST MATCH")
+		for _, f := range findings {
+			first := ""
+			if len(f.Strings) > 0 {
+				first = fmt.Sprintf("0x%x:%s: %s", f.Strings[0].Offset, f.Strings[0].Identifier, f.Strings[0].Data)
+			}
+			rw.Write(
+				f.Namespace,
+				f.ContainerID,
+				f.Source,
+				fmt.Sprint(f.Layer),
+				f.Path,
+				f.Rule,
+				fmt.Sprint(len(f.Strings)),
+				first,
+			)
+		}
+		return nil
+	},
+}
+
+// explainYara returns the explanation of a YARA rule matching a file.
+func explainYara(f yaraFinding, rules string) explanation {
+	e := explanation{
+		Namespace:   f.Namespace,
+		ContainerID: f.ContainerID,
+		Finding:     fmt.Sprintf("%s matches the YARA rule %s", f.Path, f.Rule),
+		Rule:        fmt.Sprintf("the file content matches the rule %s of %s", f.Rule, rules),
+	}
+	if f.Source == yaraSourceImageLayer {
+		e.Finding = fmt.Sprintf("%s in image layer %d of %s matches the YARA rule %s", f.Path, f.Layer, f.Image, f.Rule)
+	}
+	for _, s := range f.Strings {
+		e.Evidence = append(e.Evidence, evidence{
+			Source: f.DiskPath,
+			Field:  fmt.Sprintf("%s at 0x%x", s.Identifier, s.Offset),
+			Value:  s.Data,
+		})
+	}
+	if len(f.Strings) == 0 {
+		e.Evidence = append(e.Evidence, evidence{Source: f.DiskPath, Field: "rule", Value: f.Rule})
+	}
+	return e
+}
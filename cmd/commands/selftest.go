@@ -0,0 +1,301 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/selftest"
+	"github.com/urfave/cli"
+)
+
+// selfTestResult is the result of a self test check.
+type selfTestResult struct {
+	Fixture string `json:"fixture"`
+	Check   string `json:"check"`
+	Passed  bool   `json:"passed"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+var SelfTestCommand = cli.Command{
+	Name:  "selftest",
+	Usage: "validate the parsers against embedded reference evidence",
+	Description: `explore miniature reference evidence of each supported container
+   runtime and snapshotter using this build and report whether the results
+   match the expected values.
+
+   The reference evidence is built in a temporary directory: containerd with
+   the overlayfs, native, btrfs, and stargz snapshotters, containerd on
+   Windows with the windows snapshotter, docker with overlay2 and vfs, CRI-O
+   with containers storage on overlay and vfs, Podman with the libpod
+   database, and LXD with a dir storage pool. The checks cover the runtime
+   detection, the container, image, snapshot, and task listing, the
+   container spec, and the layer resolution with whiteouts. The layers of
+   Windows containers are not walked.
+
+   The zfs and devmapper snapshotters and the docker devicemapper graph
+   driver are not covered. Their layers are zfs datasets and thin devices
+   that cannot be built without privileges.
+
+   Run the self test before using a build on real evidence. The command
+   exits with an error if a check fails.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "keep",
+			Usage: "keep the reference evidence directory",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		if clictx.GlobalString("runtime") != "" && clictx.GlobalString("runtime") != runtimeAuto {
+			return fmt.Errorf("--runtime cannot be used with selftest")
+		}
+		for _, name := range []string{"distro", "containerd-root", "docker-root", "crio-root", "podman-root", "lxd-root", "metadata-file", "snapshot-metadata-file"} {
+			if clictx.GlobalString(name) != "" {
+				return fmt.Errorf("--%s cannot be used with selftest", name)
+			}
+		}
+		for _, name := range []string{"docker-managed", "crio-managed", "podman-managed"} {
+			if clictx.GlobalBool(name) {
+				return fmt.Errorf("--%s cannot be used with selftest", name)
+			}
+		}
+
+		dir, err := os.MkdirTemp("", "container-explorer-selftest-")
+		if err != nil {
+			return fmt.Errorf("creating reference evidence directory: %w", err)
+		}
+		if !clictx.Bool("keep") {
+			defer os.RemoveAll(dir)
+		}
+
+		var results []selfTestResult
+		for _, f := range selftest.Fixtures() {
+			results = append(results, runSelfTest(clictx, f, filepath.Join(dir, f.Name))...)
+		}
+
+		failed := 0
+		for _, r := range results {
+			if !r.Passed {
+				failed++
+			}
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			printObject(output, results)
+		} else {
+			rw := newRowWriter(output)
+			rw.Write("FIXTURE", "CHECK", "RESULT", "DETAIL")
+			for _, r := range results {
+				result := "PASS"
+				if !r.Passed {
+					result = "FAIL"
+				}
+				rw.Write(r.Fixture, r.Check, result, r.Detail)
+			}
+			rw.Flush()
+			fmt.Printf("\n%d checks passed, %d failed\n", len(results)-failed, failed)
+			if clictx.Bool("keep") {
+				fmt.Printf("reference evidence kept in %s\n", dir)
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("self test failed: %d of %d checks failed", failed, len(results))
+		}
+		return nil
+	},
+}
+
+// runSelfTest builds the reference evidence of a fixture in dir and
+// explores it using the global flags of the command.
+func runSelfTest(clictx *cli.Context, f selftest.Fixture, dir string) []selfTestResult {
+	var results []selfTestResult
+	check := func(name string, err error) bool {
+		r := selfTestResult{
+			Fixture: f.Name,
+			Check:   name,
+			Passed:  err == nil,
+		}
+		if err != nil {
+			r.Detail = err.Error()
+		}
+		results = append(results, r)
+		return err == nil
+	}
+
+	if !check("build reference evidence", f.Build(dir)) {
+		return results
+	}
+
+	// The explorer environment is computed from the global flags.
+	imageroot := clictx.GlobalString("image-root")
+	defer func() {
+		clictx.GlobalSet("image-root", imageroot)
+		detectedRuntime = ""
+	}()
+	if !check("set image root", clictx.GlobalSet("image-root", dir)) {
+		return results
+	}
+	detectedRuntime = ""
+
+	runtime := selectedRuntime(clictx)
+	if runtime != f.Runtime {
+		check("detect runtime", fmt.Errorf("detected %s, expected %s", runtime, f.Runtime))
+		return results
+	}
+	check("detect runtime", nil)
+
+	ctx, exp, cancel, err := explorerEnvironment(clictx)
+	if !check("open evidence", err) {
+		if cancel != nil {
+			cancel()
+		}
+		return results
+	}
+	defer cancel()
+	defer exp.Close()
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err == nil {
+		var got []string
+		for _, ctr := range ctrs {
+			got = append(got, ctr.ID+" "+ctr.Image)
+		}
+		var expected []string
+		for _, ctr := range f.Containers {
+			expected = append(expected, ctr.ID+" "+ctr.Image)
+		}
+		err = compareSelfTestValues("containers", got, expected)
+	}
+	check("list containers", err)
+
+	imgs, err := exp.ListImages(ctx)
+	if err == nil {
+		var got []string
+		for _, img := range imgs {
+			got = append(got, img.Name)
+		}
+		err = compareSelfTestValues("images", got, f.Images)
+	}
+	check("list images", err)
+
+	if f.Snapshots {
+		snapshots, err := exp.ListSnapshots(ctx)
+		if err == nil && len(snapshots) == 0 {
+			err = fmt.Errorf("no snapshot listed")
+		}
+		check("list snapshots", err)
+	}
+
+	if f.Tasks {
+		tasks, err := exp.ListTasks(ctx)
+		if err == nil {
+			var got []string
+			for _, task := range tasks {
+				got = append(got, task.Name+" "+task.ContainerType+" "+task.Status)
+			}
+			var expected []string
+			for _, ctr := range f.Containers {
+				expected = append(expected, ctr.ID+" "+ctr.Task)
+			}
+			err = compareSelfTestValues("tasks", got, expected)
+		}
+		check("list tasks", err)
+	}
+
+	for _, expected := range f.Containers {
+		namespace := ""
+		for _, ctr := range ctrs {
+			if ctr.ID == expected.ID {
+				namespace = ctr.Namespace
+			}
+		}
+		nsctx := namespaces.WithNamespace(ctx, namespace)
+
+		if f.Info {
+			info, err := exp.InfoContainer(nsctx, expected.ID, true)
+			if err == nil && info == nil {
+				err = fmt.Errorf("container information is empty")
+			}
+			check("info container "+shortID(expected.ID), err)
+		}
+
+		// The layers of another platform i.e. Windows are listed but
+		// not walked.
+		if expected.Platform == "" {
+			check("container layers "+shortID(expected.ID), checkSelfTestLayers(nsctx, exp, expected.ID))
+		}
+	}
+	return results
+}
+
+// checkSelfTestLayers resolves the layers of a reference container and
+// checks the files visible in the merged view.
+func checkSelfTestLayers(ctx context.Context, exp explorers.ContainerExplorer, containerid string) error {
+	upperdir, lowerdirs, err := exp.ContainerLayers(ctx, containerid)
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]bool)
+	err = explorers.WalkLayers(append([]string{upperdir}, lowerdirs...), func(f explorers.LayerFile) error {
+		found[f.Path] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range selftest.PresentFiles {
+		if !found[path] {
+			return fmt.Errorf("file %s not found", path)
+		}
+	}
+	for _, path := range selftest.DeletedFiles {
+		if found[path] {
+			return fmt.Errorf("deleted file %s is visible", path)
+		}
+	}
+	return nil
+}
+
+// compareSelfTestValues returns an error if the values differ from the
+// expected values in any order.
+func compareSelfTestValues(name string, got []string, expected []string) error {
+	sort.Strings(got)
+	sort.Strings(expected)
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		return fmt.Errorf("%s %v, expected %v", name, got, expected)
+	}
+	return nil
+}
+
+// shortID returns the first 12 characters of a 64 character container ID.
+func shortID(id string) string {
+	if len(id) == 64 {
+		return id[:12]
+	}
+	return id
+}
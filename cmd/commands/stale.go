@@ -0,0 +1,238 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/container-explorer/explorers/containerd"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var StaleMetadataCommand = cli.Command{
+	Name:  "stale-metadata",
+	Usage: "compare leftover copies of the containerd databases with the current databases",
+	Description: `find temporary and backup copies next to meta.db and the snapshotter
+   metadata.db files i.e. meta.db.tmp, meta.db.bak, or metadata.db~ and
+   compare the containers, images, snapshots, content, and leases with the
+   current database.
+
+   A removed object exists only in the copy and an added object exists only
+   in the current database. A copy with a higher transaction ID than the
+   current database was written after the current database i.e. the current
+   database was rolled back or replaced.
+
+   Use --artifact to compare a copy found elsewhere i.e. in a file carving
+   output. The copy is compared with meta.db unless --database is specified.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "list",
+			Usage: "list the leftover copies without comparing",
+		},
+		cli.StringFlag{
+			Name:  "artifact",
+			Usage: "path of a database copy",
+		},
+		cli.StringFlag{
+			Name:  "database",
+			Usage: "current database compared with --artifact. Default is meta.db",
+		},
+		explainFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		if err := applyAnalyzerConfig(clictx, analyzerStaleMetadata); err != nil {
+			return err
+		}
+
+		containerdroot, metadatafile, _ := resolveContainerdPaths(
+			clictx.GlobalString("image-root"),
+			containerdRoot(clictx),
+			clictx.GlobalString("metadata-file"),
+			clictx.GlobalString("snapshot-metadata-file"),
+		)
+
+		var artifacts []containerd.MetadataArtifact
+		if path := clictx.String("artifact"); path != "" {
+			artifact, err := metadataArtifact(path, clictx.String("database"), metadatafile)
+			if err != nil {
+				return err
+			}
+			artifacts = append(artifacts, artifact)
+		} else {
+			artifacts = containerd.FindMetadataArtifacts(containerdroot)
+		}
+		if len(artifacts) == 0 {
+			log.WithField("containerdroot", containerdroot).Info("no leftover database copies found")
+			return nil
+		}
+
+		output := clictx.GlobalString("output")
+		if clictx.Bool("list") {
+			if isStructuredOutput(output) {
+				for _, a := range artifacts {
+					printObject(output, a)
+				}
+				return nil
+			}
+
+			rw := newRowWriter(output)
+			defer rw.Flush()
+			rw.Write("PATH", "DATABASE", "SIZE", "MODIFIED", "BOLT", "TXID", "CURRENT TXID")
+			for _, a := range artifacts {
+				rw.Write(
+					a.Path,
+					filepath.Base(a.Database),
+					fmt.Sprint(a.Size),
+					formatTime(a.ModTime),
+					fmt.Sprint(a.Bolt),
+					fmt.Sprint(a.TxID),
+					fmt.Sprint(a.CurrentTxID),
+				)
+			}
+			return nil
+		}
+
+		var changes []containerd.MetadataChange
+		for _, a := range artifacts {
+			if !a.Bolt {
+				log.WithField("path", a.Path).Warn("skipping copy that is not a bolt database")
+				continue
+			}
+			if a.TxID > a.CurrentTxID {
+				log.WithFields(log.Fields{
+					"path":         a.Path,
+					"txid":         a.TxID,
+					"current txid": a.CurrentTxID,
+				}).Warn("copy is newer than the current database")
+			}
+
+			c, err := containerd.CompareMetadata(a)
+			if err != nil {
+				log.WithField("path", a.Path).Warn("comparing database copy: ", err)
+				continue
+			}
+			changes = append(changes, c...)
+		}
+
+		if clictx.Bool("explain") {
+			txids := make(map[string]containerd.MetadataArtifact)
+			for _, a := range artifacts {
+				txids[a.Path] = a
+			}
+			var explanations []explanation
+			for _, c := range changes {
+				explanations = append(explanations, explainMetadataChange(c, txids[c.Artifact]))
+			}
+			printExplanations(output, explanations)
+			return nil
+		}
+
+		if isStructuredOutput(output) {
+			for _, c := range changes {
+				printObject(output, c)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("ARTIFACT", "KIND", "NAMESPACE", "NAME", "CHANGE", "STALE UPDATED AT", "CURRENT UPDATED AT")
+		for _, c := range changes {
+			rw.Write(
+				c.Artifact,
+				c.Kind,
+				c.Namespace,
+				c.Name,
+				c.Change,
+				staleTime(c.StaleUpdatedAt),
+				staleTime(c.CurrentUpdatedAt),
+			)
+		}
+		return nil
+	},
+}
+
+// metadataArtifact returns a database copy specified using --artifact.
+//
+// The copy is compared with the database specified using --database or the
+// metadata database. A database named metadata.db is read as a snapshotter
+// database.
+func metadataArtifact(path string, database string, metadatafile string) (containerd.MetadataArtifact, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return containerd.MetadataArtifact{}, err
+	}
+	if database == "" {
+		database = metadatafile
+	}
+
+	artifact := containerd.MetadataArtifact{
+		Path:     path,
+		Database: database,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UTC(),
+	}
+	artifact.TxID, artifact.Bolt = containerd.BoltTxID(path)
+	artifact.CurrentTxID, _ = containerd.BoltTxID(database)
+	return artifact, nil
+}
+
+// explainMetadataChange returns the explanation of an object that differs
+// between a leftover database copy and the current database.
+func explainMetadataChange(c containerd.MetadataChange, a containerd.MetadataArtifact) explanation {
+	name := c.Name
+	if c.Namespace != "" {
+		name = fmt.Sprintf("%s/%s", c.Namespace, c.Name)
+	}
+	e := explanation{
+		Finding: fmt.Sprintf("%s %s %s", c.Kind, name, c.Change),
+		Evidence: []evidence{
+			{Source: c.Artifact, Field: "txid", Value: fmt.Sprint(a.TxID)},
+			{Source: c.Database, Field: "txid", Value: fmt.Sprint(a.CurrentTxID)},
+		},
+	}
+	switch c.Change {
+	case "removed":
+		e.Rule = "the object bucket exists in the copy but not in the current database"
+	case "added":
+		e.Rule = "the object bucket exists in the current database but not in the copy"
+	default:
+		e.Rule = "the object bucket exists in both databases with different content"
+	}
+	if a.TxID > a.CurrentTxID {
+		e.Rule += "; the copy has a higher transaction ID, so the current database was rolled back or replaced"
+	}
+	if !c.StaleUpdatedAt.IsZero() {
+		e.Evidence = append(e.Evidence, evidence{Source: c.Artifact, Field: "updatedat", Value: "object updated", Time: c.StaleUpdatedAt})
+	}
+	if !c.CurrentUpdatedAt.IsZero() {
+		e.Evidence = append(e.Evidence, evidence{Source: c.Database, Field: "updatedat", Value: "object updated", Time: c.CurrentUpdatedAt})
+	}
+	return e
+}
+
+// staleTime returns the formatted time or an empty string for the zero time.
+func staleTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return formatTime(t)
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var TimelineCommand = cli.Command{
+	Name:  "timeline",
+	Usage: "generate a bodyfile timeline of container filesystems",
+	Description: `walk container filesystems and write Sleuth Kit bodyfile records with MACB
+   timestamps for mactime or Plaso.
+
+   The file names are prefixed with the container directory name used by
+   mount-all i.e. /<namespace>_<hostname>_<container id>/etc/passwd.
+
+   Use --path to walk a container filesystem that is already mounted.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "container ID",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "generate the timeline of all containers",
+		},
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "mounted container filesystem directory",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "bodyfile path. Default is stdout",
+		},
+		cli.BoolFlag{
+			Name:  "md5",
+			Usage: "compute MD5 of regular files",
+		},
+		cli.BoolFlag{
+			Name:  "include-deleted",
+			Usage: "include files deleted in the upper layer that are recoverable from the lower layers",
+		},
+		cli.BoolFlag{
+			Name:  "show-support-containers",
+			Usage: "include Kubernetes support containers",
+		},
+		pathsFromFlag,
+	},
+	Action: func(clictx *cli.Context) error {
+		containerid := clictx.String("id")
+		dir := clictx.String("path")
+		if containerid == "" && dir == "" && !clictx.Bool("all") {
+			return fmt.Errorf("container id, path, or --all is required")
+		}
+
+		out := os.Stdout
+		if output := clictx.String("output"); output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		w := bufio.NewWriter(out)
+		defer w.Flush()
+
+		walk := explorers.WalkLayers
+		if clictx.Bool("include-deleted") {
+			walk = explorers.WalkLayersWithDeleted
+		}
+		md5sum := clictx.Bool("md5")
+		scope, err := pathScope(clictx)
+		if err != nil {
+			return err
+		}
+
+		if dir != "" {
+			return walk([]string{dir}, scope.Filter(func(f explorers.LayerFile) error {
+				writeBodyfileRecord(w, "", f, md5sum)
+				return nil
+			}))
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		ctrs, err := exp.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		namer := explorers.NewMountNamer()
+		found := false
+
+		for _, ctr := range ctrs {
+			if containerid != "" && ctr.ID != containerid {
+				continue
+			}
+			if containerid == "" && !clictx.Bool("show-support-containers") && ctr.SupportContainer {
+				continue
+			}
+			found = true
+
+			layers, err := containerLayers(ctx, exp, ctr)
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("skipping container: ", err)
+				continue
+			}
+
+			prefix := "/" + namer.Name(ctr)
+			err = walk(layers, scope.Filter(func(f explorers.LayerFile) error {
+				writeBodyfileRecord(w, prefix, f, md5sum)
+				return nil
+			}))
+			if err != nil {
+				log.WithField("containerid", ctr.ID).Warn("walking container filesystem: ", err)
+			}
+		}
+
+		if !found && containerid != "" {
+			return fmt.Errorf("container %s not found", containerid)
+		}
+		return nil
+	},
+}
+
+// writeBodyfileRecord writes a Sleuth Kit 3.x bodyfile record.
+//
+// MD5|name|inode|mode_as_string|UID|GID|size|atime|mtime|ctime|crtime
+//
+// The file creation time is not available and is set to 0.
+func writeBodyfileRecord(w io.Writer, prefix string, f explorers.LayerFile, md5sum bool) {
+	digest := "0"
+	if md5sum && f.Info.Mode().IsRegular() {
+		if sum, err := md5File(f.LayerPath); err != nil {
+			log.WithField("path", f.LayerPath).Warn("computing MD5: ", err)
+		} else {
+			digest = sum
+		}
+	}
+
+	name := path.Join(prefix, f.Path)
+	if f.Info.Mode()&os.ModeSymlink != 0 {
+		if link, err := os.Readlink(f.LayerPath); err == nil {
+			name = fmt.Sprintf("%s -> %s", name, link)
+		}
+	}
+	if f.Deleted {
+		name = fmt.Sprintf("%s (deleted)", name)
+	}
+	// The bodyfile uses | as the field separator
+	name = strings.ReplaceAll(name, "|", "\\|")
+
+	stat := explorers.StatInfo(f.Info)
+	fmt.Fprintf(w, "%s|%s|%d|%s|%d|%d|%d|%d|%d|%d|0\n",
+		digest,
+		name,
+		stat.Inode,
+		bodyfileMode(f.Info.Mode()),
+		stat.UID,
+		stat.GID,
+		f.Info.Size(),
+		unixTime(stat.Atime),
+		f.Info.ModTime().Unix(),
+		unixTime(stat.Ctime),
+	)
+}
+
+// bodyfileMode returns the mode string used by the Sleuth Kit i.e.
+// r/rrw-r--r-- for a regular file and d/drwxr-xr-x for a directory.
+func bodyfileMode(mode os.FileMode) string {
+	var t string
+	switch {
+	case mode.IsDir():
+		t = "d"
+	case mode&os.ModeSymlink != 0:
+		t = "l"
+	case mode&os.ModeCharDevice != 0:
+		t = "c"
+	case mode&os.ModeDevice != 0:
+		t = "b"
+	case mode&os.ModeNamedPipe != 0:
+		t = "p"
+	case mode&os.ModeSocket != 0:
+		t = "h"
+	default:
+		t = "r"
+	}
+
+	return fmt.Sprintf("%s/%s%s", t, t, mode.Perm().String()[1:])
+}
+
+// unixTime returns the Unix time of a timestamp or 0 for a zero timestamp.
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// md5File returns the MD5 of a file.
+func md5File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/container-explorer/explorers/archive"
+	"github.com/google/container-explorer/explorers/hashset"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var ToolsCommand = cli.Command{
+	Name:  "tools",
+	Usage: "built-in archive and compression helpers",
+	Description: `pure Go helpers for the archive and compression formats used by the
+   container workflows.
+
+   The helpers do not depend on the tar, gzip, or zstd commands of the
+   analysis host.`,
+	Subcommands: cli.Commands{
+		toolsTar,
+		toolsCompress,
+		toolsDecompress,
+		toolsBloom,
+	},
+}
+
+// tarFileFlag is the archive file flag shared by the tar subcommands.
+var tarFileFlag = cli.StringFlag{
+	Name:  "file, f",
+	Usage: "archive file. Default is stdin or stdout",
+}
+
+var toolsTar = cli.Command{
+	Name:  "tar",
+	Usage: "create, extract, or list tar archives",
+	Subcommands: cli.Commands{
+		{
+			Name:      "create",
+			Usage:     "create a tar archive of a directory",
+			ArgsUsage: "DIR",
+			Flags: []cli.Flag{
+				tarFileFlag,
+				cli.StringFlag{
+					Name:  "compress",
+					Usage: "compress the archive using gzip or zstd",
+					Value: archive.None,
+				},
+			},
+			Action: func(clictx *cli.Context) error {
+				if clictx.NArg() != 1 {
+					return fmt.Errorf("directory is required")
+				}
+
+				out, err := toolsOutput(clictx.String("file"))
+				if err != nil {
+					return err
+				}
+				defer out.Close()
+
+				w, err := archive.NewWriter(out, clictx.String("compress"))
+				if err != nil {
+					return err
+				}
+				if err := archive.WriteTar(w, clictx.Args().First()); err != nil {
+					w.Close()
+					return err
+				}
+				return w.Close()
+			},
+		},
+		{
+			Name:  "extract",
+			Usage: "extract a tar archive",
+			Description: `extract a tar archive. gzip and zstd compressed archives are
+   detected automatically.`,
+			Flags: []cli.Flag{
+				tarFileFlag,
+				cli.StringFlag{
+					Name:  "directory, C",
+					Usage: "extract to directory",
+					Value: ".",
+				},
+			},
+			Action: func(clictx *cli.Context) error {
+				r, err := toolsInput(clictx.String("file"))
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+
+				return archive.ExtractTar(r, clictx.String("directory"))
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "list the files in a tar archive",
+			Flags: []cli.Flag{
+				tarFileFlag,
+			},
+			Action: func(clictx *cli.Context) error {
+				r, err := toolsInput(clictx.String("file"))
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+
+				names, err := archive.ListTar(r)
+				for _, name := range names {
+					fmt.Println(name)
+				}
+				return err
+			},
+		},
+	},
+}
+
+var toolsCompress = cli.Command{
+	Name:      "compress",
+	Usage:     "compress stdin to stdout",
+	ArgsUsage: "gzip|zstd",
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() != 1 {
+			return fmt.Errorf("compression format is required")
+		}
+
+		w, err := archive.NewWriter(os.Stdout, clictx.Args().First())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, os.Stdin); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	},
+}
+
+var toolsDecompress = cli.Command{
+	Name:  "decompress",
+	Usage: "decompress gzip or zstd compressed stdin to stdout",
+	Action: func(clictx *cli.Context) error {
+		r, err := archive.NewReader(os.Stdin)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		_, err = io.Copy(os.Stdout, r)
+		return err
+	},
+}
+
+var toolsBloom = cli.Command{
+	Name:      "bloom",
+	Usage:     "build a Bloom filter hash set from hash lists",
+	ArgsUsage: "HASHLIST...",
+	Description: `build a Bloom filter from the hex encoded hashes in CSV or text hash lists
+   i.e. the NSRL NSRLFile.txt or a list exported from a threat intelligence
+   platform. The hash lists may be gzip or zstd compressed.
+
+   Use the Bloom filter with --known-good, --known-bad, or --nsrl of scan
+   hashes and hash. The filter uses a fraction of the memory of the hash
+   lists at the cost of the false positive rate.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file, f",
+			Usage: "Bloom filter file",
+		},
+		cli.Float64Flag{
+			Name:  "rate",
+			Usage: "false positive rate",
+			Value: 0.000001,
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		if clictx.NArg() == 0 {
+			return fmt.Errorf("hash list is required")
+		}
+		if clictx.String("file") == "" {
+			return fmt.Errorf("--file is required")
+		}
+
+		// The hash lists are read twice to size the filter.
+		readLists := func(fn func(sum string)) error {
+			for _, path := range clictx.Args() {
+				r, err := toolsInput(path)
+				if err != nil {
+					return err
+				}
+				err = hashset.ReadHashes(r, fn)
+				r.Close()
+				if err != nil {
+					return fmt.Errorf("reading %s: %w", path, err)
+				}
+			}
+			return nil
+		}
+
+		var count int
+		if err := readLists(func(string) { count++ }); err != nil {
+			return err
+		}
+		bloom, err := hashset.NewBloom(count, clictx.Float64("rate"))
+		if err != nil {
+			return err
+		}
+		if err := readLists(bloom.Add); err != nil {
+			return err
+		}
+
+		out, err := os.Create(clictx.String("file"))
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if _, err := bloom.WriteTo(out); err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"file":   clictx.String("file"),
+			"hashes": bloom.Len(),
+			"rate":   clictx.Float64("rate"),
+		}).Info("Bloom filter written")
+		return nil
+	},
+}
+
+// toolsInput returns a decompressing reader of the file or stdin.
+func toolsInput(path string) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return archive.NewReader(os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := archive.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &toolsReader{ReadCloser: r, file: f}, nil
+}
+
+// toolsReader closes the decompressing reader and the underlying file.
+type toolsReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (r *toolsReader) Close() error {
+	r.ReadCloser.Close()
+	return r.file.Close()
+}
+
+// toolsOutput returns the file or stdout.
+func toolsOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	log.WithField("file", path).Debug("writing archive")
+	return os.Create(path)
+}
+
+// nopCloser does not close the wrapped writer i.e. stdout.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/containerd"
+	"github.com/google/container-explorer/explorers/docker"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// watchEvent is emitted when a container, image, or snapshot appears or
+// disappears.
+type watchEvent struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	ID        string    `json:"id"`
+	Image     string    `json:"image,omitempty"`
+}
+
+// watchObject holds the attributes of a watched object.
+type watchObject struct {
+	Kind      string
+	Namespace string
+	ID        string
+	Image     string
+}
+
+var WatchCommand = cli.Command{
+	Name:  "watch",
+	Usage: "watch a live host for new or removed containers",
+	Description: `poll the containerd metadata database, docker containers directory, and
+   snapshotter directories and emit events when containers, images, or
+   snapshots appear or disappear.
+
+   The events are printed as JSON lines suitable for a SIEM forwarder.`,
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "interval",
+			Usage: "polling interval",
+			Value: 5 * time.Second,
+		},
+		cli.BoolFlag{
+			Name:  "emit-existing",
+			Usage: "emit present events for the objects found in the first poll",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		encoder := json.NewEncoder(os.Stdout)
+
+		var previous map[string]watchObject
+		ticker := time.NewTicker(clictx.Duration("interval"))
+		defer ticker.Stop()
+
+		for {
+			current, err := pollObjects(ctx, clictx)
+			if err != nil {
+				log.Error("polling objects: ", err)
+			} else {
+				now := time.Now().UTC()
+				if previous == nil {
+					if clictx.Bool("emit-existing") {
+						emitWatchEvents(encoder, now, "present", current, nil)
+					}
+				} else {
+					emitWatchEvents(encoder, now, "created", current, previous)
+					emitWatchEvents(encoder, now, "deleted", previous, current)
+				}
+				previous = current
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// emitWatchEvents emits an event for each object in objects that does not
+// exist in exclude.
+func emitWatchEvents(encoder *json.Encoder, now time.Time, event string, objects map[string]watchObject, exclude map[string]watchObject) {
+	var keys []string
+	for k := range objects {
+		if _, found := exclude[k]; !found {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		o := objects[k]
+		if err := encoder.Encode(watchEvent{
+			Time:      now,
+			Event:     fmt.Sprintf("%s_%s", o.Kind, event),
+			Kind:      o.Kind,
+			Namespace: o.Namespace,
+			ID:        o.ID,
+			Image:     o.Image,
+		}); err != nil {
+			log.Error("encoding event: ", err)
+		}
+	}
+}
+
+// pollObjects returns the containers, images, and snapshots currently on the
+// host.
+//
+// A running containerd holds a lock on the metadata database. The databases
+// are copied to a temporary directory before reading.
+func pollObjects(ctx context.Context, clictx *cli.Context) (map[string]watchObject, error) {
+	imageroot := clictx.GlobalString("image-root")
+	sc := supportContainerData(clictx)
+
+	var (
+		exp          explorers.ContainerExplorer
+		snapshotdirs []string
+		err          error
+	)
+
+	if isDockerRuntime(selectedRuntime(clictx)) {
+		dockerroot := dockerRoot(clictx)
+		exp, err = docker.NewExplorer(dockerroot, "", "", "", sc)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		containerdroot, metadatafile, snapshotfile := resolveContainerdPaths(
+			imageroot,
+			containerdRoot(clictx),
+			clictx.GlobalString("metadata-file"),
+			clictx.GlobalString("snapshot-metadata-file"),
+		)
+
+		tmpdir, err := os.MkdirTemp("", "container-explorer-watch-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmpdir)
+
+		tmpmetadata := filepath.Join(tmpdir, "meta.db")
+		if err := copyFile(metadatafile, tmpmetadata); err != nil {
+			return nil, err
+		}
+		tmpsnapshot := filepath.Join(tmpdir, "metadata.db")
+		if err := copyFile(snapshotfile, tmpsnapshot); err != nil {
+			log.Debug("copying snapshot database: ", err)
+		}
+
+		exp, err = containerd.NewExplorer(imageroot, containerdroot, tmpmetadata, tmpsnapshot, sc)
+		if err != nil {
+			return nil, err
+		}
+		defer exp.Close()
+
+		snapshotdirs, _ = filepath.Glob(filepath.Join(containerdroot, "io.containerd.snapshotter.v1.*", "snapshots", "*"))
+	}
+
+	objects := make(map[string]watchObject)
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+	for _, ctr := range ctrs {
+		objects[fmt.Sprintf("container/%s/%s", ctr.Namespace, ctr.ID)] = watchObject{
+			Kind:      "container",
+			Namespace: ctr.Namespace,
+			ID:        ctr.ID,
+			Image:     ctr.Image,
+		}
+	}
+
+	images, err := exp.ListImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing images: %w", err)
+	}
+	for _, image := range images {
+		objects[fmt.Sprintf("image/%s/%s", image.Namespace, image.Name)] = watchObject{
+			Kind:      "image",
+			Namespace: image.Namespace,
+			ID:        image.Name,
+			Image:     string(image.Target.Digest),
+		}
+	}
+
+	for _, dir := range snapshotdirs {
+		id := fmt.Sprintf("%s/%s", filepath.Base(filepath.Dir(filepath.Dir(dir))), filepath.Base(dir))
+		objects[fmt.Sprintf("snapshot/%s", id)] = watchObject{
+			Kind: "snapshot",
+			ID:   id,
+		}
+	}
+
+	return objects, nil
+}
+
+// copyFile copies a file from src to dst.
+func copyFile(src string, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return explorers.CopyFile(src, dst, info)
+}
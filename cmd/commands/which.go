@@ -0,0 +1,208 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// layerOwner is a container using a layer directory.
+type layerOwner struct {
+	Namespace    string `json:"namespace"`
+	ContainerID  string `json:"container_id"`
+	Image        string `json:"image"`
+	Pod          string `json:"pod,omitempty"`
+	PodNamespace string `json:"pod_namespace,omitempty"`
+	Layer        string `json:"layer"` // upper or lower-<n> ordered from top to bottom
+	LayerDir     string `json:"layer_dir"`
+	Path         string `json:"path,omitempty"` // path within the container
+}
+
+var WhichContainerCommand = cli.Command{
+	Name:  "which-container",
+	Usage: "find the containers owning a snapshot or a host path",
+	Description: `map a snapshot key or a host path found in the alerts of other tools
+   back to the containers and pods using the snapshot or layer.
+
+   The host path is a path on the evidence host i.e.
+   /var/lib/containerd/io.containerd.snapshotter.v1.overlayfs/snapshots/123/fs/etc/passwd
+   and may be specified with or without the image root prefix. The path
+   within each container is reported.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "snapshot",
+			Usage: "snapshot key, name, or ID",
+		},
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "host path within a snapshot or layer directory",
+		},
+	},
+	Action: func(clictx *cli.Context) error {
+		key := clictx.String("snapshot")
+		path := clictx.String("path")
+		if (key == "") == (path == "") {
+			return fmt.Errorf("specify either --snapshot or --path")
+		}
+
+		ctx, exp, cancel, err := explorerEnvironment(clictx)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		if key != "" {
+			path, err = snapshotDir(ctx, exp, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		owners, err := findLayerOwners(ctx, exp, clictx.GlobalString("image-root"), path)
+		if err != nil {
+			return err
+		}
+		if len(owners) == 0 {
+			return fmt.Errorf("no container uses %s", path)
+		}
+
+		output := clictx.GlobalString("output")
+		if isStructuredOutput(output) {
+			for _, o := range owners {
+				printObject(output, o)
+			}
+			return nil
+		}
+
+		rw := newRowWriter(output)
+		defer rw.Flush()
+		rw.Write("NAMESPACE", "CONTAINER ID", "IMAGE", "POD", "POD NAMESPACE", "LAYER", "PATH")
+		for _, o := range owners {
+			rw.Write(o.Namespace, o.ContainerID, o.Image, o.Pod, o.PodNamespace, o.Layer, o.Path)
+		}
+		return nil
+	},
+}
+
+// snapshotDir returns the directory of a snapshot specified using the
+// snapshot key, name, or ID.
+func snapshotDir(ctx context.Context, exp explorers.ContainerExplorer, key string) (string, error) {
+	ss, err := exp.ListSnapshots(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range ss {
+		if s.Key != key && s.Name != key && fmt.Sprint(s.ID) != key {
+			continue
+		}
+		if s.OverlayPath == "" {
+			return "", fmt.Errorf("snapshot %s does not have a directory", key)
+		}
+		return filepath.Join(exp.SnapshotRoot(s.Snapshotter), s.OverlayPath), nil
+	}
+	return "", fmt.Errorf("snapshot %s not found", key)
+}
+
+// findLayerOwners returns the containers with a layer directory containing
+// the path.
+func findLayerOwners(ctx context.Context, exp explorers.ContainerExplorer, imageroot string, path string) ([]layerOwner, error) {
+	candidates := hostPathCandidates(imageroot, path)
+
+	ctrs, err := exp.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var owners []layerOwner
+	for _, ctr := range ctrs {
+		layers, err := containerLayers(ctx, exp, ctr)
+		if err != nil {
+			log.WithField("containerid", ctr.ID).Debug("getting container layers: ", err)
+			continue
+		}
+
+		for i, layer := range layers {
+			rel, found := pathInDir(candidates, layer)
+			if !found {
+				continue
+			}
+
+			pod := ctr.Labels[explorers.LabelPodName]
+			if pod == "" {
+				pod = ctr.PodName
+			}
+
+			name := "upper"
+			if i > 0 {
+				name = fmt.Sprintf("lower-%d", i-1)
+			}
+			owners = append(owners, layerOwner{
+				Namespace:    ctr.Namespace,
+				ContainerID:  ctr.ID,
+				Image:        ctr.Image,
+				Pod:          pod,
+				PodNamespace: ctr.Labels[explorers.LabelPodNamespace],
+				Layer:        name,
+				LayerDir:     layer,
+				Path:         filepath.Join("/", rel),
+			})
+			break
+		}
+	}
+	return owners, nil
+}
+
+// hostPathCandidates returns the path and the path within the image root if
+// the path is not within the image root.
+func hostPathCandidates(imageroot string, path string) []string {
+	candidates := []string{filepath.Clean(path)}
+	if imageroot != "" && !strings.HasPrefix(filepath.Clean(path), filepath.Clean(imageroot)+string(filepath.Separator)) {
+		candidates = append(candidates, filepath.Join(imageroot, path))
+	}
+	return candidates
+}
+
+// pathInDir returns the path of the first candidate relative to the
+// directory if the candidate is within the directory.
+//
+// Symbolic links of the directory are resolved i.e. the docker overlay2
+// lower directories l/<short id>.
+func pathInDir(candidates []string, dir string) (string, bool) {
+	dirs := []string{filepath.Clean(dir)}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil && resolved != dirs[0] {
+		dirs = append(dirs, resolved)
+	}
+
+	for _, candidate := range candidates {
+		for _, d := range dirs {
+			if candidate == d {
+				return "", true
+			}
+			if strings.HasPrefix(candidate, d+string(filepath.Separator)) {
+				return strings.TrimPrefix(candidate, d+string(filepath.Separator)), true
+			}
+		}
+	}
+	return "", false
+}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"os"
+	"time"
 
 	cecommands "github.com/google/container-explorer/cmd/commands"
 	log "github.com/sirupsen/logrus"
@@ -51,6 +52,10 @@ func main() {
 			Name:  "debug",
 			Usage: "enable debug messages",
 		},
+		cli.StringFlag{
+			Name:  "pprof",
+			Usage: "serve the runtime profiles over HTTP at the address i.e. localhost:6060",
+		},
 
 		// Removing the default containerd-root value
 		//
@@ -72,9 +77,30 @@ func main() {
 			Name:  "containerd-root, c",
 			Usage: "specify containerd root directory",
 		},
-		cli.StringFlag{
+		cli.GenericFlag{
 			Name:  "image-root, i",
-			Usage: "specify mount point for a disk image",
+			Usage: "specify mount point for a disk image or a tar or zip evidence archive. Repeat to list the containers of several hosts",
+			Value: &cecommands.ImageRoots{},
+		},
+		cli.StringFlag{
+			Name:  "image-roots-from",
+			Usage: "file listing the mount points of the disk images of several hosts, one per line",
+		},
+		cli.StringFlag{
+			Name:  "image-file",
+			Usage: "raw disk image i.e. disk.raw, EWF image i.e. disk.E01, or virtual disk i.e. disk.qcow2, disk.vmdk, disk.vhdx, or disk.vhd. The Linux root filesystem is found and mounted read-only as the image root",
+		},
+		cli.IntFlag{
+			Name:  "image-partition",
+			Usage: "partition number of the disk image specified using --image-file. Default is the first partition with a container runtime",
+		},
+		cli.StringFlag{
+			Name:  "luks-key-file",
+			Usage: "key file unlocking the LUKS encrypted volumes of the disk image specified using --image-file",
+		},
+		cli.StringFlag{
+			Name:  "luks-passphrase",
+			Usage: "passphrase unlocking the LUKS encrypted volumes of the disk image specified using --image-file. The passphrase is visible in the process list; prefer --luks-key-file",
 		},
 		cli.StringFlag{
 			Name:  "metadata-file, m",
@@ -84,11 +110,36 @@ func main() {
 			Name:  "snapshot-metadata-file, s",
 			Usage: "specify the path to containerd snapshot metadata file i.e. metadata.db.",
 		},
+		cli.StringSliceFlag{
+			Name:  "snapshot-data-dir",
+			Usage: "directory holding the containerd snapshots captured separately from the metadata i.e. a copy of /var/lib/containerd or a snapshotter root. Use <snapshotter>=<dir> to specify a snapshotter root. Repeat to search multiple directories",
+		},
+		cli.StringSliceFlag{
+			Name:  "content-dir",
+			Usage: "containerd content store directory relocated or captured separately from the metadata i.e. the blobs directory of io.containerd.content.v1.content. Repeat to search multiple directories",
+		},
 		cli.StringFlag{
 			Name:  "namespace, n",
 			Usage: "specify container namespace",
 			Value: "default",
 		},
+		cli.StringFlag{
+			Name:  "runtime",
+			Usage: "container runtime in auto, containerd, docker, crio, podman, lxd, k3s, rke2, microk8s, kind, bottlerocket, talos, balena-engine. Default is auto",
+			Value: "auto",
+		},
+		cli.StringFlag{
+			Name:  "distro",
+			Usage: "Kubernetes distribution preset i.e. k3s, rke2, microk8s, kind, bottlerocket, talos or a knowledge pack name. Uses the containerd root of the distribution",
+		},
+		cli.StringSliceFlag{
+			Name:  "knowledge-pack",
+			Usage: "knowledge pack file or directory describing the artifact locations of a distribution. Repeat to load multiple packs",
+		},
+		cli.StringSliceFlag{
+			Name:  "noise-profile-file",
+			Usage: "noise profile file or directory identifying noisy workloads hidden by list containers --workloads-only. Repeat to load multiple profiles",
+		},
 		cli.BoolFlag{
 			Name:  "docker-managed",
 			Usage: "specify docker manages standalone or Kubernetes containers",
@@ -97,29 +148,165 @@ func main() {
 			Name:  "docker-root",
 			Usage: "specify docker root directory. This is only used with flag --docker-managed",
 		},
+		cli.BoolFlag{
+			Name:  "crio-managed",
+			Usage: "specify CRI-O manages Kubernetes containers using containers storage",
+		},
+		cli.StringFlag{
+			Name:  "crio-root",
+			Usage: "specify containers storage root directory. This is only used with flag --crio-managed",
+		},
+		cli.BoolFlag{
+			Name:  "podman-managed",
+			Usage: "specify Podman manages rootful or rootless containers",
+		},
+		cli.StringFlag{
+			Name:  "podman-root",
+			Usage: "specify Podman containers storage root directory. This is only used with flag --podman-managed",
+		},
+		cli.StringFlag{
+			Name:  "lxd-root",
+			Usage: "specify LXD root directory i.e. /var/lib/lxd or /var/snap/lxd/common/lxd",
+		},
+		cli.BoolFlag{
+			Name:  "fuse",
+			Usage: "mount containers in user space using fuse-overlayfs. Root privileges are not required",
+		},
+		cli.StringFlag{
+			Name:  "analyzer-config",
+			Usage: "a yaml file enabling, disabling, and tuning the analyzers. See analyze run --help",
+		},
 		cli.StringFlag{
 			Name:  "support-container-data",
 			Usage: "a yaml file containing information about support containers",
 		},
+		cli.BoolFlag{
+			Name:  "safe-mode",
+			Usage: "copy databases sequentially with retries before reading for failing source media",
+		},
+		cli.IntFlag{
+			Name:  "read-retries",
+			Usage: "retries on I/O error in safe mode",
+			Value: 3,
+		},
+		cli.DurationFlag{
+			Name:  "read-backoff",
+			Usage: "initial backoff between retries in safe mode",
+			Value: 100 * time.Millisecond,
+		},
+		cli.IntFlag{
+			Name:  "read-block-size",
+			Usage: "sequential read size in bytes in safe mode",
+			Value: 65536,
+		},
+		cli.IntFlag{
+			Name:  "read-rate",
+			Usage: "maximum read rate in KiB per second in safe mode. 0 is unlimited",
+		},
+		cli.StringFlag{
+			Name:  "unreadable-report",
+			Usage: "write the unreadable extents found in safe mode to a JSON file",
+		},
 		cli.StringFlag{
 			Name:  "output",
-			Usage: "output format in json, table. Default is table",
+			Usage: "output format in json, jsonl, table, csv. Default is table",
 			Value: "table",
 		},
+		cli.StringFlag{
+			Name:  "locale",
+			Usage: "report locale for the date format and CSV delimiter i.e. en-US, de-DE, fr-FR, ja-JP",
+		},
+		cli.StringFlag{
+			Name:  "time-format",
+			Usage: "report time format as a Go layout or rfc3339, rfc1123, iso8601",
+		},
+		cli.StringFlag{
+			Name:  "timezone",
+			Usage: "report timezone i.e. Europe/Berlin. Default is UTC",
+		},
+		cli.StringFlag{
+			Name:  "csv-encoding",
+			Usage: "CSV encoding in utf-8, utf-8-bom, utf-16le, utf-16be. Default is utf-8",
+		},
+		cli.StringFlag{
+			Name:  "csv-delimiter",
+			Usage: "CSV delimiter i.e. ; or \\t",
+		},
 	}
 
 	app.Commands = []cli.Command{
 		cecommands.ListCommand,
 		cecommands.InfoCommand,
+		cecommands.InspectCommand,
 		cecommands.MountCommand,
 		cecommands.MountAllCommand,
+		cecommands.ExportCommand,
+		cecommands.ExtractCommand,
+		cecommands.PeekCommand,
+		cecommands.ReportCommand,
+		cecommands.AnalyzeCommand,
+		cecommands.DriftCommand,
+		cecommands.ForeachCommand,
+		cecommands.WatchCommand,
+		cecommands.PreflightCommand,
+		cecommands.CapabilitiesCommand,
+		cecommands.SelfTestCommand,
+		cecommands.BenchCommand,
+		cecommands.TimelineCommand,
+		cecommands.HashCommand,
+		cecommands.ScanCommand,
+		cecommands.ClusterCommand,
+		cecommands.CompareCommand,
+		cecommands.WhichContainerCommand,
+		cecommands.ResolveCommand,
+		cecommands.StaleMetadataCommand,
+		cecommands.IndexCommand,
+		cecommands.ToolsCommand,
 	}
 
 	app.Before = func(context *cli.Context) error {
 		if context.GlobalBool("debug") {
 			log.SetLevel(log.DebugLevel)
 		}
-		return nil
+		if err := cecommands.SetupProfiling(context); err != nil {
+			return err
+		}
+		if err := cecommands.SetupImageRoots(context); err != nil {
+			return err
+		}
+		if err := cecommands.LoadKnowledgePacks(context); err != nil {
+			return err
+		}
+		if err := cecommands.LoadNoiseProfiles(context); err != nil {
+			return err
+		}
+		if err := cecommands.SetupImageFile(context); err != nil {
+			return err
+		}
+		if err := cecommands.SetupLocale(context); err != nil {
+			return err
+		}
+		if err := cecommands.SetupSnapshotDataDirs(context); err != nil {
+			return err
+		}
+		if err := cecommands.SetupContentDirs(context); err != nil {
+			return err
+		}
+		if err := cecommands.SetupFuseMount(context); err != nil {
+			return err
+		}
+		return cecommands.SetupSafeMode(context)
+	}
+
+	app.After = func(context *cli.Context) error {
+		ierr := cecommands.FinishImageFile(context)
+		if err := cecommands.FinishImageRoots(context); err != nil {
+			return err
+		}
+		if err := cecommands.FinishSafeMode(context); err != nil {
+			return err
+		}
+		return ierr
 	}
 
 	err := app.Run(os.Args)
@@ -0,0 +1,297 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package archive provides the pure Go tar, gzip, and zstd helpers used to
+// export and import container data without host tools.
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression formats.
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// WriteTar writes the files of a directory to a tar stream.
+//
+// The file names are relative to the directory. The file mode, ownership,
+// timestamps, and symbolic link targets are preserved.
+func WriteTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		return WriteTarFile(tw, filepath.ToSlash(rel), path, info)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// WriteTarFile writes a file to a tar stream using the entry name. The
+// content of a regular file is read from path. An error opening the file is
+// returned before the entry is written.
+func WriteTarFile(tw *tar.Writer, name string, path string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+
+	if !info.Mode().IsRegular() {
+		return tw.WriteHeader(hdr)
+	}
+
+	// The file is opened before the header is written, so an unreadable
+	// file does not leave a truncated entry.
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ExtractTar extracts a tar stream to a directory.
+//
+// The entries escaping the directory, either by name or through a symbolic
+// link extracted from an earlier entry, are skipped. The file ownership is
+// not restored.
+func ExtractTar(r io.Reader, dst string) error {
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := safeJoin(dst, hdr.Name)
+		if err != nil {
+			continue
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := removeSymlink(path); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(path, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := removeSymlink(path); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			f.Close()
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			os.Remove(path)
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+			continue // the times would be set on the link target
+		case tar.TypeLink:
+			target, err := safeJoin(dst, hdr.Linkname)
+			if err != nil {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			os.Remove(path)
+			if err := os.Link(target, path); err != nil {
+				return err
+			}
+		default:
+			continue // devices and fifos are not extracted
+		}
+
+		os.Chtimes(path, hdr.AccessTime, hdr.ModTime)
+	}
+}
+
+// safeJoin returns the path of an archive entry below the directory.
+//
+// An error is returned if the entry name escapes the directory or if a
+// parent directory of the entry below the directory is a symbolic link or
+// not a directory. An archive may hold a symbolic link to a directory
+// outside dir followed by an entry below the link.
+func safeJoin(dir string, name string) (string, error) {
+	dir = filepath.Clean(dir)
+	rel := strings.TrimPrefix(filepath.Clean("/"+filepath.FromSlash(name)), string(os.PathSeparator))
+	if rel == "" {
+		return dir, nil
+	}
+	path := filepath.Join(dir, rel)
+	if !strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("entry %s escapes %s", name, dir)
+	}
+
+	parent := dir
+	elems := strings.Split(rel, string(os.PathSeparator))
+	for _, elem := range elems[:len(elems)-1] {
+		parent = filepath.Join(parent, elem)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			// The remaining parent directories are created by the
+			// extraction.
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("entry %s is below the symbolic link %s", name, parent)
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("entry %s is below the file %s", name, parent)
+		}
+	}
+	return path, nil
+}
+
+// removeSymlink removes a symbolic link at the path, so an entry replaces
+// the link instead of writing through it.
+func removeSymlink(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// ListTar returns the entry names of a tar stream.
+func ListTar(r io.Reader) ([]string, error) {
+	tr := tar.NewReader(r)
+
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return names, err
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+// NewReader returns a reader decompressing a gzip or zstd stream. The
+// compression format is detected from the stream header and an uncompressed
+// stream is returned as is.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(header, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
+
+// NewWriter returns a writer compressing to the format i.e. gzip or zstd.
+func NewWriter(w io.Writer, format string) (io.WriteCloser, error) {
+	switch strings.ToLower(format) {
+	case "", None:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported compression format %s", format)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
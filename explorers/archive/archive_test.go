@@ -0,0 +1,108 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry is a tar entry of a test archive.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func writeTestTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+		}
+		if e.typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// TestExtractTarSymlinkEscape checks that the entries written through a
+// symbolic link extracted from an earlier entry are skipped.
+func TestExtractTarSymlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	r := writeTestTar(t, []tarEntry{
+		{name: "a", typeflag: tar.TypeSymlink, linkname: outside},
+		{name: "a/pwned", typeflag: tar.TypeReg, content: "pwned"},
+		{name: "a/dir/", typeflag: tar.TypeDir},
+		{name: "../escaped", typeflag: tar.TypeReg, content: "escaped"},
+		{name: "link", typeflag: tar.TypeLink, linkname: "a/secret"},
+		{name: "s", typeflag: tar.TypeSymlink, linkname: secret},
+		{name: "s", typeflag: tar.TypeReg, content: "replaced"},
+		{name: "file", typeflag: tar.TypeReg, content: "file"},
+		{name: "hardlink", typeflag: tar.TypeLink, linkname: "file"},
+	})
+	if err := ExtractTar(r, dst); err != nil {
+		t.Fatalf("ExtractTar() returned error: %v", err)
+	}
+
+	for _, name := range []string{"pwned", "dir"} {
+		if _, err := os.Lstat(filepath.Join(outside, name)); err == nil {
+			t.Errorf("%s was written outside the directory", name)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(filepath.Dir(dst), "escaped")); err == nil {
+		t.Errorf("../escaped was written outside the directory")
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "link")); err == nil {
+		t.Errorf("hard link to a file outside the directory was extracted")
+	}
+	if data, _ := os.ReadFile(secret); string(data) != "secret" {
+		t.Errorf("file outside the directory was overwritten with %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "s")); string(data) != "replaced" {
+		t.Errorf("s = %q, want the regular file replacing the symbolic link", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "hardlink")); string(data) != "file" {
+		t.Errorf("hardlink = %q, want %q", data, "file")
+	}
+}
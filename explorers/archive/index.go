@@ -0,0 +1,588 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Evidence archive formats.
+const (
+	Tar = "tar"
+	Zip = "zip"
+)
+
+var zipMagic = []byte("PK\x03\x04")
+
+// opaqueXattrs are the PAX records marking an overlay directory as opaque.
+// Rootless overlay uses the user namespace.
+var opaqueXattrs = []string{
+	"SCHILY.xattr.trusted.overlay.opaque",
+	"SCHILY.xattr.user.overlay.opaque",
+}
+
+// Entry is a file of an indexed archive.
+type Entry struct {
+	Name     string      // slash separated path without a leading slash
+	Mode     os.FileMode // file type and permissions
+	Size     int64
+	ModTime  time.Time
+	Linkname string // target of a symbolic or hard link
+	Hardlink bool
+	Whiteout bool // overlay whiteout i.e. a 0/0 character device
+	Opaque   bool // overlay opaque directory
+
+	offset int64     // data offset of an uncompressed tar entry
+	file   *zip.File // zip entry
+}
+
+// Index is the table of the entries of a tar or zip archive, so the content
+// of an archive is located without extracting it.
+//
+// The entries of a zip archive and of an uncompressed tar archive are read
+// in place. A gzip or zstd compressed tar archive cannot be read at an
+// offset, so its entries are read by decompressing the archive from the
+// start.
+type Index struct {
+	Path        string
+	Format      string // tar or zip
+	Compression string // none, gzip, or zstd
+	Entries     []Entry
+
+	names map[string]int
+	zr    *zip.ReadCloser
+}
+
+// DetectEvidence returns the archive format and the compression of a tar or
+// zip archive, or empty strings if the file is not an archive.
+func DetectEvidence(path string) (string, string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return "", ""
+	}
+	if bytes.Equal(magic, zipMagic) {
+		return Zip, None
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", ""
+	}
+
+	compression := None
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		compression = Gzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		compression = Zstd
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		return "", ""
+	}
+	defer r.Close()
+
+	// The ustar magic of the first header block is at offset 257.
+	block := make([]byte, 512)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return "", ""
+	}
+	if !bytes.HasPrefix(block[257:], []byte("ustar")) {
+		return "", ""
+	}
+	return Tar, compression
+}
+
+// OpenIndex reads the table of entries of a tar or zip archive. The index
+// of a zip archive holds the archive open until Close.
+func OpenIndex(path string) (*Index, error) {
+	format, compression := DetectEvidence(path)
+	idx := &Index{
+		Path:        path,
+		Format:      format,
+		Compression: compression,
+		names:       make(map[string]int),
+	}
+
+	var err error
+	switch format {
+	case Tar:
+		err = idx.readTar()
+	case Zip:
+		err = idx.readZip()
+	default:
+		return nil, fmt.Errorf("%s is not a tar or zip archive", path)
+	}
+	if err != nil {
+		idx.Close()
+		return nil, fmt.Errorf("indexing %s: %w", path, err)
+	}
+
+	log.WithFields(log.Fields{
+		"archive":     path,
+		"format":      format,
+		"compression": compression,
+		"entries":     len(idx.Entries),
+	}).Debug("indexed archive")
+	return idx, nil
+}
+
+// Close closes the archive.
+func (idx *Index) Close() error {
+	if idx.zr != nil {
+		return idx.zr.Close()
+	}
+	return nil
+}
+
+// add adds an entry. A later entry replaces an entry with the same name as
+// with tar extraction.
+func (idx *Index) add(e Entry) {
+	if i, found := idx.names[e.Name]; found {
+		idx.Entries[i] = e
+		return
+	}
+	idx.names[e.Name] = len(idx.Entries)
+	idx.Entries = append(idx.Entries, e)
+}
+
+// Lookup returns the entry with the name.
+func (idx *Index) Lookup(name string) (Entry, bool) {
+	i, found := idx.names[entryName(name)]
+	if !found {
+		return Entry{}, false
+	}
+	return idx.Entries[i], true
+}
+
+// readTar reads the headers of a tar archive. The data of an uncompressed
+// archive is skipped using seeks.
+func (idx *Index) readTar() error {
+	f, err := os.Open(idx.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader
+	cr := &countingReader{f: f}
+	if idx.Compression == None {
+		r = cr
+	} else {
+		dr, err := NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+		r = dr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := entryName(hdr.Name)
+		if name == "" {
+			continue
+		}
+		idx.add(Entry{
+			Name:     name,
+			Mode:     hdr.FileInfo().Mode(),
+			Size:     hdr.Size,
+			ModTime:  hdr.ModTime,
+			Linkname: hdr.Linkname,
+			Hardlink: hdr.Typeflag == tar.TypeLink,
+			Whiteout: isTarWhiteout(hdr),
+			Opaque:   isTarOpaque(hdr),
+			offset:   cr.pos,
+		})
+	}
+}
+
+// readZip reads the central directory of a zip archive.
+func (idx *Index) readZip() error {
+	zr, err := zip.OpenReader(idx.Path)
+	if err != nil {
+		return err
+	}
+	idx.zr = zr
+
+	for _, f := range zr.File {
+		name := entryName(f.Name)
+		if name == "" {
+			continue
+		}
+		e := Entry{
+			Name:    name,
+			Mode:    f.Mode(),
+			Size:    int64(f.UncompressedSize64),
+			ModTime: f.Modified,
+			file:    f,
+		}
+		if e.Mode&os.ModeSymlink != 0 {
+			target, err := readZipFile(f)
+			if err != nil {
+				return err
+			}
+			e.Linkname = string(target)
+		}
+		idx.add(e)
+	}
+	return nil
+}
+
+// Open returns a reader of the content of a regular file entry.
+func (idx *Index) Open(name string) (io.ReadCloser, error) {
+	e, found := idx.Lookup(name)
+	if !found {
+		return nil, fmt.Errorf("%s not found in %s: %w", name, idx.Path, os.ErrNotExist)
+	}
+	if !e.Mode.IsRegular() || e.Hardlink {
+		return nil, fmt.Errorf("%s is not a regular file", name)
+	}
+
+	if e.file != nil {
+		return e.file.Open()
+	}
+
+	f, err := os.Open(idx.Path)
+	if err != nil {
+		return nil, err
+	}
+	if idx.Compression == None {
+		return readCloser{io.NewSectionReader(f, e.offset, e.Size), f}, nil
+	}
+
+	// The compressed archive is read from the start up to the entry.
+	dr, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			dr.Close()
+			f.Close()
+			if err == io.EOF {
+				err = fmt.Errorf("%s not found in %s: %w", name, idx.Path, os.ErrNotExist)
+			}
+			return nil, err
+		}
+		if entryName(hdr.Name) == e.Name && hdr.Size == e.Size {
+			return readCloser{tr, closers{dr, f}}, nil
+		}
+	}
+}
+
+// Extract extracts the entries below the directory prefix of the archive to
+// dst and returns the number of extracted entries. An empty prefix extracts
+// all entries.
+//
+// The overlay whiteouts are written as AUFS style .wh.<name> files and the
+// opaque directories are marked using a .wh..wh..opq file, so the layers
+// are merged without the character devices and the extended attributes
+// that require privileges. The file ownership is not restored. The
+// directory timestamps are restored after their content is written.
+func (idx *Index) Extract(dst string, prefix string) (int, error) {
+	prefix = entryName(prefix)
+
+	x := &extractor{
+		dst:    dst,
+		prefix: prefix,
+		times:  make(map[string]time.Time),
+	}
+
+	var err error
+	if idx.Format == Zip {
+		for _, e := range idx.Entries {
+			if err = x.extract(e, func() (io.ReadCloser, error) { return e.file.Open() }); err != nil {
+				break
+			}
+		}
+	} else {
+		err = idx.extractTar(x)
+	}
+	if err != nil {
+		return x.count, err
+	}
+
+	// The deepest directories are restored first.
+	dirs := make([]string, 0, len(x.times))
+	for dir := range x.times {
+		dirs = append(dirs, dir)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		// A directory replaced by a later symbolic link entry is skipped,
+		// so the times of the link target are not changed.
+		if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		os.Chtimes(dir, x.times[dir], x.times[dir])
+	}
+	return x.count, nil
+}
+
+// extractTar extracts the entries of a tar archive in one sequential pass.
+func (idx *Index) extractTar(x *extractor) error {
+	f, err := os.Open(idx.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dr, err := NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := entryName(hdr.Name)
+		if name == "" {
+			continue
+		}
+
+		// A later entry with the same name replaces the indexed entry.
+		e, found := idx.Lookup(name)
+		if !found || e.Size != hdr.Size || e.Hardlink != (hdr.Typeflag == tar.TypeLink) {
+			continue
+		}
+		if err := x.extract(e, func() (io.ReadCloser, error) { return io.NopCloser(tr), nil }); err != nil {
+			return err
+		}
+	}
+}
+
+// extractor writes the archive entries below a prefix to a directory.
+type extractor struct {
+	dst    string
+	prefix string
+	count  int
+	times  map[string]time.Time // directory modification times
+}
+
+// extract writes an entry. The entries outside the prefix or escaping the
+// directory, either by name or through a symbolic link extracted from an
+// earlier entry, are skipped.
+func (x *extractor) extract(e Entry, open func() (io.ReadCloser, error)) error {
+	rel := e.Name
+	if x.prefix != "" {
+		if rel == x.prefix {
+			rel = ""
+		} else if strings.HasPrefix(rel, x.prefix+"/") {
+			rel = strings.TrimPrefix(rel, x.prefix+"/")
+		} else {
+			return nil
+		}
+	}
+	if e.Whiteout {
+		rel = path.Join(path.Dir(rel), ".wh."+path.Base(rel))
+	}
+	target, err := safeJoin(x.dst, rel)
+	if err != nil {
+		log.WithField("entry", e.Name).Debug("skipping entry: ", err)
+		return nil
+	}
+	perm := e.Mode.Perm()
+
+	if rel != "" {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := removeSymlink(target); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case e.Whiteout:
+		if err := os.WriteFile(target, nil, 0644); err != nil {
+			return err
+		}
+	case e.Mode.IsDir():
+		if err := os.MkdirAll(target, perm|0700); err != nil {
+			return err
+		}
+		if e.Opaque {
+			marker := filepath.Join(target, ".wh..wh..opq")
+			if err := removeSymlink(marker); err != nil {
+				return err
+			}
+			if err := os.WriteFile(marker, nil, 0644); err != nil {
+				return err
+			}
+		}
+		x.times[target] = e.ModTime
+		x.count++
+		return nil
+	case e.Hardlink:
+		link := entryName(e.Linkname)
+		if x.prefix != "" {
+			if !strings.HasPrefix(link, x.prefix+"/") {
+				return nil
+			}
+			link = strings.TrimPrefix(link, x.prefix+"/")
+		}
+		source, err := safeJoin(x.dst, link)
+		if err != nil {
+			log.WithField("entry", e.Name).Debug("skipping hard link: ", err)
+			return nil
+		}
+		os.Remove(target)
+		if err := os.Link(source, target); err != nil {
+			log.WithField("entry", e.Name).Debug("extracting hard link: ", err)
+			return nil
+		}
+	case e.Mode&os.ModeSymlink != 0:
+		os.Remove(target)
+		if err := os.Symlink(e.Linkname, target); err != nil {
+			return err
+		}
+		x.count++
+		return nil
+	case e.Mode.IsRegular():
+		r, err := open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm|0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return fmt.Errorf("extracting %s: %w", e.Name, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	default:
+		return nil // devices and fifos are not extracted
+	}
+
+	os.Chtimes(target, e.ModTime, e.ModTime)
+	x.count++
+	return nil
+}
+
+// entryName returns the cleaned name of an archive entry without a leading
+// slash or ./ i.e. var/lib/containerd.
+func entryName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return name
+}
+
+// isTarWhiteout returns true if the tar entry is an overlay whiteout.
+func isTarWhiteout(hdr *tar.Header) bool {
+	return hdr.Typeflag == tar.TypeChar && hdr.Devmajor == 0 && hdr.Devminor == 0
+}
+
+// isTarOpaque returns true if the tar entry is an opaque overlay directory.
+func isTarOpaque(hdr *tar.Header) bool {
+	if hdr.Typeflag != tar.TypeDir {
+		return false
+	}
+	for _, key := range opaqueXattrs {
+		if hdr.PAXRecords[key] == "y" {
+			return true
+		}
+	}
+	return false
+}
+
+// readZipFile returns the content of a zip entry.
+func readZipFile(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// countingReader tracks the read position of a file. The tar reader skips
+// the entry data using Seek.
+type countingReader struct {
+	f   *os.File
+	pos int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *countingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.f.Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
+
+// readCloser reads from a reader and closes a closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// closers closes several closers in order.
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var first error
+	for _, closer := range c {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestIndexExtractSymlinkEscape checks that the evidence archive entries
+// written through a symbolic link extracted from an earlier entry are
+// skipped.
+func TestIndexExtractSymlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := writeTestTar(t, []tarEntry{
+		{name: "root/a", typeflag: tar.TypeSymlink, linkname: outside},
+		{name: "root/a/pwned", typeflag: tar.TypeReg, content: "pwned"},
+		{name: "root/a/dir/", typeflag: tar.TypeDir},
+		{name: "root/link", typeflag: tar.TypeLink, linkname: "root/a/secret"},
+		{name: "root/file", typeflag: tar.TypeReg, content: "file"},
+	})
+	path := filepath.Join(t.TempDir(), "evidence.tar")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := OpenIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	dst := t.TempDir()
+	if _, err := idx.Extract(dst, "root"); err != nil {
+		t.Fatalf("Extract() returned error: %v", err)
+	}
+
+	for _, name := range []string{"pwned", "dir"} {
+		if _, err := os.Lstat(filepath.Join(outside, name)); err == nil {
+			t.Errorf("%s was written outside the directory", name)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "link")); err == nil {
+		t.Errorf("hard link to a file outside the directory was extracted")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "file")); string(data) != "file" {
+		t.Errorf("file = %q, want %q", data, "file")
+	}
+}
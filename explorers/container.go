@@ -16,7 +16,23 @@ limitations under the License.
 
 package explorers
 
-import "github.com/containerd/containerd/containers"
+import (
+	"time"
+
+	"github.com/containerd/containerd/containers"
+)
+
+// Kubernetes labels added to the containers created by kubelet.
+const (
+	LabelPodUID        = "io.kubernetes.pod.uid"
+	LabelPodName       = "io.kubernetes.pod.name"
+	LabelPodNamespace  = "io.kubernetes.pod.namespace"
+	LabelContainerName = "io.kubernetes.container.name"
+
+	// LabelContainerRestartCount is the restart attempt of a container. It
+	// is a container annotation copied to the labels by the explorers.
+	LabelContainerRestartCount = "io.kubernetes.container.restartCount"
+)
 
 // Container provides information about a container.
 type Container struct {
@@ -27,11 +43,34 @@ type Container struct {
 	ContainerType    string
 	ProcessID        int
 	Status           string
+	StartedAt        time.Time // zero if the start time is not recorded
+	FinishedAt       time.Time // zero if the container did not exit or the exit time is not recorded
+	ExitCode         int
+	VolatileMounts   []VolatileMount
 
 	// containerd specific fields
 	containers.Container
+	SandboxID string // containerd 1.7 and 2.x
 
 	// docker specific fields
 	Running      bool
 	ExposedPorts []string
+	LogPath      string
+
+	// podman specific fields
+	PodID   string
+	PodName string
+
+	// Windows container (WCOW) specific fields
+	Windows *WindowsContainer `json:",omitempty"`
+}
+
+// OptionalTime returns a pointer to t or nil for the zero time. The
+// omitempty option has no effect on a time.Time, so an unknown time is
+// omitted from JSON using a nil pointer.
+func OptionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
 }
@@ -21,15 +21,21 @@ import (
 )
 
 var (
-	bucketKeyVersion         = []byte("v1")
-	bucketKeyObjectSnapshots = []byte("snapshots") // stores snapshot references
-	bucketKeyObjectContent   = []byte("content")   // stores content references
-	bucketKeyObjectBlob      = []byte("blob")      // stores content links
-	bucketKeySize            = []byte("size")
-	bucketKeyName            = []byte("name")
-	bucketKeyParent          = []byte("parent")
-	bucketKeyKind            = []byte("kind")
-	bucketKeyID              = []byte("id")
+	bucketKeyVersion          = []byte("v1")
+	bucketKeyDBVersion        = []byte("version")    // stores the schema update version
+	bucketKeyObjectSnapshots  = []byte("snapshots")  // stores snapshot references
+	bucketKeyObjectContent    = []byte("content")    // stores content references
+	bucketKeyObjectBlob       = []byte("blob")       // stores content links
+	bucketKeyObjectLeases     = []byte("leases")     // stores leases
+	bucketKeyObjectIngests    = []byte("ingests")    // stores ingest references
+	bucketKeyObjectContainers = []byte("containers") // stores container objects
+	bucketKeyObjectSandboxes  = []byte("sandboxes")  // stores sandboxes. containerd 1.7 and 2.x
+	bucketKeySandboxID        = []byte("sandboxid")  // container sandbox. containerd 1.7 and 2.x
+	bucketKeySize             = []byte("size")
+	bucketKeyName             = []byte("name")
+	bucketKeyParent           = []byte("parent")
+	bucketKeyKind             = []byte("kind")
+	bucketKeyID               = []byte("id")
 )
 
 func getBucket(tx *bolt.Tx, keys ...[]byte) *bolt.Bucket {
@@ -49,6 +55,18 @@ func getBlobsBucket(tx *bolt.Tx, namespace string) *bolt.Bucket {
 	return getBucket(tx, bucketKeyVersion, []byte(namespace), bucketKeyObjectContent, bucketKeyObjectBlob)
 }
 
+func getContainersBucket(tx *bolt.Tx, namespace string) *bolt.Bucket {
+	return getBucket(tx, bucketKeyVersion, []byte(namespace), bucketKeyObjectContainers)
+}
+
+func getContainerBucket(tx *bolt.Tx, namespace string, containerid string) *bolt.Bucket {
+	return getBucket(tx, bucketKeyVersion, []byte(namespace), bucketKeyObjectContainers, []byte(containerid))
+}
+
+func getLeasesBucket(tx *bolt.Tx, namespace string) *bolt.Bucket {
+	return getBucket(tx, bucketKeyVersion, []byte(namespace), bucketKeyObjectLeases)
+}
+
 func getSnapshottersBucket(tx *bolt.Tx, namespace string) *bolt.Bucket {
 	return getBucket(tx, bucketKeyVersion, []byte(namespace), bucketKeyObjectSnapshots)
 }
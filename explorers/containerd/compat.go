@@ -0,0 +1,285 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/metadata/boltutil"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// metaDBVersion is the meta.db schema update version used by containerd 1.x.
+const metaDBVersion = 3
+
+// knownNamespaceBuckets are the buckets of a namespace in meta.db
+// i.e. meta.db/v1/<namespace>/<bucket>.
+//
+// The sandboxes bucket is used by containerd 1.7 and 2.x.
+var knownNamespaceBuckets = map[string]bool{
+	"labels":     true,
+	"images":     true,
+	"containers": true,
+	"snapshots":  true,
+	"content":    true,
+	"leases":     true,
+	"sandboxes":  true,
+}
+
+// configVersionRE matches the version of containerd config.toml. The
+// version 3 configuration is used by containerd 2.x.
+var configVersionRE = regexp.MustCompile(`(?m)^\s*version\s*=\s*(\d+)`)
+
+// metadataSchema describes the schema of the metadata file meta.db.
+type metadataSchema struct {
+	DBVersion     int64    // schema update version i.e. meta.db/v1/version
+	ConfigVersion int      // config.toml version if available
+	Sandboxes     bool     // sandbox store or container sandbox IDs exist
+	Unknown       []string // unknown namespace buckets i.e. <namespace>/<bucket>
+}
+
+// ContainerdVersion returns the containerd release line that created the
+// metadata file.
+//
+// containerd 1.7 and 2.x share the meta.db schema. The release line is
+// distinguished using the version of config.toml when available.
+func (s metadataSchema) ContainerdVersion() string {
+	switch {
+	case s.DBVersion > metaDBVersion || s.ConfigVersion >= 3:
+		return "2.x"
+	case s.Sandboxes:
+		return "1.7+"
+	}
+	return "1.x"
+}
+
+// readConfigVersion returns the version of containerd config.toml.
+func readConfigVersion(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	m := configVersionRE.FindSubmatch(data)
+	if m == nil {
+		return 0
+	}
+	version, _ := strconv.Atoi(string(m[1]))
+	return version
+}
+
+// readMetadataSchema detects the schema of the metadata file meta.db.
+//
+// The buckets not known to this version of container explorer are recorded
+// and ignored rather than treated as errors.
+func readMetadataSchema(db *bolt.DB) metadataSchema {
+	var schema metadataSchema
+
+	db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bucketKeyVersion)
+		if bkt == nil {
+			return nil
+		}
+
+		if v := bkt.Get(bucketKeyDBVersion); v != nil {
+			schema.DBVersion, _ = binary.Varint(v)
+		}
+
+		return bkt.ForEach(func(ns, v []byte) error {
+			nsbkt := bkt.Bucket(ns)
+			if nsbkt == nil {
+				return nil // version key
+			}
+			if cbkt := nsbkt.Bucket(bucketKeyObjectContainers); cbkt != nil && !schema.Sandboxes {
+				cbkt.ForEach(func(k, v []byte) error {
+					if ctrbkt := cbkt.Bucket(k); ctrbkt != nil && ctrbkt.Get(bucketKeySandboxID) != nil {
+						schema.Sandboxes = true
+					}
+					return nil
+				})
+			}
+
+			return nsbkt.ForEach(func(k, v []byte) error {
+				if string(k) == string(bucketKeyObjectSandboxes) {
+					schema.Sandboxes = true
+				}
+				if !knownNamespaceBuckets[string(k)] {
+					schema.Unknown = append(schema.Unknown, fmt.Sprintf("%s/%s", ns, k))
+				}
+				return nil
+			})
+		})
+	})
+
+	return schema
+}
+
+// listContainers returns the containers in a namespace.
+//
+// The containers are read using the containerd metadata store. If the store
+// fails to read a container created by a newer containerd, the containers
+// are read using readContainerCompat.
+func listContainers(ctx context.Context, db *bolt.DB) ([]containers.Container, error) {
+	store := metadata.NewContainerStore(metadata.NewDB(db, nil, nil))
+
+	results, err := store.List(ctx)
+	if err == nil {
+		return results, nil
+	}
+
+	namespace, nserr := namespaces.NamespaceRequired(ctx)
+	if nserr != nil {
+		return nil, err
+	}
+	log.WithField("namespace", namespace).Warn("reading containers using compatibility reader: ", err)
+
+	var ctrs []containers.Container
+	if err := db.View(func(tx *bolt.Tx) error {
+		bkt := getContainersBucket(tx, namespace)
+		if bkt == nil {
+			return nil // empty store
+		}
+
+		return bkt.ForEach(func(k, v []byte) error {
+			cbkt := bkt.Bucket(k)
+			if cbkt == nil {
+				return nil
+			}
+			ctrs = append(ctrs, readContainerCompat(string(k), cbkt))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return ctrs, nil
+}
+
+// getContainer returns a container in a namespace.
+//
+// The container is read using readContainerCompat if the containerd metadata
+// store fails to read the container.
+func getContainer(ctx context.Context, db *bolt.DB, containerid string) (containers.Container, error) {
+	store := metadata.NewContainerStore(metadata.NewDB(db, nil, nil))
+
+	container, err := store.Get(ctx, containerid)
+	if err == nil {
+		return container, nil
+	}
+
+	namespace, nserr := namespaces.NamespaceRequired(ctx)
+	if nserr != nil {
+		return containers.Container{}, err
+	}
+
+	found := false
+	db.View(func(tx *bolt.Tx) error {
+		cbkt := getContainerBucket(tx, namespace, containerid)
+		if cbkt == nil {
+			return nil
+		}
+		container = readContainerCompat(containerid, cbkt)
+		found = true
+		return nil
+	})
+	if !found {
+		return containers.Container{}, err
+	}
+
+	log.WithField("containerid", containerid).Debug("read container using compatibility reader: ", err)
+	return container, nil
+}
+
+// readContainerCompat reads a container bucket
+// i.e. meta.db/v1/<namespace>/containers/<container id>
+//
+// Unlike the containerd metadata store, the fields that cannot be decoded
+// and the unknown keys are logged and skipped.
+func readContainerCompat(containerid string, bkt *bolt.Bucket) containers.Container {
+	container := containers.Container{ID: containerid}
+
+	logger := log.WithField("containerid", containerid)
+
+	if labels, err := boltutil.ReadLabels(bkt); err == nil {
+		container.Labels = labels
+	} else {
+		logger.Debug("reading container labels: ", err)
+	}
+
+	if err := boltutil.ReadTimestamps(bkt, &container.CreatedAt, &container.UpdatedAt); err != nil {
+		logger.Debug("reading container timestamps: ", err)
+	}
+
+	bkt.ForEach(func(k, v []byte) error {
+		switch string(k) {
+		case "image":
+			container.Image = string(v)
+		case "runtime":
+			rbkt := bkt.Bucket(k)
+			if rbkt == nil {
+				return nil
+			}
+			container.Runtime.Name = string(rbkt.Get(bucketKeyName))
+			if any, err := boltutil.ReadAny(rbkt, []byte("options")); err == nil {
+				container.Runtime.Options = any
+			}
+		case "spec":
+			var any types.Any
+			if err := proto.Unmarshal(v, &any); err != nil {
+				logger.Debug("unmarshalling container spec: ", err)
+				return nil
+			}
+			container.Spec = &any
+		case "snapshotKey":
+			container.SnapshotKey = string(v)
+		case "snapshotter":
+			container.Snapshotter = string(v)
+		case "extensions":
+			if extensions, err := boltutil.ReadExtensions(bkt); err == nil {
+				container.Extensions = extensions
+			}
+		case "labels", "createdat", "updatedat", string(bucketKeySandboxID):
+		default:
+			logger.WithField("key", string(k)).Debug("skipping unknown container key")
+		}
+		return nil
+	})
+
+	return container
+}
+
+// containerSandboxID returns the sandbox ID of a container created by
+// containerd 1.7 or 2.x.
+func containerSandboxID(db *bolt.DB, namespace string, containerid string) string {
+	var sandboxid string
+	db.View(func(tx *bolt.Tx) error {
+		if cbkt := getContainerBucket(tx, namespace, containerid); cbkt != nil {
+			sandboxid = string(cbkt.Get(bucketKeySandboxID))
+		}
+		return nil
+	})
+	return sandboxid
+}
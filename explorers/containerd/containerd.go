@@ -21,15 +21,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/containerd/containerd/containers"
 	"github.com/containerd/containerd/metadata"
 	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
 	"github.com/gogo/protobuf/types"
 	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/storage"
 
 	spec "github.com/opencontainers/runtime-spec/specs-go"
 
@@ -37,6 +39,16 @@ import (
 	bolt "go.etcd.io/bbolt"
 )
 
+const (
+	// snapshotterDirPrefix is the prefix of a snapshotter root directory
+	// i.e. /var/lib/containerd/io.containerd.snapshotter.v1.overlayfs
+	snapshotterDirPrefix = "io.containerd.snapshotter.v1."
+
+	// snapshotFilename is the snapshot database in a snapshotter root
+	// directory.
+	snapshotFilename = "metadata.db"
+)
+
 type explorer struct {
 	imageroot string                      // mounted image path
 	root      string                      // containerd root
@@ -44,18 +56,42 @@ type explorer struct {
 	snapshot  string                      // path to snapshot database file i.e. metadata.db
 	mdb       *bolt.DB                    // manifest database
 	sc        *explorers.SupportContainer // support container structure object
+	schema    metadataSchema              // manifest database schema
+	blobroots []string                    // content store blobs directories in lookup order
+
+	referrers map[string][]referrerManifest // referrer manifests keyed by subject digest
 }
 
 // NewExplorer returns a ContainerExplorer interface to explore containerd.
 func NewExplorer(imageroot string, root string, manifest string, snapshot string, sc *explorers.SupportContainer) (explorers.ContainerExplorer, error) {
-	opt := &bolt.Options{
-		ReadOnly: true,
-	}
-	db, err := bolt.Open(manifest, 0444, opt)
+	db, err := explorers.OpenBolt(manifest, 0)
 	if err != nil {
 		return &explorer{}, err
 	}
 
+	// containerd 2.x adds buckets i.e. the sandbox store to meta.db.
+	// The unknown buckets are ignored.
+	schema := readMetadataSchema(db)
+	if imageroot != "" {
+		schema.ConfigVersion = readConfigVersion(explorers.ContainerdConfigPath(imageroot))
+	}
+	log.WithFields(log.Fields{
+		"dbversion":     schema.DBVersion,
+		"configversion": schema.ConfigVersion,
+		"sandboxes":     schema.Sandboxes,
+		"containerd":    schema.ContainerdVersion(),
+	}).Debug("containerd metadata schema")
+	if len(schema.Unknown) > 0 {
+		log.WithField("buckets", schema.Unknown).Info("ignoring unknown containerd metadata buckets")
+	}
+
+	// The overlayfs snapshot database may have been captured with the
+	// snapshots tree rather than with the containerd metadata.
+	if snapshotfile, found := snapshotDataFiles()["overlayfs"]; found && !explorers.PathExists(snapshot, true) {
+		log.WithField("snapshotfile", snapshotfile).Debug("using snapshot database from snapshot data directory")
+		snapshot = snapshotfile
+	}
+
 	return &explorer{
 		imageroot: imageroot,
 		root:      root,
@@ -63,6 +99,8 @@ func NewExplorer(imageroot string, root string, manifest string, snapshot string
 		snapshot:  snapshot,
 		mdb:       db,
 		sc:        sc,
+		schema:    schema,
+		blobroots: blobRoots(imageroot, root),
 	}, nil
 }
 
@@ -74,15 +112,36 @@ func NewExplorer(imageroot string, root string, manifest string, snapshot string
 // The default snapshot root directrion location for containerd is
 // /var/lib/containerd/io.containerd.snapshotter.v1.overlayfs
 func (e *explorer) SnapshotRoot(snapshotter string) string {
-	dirs, _ := filepath.Glob(filepath.Join(e.root, "*"))
-	for _, dir := range dirs {
-		if strings.Contains(strings.ToLower(dir), strings.ToLower(snapshotter)) {
-			return dir
-		}
+	if dir := snapshotRootDir(e.root, snapshotter); dir != "" {
+		return dir
 	}
 	return "unknown"
 }
 
+// snapshotFile returns the snapshot database file metadata.db of a
+// snapshotter.
+//
+// The specified snapshot database is used for overlayfs and for the
+// snapshotters without a snapshot database in the snapshotter root
+// directory.
+func (e *explorer) snapshotFile(snapshotter string) string {
+	if snapshotter == "" || snapshotter == "overlayfs" {
+		return e.snapshot
+	}
+
+	snapshotfile := filepath.Join(e.root, snapshotterDirPrefix+snapshotter, snapshotFilename)
+	if explorers.PathExists(snapshotfile, true) {
+		return snapshotfile
+	}
+	if snapshotfile, found := snapshotDataFiles()[snapshotter]; found {
+		return snapshotfile
+	}
+	if snapshotfile, found := proxySnapshotFiles(e.root)[snapshotter]; found {
+		return snapshotfile
+	}
+	return e.snapshot
+}
+
 // ListNamespace returns namespaces.
 //
 // In containerd the namespace information is stored in metadata file meta.db.
@@ -116,35 +175,184 @@ func (e *explorer) ListContainers(ctx context.Context) ([]explorers.Container, e
 		return nil, err
 	}
 
-	store := metadata.NewContainerStore(metadata.NewDB(e.mdb, nil, nil))
-
 	for _, ns := range nss {
 		ctx = namespaces.WithNamespace(ctx, ns)
 
-		results, err := store.List(ctx)
+		results, err := listContainers(ctx, e.mdb)
 		if err != nil {
 			return nil, err
 		}
 
 		for _, result := range results {
-			cectr := convertToContainerExplorerContainer(ns, result)
-			cectr.ImageBase = imageBasename(cectr.Image)
-			cectr.SupportContainer = e.sc.IsSupportContainer(cectr)
+			cecontainers = append(cecontainers, e.containerInfo(ctx, ns, result))
+		}
+	}
+	return cecontainers, nil
+}
+
+// ListContainersPage returns up to limit containers following the page token
+// and the token of the next page.
+//
+// Only the container keys are read to find the page. The containers are
+// read and the container specs are decoded for the containers of the page.
+func (e *explorer) ListContainersPage(ctx context.Context, limit int, token string) ([]explorers.Container, string, error) {
+	afterns, afterid, err := explorers.ParsePageToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nss, err := e.ListNamespaces(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(nss)
+
+	type pageKey struct {
+		namespace   string
+		containerid string
+	}
+	var keys []pageKey
+	more := false
+
+	if err := e.mdb.View(func(tx *bolt.Tx) error {
+		for _, ns := range nss {
+			if ns < afterns {
+				continue
+			}
+			bkt := getContainersBucket(tx, ns)
+			if bkt == nil {
+				continue
+			}
 
-			task, err := e.GetContainerTask(ctx, cectr)
-			if err != nil {
-				log.WithField("containerid", cectr.ID).Error("failed getting container task")
+			c := bkt.Cursor()
+			k, _ := c.First()
+			if ns == afterns {
+				k, _ = c.Seek([]byte(afterid))
+				if k != nil && string(k) == afterid {
+					k, _ = c.Next()
+				}
 			}
-			cectr.ProcessID = task.PID
-			cectr.ContainerType = task.ContainerType
-			cectr.Status = task.Status
+			for ; k != nil; k, _ = c.Next() {
+				if limit > 0 && len(keys) == limit {
+					more = true
+					return nil
+				}
+				keys = append(keys, pageKey{namespace: ns, containerid: string(k)})
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+
+	var cecontainers []explorers.Container
+	for _, key := range keys {
+		ctx = namespaces.WithNamespace(ctx, key.namespace)
 
+		result, err := getContainer(ctx, e.mdb, key.containerid)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"namespace":   key.namespace,
+				"containerid": key.containerid,
+			}).Warn("reading container: ", err)
+			continue
+		}
+		cecontainers = append(cecontainers, e.containerInfo(ctx, key.namespace, result))
+	}
+
+	var next string
+	if more && len(keys) > 0 {
+		last := keys[len(keys)-1]
+		next = explorers.PageToken(explorers.Container{
+			Namespace: last.namespace,
+			Container: containers.Container{ID: last.containerid},
+		})
+	}
+	return cecontainers, next, nil
+}
+
+// ListContainerRecords returns the containers without decoding the container
+// specs or reading the container tasks and runtime states.
+//
+// The exit time and exit code of the containers created by the CRI plugin are
+// read from the CRI status files.
+func (e *explorer) ListContainerRecords(ctx context.Context) ([]explorers.Container, error) {
+	var cecontainers []explorers.Container
+
+	nss, err := e.ListNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ns := range nss {
+		ctx = namespaces.WithNamespace(ctx, ns)
+
+		results, err := listContainers(ctx, e.mdb)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range results {
+			cectr := e.containerRecord(ns, result, nil)
+			cectr.Status = "UNKNOWN"
+			e.setCRIStatus(&cectr)
 			cecontainers = append(cecontainers, cectr)
 		}
 	}
 	return cecontainers, nil
 }
 
+// containerRecord returns a Container without the task and runtime state.
+//
+// The container spec is used for the hostname if the container does not have
+// a pod name label.
+func (e *explorer) containerRecord(ns string, result containers.Container, ctrspec *spec.Spec) explorers.Container {
+	cectr := convertToContainerExplorerContainer(ns, result, ctrspec)
+	cectr.ImageBase = imageBasename(cectr.Image)
+	if e.schema.Sandboxes {
+		cectr.SandboxID = containerSandboxID(e.mdb, ns, cectr.ID)
+	}
+	cectr.SupportContainer = e.sc.IsSupportContainer(cectr)
+	return cectr
+}
+
+// containerInfo returns a Container with the task and runtime state.
+//
+// The container spec is decoded once and used for the hostname, the volatile
+// mounts, and the task.
+func (e *explorer) containerInfo(ctx context.Context, ns string, result containers.Container) explorers.Container {
+	var ctrspec *spec.Spec
+	if result.Spec != nil && result.Spec.Value != nil {
+		var v spec.Spec
+		if err := json.Unmarshal(result.Spec.Value, &v); err == nil {
+			ctrspec = &v
+		}
+	}
+
+	cectr := e.containerRecord(ns, result, ctrspec)
+	if ctrspec != nil {
+		cectr.VolatileMounts = explorers.VolatileMounts(e.imageroot, ctrspec.Mounts)
+		cectr.Windows = explorers.NewWindowsContainer(ctrspec)
+	}
+
+	task, err := e.containerTask(ctx, cectr, ctrspec)
+	if err != nil {
+		log.WithField("containerid", cectr.ID).Error("failed getting container task")
+	}
+	cectr.ProcessID = task.PID
+	cectr.ContainerType = task.ContainerType
+	cectr.Status = task.Status
+
+	// runc records the container start time in state.json
+	// while the container exists.
+	if state, err := e.GetContainerState(ctx, cectr); err == nil {
+		cectr.StartedAt = state.Created
+	}
+	e.setCRIStatus(&cectr)
+
+	return cectr
+}
+
 // ListImages returns the information about content.
 //
 // In containerd, the image information is stored in metadata file meta.db.
@@ -238,10 +446,7 @@ func (e *explorer) ListSnapshots(ctx context.Context) ([]explorers.SnapshotKeyIn
 	}
 
 	// snapshot database
-	opts := bolt.Options{
-		ReadOnly: true,
-	}
-	ssdb, err := bolt.Open(e.snapshot, 0444, &opts)
+	ssdb, err := explorers.OpenBolt(e.snapshot, 0)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"snapshotfile": e.snapshot,
@@ -250,6 +455,49 @@ func (e *explorer) ListSnapshots(ctx context.Context) ([]explorers.SnapshotKeyIn
 
 	store := NewSnaptshotStore(e.root, e.mdb, ssdb)
 
+	// Snapshot databases of the other snapshotters i.e. native
+	snapshotfiles, _ := filepath.Glob(filepath.Join(e.root, snapshotterDirPrefix+"*", snapshotFilename))
+	for _, snapshotfile := range snapshotfiles {
+		if snapshotfile == e.snapshot {
+			continue
+		}
+		sdb, err := explorers.OpenBolt(snapshotfile, 0)
+		if err != nil {
+			log.WithField("snapshotfile", snapshotfile).Warn("opening snapshot database: ", err)
+			continue
+		}
+		defer sdb.Close()
+
+		snapshotter := strings.TrimPrefix(filepath.Base(filepath.Dir(snapshotfile)), snapshotterDirPrefix)
+		store.SetSnapshotDB(snapshotter, sdb)
+	}
+
+	// Snapshot databases captured with the snapshots tree in a snapshot
+	// data directory
+	for snapshotter, snapshotfile := range snapshotDataFiles() {
+		if snapshotfile == e.snapshot || explorers.PathExists(filepath.Join(e.root, snapshotterDirPrefix+snapshotter, snapshotFilename), true) {
+			continue
+		}
+		sdb, err := explorers.OpenBolt(snapshotfile, 0)
+		if err != nil {
+			log.WithField("snapshotfile", snapshotfile).Warn("opening snapshot database: ", err)
+			continue
+		}
+		defer sdb.Close()
+		store.SetSnapshotDB(snapshotter, sdb)
+	}
+
+	// Snapshot databases of the proxy snapshotters i.e. stargz
+	for snapshotter, snapshotfile := range proxySnapshotFiles(e.root) {
+		sdb, err := explorers.OpenBolt(snapshotfile, 0)
+		if err != nil {
+			log.WithField("snapshotfile", snapshotfile).Warn("opening snapshot database: ", err)
+			continue
+		}
+		defer sdb.Close()
+		store.SetSnapshotDB(snapshotter, sdb)
+	}
+
 	for _, ns := range nss {
 		ctx = namespaces.WithNamespace(ctx, ns)
 
@@ -261,6 +509,39 @@ func (e *explorer) ListSnapshots(ctx context.Context) ([]explorers.SnapshotKeyIn
 		cesnapshots = append(cesnapshots, results...)
 	}
 
+	// The devmapper snapshots are thin devices rather than directories.
+	for i, s := range cesnapshots {
+		if s.Snapshotter != "devmapper" || s.ID == 0 {
+			continue
+		}
+		device, err := storage.FindThinDevice(e.SnapshotRoot(s.Snapshotter), fmt.Sprintf("%d", s.ID))
+		if err != nil {
+			log.WithField("snapshotkey", s.Key).Debug("finding thin device: ", err)
+			continue
+		}
+		cesnapshots[i].Device = device.Path()
+		cesnapshots[i].DeviceID = device.DeviceID
+		cesnapshots[i].Pool = device.Pool
+	}
+
+	// The remote snapshots i.e. stargz may not be materialized on disk.
+	for i, s := range cesnapshots {
+		cesnapshots[i].Remote = e.remoteSnapshotState(s)
+	}
+
+	// The zfs snapshots are datasets of the root dataset.
+	for i, s := range cesnapshots {
+		if s.Snapshotter != "zfs" || s.ID == 0 {
+			continue
+		}
+		dataset, err := storage.ZFSDataset(e.SnapshotRoot(s.Snapshotter), fmt.Sprintf("%d", s.ID), s.Kind == snapshots.KindActive)
+		if err != nil {
+			log.WithField("snapshotkey", s.Key).Debug("resolving zfs dataset: ", err)
+			continue
+		}
+		cesnapshots[i].Dataset = dataset
+	}
+
 	return cesnapshots, nil
 }
 
@@ -290,6 +571,32 @@ func (e *explorer) ListTasks(ctx context.Context) ([]explorers.Task, error) {
 	return cetasks, nil
 }
 
+// ListLeases returns the information about leases.
+//
+// In containerd, the lease information is stored in metadata file meta.db.
+func (e *explorer) ListLeases(ctx context.Context) ([]explorers.Lease, error) {
+	var celeases []explorers.Lease
+
+	nss, err := e.ListNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	store := NewLeaseStore(e.mdb)
+
+	for _, ns := range nss {
+		ctx = namespaces.WithNamespace(ctx, ns)
+
+		results, err := store.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		celeases = append(celeases, results...)
+	}
+
+	return celeases, nil
+}
+
 // GetContainerTask returns container task
 func (e *explorer) GetContainerTask(ctx context.Context, ctr explorers.Container) (explorers.Task, error) {
 	ctx = namespaces.WithNamespace(ctx, ctr.Namespace)
@@ -299,7 +606,21 @@ func (e *explorer) GetContainerTask(ctx context.Context, ctr explorers.Container
 	if err != nil {
 		return explorers.Task{}, fmt.Errorf("failed getting container spec for %s container: %w", ctr.ID, err)
 	}
-	ctrspec := v.(spec.Spec)
+	ctrspec, ok := v.(spec.Spec)
+	if !ok {
+		return explorers.Task{}, fmt.Errorf("container %s does not have a linux spec", ctr.ID)
+	}
+	return e.containerTask(ctx, ctr, &ctrspec)
+}
+
+// containerTask returns the container task using the decoded container spec.
+func (e *explorer) containerTask(ctx context.Context, ctr explorers.Container, ctrspec *spec.Spec) (explorers.Task, error) {
+	if wc := explorers.NewWindowsContainer(ctrspec); wc != nil {
+		return windowsTask(ctr, wc), nil
+	}
+	if ctrspec == nil || ctrspec.Linux == nil {
+		return explorers.Task{}, fmt.Errorf("container %s does not have a linux spec", ctr.ID)
+	}
 
 	var cgroupspath string
 	var containertype string
@@ -402,9 +723,7 @@ func (e *explorer) GetContainerState(ctx context.Context, ctr explorers.Containe
 
 // InfoContainer returns container internal information.
 func (e *explorer) InfoContainer(ctx context.Context, containerid string, spec bool) (interface{}, error) {
-	store := metadata.NewContainerStore(metadata.NewDB(e.mdb, nil, nil))
-
-	container, err := store.Get(ctx, containerid)
+	container, err := getContainer(ctx, e.mdb, containerid)
 	if err != nil {
 		return nil, err
 	}
@@ -434,13 +753,12 @@ func (e *explorer) InfoContainer(ctx context.Context, containerid string, spec b
 	return nil, nil
 }
 
-// MountContainer mounts a container to the specified path
-func (e *explorer) MountContainer(ctx context.Context, containerid string, mountpoint string) error {
-	store := metadata.NewContainerStore(metadata.NewDB(e.mdb, nil, nil))
-
-	container, err := store.Get(ctx, containerid)
+// ContainerLayers returns the container's upper directory and lower
+// directories ordered from top to bottom.
+func (e *explorer) ContainerLayers(ctx context.Context, containerid string) (string, []string, error) {
+	container, err := getContainer(ctx, e.mdb, containerid)
 	if err != nil {
-		return fmt.Errorf("failed getting container information %v", err)
+		return "", nil, fmt.Errorf("failed getting container information %v", err)
 	}
 	log.WithFields(log.Fields{
 		"snapshotter": container.Snapshotter,
@@ -449,13 +767,11 @@ func (e *explorer) MountContainer(ctx context.Context, containerid string, mount
 	}).Debug("container snapshotter")
 
 	// Snapshot database metadata.db access
-	opts := bolt.Options{
-		ReadOnly: true,
-	}
-	ssdb, err := bolt.Open(e.snapshot, 0444, &opts)
+	ssdb, err := explorers.OpenBolt(e.snapshotFile(container.Snapshotter), 0)
 	if err != nil {
-		return fmt.Errorf("failed to open snapshot database %v", err)
+		return "", nil, fmt.Errorf("failed to open snapshot database %v", err)
 	}
+	defer ssdb.Close()
 
 	// snapshot store
 	ssstore := NewSnaptshotStore(e.root, e.mdb, ssdb)
@@ -466,78 +782,87 @@ func (e *explorer) MountContainer(ctx context.Context, containerid string, mount
 		"workdir":  workdir,
 	}).Debug("overlay directories")
 	if err != nil {
-		return fmt.Errorf("failed to get overlay path %v", err)
+		return "", nil, fmt.Errorf("failed to get overlay path %v", err)
+	}
+
+	// The upper directory of a full copy snapshotter i.e. native contains
+	// all the container files.
+	if lowerdir == "" && storage.IsFullCopy(container.Snapshotter) {
+		return upperdir, nil, nil
 	}
 
 	if lowerdir == "" {
-		return fmt.Errorf("lowerdir is empty")
+		return "", nil, fmt.Errorf("lowerdir is empty")
+	}
+	lowerdirs := strings.Split(lowerdir, ":")
+
+	// The lazily pulled layers of a proxy snapshotter i.e. stargz are
+	// mounted using FUSE and are empty on a disk image.
+	if _, found := proxySnapshotterDirs[container.Snapshotter]; found {
+		for _, dir := range lowerdirs {
+			if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+				log.WithFields(log.Fields{
+					"containerid": containerid,
+					"layer":       dir,
+				}).Warn("lazily pulled layer is not materialized on disk. The container files are incomplete")
+			}
+		}
 	}
 
-	// TODO(rmaskey): Use github.com/containerd/containerd/mount.Mount to mount
-	// a container
-	mountopts := fmt.Sprintf("ro,lowerdir=%s:%s", upperdir, lowerdir)
-	mountArgs := []string{"-t", "overlay", "overlay", "-o", mountopts, mountpoint}
-	log.Debug("container mount command ", mountArgs)
+	return upperdir, lowerdirs, nil
+}
 
-	cmd := exec.Command("mount", mountArgs...)
-	out, err := cmd.CombinedOutput()
+// MountContainer mounts a container to the specified path
+func (e *explorer) MountContainer(ctx context.Context, containerid string, mountpoint string) error {
+	container, err := getContainer(ctx, e.mdb, containerid)
 	if err != nil {
-		log.Errorf("running mount command %v", err)
-
-		if strings.Contains(err.Error(), " 32") {
-			log.Error("invalid overlayfs lowerdir path. Use --debug to view lowerdir path")
-		}
-
-		return err
+		return fmt.Errorf("failed getting container information %v", err)
+	}
+	if platform := storage.Platform(container.Snapshotter); platform != "" {
+		return fmt.Errorf("container %s uses the %s snapshotter. The %s layers cannot be mounted on Linux", containerid, container.Snapshotter, platform)
 	}
 
-	if string(out) != "" {
-		log.Info("mount command output ", string(out))
+	upperdir, lowerdirs, err := e.ContainerLayers(ctx, containerid)
+	if err != nil {
+		return err
 	}
 
-	// default
-	return nil
+	// TODO(rmaskey): Use github.com/containerd/containerd/mount.Mount to mount
+	// a container
+	return explorers.MountOverlay(append([]string{upperdir}, lowerdirs...), mountpoint)
 }
 
 // MountAllContainers mounts all the containers
-func (e *explorer) MountAllContainers(ctx context.Context, mountpoint string, skipsupportcontainers bool) error {
+func (e *explorer) MountAllContainers(ctx context.Context, mountpoint string, opts explorers.MountAllOptions) ([]explorers.MountIndexEntry, error) {
 	ctrs, err := e.ListContainers(ctx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	batch, err := explorers.NewMountBatch(mountpoint, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, ctr := range ctrs {
 		// Skip Kubernetes suppot containers
-		if skipsupportcontainers && ctr.SupportContainer {
+		if opts.SkipSupportContainers && ctr.SupportContainer {
 			log.WithFields(log.Fields{
 				"namespace":   ctr.Namespace,
 				"containerid": ctr.ID,
 			}).Info("skip mounting Kubernetes containers")
 
+			batch.Skip(ctr, "Kubernetes support container")
 			continue
 		}
 
-		// Create a subdirectory within the specified mountpoint
-		ctrmountpoint := filepath.Join(mountpoint, ctr.ID)
-		if err := os.MkdirAll(ctrmountpoint, 0755); err != nil {
-			log.WithFields(log.Fields{
-				"namespace":   ctr.Namespace,
-				"containerid": ctr.ID,
-				"mountpoint":  mountpoint,
-			}).Error("creating mount point for a container")
-
-			log.WithField("containerid", ctr.ID).Warn("skipping container mount")
-			continue
-		}
-
-		ctx = namespaces.WithNamespace(ctx, ctr.Namespace)
-		if err := e.MountContainer(ctx, ctr.ID, ctrmountpoint); err != nil {
-			return err
-		}
+		nsctx := namespaces.WithNamespace(ctx, ctr.Namespace)
+		batch.Mount(ctr, func(ctrmountpoint string) error {
+			return e.MountContainer(nsctx, ctr.ID, ctrmountpoint)
+		})
 	}
 
-	// default
-	return nil
+	return batch.Close()
 }
 
 // Close releases the internal resources
@@ -547,7 +872,9 @@ func (e *explorer) Close() error {
 
 // convertToContainerExplorerContainer returns a Container object which is
 // superset of containers.Container object.
-func convertToContainerExplorerContainer(ns string, ctr containers.Container) explorers.Container {
+//
+// The decoded container spec is nil if the spec is not used.
+func convertToContainerExplorerContainer(ns string, ctr containers.Container, ctrspec *spec.Spec) explorers.Container {
 	var hostname string
 
 	// Try using io.kubernetes.pod.name as the hostname.
@@ -559,16 +886,13 @@ func convertToContainerExplorerContainer(ns string, ctr containers.Container) ex
 	}
 
 	// Get hostname from runtime fields
-	if hostname == "" && ctr.Spec != nil && ctr.Spec.Value != nil {
-		var v spec.Spec
-		json.Unmarshal(ctr.Spec.Value, &v)
-
-		if v.Hostname != "" {
-			hostname = v.Hostname
-		} else {
+	if hostname == "" && ctrspec != nil {
+		if ctrspec.Hostname != "" {
+			hostname = ctrspec.Hostname
+		} else if ctrspec.Process != nil {
 			// Using HOSTNAME from environment as last resort.
 			// HOSTNAME contains node's hostname.
-			for _, kv := range v.Process.Env {
+			for _, kv := range ctrspec.Process.Env {
 				if strings.HasPrefix(kv, "HOSTNAME=") {
 					hostname = strings.TrimSpace(strings.Split(kv, "=")[1])
 					break
@@ -0,0 +1,144 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+)
+
+// contentPluginDir is the content store directory relative to the
+// containerd root directory.
+const contentPluginDir = "io.containerd.content.v1.content"
+
+// contentDirs are the blobs directories of the content stores captured
+// separately from the containerd root directory.
+var contentDirs []string
+
+// SetContentDirs sets the content store directories captured separately
+// from the containerd root directory i.e. a blob store relocated to a data
+// disk.
+//
+// A directory is the blobs directory, the content store directory
+// containing the blobs directory, or a copy of the containerd root
+// directory. The directories are searched in order before the content store
+// of the containerd root directory.
+func SetContentDirs(dirs []string) error {
+	contentDirs = nil
+	for _, dir := range dirs {
+		blobs := contentBlobsRoot(dir)
+		if blobs == "" {
+			return fmt.Errorf("content directory %s does not contain a blobs directory", dir)
+		}
+		log.WithField("dir", blobs).Debug("content directory")
+		contentDirs = append(contentDirs, blobs)
+	}
+	return nil
+}
+
+// contentBlobsRoot returns the blobs directory of a content store directory
+// or an empty string.
+func contentBlobsRoot(dir string) string {
+	for _, blobs := range []string{
+		filepath.Join(dir, "blobs"),
+		filepath.Join(dir, contentBlobsDir),
+	} {
+		if explorers.PathExists(blobs, false) {
+			return blobs
+		}
+	}
+	if filepath.Base(dir) == "blobs" && explorers.PathExists(dir, false) {
+		return dir
+	}
+	return ""
+}
+
+// configContentDirs returns the content store directories configured in
+// containerd config.toml within the image root.
+//
+// A relocated containerd root is set using the top-level root key. A
+// relocated content store is set using the path or root_path key of the
+// io.containerd.content.v1.content plugin section. The configured paths are
+// host paths and are joined with the image root.
+func configContentDirs(imageroot string) []string {
+	if imageroot == "" {
+		return nil
+	}
+	data, err := os.ReadFile(explorers.ContainerdConfigPath(imageroot))
+	if err != nil {
+		return nil
+	}
+
+	var dirs []string
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[] ")
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		key := strings.TrimSpace(line[:i])
+		value, err := strconv.Unquote(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			value = strings.Trim(strings.TrimSpace(line[i+1:]), `'`)
+		}
+		if value == "" || !strings.HasPrefix(value, "/") {
+			continue
+		}
+
+		switch {
+		case section == "" && key == "root":
+			dirs = append(dirs, filepath.Join(imageroot, value, contentPluginDir))
+		case strings.Contains(section, contentPluginDir) && (key == "path" || key == "root_path"):
+			dirs = append(dirs, filepath.Join(imageroot, value))
+		}
+	}
+	return dirs
+}
+
+// blobRoots returns the blobs directories searched for a blob in order.
+//
+// The content directories set using SetContentDirs are searched first, then
+// the content stores configured in config.toml, and then the content store
+// of the containerd root directory.
+func blobRoots(imageroot string, root string) []string {
+	roots := append([]string{}, contentDirs...)
+	for _, dir := range configContentDirs(imageroot) {
+		if blobs := contentBlobsRoot(dir); blobs != "" {
+			log.WithField("dir", blobs).Debug("content directory configured in config.toml")
+			roots = append(roots, blobs)
+		}
+	}
+	return append(roots, filepath.Join(root, contentBlobsDir))
+}
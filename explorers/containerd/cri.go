@@ -0,0 +1,122 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+)
+
+// criContainersDir is the directory containing the container status files
+// written by the CRI plugin relative to the containerd root directory.
+const criContainersDir = "io.containerd.grpc.v1.cri/containers"
+
+// criStatus is the container status written by the CRI plugin in
+// io.containerd.grpc.v1.cri/containers/<container id>/status.
+//
+// Reference to containerd source code
+// https://github.com/containerd/containerd/blob/main/internal/cri/store/container/status.go
+type criStatus struct {
+	Version    string
+	Pid        uint32
+	CreatedAt  int64
+	StartedAt  int64
+	FinishedAt int64
+	ExitCode   int32
+	Reason     string
+}
+
+// readCRIStatus reads the CRI status file of a container.
+func readCRIStatus(root string, containerid string) (criStatus, error) {
+	var status criStatus
+
+	data, err := os.ReadFile(filepath.Join(root, criContainersDir, containerid, "status"))
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+// setCRIStatus sets the start time, exit time, and exit code of a container
+// created by the CRI plugin.
+//
+// The CRI plugin keeps the status of an exited container until kubelet
+// removes the container. A container with an exit time is reported as
+// STOPPED when the status could not be read from the container cgroup.
+func (e *explorer) setCRIStatus(ctr *explorers.Container) {
+	status, err := readCRIStatus(e.root, ctr.ID)
+	if err != nil {
+		return
+	}
+
+	if ctr.StartedAt.IsZero() && status.StartedAt != 0 {
+		ctr.StartedAt = time.Unix(0, status.StartedAt).UTC()
+	}
+	if status.FinishedAt == 0 {
+		return
+	}
+	ctr.FinishedAt = time.Unix(0, status.FinishedAt).UTC()
+	ctr.ExitCode = int(status.ExitCode)
+	if ctr.Status == "" || ctr.Status == "UNKNOWN" {
+		ctr.Status = "STOPPED"
+	}
+}
+
+// CRIStatusContainers returns the containers of the CRI status files in
+// io.containerd.grpc.v1.cri/containers within the containerd root.
+//
+// The CRI plugin removes the status file when kubelet removes the
+// container. A status file of a container missing from meta.db is left by
+// an interrupted removal.
+func CRIStatusContainers(root string) []explorers.KubeletContainer {
+	entries, err := os.ReadDir(filepath.Join(root, criContainersDir))
+	if err != nil {
+		return nil
+	}
+
+	var ctrs []explorers.KubeletContainer
+	for _, e := range entries {
+		status, err := readCRIStatus(root, e.Name())
+		if err != nil {
+			continue
+		}
+		k := explorers.KubeletContainer{
+			ID:           e.Name(),
+			RestartCount: -1,
+			ExitCode:     int(status.ExitCode),
+			Sources:      []string{explorers.KubeletSourceCRIStatus},
+		}
+		if status.CreatedAt != 0 {
+			k.CreatedAt = time.Unix(0, status.CreatedAt).UTC()
+		}
+		if status.StartedAt != 0 {
+			k.StartedAt = time.Unix(0, status.StartedAt).UTC()
+		}
+		if status.FinishedAt != 0 {
+			k.FinishedAt = time.Unix(0, status.FinishedAt).UTC()
+		}
+		ctrs = append(ctrs, k)
+	}
+	return ctrs
+}
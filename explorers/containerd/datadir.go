@@ -0,0 +1,141 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+)
+
+// snapshotDataDir is a directory holding snapshot data captured separately
+// from the containerd root directory.
+//
+// An acquisition may split the containerd metadata and the snapshots tree
+// onto different evidence volumes. The snapshot directories are looked up in
+// the snapshot data directories before the containerd root directory.
+type snapshotDataDir struct {
+	snapshotter string // snapshotter of the directory or empty if not specified
+	dir         string
+}
+
+// snapshotDataDirs are the snapshot data directories in lookup order.
+var snapshotDataDirs []snapshotDataDir
+
+// SetSnapshotDataDirs sets the directories holding snapshot data captured
+// separately from the containerd root directory.
+//
+// A directory is one of the following:
+//   - a copy of the containerd root directory containing the snapshotter root
+//     directories i.e. io.containerd.snapshotter.v1.overlayfs
+//   - a snapshotter root directory containing the snapshots directory
+//   - <snapshotter>=<dir> where dir is the root directory of the snapshotter
+//
+// The directories are searched in order for each snapshot, so the
+// snapshots of a snapshotter may be split across several directories.
+func SetSnapshotDataDirs(dirs []string) error {
+	snapshotDataDirs = nil
+	for _, dir := range dirs {
+		var sd snapshotDataDir
+		if i := strings.Index(dir, "="); i > 0 {
+			sd.snapshotter = dir[:i]
+			dir = dir[i+1:]
+		}
+		if !explorers.PathExists(dir, false) {
+			return fmt.Errorf("snapshot data directory %s does not exist", dir)
+		}
+		if sd.snapshotter == "" && strings.HasPrefix(filepath.Base(dir), snapshotterDirPrefix) {
+			sd.snapshotter = strings.TrimPrefix(filepath.Base(dir), snapshotterDirPrefix)
+		}
+		sd.dir = dir
+
+		log.WithFields(log.Fields{
+			"snapshotter": sd.snapshotter,
+			"dir":         sd.dir,
+		}).Debug("snapshot data directory")
+		snapshotDataDirs = append(snapshotDataDirs, sd)
+	}
+	return nil
+}
+
+// snapshotDataRoots returns the snapshotter root directories found in the
+// snapshot data directories.
+func snapshotDataRoots(snapshotter string) []string {
+	var roots []string
+	for _, sd := range snapshotDataDirs {
+		switch {
+		case sd.snapshotter != "":
+			if sd.snapshotter == snapshotter {
+				roots = append(roots, sd.dir)
+			}
+		case explorers.PathExists(filepath.Join(sd.dir, snapshotterDirPrefix+snapshotter), false):
+			roots = append(roots, filepath.Join(sd.dir, snapshotterDirPrefix+snapshotter))
+		case explorers.PathExists(filepath.Join(sd.dir, "snapshots"), false):
+			roots = append(roots, sd.dir)
+		}
+	}
+	return roots
+}
+
+// snapshotLayerRoot returns the snapshotter root directory containing the
+// snapshot directory of the snapshot ID.
+//
+// The snapshot data directories are searched before the snapshotter root
+// directory within the containerd root. The snapshotter root directory is
+// returned if the snapshot directory is not found i.e. for the block based
+// snapshotters.
+func snapshotLayerRoot(root string, snapshotter string, id string) string {
+	candidates := append(snapshotDataRoots(snapshotter), filepath.Join(root, snapshotterDirPrefix+snapshotter))
+	for _, candidate := range candidates {
+		if explorers.PathExists(filepath.Join(candidate, "snapshots", id), false) {
+			return candidate
+		}
+	}
+	return snapshotRootDir(root, snapshotter)
+}
+
+// snapshotDataFiles returns the snapshot databases found in the snapshot
+// data directories keyed by snapshotter.
+//
+// The snapshot database metadata.db of a snapshotter is stored in the
+// snapshotter root directory and may have been captured with the snapshots
+// tree.
+func snapshotDataFiles() map[string]string {
+	files := make(map[string]string)
+	for _, sd := range snapshotDataDirs {
+		if sd.snapshotter != "" {
+			if snapshotfile := filepath.Join(sd.dir, snapshotFilename); explorers.PathExists(snapshotfile, true) {
+				if _, found := files[sd.snapshotter]; !found {
+					files[sd.snapshotter] = snapshotfile
+				}
+			}
+			continue
+		}
+
+		snapshotfiles, _ := filepath.Glob(filepath.Join(sd.dir, snapshotterDirPrefix+"*", snapshotFilename))
+		for _, snapshotfile := range snapshotfiles {
+			snapshotter := strings.TrimPrefix(filepath.Base(filepath.Dir(snapshotfile)), snapshotterDirPrefix)
+			if _, found := files[snapshotter]; !found {
+				files[snapshotter] = snapshotfile
+			}
+		}
+	}
+	return files
+}
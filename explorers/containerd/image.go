@@ -0,0 +1,188 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/google/container-explorer/explorers"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// contentBlobsDir is the blobs directory of the content store relative to
+// the containerd root directory.
+const contentBlobsDir = "io.containerd.content.v1.content/blobs"
+
+// imageManifest holds the fields of an image index or an image manifest.
+type imageManifest struct {
+	MediaType    string               `json:"mediaType,omitempty"`
+	ArtifactType string               `json:"artifactType,omitempty"`
+	Manifests    []ocispec.Descriptor `json:"manifests,omitempty"`
+	Config       ocispec.Descriptor   `json:"config"`
+	Layers       []ocispec.Descriptor `json:"layers"`
+	Subject      *ocispec.Descriptor  `json:"subject,omitempty"`
+}
+
+// InspectImage returns the configuration and layers of an image.
+//
+// The image manifest and configuration are read from the content store. The
+// first platform manifest available in the content store is used for a
+// multi-platform image. The unpacked layer directories are the committed
+// snapshots named using the layer chain IDs.
+func (e *explorer) InspectImage(ctx context.Context, name string) (explorers.ImageDetail, error) {
+	images, err := e.ListImages(ctx)
+	if err != nil {
+		return explorers.ImageDetail{}, err
+	}
+
+	var image *explorers.Image
+	for i := range images {
+		if explorers.MatchImageName(images[i].Name, name) {
+			image = &images[i]
+			break
+		}
+	}
+	if image == nil {
+		return explorers.ImageDetail{}, fmt.Errorf("image %s not found", name)
+	}
+
+	detail := explorers.ImageDetail{
+		Namespace: image.Namespace,
+		Name:      image.Name,
+		Target:    image.Target.Digest.String(),
+	}
+
+	manifest, manifestdigest, err := e.readImageManifest(image.Target.Digest)
+	if err != nil {
+		return detail, err
+	}
+	detail.Manifest = manifestdigest.String()
+	detail.Config = manifest.Config.Digest.String()
+	detail.ConfigPath = e.blobPath(manifest.Config.Digest)
+
+	var config ocispec.Image
+	if err := e.readBlob(manifest.Config.Digest, &config); err != nil {
+		return detail, err
+	}
+
+	layerdirs := e.layerDirs(ctx, image.Namespace)
+
+	diffids := config.RootFS.DiffIDs
+	chainids := identity.ChainIDs(append([]digest.Digest(nil), diffids...))
+	for i, diffid := range diffids {
+		layer := explorers.ImageLayer{
+			DiffID: diffid.String(),
+			Dir:    layerdirs[chainids[i].String()],
+		}
+		if i < len(manifest.Layers) {
+			layer.Digest = manifest.Layers[i].Digest.String()
+			layer.BlobPath = e.blobPath(manifest.Layers[i].Digest)
+		}
+		detail.Layers = append(detail.Layers, layer)
+	}
+	return detail, nil
+}
+
+// readImageManifest returns the image manifest and its digest.
+//
+// The digest of an image index is resolved to the first manifest available
+// in the content store.
+func (e *explorer) readImageManifest(dgst digest.Digest) (imageManifest, digest.Digest, error) {
+	var manifest imageManifest
+	if err := e.readBlob(dgst, &manifest); err != nil {
+		return manifest, dgst, err
+	}
+	if len(manifest.Manifests) == 0 {
+		return manifest, dgst, nil
+	}
+
+	for _, desc := range manifest.Manifests {
+		if !explorers.PathExists(e.blobPath(desc.Digest), true) {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"index":    dgst,
+			"manifest": desc.Digest,
+			"platform": desc.Platform,
+		}).Debug("selected image manifest")
+
+		var platformmanifest imageManifest
+		err := e.readBlob(desc.Digest, &platformmanifest)
+		return platformmanifest, desc.Digest, err
+	}
+	return manifest, dgst, fmt.Errorf("no manifest of image index %s in content store", dgst)
+}
+
+// readBlob reads a JSON blob from the content store.
+func (e *explorer) readBlob(dgst digest.Digest, v interface{}) error {
+	if err := dgst.Validate(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(e.blobPath(dgst))
+	if err != nil {
+		return fmt.Errorf("reading blob %s: %w", dgst, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unmarshalling blob %s: %w", dgst, err)
+	}
+	return nil
+}
+
+// blobPath returns the path of a blob in the content store.
+//
+// The blob is looked up in the content directories captured separately
+// from the containerd root before the content store of the containerd root.
+// The path in the last content store is returned if the blob is not found.
+func (e *explorer) blobPath(dgst digest.Digest) string {
+	var path string
+	for _, root := range e.blobroots {
+		path = filepath.Join(root, dgst.Algorithm().String(), dgst.Encoded())
+		if explorers.PathExists(path, true) {
+			break
+		}
+	}
+	return path
+}
+
+// layerDirs returns the directories of the committed snapshots in a
+// namespace by snapshot key.
+func (e *explorer) layerDirs(ctx context.Context, namespace string) map[string]string {
+	dirs := make(map[string]string)
+
+	skinfos, err := e.ListSnapshots(ctx)
+	if err != nil {
+		log.Warn("listing snapshots: ", err)
+		return dirs
+	}
+	for _, s := range skinfos {
+		if s.Namespace != namespace || s.Kind != snapshots.KindCommitted || s.OverlayPath == "" {
+			continue
+		}
+		if _, found := dirs[s.Key]; !found {
+			dirs[s.Key] = filepath.Join(snapshotLayerRoot(e.root, s.Snapshotter, fmt.Sprint(s.ID)), s.OverlayPath)
+		}
+	}
+	return dirs
+}
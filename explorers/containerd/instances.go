@@ -0,0 +1,343 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+)
+
+// Instance describes a containerd instance.
+//
+// A host may run several containerd instances i.e. Bottlerocket runs the
+// host containers using host-containerd and the orchestrated containers
+// using containerd. Name is empty for the orchestrated containerd and
+// qualifies the namespaces of the other instances i.e.
+// host-containerd/default.
+type Instance struct {
+	Name     string
+	Root     string
+	Metadata string // meta.db
+	Snapshot string // overlayfs metadata.db
+}
+
+// instance holds the explorer of an Instance.
+type instance struct {
+	Instance
+	exp explorers.ContainerExplorer
+}
+
+type instancesExplorer struct {
+	imageroot string
+	instances []instance
+}
+
+// NewInstancesExplorer returns a ContainerExplorer interface to explore the
+// containers of several containerd instances.
+//
+// The namespaces of an instance with a name are qualified using the
+// instance name to distinguish the host containers from the orchestrated
+// containers.
+func NewInstancesExplorer(imageroot string, instances []Instance, sc *explorers.SupportContainer) (explorers.ContainerExplorer, error) {
+	e := &instancesExplorer{imageroot: imageroot}
+
+	for _, i := range instances {
+		exp, err := NewExplorer(imageroot, i.Root, i.Metadata, i.Snapshot, sc)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"instance": i.Name,
+				"root":     i.Root,
+			}).Warn("skipping containerd instance: ", err)
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"instance": i.Name,
+			"root":     i.Root,
+		}).Debug("containerd instance")
+		e.instances = append(e.instances, instance{Instance: i, exp: exp})
+	}
+
+	if len(e.instances) == 0 {
+		return e, fmt.Errorf("no containerd metadata database found")
+	}
+	return e, nil
+}
+
+// qualify returns the namespace qualified using the instance name.
+func (i instance) qualify(ns string) string {
+	if i.Name == "" {
+		return ns
+	}
+	return i.Name + "/" + ns
+}
+
+// lookup returns the instance of the namespace in the context and the
+// context with the instance namespace.
+func (e *instancesExplorer) lookup(ctx context.Context) (instance, context.Context) {
+	ns, ok := namespaces.Namespace(ctx)
+	if ok {
+		for _, i := range e.instances {
+			if i.Name != "" && strings.HasPrefix(ns, i.Name+"/") {
+				return i, namespaces.WithNamespace(ctx, strings.TrimPrefix(ns, i.Name+"/"))
+			}
+		}
+	}
+
+	for _, i := range e.instances {
+		if i.Name == "" {
+			return i, ctx
+		}
+	}
+	return e.instances[0], ctx
+}
+
+// SnapshotRoot returns the snapshot root directory of the orchestrated
+// containerd.
+func (e *instancesExplorer) SnapshotRoot(snapshotter string) string {
+	i, _ := e.lookup(context.Background())
+	return i.exp.SnapshotRoot(snapshotter)
+}
+
+// ListNamespaces returns the qualified namespaces of all the instances.
+func (e *instancesExplorer) ListNamespaces(ctx context.Context) ([]string, error) {
+	var nss []string
+	for _, i := range e.instances {
+		results, err := i.exp.ListNamespaces(ctx)
+		if err != nil {
+			log.WithField("instance", i.Name).Warn("listing namespaces: ", err)
+			continue
+		}
+		for _, ns := range results {
+			nss = append(nss, i.qualify(ns))
+		}
+	}
+	return nss, nil
+}
+
+// ListContainers returns the containers of all the instances.
+func (e *instancesExplorer) ListContainers(ctx context.Context) ([]explorers.Container, error) {
+	var cecontainers []explorers.Container
+	for _, i := range e.instances {
+		ctrs, err := i.exp.ListContainers(ctx)
+		if err != nil {
+			log.WithField("instance", i.Name).Warn("listing containers: ", err)
+			continue
+		}
+		for _, ctr := range ctrs {
+			ctr.Namespace = i.qualify(ctr.Namespace)
+			cecontainers = append(cecontainers, ctr)
+		}
+	}
+	return cecontainers, nil
+}
+
+// ListContainerRecords returns the container records of all the instances.
+func (e *instancesExplorer) ListContainerRecords(ctx context.Context) ([]explorers.Container, error) {
+	var cecontainers []explorers.Container
+	for _, i := range e.instances {
+		lister, ok := i.exp.(explorers.ContainerRecordLister)
+		if !ok {
+			continue
+		}
+		ctrs, err := lister.ListContainerRecords(ctx)
+		if err != nil {
+			log.WithField("instance", i.Name).Warn("listing containers: ", err)
+			continue
+		}
+		for _, ctr := range ctrs {
+			ctr.Namespace = i.qualify(ctr.Namespace)
+			cecontainers = append(cecontainers, ctr)
+		}
+	}
+	return cecontainers, nil
+}
+
+// ListImages returns the images of all the instances.
+func (e *instancesExplorer) ListImages(ctx context.Context) ([]explorers.Image, error) {
+	var ceimages []explorers.Image
+	for _, i := range e.instances {
+		images, err := i.exp.ListImages(ctx)
+		if err != nil {
+			log.WithField("instance", i.Name).Warn("listing images: ", err)
+			continue
+		}
+		for _, image := range images {
+			image.Namespace = i.qualify(image.Namespace)
+			ceimages = append(ceimages, image)
+		}
+	}
+	return ceimages, nil
+}
+
+// InspectImage returns the image from the first instance containing the
+// image.
+func (e *instancesExplorer) InspectImage(ctx context.Context, name string) (explorers.ImageDetail, error) {
+	for _, i := range e.instances {
+		detail, err := i.exp.InspectImage(ctx, name)
+		if err != nil {
+			log.WithField("instance", i.Name).Debug("inspecting image: ", err)
+			continue
+		}
+		detail.Namespace = i.qualify(detail.Namespace)
+		return detail, nil
+	}
+	return explorers.ImageDetail{}, fmt.Errorf("image %s not found", name)
+}
+
+// ImageReferrers returns the referrers of an image from the instance of the
+// namespace.
+func (e *instancesExplorer) ImageReferrers(ctx context.Context, name string) ([]explorers.Referrer, error) {
+	i, ctx := e.lookup(ctx)
+	re, ok := i.exp.(explorers.ReferrerExplorer)
+	if !ok {
+		return nil, fmt.Errorf("instance %s does not keep image referrers", i.Name)
+	}
+	return re.ImageReferrers(ctx, name)
+}
+
+// ListSnapshots returns the snapshots of all the instances.
+func (e *instancesExplorer) ListSnapshots(ctx context.Context) ([]explorers.SnapshotKeyInfo, error) {
+	var ss []explorers.SnapshotKeyInfo
+	for _, i := range e.instances {
+		results, err := i.exp.ListSnapshots(ctx)
+		if err != nil {
+			log.WithField("instance", i.Name).Warn("listing snapshots: ", err)
+			continue
+		}
+		for _, s := range results {
+			s.Namespace = i.qualify(s.Namespace)
+			ss = append(ss, s)
+		}
+	}
+	return ss, nil
+}
+
+// ListContent returns the content of all the instances.
+func (e *instancesExplorer) ListContent(ctx context.Context) ([]explorers.Content, error) {
+	var cecontent []explorers.Content
+	for _, i := range e.instances {
+		results, err := i.exp.ListContent(ctx)
+		if err != nil {
+			log.WithField("instance", i.Name).Warn("listing content: ", err)
+			continue
+		}
+		for _, c := range results {
+			c.Namespace = i.qualify(c.Namespace)
+			cecontent = append(cecontent, c)
+		}
+	}
+	return cecontent, nil
+}
+
+// ListTasks returns the tasks of all the instances.
+func (e *instancesExplorer) ListTasks(ctx context.Context) ([]explorers.Task, error) {
+	var tasks []explorers.Task
+	for _, i := range e.instances {
+		results, err := i.exp.ListTasks(ctx)
+		if err != nil {
+			log.WithField("instance", i.Name).Warn("listing tasks: ", err)
+			continue
+		}
+		for _, t := range results {
+			t.Namespace = i.qualify(t.Namespace)
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks, nil
+}
+
+// ListLeases returns the leases of all the instances.
+func (e *instancesExplorer) ListLeases(ctx context.Context) ([]explorers.Lease, error) {
+	var celeases []explorers.Lease
+	for _, i := range e.instances {
+		results, err := i.exp.ListLeases(ctx)
+		if err != nil {
+			log.WithField("instance", i.Name).Warn("listing leases: ", err)
+			continue
+		}
+		for _, l := range results {
+			l.Namespace = i.qualify(l.Namespace)
+			celeases = append(celeases, l)
+		}
+	}
+	return celeases, nil
+}
+
+// InfoContainer returns container internal information from the instance of
+// the namespace.
+func (e *instancesExplorer) InfoContainer(ctx context.Context, containerid string, spec bool) (interface{}, error) {
+	i, ctx := e.lookup(ctx)
+	return i.exp.InfoContainer(ctx, containerid, spec)
+}
+
+// ContainerLayers returns the container layers from the instance of the
+// namespace.
+func (e *instancesExplorer) ContainerLayers(ctx context.Context, containerid string) (string, []string, error) {
+	i, ctx := e.lookup(ctx)
+	return i.exp.ContainerLayers(ctx, containerid)
+}
+
+// MountContainer mounts a container of the instance of the namespace.
+func (e *instancesExplorer) MountContainer(ctx context.Context, containerid string, mountpoint string) error {
+	i, ctx := e.lookup(ctx)
+	return i.exp.MountContainer(ctx, containerid, mountpoint)
+}
+
+// MountAllContainers mounts the containers of all the instances.
+func (e *instancesExplorer) MountAllContainers(ctx context.Context, mountpoint string, opts explorers.MountAllOptions) ([]explorers.MountIndexEntry, error) {
+	ctrs, err := e.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := explorers.NewMountBatch(mountpoint, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ctr := range ctrs {
+		if opts.SkipSupportContainers && ctr.SupportContainer {
+			log.WithFields(log.Fields{
+				"namespace":   ctr.Namespace,
+				"containerid": ctr.ID,
+			}).Info("skip mounting Kubernetes containers")
+			batch.Skip(ctr, "Kubernetes support container")
+			continue
+		}
+
+		nsctx := namespaces.WithNamespace(ctx, ctr.Namespace)
+		batch.Mount(ctr, func(ctrmountpoint string) error {
+			return e.MountContainer(nsctx, ctr.ID, ctrmountpoint)
+		})
+	}
+
+	return batch.Close()
+}
+
+// Close releases the internal resources of all the instances.
+func (e *instancesExplorer) Close() error {
+	for _, i := range e.instances {
+		i.exp.Close()
+	}
+	return nil
+}
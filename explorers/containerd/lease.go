@@ -0,0 +1,129 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/metadata/boltutil"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	bolt "go.etcd.io/bbolt"
+)
+
+type leaseStore struct {
+	db *bolt.DB
+}
+
+// NewLeaseStore returns lease store used for lease operation
+//
+// In containerd, lease information is stored in metadata file meta.db.
+// i.e. meta.db/v1/<namespace>/leases/<lease id>
+func NewLeaseStore(db *bolt.DB) *leaseStore {
+	return &leaseStore{
+		db: db,
+	}
+}
+
+// List returns leases information.
+func (l *leaseStore) List(ctx context.Context) ([]explorers.Lease, error) {
+	namespace, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var leases []explorers.Lease
+
+	if err := l.db.View(func(tx *bolt.Tx) error {
+		bkt := getLeasesBucket(tx, namespace)
+		if bkt == nil {
+			return nil // no leases
+		}
+
+		return bkt.ForEach(func(k, v []byte) error {
+			lbkt := bkt.Bucket(k)
+			if lbkt == nil {
+				return nil
+			}
+
+			lease := explorers.Lease{
+				Namespace: namespace,
+				ID:        string(k),
+			}
+			if err := readLease(&lease, lbkt); err != nil {
+				return err
+			}
+
+			leases = append(leases, lease)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return leases, nil
+}
+
+// readLease reads the lease timestamp, labels, and referenced resources.
+//
+// The resources are stored in the buckets content, ingests, and
+// snapshots/<snapshotter> of a lease.
+func readLease(lease *explorers.Lease, bkt *bolt.Bucket) error {
+	var updatedAt time.Time
+	if err := boltutil.ReadTimestamps(bkt, &lease.CreatedAt, &updatedAt); err != nil {
+		return err
+	}
+
+	labels, err := boltutil.ReadLabels(bkt)
+	if err != nil {
+		return err
+	}
+	lease.Labels = labels
+
+	for _, name := range [][]byte{bucketKeyObjectContent, bucketKeyObjectIngests} {
+		rbkt := bkt.Bucket(name)
+		if rbkt == nil {
+			continue
+		}
+		rbkt.ForEach(func(k, v []byte) error {
+			lease.Resources = append(lease.Resources, explorers.LeaseResource{
+				Type: string(name),
+				ID:   string(k),
+			})
+			return nil
+		})
+	}
+
+	if sbkt := bkt.Bucket(bucketKeyObjectSnapshots); sbkt != nil {
+		sbkt.ForEach(func(snapshotter, v []byte) error {
+			ssbkt := sbkt.Bucket(snapshotter)
+			if ssbkt == nil {
+				return nil
+			}
+			return ssbkt.ForEach(func(k, v []byte) error {
+				lease.Resources = append(lease.Resources, explorers.LeaseResource{
+					Type: string(bucketKeyObjectSnapshots) + "/" + string(snapshotter),
+					ID:   string(k),
+				})
+				return nil
+			})
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// ContentBlob returns the path of a blob in the content store.
+//
+// The blobs are shared by the namespaces, so the blob is returned even if
+// it is not referenced by the namespace of the context.
+func (e *explorer) ContentBlob(ctx context.Context, dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	path := e.blobPath(dgst)
+	if !explorers.PathExists(path, true) {
+		return "", fmt.Errorf("blob %s not in content store", dgst)
+	}
+	return path, nil
+}
+
+// ImageBlobs returns the blobs of an image in the content store.
+//
+// The manifests of an image index missing from the content store are
+// skipped because containerd only fetches the manifest of the host
+// platform. The configuration and layers of the manifests are returned with
+// an empty path when they are missing e.g. the layers discarded after they
+// were unpacked.
+func (e *explorer) ImageBlobs(ctx context.Context, name string) (string, ocispec.Descriptor, []explorers.ImageBlob, error) {
+	images, err := e.ListImages(ctx)
+	if err != nil {
+		return "", ocispec.Descriptor{}, nil, err
+	}
+
+	ns, _ := namespaces.Namespace(ctx)
+
+	var image *explorers.Image
+	for i := range images {
+		if ns != "" && images[i].Namespace != ns {
+			continue
+		}
+		if explorers.MatchImageName(images[i].Name, name) {
+			image = &images[i]
+			break
+		}
+	}
+	if image == nil {
+		return "", ocispec.Descriptor{}, nil, fmt.Errorf("image %s not found", name)
+	}
+
+	target := image.Target
+	if !explorers.PathExists(e.blobPath(target.Digest), true) {
+		return image.Name, target, nil, fmt.Errorf("image target %s not in content store", target.Digest)
+	}
+
+	var blobs []explorers.ImageBlob
+	seen := make(map[digest.Digest]bool)
+
+	add := func(desc ocispec.Descriptor) bool {
+		if seen[desc.Digest] {
+			return false
+		}
+		seen[desc.Digest] = true
+
+		blob := explorers.ImageBlob{Descriptor: desc}
+		if desc.Digest.Validate() == nil {
+			if path := e.blobPath(desc.Digest); explorers.PathExists(path, true) {
+				blob.Path = path
+			}
+		}
+		blobs = append(blobs, blob)
+		return blob.Path != ""
+	}
+
+	var walk func(desc ocispec.Descriptor) error
+	walk = func(desc ocispec.Descriptor) error {
+		if !add(desc) {
+			return nil
+		}
+
+		var manifest imageManifest
+		if err := e.readBlob(desc.Digest, &manifest); err != nil {
+			return err
+		}
+
+		for _, child := range manifest.Manifests {
+			if !explorers.PathExists(e.blobPath(child.Digest), true) {
+				log.WithFields(log.Fields{
+					"index":    desc.Digest,
+					"manifest": child.Digest,
+					"platform": child.Platform,
+				}).Debug("skipping index manifest not in content store")
+				continue
+			}
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+
+		if manifest.Config.Digest != "" {
+			add(manifest.Config)
+		}
+		for _, layer := range manifest.Layers {
+			add(layer)
+		}
+		return nil
+	}
+
+	if err := walk(target); err != nil {
+		return image.Name, target, nil, err
+	}
+	return image.Name, target, blobs, nil
+}
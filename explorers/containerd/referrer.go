@@ -0,0 +1,252 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/google/container-explorer/explorers"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxReferrerManifestSize is the maximum size of a content store blob
+	// read when looking for the referrer manifests.
+	maxReferrerManifestSize = 4 << 20
+
+	// Docker BuildKit attestation manifest annotations.
+	dockerReferenceTypeAnnotation   = "vnd.docker.reference.type"
+	dockerReferenceDigestAnnotation = "vnd.docker.reference.digest"
+	dockerAttestationManifest       = "attestation-manifest"
+
+	// Predicate type annotations of the in-toto attestation layers.
+	intotoPredicateTypeAnnotation = "in-toto.io/predicate-type"
+	cosignPredicateTypeAnnotation = "predicateType"
+)
+
+// cosignTagSuffixes are the tag suffixes of the cosign attachments i.e.
+// sha256-<digest>.att.
+var cosignTagSuffixes = []string{".att", ".sbom"}
+
+// imageConfigMediaTypes are the config media types which are not artifact
+// types.
+var imageConfigMediaTypes = map[string]bool{
+	ocispec.MediaTypeImageConfig:        true,
+	images.MediaTypeDockerSchema2Config: true,
+	"application/vnd.oci.empty.v1+json": true,
+}
+
+// referrerManifest is a manifest with a subject in the content store.
+type referrerManifest struct {
+	digest   digest.Digest
+	manifest imageManifest
+}
+
+// ImageReferrers returns the SBOM, vulnerability, and provenance documents
+// attached to an image. The image is looked up in the namespace of the
+// context if set.
+//
+// The referrers are read from the content store:
+//   - artifact manifests with a subject pulled using the OCI referrers API
+//   - attestation manifests of the image index added by Docker BuildKit
+//   - cosign attachments tagged sha256-<digest>.att or sha256-<digest>.sbom
+func (e *explorer) ImageReferrers(ctx context.Context, name string) ([]explorers.Referrer, error) {
+	images, err := e.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ns, _ := namespaces.Namespace(ctx)
+
+	var image *explorers.Image
+	for i := range images {
+		if ns != "" && images[i].Namespace != ns {
+			continue
+		}
+		if explorers.MatchImageName(images[i].Name, name) {
+			image = &images[i]
+			break
+		}
+	}
+	if image == nil {
+		return nil, fmt.Errorf("image %s not found", name)
+	}
+
+	// The referrers may refer to the image index or the platform manifests.
+	target := image.Target.Digest
+	subjects := []digest.Digest{target}
+
+	var referrers []explorers.Referrer
+	var index imageManifest
+	if err := e.readBlob(target, &index); err != nil {
+		log.WithField("image", image.Name).Debug("reading image target: ", err)
+	}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[dockerReferenceTypeAnnotation] != dockerAttestationManifest {
+			subjects = append(subjects, desc.Digest)
+			continue
+		}
+
+		var manifest imageManifest
+		if err := e.readBlob(desc.Digest, &manifest); err != nil {
+			log.WithField("manifest", desc.Digest).Debug("reading attestation manifest: ", err)
+			continue
+		}
+		referrers = append(referrers, e.manifestReferrers(
+			desc.Annotations[dockerReferenceDigestAnnotation],
+			desc.Digest,
+			manifest,
+			explorers.ReferrerSourceAttestation,
+		)...)
+	}
+
+	for _, subject := range subjects {
+		for _, r := range e.subjectReferrers(subject.String()) {
+			referrers = append(referrers, e.manifestReferrers(subject.String(), r.digest, r.manifest, explorers.ReferrerSourceSubject)...)
+		}
+	}
+
+	repository, _ := splitImageTag(image.Name)
+	for _, img := range images {
+		if img.Namespace != image.Namespace {
+			continue
+		}
+		repo, tag := splitImageTag(img.Name)
+		if repo != repository {
+			continue
+		}
+		for _, subject := range subjects {
+			if !isCosignTag(tag, subject) {
+				continue
+			}
+			var manifest imageManifest
+			if err := e.readBlob(img.Target.Digest, &manifest); err != nil {
+				log.WithField("image", img.Name).Debug("reading cosign manifest: ", err)
+				continue
+			}
+			referrers = append(referrers, e.manifestReferrers(subject.String(), img.Target.Digest, manifest, explorers.ReferrerSourceCosign)...)
+		}
+	}
+	return referrers, nil
+}
+
+// manifestReferrers returns a referrer for each layer of a referrer manifest.
+func (e *explorer) manifestReferrers(subject string, dgst digest.Digest, manifest imageManifest, source string) []explorers.Referrer {
+	// Artifacts pushed before the artifactType field was added used the
+	// config media type as the artifact type.
+	artifacttype := manifest.ArtifactType
+	if artifacttype == "" && !imageConfigMediaTypes[manifest.Config.MediaType] {
+		artifacttype = manifest.Config.MediaType
+	}
+
+	var referrers []explorers.Referrer
+	for _, layer := range manifest.Layers {
+		predicatetype := layer.Annotations[intotoPredicateTypeAnnotation]
+		if predicatetype == "" {
+			predicatetype = layer.Annotations[cosignPredicateTypeAnnotation]
+		}
+
+		r := explorers.NewReferrer(subject, dgst.String(), artifacttype, layer.MediaType, layer.Digest.String(), predicatetype)
+		r.Source = source
+		r.Path = e.blobPath(layer.Digest)
+		referrers = append(referrers, r)
+	}
+	return referrers
+}
+
+// subjectReferrers returns the manifests in the content store referring to
+// a subject digest.
+//
+// The content stores are read once. Only the blobs smaller than
+// maxReferrerManifestSize are decoded.
+func (e *explorer) subjectReferrers(subject string) []referrerManifest {
+	if e.referrers != nil {
+		return e.referrers[subject]
+	}
+	e.referrers = make(map[string][]referrerManifest)
+
+	seen := make(map[string]bool)
+	for _, root := range e.blobroots {
+		algorithms, err := os.ReadDir(root)
+		if err != nil {
+			log.WithField("path", root).Debug("reading content store: ", err)
+			continue
+		}
+		for _, algorithm := range algorithms {
+			blobs, err := os.ReadDir(filepath.Join(root, algorithm.Name()))
+			if err != nil {
+				continue
+			}
+			for _, blob := range blobs {
+				dgst := digest.NewDigestFromEncoded(digest.Algorithm(algorithm.Name()), blob.Name())
+				if seen[dgst.String()] {
+					continue
+				}
+				seen[dgst.String()] = true
+
+				info, err := blob.Info()
+				if err != nil || !info.Mode().IsRegular() || info.Size() > maxReferrerManifestSize {
+					continue
+				}
+				data, err := os.ReadFile(filepath.Join(root, algorithm.Name(), blob.Name()))
+				if err != nil || len(data) == 0 || data[0] != '{' {
+					continue
+				}
+
+				var manifest imageManifest
+				if err := json.Unmarshal(data, &manifest); err != nil || manifest.Subject == nil {
+					continue
+				}
+				key := manifest.Subject.Digest.String()
+				e.referrers[key] = append(e.referrers[key], referrerManifest{
+					digest:   dgst,
+					manifest: manifest,
+				})
+			}
+		}
+	}
+	return e.referrers[subject]
+}
+
+// splitImageTag returns the repository and the tag of an image name.
+func splitImageTag(name string) (string, string) {
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// isCosignTag returns true if the tag is a cosign attachment of the subject
+// i.e. sha256-<digest>.att.
+func isCosignTag(tag string, subject digest.Digest) bool {
+	prefix := subject.Algorithm().String() + "-" + subject.Encoded()
+	for _, suffix := range cosignTagSuffixes {
+		if tag == prefix+suffix {
+			return true
+		}
+	}
+	return false
+}
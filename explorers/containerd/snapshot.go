@@ -28,6 +28,7 @@ import (
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/snapshots"
 	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/storage"
 	log "github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
 )
@@ -36,6 +37,7 @@ type snapshotStore struct {
 	root string // containerd root directory
 	db   *bolt.DB
 	sdb  *bolt.DB
+	sdbs map[string]*bolt.DB // snapshot database of other snapshotters
 }
 
 // NewSnapshotStore returns snapshotStore which handles viewing of snapshot information
@@ -61,6 +63,27 @@ func NewSnaptshotStore(root string, db *bolt.DB, sdb *bolt.DB) *snapshotStore {
 	}
 }
 
+// SetSnapshotDB sets the snapshot database metadata.db of a snapshotter.
+//
+// Each snapshotter keeps a snapshot database in its root directory i.e.
+// /var/lib/containerd/io.containerd.snapshotter.v1.native/metadata.db. The
+// snapshot database specified using NewSnaptshotStore is used for the
+// snapshotters without a snapshot database.
+func (s *snapshotStore) SetSnapshotDB(snapshotter string, sdb *bolt.DB) {
+	if s.sdbs == nil {
+		s.sdbs = make(map[string]*bolt.DB)
+	}
+	s.sdbs[snapshotter] = sdb
+}
+
+// snapshotDB returns the snapshot database of a snapshotter.
+func (s *snapshotStore) snapshotDB(snapshotter string) *bolt.DB {
+	if sdb, found := s.sdbs[snapshotter]; found {
+		return sdb
+	}
+	return s.sdb
+}
+
 // List returns a structure that contains combined information from metadata
 // and snapshot database snapshot key.
 func (s *snapshotStore) List(ctx context.Context) ([]explorers.SnapshotKeyInfo, error) {
@@ -70,7 +93,7 @@ func (s *snapshotStore) List(ctx context.Context) ([]explorers.SnapshotKeyInfo,
 	}
 
 	// Overlay snapshot bucket
-	if s.sdb == nil {
+	if s.sdb == nil && len(s.sdbs) == 0 {
 		log.Warn("handle to snapshot database does not exist")
 	}
 
@@ -113,8 +136,8 @@ func (s *snapshotStore) List(ctx context.Context) ([]explorers.SnapshotKeyInfo,
 
 				// Reading additional snapshot key information from metadata.db
 				// snapshot key
-				if s.sdb != nil {
-					s.sdb.View(func(otx *bolt.Tx) error {
+				if sdb := s.snapshotDB(string(k)); sdb != nil {
+					sdb.View(func(otx *bolt.Tx) error {
 						log.WithFields(log.Fields{
 							"snapshot key":  skinfo.Key,
 							"snapshot name": skinfo.Name,
@@ -152,7 +175,8 @@ func (s *snapshotStore) OverlayPath(ctx context.Context, container containers.Co
 		return "", "", "", fmt.Errorf("failed to get namespace from context %v", err)
 	}
 
-	if s.sdb == nil {
+	sdb := s.snapshotDB(container.Snapshotter)
+	if sdb == nil {
 		return "", "", "", fmt.Errorf("snapshot database handler (metadata.db) is nil")
 	}
 
@@ -189,25 +213,38 @@ func (s *snapshotStore) OverlayPath(ctx context.Context, container containers.Co
 	}
 
 	var (
-		lowerdir     string
-		upperdir     string
-		workdir      string
-		snapshotroot string
+		lowerdir string
+		upperdir string
+		workdir  string
 	)
 
-	snapshotroot = snapshotRootDir(s.root, container.Snapshotter)
+	driver, err := storage.Get(container.Snapshotter)
+	if err != nil {
+		return "", "", "", err
+	}
+
 	// Read snapshot metadata (metadata.db) snapshotkey bucket
 	// and extract value of key "id".
 	//
-	// The value of "id" specifies snapshot path in overlayfs
+	// The value of "id" specifies the snapshot path of the storage driver
 	// i.e. /var/lib/containerd/io.containerd.snapshotter.v1.overlayfs/snapshots/<id>/fs
-	if err := s.sdb.View(func(tx *bolt.Tx) error {
+	if err := sdb.View(func(tx *bolt.Tx) error {
 		upperdirID, err := getSnapshotID(tx, snapshotkeys[0])
 		if err != nil {
 			return err
 		}
-		upperdir = filepath.Join(snapshotroot, "snapshots", fmt.Sprintf("%d", upperdirID), "fs")
-		workdir = filepath.Join(snapshotroot, "snapshots", fmt.Sprintf("%d", upperdirID), "work")
+		upperroot := snapshotLayerRoot(s.root, container.Snapshotter, fmt.Sprintf("%d", upperdirID))
+		upperdir, err = driver.LayerDir(upperroot, fmt.Sprintf("%d", upperdirID), true)
+		if err != nil {
+			return err
+		}
+		workdir = filepath.Join(upperroot, "snapshots", fmt.Sprintf("%d", upperdirID), "work")
+
+		// The upper directory of a full copy snapshotter i.e. native
+		// contains all the container files.
+		if storage.IsFullCopy(container.Snapshotter) {
+			return nil
+		}
 
 		// compute lowerdir
 		for _, ssk := range snapshotkeys[1:] {
@@ -215,7 +252,10 @@ func (s *snapshotStore) OverlayPath(ctx context.Context, container containers.Co
 			if err != nil {
 				return err
 			}
-			ldir := filepath.Join(snapshotroot, "snapshots", fmt.Sprintf("%d", id), "fs")
+			ldir, err := driver.LayerDir(snapshotLayerRoot(s.root, container.Snapshotter, fmt.Sprintf("%d", id)), fmt.Sprintf("%d", id), false)
+			if err != nil {
+				return err
+			}
 
 			if lowerdir == "" {
 				lowerdir = ldir
@@ -258,15 +298,22 @@ func readOverlaySnapshotKey(skinfo *explorers.SnapshotKeyInfo, bkt *bolt.Bucket)
 	}
 
 	skinfo.ID, _ = binary.Uvarint(bkt.Get(bucketKeyID))
-	skinfo.OverlayPath = fmt.Sprintf("snapshots/%d/fs", skinfo.ID)
 
 	kind, _ := binary.Uvarint(bkt.Get(bucketKeyKind))
 	skinfo.Kind = snapshots.Kind(uint8(kind))
 
+	// The snapshot path relative to the snapshot root directory
+	if driver, err := storage.Get(skinfo.Snapshotter); err == nil {
+		skinfo.OverlayPath, _ = driver.LayerDir("", fmt.Sprintf("%d", skinfo.ID), skinfo.Kind == snapshots.KindActive)
+	}
+
 	skinfo.Size, _ = binary.Uvarint(bkt.Get(bucketKeySize))
 
 	// Handle if skinfo already has labels from meta.db
 	labels, _ := boltutil.ReadLabels(bkt)
+	if skinfo.Labels == nil && len(labels) > 0 {
+		skinfo.Labels = make(map[string]string)
+	}
 	for k, v := range labels {
 		if val, found := skinfo.Labels[k]; found {
 			if v != val {
@@ -299,7 +346,23 @@ func getSnapshotID(tx *bolt.Tx, snapshotkey string) (uint64, error) {
 //
 // In containerd, the default snapshot root directory is
 // /var/lib/containerd/io.containerd.snapshotter.v1.overlayfs
+//
+// The exact directory name is preferred because a snapshotter name may be a
+// part of another snapshotter name i.e. overlayfs and fuse-overlayfs. The
+// proxy snapshotters i.e. stargz use a directory outside the containerd root.
+// A snapshotter root directory found in a snapshot data directory is
+// preferred over the containerd root.
 func snapshotRootDir(root string, snapshotter string) string {
+	if dirs := snapshotDataRoots(snapshotter); len(dirs) > 0 {
+		return dirs[0]
+	}
+	if dir := filepath.Join(root, snapshotterDirPrefix+snapshotter); explorers.PathExists(dir, false) {
+		return dir
+	}
+	if dir := proxySnapshotterDir(root, snapshotter); dir != "" {
+		return dir
+	}
+
 	dirs, _ := filepath.Glob(filepath.Join(root, "*"))
 	for _, dir := range dirs {
 		if strings.Contains(strings.ToLower(dir), strings.ToLower(snapshotter)) {
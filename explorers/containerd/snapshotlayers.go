@@ -0,0 +1,217 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/storage"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// SnapshotLayers returns the directory of a snapshot and the directories of
+// its parent snapshots ordered from the nearest parent.
+//
+// The parent chain is read from the snapshot database metadata.db, so an
+// active or committed snapshot is resolved when the container records in
+// meta.db are damaged. The snapshot is specified using the meta.db snapshot
+// key, the metadata.db snapshot name i.e. k8s.io/12/<key>, or the key part
+// of a snapshot name.
+func (e *explorer) SnapshotLayers(ctx context.Context, snapshotter string, key string) (string, []string, error) {
+	if platform := storage.Platform(snapshotter); platform != "" {
+		return "", nil, fmt.Errorf("the %s snapshotter layers cannot be mounted on Linux", platform)
+	}
+	driver, err := storage.Get(snapshotter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	snapshotfile := e.snapshotFile(snapshotter)
+	sdb, err := explorers.OpenBolt(snapshotfile, 0)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open snapshot database %s: %w", snapshotfile, err)
+	}
+	defer sdb.Close()
+
+	name, err := e.snapshotName(ctx, sdb, snapshotter, key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var dirs []string
+	if err := sdb.View(func(tx *bolt.Tx) error {
+		seen := make(map[string]bool)
+		for name != "" {
+			if seen[name] {
+				return fmt.Errorf("snapshot %s has a parent cycle", name)
+			}
+			seen[name] = true
+
+			bkt := getOverlaySnapshotBucket(tx, name)
+			if bkt == nil {
+				return fmt.Errorf("parent snapshot %s does not exist in %s", name, snapshotfile)
+			}
+			id, _ := binary.Uvarint(bkt.Get(bucketKeyID))
+			kind, _ := binary.Uvarint(bkt.Get(bucketKeyKind))
+			active := snapshots.Kind(uint8(kind)) == snapshots.KindActive
+
+			sid := fmt.Sprintf("%d", id)
+			dir, err := driver.LayerDir(snapshotLayerRoot(e.root, snapshotter, sid), sid, active)
+			if err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"snapshot": name,
+				"id":       id,
+				"active":   active,
+				"dir":      dir,
+			}).Debug("snapshot layer")
+			dirs = append(dirs, dir)
+
+			// The snapshot of a full copy snapshotter i.e. native contains
+			// the files of the parent snapshots.
+			if storage.IsFullCopy(snapshotter) {
+				break
+			}
+			name = string(bkt.Get(bucketKeyParent))
+		}
+		return nil
+	}); err != nil {
+		return "", nil, err
+	}
+
+	for _, dir := range dirs {
+		if !explorers.PathExists(dir, false) {
+			log.WithField("dir", dir).Warn("snapshot directory does not exist")
+		}
+	}
+	return dirs[0], dirs[1:], nil
+}
+
+// ContainerImageCopy returns the directory of the committed parent snapshot
+// of the container snapshot of a full copy snapshotter i.e. native.
+func (e *explorer) ContainerImageCopy(ctx context.Context, containerid string) (string, error) {
+	container, err := getContainer(ctx, e.mdb, containerid)
+	if err != nil {
+		return "", fmt.Errorf("failed getting container information %v", err)
+	}
+	if !storage.IsFullCopy(container.Snapshotter) {
+		return "", fmt.Errorf("the %s snapshotter does not keep a full copy of the files", container.Snapshotter)
+	}
+
+	snapshotfile := e.snapshotFile(container.Snapshotter)
+	sdb, err := explorers.OpenBolt(snapshotfile, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to open snapshot database %s: %w", snapshotfile, err)
+	}
+	name, err := e.snapshotName(ctx, sdb, container.Snapshotter, container.SnapshotKey)
+	var parent string
+	if err == nil {
+		err = sdb.View(func(tx *bolt.Tx) error {
+			bkt := getOverlaySnapshotBucket(tx, name)
+			if bkt == nil {
+				return fmt.Errorf("snapshot %s does not exist in %s", name, snapshotfile)
+			}
+			parent = string(bkt.Get(bucketKeyParent))
+			return nil
+		})
+	}
+	sdb.Close()
+	if err != nil {
+		return "", err
+	}
+	if parent == "" {
+		return "", fmt.Errorf("snapshot %s has no parent snapshot", name)
+	}
+
+	dir, _, err := e.SnapshotLayers(ctx, container.Snapshotter, parent)
+	return dir, err
+}
+
+// snapshotName returns the metadata.db snapshot name of a snapshot.
+//
+// A metadata.db snapshot name is used as is. A meta.db snapshot key is
+// resolved in the namespace of the context or in all namespaces. Otherwise,
+// the snapshot names ending with the key are matched.
+func (e *explorer) snapshotName(ctx context.Context, sdb *bolt.DB, snapshotter string, key string) (string, error) {
+	var names []string
+	if err := sdb.View(func(tx *bolt.Tx) error {
+		if getOverlaySnapshotBucket(tx, key) != nil {
+			names = []string{key}
+			return nil
+		}
+		bkt := getBucket(tx, bucketKeyVersion, bucketKeyObjectSnapshots)
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if strings.HasSuffix(string(k), "/"+key) {
+				names = append(names, string(k))
+			}
+			return nil
+		})
+	}); err != nil {
+		return "", err
+	}
+	if len(names) == 1 && names[0] == key {
+		return key, nil
+	}
+
+	// The meta.db snapshot key maps to the metadata.db snapshot name.
+	if e.mdb != nil {
+		var nss []string
+		if ns, ok := namespaces.Namespace(ctx); ok && ns != "" {
+			nss = []string{ns}
+		} else if all, err := e.ListNamespaces(ctx); err == nil {
+			nss = all
+		}
+
+		var resolved []string
+		e.mdb.View(func(tx *bolt.Tx) error {
+			for _, ns := range nss {
+				if bkt := getSnapshotKeyBucket(tx, ns, snapshotter, key); bkt != nil {
+					if name := string(bkt.Get(bucketKeyName)); name != "" {
+						resolved = append(resolved, name)
+					}
+				}
+			}
+			return nil
+		})
+		if len(resolved) == 1 {
+			return resolved[0], nil
+		}
+		if len(resolved) > 1 {
+			return "", fmt.Errorf("snapshot key %s exists in several namespaces: %s. Use --namespace", key, strings.Join(resolved, ", "))
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("snapshot %s/%s does not exist", snapshotter, key)
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("snapshot key %s matches several snapshots: %s. Use the snapshot name", key, strings.Join(names, ", "))
+	}
+}
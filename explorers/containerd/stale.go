@@ -0,0 +1,410 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/metadata/boltutil"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// metadataDBName is the containerd metadata database i.e.
+	// io.containerd.metadata.v1.bolt/meta.db
+	metadataDBName = "meta.db"
+
+	// snapshotDBName is the snapshotter database i.e.
+	// io.containerd.snapshotter.v1.overlayfs/metadata.db
+	snapshotDBName = "metadata.db"
+
+	// boltMagic is the magic number in the meta pages of a bolt database.
+	boltMagic = 0xED0CDAED
+)
+
+// staleSuffixes are the file name suffixes of temporary and backup copies of
+// a database.
+var staleSuffixes = []string{".tmp", ".bak", ".old", ".orig", ".backup", ".save", "~"}
+
+// MetadataArtifact is a leftover temporary or backup copy of a containerd
+// metadata or snapshotter database.
+type MetadataArtifact struct {
+	Path        string    `json:"path"`
+	Database    string    `json:"database"` // current database
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	Bolt        bool      `json:"bolt"`
+	TxID        uint64    `json:"txid,omitempty"`
+	CurrentTxID uint64    `json:"current_txid,omitempty"`
+}
+
+// SnapshotDB returns true if the artifact is a copy of a snapshotter database.
+func (a MetadataArtifact) SnapshotDB() bool {
+	return filepath.Base(a.Database) == snapshotDBName
+}
+
+// MetadataChange is an object that differs between a leftover copy and the
+// current database.
+//
+// Change is removed if the object only exists in the copy, added if the
+// object only exists in the current database, and modified if the object
+// exists in both with different values.
+type MetadataChange struct {
+	Artifact         string    `json:"artifact"`
+	Database         string    `json:"database"`
+	Kind             string    `json:"kind"`
+	Namespace        string    `json:"namespace,omitempty"`
+	Name             string    `json:"name"`
+	Change           string    `json:"change"`
+	StaleUpdatedAt   time.Time `json:"stale_updated_at"`   // zero if removed or not recorded
+	CurrentUpdatedAt time.Time `json:"current_updated_at"` // zero if added or not recorded
+}
+
+// MarshalJSON omits the update times that are not known.
+func (c MetadataChange) MarshalJSON() ([]byte, error) {
+	type change MetadataChange
+	return json.Marshal(struct {
+		change
+		StaleUpdatedAt   *time.Time `json:"stale_updated_at,omitempty"`
+		CurrentUpdatedAt *time.Time `json:"current_updated_at,omitempty"`
+	}{
+		change:           change(c),
+		StaleUpdatedAt:   explorers.OptionalTime(c.StaleUpdatedAt),
+		CurrentUpdatedAt: explorers.OptionalTime(c.CurrentUpdatedAt),
+	})
+}
+
+// metadataObject is an object bucket in a metadata database.
+type metadataObject struct {
+	Kind      string
+	Namespace string
+	Name      string
+	UpdatedAt time.Time
+	Digest    string // digest of the keys and values in the bucket
+}
+
+// namespaceObjectBuckets are the object buckets in meta.db/v1/<namespace>
+// keyed by object kind.
+var namespaceObjectBuckets = map[string][][]byte{
+	"container": {bucketKeyObjectContainers},
+	"image":     {[]byte("images")},
+	"snapshot":  {bucketKeyObjectSnapshots},
+	"content":   {bucketKeyObjectContent, bucketKeyObjectBlob},
+	"ingest":    {bucketKeyObjectContent, bucketKeyObjectIngests},
+	"lease":     {bucketKeyObjectLeases},
+	"sandbox":   {bucketKeyObjectSandboxes},
+}
+
+// FindMetadataArtifacts returns the temporary and backup copies of the
+// metadata database and the snapshotter databases in the containerd root
+// directory.
+//
+// A file next to a database is an artifact if the name starts with the
+// database name i.e. meta.db.tmp, ends with a temporary or backup suffix, or
+// is a bolt database.
+func FindMetadataArtifacts(root string) []MetadataArtifact {
+	dirs, _ := filepath.Glob(filepath.Join(root, "io.containerd.snapshotter.v1.*"))
+	dirs = append([]string{filepath.Join(root, "io.containerd.metadata.v1.bolt")}, dirs...)
+
+	var artifacts []MetadataArtifact
+	for _, dir := range dirs {
+		dbname := snapshotDBName
+		if filepath.Base(dir) == "io.containerd.metadata.v1.bolt" {
+			dbname = metadataDBName
+		}
+		database := filepath.Join(dir, dbname)
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if name == dbname || !entry.Type().IsRegular() {
+				continue
+			}
+
+			path := filepath.Join(dir, name)
+			txid, isbolt := BoltTxID(path)
+			if !isbolt && !strings.HasPrefix(name, dbname) && !hasStaleSuffix(name) {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			artifact := MetadataArtifact{
+				Path:     path,
+				Database: database,
+				Size:     info.Size(),
+				ModTime:  info.ModTime().UTC(),
+				Bolt:     isbolt,
+				TxID:     txid,
+			}
+			artifact.CurrentTxID, _ = BoltTxID(database)
+			artifacts = append(artifacts, artifact)
+
+			log.WithFields(log.Fields{
+				"path":     path,
+				"database": database,
+				"bolt":     isbolt,
+				"txid":     txid,
+			}).Debug("found metadata artifact")
+		}
+	}
+	return artifacts
+}
+
+// hasStaleSuffix returns true if the file name ends with a temporary or
+// backup suffix.
+func hasStaleSuffix(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range staleSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// BoltTxID returns the last transaction ID of a bolt database and true if
+// the file is a bolt database.
+//
+// A bolt database has two meta pages at the start of the file. The meta page
+// with the higher transaction ID is the current meta page. The transaction ID
+// tells whether a copy was written before or after the current database.
+func BoltTxID(path string) (uint64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	// page header (16 bytes) followed by the meta i.e. magic, version,
+	// page size, flags, root bucket, freelist, pgid, txid, and checksum.
+	meta := func(offset int64) (uint32, uint64, bool) {
+		buf := make([]byte, 80)
+		if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return 0, 0, false
+		}
+		if binary.LittleEndian.Uint32(buf[16:20]) != boltMagic {
+			return 0, 0, false
+		}
+		return binary.LittleEndian.Uint32(buf[24:28]), binary.LittleEndian.Uint64(buf[64:72]), true
+	}
+
+	pagesize, txid, ok := meta(0)
+	if !ok {
+		return 0, false
+	}
+	if _, txid1, ok := meta(int64(pagesize)); ok && txid1 > txid {
+		txid = txid1
+	}
+	return txid, true
+}
+
+// CompareMetadata returns the objects that differ between a leftover copy
+// and the current database.
+//
+// The objects are the containers, images, snapshots, content, ingests,
+// leases, and sandboxes of the metadata database and the snapshots of a
+// snapshotter database.
+func CompareMetadata(artifact MetadataArtifact) ([]MetadataChange, error) {
+	stale, err := readMetadataObjects(artifact.Path, artifact.SnapshotDB())
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", artifact.Path, err)
+	}
+	current, err := readMetadataObjects(artifact.Database, artifact.SnapshotDB())
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", artifact.Database, err)
+	}
+
+	var changes []MetadataChange
+	newChange := func(o metadataObject, change string) MetadataChange {
+		return MetadataChange{
+			Artifact:  artifact.Path,
+			Database:  artifact.Database,
+			Kind:      o.Kind,
+			Namespace: o.Namespace,
+			Name:      o.Name,
+			Change:    change,
+		}
+	}
+
+	for k, so := range stale {
+		co, found := current[k]
+		if !found {
+			c := newChange(so, "removed")
+			c.StaleUpdatedAt = so.UpdatedAt
+			changes = append(changes, c)
+			continue
+		}
+		if so.Digest != co.Digest {
+			c := newChange(so, "modified")
+			c.StaleUpdatedAt = so.UpdatedAt
+			c.CurrentUpdatedAt = co.UpdatedAt
+			changes = append(changes, c)
+		}
+	}
+	for k, co := range current {
+		if _, found := stale[k]; !found {
+			c := newChange(co, "added")
+			c.CurrentUpdatedAt = co.UpdatedAt
+			changes = append(changes, c)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		if changes[i].Namespace != changes[j].Namespace {
+			return changes[i].Namespace < changes[j].Namespace
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes, nil
+}
+
+// readMetadataObjects returns the object buckets of a metadata database or
+// a snapshotter database keyed by kind, namespace, and name.
+func readMetadataObjects(path string, snapshotdb bool) (map[string]metadataObject, error) {
+	db, err := explorers.OpenBolt(path, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	objects := make(map[string]metadataObject)
+	add := func(kind string, namespace string, name string, bkt *bolt.Bucket) {
+		o := metadataObject{
+			Kind:      kind,
+			Namespace: namespace,
+			Name:      name,
+			Digest:    bucketDigest(bkt),
+		}
+		var createdat time.Time
+		boltutil.ReadTimestamps(bkt, &createdat, &o.UpdatedAt)
+		objects[fmt.Sprintf("%s/%s/%s", kind, namespace, name)] = o
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		vbkt := tx.Bucket(bucketKeyVersion)
+		if vbkt == nil {
+			return fmt.Errorf("bucket %s does not exist", bucketKeyVersion)
+		}
+
+		// metadata.db/v1/snapshots/<snapshot key>
+		if snapshotdb {
+			sbkt := vbkt.Bucket(bucketKeyObjectSnapshots)
+			if sbkt == nil {
+				return nil
+			}
+			return sbkt.ForEach(func(k, v []byte) error {
+				if kbkt := sbkt.Bucket(k); kbkt != nil {
+					add("snapshot", "", string(k), kbkt)
+				}
+				return nil
+			})
+		}
+
+		// meta.db/v1/<namespace>/<object bucket>/<object>
+		return vbkt.ForEach(func(ns, v []byte) error {
+			nsbkt := vbkt.Bucket(ns)
+			if nsbkt == nil {
+				return nil
+			}
+			for kind, keys := range namespaceObjectBuckets {
+				obkt := nsbkt.Bucket(keys[0])
+				for _, key := range keys[1:] {
+					if obkt == nil {
+						break
+					}
+					obkt = obkt.Bucket(key)
+				}
+				if obkt == nil {
+					continue
+				}
+
+				obkt.ForEach(func(k, v []byte) error {
+					kbkt := obkt.Bucket(k)
+					if kbkt == nil {
+						return nil
+					}
+
+					// meta.db/v1/<namespace>/snapshots/<snapshotter>/<snapshot key>
+					if kind == "snapshot" {
+						return kbkt.ForEach(func(k1, v1 []byte) error {
+							if sbkt := kbkt.Bucket(k1); sbkt != nil {
+								add(kind, string(ns), fmt.Sprintf("%s/%s", k, k1), sbkt)
+							}
+							return nil
+						})
+					}
+					add(kind, string(ns), string(k), kbkt)
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// bucketDigest returns the SHA-256 digest of the keys and values of a bucket
+// and its nested buckets.
+func bucketDigest(bkt *bolt.Bucket) string {
+	h := sha256.New()
+
+	var walk func(b *bolt.Bucket, prefix []byte)
+	walk = func(b *bolt.Bucket, prefix []byte) {
+		b.ForEach(func(k, v []byte) error {
+			key := bytes.Join([][]byte{prefix, k}, []byte("/"))
+			binary.Write(h, binary.LittleEndian, uint32(len(key)))
+			h.Write(key)
+			if v == nil {
+				if nbkt := b.Bucket(k); nbkt != nil {
+					walk(nbkt, key)
+				}
+				return nil
+			}
+			binary.Write(h, binary.LittleEndian, uint32(len(v)))
+			h.Write(v)
+			return nil
+		})
+	}
+	walk(bkt, nil)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/google/container-explorer/explorers"
+	digest "github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// remoteSnapshotLabel marks a snapshot lazily pulled by a remote
+	// snapshotter i.e. stargz. The files are fetched on demand from the
+	// registry and served using FUSE.
+	remoteSnapshotLabel = "containerd.io/snapshot/remote"
+
+	// stargzDigestLabel is the layer digest of a stargz remote snapshot.
+	stargzDigestLabel = "containerd.io/snapshot/remote/stargz.digest"
+
+	// criLayerDigestLabel is the layer digest of a snapshot prepared by the
+	// CRI plugin.
+	criLayerDigestLabel = "containerd.io/snapshot/cri.layer-digest"
+)
+
+// Remote snapshot states reported in SnapshotKeyInfo.Remote.
+const (
+	// remoteMaterialized is a remote snapshot with the files on disk.
+	remoteMaterialized = "materialized"
+
+	// remoteBlob is a remote snapshot without the files on disk. The layer
+	// blob is in the content store.
+	remoteBlob = "blob"
+
+	// remoteIncomplete is a remote snapshot without the files or the layer
+	// blob on disk. Only the chunks read by the containers may be in the
+	// snapshotter cache.
+	remoteIncomplete = "incomplete"
+)
+
+// proxySnapshotterDirs are the root directories of the snapshotters running
+// as proxy plugins outside the containerd root directory. The directories
+// are relative to the parent of the containerd root directory i.e.
+// /var/lib/containerd-stargz-grpc/snapshotter.
+var proxySnapshotterDirs = map[string]string{
+	"stargz": "containerd-stargz-grpc/snapshotter",
+}
+
+// proxySnapshotterDir returns the root directory of a proxy snapshotter or
+// an empty string.
+func proxySnapshotterDir(root string, snapshotter string) string {
+	dir, found := proxySnapshotterDirs[snapshotter]
+	if !found {
+		return ""
+	}
+	dir = filepath.Join(filepath.Dir(root), dir)
+	if !explorers.PathExists(dir, false) {
+		return ""
+	}
+	return dir
+}
+
+// proxySnapshotFiles returns the snapshot databases of the proxy
+// snapshotters found on disk keyed by snapshotter.
+func proxySnapshotFiles(root string) map[string]string {
+	files := make(map[string]string)
+	for snapshotter := range proxySnapshotterDirs {
+		dir := proxySnapshotterDir(root, snapshotter)
+		if dir == "" {
+			continue
+		}
+		if snapshotfile := filepath.Join(dir, snapshotFilename); explorers.PathExists(snapshotfile, true) {
+			files[snapshotter] = snapshotfile
+		}
+	}
+	return files
+}
+
+// remoteSnapshotState returns the state of a remote snapshot or an empty
+// string if the snapshot is not a remote snapshot.
+//
+// A lazily pulled layer is mounted using FUSE on the host. The mounted files
+// are not in the snapshot directory of a disk image, and the layer is
+// complete only if the layer blob was also pulled into the content store.
+func (e *explorer) remoteSnapshotState(s explorers.SnapshotKeyInfo) string {
+	if _, found := s.Labels[remoteSnapshotLabel]; !found {
+		return ""
+	}
+
+	if s.OverlayPath != "" {
+		entries, err := os.ReadDir(filepath.Join(e.SnapshotRoot(s.Snapshotter), s.OverlayPath))
+		if err == nil && len(entries) > 0 {
+			return remoteMaterialized
+		}
+	}
+
+	for _, label := range []string{stargzDigestLabel, criLayerDigestLabel} {
+		dgst, err := digest.Parse(s.Labels[label])
+		if err != nil {
+			continue
+		}
+		if explorers.PathExists(e.blobPath(dgst), true) {
+			return remoteBlob
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"snapshotter": s.Snapshotter,
+		"snapshotkey": s.Key,
+	}).Debug("remote snapshot is not materialized on disk")
+	return remoteIncomplete
+}
@@ -0,0 +1,634 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/metadata/boltutil"
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt page flags and the leaf element flag of a nested bucket.
+const (
+	boltLeafPageFlag    = 0x02
+	boltBucketLeafFlag  = 0x01
+	boltPageHeaderSize  = 16
+	boltLeafElementSize = 16
+	boltBucketSize      = 16
+)
+
+// Tag history changes reported in TagHistory.Change.
+const (
+	// tagRepointed is a name that currently resolves to a different digest.
+	tagRepointed = "repointed"
+
+	// tagDeleted is a name that no longer exists.
+	tagDeleted = "deleted"
+)
+
+// TagHistory is a previous image name to digest mapping recovered from the
+// remnants of the metadata database.
+//
+// Bolt writes a modified page to a new location and frees the old page
+// when the transaction is committed. The freed pages and the unallocated
+// pages at the end of meta.db still hold the previous image records until
+// the pages are reused. A name that was repointed to a different digest is
+// evidence of an image substitution.
+type TagHistory struct {
+	Namespace        string     `json:"namespace,omitempty"`
+	Name             string     `json:"name"`
+	Digest           string     `json:"digest"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Change           string     `json:"change"`
+	CurrentDigest    string     `json:"current_digest,omitempty"`
+	CurrentUpdatedAt time.Time  `json:"current_updated_at"` // zero if the name was removed
+	Sources          []string   `json:"sources"`            // i.e. meta.db free page 42
+	Leases           []TagLease `json:"leases,omitempty"`
+}
+
+// MarshalJSON omits the times that are not recorded.
+func (h TagHistory) MarshalJSON() ([]byte, error) {
+	type history TagHistory
+	return json.Marshal(struct {
+		history
+		CreatedAt        *time.Time `json:"created_at,omitempty"`
+		UpdatedAt        *time.Time `json:"updated_at,omitempty"`
+		CurrentUpdatedAt *time.Time `json:"current_updated_at,omitempty"`
+	}{
+		history:          history(h),
+		CreatedAt:        explorers.OptionalTime(h.CreatedAt),
+		UpdatedAt:        explorers.OptionalTime(h.UpdatedAt),
+		CurrentUpdatedAt: explorers.OptionalTime(h.CurrentUpdatedAt),
+	})
+}
+
+// TagLease is a lease referencing the previous or the current digest of a
+// name. The lease created by a pull tells when the digest was pulled.
+type TagLease struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Digest    string    `json:"digest"`
+	Source    string    `json:"source"` // live or the carved page
+}
+
+// MarshalJSON omits the creation time if it is not recorded.
+func (l TagLease) MarshalJSON() ([]byte, error) {
+	type lease TagLease
+	return json.Marshal(struct {
+		lease
+		CreatedAt *time.Time `json:"created_at,omitempty"`
+	}{
+		lease:     lease(l),
+		CreatedAt: explorers.OptionalTime(l.CreatedAt),
+	})
+}
+
+// carvedBucket is a bucket carved from a bolt leaf page or an inline
+// bucket.
+type carvedBucket struct {
+	values  map[string][]byte
+	buckets map[string]*carvedBucket // inline buckets
+	roots   map[string]uint64        // root pages of the other buckets
+}
+
+// imageRecord is an image name to digest mapping.
+type imageRecord struct {
+	namespace string
+	name      string
+	digest    string
+	createdAt time.Time
+	updatedAt time.Time
+	source    string
+}
+
+// leaseRecord is a lease and the referenced content digests.
+type leaseRecord struct {
+	id        string
+	createdAt time.Time
+	digests   []string
+	source    string
+}
+
+// RecoverTagHistory returns the previous image name to digest mappings
+// recovered from the free and unallocated pages of the metadata database
+// and from all the pages of the leftover copies.
+//
+// A mapping is reported when the name now resolves to a different digest or
+// no longer exists. The live and carved leases referencing the previous or
+// current digest are attached.
+func RecoverTagHistory(metadatafile string, copies []string) ([]TagHistory, error) {
+	live, leases, err := readLiveTags(metadatafile)
+	if err != nil {
+		return nil, err
+	}
+
+	var carved []imageRecord
+	records, carvedleases, err := carveTags(metadatafile, true)
+	if err != nil {
+		return nil, err
+	}
+	carved = append(carved, records...)
+	leases = append(leases, carvedleases...)
+
+	for _, path := range copies {
+		records, carvedleases, err := carveTags(path, false)
+		if err != nil {
+			log.WithField("path", path).Warn("carving database copy: ", err)
+			continue
+		}
+		carved = append(carved, records...)
+		leases = append(leases, carvedleases...)
+	}
+
+	// current maps a name to the live records in the namespaces.
+	current := make(map[string][]imageRecord)
+	for _, r := range live {
+		current[r.name] = append(current[r.name], r)
+	}
+
+	histories := make(map[string]*TagHistory)
+	var keys []string
+	for _, r := range carved {
+		isCurrent := false
+		for _, c := range current[r.name] {
+			if c.digest == r.digest {
+				isCurrent = true
+			}
+		}
+		if isCurrent {
+			continue
+		}
+
+		key := r.name + "@" + r.digest
+		h, found := histories[key]
+		if !found {
+			h = &TagHistory{
+				Name:   r.name,
+				Digest: r.digest,
+				Change: tagDeleted,
+			}
+			// The namespace of a carved record is unknown. The name is
+			// matched with the live images sorted by namespace.
+			if c := current[r.name]; len(c) > 0 {
+				h.Namespace = c[0].namespace
+				h.Change = tagRepointed
+				h.CurrentDigest = c[0].digest
+				h.CurrentUpdatedAt = c[0].updatedAt
+			}
+			histories[key] = h
+			keys = append(keys, key)
+		}
+		if r.updatedAt.After(h.UpdatedAt) {
+			h.CreatedAt = r.createdAt
+			h.UpdatedAt = r.updatedAt
+		}
+		h.Sources = appendUnique(h.Sources, r.source)
+	}
+
+	var result []TagHistory
+	for _, key := range keys {
+		h := histories[key]
+		// The live leases are first, so a carved copy of a live lease is
+		// not attached.
+		seen := make(map[string]bool)
+		for _, l := range leases {
+			for _, dgst := range l.digests {
+				if seen[l.id+"@"+dgst] {
+					continue
+				}
+				if dgst == h.Digest || (dgst == h.CurrentDigest && h.CurrentDigest != "") {
+					seen[l.id+"@"+dgst] = true
+					h.Leases = append(h.Leases, TagLease{
+						ID:        l.id,
+						CreatedAt: l.createdAt,
+						Digest:    dgst,
+						Source:    l.source,
+					})
+				}
+			}
+		}
+		result = append(result, *h)
+	}
+
+	// The most recently repointed names first.
+	sort.SliceStable(result, func(i, j int) bool {
+		if !result[i].CurrentUpdatedAt.Equal(result[j].CurrentUpdatedAt) {
+			return result[i].CurrentUpdatedAt.After(result[j].CurrentUpdatedAt)
+		}
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].UpdatedAt.After(result[j].UpdatedAt)
+	})
+	return result, nil
+}
+
+// readLiveTags returns the live image records and leases of the metadata
+// database.
+func readLiveTags(path string) ([]imageRecord, []leaseRecord, error) {
+	db, err := explorers.OpenBolt(path, 5*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	var (
+		images []imageRecord
+		leases []leaseRecord
+	)
+	err = db.View(func(tx *bolt.Tx) error {
+		vbkt := tx.Bucket(bucketKeyVersion)
+		if vbkt == nil {
+			return fmt.Errorf("bucket %s does not exist", bucketKeyVersion)
+		}
+
+		// meta.db/v1/<namespace>/images/<name> and
+		// meta.db/v1/<namespace>/leases/<lease id>
+		return vbkt.ForEach(func(ns, v []byte) error {
+			nsbkt := vbkt.Bucket(ns)
+			if nsbkt == nil {
+				return nil
+			}
+			if ibkt := nsbkt.Bucket([]byte("images")); ibkt != nil {
+				ibkt.ForEach(func(k, v []byte) error {
+					bkt := ibkt.Bucket(k)
+					if bkt == nil || bkt.Bucket([]byte("target")) == nil {
+						return nil
+					}
+					r := imageRecord{
+						namespace: string(ns),
+						name:      string(k),
+						digest:    string(bkt.Bucket([]byte("target")).Get([]byte("digest"))),
+						source:    "live",
+					}
+					boltutil.ReadTimestamps(bkt, &r.createdAt, &r.updatedAt)
+					images = append(images, r)
+					return nil
+				})
+			}
+			if lbkt := getLeasesBucket(tx, string(ns)); lbkt != nil {
+				lbkt.ForEach(func(k, v []byte) error {
+					bkt := lbkt.Bucket(k)
+					if bkt == nil {
+						return nil
+					}
+					lease := explorers.Lease{ID: string(k)}
+					readLease(&lease, bkt)
+
+					r := leaseRecord{id: lease.ID, createdAt: lease.CreatedAt, source: "live"}
+					for _, res := range lease.Resources {
+						if res.Type == string(bucketKeyObjectContent) {
+							r.digests = append(r.digests, res.ID)
+						}
+					}
+					leases = append(leases, r)
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].namespace < images[j].namespace
+	})
+	return images, leases, nil
+}
+
+// carveTags returns the image records and leases in the leaf pages of a
+// bolt database.
+//
+// Only the free pages and the unallocated pages after the high water mark
+// are carved if freeOnly is true. Otherwise all the pages are carved i.e. in
+// a leftover copy.
+func carveTags(path string, freeOnly bool) ([]imageRecord, []leaseRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// meta page: page header, magic, version, and page size
+	meta := make([]byte, boltPageHeaderSize+12)
+	if _, err := f.ReadAt(meta, 0); err != nil {
+		return nil, nil, err
+	}
+	if binary.LittleEndian.Uint32(meta[16:]) != boltMagic {
+		return nil, nil, fmt.Errorf("%s is not a bolt database", path)
+	}
+	pagesize := int64(binary.LittleEndian.Uint32(meta[24:]))
+	if pagesize < 512 || pagesize > 1<<20 {
+		return nil, nil, fmt.Errorf("invalid page size %d", pagesize)
+	}
+
+	var (
+		free map[uint64]bool
+		hwm  uint64
+	)
+	if freeOnly {
+		free, hwm = boltFreePages(f, pagesize)
+	}
+
+	// A bucket with nested buckets is never stored inline. The image and
+	// lease buckets are stored in their own pages and the names are only
+	// stored in the parent pages referencing the root pages. The references
+	// are collected from all the pages.
+	pages := make(map[uint64]*carvedBucket)
+	refs := make(map[uint64][]string)
+	for id := uint64(2); int64(id+1)*pagesize <= info.Size(); id++ {
+		// The carved values refer to the page data.
+		page := make([]byte, pagesize)
+		if _, err := f.ReadAt(page, int64(id)*pagesize); err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if binary.LittleEndian.Uint16(page[8:]) != boltLeafPageFlag {
+			continue
+		}
+
+		data := page
+		if overflow := int64(binary.LittleEndian.Uint32(page[12:])); overflow > 0 && int64(id)+overflow < info.Size()/pagesize {
+			data = make([]byte, (overflow+1)*pagesize)
+			if _, err := f.ReadAt(data, int64(id)*pagesize); err != nil && err != io.EOF {
+				return nil, nil, err
+			}
+		}
+
+		b := parseLeafPage(data)
+		if b == nil {
+			continue
+		}
+		pages[id] = b
+		for name, root := range b.roots {
+			refs[root] = appendUnique(refs[root], name)
+		}
+	}
+
+	var (
+		images []imageRecord
+		leases []leaseRecord
+	)
+	for id, b := range pages {
+		source := fmt.Sprintf("%s page %d", path, id)
+		if freeOnly {
+			switch {
+			case free[id]:
+				source = fmt.Sprintf("%s free page %d", path, id)
+			case id >= hwm:
+				source = fmt.Sprintf("%s unallocated page %d", path, id)
+			default:
+				continue
+			}
+		}
+
+		// A reused page may be referenced using different names by the
+		// parent pages of different transactions.
+		var name string
+		if names := refs[id]; len(names) == 1 {
+			name = names[0]
+		} else if len(names) > 1 {
+			log.WithFields(log.Fields{
+				"source": source,
+				"names":  names,
+			}).Debug("skipping carved page referenced using several names")
+			continue
+		}
+
+		if r, ok := b.imageRecord(name); ok && name != "" {
+			r.source = source
+			images = append(images, r)
+		}
+		if r, ok := b.leaseRecord(name); ok && name != "" {
+			r.source = source
+			leases = append(leases, r)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"path":   path,
+		"images": len(images),
+		"leases": len(leases),
+	}).Debug("carved image records")
+	return images, leases, nil
+}
+
+// boltFreePages returns the IDs of the free pages and the high water mark of
+// the current meta page i.e. the page ID after the last allocated page.
+//
+// The free pages include the pages pending release. The free pages are
+// unknown if the freelist is not synced i.e. NoFreelistSync. A meta page is
+// only used if the checksum is valid. A nil map and a zero high water mark
+// are returned if the meta page is invalid.
+func boltFreePages(f *os.File, pagesize int64) (map[uint64]bool, uint64) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0
+	}
+
+	var freelist, hwm, txid uint64
+	for _, offset := range []int64{0, pagesize} {
+		buf := make([]byte, 80)
+		if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+			continue
+		}
+		if binary.LittleEndian.Uint32(buf[16:]) != boltMagic {
+			continue
+		}
+		// The checksum is the FNV-1a hash of the meta before the checksum.
+		h := fnv.New64a()
+		h.Write(buf[16:72])
+		if h.Sum64() != binary.LittleEndian.Uint64(buf[72:]) {
+			log.WithField("offset", offset).Debug("skipping bolt meta page with an invalid checksum")
+			continue
+		}
+		if t := binary.LittleEndian.Uint64(buf[64:]); t >= txid {
+			txid = t
+			freelist = binary.LittleEndian.Uint64(buf[48:])
+			hwm = binary.LittleEndian.Uint64(buf[56:])
+		}
+	}
+
+	if hwm == 0 {
+		return nil, 0
+	}
+
+	free := make(map[uint64]bool)
+	if freelist == 0 || freelist >= hwm {
+		log.Debug("bolt freelist is not synced")
+		return free, hwm
+	}
+	if freelist >= uint64(info.Size()/pagesize) {
+		log.Debug("bolt freelist page is after the end of the file")
+		return free, hwm
+	}
+
+	header := make([]byte, boltPageHeaderSize+8)
+	if _, err := f.ReadAt(header, int64(freelist)*pagesize); err != nil {
+		return free, hwm
+	}
+
+	// A count of 0xFFFF is stored in the first page ID.
+	count := int64(binary.LittleEndian.Uint16(header[10:]))
+	start := int64(boltPageHeaderSize)
+	if count == 0xffff {
+		count = int64(binary.LittleEndian.Uint64(header[16:]))
+		start += 8
+	}
+	// The page IDs are stored in the freelist page and the overflow pages
+	// after it.
+	if count <= 0 || count > int64(hwm) || count > (info.Size()-int64(freelist)*pagesize-start)/8 {
+		return free, hwm
+	}
+
+	ids := make([]byte, count*8)
+	if _, err := f.ReadAt(ids, int64(freelist)*pagesize+start); err != nil && err != io.EOF {
+		return free, hwm
+	}
+	for i := int64(0); i < count; i++ {
+		free[binary.LittleEndian.Uint64(ids[i*8:])] = true
+	}
+	return free, hwm
+}
+
+// parseLeafPage returns the keys, values, and inline buckets of a leaf page.
+//
+// A small nested bucket is stored inline in the value of the parent bucket
+// i.e. the bucket header with root page 0 followed by a leaf page. Nil is
+// returned if the page is not a valid leaf page.
+func parseLeafPage(data []byte) *carvedBucket {
+	if len(data) < boltPageHeaderSize || binary.LittleEndian.Uint16(data[8:])&boltLeafPageFlag == 0 {
+		return nil
+	}
+	count := int(binary.LittleEndian.Uint16(data[10:]))
+	if boltPageHeaderSize+count*boltLeafElementSize > len(data) {
+		return nil
+	}
+
+	b := &carvedBucket{
+		values:  make(map[string][]byte),
+		buckets: make(map[string]*carvedBucket),
+		roots:   make(map[string]uint64),
+	}
+	for i := 0; i < count; i++ {
+		elem := boltPageHeaderSize + i*boltLeafElementSize
+		flags := binary.LittleEndian.Uint32(data[elem:])
+		pos := int(binary.LittleEndian.Uint32(data[elem+4:]))
+		ksize := int(binary.LittleEndian.Uint32(data[elem+8:]))
+		vsize := int(binary.LittleEndian.Uint32(data[elem+12:]))
+
+		start := elem + pos
+		if pos < 0 || ksize < 0 || vsize < 0 || start+ksize+vsize > len(data) || start+ksize+vsize < start {
+			return nil
+		}
+		key := string(data[start : start+ksize])
+		value := data[start+ksize : start+ksize+vsize]
+
+		if flags&boltBucketLeafFlag == 0 {
+			b.values[key] = value
+			continue
+		}
+		if len(value) < boltBucketSize {
+			continue
+		}
+		if root := binary.LittleEndian.Uint64(value); root != 0 {
+			b.roots[key] = root
+		} else if nested := parseLeafPage(value[boltBucketSize:]); nested != nil {
+			b.buckets[key] = nested
+		}
+	}
+	return b
+}
+
+// imageRecord returns the image record if the bucket is an image bucket i.e.
+// images/<name> with the inline target bucket.
+func (b *carvedBucket) imageRecord(name string) (imageRecord, bool) {
+	target := b.buckets["target"]
+	if target == nil {
+		return imageRecord{}, false
+	}
+	dgst := string(target.values["digest"])
+	if !strings.Contains(dgst, ":") {
+		return imageRecord{}, false
+	}
+
+	r := imageRecord{
+		name:   name,
+		digest: dgst,
+	}
+	r.createdAt = carvedTime(b.values["createdat"])
+	r.updatedAt = carvedTime(b.values["updatedat"])
+	return r, true
+}
+
+// leaseRecord returns the lease record if the bucket is a lease bucket i.e.
+// leases/<lease id> with the inline content bucket.
+func (b *carvedBucket) leaseRecord(name string) (leaseRecord, bool) {
+	content := b.buckets[string(bucketKeyObjectContent)]
+	if content == nil || b.values["createdat"] == nil {
+		return leaseRecord{}, false
+	}
+
+	r := leaseRecord{
+		id:        name,
+		createdAt: carvedTime(b.values["createdat"]),
+	}
+	for dgst := range content.values {
+		if strings.Contains(dgst, ":") {
+			r.digests = append(r.digests, dgst)
+		}
+	}
+	sort.Strings(r.digests)
+	return r, len(r.digests) > 0
+}
+
+// carvedTime returns the time of a binary timestamp or the zero time.
+func carvedTime(value []byte) time.Time {
+	var t time.Time
+	if err := t.UnmarshalBinary(value); err != nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+// appendUnique appends the value if the slice does not contain it.
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
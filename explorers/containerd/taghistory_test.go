@@ -0,0 +1,153 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// writeFreelistDB writes a bolt database with free pages and returns the
+// path and the page size.
+func writeFreelistDB(t *testing.T) (string, int64) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "meta.db")
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 1024)
+	for i := 0; i < 2; i++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("images"))
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 32; j++ {
+				if err := b.Put([]byte(fmt.Sprintf("image-%d-%d", i, j)), value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pagesize := int64(db.Info().PageSize)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, pagesize
+}
+
+// boltMeta returns the offset of the current meta page.
+func boltMeta(data []byte, pagesize int64) int64 {
+	current := int64(0)
+	if binary.LittleEndian.Uint64(data[pagesize+64:]) > binary.LittleEndian.Uint64(data[64:]) {
+		current = pagesize
+	}
+	return current
+}
+
+// setBoltChecksum updates the checksum of the meta page at the offset.
+func setBoltChecksum(data []byte, offset int64) {
+	h := fnv.New64a()
+	h.Write(data[offset+16 : offset+72])
+	binary.LittleEndian.PutUint64(data[offset+72:], h.Sum64())
+}
+
+// freePages opens the database and returns the free pages and the high
+// water mark.
+func freePages(t *testing.T, path string, pagesize int64) (map[uint64]bool, uint64) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return boltFreePages(f, pagesize)
+}
+
+func TestBoltFreePages(t *testing.T) {
+	path, pagesize := writeFreelistDB(t)
+	free, hwm := freePages(t, path, pagesize)
+	if hwm == 0 || len(free) == 0 {
+		t.Fatalf("boltFreePages() = %d free pages and high water mark %d, want free pages", len(free), hwm)
+	}
+	for id := range free {
+		if id < 2 || id >= hwm {
+			t.Errorf("free page %d is not between the meta pages and the high water mark %d", id, hwm)
+		}
+	}
+}
+
+// TestBoltFreePagesInvalidChecksum checks that the meta pages with an
+// invalid checksum are not used.
+func TestBoltFreePagesInvalidChecksum(t *testing.T) {
+	path, pagesize := writeFreelistDB(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []int64{0, pagesize} {
+		binary.LittleEndian.PutUint64(data[offset+56:], 1<<40)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if free, hwm := freePages(t, path, pagesize); free != nil || hwm != 0 {
+		t.Errorf("boltFreePages() = %d free pages and high water mark %d, want none", len(free), hwm)
+	}
+}
+
+// TestBoltFreePagesCorruptCount checks that a freelist count larger than
+// the file is not read.
+func TestBoltFreePagesCorruptCount(t *testing.T) {
+	path, pagesize := writeFreelistDB(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A large high water mark with a valid checksum and a count stored in
+	// the first page ID of the freelist page.
+	meta := boltMeta(data, pagesize)
+	binary.LittleEndian.PutUint64(data[meta+56:], 1<<40)
+	setBoltChecksum(data, meta)
+	freelist := int64(binary.LittleEndian.Uint64(data[meta+48:])) * pagesize
+	binary.LittleEndian.PutUint16(data[freelist+10:], 0xffff)
+	binary.LittleEndian.PutUint64(data[freelist+16:], 1<<24)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	free, hwm := freePages(t, path, pagesize)
+	if hwm != 1<<40 {
+		t.Fatalf("high water mark = %d, want %d", hwm, 1<<40)
+	}
+	if len(free) != 0 {
+		t.Errorf("boltFreePages() = %d free pages, want none", len(free))
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"github.com/google/container-explorer/explorers"
+)
+
+// Windows container types reported in Task.ContainerType.
+const (
+	containerTypeWCOW       = "wcow"
+	containerTypeWCOWHyperV = "wcow-hyperv"
+)
+
+// windowsTask returns the task of a Windows container.
+//
+// A Windows node does not have cgroups or the runc state directory. The
+// task status is unknown and the container type records the isolation
+// mode.
+func windowsTask(ctr explorers.Container, wc *explorers.WindowsContainer) explorers.Task {
+	containertype := containerTypeWCOW
+	if wc.Isolation == explorers.WindowsIsolationHyperV {
+		containertype = containerTypeWCOWHyperV
+	}
+	return explorers.Task{
+		Namespace:     ctr.Namespace,
+		Name:          ctr.ID,
+		ContainerType: containertype,
+		Status:        "UNKNOWN",
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crio
+
+import "time"
+
+// Container represents a container record in containers.json.
+//
+// Reference to containers storage source code
+// https://github.com/containers/storage/blob/main/containers.go
+type Container struct {
+	ID       string            `json:"id"`
+	Names    []string          `json:"names,omitempty"`
+	ImageID  string            `json:"image"`
+	LayerID  string            `json:"layer"`
+	Metadata string            `json:"metadata,omitempty"`
+	Created  time.Time         `json:"created"`
+	Flags    map[string]string `json:"flags,omitempty"`
+}
+
+// Image represents an image record in images.json.
+//
+// Reference to containers storage source code
+// https://github.com/containers/storage/blob/main/images.go
+type Image struct {
+	ID       string    `json:"id"`
+	Digest   string    `json:"digest,omitempty"`
+	Names    []string  `json:"names,omitempty"`
+	TopLayer string    `json:"layer,omitempty"`
+	Metadata string    `json:"metadata,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// Layer represents a layer record in layers.json.
+//
+// Reference to containers storage source code
+// https://github.com/containers/storage/blob/main/layers.go
+type Layer struct {
+	ID                 string    `json:"id"`
+	Names              []string  `json:"names,omitempty"`
+	Parent             string    `json:"parent,omitempty"`
+	Created            time.Time `json:"created"`
+	CompressedDigest   string    `json:"compressed-diff-digest,omitempty"`
+	CompressedSize     int64     `json:"compressed-size,omitempty"`
+	UncompressedDigest string    `json:"diff-digest,omitempty"`
+	UncompressedSize   int64     `json:"diff-size,omitempty"`
+}
+
+// ContainerMetadata represents the container metadata stored by CRI-O.
+type ContainerMetadata struct {
+	PodName       string `json:"pod-name"`
+	PodID         string `json:"pod-id"`
+	ImageName     string `json:"image-name"`
+	ImageID       string `json:"image-id"`
+	ContainerName string `json:"name"`
+	MountLabel    string `json:"mountlabel,omitempty"`
+}
+
+// ContainerState represents the container state saved by CRI-O in
+// userdata/state.json.
+//
+// Reference to CRI-O source code
+// https://github.com/cri-o/cri-o/blob/main/internal/oci/container.go
+type ContainerState struct {
+	Status   string    `json:"status"`
+	Pid      int       `json:"pid,omitempty"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	ExitCode *int32    `json:"exitCode,omitempty"`
+}
@@ -0,0 +1,679 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crio
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/storage"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	spec "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	containersFilename     = "containers.json"
+	imagesFilename         = "images.json"
+	layersFilename         = "layers.json"
+	volatileLayersFilename = "volatile-layers.json"
+	userdataDirName        = "userdata"
+	specFilename           = "config.json"
+	stateFilename          = "state.json"
+	diffDirName            = "diff"
+	storageDriverOverlay   = "overlay"
+	storageDriverVFS       = "vfs"
+)
+
+// CRI-O annotations in the container spec.
+const (
+	annotationContainerType = "io.kubernetes.cri-o.ContainerType"
+	annotationLabels        = "io.kubernetes.cri-o.Labels"
+	annotationImageName     = "io.kubernetes.cri-o.ImageName"
+	annotationLogPath       = "io.kubernetes.cri-o.LogPath"
+)
+
+type explorer struct {
+	imageroot string                      // mounted image path
+	root      string                      // containers storage root i.e. /var/lib/containers/storage
+	driver    string                      // storage driver i.e. overlay
+	sc        *explorers.SupportContainer // support container structure object
+}
+
+// NewExplorer returns a ContainerExplorer interface to explore CRI-O managed
+// containers stored in containers storage.
+func NewExplorer(imageroot string, root string, sc *explorers.SupportContainer) (explorers.ContainerExplorer, error) {
+	driver := storageDriver(root)
+	containersfile := filepath.Join(root, driver+"-containers", containersFilename)
+	if !explorers.PathExists(containersfile, true) {
+		return &explorer{}, fmt.Errorf("containers storage file %s does not exist", containersfile)
+	}
+
+	log.WithFields(log.Fields{
+		"imageroot": imageroot,
+		"root":      root,
+		"driver":    driver,
+	}).Debug("new CRI-O explorer")
+
+	return &explorer{
+		imageroot: imageroot,
+		root:      root,
+		driver:    driver,
+		sc:        sc,
+	}, nil
+}
+
+// storageDriver returns the graph driver of containers storage.
+//
+// The driver is detected from the <driver>-containers directory. The default
+// is overlay.
+func storageDriver(root string) string {
+	for _, driver := range []string{storageDriverOverlay, storageDriverVFS} {
+		if explorers.PathExists(filepath.Join(root, driver+"-containers", containersFilename), true) {
+			return driver
+		}
+	}
+	return storageDriverOverlay
+}
+
+// SnapshotRoot returns the storage driver directory containing the layers
+// i.e. /var/lib/containers/storage/overlay.
+func (e *explorer) SnapshotRoot(snapshotter string) string {
+	return filepath.Join(e.root, e.driver)
+}
+
+// ListNamespaces returns namespaces.
+//
+// CRI-O does not use namespaces.
+func (e *explorer) ListNamespaces(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+// ListContainers returns the information about containers.
+//
+// The containers are read from <driver>-containers/containers.json and the
+// container spec userdata/config.json written by CRI-O.
+func (e *explorer) ListContainers(ctx context.Context) ([]explorers.Container, error) {
+	records, err := e.readContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	imagenames := make(map[string]string)
+	if imgs, err := e.readImages(); err == nil {
+		for _, img := range imgs {
+			if len(img.Names) > 0 {
+				imagenames[img.ID] = img.Names[0]
+			}
+		}
+	}
+
+	var cecontainers []explorers.Container
+	for _, record := range records {
+		cectr := e.convertToContainerExplorerContainer(record, imagenames)
+		cecontainers = append(cecontainers, cectr)
+	}
+	return cecontainers, nil
+}
+
+// ListImages returns the information about images.
+//
+// An image with multiple names is listed once for each name.
+func (e *explorer) ListImages(ctx context.Context) ([]explorers.Image, error) {
+	records, err := e.readImages()
+	if err != nil {
+		return nil, err
+	}
+
+	var ceimages []explorers.Image
+	for _, record := range records {
+		names := record.Names
+		if len(names) == 0 {
+			names = []string{record.ID}
+		}
+
+		for _, name := range names {
+			ceimages = append(ceimages, explorers.Image{
+				Image: images.Image{
+					Name: name,
+					Target: ocispec.Descriptor{
+						Digest: digest.Digest(record.Digest),
+					},
+					CreatedAt: record.Created,
+				},
+				SupportContainerImage: e.sc.SupportContainerImage(imageBasename(name)),
+			})
+		}
+	}
+	return ceimages, nil
+}
+
+// ListContent returns information about content.
+//
+// Containers storage does not keep a content store.
+func (e *explorer) ListContent(ctx context.Context) ([]explorers.Content, error) {
+	log.Info("listing content is not supported for CRI-O")
+	return nil, nil
+}
+
+// ListSnapshots returns the layers as snapshots.
+//
+// Container layers are active snapshots and image layers are committed
+// snapshots.
+func (e *explorer) ListSnapshots(ctx context.Context) ([]explorers.SnapshotKeyInfo, error) {
+	layers, err := e.readLayers()
+	if err != nil {
+		return nil, err
+	}
+
+	containerlayers := make(map[string]bool)
+	if records, err := e.readContainers(); err == nil {
+		for _, record := range records {
+			containerlayers[record.LayerID] = true
+		}
+	}
+
+	var ss []explorers.SnapshotKeyInfo
+	for _, layer := range layers {
+		kind := snapshots.KindCommitted
+		if containerlayers[layer.ID] {
+			kind = snapshots.KindActive
+		}
+
+		overlaypath := ""
+		if driver, err := storage.Get(e.driver); err == nil {
+			overlaypath, _ = driver.LayerDir("", layer.ID, kind == snapshots.KindActive)
+		}
+
+		ss = append(ss, explorers.SnapshotKeyInfo{
+			Snapshotter: e.driver,
+			Key:         layer.ID,
+			Name:        strings.Join(layer.Names, ","),
+			Parent:      layer.Parent,
+			Kind:        kind,
+			Size:        uint64(layer.UncompressedSize),
+			OverlayPath: overlaypath,
+			CreatedAt:   layer.Created,
+			UpdatedAt:   layer.Created,
+		})
+	}
+	return ss, nil
+}
+
+// ListTasks returns the container task status.
+func (e *explorer) ListTasks(ctx context.Context) ([]explorers.Task, error) {
+	ctrs, err := e.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []explorers.Task
+	for _, ctr := range ctrs {
+		tasks = append(tasks, explorers.Task{
+			Namespace:     ctr.Namespace,
+			Name:          ctr.ID,
+			PID:           ctr.ProcessID,
+			ContainerType: ctr.ContainerType,
+			Status:        ctr.Status,
+		})
+	}
+	return tasks, nil
+}
+
+// InspectImage returns the configuration and layers of an image.
+//
+// The image ID is the digest of the image configuration. The configuration
+// is stored as big data of the image record and the layers are resolved by
+// following the parent of the image top layer in layers.json.
+func (e *explorer) InspectImage(ctx context.Context, name string) (explorers.ImageDetail, error) {
+	records, err := e.readImages()
+	if err != nil {
+		return explorers.ImageDetail{}, err
+	}
+
+	var record *Image
+	for i := range records {
+		for _, n := range append(records[i].Names, records[i].ID) {
+			if explorers.MatchImageName(n, name) {
+				record = &records[i]
+				name = n
+				break
+			}
+		}
+		if record != nil {
+			break
+		}
+	}
+	if record == nil {
+		return explorers.ImageDetail{}, fmt.Errorf("image %s not found", name)
+	}
+
+	// The big data file name is the base64 encoded key i.e. sha256:<id>
+	configkey := digest.NewDigestFromEncoded(digest.SHA256, record.ID).String()
+	detail := explorers.ImageDetail{
+		Name:       name,
+		Target:     record.Digest,
+		Manifest:   record.Digest,
+		Config:     configkey,
+		ConfigPath: filepath.Join(e.root, e.driver+"-images", record.ID, "="+base64.StdEncoding.EncodeToString([]byte(configkey))),
+	}
+
+	layers, err := e.readLayers()
+	if err != nil {
+		return detail, err
+	}
+	layermap := make(map[string]Layer)
+	for _, layer := range layers {
+		layermap[layer.ID] = layer
+	}
+
+	driver, err := storage.Get(e.driver)
+	if err != nil {
+		return detail, err
+	}
+
+	// The layers are ordered from the base layer to the top layer.
+	seen := make(map[string]bool)
+	for id := record.TopLayer; id != ""; id = layermap[id].Parent {
+		if seen[id] {
+			return detail, fmt.Errorf("layer %s has a circular parent", id)
+		}
+		seen[id] = true
+
+		layer := layermap[id]
+		dir, _ := driver.LayerDir(filepath.Join(e.root, e.driver), id, false)
+		detail.Layers = append([]explorers.ImageLayer{{
+			Digest: layer.CompressedDigest,
+			DiffID: layer.UncompressedDigest,
+			Dir:    dir,
+		}}, detail.Layers...)
+	}
+	return detail, nil
+}
+
+// ListLeases returns the leases.
+//
+// Containers storage does not keep leases.
+func (e *explorer) ListLeases(ctx context.Context) ([]explorers.Lease, error) {
+	log.Info("listing leases is not supported for CRI-O")
+	return nil, nil
+}
+
+// InfoContainer returns container internal information.
+func (e *explorer) InfoContainer(ctx context.Context, containerid string, spec bool) (interface{}, error) {
+	ctrspec, err := e.readSpec(containerid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only return spec
+	if spec {
+		return ctrspec, nil
+	}
+
+	record, err := e.getContainer(containerid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return container and spec info
+	return struct {
+		Container
+		Spec interface{} `json:"Spec,omitempty"`
+	}{
+		Container: record,
+		Spec:      ctrspec,
+	}, nil
+}
+
+// ContainerLayers returns the container's upper layer directory and the
+// lower layer directories ordered from top to bottom.
+//
+// The lower layers are resolved by following the parent of the container
+// layer in layers.json.
+func (e *explorer) ContainerLayers(ctx context.Context, containerid string) (string, []string, error) {
+	record, err := e.getContainer(containerid)
+	if err != nil {
+		return "", nil, err
+	}
+
+	layers, err := e.readLayers()
+	if err != nil {
+		return "", nil, err
+	}
+	parents := make(map[string]string)
+	for _, layer := range layers {
+		parents[layer.ID] = layer.Parent
+	}
+
+	driver, err := storage.Get(e.driver)
+	if err != nil {
+		return "", nil, err
+	}
+	driverdir := filepath.Join(e.root, e.driver)
+	upperdir, err := driver.LayerDir(driverdir, record.LayerID, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The upper directory of a full copy graph driver i.e. vfs contains
+	// all the container files.
+	if storage.IsFullCopy(e.driver) {
+		return upperdir, nil, nil
+	}
+
+	var lowerdirs []string
+	seen := make(map[string]bool)
+	for id := parents[record.LayerID]; id != ""; id = parents[id] {
+		if seen[id] {
+			return "", nil, fmt.Errorf("layer %s has a circular parent", id)
+		}
+		seen[id] = true
+		lowerdir, err := driver.LayerDir(driverdir, id, false)
+		if err != nil {
+			return "", nil, err
+		}
+		lowerdirs = append(lowerdirs, lowerdir)
+	}
+
+	log.WithFields(log.Fields{
+		"lowerdir": lowerdirs,
+		"upperdir": upperdir,
+	}).Debug("container overlay directories")
+
+	return upperdir, lowerdirs, nil
+}
+
+// MountContainer mounts a container to the specified path.
+func (e *explorer) MountContainer(ctx context.Context, containerid string, mountpoint string) error {
+	upperdir, lowerdirs, err := e.ContainerLayers(ctx, containerid)
+	if err != nil {
+		return err
+	}
+
+	return explorers.MountOverlay(append([]string{upperdir}, lowerdirs...), mountpoint)
+}
+
+// MountAllContainers mounts all containers to the specified path.
+func (e *explorer) MountAllContainers(ctx context.Context, mountpoint string, opts explorers.MountAllOptions) ([]explorers.MountIndexEntry, error) {
+	ctrs, err := e.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := explorers.NewMountBatch(mountpoint, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ctr := range ctrs {
+		if opts.SkipSupportContainers && ctr.SupportContainer {
+			log.WithField("containerid", ctr.ID).Info("skip mounting Kubernetes support container")
+			batch.Skip(ctr, "Kubernetes support container")
+			continue
+		}
+
+		// pod sandbox (pause) containers do not have a useful filesystem
+		if ctr.ContainerType == "sandbox" {
+			log.WithField("containerid", ctr.ID).Debug("skip mounting pod sandbox container")
+			batch.Skip(ctr, "pod sandbox container")
+			continue
+		}
+
+		batch.Mount(ctr, func(ctrmountpoint string) error {
+			return e.MountContainer(ctx, ctr.ID, ctrmountpoint)
+		})
+	}
+
+	return batch.Close()
+}
+
+// Close releases the internal resources.
+func (e *explorer) Close() error {
+	return nil
+}
+
+// convertToContainerExplorerContainer returns a Container object from the
+// containers.json record and the container spec.
+func (e *explorer) convertToContainerExplorerContainer(record Container, imagenames map[string]string) explorers.Container {
+	var metadata ContainerMetadata
+	if record.Metadata != "" {
+		if err := json.Unmarshal([]byte(record.Metadata), &metadata); err != nil {
+			log.WithField("containerid", record.ID).Debug("unmarshalling container metadata: ", err)
+		}
+	}
+
+	image := metadata.ImageName
+	if image == "" {
+		image = imagenames[record.ImageID]
+	}
+
+	cectr := explorers.Container{
+		Hostname:      metadata.PodName,
+		ContainerType: "container",
+		Status:        "UNKNOWN",
+		Container: containers.Container{
+			ID:          record.ID,
+			CreatedAt:   record.Created,
+			UpdatedAt:   record.Created,
+			Image:       image,
+			Labels:      make(map[string]string),
+			Snapshotter: e.driver,
+			SnapshotKey: record.LayerID,
+			Runtime: containers.RuntimeInfo{
+				Name: "crio",
+			},
+		},
+	}
+
+	ctrspec, err := e.readSpec(record.ID)
+	if err != nil {
+		log.WithField("containerid", record.ID).Debug("reading container spec: ", err)
+	} else {
+		if ctrspec.Hostname != "" {
+			cectr.Hostname = ctrspec.Hostname
+		}
+		if v := ctrspec.Annotations[annotationContainerType]; v != "" {
+			cectr.ContainerType = v
+		}
+		if v := ctrspec.Annotations[annotationImageName]; v != "" && cectr.Image == "" {
+			cectr.Image = v
+		}
+		if v := ctrspec.Annotations[annotationLabels]; v != "" {
+			if err := json.Unmarshal([]byte(v), &cectr.Labels); err != nil {
+				log.WithField("containerid", record.ID).Debug("unmarshalling container labels: ", err)
+			}
+		}
+		for _, k := range []string{explorers.LabelPodUID, explorers.LabelPodName, explorers.LabelPodNamespace, explorers.LabelContainerName, explorers.LabelContainerRestartCount} {
+			if v, found := ctrspec.Annotations[k]; found && cectr.Labels[k] == "" {
+				cectr.Labels[k] = v
+			}
+		}
+		if v := ctrspec.Annotations[annotationLogPath]; v != "" && e.imageroot != "" {
+			cectr.LogPath = filepath.Join(e.imageroot, v)
+		}
+		cectr.VolatileMounts = explorers.VolatileMounts(e.imageroot, ctrspec.Mounts)
+
+		if ctrspec.Linux != nil {
+			cectr.Status, cectr.ProcessID = e.taskStatus(ctrspec.Linux.CgroupsPath)
+		}
+	}
+
+	var state ContainerState
+	statefile := filepath.Join(e.root, e.driver+"-containers", record.ID, userdataDirName, stateFilename)
+	if err := readJSONFile(statefile, &state); err == nil {
+		cectr.StartedAt = state.Started
+		cectr.FinishedAt = state.Finished
+		if state.ExitCode != nil {
+			cectr.ExitCode = int(*state.ExitCode)
+		}
+	}
+
+	cectr.ImageBase = imageBasename(cectr.Image)
+	cectr.SupportContainer = e.sc.IsSupportContainer(cectr)
+	return cectr
+}
+
+// taskStatus returns the container status and process ID using the
+// container cgroup.
+//
+// CRI-O uses the systemd cgroup manager by default. The cgroupsPath
+// <slice>:crio:<container id> maps to the scope crio-<container id>.scope
+// within the nested kubepods slices.
+func (e *explorer) taskStatus(cgroupspath string) (string, int) {
+	if e.imageroot == "" || cgroupspath == "" {
+		return "UNKNOWN", 0
+	}
+
+	cgroupdir := filepath.Join(e.imageroot, "sys", "fs", "cgroup")
+	if m := strings.Split(cgroupspath, ":"); len(m) == 3 {
+		scope := fmt.Sprintf("%s-%s.scope", m[1], m[2])
+
+		cgroupspath = ""
+		pattern := cgroupdir
+		for depth := 0; depth < 5 && cgroupspath == ""; depth++ {
+			pattern = filepath.Join(pattern, "*")
+			if matches, _ := filepath.Glob(filepath.Join(pattern, scope)); len(matches) > 0 {
+				cgroupspath = matches[0]
+			}
+		}
+		if cgroupspath == "" {
+			return "UNKNOWN", 0
+		}
+	} else {
+		cgroupspath = filepath.Join(cgroupdir, cgroupspath)
+	}
+
+	if !explorers.PathExists(cgroupspath, false) {
+		return "UNKNOWN", 0
+	}
+
+	status, err := explorers.GetTaskStatus(cgroupspath)
+	if err != nil {
+		log.WithField("cgroupspath", cgroupspath).Debug("getting task status: ", err)
+	}
+	return status, explorers.GetTaskPID(cgroupspath)
+}
+
+// readContainers returns the records in containers.json.
+func (e *explorer) readContainers() ([]Container, error) {
+	var records []Container
+	path := filepath.Join(e.root, e.driver+"-containers", containersFilename)
+	if err := readJSONFile(path, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// getContainer returns the containers.json record of a container.
+func (e *explorer) getContainer(containerid string) (Container, error) {
+	records, err := e.readContainers()
+	if err != nil {
+		return Container{}, err
+	}
+	for _, record := range records {
+		if record.ID == containerid {
+			return record, nil
+		}
+	}
+	return Container{}, fmt.Errorf("container %s does not exist", containerid)
+}
+
+// readImages returns the records in images.json.
+func (e *explorer) readImages() ([]Image, error) {
+	var records []Image
+	path := filepath.Join(e.root, e.driver+"-images", imagesFilename)
+	if err := readJSONFile(path, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// readLayers returns the records in layers.json and volatile-layers.json.
+//
+// Newer versions of containers storage store the container layers in
+// volatile-layers.json.
+func (e *explorer) readLayers() ([]Layer, error) {
+	layersdir := filepath.Join(e.root, e.driver+"-layers")
+
+	var records []Layer
+	if err := readJSONFile(filepath.Join(layersdir, layersFilename), &records); err != nil {
+		return nil, err
+	}
+
+	volatilefile := filepath.Join(layersdir, volatileLayersFilename)
+	if explorers.PathExists(volatilefile, true) {
+		var volatile []Layer
+		if err := readJSONFile(volatilefile, &volatile); err != nil {
+			log.WithField("path", volatilefile).Warn("reading volatile layers: ", err)
+		}
+		records = append(records, volatile...)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+	return records, nil
+}
+
+// readSpec returns the container spec written by CRI-O.
+func (e *explorer) readSpec(containerid string) (spec.Spec, error) {
+	var ctrspec spec.Spec
+	path := filepath.Join(e.root, e.driver+"-containers", containerid, userdataDirName, specFilename)
+	if err := readJSONFile(path, &ctrspec); err != nil {
+		return spec.Spec{}, err
+	}
+	return ctrspec, nil
+}
+
+// readJSONFile unmarshals the JSON file at path into v.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unmarshalling %s: %w", path, err)
+	}
+	return nil
+}
+
+// imageBasename returns the image base name without version information to
+// match with supportcontainer.yaml configuration.
+func imageBasename(image string) string {
+	imagebase := image
+
+	if strings.Contains(imagebase, "@") {
+		imagebase = strings.Split(imagebase, "@")[0]
+	}
+
+	if strings.Contains(imagebase, ":") {
+		imagebase = strings.Split(imagebase, ":")[0]
+	}
+	return imagebase
+}
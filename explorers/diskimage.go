@@ -0,0 +1,415 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Filesystems detected in a partition.
+const (
+	FilesystemExt4  = "ext4"
+	FilesystemXFS   = "xfs"
+	FilesystemBtrfs = "btrfs"
+	FilesystemNTFS  = "ntfs"
+	FilesystemLVM   = "LVM2_member"
+	FilesystemLUKS  = "crypto_LUKS"
+)
+
+const (
+	sectorSize = 512
+
+	// extractBlockSize is the read size used to extract a partition.
+	extractBlockSize = 1024 * 1024
+
+	// gptProtectiveType is the MBR partition type of a GPT disk.
+	gptProtectiveType = 0xee
+)
+
+// mbrExtendedTypes are the MBR partition types of an extended partition.
+var mbrExtendedTypes = map[byte]bool{
+	0x05: true,
+	0x0f: true,
+	0x85: true,
+}
+
+// Partition describes a partition of a raw disk image.
+//
+// The partition of a disk image without a partition table starts at offset
+// 0 and spans the image. An LVM logical volume is also a partition.
+type Partition struct {
+	Index      int    `json:"index"`
+	Offset     int64  `json:"offset"` // bytes
+	Size       int64  `json:"size"`   // bytes
+	Type       string `json:"type"`   // GPT type GUID, MBR type i.e. 0x83, or LVM
+	Name       string `json:"name,omitempty"`
+	Filesystem string `json:"filesystem,omitempty"`
+
+	// Segments are the media data ranges of a partition that is not
+	// contiguous i.e. a logical volume extended after it was created.
+	Segments []PartitionSegment `json:"segments,omitempty"`
+
+	// parent is the partition holding the partition data i.e. the
+	// encrypted partition of an unlocked LUKS volume. The offsets are
+	// relative to the parent data.
+	parent *Partition
+
+	// luks decrypts the data of an unlocked LUKS volume.
+	luks *luksVolume
+}
+
+// PartitionSegment is a contiguous media data range of a partition.
+type PartitionSegment struct {
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+// Contiguous returns true if the partition data is stored contiguously and
+// unencrypted in the media data, so the partition can be mounted in place
+// using a loop device.
+func (p Partition) Contiguous() bool {
+	return len(p.Segments) == 0 && p.parent == nil
+}
+
+// Data returns the reader of the partition data in the media data read
+// using r.
+func (p Partition) Data(r io.ReaderAt) io.ReaderAt {
+	if p.parent != nil {
+		r = p.parent.Data(r)
+	}
+
+	var data io.ReaderAt
+	if len(p.Segments) == 0 {
+		data = io.NewSectionReader(r, p.Offset, p.Size)
+	} else {
+		data = segmentReader{r: r, segments: p.Segments}
+	}
+	if p.luks != nil {
+		data = p.luks.reader(data)
+	}
+	return data
+}
+
+// segmentReader reads the data of a partition made of several media data
+// ranges.
+type segmentReader struct {
+	r        io.ReaderAt
+	segments []PartitionSegment
+}
+
+// ReadAt reads the partition data at the offset.
+func (s segmentReader) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	start := int64(0)
+	for _, segment := range s.segments {
+		if n == len(p) {
+			break
+		}
+		if off >= start+segment.Size {
+			start += segment.Size
+			continue
+		}
+
+		count := start + segment.Size - off
+		if remaining := int64(len(p) - n); count > remaining {
+			count = remaining
+		}
+		read, err := s.r.ReadAt(p[n:n+int(count)], segment.Offset+off-start)
+		n += read
+		if err != nil {
+			return n, err
+		}
+		off += count
+		start += segment.Size
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Mountable returns true if the partition has a Linux filesystem that can be
+// mounted read-only.
+func (p Partition) Mountable() bool {
+	switch p.Filesystem {
+	case FilesystemExt4, FilesystemXFS, FilesystemBtrfs:
+		return true
+	}
+	return false
+}
+
+// journalOption returns the mount option that skips replaying the journal of
+// the filesystem. A dirty journal cannot be replayed on read-only evidence.
+func journalOption(fstype string) string {
+	switch fstype {
+	case FilesystemExt4:
+		return "noload"
+	case FilesystemXFS:
+		return "norecovery"
+	}
+	return ""
+}
+
+// ReadPartitions returns the partitions of a raw disk image.
+//
+// The GPT and MBR partition tables including the MBR extended partitions are
+// supported. The filesystem of each partition is detected from the
+// superblock.
+func ReadPartitions(path string) ([]Partition, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return ReadPartitionsFrom(f, info.Size())
+}
+
+// ReadPartitionsFrom returns the partitions of the disk media data read
+// using r i.e. the media data of a forensic image.
+func ReadPartitionsFrom(r io.ReaderAt, size int64) ([]Partition, error) {
+	mbr := make([]byte, sectorSize)
+	if _, err := r.ReadAt(mbr, 0); err != nil {
+		return nil, fmt.Errorf("reading master boot record: %w", err)
+	}
+
+	var (
+		partitions []Partition
+		err        error
+	)
+	switch {
+	case mbr[510] == 0x55 && mbr[511] == 0xaa && mbr[450] == gptProtectiveType:
+		partitions, err = readGPT(r)
+	case mbr[510] == 0x55 && mbr[511] == 0xaa && detectFilesystem(r, 0) == "":
+		partitions, err = readMBR(r, mbr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A filesystem image without a partition table
+	if len(partitions) == 0 {
+		partitions = []Partition{{Index: 1, Size: size}}
+	}
+	for i := range partitions {
+		partitions[i].Filesystem = detectFilesystem(r, partitions[i].Offset)
+	}
+	return partitions, nil
+}
+
+// readGPT returns the partitions of a GPT partition table.
+func readGPT(r io.ReaderAt) ([]Partition, error) {
+	header := make([]byte, 92)
+	if _, err := r.ReadAt(header, sectorSize); err != nil {
+		return nil, fmt.Errorf("reading GPT header: %w", err)
+	}
+	if string(header[:8]) != "EFI PART" {
+		return nil, fmt.Errorf("invalid GPT header signature")
+	}
+
+	entrylba := binary.LittleEndian.Uint64(header[72:])
+	count := binary.LittleEndian.Uint32(header[80:])
+	entrysize := binary.LittleEndian.Uint32(header[84:])
+	if entrysize < 128 || count > 1024 {
+		return nil, fmt.Errorf("invalid GPT partition entries %d of size %d", count, entrysize)
+	}
+
+	entries := make([]byte, int(count)*int(entrysize))
+	if _, err := r.ReadAt(entries, int64(entrylba)*sectorSize); err != nil {
+		return nil, fmt.Errorf("reading GPT partition entries: %w", err)
+	}
+
+	var partitions []Partition
+	for i := 0; i < int(count); i++ {
+		entry := entries[i*int(entrysize) : (i+1)*int(entrysize)]
+		if bytes.Equal(entry[:16], make([]byte, 16)) {
+			continue
+		}
+		first := binary.LittleEndian.Uint64(entry[32:])
+		last := binary.LittleEndian.Uint64(entry[40:])
+		partitions = append(partitions, Partition{
+			Index:  i + 1,
+			Offset: int64(first) * sectorSize,
+			Size:   int64(last-first+1) * sectorSize,
+			Type:   guidString(entry[:16]),
+			Name:   utf16String(entry[56:128]),
+		})
+	}
+	return partitions, nil
+}
+
+// readMBR returns the primary and logical partitions of an MBR partition
+// table.
+//
+// The logical partitions are chained using an extended boot record in the
+// extended partition. The logical partitions are numbered from 5.
+func readMBR(r io.ReaderAt, mbr []byte) ([]Partition, error) {
+	var partitions []Partition
+	for i := 0; i < 4; i++ {
+		entry := mbr[446+i*16 : 446+(i+1)*16]
+		ptype := entry[4]
+		start := int64(binary.LittleEndian.Uint32(entry[8:])) * sectorSize
+		size := int64(binary.LittleEndian.Uint32(entry[12:])) * sectorSize
+		if ptype == 0 || size == 0 {
+			continue
+		}
+		if mbrExtendedTypes[ptype] {
+			partitions = append(partitions, readEBR(r, start)...)
+			continue
+		}
+		partitions = append(partitions, Partition{
+			Index:  i + 1,
+			Offset: start,
+			Size:   size,
+			Type:   fmt.Sprintf("0x%02x", ptype),
+		})
+	}
+	return partitions, nil
+}
+
+// readEBR returns the logical partitions of an extended partition.
+func readEBR(r io.ReaderAt, extended int64) []Partition {
+	var partitions []Partition
+	ebr := make([]byte, sectorSize)
+	offset := extended
+	for index := 5; index < 5+128; index++ {
+		if _, err := r.ReadAt(ebr, offset); err != nil || ebr[510] != 0x55 || ebr[511] != 0xaa {
+			break
+		}
+		entry := ebr[446:462]
+		if size := int64(binary.LittleEndian.Uint32(entry[12:])) * sectorSize; entry[4] != 0 && size > 0 {
+			partitions = append(partitions, Partition{
+				Index:  index,
+				Offset: offset + int64(binary.LittleEndian.Uint32(entry[8:]))*sectorSize,
+				Size:   size,
+				Type:   fmt.Sprintf("0x%02x", entry[4]),
+			})
+		}
+
+		next := ebr[462:478]
+		if next[4] == 0 {
+			break
+		}
+		offset = extended + int64(binary.LittleEndian.Uint32(next[8:]))*sectorSize
+	}
+	return partitions
+}
+
+// detectFilesystem returns the filesystem at the offset or an empty string.
+func detectFilesystem(r io.ReaderAt, offset int64) string {
+	magic := func(off int64, value string) bool {
+		buf := make([]byte, len(value))
+		if _, err := r.ReadAt(buf, offset+off); err != nil {
+			return false
+		}
+		return string(buf) == value
+	}
+
+	switch {
+	case magic(1024+56, "\x53\xef"):
+		return FilesystemExt4
+	case magic(0, "XFSB"):
+		return FilesystemXFS
+	case magic(0x10040, "_BHRfS_M"):
+		return FilesystemBtrfs
+	case magic(3, "NTFS    "):
+		return FilesystemNTFS
+	case magic(0, "LUKS\xba\xbe"):
+		return FilesystemLUKS
+	case magic(sectorSize, "LABELONE") && magic(sectorSize+24, "LVM2"):
+		return FilesystemLVM
+	}
+	return ""
+}
+
+// guidString returns the string form of a mixed-endian GPT GUID.
+func guidString(b []byte) string {
+	return strings.ToUpper(fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10],
+		b[10:16],
+	))
+}
+
+// utf16String returns a NUL terminated UTF-16LE string.
+func utf16String(b []byte) string {
+	var u []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// ExtractPartition writes the partition data read from the media data of a
+// forensic or a virtual disk image to a sparse raw file. It is used when the
+// kernel cannot read the image format or the partition is not contiguous,
+// so the partition can be mounted using a loop device.
+//
+// The blocks containing only zero bytes are not written, so the unused
+// space of a filesystem does not use disk space.
+func ExtractPartition(r io.ReaderAt, p Partition, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	log.WithFields(log.Fields{
+		"partition": p.Index,
+		"offset":    p.Offset,
+		"size":      p.Size,
+		"path":      path,
+	}).Info("extracting partition to a raw file")
+
+	data := p.Data(r)
+	buf := make([]byte, extractBlockSize)
+	zero := make([]byte, extractBlockSize)
+	for pos := int64(0); pos < p.Size; {
+		n := int64(len(buf))
+		if p.Size-pos < n {
+			n = p.Size - pos
+		}
+		if _, err := data.ReadAt(buf[:n], pos); err != nil && err != io.EOF {
+			return fmt.Errorf("reading partition data at offset %d: %w", pos, err)
+		}
+		if !bytes.Equal(buf[:n], zero[:n]) {
+			if _, err := f.WriteAt(buf[:n], pos); err != nil {
+				return err
+			}
+		}
+		pos += n
+	}
+	return f.Truncate(p.Size)
+}
@@ -121,3 +121,12 @@ type ConfigFile struct {
 	SeccompProfile         string
 	NoNewPrivileges        bool
 }
+
+// HostConfig represents docker hostconfig.json structure
+type HostConfig struct {
+	Tmpfs       map[string]string
+	Privileged  bool
+	CapAdd      []string
+	CapDrop     []string
+	SecurityOpt []string
+}
@@ -22,8 +22,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -31,8 +31,10 @@ import (
 	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/metadata"
 	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/storage"
 	digest "github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	spec "github.com/opencontainers/runtime-spec/specs-go"
 	log "github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
 )
@@ -40,10 +42,10 @@ import (
 const (
 	configV2Filename     = "config.v2.json"
 	containersDirName    = "containers"
+	hostConfigFilename   = "hostconfig.json"
 	lowerdirName         = "lower"
 	repositoriesDirName  = "image"
 	repositoriesFileName = "repositories.json"
-	storageOverlay2      = "overlay2"
 )
 
 var imagerepo map[string]string
@@ -70,10 +72,7 @@ func NewExplorer(root string, containerdroot string, manifest string, snapshot s
 	var err error
 
 	if fileExists(containerdroot) {
-		opt := &bolt.Options{
-			ReadOnly: true,
-		}
-		db, err = bolt.Open(manifest, 0444, opt)
+		db, err = explorers.OpenBolt(manifest, 0)
 		if err != nil {
 			return &explorer{}, err
 		}
@@ -255,7 +254,7 @@ func (e *explorer) ListImages(ctx context.Context) ([]explorers.Image, error) {
 					},
 				}
 
-				if storagename == storageOverlay2 {
+				if _, err := storage.Get(storagename); err == nil {
 					imagecontent, err := readImageContent(storagename, storagedir, image.Target.Digest)
 					if err != nil {
 						log.Error("reading image content file ", err)
@@ -300,6 +299,13 @@ func (e *explorer) ListTasks(cxt context.Context) ([]explorers.Task, error) {
 	return tasks, nil
 }
 
+// ListLeases returns the leases.
+func (e *explorer) ListLeases(ctx context.Context) ([]explorers.Lease, error) {
+	fmt.Printf("INFO: listing leases not implemented\n\n")
+
+	return nil, nil
+}
+
 // InfoContainer returns container internal information.
 func (e *explorer) InfoContainer(ctx context.Context, containerid string, spec bool) (interface{}, error) {
 	// TODO(rmaskey): implement the function
@@ -308,83 +314,143 @@ func (e *explorer) InfoContainer(ctx context.Context, containerid string, spec b
 	return nil, nil
 }
 
-// MountContainer mounts a container to the specified path
-func (e *explorer) MountContainer(ctx context.Context, containerid string, mountpoint string) error {
+// ContainerLayers returns the container's upper directory and lower
+// directories ordered from top to bottom.
+func (e *explorer) ContainerLayers(ctx context.Context, containerid string) (string, []string, error) {
 	container, err := e.GetContainer(ctx, containerid)
 	if err != nil {
-		return fmt.Errorf("getting container %v", err)
+		return "", nil, fmt.Errorf("getting container %v", err)
 	}
 
-	containerMountIDPath := filepath.Join(e.root, repositoriesDirName, container.Driver, "layerdb", "mounts", containerid, "mount-id")
-	log.WithField("containerMountIDPath", containerMountIDPath).Debug("container mount-id path")
+	mountID, err := e.containerMountID(container)
+	if err != nil {
+		return "", nil, err
+	}
 
-	mountIDByte, err := ioutil.ReadFile(containerMountIDPath)
+	driver, err := storage.Get(container.Driver)
 	if err != nil {
-		return fmt.Errorf("reading container mount-id")
+		return "", nil, err
+	}
+	upperdir, err := driver.LayerDir(filepath.Join(e.root, container.Driver), mountID, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The upper directory of a full copy graph driver i.e. devicemapper
+	// contains all the container files.
+	if storage.IsFullCopy(container.Driver) {
+		log.WithField("upperdir", upperdir).Debug("container full copy directory")
+		return upperdir, nil, nil
 	}
-	mountID := string(mountIDByte)
-	log.WithField("mount-id", mountID).Debug("container mount-id")
 
 	// build container lower directory
 	lowerdirpath := filepath.Join(e.root, container.Driver, mountID, lowerdirName)
 	log.WithField("lowerdirpath", lowerdirpath).Debug("container lowerdir path")
 	data, err := ioutil.ReadFile(lowerdirpath)
 	if err != nil {
-		return fmt.Errorf("reading lower file %v", err)
+		return "", nil, fmt.Errorf("reading lower file %v", err)
 	}
 
-	var lowerdir string
-	for i, ldir := range strings.Split(string(data), ":") {
-		ldirpath := filepath.Join(e.root, container.Driver, ldir)
-		if i == 0 {
-			lowerdir = ldirpath
-			continue
-		}
-		lowerdir = fmt.Sprintf("%s:%s", lowerdir, ldirpath)
+	// The lower directories are the shortened layer links i.e. l/<link>
+	// to ../<layer id>/diff. The links are resolved, so the layers are
+	// walked and not only mounted.
+	var lowerdirs []string
+	for _, ldir := range strings.Split(strings.TrimSpace(string(data)), ":") {
+		lowerdirs = append(lowerdirs, resolveLayerLink(filepath.Join(e.root, container.Driver, ldir)))
 	}
-
-	upperdir := filepath.Join(e.root, container.Driver, mountID, "diff")
 	workdir := filepath.Join(e.root, container.Driver, mountID, "work")
 
 	log.WithFields(log.Fields{
-		"lowerdir": lowerdir,
+		"lowerdir": lowerdirs,
 		"upperdir": upperdir,
 		"workdir":  workdir,
 	}).Debug("container overlay directories")
 
-	// mounting container
-	mountopts := fmt.Sprintf("ro,lowerdir=%s:%s", upperdir, lowerdir)
-	mountargs := []string{"-t", "overlay", "overlay", "-o", mountopts, mountpoint}
+	return upperdir, lowerdirs, nil
+}
+
+// resolveLayerLink returns the layer directory of a shortened layer link.
+// The relative link target is resolved within the overlay2 directory. A
+// path that is not a link is returned as is.
+func resolveLayerLink(path string) string {
+	target, err := os.Readlink(path)
+	if err != nil || filepath.IsAbs(target) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(path), target)
+}
+
+// containerMountID returns the mount ID of a container i.e. the layer ID of
+// the container layer in the graph driver directory.
+func (e *explorer) containerMountID(container ConfigFile) (string, error) {
+	containerMountIDPath := filepath.Join(e.root, repositoriesDirName, container.Driver, "layerdb", "mounts", container.ID, "mount-id")
+	log.WithField("containerMountIDPath", containerMountIDPath).Debug("container mount-id path")
 
-	cmd := exec.Command("mount", mountargs...)
-	out, err := cmd.CombinedOutput()
+	mountIDByte, err := ioutil.ReadFile(containerMountIDPath)
 	if err != nil {
-		log.Errorf("running mount command %v", mountargs)
+		return "", fmt.Errorf("reading container mount-id")
+	}
+	mountID := string(mountIDByte)
+	log.WithField("mount-id", mountID).Debug("container mount-id")
+	return mountID, nil
+}
 
-		if strings.Contains(err.Error(), " 32") {
-			return fmt.Errorf("invalid lowerdir path %v. Use --debug to view lowerdir path", err)
+// MountContainer mounts a container to the specified path
+//
+// The container layer of a device graph driver i.e. devicemapper is mounted
+// on a temporary directory while the layer directory is bind mounted to the
+// specified path. The temporary mount is released once the bind mount holds
+// the filesystem.
+func (e *explorer) MountContainer(ctx context.Context, containerid string, mountpoint string) error {
+	container, err := e.GetContainer(ctx, containerid)
+	if err != nil {
+		return fmt.Errorf("getting container %v", err)
+	}
+	driver, err := storage.Get(container.Driver)
+	if err != nil {
+		return err
+	}
+	if dd, ok := driver.(storage.DeviceDriver); ok {
+		mountID, err := e.containerMountID(container)
+		if err != nil {
+			return err
+		}
+		dir, release, err := dd.MountLayer(filepath.Join(e.root, container.Driver), mountID, true)
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("executing mount command %v", err)
+		defer func() {
+			if err := release(); err != nil {
+				log.WithField("layerdir", dir).Warn("releasing container layer device: ", err)
+			}
+		}()
+		return explorers.MountOverlay([]string{dir}, mountpoint)
 	}
 
-	if string(out) != "" {
-		log.WithField("mount command", string(out)).Debug("container mount command")
+	upperdir, lowerdirs, err := e.ContainerLayers(ctx, containerid)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return explorers.MountOverlay(append([]string{upperdir}, lowerdirs...), mountpoint)
 }
 
 // MountAllContainers mounts all the containers
-func (e *explorer) MountAllContainers(ctx context.Context, mountpoint string, skipsupportcontainers bool) error {
+func (e *explorer) MountAllContainers(ctx context.Context, mountpoint string, opts explorers.MountAllOptions) ([]explorers.MountIndexEntry, error) {
 	containersdir := filepath.Join(e.root, containersDirName)
 	log.WithField("containersdir", containersdir).Debug("docker containers directory")
 
 	containerids, err := e.GetContainerIDs(ctx, containersdir)
 	if err != nil {
-		return fmt.Errorf("failed listing containers ID %v", err)
+		return nil, fmt.Errorf("failed listing containers ID %v", err)
 	}
 	if containerids == nil {
-		return fmt.Errorf("no container ID returned")
+		return nil, fmt.Errorf("no container ID returned")
+	}
+
+	batch, err := explorers.NewMountBatch(mountpoint, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, containerid := range containerids {
@@ -392,43 +458,32 @@ func (e *explorer) MountAllContainers(ctx context.Context, mountpoint string, sk
 		if err != nil {
 			log.WithField("containerid", containerid).Error("getting container details")
 			log.WithField("containerid", containerid).Warn("skipping container mount")
+			batch.Fail(explorers.Container{Container: containers.Container{ID: containerid}}, fmt.Errorf("getting container details: %w", err))
 			continue
 		}
 
-		if skipsupportcontainers && cecontainer.SupportContainer {
+		if opts.SkipSupportContainers && cecontainer.SupportContainer {
 			log.WithFields(log.Fields{
 				"namespace":   cecontainer.Namespace,
 				"containerid": cecontainer.ID,
 			}).Info("skip mounting Kubernetes support container")
+			batch.Skip(cecontainer, "Kubernetes support container")
 			continue
 		}
 
-		// Create mountpoint for each container
-		ctrmountpoint := filepath.Join(mountpoint, cecontainer.ID)
-		if err := os.MkdirAll(ctrmountpoint, 0755); err != nil {
-			log.WithFields(log.Fields{
-				"namespace":   cecontainer.Namespace,
-				"containerid": cecontainer.ID,
-				"mountpoint":  ctrmountpoint,
-			}).Error("creating mountpoint for container")
-			log.WithField("containerid", containerid).Warn("skippoing container mount")
-			continue
-		}
-
-		if err := e.MountContainer(ctx, containerid, ctrmountpoint); err != nil {
-			log.WithFields(log.Fields{
-				"containerid": containerid,
-				"message":     err.Error(),
-			}).Error("mounting container")
-		}
+		batch.Mount(cecontainer, func(ctrmountpoint string) error {
+			return e.MountContainer(ctx, containerid, ctrmountpoint)
+		})
 	}
 
-	// default
-	return nil
+	return batch.Close()
 }
 
 // Close releases internal resources.
 func (e *explorer) Close() error {
+	if e.mdb == nil {
+		return nil
+	}
 	return e.mdb.Close()
 }
 
@@ -473,6 +528,14 @@ func (e *explorer) GetCEContainer(ctx context.Context, containerid string) (expl
 
 	cectr := convertToContainerExplorerContainer(config)
 
+	// LogPath in config.v2.json is the path on the host. Use the log file
+	// within the docker root directory.
+	if config.LogPath != "" {
+		cectr.LogPath = filepath.Join(e.root, containersDirName, containerid, filepath.Base(config.LogPath))
+	}
+
+	cectr.VolatileMounts = explorers.VolatileMounts("", e.tmpfsMounts(containerid, config))
+
 	// Use image friendly name if exits
 	if imagerepo != nil {
 		if val, found := imagerepo[cectr.Image]; found {
@@ -484,9 +547,58 @@ func (e *explorer) GetCEContainer(ctx context.Context, containerid string) (expl
 	cectr.ImageBase = imageBasename(cectr.Image)
 	cectr.SupportContainer = e.sc.IsSupportContainer(cectr)
 
+	// Docker containers are also matched using the container name i.e. the
+	// balena supervisor container balena_supervisor.
+	if !cectr.SupportContainer && e.sc != nil {
+		cectr.SupportContainer = e.sc.SupportContainerName(strings.TrimPrefix(config.Name, "/"))
+	}
+
 	return cectr, nil
 }
 
+// tmpfsMounts returns the tmpfs mounts of a container.
+//
+// The tmpfs mounts created using --mount type=tmpfs are stored in
+// config.v2.json and the mounts created using --tmpfs are stored in
+// hostconfig.json.
+func (e *explorer) tmpfsMounts(containerid string, config ConfigFile) []spec.Mount {
+	var mounts []spec.Mount
+
+	for destination, v := range config.MountPoints {
+		mp, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if mptype, _ := mp["Type"].(string); mptype == "tmpfs" {
+			mounts = append(mounts, spec.Mount{
+				Destination: destination,
+				Type:        "tmpfs",
+				Source:      "tmpfs",
+			})
+		}
+	}
+
+	hostconfigfile := filepath.Join(e.root, containersDirName, containerid, hostConfigFilename)
+	var hostconfig HostConfig
+	if data, err := ioutil.ReadFile(hostconfigfile); err != nil {
+		log.WithField("hostconfigfile", hostconfigfile).Debug("reading host config: ", err)
+	} else if err := json.Unmarshal(data, &hostconfig); err != nil {
+		log.WithField("hostconfigfile", hostconfigfile).Warn("unmarshalling host config: ", err)
+	}
+	for destination := range hostconfig.Tmpfs {
+		mounts = append(mounts, spec.Mount{
+			Destination: destination,
+			Type:        "tmpfs",
+			Source:      "tmpfs",
+		})
+	}
+
+	sort.Slice(mounts, func(i, j int) bool {
+		return mounts[i].Destination < mounts[j].Destination
+	})
+	return mounts
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -507,13 +619,13 @@ func (e *explorer) GetRepositories(ctx context.Context) (map[string]string, erro
 	for _, storagedir := range storagedirs {
 		_, storagename := filepath.Split(storagedir)
 
-		if storagename != "overlay2" {
-			// TODO(rmaskey): handle other storage
+		// The repositories file has the same format for all the graph
+		// drivers i.e. overlay2 and fuse-overlayfs.
+		if _, err := storage.Get(storagename); err != nil {
 			log.Warn("storage ", storagename, " currently not supported")
 			continue
 		}
 
-		// Handle overlay2 storage
 		repositoriesfile := filepath.Join(storagedir, repositoriesFileName)
 		data, err := ioutil.ReadFile(repositoriesfile)
 		if err != nil {
@@ -581,6 +693,7 @@ func convertToContainerExplorerContainer(config ConfigFile) explorers.Container
 			ID:          config.ID,
 			CreatedAt:   config.Created,
 			Image:       config.Image,
+			Labels:      config.Config.Labels,
 			Snapshotter: config.Driver,
 			Runtime: containers.RuntimeInfo{
 				Name: config.Name,
@@ -589,6 +702,9 @@ func convertToContainerExplorerContainer(config ConfigFile) explorers.Container
 		Running:      config.State.Running,
 		ExposedPorts: exposedports,
 		Status:       status,
+		StartedAt:    config.State.StartedAt,
+		FinishedAt:   config.State.FinishedAt,
+		ExitCode:     int(config.State.ExitCode),
 	}
 }
 
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/storage"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// v2Metadata is an entry of the docker distribution metadata i.e.
+// image/overlay2/distribution/v2metadata-by-diffid/sha256/<diff id>
+type v2Metadata struct {
+	Digest           digest.Digest
+	SourceRepository string
+}
+
+// InspectImage returns the configuration and layers of an image.
+//
+// Docker does not keep the image manifest. The image ID is the digest of
+// the image configuration and the compressed layer digests are read from
+// the distribution metadata of the pulled images.
+func (e *explorer) InspectImage(ctx context.Context, name string) (explorers.ImageDetail, error) {
+	images, err := e.ListImages(ctx)
+	if err != nil {
+		return explorers.ImageDetail{}, err
+	}
+
+	for _, image := range images {
+		if !explorers.MatchImageName(image.Name, name) {
+			continue
+		}
+
+		storagedirs, _ := filepath.Glob(filepath.Join(e.root, repositoriesDirName, "*"))
+		for _, storagedir := range storagedirs {
+			detail, err := e.inspectImage(storagedir, image.Name, image.Target.Digest)
+			if err != nil {
+				log.WithField("storagedir", storagedir).Debug("inspecting image: ", err)
+				continue
+			}
+			return detail, nil
+		}
+		return explorers.ImageDetail{}, fmt.Errorf("image configuration %s not found", image.Target.Digest)
+	}
+	return explorers.ImageDetail{}, fmt.Errorf("image %s not found", name)
+}
+
+// inspectImage returns the configuration and layers of an image in a
+// storage directory i.e. /var/lib/docker/image/overlay2.
+func (e *explorer) inspectImage(storagedir string, name string, id digest.Digest) (explorers.ImageDetail, error) {
+	if err := id.Validate(); err != nil {
+		return explorers.ImageDetail{}, err
+	}
+
+	detail := explorers.ImageDetail{
+		Name:       name,
+		Target:     id.String(),
+		Config:     id.String(),
+		ConfigPath: filepath.Join(storagedir, "imagedb", "content", id.Algorithm().String(), id.Encoded()),
+	}
+
+	data, err := ioutil.ReadFile(detail.ConfigPath)
+	if err != nil {
+		return detail, err
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(data, &config); err != nil {
+		return detail, fmt.Errorf("unmarshalling image configuration %s: %w", id, err)
+	}
+
+	drivername := filepath.Base(storagedir)
+	driver, err := storage.Get(drivername)
+	if err != nil {
+		return detail, err
+	}
+
+	diffids := config.RootFS.DiffIDs
+	chainids := identity.ChainIDs(append([]digest.Digest(nil), diffids...))
+	for i, diffid := range diffids {
+		layer := explorers.ImageLayer{
+			DiffID: diffid.String(),
+		}
+
+		metadatafile := filepath.Join(storagedir, "distribution", "v2metadata-by-diffid", diffid.Algorithm().String(), diffid.Encoded())
+		if data, err := ioutil.ReadFile(metadatafile); err == nil {
+			var metadata []v2Metadata
+			if err := json.Unmarshal(data, &metadata); err == nil && len(metadata) > 0 {
+				layer.Digest = metadata[0].Digest.String()
+			}
+		}
+
+		cacheidfile := filepath.Join(storagedir, "layerdb", chainids[i].Algorithm().String(), chainids[i].Encoded(), "cache-id")
+		if data, err := ioutil.ReadFile(cacheidfile); err == nil {
+			layer.Dir, _ = driver.LayerDir(filepath.Join(e.root, drivername), strings.TrimSpace(string(data)), false)
+		}
+
+		detail.Layers = append(detail.Layers, layer)
+	}
+	return detail, nil
+}
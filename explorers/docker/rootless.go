@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/google/container-explorer/explorers"
+)
+
+const (
+	// rootfulDockerDir is the docker root directory of the rootful daemon.
+	rootfulDockerDir = "/var/lib/docker"
+
+	// rootlessDockerDir is the docker root directory of rootless docker
+	// relative to the user home directory i.e. $XDG_DATA_HOME/docker.
+	rootlessDockerDir = ".local/share/docker"
+)
+
+// Root describes a docker root directory.
+//
+// User is empty for the rootful docker root and the user name for a rootless
+// docker root.
+type Root struct {
+	User string
+	Root string
+}
+
+// FindRoots returns the rootful and rootless docker root directories within
+// the image root.
+//
+// Rootless docker runs a daemon for each user and keeps the data in the user
+// home directory. The layers of a rootless docker root are usually stored
+// using the fuse-overlayfs graph driver.
+func FindRoots(imageroot string) []Root {
+	var roots []Root
+
+	rootful := filepath.Join(imageroot, rootfulDockerDir)
+	if explorers.PathExists(rootful, false) {
+		roots = append(roots, Root{Root: rootful})
+	}
+
+	homes, _ := filepath.Glob(filepath.Join(imageroot, "home", "*"))
+	homes = append(homes, filepath.Join(imageroot, "root"))
+	for _, home := range homes {
+		root := filepath.Join(home, rootlessDockerDir)
+		if explorers.PathExists(root, false) {
+			roots = append(roots, Root{
+				User: filepath.Base(home),
+				Root: root,
+			})
+		}
+	}
+	return roots
+}
+
+// HasContainers returns true if the docker root directory has containers.
+func (r Root) HasContainers() bool {
+	entries, err := os.ReadDir(filepath.Join(r.Root, containersDirName))
+	return err == nil && len(entries) > 0
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultAppArmorProfile is the AppArmor profile applied by docker when a
+// container does not specify a profile.
+const DefaultAppArmorProfile = "docker-default"
+
+// defaultCapabilities are the capabilities granted by docker to a container
+// that is not privileged.
+var defaultCapabilities = []string{
+	"CAP_AUDIT_WRITE",
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_MKNOD",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_RAW",
+	"CAP_SETFCAP",
+	"CAP_SETGID",
+	"CAP_SETPCAP",
+	"CAP_SETUID",
+	"CAP_SYS_CHROOT",
+}
+
+// ContainerSecurityProfile returns the security profile of a container.
+//
+// Docker does not store an OCI runtime spec. The profiles are read from
+// config.v2.json where an empty seccomp profile is the daemon default
+// profile and a custom seccomp profile is stored as JSON. The capabilities
+// are the docker default capabilities modified by CapAdd and CapDrop of
+// hostconfig.json.
+func (e *explorer) ContainerSecurityProfile(ctx context.Context, containerid string) (explorers.SecurityProfile, error) {
+	container, err := e.GetContainer(ctx, containerid)
+	if err != nil {
+		return explorers.SecurityProfile{}, err
+	}
+
+	hostconfigfile := filepath.Join(e.root, containersDirName, containerid, hostConfigFilename)
+	var hostconfig HostConfig
+	if data, err := ioutil.ReadFile(hostconfigfile); err != nil {
+		log.WithField("hostconfigfile", hostconfigfile).Debug("reading host config: ", err)
+	} else if err := json.Unmarshal(data, &hostconfig); err != nil {
+		log.WithField("hostconfigfile", hostconfigfile).Warn("unmarshalling host config: ", err)
+	}
+
+	profile := explorers.SecurityProfile{
+		AppArmor:     container.AppArmorProfile,
+		Capabilities: containerCapabilities(hostconfig),
+		Privileged:   hostconfig.Privileged,
+		Source:       filepath.Join(e.root, containersDirName, containerid, configV2Filename),
+	}
+
+	// A privileged container is not confined.
+	if hostconfig.Privileged {
+		profile.AppArmor = explorers.ProfileUnconfined
+		return profile, nil
+	}
+
+	if profile.AppArmor == "" {
+		profile.AppArmor = DefaultAppArmorProfile
+	}
+
+	switch container.SeccompProfile {
+	case "":
+		profile.SeccompRuntimeDefault = true
+	case explorers.ProfileUnconfined:
+	default:
+		seccomp, err := explorers.ParseSeccompProfile([]byte(container.SeccompProfile), profile.Capabilities)
+		if err != nil {
+			return profile, err
+		}
+		profile.Seccomp = seccomp
+	}
+	return profile, nil
+}
+
+// containerCapabilities returns the capabilities of a container that is not
+// privileged.
+func containerCapabilities(hostconfig HostConfig) []string {
+	normalize := func(c string) string {
+		c = strings.ToUpper(c)
+		if c != "ALL" && !strings.HasPrefix(c, "CAP_") {
+			c = "CAP_" + c
+		}
+		return c
+	}
+
+	caps := make(map[string]bool)
+	for _, c := range defaultCapabilities {
+		caps[c] = true
+	}
+	for _, c := range hostconfig.CapDrop {
+		if c = normalize(c); c == "ALL" {
+			caps = make(map[string]bool)
+		} else {
+			delete(caps, c)
+		}
+	}
+	for _, c := range hostconfig.CapAdd {
+		caps[normalize(c)] = true
+	}
+
+	var result []string
+	for c := range caps {
+		result = append(result, c)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+)
+
+// dockerArchiveManifest is an image of the manifest.json of a docker save
+// archive.
+type dockerArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// DockerArchiveLayer is an uncompressed layer tar file of a docker save
+// archive.
+type DockerArchiveLayer struct {
+	Path   string        // uncompressed layer tar file
+	DiffID digest.Digest // digest of the uncompressed layer tar file
+}
+
+// WriteDockerArchive writes an image to a tar stream in the docker save
+// format loaded by docker load and podman load.
+//
+// The archive holds manifest.json, the image configuration named
+// <config digest>.json, and the uncompressed layers named
+// <diff id>/layer.tar. The entries have a fixed modification time, so the
+// same image always produces the same archive.
+func WriteDockerArchive(w io.Writer, config []byte, repotags []string, layers []DockerArchiveLayer) error {
+	tw := tar.NewWriter(w)
+
+	configname := digest.FromBytes(config).Encoded() + ".json"
+	manifest := []dockerArchiveManifest{{
+		Config:   configname,
+		RepoTags: repotags,
+		Layers:   []string{},
+	}}
+
+	written := make(map[digest.Digest]bool)
+	for _, layer := range layers {
+		name := layer.DiffID.Encoded() + "/layer.tar"
+		manifest[0].Layers = append(manifest[0].Layers, name)
+		if written[layer.DiffID] {
+			continue
+		}
+		written[layer.DiffID] = true
+
+		if err := writeDockerArchiveDir(tw, layer.DiffID.Encoded()+"/"); err != nil {
+			return err
+		}
+		if err := writeDockerArchiveLayer(tw, name, layer.Path); err != nil {
+			return fmt.Errorf("writing layer %s: %w", layer.DiffID, err)
+		}
+	}
+
+	if err := writeDockerArchiveFile(tw, configname, config); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := writeDockerArchiveFile(tw, "manifest.json", data); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// DockerRepoTag returns the familiar repository and tag of an image name
+// used in the RepoTags of a docker save archive i.e. nginx:1.25 for
+// docker.io/library/nginx:1.25.
+//
+// An image name without a tag i.e. a digest reference has no repository
+// tag, and false is returned.
+func DockerRepoTag(name string) (string, bool) {
+	ref, err := docker.ParseNormalizedNamed(name)
+	if err != nil {
+		return "", false
+	}
+	tagged, ok := ref.(docker.Tagged)
+	if !ok {
+		return "", false
+	}
+	repository := ref.Name()
+	if familiar, ok := docker.TrimNamed(ref).(interface{ Familiar() docker.Named }); ok {
+		repository = familiar.Familiar().Name()
+	}
+	return repository + ":" + tagged.Tag(), true
+}
+
+// dockerArchiveTime is the modification time of the docker save archive
+// entries.
+var dockerArchiveTime = time.Unix(0, 0)
+
+// writeDockerArchiveDir writes a directory entry.
+func writeDockerArchiveDir(tw *tar.Writer, name string) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     0755,
+		ModTime:  dockerArchiveTime,
+		Format:   tar.FormatPAX,
+	})
+}
+
+// writeDockerArchiveFile writes a file entry with the content data.
+func writeDockerArchiveFile(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  dockerArchiveTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// writeDockerArchiveLayer writes a file entry with the content of a layer
+// tar file.
+func writeDockerArchiveLayer(tw *tar.Writer, name string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  dockerArchiveTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
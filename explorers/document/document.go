@@ -0,0 +1,188 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package document renders the investigative reports written in a small
+// Markdown subset to DOCX and PDF documents without external tools.
+//
+// The supported blocks are the headings (#, ##, and ###), the paragraphs,
+// the list items (-), the tables (| cell | cell |) where the first row is
+// the header row, and the page breaks (---). A | within a table cell is
+// escaped as \|.
+package document
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Block kinds of a document.
+const (
+	BlockHeading   = "heading"
+	BlockParagraph = "paragraph"
+	BlockList      = "list"
+	BlockTable     = "table"
+	BlockPageBreak = "pagebreak"
+)
+
+// Block is a block of a document.
+type Block struct {
+	Kind  string
+	Level int        // heading level from 1 to 3
+	Text  string     // heading and paragraph text
+	Items []string   // list items
+	Rows  [][]string // table rows where the first row is the header row
+}
+
+// Document is a parsed report document.
+type Document struct {
+	Title  string // text of the first level 1 heading
+	Blocks []Block
+}
+
+// Parse parses a document written in the Markdown subset.
+func Parse(r io.Reader) (Document, error) {
+	var (
+		doc       Document
+		paragraph []string
+		items     []string
+		rows      [][]string
+	)
+
+	// flush appends the pending paragraph, list, or table.
+	flush := func() {
+		if len(paragraph) > 0 {
+			doc.Blocks = append(doc.Blocks, Block{Kind: BlockParagraph, Text: strings.Join(paragraph, " ")})
+			paragraph = nil
+		}
+		if len(items) > 0 {
+			doc.Blocks = append(doc.Blocks, Block{Kind: BlockList, Items: items})
+			items = nil
+		}
+		if len(rows) > 0 {
+			doc.Blocks = append(doc.Blocks, Block{Kind: BlockTable, Rows: normalizeRows(rows)})
+			rows = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			flush()
+		case line == "---":
+			flush()
+			doc.Blocks = append(doc.Blocks, Block{Kind: BlockPageBreak})
+		case strings.HasPrefix(line, "#"):
+			flush()
+			level := len(line) - len(strings.TrimLeft(line, "#"))
+			text := strings.TrimSpace(line[level:])
+			if level > 3 {
+				level = 3
+			}
+			if level == 1 && doc.Title == "" {
+				doc.Title = text
+			}
+			doc.Blocks = append(doc.Blocks, Block{Kind: BlockHeading, Level: level, Text: text})
+		case strings.HasPrefix(line, "|"):
+			if len(paragraph) > 0 || len(items) > 0 {
+				flush()
+			}
+			if row := splitRow(line); !isSeparatorRow(row) {
+				rows = append(rows, row)
+			}
+		case strings.HasPrefix(line, "- "):
+			if len(paragraph) > 0 || len(rows) > 0 {
+				flush()
+			}
+			items = append(items, strings.TrimSpace(line[2:]))
+		default:
+			if len(items) > 0 || len(rows) > 0 {
+				flush()
+			}
+			paragraph = append(paragraph, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return doc, fmt.Errorf("reading document: %w", err)
+	}
+	flush()
+	return doc, nil
+}
+
+// EscapeCell returns the text escaped for a table cell. The line breaks are
+// replaced with spaces.
+func EscapeCell(s string) string {
+	s = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// splitRow returns the cells of a table row.
+func splitRow(line string) []string {
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var (
+		cells []string
+		cell  strings.Builder
+	)
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// isSeparatorRow returns true if the row separates the header row i.e.
+// |---|:---:|.
+func isSeparatorRow(row []string) bool {
+	for _, cell := range row {
+		if strings.Trim(cell, "-: ") != "" || !strings.Contains(cell, "-") {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeRows pads the rows to the number of columns of the widest row.
+func normalizeRows(rows [][]string) [][]string {
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	for i := range rows {
+		for len(rows[i]) < columns {
+			rows[i] = append(rows[i], "")
+		}
+	}
+	return rows
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DOCX page layout in twentieths of a point i.e. A4 with 2 cm margins.
+const (
+	docxPageWidth  = 11906
+	docxPageHeight = 16838
+	docxMargin     = 1134
+	docxTextWidth  = docxPageWidth - 2*docxMargin
+)
+
+const docxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>
+<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>
+</Types>`
+
+const docxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>
+</Relationships>`
+
+const docxDocumentRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+const docxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:docDefaults>
+<w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:cs="Calibri"/><w:sz w:val="20"/></w:rPr></w:rPrDefault>
+<w:pPrDefault><w:pPr><w:spacing w:after="120"/></w:pPr></w:pPrDefault>
+</w:docDefaults>
+<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>
+<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="240"/></w:pPr><w:rPr><w:b/><w:sz w:val="40"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="360" w:after="120"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:sz w:val="32"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240" w:after="120"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:sz w:val="26"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading3"><w:name w:val="heading 3"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="200" w:after="80"/><w:outlineLvl w:val="2"/></w:pPr><w:rPr><w:b/><w:sz w:val="22"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="ListBullet"><w:name w:val="List Bullet"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="40"/><w:ind w:left="360" w:hanging="240"/></w:pPr></w:style>
+<w:style w:type="paragraph" w:styleId="TableText"><w:name w:val="Table Text"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="0"/></w:pPr><w:rPr><w:sz w:val="16"/></w:rPr></w:style>
+<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:tblPr><w:tblBorders>
+<w:top w:val="single" w:sz="4" w:space="0" w:color="808080"/><w:left w:val="single" w:sz="4" w:space="0" w:color="808080"/>
+<w:bottom w:val="single" w:sz="4" w:space="0" w:color="808080"/><w:right w:val="single" w:sz="4" w:space="0" w:color="808080"/>
+<w:insideH w:val="single" w:sz="4" w:space="0" w:color="808080"/><w:insideV w:val="single" w:sz="4" w:space="0" w:color="808080"/>
+</w:tblBorders><w:tblCellMar><w:left w:w="60" w:type="dxa"/><w:right w:w="60" w:type="dxa"/></w:tblCellMar></w:tblPr></w:style>
+</w:styles>`
+
+// WriteDOCX writes the document as an Office Open XML document.
+//
+// The first level 1 heading is the title of the document. The table header
+// rows are repeated on each page.
+func WriteDOCX(w io.Writer, doc Document, created time.Time) error {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	body.WriteString(`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>`)
+
+	title := true
+	for _, block := range doc.Blocks {
+		switch block.Kind {
+		case BlockHeading:
+			style := fmt.Sprintf("Heading%d", block.Level)
+			if block.Level == 1 && title {
+				style = "Title"
+			}
+			title = false
+			writeDOCXParagraph(&body, style, block.Text)
+		case BlockParagraph:
+			writeDOCXParagraph(&body, "", block.Text)
+		case BlockList:
+			for _, item := range block.Items {
+				writeDOCXParagraph(&body, "ListBullet", "•\t"+item)
+			}
+		case BlockTable:
+			writeDOCXTable(&body, block.Rows)
+		case BlockPageBreak:
+			body.WriteString(`<w:p><w:r><w:br w:type="page"/></w:r></w:p>`)
+		}
+	}
+
+	fmt.Fprintf(&body, `<w:sectPr><w:pgSz w:w="%d" w:h="%d"/><w:pgMar w:top="%d" w:right="%d" w:bottom="%d" w:left="%d" w:header="567" w:footer="567" w:gutter="0"/></w:sectPr>`,
+		docxPageWidth, docxPageHeight, docxMargin, docxMargin, docxMargin, docxMargin)
+	body.WriteString(`</w:body></w:document>`)
+
+	var core bytes.Buffer
+	core.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	core.WriteString(`<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`)
+	core.WriteString(`<dc:title>`)
+	xml.EscapeText(&core, []byte(doc.Title))
+	core.WriteString(`</dc:title><dc:creator>container-explorer</dc:creator>`)
+	fmt.Fprintf(&core, `<dcterms:created xsi:type="dcterms:W3CDTF">%s</dcterms:created>`, created.UTC().Format(time.RFC3339))
+	core.WriteString(`</cp:coreProperties>`)
+
+	zw := zip.NewWriter(w)
+	for _, part := range []struct {
+		name string
+		data []byte
+	}{
+		{"[Content_Types].xml", []byte(docxContentTypes)},
+		{"_rels/.rels", []byte(docxRels)},
+		{"docProps/core.xml", core.Bytes()},
+		{"word/_rels/document.xml.rels", []byte(docxDocumentRels)},
+		{"word/styles.xml", []byte(docxStyles)},
+		{"word/document.xml", body.Bytes()},
+	} {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     part.name,
+			Method:   zip.Deflate,
+			Modified: created,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(part.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// writeDOCXParagraph writes a paragraph with a style.
+func writeDOCXParagraph(buf *bytes.Buffer, style string, text string) {
+	buf.WriteString(`<w:p>`)
+	if style != "" {
+		fmt.Fprintf(buf, `<w:pPr><w:pStyle w:val="%s"/></w:pPr>`, style)
+	}
+	writeDOCXRun(buf, text, false)
+	buf.WriteString(`</w:p>`)
+}
+
+// writeDOCXRun writes a run of text. The tabs are written as tab
+// characters.
+func writeDOCXRun(buf *bytes.Buffer, text string, bold bool) {
+	buf.WriteString(`<w:r>`)
+	if bold {
+		buf.WriteString(`<w:rPr><w:b/></w:rPr>`)
+	}
+	for i, part := range bytes.Split([]byte(text), []byte("\t")) {
+		if i > 0 {
+			buf.WriteString(`<w:tab/>`)
+		}
+		buf.WriteString(`<w:t xml:space="preserve">`)
+		xml.EscapeText(buf, part)
+		buf.WriteString(`</w:t>`)
+	}
+	buf.WriteString(`</w:r>`)
+}
+
+// writeDOCXTable writes a table where the first row is the header row.
+//
+// The column widths are proportional to the length of the longest cell of
+// each column.
+func writeDOCXTable(buf *bytes.Buffer, rows [][]string) {
+	widths := columnWidths(rows, docxTextWidth)
+
+	buf.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/>`)
+	fmt.Fprintf(buf, `<w:tblW w:w="%d" w:type="dxa"/><w:tblLayout w:type="fixed"/></w:tblPr><w:tblGrid>`, docxTextWidth)
+	for _, width := range widths {
+		fmt.Fprintf(buf, `<w:gridCol w:w="%d"/>`, int(width))
+	}
+	buf.WriteString(`</w:tblGrid>`)
+
+	for i, row := range rows {
+		buf.WriteString(`<w:tr>`)
+		if i == 0 {
+			buf.WriteString(`<w:trPr><w:tblHeader/></w:trPr>`)
+		}
+		for j, cell := range row {
+			fmt.Fprintf(buf, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/>`, int(widths[j]))
+			if i == 0 {
+				buf.WriteString(`<w:shd w:val="clear" w:color="auto" w:fill="D9D9D9"/>`)
+			}
+			buf.WriteString(`</w:tcPr><w:p><w:pPr><w:pStyle w:val="TableText"/></w:pPr>`)
+			writeDOCXRun(buf, cell, i == 0)
+			buf.WriteString(`</w:p></w:tc>`)
+		}
+		buf.WriteString(`</w:tr>`)
+	}
+	buf.WriteString(`</w:tbl><w:p/>`)
+}
+
+// columnWidths returns the widths of the table columns distributing the
+// total width in proportion to the length of the longest cell of each
+// column.
+func columnWidths(rows [][]string, total float64) []float64 {
+	if len(rows) == 0 {
+		return nil
+	}
+	columns := len(rows[0])
+	natural := make([]float64, columns)
+	for _, row := range rows {
+		for j, cell := range row {
+			if w := float64(len([]rune(cell))); w > natural[j] {
+				natural[j] = w
+			}
+		}
+	}
+
+	// Long cells i.e. hashes are wrapped, so the natural width of a column
+	// is capped to keep the short columns readable.
+	sum := 0.0
+	for j := range natural {
+		if natural[j] < 4 {
+			natural[j] = 4
+		}
+		if natural[j] > 40 {
+			natural[j] = 40
+		}
+		sum += natural[j]
+	}
+
+	widths := make([]float64, columns)
+	for j := range natural {
+		widths[j] = total * natural[j] / sum
+	}
+	return widths
+}
@@ -0,0 +1,397 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package document
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PDF page layout in points i.e. A4 with 2 cm margins.
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 56.7
+	pdfTextWidth  = pdfPageWidth - 2*pdfMargin
+	pdfFooterSize = 8
+
+	// pdfMaxTableLines is the maximum number of lines of a table row.
+	pdfMaxTableLines = 64
+)
+
+// Standard PDF fonts used by the documents. The standard fonts are not
+// embedded.
+const (
+	pdfFontRegular = "F1" // Helvetica
+	pdfFontBold    = "F2" // Helvetica-Bold
+)
+
+// Glyph widths of the printable ASCII characters from the Adobe font
+// metrics of the standard fonts in thousandths of the font size.
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// winAnsiRunes maps the characters outside of Latin-1 to the Windows-1252
+// encoding of the standard fonts.
+var winAnsiRunes = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfHeadingSizes are the font sizes of the heading levels.
+var pdfHeadingSizes = map[int]float64{1: 18, 2: 14, 3: 11}
+
+// pdfWriter lays out the blocks of a document on pages.
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64 // baseline of the next line from the bottom of the page
+}
+
+// WritePDF writes the document as a PDF document.
+//
+// The text is set in the standard Helvetica fonts and encoded in
+// Windows-1252; the characters outside of Windows-1252 are replaced with a
+// question mark. The table header rows are repeated on each page, and each
+// page has a footer with the document title and the page number.
+func WritePDF(w io.Writer, doc Document, created time.Time) error {
+	pw := &pdfWriter{}
+	pw.newPage()
+
+	for _, block := range doc.Blocks {
+		switch block.Kind {
+		case BlockHeading:
+			pw.heading(block.Level, block.Text)
+		case BlockParagraph:
+			pw.paragraph(pdfMargin, pdfTextWidth, block.Text)
+		case BlockList:
+			for _, item := range block.Items {
+				pw.listItem(item)
+			}
+			pw.y -= 4
+		case BlockTable:
+			pw.table(block.Rows)
+		case BlockPageBreak:
+			if pw.y < pdfPageHeight-pdfMargin {
+				pw.newPage()
+			}
+		}
+	}
+
+	for i, page := range pw.pages {
+		footer := fmt.Sprintf("Page %d of %d", i+1, len(pw.pages))
+		pdfText(page, pdfFontRegular, pdfFooterSize, pdfMargin, pdfMargin/2, doc.Title)
+		pdfText(page, pdfFontRegular, pdfFooterSize, pdfPageWidth-pdfMargin-textWidth(footer, pdfFontRegular, pdfFooterSize), pdfMargin/2, footer)
+	}
+	return pw.write(w, doc.Title, created)
+}
+
+// newPage starts a new page.
+func (pw *pdfWriter) newPage() {
+	pw.page = &bytes.Buffer{}
+	pw.pages = append(pw.pages, pw.page)
+	pw.y = pdfPageHeight - pdfMargin
+}
+
+// ensure starts a new page if the height does not fit on the page.
+func (pw *pdfWriter) ensure(height float64) {
+	if pw.y-height < pdfMargin && pw.y < pdfPageHeight-pdfMargin {
+		pw.newPage()
+	}
+}
+
+// heading writes a heading. The heading is kept on the page of the next
+// lines.
+func (pw *pdfWriter) heading(level int, text string) {
+	size := pdfHeadingSizes[level]
+	lines := wrapText(text, pdfFontBold, size, pdfTextWidth)
+	leading := size * 1.25
+
+	if pw.y < pdfPageHeight-pdfMargin {
+		pw.y -= size * 0.6
+	}
+	pw.ensure(float64(len(lines))*leading + 40)
+	for _, line := range lines {
+		pw.y -= size
+		pdfText(pw.page, pdfFontBold, size, pdfMargin, pw.y, line)
+		pw.y -= leading - size
+	}
+	pw.y -= size * 0.4
+}
+
+// paragraph writes the text wrapped to the width.
+func (pw *pdfWriter) paragraph(x float64, width float64, text string) {
+	const size, leading = 10, 13
+	for _, line := range wrapText(text, pdfFontRegular, size, width) {
+		pw.ensure(leading)
+		pw.y -= leading
+		pdfText(pw.page, pdfFontRegular, size, x, pw.y, line)
+	}
+	pw.y -= 6
+}
+
+// listItem writes a list item with a bullet.
+func (pw *pdfWriter) listItem(text string) {
+	const size, leading, indent = 10, 13, 14
+	for i, line := range wrapText(text, pdfFontRegular, size, pdfTextWidth-indent) {
+		pw.ensure(leading)
+		pw.y -= leading
+		if i == 0 {
+			pdfText(pw.page, pdfFontRegular, size, pdfMargin+3, pw.y, "•")
+		}
+		pdfText(pw.page, pdfFontRegular, size, pdfMargin+indent, pw.y, line)
+	}
+}
+
+// table writes a table with borders where the first row is the header row.
+// The header row is repeated when the table continues on a new page.
+func (pw *pdfWriter) table(rows [][]string) {
+	const size, leading, padding = 7.5, 9.5, 3
+	if len(rows) == 0 {
+		return
+	}
+	widths := columnWidths(rows, pdfTextWidth)
+
+	// layout returns the wrapped lines of the cells of a row and the row
+	// height.
+	layout := func(row []string, font string) ([][]string, float64) {
+		cells := make([][]string, len(row))
+		lines := 1
+		for j, cell := range row {
+			cells[j] = wrapText(cell, font, size, widths[j]-2*padding)
+			if len(cells[j]) > lines {
+				lines = len(cells[j])
+			}
+		}
+		// A row taller than a page is truncated.
+		if lines > pdfMaxTableLines {
+			lines = pdfMaxTableLines
+			for j := range cells {
+				if len(cells[j]) > lines {
+					cells[j] = cells[j][:lines]
+				}
+			}
+		}
+		return cells, float64(lines)*leading + 2*padding
+	}
+
+	draw := func(cells [][]string, height float64, font string, header bool) {
+		x := pdfMargin
+		top := pw.y
+		if header {
+			fmt.Fprintf(pw.page, "0.85 g %.2f %.2f %.2f %.2f re f 0 g\n", x, top-height, pdfTextWidth, height)
+		}
+		for j, lines := range cells {
+			fmt.Fprintf(pw.page, "0.5 G 0.5 w %.2f %.2f %.2f %.2f re S 0 G\n", x, top-height, widths[j], height)
+			for k, line := range lines {
+				pdfText(pw.page, font, size, x+padding, top-padding-float64(k+1)*leading+2, line)
+			}
+			x += widths[j]
+		}
+		pw.y -= height
+	}
+
+	header, headerheight := layout(rows[0], pdfFontBold)
+	pw.ensure(headerheight + leading + 2*padding)
+	draw(header, headerheight, pdfFontBold, true)
+
+	for _, row := range rows[1:] {
+		cells, height := layout(row, pdfFontRegular)
+		if pw.y-height < pdfMargin {
+			pw.newPage()
+			draw(header, headerheight, pdfFontBold, true)
+		}
+		draw(cells, height, pdfFontRegular, false)
+	}
+	pw.y -= 10
+}
+
+// write writes the PDF objects, the cross-reference table, and the trailer.
+func (pw *pdfWriter) write(w io.Writer, title string, created time.Time) error {
+	var (
+		out     bytes.Buffer
+		offsets []int
+	)
+	object := func(format string, args ...interface{}) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&out, format, args...)
+		out.WriteString("\nendobj\n")
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// The catalog, page tree, fonts, and information dictionary are
+	// followed by a page object and a content stream per page.
+	const firstPage = 6
+	var kids []string
+	for i := range pw.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+2*i))
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pw.pages))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object("<< /Title %s /Producer (container-explorer) /CreationDate (D:%s) >>", pdfString(title), created.UTC().Format("20060102150405Z"))
+
+	for i, page := range pw.pages {
+		var content bytes.Buffer
+		zw := zlib.NewWriter(&content)
+		if _, err := zw.Write(page.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		object("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, pdfFontRegular, pdfFontBold, firstPage+2*i+1)
+		object("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", content.Len(), content.Bytes())
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pdfText writes a line of text at a position.
+func pdfText(buf *bytes.Buffer, font string, size float64, x float64, y float64, text string) {
+	fmt.Fprintf(buf, "BT /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", font, size, x, y, pdfString(text))
+}
+
+// pdfString returns the text as a PDF literal string encoded in
+// Windows-1252.
+func pdfString(text string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, c := range winAnsi(text) {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// winAnsi returns the text encoded in Windows-1252. The control characters
+// are replaced with spaces.
+func winAnsi(text string) []byte {
+	var b []byte
+	for _, r := range text {
+		switch {
+		case r < 0x20:
+			b = append(b, ' ')
+		case r < 0x7f || (r >= 0xa0 && r <= 0xff):
+			b = append(b, byte(r))
+		case winAnsiRunes[r] != 0:
+			b = append(b, winAnsiRunes[r])
+		default:
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+// textWidth returns the width of the text in points.
+func textWidth(text string, font string, size float64) float64 {
+	widths := &helveticaWidths
+	if font == pdfFontBold {
+		widths = &helveticaBoldWidths
+	}
+
+	total := 0
+	for _, c := range winAnsi(text) {
+		switch {
+		case c >= 0x20 && c < 0x7f:
+			total += widths[c-0x20]
+		case c == 0x95:
+			total += 350
+		default:
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// wrapText returns the lines of the text wrapped to the width. The words
+// longer than the width i.e. hashes are broken.
+func wrapText(text string, font string, size float64, width float64) []string {
+	var (
+		lines []string
+		line  string
+	)
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if textWidth(candidate, font, size) <= width {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+			line = ""
+		}
+		for textWidth(word, font, size) > width {
+			runes := []rune(word)
+			n := 1
+			for n < len(runes) && textWidth(string(runes[:n+1]), font, size) <= width {
+				n++
+			}
+			lines = append(lines, string(runes[:n]))
+			word = string(runes[n:])
+		}
+		line = word
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
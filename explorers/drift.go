@@ -0,0 +1,325 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Drift changes.
+const (
+	DriftAdded    = "added"
+	DriftModified = "modified"
+	DriftDeleted  = "deleted"
+)
+
+// DriftMissing is the detail of a file deleted in a full copy snapshot.
+const DriftMissing = "missing"
+
+// FileDrift is a file of the container writable layer differing from the
+// image.
+//
+// The container attributes are empty for a deleted file. ModTime is the
+// modification time of the whiteout for a deleted file i.e. close to the
+// deletion time. A file deleted in a full copy snapshot has the detail
+// missing and the modification time of the nearest container directory.
+type FileDrift struct {
+	Path     string    `json:"path"`
+	Change   string    `json:"change"`
+	Details  []string  `json:"details,omitempty"` // modified attributes i.e. content, mode, owner
+	Type     string    `json:"type"`
+	Mode     string    `json:"mode,omitempty"`
+	UID      uint32    `json:"uid"`
+	GID      uint32    `json:"gid"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	SHA256   string    `json:"sha256,omitempty"`
+	Target   string    `json:"target,omitempty"` // symbolic link target
+	DiskPath string    `json:"disk_path"`        // file, whiteout, opaque directory, or parent directory in the upper layer
+
+	ImageLayer  int    `json:"image_layer"` // layer index of the image file where 1 is the top image layer. 0 if none
+	ImagePath   string `json:"image_path,omitempty"`
+	ImageType   string `json:"image_type,omitempty"`
+	ImageMode   string `json:"image_mode,omitempty"`
+	ImageSize   int64  `json:"image_size,omitempty"`
+	ImageSHA256 string `json:"image_sha256,omitempty"`
+	ImageTarget string `json:"image_target,omitempty"`
+}
+
+// ContainerDrift returns the files of the upper (writable) layer added,
+// modified, or deleted relative to the image layers sorted by path.
+//
+// The lower directories are ordered from top to bottom. A file copied to
+// the upper layer without a change of the content, type, mode, owner, or
+// modification time is not reported, and a directory is only reported when
+// it is added or its type, mode, or owner changed. The files of the image
+// hidden by a whiteout or an opaque directory are reported as deleted. The
+// SHA256 digests of the regular files are computed in both the container
+// and the image.
+func ContainerDrift(upperdir string, lowerdirs []string) ([]FileDrift, error) {
+	var (
+		drifts  []FileDrift
+		deleted = make(map[string]bool)
+		hidden  []FileDrift // whiteouts and opaque directories
+	)
+
+	err := filepath.Walk(upperdir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(upperdir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.Join("/", rel)
+
+		// The whiteouts are recorded and reported with the image files they
+		// hide.
+		name := info.Name()
+		whiteout := FileDrift{DiskPath: path, ModTime: info.ModTime().UTC()}
+		switch {
+		case name == whiteoutOpaqueDir:
+			whiteout.Path = filepath.Dir(rel)
+			whiteout.Change = DriftModified
+			hidden = append(hidden, whiteout)
+			return nil
+		case strings.HasPrefix(name, whiteoutPrefix):
+			whiteout.Path = filepath.Join(filepath.Dir(rel), strings.TrimPrefix(name, whiteoutPrefix))
+			whiteout.Change = DriftDeleted
+			hidden = append(hidden, whiteout)
+			return nil
+		case isWhiteout(info):
+			whiteout.Path = rel
+			whiteout.Change = DriftDeleted
+			hidden = append(hidden, whiteout)
+			return nil
+		case rel == "/":
+			return nil
+		}
+
+		if info.IsDir() && isOpaqueDir(path) {
+			whiteout.Path = rel
+			whiteout.Change = DriftModified
+			hidden = append(hidden, whiteout)
+		}
+
+		d := newFileDrift(rel, path, info)
+		if info.Mode().IsRegular() {
+			if d.SHA256, err = hashPackageFile(path, "sha256"); err != nil {
+				log.WithField("path", path).Warn("hashing file: ", err)
+			}
+		}
+
+		d.Change = DriftAdded
+		if image, found := findLayerFile(lowerdirs, rel); found {
+			setDriftImage(&d, image)
+			if d.Details = driftDetails(d, info, image.Info); len(d.Details) == 0 {
+				return nil
+			}
+			d.Change = DriftModified
+		}
+		drifts = append(drifts, d)
+		return nil
+	})
+	if err != nil {
+		return drifts, fmt.Errorf("walking upper layer %s: %w", upperdir, err)
+	}
+
+	// The image files hidden by a whiteout are deleted. The image files
+	// within an opaque directory are deleted unless the upper layer has a
+	// file at the same path.
+	for _, h := range hidden {
+		scope := newPathList()
+		scope.add(h.Path)
+		scope.add(strings.TrimSuffix(h.Path, "/") + "/")
+
+		err := WalkLayers(lowerdirs, scope.Filter(func(f LayerFile) error {
+			if deleted[f.Path] {
+				return nil
+			}
+			if h.Change == DriftModified && (f.Path == h.Path || PathExists(filepath.Join(upperdir, f.Path), true)) {
+				return nil
+			}
+			deleted[f.Path] = true
+
+			d := FileDrift{
+				Path:     f.Path,
+				Change:   DriftDeleted,
+				Type:     fileTypeName(f.Info.Mode()),
+				ModTime:  h.ModTime,
+				DiskPath: h.DiskPath,
+			}
+			setDriftImage(&d, f)
+			drifts = append(drifts, d)
+			return nil
+		}))
+		if err != nil {
+			return drifts, err
+		}
+	}
+
+	sort.SliceStable(drifts, func(i, j int) bool {
+		return drifts[i].Path < drifts[j].Path
+	})
+	return drifts, nil
+}
+
+// FullCopyDrift returns the files of a full copy container snapshot added,
+// modified, or deleted relative to the image snapshot it was copied from
+// sorted by path.
+//
+// A full copy storage driver i.e. native keeps all the files of the image
+// in the container snapshot, and a deleted file leaves no whiteout. The
+// image files missing in the container are deleted, and the modification
+// time of a deleted file is the time of the nearest container directory,
+// i.e. the time of the last change of the directory.
+func FullCopyDrift(dir string, imagedir string) ([]FileDrift, error) {
+	drifts, err := ContainerDrift(dir, []string{imagedir})
+	if err != nil {
+		return drifts, err
+	}
+
+	err = WalkLayers([]string{imagedir}, func(f LayerFile) error {
+		if _, err := os.Lstat(filepath.Join(dir, f.Path)); !os.IsNotExist(err) {
+			return nil
+		}
+
+		parent := filepath.Dir(f.Path)
+		for parent != "/" && !PathExists(filepath.Join(dir, parent), false) {
+			parent = filepath.Dir(parent)
+		}
+		d := FileDrift{
+			Path:     f.Path,
+			Change:   DriftDeleted,
+			Details:  []string{DriftMissing},
+			Type:     fileTypeName(f.Info.Mode()),
+			DiskPath: filepath.Join(dir, parent),
+		}
+		if info, err := os.Stat(d.DiskPath); err == nil {
+			d.ModTime = info.ModTime().UTC()
+		}
+		setDriftImage(&d, f)
+		drifts = append(drifts, d)
+		return nil
+	})
+	if err != nil {
+		return drifts, fmt.Errorf("walking image snapshot %s: %w", imagedir, err)
+	}
+
+	sort.SliceStable(drifts, func(i, j int) bool {
+		return drifts[i].Path < drifts[j].Path
+	})
+	return drifts, nil
+}
+
+// newFileDrift returns the container attributes of a file of the upper
+// layer.
+func newFileDrift(rel string, path string, info os.FileInfo) FileDrift {
+	stat := StatInfo(info)
+	d := FileDrift{
+		Path:     rel,
+		Type:     fileTypeName(info.Mode()),
+		Mode:     info.Mode().String(),
+		UID:      stat.UID,
+		GID:      stat.GID,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UTC(),
+		DiskPath: path,
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		d.Target, _ = os.Readlink(path)
+	}
+	return d
+}
+
+// setDriftImage sets the image attributes of a file. The image layer index
+// of f starts at 0 for the top image layer.
+func setDriftImage(d *FileDrift, f LayerFile) {
+	d.ImageLayer = f.Layer + 1
+	d.ImagePath = f.LayerPath
+	d.ImageType = fileTypeName(f.Info.Mode())
+	d.ImageMode = f.Info.Mode().String()
+	d.ImageSize = f.Info.Size()
+
+	var err error
+	switch {
+	case f.Info.Mode().IsRegular():
+		if d.ImageSHA256, err = hashPackageFile(f.LayerPath, "sha256"); err != nil {
+			log.WithField("path", f.LayerPath).Warn("hashing file: ", err)
+		}
+	case f.Info.Mode()&os.ModeSymlink != 0:
+		d.ImageTarget, _ = os.Readlink(f.LayerPath)
+	}
+}
+
+// driftDetails returns the attributes of a file differing from the image
+// file.
+func driftDetails(d FileDrift, info os.FileInfo, image os.FileInfo) []string {
+	if d.Type != d.ImageType {
+		return []string{"type"}
+	}
+
+	var details []string
+	switch {
+	case info.Mode().IsRegular():
+		if info.Size() != image.Size() || d.SHA256 == "" || d.SHA256 != d.ImageSHA256 {
+			details = append(details, "content")
+		}
+	case d.Target != d.ImageTarget:
+		details = append(details, "target")
+	}
+
+	if info.Mode() != image.Mode() {
+		details = append(details, "mode")
+	}
+	istat := StatInfo(image)
+	if d.UID != istat.UID || d.GID != istat.GID {
+		details = append(details, "owner")
+	}
+	// The modification time of a directory changes when a file is added or
+	// deleted.
+	if !info.IsDir() && !info.ModTime().Equal(image.ModTime()) {
+		details = append(details, "mtime")
+	}
+	return details
+}
+
+// fileTypeName returns the type of a file i.e. file, dir, or symlink.
+func fileTypeName(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "dir"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	}
+	return "unknown"
+}
@@ -0,0 +1,583 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Egress endpoint kinds i.e. the type of the configuration naming the
+// endpoint.
+const (
+	EgressNginx      = "nginx"
+	EgressEnv        = "env"
+	EgressKubeconfig = "kubeconfig"
+	EgressRegistry   = "registry"
+)
+
+// egressMaxFileSize is the maximum size of a configuration file read for
+// endpoints.
+const egressMaxFileSize = 1024 * 1024
+
+// nginxConfigDirs are the nginx configuration directories of the nginx,
+// OpenResty, and source installations.
+var nginxConfigDirs = []string{
+	"/etc/nginx/",
+	"/usr/local/nginx/conf/",
+	"/usr/local/openresty/nginx/conf/",
+}
+
+// egressURLPattern matches the URLs in a configuration value.
+var egressURLPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s'",;]+`)
+
+// egressHostSuffixes are the suffixes of the environment variables holding
+// a host name or a host:port address i.e. DB_HOST or REDIS_ADDR.
+var egressHostSuffixes = []string{"_HOST", "_HOSTNAME", "_ADDR", "_ADDRESS", "_SERVER", "_ENDPOINT"}
+
+// serviceLinkPattern matches the service link variables injected by kubelet
+// and docker i.e. REDIS_SERVICE_HOST or REDIS_PORT_6379_TCP_ADDR. They name
+// every service of the namespace, not the endpoints used by the application.
+var serviceLinkPattern = regexp.MustCompile(`_SERVICE_(HOST|PORT\w*)$|_PORT(_\d+_(TCP|UDP|SCTP)\w*)?$`)
+
+// egressDefaultPorts are the default ports of the URL schemes.
+var egressDefaultPorts = map[string]int{
+	"http":       80,
+	"https":      443,
+	"ws":         80,
+	"wss":        443,
+	"ftp":        21,
+	"grpc":       443,
+	"amqp":       5672,
+	"amqps":      5671,
+	"mongodb":    27017,
+	"mysql":      3306,
+	"nats":       4222,
+	"postgres":   5432,
+	"postgresql": 5432,
+	"redis":      6379,
+	"rediss":     6379,
+}
+
+// EgressEndpoint is a network endpoint a container is expected to connect to
+// inferred from the application configuration. The expected endpoints are
+// compared with the network telemetry to find unexpected connections.
+type EgressEndpoint struct {
+	Host   string `json:"host"`
+	Port   int    `json:"port,omitempty"`
+	Scheme string `json:"scheme,omitempty"`
+	Kind   string `json:"kind"`
+	Source string `json:"source"` // container path of the configuration file
+	Field  string `json:"field"`  // directive, variable, or key naming the endpoint
+	Layer  int    `json:"layer"`  // layer index where 0 is the upper layer
+}
+
+// InferEgress returns the endpoints named in the configuration files in the
+// merged view of the layers i.e. nginx upstreams, application .env files,
+// kubeconfig cluster servers, and package registries.
+//
+// The loopback endpoints and the endpoints using variables are omitted.
+func InferEgress(layers []string) ([]EgressEndpoint, error) {
+	var endpoints []EgressEndpoint
+	err := WalkLayers(layers, func(f LayerFile) error {
+		if !f.Info.Mode().IsRegular() || f.Info.Size() > egressMaxFileSize {
+			return nil
+		}
+
+		parse := egressParser(f.Path)
+		if parse == nil {
+			return nil
+		}
+		data, err := os.ReadFile(f.LayerPath)
+		if err != nil {
+			log.WithField("path", f.Path).Warn("reading configuration file: ", err)
+			return nil
+		}
+		for _, e := range parse(data) {
+			e.Source = f.Path
+			e.Layer = f.Layer
+			endpoints = append(endpoints, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uniqueEndpoints(endpoints), nil
+}
+
+// egressParser returns the parser of a configuration file or nil if the file
+// does not name endpoints.
+func egressParser(p string) func([]byte) []EgressEndpoint {
+	dir, base := path.Dir(p), path.Base(p)
+
+	for _, nginxdir := range nginxConfigDirs {
+		if strings.HasPrefix(p, nginxdir) && (strings.HasSuffix(base, ".conf") || path.Base(dir) == "sites-enabled") {
+			return parseNginxEgress
+		}
+	}
+
+	switch {
+	case base == ".env" || strings.HasPrefix(base, ".env.") || strings.HasSuffix(base, ".env"):
+		return func(data []byte) []EgressEndpoint {
+			return EgressFromEnv(dotenvVariables(data))
+		}
+	case base == "config" && path.Base(dir) == ".kube",
+		base == "kubeconfig" || strings.HasSuffix(base, ".kubeconfig"),
+		dir == "/etc/kubernetes" && strings.HasSuffix(base, ".conf"):
+		return parseKubeconfigEgress
+	case p == "/etc/apt/sources.list", dir == "/etc/apt/sources.list.d":
+		return parseAptEgress
+	case dir == "/etc/yum.repos.d" && strings.HasSuffix(base, ".repo"):
+		return registryKeyParser("baseurl", "mirrorlist", "metalink")
+	case p == "/etc/apk/repositories":
+		return parseURLLines
+	case base == "pip.conf" || base == "pip.ini":
+		return registryKeyParser("index-url", "extra-index-url", "find-links")
+	case base == ".npmrc":
+		return registryKeyParser("registry")
+	}
+	return nil
+}
+
+// EgressFromEnv returns the endpoints named in the environment variables
+// i.e. API_URL=https://api.example.com or DB_HOST=db with DB_PORT=5432.
+// The service link variables are ignored.
+func EgressFromEnv(env []string) []EgressEndpoint {
+	values := make(map[string]string)
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i > 0 {
+			values[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	var endpoints []EgressEndpoint
+	for _, kv := range env {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			continue
+		}
+		key, value := kv[:i], kv[i+1:]
+		if serviceLinkPattern.MatchString(key) {
+			continue
+		}
+
+		urls := egressURLPattern.FindAllString(value, -1)
+		for _, u := range urls {
+			if e, ok := parseEgressEndpoint(u); ok {
+				e.Kind = EgressEnv
+				e.Field = key
+				endpoints = append(endpoints, e)
+			}
+		}
+		if len(urls) > 0 {
+			continue
+		}
+
+		for _, suffix := range egressHostSuffixes {
+			if !strings.HasSuffix(strings.ToUpper(key), suffix) {
+				continue
+			}
+			e, ok := parseEgressEndpoint(value)
+			if !ok {
+				break
+			}
+			if e.Port == 0 {
+				prefix := key[:len(key)-len(suffix)]
+				e.Port, _ = strconv.Atoi(values[prefix+"_PORT"])
+			}
+			e.Kind = EgressEnv
+			e.Field = key
+			endpoints = append(endpoints, e)
+			break
+		}
+	}
+	return endpoints
+}
+
+// dotenvVariables returns the KEY=VALUE variables of a .env file. The export
+// keyword and the quotes of the values are removed.
+func dotenvVariables(data []byte) []string {
+	var env []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(line[:i])
+		value := strings.Trim(strings.TrimSpace(line[i+1:]), `"'`)
+		env = append(env, key+"="+value)
+	}
+	return env
+}
+
+// nginxStatement is a directive of an nginx configuration file.
+type nginxStatement struct {
+	block string // enclosing block i.e. upstream backend
+	args  []string
+}
+
+// parseNginxEgress returns the upstream servers, the proxied addresses, and
+// the resolvers of an nginx configuration file. A proxy_pass naming an
+// upstream block is resolved by the upstream servers.
+func parseNginxEgress(data []byte) []EgressEndpoint {
+	statements := nginxStatements(data)
+
+	upstreams := make(map[string]bool)
+	for _, s := range statements {
+		if len(s.args) == 2 && s.args[0] == "upstream" {
+			upstreams[s.args[1]] = true
+		}
+	}
+
+	var endpoints []EgressEndpoint
+	add := func(field string, address string) {
+		if e, ok := parseEgressEndpoint(address); ok {
+			e.Kind = EgressNginx
+			e.Field = field
+			endpoints = append(endpoints, e)
+		}
+	}
+	for _, s := range statements {
+		if len(s.args) < 2 {
+			continue
+		}
+		switch directive := s.args[0]; directive {
+		case "server":
+			if !strings.HasPrefix(s.block, "upstream ") {
+				continue
+			}
+			// An upstream server without a port uses port 80.
+			if e, ok := parseEgressEndpoint(s.args[1]); ok {
+				if e.Port == 0 {
+					e.Port = 80
+				}
+				e.Kind = EgressNginx
+				e.Field = s.block + " server"
+				endpoints = append(endpoints, e)
+			}
+		case "proxy_pass", "grpc_pass", "fastcgi_pass", "uwsgi_pass", "scgi_pass", "memcached_pass":
+			address := s.args[1]
+			host := address
+			if i := strings.Index(host, "://"); i >= 0 {
+				host = host[i+3:]
+			}
+			if i := strings.IndexAny(host, ":/"); i >= 0 {
+				host = host[:i]
+			}
+			if upstreams[host] {
+				continue
+			}
+			add(directive, address)
+		case "resolver":
+			for _, arg := range s.args[1:] {
+				if strings.Contains(arg, "=") {
+					continue
+				}
+				if e, ok := parseEgressEndpoint(arg); ok {
+					if e.Port == 0 {
+						e.Port = 53
+					}
+					e.Kind = EgressNginx
+					e.Field = directive
+					endpoints = append(endpoints, e)
+				}
+			}
+		}
+	}
+	return endpoints
+}
+
+// nginxStatements returns the directives of an nginx configuration file with
+// the enclosing block of each directive.
+func nginxStatements(data []byte) []nginxStatement {
+	var (
+		statements []nginxStatement
+		blocks     []string
+		args       []string
+		token      strings.Builder
+		quote      byte
+		comment    bool
+	)
+	flush := func() {
+		if token.Len() > 0 {
+			args = append(args, token.String())
+			token.Reset()
+		}
+	}
+	block := func() string {
+		if len(blocks) == 0 {
+			return ""
+		}
+		return blocks[len(blocks)-1]
+	}
+
+	for _, c := range data {
+		switch {
+		case comment:
+			if c == '\n' {
+				comment = false
+			}
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				token.WriteByte(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			flush()
+			comment = true
+		case c == ';':
+			flush()
+			if len(args) > 0 {
+				statements = append(statements, nginxStatement{block: block(), args: args})
+			}
+			args = nil
+		case c == '{':
+			flush()
+			if len(args) > 0 {
+				statements = append(statements, nginxStatement{block: block(), args: args})
+			}
+			blocks = append(blocks, strings.Join(args, " "))
+			args = nil
+		case c == '}':
+			flush()
+			args = nil
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+		default:
+			token.WriteByte(c)
+		}
+	}
+	return statements
+}
+
+// parseKubeconfigEgress returns the API servers and the proxies of the
+// clusters of a kubeconfig file.
+func parseKubeconfigEgress(data []byte) []EgressEndpoint {
+	var config struct {
+		Clusters []struct {
+			Name    string `yaml:"name"`
+			Cluster struct {
+				Server   string `yaml:"server"`
+				ProxyURL string `yaml:"proxy-url"`
+			} `yaml:"cluster"`
+		} `yaml:"clusters"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil
+	}
+
+	var endpoints []EgressEndpoint
+	for _, c := range config.Clusters {
+		for field, value := range map[string]string{"server": c.Cluster.Server, "proxy-url": c.Cluster.ProxyURL} {
+			if e, ok := parseEgressEndpoint(value); ok {
+				e.Kind = EgressKubeconfig
+				e.Field = fmt.Sprintf("clusters[%s].%s", c.Name, field)
+				endpoints = append(endpoints, e)
+			}
+		}
+	}
+	return endpoints
+}
+
+// parseAptEgress returns the repositories of an apt sources.list file or a
+// deb822 .sources file.
+func parseAptEgress(data []byte) []EgressEndpoint {
+	var endpoints []EgressEndpoint
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		var urls []string
+		switch fields[0] {
+		case "deb", "deb-src":
+			urls = fields[1:2]
+			if strings.HasPrefix(fields[1], "[") {
+				// Skip the options i.e. [arch=amd64 signed-by=...].
+				for i, f := range fields[1:] {
+					if strings.HasSuffix(f, "]") && i+2 < len(fields) {
+						urls = fields[i+2 : i+3]
+						break
+					}
+				}
+			}
+		case "URIs:":
+			urls = fields[1:]
+		}
+		for _, u := range urls {
+			if e, ok := parseEgressEndpoint(u); ok {
+				e.Kind = EgressRegistry
+				e.Field = fields[0]
+				endpoints = append(endpoints, e)
+			}
+		}
+	}
+	return endpoints
+}
+
+// parseURLLines returns the URLs listed one per line i.e. the apk
+// repositories. A repository tag prefix i.e. @edge is ignored.
+func parseURLLines(data []byte) []EgressEndpoint {
+	var endpoints []EgressEndpoint
+	for _, u := range egressURLPattern.FindAllString(string(data), -1) {
+		if e, ok := parseEgressEndpoint(u); ok {
+			e.Kind = EgressRegistry
+			e.Field = "repository"
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints
+}
+
+// registryKeyParser returns a parser of the package manager configuration
+// files with key=value lines i.e. baseurl= of a yum repository.
+func registryKeyParser(keys ...string) func([]byte) []EgressEndpoint {
+	return func(data []byte) []EgressEndpoint {
+		var endpoints []EgressEndpoint
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			i := strings.Index(line, "=")
+			if i <= 0 {
+				continue
+			}
+			key := strings.TrimSpace(line[:i])
+			for _, k := range keys {
+				// The scoped npm registries are named @scope:registry.
+				if key != k && !strings.HasSuffix(key, ":"+k) {
+					continue
+				}
+				for _, u := range egressURLPattern.FindAllString(line[i+1:], -1) {
+					if e, ok := parseEgressEndpoint(u); ok {
+						e.Kind = EgressRegistry
+						e.Field = key
+						endpoints = append(endpoints, e)
+					}
+				}
+			}
+		}
+		return endpoints
+	}
+}
+
+// parseEgressEndpoint parses a URL, a host:port address, or a host name.
+// The credentials of a URL are not returned. The loopback addresses, the
+// UNIX sockets, and the host names using variables are not endpoints.
+func parseEgressEndpoint(address string) (EgressEndpoint, bool) {
+	address = strings.TrimSpace(address)
+	if address == "" || strings.HasPrefix(address, "unix:") {
+		return EgressEndpoint{}, false
+	}
+
+	var e EgressEndpoint
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return EgressEndpoint{}, false
+		}
+		e.Scheme = strings.ToLower(u.Scheme)
+		e.Host = u.Hostname()
+		e.Port, _ = strconv.Atoi(u.Port())
+		if e.Port == 0 {
+			e.Port = egressDefaultPorts[e.Scheme]
+		}
+	} else if host, port, err := net.SplitHostPort(address); err == nil {
+		e.Host = host
+		e.Port, _ = strconv.Atoi(port)
+	} else {
+		e.Host = strings.Trim(address, "[]")
+	}
+
+	e.Host = strings.ToLower(strings.TrimSuffix(e.Host, "."))
+	if !validEgressHost(e.Host) {
+		return EgressEndpoint{}, false
+	}
+	return e, true
+}
+
+// validEgressHost returns true if the host is an IP address or a host name
+// that is not a loopback address.
+func validEgressHost(host string) bool {
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return !ip.IsLoopback() && !ip.IsUnspecified()
+	}
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '.' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueEndpoints returns the endpoints without duplicates sorted by host,
+// port, and source.
+func uniqueEndpoints(endpoints []EgressEndpoint) []EgressEndpoint {
+	seen := make(map[EgressEndpoint]bool)
+	var unique []EgressEndpoint
+	for _, e := range endpoints {
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+		unique = append(unique, e)
+	}
+
+	sort.Slice(unique, func(i, j int) bool {
+		a, b := unique[i], unique[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Field < b.Field
+	})
+	return unique
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Encodings detected by FindEncodedBlobs.
+const (
+	EncodingBase64 = "base64"
+	EncodingHex    = "hex"
+)
+
+var (
+	base64Pattern = regexp.MustCompile(`[A-Za-z0-9+/_-]{16,}={0,2}`)
+	hexPattern    = regexp.MustCompile(`(?:[0-9a-fA-F]{2}){8,}`)
+)
+
+// EncodedBlob describes an encoded blob and its decoded value.
+type EncodedBlob struct {
+	Encoding  string  `json:"encoding"`
+	Offset    int     `json:"offset"`
+	Length    int     `json:"length"`
+	Decoded   []byte  `json:"-"`
+	Printable float64 `json:"printable"` // ratio of printable decoded characters
+}
+
+// FindEncodedBlobs returns the base64 and hex blobs of at least minlength
+// characters in data decoded one level.
+//
+// Hex blobs are matched first. A blob matching both encodings is reported
+// once as hex.
+func FindEncodedBlobs(data []byte, minlength int) []EncodedBlob {
+	var blobs []EncodedBlob
+	hexranges := make(map[int]int)
+
+	for _, loc := range hexPattern.FindAllIndex(data, -1) {
+		if loc[1]-loc[0] < minlength {
+			continue
+		}
+		decoded, err := hex.DecodeString(string(data[loc[0]:loc[1]]))
+		if err != nil {
+			continue
+		}
+		hexranges[loc[0]] = loc[1]
+		blobs = append(blobs, newEncodedBlob(EncodingHex, loc, decoded))
+	}
+
+	for _, loc := range base64Pattern.FindAllIndex(data, -1) {
+		if loc[1]-loc[0] < minlength {
+			continue
+		}
+		if end, found := hexranges[loc[0]]; found && end == loc[1] {
+			continue
+		}
+		decoded, err := decodeBase64(string(data[loc[0]:loc[1]]))
+		if err != nil {
+			continue
+		}
+		blobs = append(blobs, newEncodedBlob(EncodingBase64, loc, decoded))
+	}
+	return blobs
+}
+
+// newEncodedBlob returns an EncodedBlob for the match location.
+func newEncodedBlob(encoding string, loc []int, decoded []byte) EncodedBlob {
+	return EncodedBlob{
+		Encoding:  encoding,
+		Offset:    loc[0],
+		Length:    loc[1] - loc[0],
+		Decoded:   decoded,
+		Printable: printableRatio(decoded),
+	}
+}
+
+// decodeBase64 decodes standard and URL-safe base64 with or without
+// padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// printableRatio returns the ratio of printable UTF-8 characters in data.
+func printableRatio(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var total, printable int
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		total++
+		if r != utf8.RuneError && (unicode.IsPrint(r) || unicode.IsSpace(r)) {
+			printable++
+		}
+	}
+	return float64(printable) / float64(total)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// keyBucket is the etcd bucket containing the key revisions.
+	keyBucket = "key"
+
+	// revisionKeyLength is the length of a revision key
+	// <main revision>_<sub revision>. A tombstone revision has the suffix t.
+	revisionKeyLength = 17
+
+	// DBPath is the etcd database file relative to the etcd data directory.
+	DBPath = "member/snap/db"
+)
+
+// ResolveDBPath returns the etcd database file for a data directory or a
+// database file.
+func ResolveDBPath(path string) string {
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		return path
+	}
+	return filepath.Join(path, DBPath)
+}
+
+// ReadObjects returns the Kubernetes objects in an etcd database file.
+//
+// The database is opened read-only. The latest revision of each key is
+// returned. Keys deleted after the last compaction are returned with Deleted
+// set and the value of the last revision before the deletion if
+// includedeleted is true.
+func ReadObjects(dbpath string, includedeleted bool) ([]Object, error) {
+	db, err := explorers.OpenBolt(dbpath, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("opening etcd database %s: %w", dbpath, err)
+	}
+	defer db.Close()
+
+	objects := make(map[string]*Object)
+	err = db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(keyBucket))
+		if bkt == nil {
+			return fmt.Errorf("etcd bucket %s does not exist", keyBucket)
+		}
+
+		// The revision keys are sorted by revision.
+		return bkt.ForEach(func(k, v []byte) error {
+			if len(k) < revisionKeyLength {
+				return nil
+			}
+			tombstone := len(k) > revisionKeyLength && k[revisionKeyLength] == 't'
+
+			// mvccpb.KeyValue: key=1, create_revision=2, mod_revision=3,
+			// version=4, value=5
+			kv := protoMessage(v)
+			key := protoString(kv, 1)
+			if !strings.HasPrefix(key, registryPrefix) {
+				return nil
+			}
+
+			if tombstone {
+				if o, found := objects[key]; found {
+					o.Deleted = true
+					o.ModRevision = int64(binary.BigEndian.Uint64(k[:8]))
+				}
+				return nil
+			}
+
+			o := &Object{
+				CreateRevision: protoInt(kv, 2),
+				ModRevision:    protoInt(kv, 3),
+				Version:        protoInt(kv, 4),
+			}
+			o.parseKey(key)
+			o.decodeValue(kvValue(kv))
+			objects[key] = o
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Object
+	for _, o := range objects {
+		if o.Deleted && !includedeleted {
+			continue
+		}
+		results = append(results, *o)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Resource != results[j].Resource {
+			return results[i].Resource < results[j].Resource
+		}
+		if results[i].Namespace != results[j].Namespace {
+			return results[i].Namespace < results[j].Namespace
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	log.WithFields(log.Fields{
+		"dbpath":  dbpath,
+		"objects": len(results),
+	}).Debug("read etcd objects")
+
+	return results, nil
+}
+
+// kvValue returns the value field of a mvccpb.KeyValue.
+func kvValue(kv map[int][]protoField) []byte {
+	if fields := kv[5]; len(fields) > 0 {
+		return fields[len(fields)-1].Bytes
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+)
+
+const (
+	// registryPrefix is the default kube-apiserver etcd key prefix.
+	registryPrefix = "/registry/"
+
+	// protobufPrefix is the magic prefix of protobuf encoded objects.
+	protobufPrefix = "k8s\x00"
+
+	// encryptedPrefix is the prefix of objects encrypted at rest i.e.
+	// k8s:enc:aescbc:v1:key1:<ciphertext>.
+	encryptedPrefix = "k8s:enc:"
+)
+
+// Object holds the metadata of a Kubernetes object stored in etcd.
+//
+// The object data is not decoded. Only the metadata is recovered for secrets.
+type Object struct {
+	Key            string            `json:"key"`
+	Resource       string            `json:"resource"`
+	APIVersion     string            `json:"api_version,omitempty"`
+	Kind           string            `json:"kind,omitempty"`
+	Namespace      string            `json:"namespace,omitempty"`
+	Name           string            `json:"name"`
+	UID            string            `json:"uid,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	DeletedAt      time.Time         `json:"deletion_timestamp"` // zero if the deletion was not requested
+	Labels         map[string]string `json:"labels,omitempty"`
+	Owners         []Owner           `json:"owners,omitempty"`
+	NodeName       string            `json:"node_name,omitempty"`
+	Phase          string            `json:"phase,omitempty"`
+	ServiceAccount string            `json:"service_account,omitempty"`
+	Images         []string          `json:"images,omitempty"`
+	ContainerSpecs []ContainerSpec   `json:"container_specs,omitempty"`
+	PullSecrets    []string          `json:"image_pull_secrets,omitempty"` // pod or service account image pull secrets
+	Containers     []ContainerStatus `json:"containers,omitempty"`
+	SecretType     string            `json:"secret_type,omitempty"`
+	Encrypted      string            `json:"encrypted,omitempty"` // encryption provider
+	CreateRevision int64             `json:"create_revision"`
+	ModRevision    int64             `json:"mod_revision"`
+	Version        int64             `json:"version"`
+	Deleted        bool              `json:"deleted,omitempty"` // deleted from etcd but not compacted
+}
+
+// MarshalJSON omits the creation and the deletion timestamps that are not
+// set.
+func (o Object) MarshalJSON() ([]byte, error) {
+	type object Object
+	return json.Marshal(struct {
+		object
+		CreatedAt *time.Time `json:"created_at,omitempty"`
+		DeletedAt *time.Time `json:"deletion_timestamp,omitempty"`
+	}{
+		object:    object(o),
+		CreatedAt: explorers.OptionalTime(o.CreatedAt),
+		DeletedAt: explorers.OptionalTime(o.DeletedAt),
+	})
+}
+
+// Owner holds an owner reference of an object.
+type Owner struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+	Controller bool   `json:"controller,omitempty"`
+}
+
+// ContainerSpec holds the image and image pull policy of a pod container.
+type ContainerSpec struct {
+	Name            string `json:"name"`
+	Image           string `json:"image"`
+	ImagePullPolicy string `json:"image_pull_policy,omitempty"`
+}
+
+// ContainerStatus holds the status of a pod container.
+type ContainerStatus struct {
+	Name        string `json:"name"`
+	Image       string `json:"image"`
+	ImageID     string `json:"image_id,omitempty"`
+	ContainerID string `json:"container_id,omitempty"` // <runtime>://<container id>
+}
+
+// RuntimeContainerID returns the container ID without the runtime prefix.
+func (c ContainerStatus) RuntimeContainerID() string {
+	if i := strings.Index(c.ContainerID, "://"); i >= 0 {
+		return c.ContainerID[i+3:]
+	}
+	return c.ContainerID
+}
+
+// parseKey sets the resource, namespace, and name from the etcd key.
+//
+// Keys are /registry/<resource>/<namespace>/<name> for namespaced objects
+// and /registry/<resource>/<name> for cluster scoped objects. Resources of
+// API groups other than the core group may include the group i.e.
+// /registry/apiregistration.k8s.io/apiservices/<name>.
+func (o *Object) parseKey(key string) {
+	o.Key = key
+
+	parts := strings.Split(strings.TrimPrefix(key, registryPrefix), "/")
+	if len(parts) > 2 && strings.Contains(parts[0], ".") {
+		parts = append([]string{parts[0] + "/" + parts[1]}, parts[2:]...)
+	}
+
+	o.Resource = parts[0]
+	switch len(parts) {
+	case 1:
+	case 2:
+		o.Name = parts[1]
+	default:
+		o.Namespace = parts[1]
+		o.Name = strings.Join(parts[2:], "/")
+	}
+}
+
+// decodeValue decodes a protobuf, JSON, or encrypted object value.
+func (o *Object) decodeValue(value []byte) {
+	switch {
+	case bytes.HasPrefix(value, []byte(encryptedPrefix)):
+		provider := strings.SplitN(string(value[len(encryptedPrefix):]), ":", 2)[0]
+		o.Encrypted = provider
+	case bytes.HasPrefix(value, []byte(protobufPrefix)):
+		o.decodeProtobuf(value[len(protobufPrefix):])
+	case bytes.HasPrefix(bytes.TrimSpace(value), []byte("{")):
+		o.decodeJSON(value)
+	}
+}
+
+// decodeProtobuf decodes a runtime.Unknown envelope and the object
+// metadata.
+//
+// runtime.Unknown: typeMeta=1 {apiVersion=1, kind=2}, raw=2
+func (o *Object) decodeProtobuf(data []byte) {
+	unknown := protoMessage(data)
+	typemeta := protoEmbedded(unknown, 1)
+	o.APIVersion = protoString(typemeta, 1)
+	o.Kind = protoString(typemeta, 2)
+
+	raw := protoEmbedded(unknown, 2)
+
+	// All objects store ObjectMeta in field 1.
+	//
+	// ObjectMeta: name=1, namespace=3, uid=5, creationTimestamp=8,
+	// deletionTimestamp=9, labels=11, ownerReferences=13
+	meta := protoEmbedded(raw, 1)
+	if name := protoString(meta, 1); name != "" {
+		o.Name = name
+	}
+	if ns := protoString(meta, 3); ns != "" {
+		o.Namespace = ns
+	}
+	o.UID = protoString(meta, 5)
+	o.CreatedAt = protoTime(meta, 8)
+	o.DeletedAt = protoTime(meta, 9)
+	if labels := protoMap(meta, 11); len(labels) > 0 {
+		o.Labels = labels
+	}
+
+	// OwnerReference: kind=1, name=3, uid=4, controller=6
+	for _, f := range meta[13] {
+		ref := protoMessage(f.Bytes)
+		o.Owners = append(o.Owners, Owner{
+			Kind:       protoString(ref, 1),
+			Name:       protoString(ref, 3),
+			UID:        protoString(ref, 4),
+			Controller: protoInt(ref, 6) != 0,
+		})
+	}
+
+	switch o.Kind {
+	case "Pod":
+		// Pod: spec=2, status=3
+		o.decodePodSpec(protoEmbedded(raw, 2))
+
+		// PodStatus: phase=1, containerStatuses=8, initContainerStatuses=10
+		status := protoEmbedded(raw, 3)
+		o.Phase = protoString(status, 1)
+		for _, number := range []int{10, 8} {
+			for _, f := range status[number] {
+				// ContainerStatus: name=1, image=6, imageID=7, containerID=8
+				cs := protoMessage(f.Bytes)
+				o.Containers = append(o.Containers, ContainerStatus{
+					Name:        protoString(cs, 1),
+					Image:       protoString(cs, 6),
+					ImageID:     protoString(cs, 7),
+					ContainerID: protoString(cs, 8),
+				})
+			}
+		}
+	case "Deployment", "ReplicaSet", "StatefulSet":
+		// spec=2 {template=3 {spec=2}}
+		o.decodePodSpec(protoEmbedded(protoEmbedded(protoEmbedded(raw, 2), 3), 2))
+	case "DaemonSet":
+		// spec=2 {template=2 {spec=2}}
+		o.decodePodSpec(protoEmbedded(protoEmbedded(protoEmbedded(raw, 2), 2), 2))
+	case "Job":
+		// spec=2 {template=6 {spec=2}}
+		o.decodePodSpec(protoEmbedded(protoEmbedded(protoEmbedded(raw, 2), 6), 2))
+	case "CronJob":
+		// spec=2 {jobTemplate=5 {spec=2 {template=6 {spec=2}}}}
+		job := protoEmbedded(protoEmbedded(protoEmbedded(raw, 2), 5), 2)
+		o.decodePodSpec(protoEmbedded(protoEmbedded(job, 6), 2))
+	case "ServiceAccount":
+		// ServiceAccount: imagePullSecrets=3 {name=1}
+		for _, f := range raw[3] {
+			if name := protoString(protoMessage(f.Bytes), 1); name != "" {
+				o.PullSecrets = append(o.PullSecrets, name)
+			}
+		}
+	case "Secret":
+		// Secret: type=3. The secret data is not decoded.
+		o.SecretType = protoString(raw, 3)
+	}
+}
+
+// decodePodSpec decodes the pod node name, service account, images, and
+// image pull secrets.
+//
+// PodSpec: containers=2, serviceAccountName=8, nodeName=10,
+// imagePullSecrets=15, initContainers=20, ephemeralContainers=34
+func (o *Object) decodePodSpec(podspec map[int][]protoField) {
+	o.NodeName = protoString(podspec, 10)
+	o.ServiceAccount = protoString(podspec, 8)
+	for _, number := range []int{20, 2, 34} {
+		for _, f := range podspec[number] {
+			// Container: name=1, image=2, imagePullPolicy=14.
+			// EphemeralContainer embeds the common fields in field 1.
+			c := protoMessage(f.Bytes)
+			if number == 34 {
+				c = protoEmbedded(c, 1)
+			}
+			if image := protoString(c, 2); image != "" {
+				o.Images = append(o.Images, image)
+				o.ContainerSpecs = append(o.ContainerSpecs, ContainerSpec{
+					Name:            protoString(c, 1),
+					Image:           image,
+					ImagePullPolicy: protoString(c, 14),
+				})
+			}
+		}
+	}
+
+	// LocalObjectReference: name=1
+	for _, f := range podspec[15] {
+		if name := protoString(protoMessage(f.Bytes), 1); name != "" {
+			o.PullSecrets = append(o.PullSecrets, name)
+		}
+	}
+}
+
+// jsonObject maps the attributes of a JSON encoded object such as a custom
+// resource.
+type jsonObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name              string            `json:"name"`
+		Namespace         string            `json:"namespace"`
+		UID               string            `json:"uid"`
+		CreationTimestamp time.Time         `json:"creationTimestamp"`
+		DeletionTimestamp time.Time         `json:"deletionTimestamp"`
+		Labels            map[string]string `json:"labels"`
+		OwnerReferences   []struct {
+			Kind       string `json:"kind"`
+			Name       string `json:"name"`
+			UID        string `json:"uid"`
+			Controller bool   `json:"controller"`
+		} `json:"ownerReferences"`
+	} `json:"metadata"`
+	Type string `json:"type"`
+}
+
+// decodeJSON decodes the metadata of a JSON encoded object.
+func (o *Object) decodeJSON(data []byte) {
+	var v jsonObject
+	if err := json.Unmarshal(data, &v); err != nil {
+		return
+	}
+
+	o.APIVersion = v.APIVersion
+	o.Kind = v.Kind
+	if v.Metadata.Name != "" {
+		o.Name = v.Metadata.Name
+	}
+	if v.Metadata.Namespace != "" {
+		o.Namespace = v.Metadata.Namespace
+	}
+	o.UID = v.Metadata.UID
+	o.CreatedAt = v.Metadata.CreationTimestamp
+	o.DeletedAt = v.Metadata.DeletionTimestamp
+	o.Labels = v.Metadata.Labels
+	for _, ref := range v.Metadata.OwnerReferences {
+		o.Owners = append(o.Owners, Owner{
+			Kind:       ref.Kind,
+			Name:       ref.Name,
+			UID:        ref.UID,
+			Controller: ref.Controller,
+		})
+	}
+	if v.Kind == "Secret" {
+		o.SecretType = v.Type
+	}
+}
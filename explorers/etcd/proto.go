@@ -0,0 +1,157 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoField holds a decoded protobuf field. Varint and fixed values are
+// stored in Value and length delimited values in Bytes.
+type protoField struct {
+	Number int
+	Type   int
+	Value  uint64
+	Bytes  []byte
+}
+
+// protoFields returns the top-level fields of a protobuf message.
+//
+// The Kubernetes and etcd messages are decoded by field number to avoid
+// depending on the generated Kubernetes API types.
+func protoFields(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fields, fmt.Errorf("invalid field tag")
+		}
+		data = data[n:]
+
+		f := protoField{
+			Number: int(tag >> 3),
+			Type:   int(tag & 7),
+		}
+		switch f.Type {
+		case wireVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return fields, fmt.Errorf("invalid varint in field %d", f.Number)
+			}
+			f.Value = v
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return fields, fmt.Errorf("truncated field %d", f.Number)
+			}
+			f.Value = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return fields, fmt.Errorf("truncated field %d", f.Number)
+			}
+			f.Bytes = data[n : n+int(l)]
+			data = data[n+int(l):]
+		case wireFixed32:
+			if len(data) < 4 {
+				return fields, fmt.Errorf("truncated field %d", f.Number)
+			}
+			f.Value = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		default:
+			return fields, fmt.Errorf("unsupported wire type %d in field %d", f.Type, f.Number)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// protoMessage returns the fields of a message grouped by field number.
+// Decoding errors are ignored and the fields decoded so far are returned.
+func protoMessage(data []byte) map[int][]protoField {
+	fields, _ := protoFields(data)
+
+	m := make(map[int][]protoField)
+	for _, f := range fields {
+		m[f.Number] = append(m[f.Number], f)
+	}
+	return m
+}
+
+// protoString returns the last value of a string field.
+func protoString(m map[int][]protoField, number int) string {
+	if fields := m[number]; len(fields) > 0 {
+		return string(fields[len(fields)-1].Bytes)
+	}
+	return ""
+}
+
+// protoStrings returns the values of a repeated string field.
+func protoStrings(m map[int][]protoField, number int) []string {
+	var values []string
+	for _, f := range m[number] {
+		values = append(values, string(f.Bytes))
+	}
+	return values
+}
+
+// protoInt returns the last value of a varint field.
+func protoInt(m map[int][]protoField, number int) int64 {
+	if fields := m[number]; len(fields) > 0 {
+		return int64(fields[len(fields)-1].Value)
+	}
+	return 0
+}
+
+// protoEmbedded returns the fields of the last embedded message field.
+func protoEmbedded(m map[int][]protoField, number int) map[int][]protoField {
+	if fields := m[number]; len(fields) > 0 {
+		return protoMessage(fields[len(fields)-1].Bytes)
+	}
+	return map[int][]protoField{}
+}
+
+// protoMap returns the values of a map<string, string> field.
+func protoMap(m map[int][]protoField, number int) map[string]string {
+	values := make(map[string]string)
+	for _, f := range m[number] {
+		entry := protoMessage(f.Bytes)
+		values[protoString(entry, 1)] = protoString(entry, 2)
+	}
+	return values
+}
+
+// protoTime returns the value of a Kubernetes metav1.Time field.
+func protoTime(m map[int][]protoField, number int) time.Time {
+	fields, found := m[number]
+	if !found {
+		return time.Time{}
+	}
+	t := protoMessage(fields[len(fields)-1].Bytes)
+	return time.Unix(protoInt(t, 1), protoInt(t, 2)).UTC()
+}
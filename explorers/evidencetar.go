@@ -0,0 +1,178 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// paxXattrPrefix is the PAX record prefix of an extended attribute.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// overlayXattrPrefixes are the prefixes of the overlayfs private extended
+// attributes. The attributes describe the overlay and not the file.
+var overlayXattrPrefixes = []string{"trusted.overlay.", "user.overlay."}
+
+// WriteEvidenceTar writes the merged view of the layers to a deterministic
+// tar stream.
+//
+// The entries are written in lexical order using the PAX format. The owners
+// are numeric, the access and change times are omitted, and the extended
+// attributes i.e. security.selinux and security.capability are preserved.
+// The same layers always produce the same tar stream.
+func WriteEvidenceTar(w io.Writer, layers []string) error {
+	tw := tar.NewWriter(w)
+
+	err := WalkLayers(layers, func(f LayerFile) error {
+		return writeEvidenceEntry(tw, strings.TrimPrefix(f.Path, "/"), f.LayerPath, f.Info)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// WriteLayerDiffTar writes a single overlay layer i.e. the upper layer of a
+// container to a deterministic tar stream like WriteEvidenceTar.
+//
+// The overlayfs whiteouts are translated to the .wh. whiteout files and the
+// opaque directories to the .wh..wh..opq files of the OCI image layers, so
+// the files deleted in the layer are recorded in the archive.
+func WriteLayerDiffTar(w io.Writer, layer string) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(layer, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(layer, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+
+		if isWhiteout(info) {
+			whiteout := filepath.ToSlash(filepath.Join(filepath.Dir(rel), whiteoutPrefix+info.Name()))
+			return writeWhiteoutEntry(tw, whiteout, info.ModTime())
+		}
+
+		if err := writeEvidenceEntry(tw, name, path, info); err != nil {
+			return err
+		}
+		if info.IsDir() && isOpaqueDir(path) {
+			return writeWhiteoutEntry(tw, name+"/"+whiteoutOpaqueDir, info.ModTime())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// writeEvidenceEntry writes a file to a tar stream with numeric owners and
+// the extended attributes. A file that cannot be opened is skipped.
+func writeEvidenceEntry(tw *tar.Writer, name string, path string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			log.WithField("path", path).Warn("skipping symbolic link: ", err)
+			return nil
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		log.WithField("path", path).Warn("skipping file: ", err)
+		return nil
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Format = tar.FormatPAX
+	hdr.Uname = ""
+	hdr.Gname = ""
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+
+	stat := StatInfo(info)
+	hdr.Uid = int(stat.UID)
+	hdr.Gid = int(stat.GID)
+
+	for attr, value := range fileXattrs(path) {
+		if isOverlayXattr(attr) {
+			continue
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords[paxXattrPrefix+attr] = value
+	}
+
+	if !info.Mode().IsRegular() {
+		return tw.WriteHeader(hdr)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.WithField("path", path).Warn("skipping file: ", err)
+		return nil
+	}
+	defer f.Close()
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeWhiteoutEntry writes an empty OCI whiteout file to a tar stream.
+func writeWhiteoutEntry(tw *tar.Writer, name string, modtime time.Time) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		ModTime:  modtime,
+		Format:   tar.FormatPAX,
+	})
+}
+
+// isOverlayXattr returns true if the extended attribute is private to
+// overlayfs.
+func isOverlayXattr(attr string) bool {
+	for _, prefix := range overlayXattrPrefixes {
+		if strings.HasPrefix(attr, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,416 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ewf provides a pure Go reader of the Expert Witness Compression
+// Format (EWF) forensic images i.e. image.E01.
+//
+// The EWF version 1 format written by EnCase and FTK Imager is supported
+// including split segment files. The EWF version 2 format i.e. image.Ex01
+// is detected but is only read using the ewfmount tool of libewf.
+package ewf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// evfSignature is the signature of an EWF version 1 segment file.
+	evfSignature = []byte("EVF\x09\x0d\x0a\xff\x00")
+
+	// lvfSignature is the signature of an EWF version 1 logical evidence
+	// file i.e. image.L01.
+	lvfSignature = []byte("LVF\x09\x0d\x0a\xff\x00")
+
+	// evf2Signature is the signature of an EWF version 2 segment file.
+	evf2Signature = []byte("EVF2\x0d\x0a\x81\x00")
+)
+
+const (
+	fileHeaderSize        = 13
+	sectionDescriptorSize = 76
+
+	// compressedChunk is the table entry flag of a compressed chunk.
+	compressedChunk = 0x80000000
+)
+
+// Format returns the EWF format version of the file or 0 if the file is not
+// an EWF segment file.
+func Format(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(f, signature); err != nil {
+		return 0
+	}
+	switch {
+	case bytes.Equal(signature, evfSignature):
+		return 1
+	case bytes.Equal(signature, evf2Signature):
+		return 2
+	}
+	return 0
+}
+
+// chunk is the location of a chunk in a segment file.
+type chunk struct {
+	segment    int
+	offset     int64
+	size       int64
+	compressed bool
+}
+
+// Image is an EWF version 1 image. The image implements io.ReaderAt over
+// the media data.
+type Image struct {
+	segments  []*os.File
+	chunks    []chunk
+	chunkSize int64
+	size      int64
+
+	// MD5 is the MD5 hash of the media data stored in the image or an empty
+	// string.
+	MD5 string
+
+	mu         sync.Mutex
+	cacheIndex int
+	cacheData  []byte
+}
+
+// Open opens an EWF version 1 image using the first segment file i.e.
+// image.E01.
+//
+// The remaining segment files i.e. image.E02 or image.EAA are found next to
+// the first segment file and ordered using the segment number in the file
+// header.
+func Open(path string) (*Image, error) {
+	paths, err := segmentPaths(path)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &Image{cacheIndex: -1}
+	for i, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			img.Close()
+			return nil, err
+		}
+		img.segments = append(img.segments, f)
+
+		if err := img.readSegment(i, f); err != nil {
+			img.Close()
+			return nil, fmt.Errorf("reading segment file %s: %w", p, err)
+		}
+	}
+
+	if img.chunkSize == 0 {
+		img.Close()
+		return nil, fmt.Errorf("volume section not found in %s", path)
+	}
+	if max := int64(len(img.chunks)) * img.chunkSize; img.size == 0 || img.size > max {
+		img.size = max
+	}
+
+	log.WithFields(log.Fields{
+		"path":      path,
+		"segments":  len(img.segments),
+		"chunks":    len(img.chunks),
+		"chunksize": img.chunkSize,
+		"size":      img.size,
+	}).Debug("opened EWF image")
+	return img, nil
+}
+
+// segmentPaths returns the segment files of an image ordered by segment
+// number.
+func segmentPaths(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	if len(ext) != 4 {
+		return []string{path}, nil
+	}
+
+	// The segment files share the base name and the first letter of the
+	// extension i.e. E01 to E99 and then EAA to EZZ.
+	base := strings.TrimSuffix(path, ext)
+	matches, err := filepath.Glob(base + ext[:2] + "??")
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make(map[string]int)
+	var paths []string
+	for _, m := range matches {
+		n, err := segmentNumber(m)
+		if err != nil {
+			continue
+		}
+		numbers[m] = n
+		paths = append(paths, m)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s is not an EWF segment file", path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return numbers[paths[i]] < numbers[paths[j]]
+	})
+
+	for i, p := range paths {
+		if numbers[p] != i+1 {
+			return nil, fmt.Errorf("segment file %d missing before %s", i+1, p)
+		}
+	}
+	return paths, nil
+}
+
+// segmentNumber returns the segment number in the file header.
+func segmentNumber(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	header := make([]byte, fileHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(header[:8], evfSignature) && !bytes.Equal(header[:8], lvfSignature) {
+		return 0, fmt.Errorf("invalid EWF signature")
+	}
+	return int(binary.LittleEndian.Uint16(header[9:])), nil
+}
+
+// readSegment reads the section descriptors of a segment file.
+func (img *Image) readSegment(segment int, f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var (
+		offset      = int64(fileHeaderSize)
+		sectorsEnd  int64
+		descriptor  = make([]byte, sectionDescriptorSize)
+		sectionType string
+	)
+	for offset+sectionDescriptorSize <= info.Size() {
+		if _, err := f.ReadAt(descriptor, offset); err != nil {
+			return err
+		}
+		sectionType = string(bytes.TrimRight(descriptor[:16], "\x00"))
+		next := int64(binary.LittleEndian.Uint64(descriptor[16:]))
+		size := int64(binary.LittleEndian.Uint64(descriptor[24:]))
+		data := offset + sectionDescriptorSize
+
+		switch sectionType {
+		case "volume", "disk":
+			if err := img.readVolume(f, data, size-sectionDescriptorSize); err != nil {
+				return err
+			}
+		case "sectors":
+			sectorsEnd = offset + size
+		case "table":
+			if err := img.readTable(segment, f, data, sectorsEnd); err != nil {
+				return err
+			}
+		case "hash":
+			digest := make([]byte, 16)
+			if _, err := f.ReadAt(digest, data); err == nil {
+				img.MD5 = fmt.Sprintf("%x", digest)
+			}
+		}
+
+		if sectionType == "next" || sectionType == "done" || next <= offset {
+			break
+		}
+		offset = next
+	}
+
+	if sectionType != "next" && sectionType != "done" {
+		log.WithFields(log.Fields{
+			"segment": segment + 1,
+			"section": sectionType,
+		}).Warn("EWF segment file is truncated")
+	}
+	return nil
+}
+
+// readVolume reads the chunk size and media size of the volume section.
+//
+// The EnCase volume section is 1052 bytes and the SMART volume section is
+// 94 bytes.
+func (img *Image) readVolume(f *os.File, offset int64, size int64) error {
+	data := make([]byte, 24)
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return fmt.Errorf("reading volume section: %w", err)
+	}
+
+	sectorsPerChunk := int64(binary.LittleEndian.Uint32(data[8:]))
+	bytesPerSector := int64(binary.LittleEndian.Uint32(data[12:]))
+	img.chunkSize = sectorsPerChunk * bytesPerSector
+
+	var sectors int64
+	if size == 94 {
+		sectors = int64(binary.LittleEndian.Uint32(data[16:]))
+	} else {
+		sectors = int64(binary.LittleEndian.Uint64(data[16:]))
+	}
+	img.size = sectors * bytesPerSector
+
+	if img.chunkSize <= 0 || img.chunkSize > 64*1024*1024 {
+		return fmt.Errorf("invalid chunk size %d", img.chunkSize)
+	}
+	return nil
+}
+
+// readTable reads the chunk offsets of a table section.
+//
+// The offsets are relative to the base offset in the table header. The
+// size of a chunk is the distance to the next chunk. The last chunk ends at
+// the end of the sectors section.
+func (img *Image) readTable(segment int, f *os.File, offset int64, sectorsEnd int64) error {
+	header := make([]byte, 24)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return fmt.Errorf("reading table section: %w", err)
+	}
+	count := int(binary.LittleEndian.Uint32(header))
+	base := int64(binary.LittleEndian.Uint64(header[8:]))
+	if count > 1<<24 {
+		return fmt.Errorf("invalid table entry count %d", count)
+	}
+
+	entries := make([]byte, count*4)
+	if _, err := f.ReadAt(entries, offset+24); err != nil {
+		return fmt.Errorf("reading table entries: %w", err)
+	}
+
+	chunks := make([]chunk, count)
+	for i := range chunks {
+		entry := binary.LittleEndian.Uint32(entries[i*4:])
+		chunks[i] = chunk{
+			segment:    segment,
+			offset:     base + int64(entry&^compressedChunk),
+			compressed: entry&compressedChunk != 0,
+		}
+	}
+	for i := range chunks {
+		end := sectorsEnd
+		if i+1 < len(chunks) {
+			end = chunks[i+1].offset
+		}
+		chunks[i].size = end - chunks[i].offset
+	}
+
+	img.chunks = append(img.chunks, chunks...)
+	return nil
+}
+
+// Size returns the size of the media data.
+func (img *Image) Size() int64 {
+	return img.size
+}
+
+// ReadAt reads the media data at the offset.
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off >= img.size {
+		return 0, io.EOF
+	}
+
+	var n int
+	for n < len(p) && off < img.size {
+		index := int(off / img.chunkSize)
+		data, err := img.readChunk(index)
+		if err != nil {
+			return n, err
+		}
+
+		c := copy(p[n:], data[off%img.chunkSize:])
+		if remaining := img.size - off; int64(c) > remaining {
+			c = int(remaining)
+		}
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readChunk returns the decompressed data of a chunk. The last chunk read is
+// cached.
+func (img *Image) readChunk(index int) ([]byte, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+
+	if index == img.cacheIndex {
+		return img.cacheData, nil
+	}
+	if index >= len(img.chunks) {
+		return nil, io.EOF
+	}
+
+	c := img.chunks[index]
+	if c.size <= 0 || c.size > img.chunkSize*2 {
+		return nil, fmt.Errorf("invalid size %d of chunk %d", c.size, index)
+	}
+	raw := make([]byte, c.size)
+	if _, err := img.segments[c.segment].ReadAt(raw, c.offset); err != nil {
+		return nil, fmt.Errorf("reading chunk %d: %w", index, err)
+	}
+
+	data := make([]byte, img.chunkSize)
+	if c.compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing chunk %d: %w", index, err)
+		}
+		// The data of a short chunk is padded using zero bytes.
+		if _, err := io.ReadFull(zr, data); err != nil && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("decompressing chunk %d: %w", index, err)
+		}
+	} else {
+		// An uncompressed chunk is followed by the Adler-32 checksum.
+		copy(data, raw)
+	}
+
+	img.cacheIndex = index
+	img.cacheData = data
+	return data, nil
+}
+
+// Close closes the segment files.
+func (img *Image) Close() error {
+	for _, f := range img.segments {
+		f.Close()
+	}
+	return nil
+}
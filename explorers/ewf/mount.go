@@ -0,0 +1,50 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ewf
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ewfmountCommand exposes the media data of an EWF image as a raw file using
+// FUSE. It supports both EWF format versions.
+const ewfmountCommand = "ewfmount"
+
+// Mount exposes the media data of the EWF image as a raw file using
+// ewfmount and returns the path of the raw file.
+func Mount(path string, mountpoint string) (string, error) {
+	command, err := exec.LookPath(ewfmountCommand)
+	if err != nil {
+		return "", fmt.Errorf("%s is not installed: %w", ewfmountCommand, err)
+	}
+
+	out, err := exec.Command(command, path, mountpoint).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("running %s: %v %s", ewfmountCommand, err, strings.TrimSpace(string(out)))
+	}
+
+	// ewfmount names the raw file ewf1.
+	raw := filepath.Join(mountpoint, "ewf1")
+	if _, err := os.Stat(raw); err != nil {
+		return "", fmt.Errorf("%s did not expose the media data: %w", ewfmountCommand, err)
+	}
+	return raw, nil
+}
@@ -42,6 +42,9 @@ type ContainerExplorer interface {
 	// ListImages returns content information
 	ListImages(ctx context.Context) ([]Image, error)
 
+	// InspectImage returns the configuration and layers of an image
+	InspectImage(ctx context.Context, name string) (ImageDetail, error)
+
 	// ListSnapshots returns the snapshot information
 	ListSnapshots(ctx context.Context) ([]SnapshotKeyInfo, error)
 
@@ -51,14 +54,23 @@ type ContainerExplorer interface {
 	// ListTasks returns the container task status
 	ListTasks(ctx context.Context) ([]Task, error)
 
+	// ListLeases returns the containerd leases
+	ListLeases(ctx context.Context) ([]Lease, error)
+
 	// InfoContainer returns container internal information
 	InfoContainer(ctx context.Context, containerid string, spec bool) (interface{}, error)
 
 	// MountContainer mounts a container to the specified path
 	MountContainer(ctx context.Context, containerid string, mountpoint string) error
 
-	// MountAllContainer mounts all containers to the specfied path
-	MountAllContainers(ctx context.Context, mountpoint string, skipsupportcontainers bool) error
+	// ContainerLayers returns the container's upper (writable) layer directory
+	// and the lower (image) layer directories ordered from top to bottom.
+	ContainerLayers(ctx context.Context, containerid string) (string, []string, error)
+
+	// MountAllContainer mounts all containers to the specfied path. A
+	// container failing to mount does not stop the run and is recorded
+	// with the reason in the returned mount index entries.
+	MountAllContainers(ctx context.Context, mountpoint string, opts MountAllOptions) ([]MountIndexEntry, error)
 
 	// Close releases the internal resources
 	Close() error
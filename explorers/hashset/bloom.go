@@ -0,0 +1,185 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hashset
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+)
+
+// bloomMagic is the signature of a Bloom filter written by WriteTo.
+var bloomMagic = []byte("CEBLOOM\x01")
+
+// maxBloomBits limits the memory used by a Bloom filter to 4 GiB.
+const maxBloomBits = 1 << 35
+
+func init() {
+	Register("bloom", openBloom)
+}
+
+// Bloom is a Bloom filter of file hashes.
+//
+// A Bloom filter has no false negatives and a configurable false positive
+// rate, so a large hash set i.e. NSRL is held in a fraction of the memory.
+// A hash is added using the decoded bytes, so the hash is found regardless
+// of the hex case.
+//
+// The file format is the signature, the number of hash functions (uint32),
+// the number of bits (uint64), the number of hashes (uint64), and the bits
+// (uint64 words) in little endian.
+type Bloom struct {
+	k    uint32
+	m    uint64
+	n    uint64
+	bits []uint64
+}
+
+// NewBloom returns an empty Bloom filter sized for n hashes with the false
+// positive rate i.e. 0.000001.
+func NewBloom(n int, rate float64) (*Bloom, error) {
+	if n < 1 {
+		n = 1
+	}
+	if rate <= 0 || rate >= 1 {
+		return nil, fmt.Errorf("invalid false positive rate %v", rate)
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	if m > maxBloomBits {
+		return nil, fmt.Errorf("Bloom filter of %d hashes is larger than 4 GiB. Increase the false positive rate", n)
+	}
+	if m < 64 {
+		m = 64
+	}
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Bloom{
+		k:    k,
+		m:    m,
+		bits: make([]uint64, (m+63)/64),
+	}, nil
+}
+
+// openBloom reads a Bloom filter file.
+func openBloom(path string) (Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, len(bloomMagic)+20)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading Bloom filter header: %w", err)
+	}
+	if string(header[:len(bloomMagic)]) != string(bloomMagic) {
+		return nil, fmt.Errorf("invalid Bloom filter signature")
+	}
+
+	b := &Bloom{
+		k: binary.LittleEndian.Uint32(header[8:]),
+		m: binary.LittleEndian.Uint64(header[12:]),
+		n: binary.LittleEndian.Uint64(header[20:]),
+	}
+	if b.k == 0 || b.k > 64 || b.m == 0 || b.m > maxBloomBits {
+		return nil, fmt.Errorf("invalid Bloom filter parameters k=%d m=%d", b.k, b.m)
+	}
+
+	b.bits = make([]uint64, (b.m+63)/64)
+	if err := binary.Read(r, binary.LittleEndian, b.bits); err != nil {
+		return nil, fmt.Errorf("reading Bloom filter: %w", err)
+	}
+	return b, nil
+}
+
+// Add adds a hex encoded hash. A value that is not hex encoded is ignored.
+func (b *Bloom) Add(sum string) {
+	h1, h2, ok := bloomHashes(sum)
+	if !ok {
+		return
+	}
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+	b.n++
+}
+
+// Contains returns true if the hash may be in the set.
+func (b *Bloom) Contains(sum string) bool {
+	h1, h2, ok := bloomHashes(sum)
+	if !ok {
+		return false
+	}
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo writes the Bloom filter file.
+func (b *Bloom) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, len(bloomMagic)+20)
+	copy(header, bloomMagic)
+	binary.LittleEndian.PutUint32(header[8:], b.k)
+	binary.LittleEndian.PutUint64(header[12:], b.m)
+	binary.LittleEndian.PutUint64(header[20:], b.n)
+	if _, err := bw.Write(header); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, b.bits); err != nil {
+		return 0, err
+	}
+	return int64(len(header) + len(b.bits)*8), bw.Flush()
+}
+
+// Len returns the number of hashes added.
+func (b *Bloom) Len() uint64 {
+	return b.n
+}
+
+// bloomHashes returns the two hashes of the decoded hash used for double
+// hashing. The second hash is odd.
+func bloomHashes(sum string) (uint64, uint64, bool) {
+	data, err := hex.DecodeString(sum)
+	if err != nil || len(data) == 0 {
+		return 0, 0, false
+	}
+	h1 := fnv.New64a()
+	h1.Write(data)
+	h2 := fnv.New64()
+	h2.Write(data)
+	return h1.Sum64(), h2.Sum64() | 1, true
+}
+
+// ReadHashes calls fn for each hex encoded hash in a CSV or text hash list.
+func ReadHashes(r io.Reader, fn func(sum string)) error {
+	return readHashes(r, fn)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hashset
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+func init() {
+	Register("csv", openCSV)
+}
+
+// memorySet is a hash set held in memory.
+type memorySet map[string]struct{}
+
+// Contains returns true if the set contains the hash.
+func (s memorySet) Contains(sum string) bool {
+	_, found := s[sum]
+	return found
+}
+
+// openCSV reads a CSV or text hash list.
+//
+// Every field of a line that is a hex encoded hash is added to the set, so
+// the hash lists exported by most tools are read without configuration i.e.
+// md5sum output, a CSV with a header, or a list with one hash per line.
+func openCSV(path string) (Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return openCSVReader(f)
+}
+
+// openCSVReader reads a CSV or text hash list from r.
+func openCSVReader(r io.Reader) (Set, error) {
+	set := make(memorySet)
+	if err := readHashes(r, func(sum string) {
+		set[sum] = struct{}{}
+	}); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// readHashes calls fn for each hash field in the lines read from r.
+func readHashes(r io.Reader, fn func(sum string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.FieldsFunc(scanner.Text(), func(c rune) bool {
+			return c == ',' || c == ';' || c == '\t' || c == ' ' || c == '|'
+		})
+		for _, field := range fields {
+			field = strings.ToLower(strings.Trim(field, `"'*`))
+			if isHash(field) {
+				fn(field)
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package hashset provides the registry of hash set backends used to triage
+// file hashes into known-good, known-bad, and unknown files.
+//
+// A backend opens a hash set file i.e. an NSRL RDS file, a CSV hash list, or
+// a Bloom filter. External packages add backends by calling Register in an
+// init function and are enabled using a blank import:
+//
+//	import _ "example.com/vendor/hashlookup"
+package hashset
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Verdicts of a file hash.
+const (
+	KnownGood = "known-good"
+	KnownBad  = "known-bad"
+	Unknown   = "unknown"
+)
+
+// Set is a set of file hashes.
+type Set interface {
+	// Contains returns true if the set contains the lowercase hex encoded
+	// MD5, SHA-1, or SHA-256 hash.
+	Contains(sum string) bool
+}
+
+// OpenFunc opens a hash set file.
+type OpenFunc func(path string) (Set, error)
+
+var (
+	mu       sync.RWMutex
+	backends = make(map[string]OpenFunc)
+)
+
+// Register makes a hash set backend available by name i.e. nsrl.
+//
+// Register panics if the function is nil or a backend with the same name is
+// already registered.
+func Register(name string, open OpenFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if open == nil {
+		panic("hashset: Register open function is nil")
+	}
+	if _, found := backends[name]; found {
+		panic(fmt.Sprintf("hashset: Register called twice for backend %s", name))
+	}
+	backends[name] = open
+}
+
+// Names returns the sorted names of the registered backends.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens a hash set specified as <backend>:<path> or <path>.
+//
+// The backend of a path without a backend name is detected from the file
+// content i.e. the Bloom filter signature, the SQLite signature of NSRL
+// RDSv3, or the header of the NSRL RDS 2.x NSRLFile.txt. Other files are
+// read as CSV hash lists.
+func Open(spec string) (Set, error) {
+	backend, path := "", spec
+	if i := strings.Index(spec, ":"); i > 0 {
+		mu.RLock()
+		_, found := backends[spec[:i]]
+		mu.RUnlock()
+		if found {
+			backend, path = spec[:i], spec[i+1:]
+		}
+	}
+	if backend == "" {
+		backend = detectBackend(path)
+	}
+
+	mu.RLock()
+	open, found := backends[backend]
+	mu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unsupported hash set backend %s", backend)
+	}
+
+	set, err := open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s hash set %s: %w", backend, path, err)
+	}
+	log.WithFields(log.Fields{
+		"backend": backend,
+		"path":    path,
+	}).Debug("opened hash set")
+	return set, nil
+}
+
+// detectBackend returns the backend name of a hash set file.
+func detectBackend(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return "csv"
+	}
+	defer f.Close()
+
+	header := make([]byte, 64)
+	n, _ := io.ReadFull(f, header)
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, bloomMagic):
+		return "bloom"
+	case bytes.HasPrefix(header, sqliteMagic):
+		return "nsrl"
+	case bytes.HasPrefix(header, []byte(`"SHA-1"`)):
+		return "nsrl"
+	}
+	return "csv"
+}
+
+// namedSet is a hash set with the verdict of the hashes in the set.
+type namedSet struct {
+	name    string
+	verdict string
+	set     Set
+}
+
+// Matcher classifies file hashes using the known-good and known-bad hash
+// sets.
+type Matcher struct {
+	sets []namedSet
+}
+
+// NewMatcher opens the known-good and known-bad hash sets.
+//
+// The known-bad sets are checked first, so a hash in both a known-bad and a
+// known-good set is known-bad.
+func NewMatcher(good []string, bad []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, specs := range []struct {
+		verdict string
+		specs   []string
+	}{
+		{KnownBad, bad},
+		{KnownGood, good},
+	} {
+		for _, spec := range specs.specs {
+			set, err := Open(spec)
+			if err != nil {
+				return nil, err
+			}
+			m.sets = append(m.sets, namedSet{
+				name:    setName(spec),
+				verdict: specs.verdict,
+				set:     set,
+			})
+		}
+	}
+	return m, nil
+}
+
+// setName returns the file name of a hash set specification.
+func setName(spec string) string {
+	if i := strings.Index(spec, ":"); i > 0 {
+		mu.RLock()
+		_, found := backends[spec[:i]]
+		mu.RUnlock()
+		if found {
+			spec = spec[i+1:]
+		}
+	}
+	return filepath.Base(spec)
+}
+
+// Empty returns true if the matcher has no hash sets.
+func (m *Matcher) Empty() bool {
+	return m == nil || len(m.sets) == 0
+}
+
+// Classify returns the verdict of a file and the name of the matching hash
+// set. The hashes are the hex encoded MD5, SHA-1, or SHA-256 of the file.
+func (m *Matcher) Classify(sums ...string) (string, string) {
+	if m == nil {
+		return Unknown, ""
+	}
+	for _, s := range m.sets {
+		for _, sum := range sums {
+			if sum != "" && s.set.Contains(strings.ToLower(sum)) {
+				return s.verdict, s.name
+			}
+		}
+	}
+	return Unknown, ""
+}
+
+// isHash returns true if the value is a hex encoded MD5, SHA-1, SHA-256, or
+// SHA-512 hash.
+func isHash(value string) bool {
+	switch len(value) {
+	case 32, 40, 64, 128:
+	default:
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
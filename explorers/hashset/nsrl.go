@@ -0,0 +1,87 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hashset
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// sqliteMagic is the signature of an SQLite database i.e. the NSRL RDSv3
+// RDS_<version>_modern.db.
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// nsrlQuery selects the hashes of the NSRL RDSv3 FILE table.
+const nsrlQuery = "SELECT sha256, sha1, md5 FROM FILE"
+
+func init() {
+	Register("nsrl", openNSRL)
+}
+
+// openNSRL reads the SHA-1 and MD5 hashes of the NSRL RDS 2.x NSRLFile.txt
+// or the SHA-256, SHA-1, and MD5 hashes of an NSRL RDSv3 database.
+//
+// The RDSv3 database is read using the sqlite3 command of the analysis host.
+// The full NSRL sets use several GiB of memory. Build a Bloom filter using
+// tools bloom to reduce the memory use.
+func openNSRL(path string) (Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(sqliteMagic))
+	if _, err := f.Read(header); err != nil {
+		return nil, err
+	}
+	if string(header) != string(sqliteMagic) {
+		if _, err := f.Seek(0, 0); err != nil {
+			return nil, err
+		}
+		return openCSVReader(f)
+	}
+
+	sqlite, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return nil, fmt.Errorf("reading NSRL RDSv3 requires sqlite3: %w", err)
+	}
+	cmd := exec.Command(sqlite, "-readonly", "-csv", path, nsrlQuery)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	set := make(memorySet)
+	rerr := readHashes(out, func(sum string) {
+		set[sum] = struct{}{}
+	})
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("running sqlite3: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if rerr != nil {
+		return nil, rerr
+	}
+	return set, nil
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Hook stages of the OCI runtime spec.
+const (
+	HookPrestart        = "prestart"
+	HookCreateRuntime   = "createRuntime"
+	HookCreateContainer = "createContainer"
+	HookStartContainer  = "startContainer"
+	HookPoststart       = "poststart"
+	HookPoststop        = "poststop"
+)
+
+// Hook file states.
+const (
+	HookPackaged   = "packaged"   // owned by a package with a matching digest
+	HookModified   = "modified"   // owned by a package with a different digest
+	HookUnpackaged = "unpackaged" // not owned by a package
+	HookMissing    = "missing"    // not found in the evidence
+)
+
+// hookConfigDirs are the directories of the OCI hook configuration files
+// read by CRI-O and Podman i.e. the NVIDIA container toolkit hook.
+var hookConfigDirs = []string{
+	"/usr/share/containers/oci/hooks.d",
+	"/etc/containers/oci/hooks.d",
+}
+
+// Hook is a hook run by the OCI runtime.
+type Hook struct {
+	Stage   string   `json:"stage"`
+	Path    string   `json:"path"`
+	Args    []string `json:"args,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Timeout int      `json:"timeout,omitempty"`
+	Source  string   `json:"source"`         // container spec or hook configuration file
+	Field   string   `json:"field"`          // field of the source i.e. hooks.prestart[0]
+	When    string   `json:"when,omitempty"` // conditions of a hook configuration file
+}
+
+// HookFile is the executable of a hook found in the evidence.
+type HookFile struct {
+	Path        string    `json:"path"`                  // path after resolving the symbolic links
+	DiskPath    string    `json:"disk_path,omitempty"`   // file in the image root or in a layer
+	Status      string    `json:"status"`                // packaged, modified, unpackaged, or missing
+	Package     string    `json:"package,omitempty"`     // owning package
+	Size        int64     `json:"size"`                  // size in bytes
+	ModTime     time.Time `json:"mod_time"`              // modification time
+	SHA256      string    `json:"sha256,omitempty"`      // SHA256 digest
+	Interpreter string    `json:"interpreter,omitempty"` // interpreter of a script i.e. /bin/sh
+}
+
+// ContainerNamespace returns true if the hook path is resolved in the
+// container filesystem rather than in the runtime namespace i.e. the host.
+func (h Hook) ContainerNamespace() bool {
+	return h.Stage == HookStartContainer
+}
+
+// SpecHooks returns the hooks of an OCI runtime spec.
+func SpecHooks(spec *specs.Spec) []Hook {
+	if spec == nil || spec.Hooks == nil {
+		return nil
+	}
+
+	var hooks []Hook
+	for _, stage := range []struct {
+		name  string
+		hooks []specs.Hook
+	}{
+		{HookPrestart, spec.Hooks.Prestart},
+		{HookCreateRuntime, spec.Hooks.CreateRuntime},
+		{HookCreateContainer, spec.Hooks.CreateContainer},
+		{HookStartContainer, spec.Hooks.StartContainer},
+		{HookPoststart, spec.Hooks.Poststart},
+		{HookPoststop, spec.Hooks.Poststop},
+	} {
+		for i, h := range stage.hooks {
+			hook := Hook{
+				Stage:  stage.name,
+				Path:   h.Path,
+				Args:   h.Args,
+				Env:    h.Env,
+				Source: "container spec",
+				Field:  fmt.Sprintf("hooks.%s[%d]", stage.name, i),
+			}
+			if h.Timeout != nil {
+				hook.Timeout = *h.Timeout
+			}
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// hookConfig is an OCI hook configuration file version 1.0.0 i.e.
+// /usr/share/containers/oci/hooks.d/oci-nvidia-hook.json.
+type hookConfig struct {
+	Version string     `json:"version"`
+	Hook    specs.Hook `json:"hook"`
+	When    struct {
+		Always        *bool             `json:"always"`
+		Annotations   map[string]string `json:"annotations"`
+		Commands      []string          `json:"commands"`
+		HasBindMounts *bool             `json:"hasBindMounts"`
+	} `json:"when"`
+	Stages []string `json:"stages"`
+}
+
+// ReadHookConfigs returns the hooks of the OCI hook configuration files of
+// the evidence host. The hooks are injected by CRI-O and Podman in the spec
+// of the containers matching the conditions of the configuration file.
+func ReadHookConfigs(imageroot string) []Hook {
+	if imageroot == "" {
+		return nil
+	}
+
+	var hooks []Hook
+	for _, dir := range hookConfigDirs {
+		resolved, err := ResolveHostPath(imageroot, dir)
+		if err != nil {
+			continue
+		}
+		files, _ := filepath.Glob(filepath.Join(resolved, "*.json"))
+		sort.Strings(files)
+		for _, file := range files {
+			source := path.Join(dir, filepath.Base(file))
+			data, err := os.ReadFile(file)
+			if err != nil {
+				log.WithField("path", source).Warn("reading hook configuration: ", err)
+				continue
+			}
+			var config hookConfig
+			if err := json.Unmarshal(data, &config); err != nil {
+				log.WithField("path", source).Warn("unmarshalling hook configuration: ", err)
+				continue
+			}
+
+			var when []string
+			if config.When.Always != nil && *config.When.Always {
+				when = append(when, "always")
+			}
+			for key, value := range config.When.Annotations {
+				when = append(when, fmt.Sprintf("annotation %s=%s", key, value))
+			}
+			for _, command := range config.When.Commands {
+				when = append(when, fmt.Sprintf("command %s", command))
+			}
+			if config.When.HasBindMounts != nil && *config.When.HasBindMounts {
+				when = append(when, "bind mounts")
+			}
+			sort.Strings(when)
+
+			for _, stage := range config.Stages {
+				hook := Hook{
+					Stage:  stage,
+					Path:   config.Hook.Path,
+					Args:   config.Hook.Args,
+					Env:    config.Hook.Env,
+					Source: source,
+					Field:  "hook",
+					When:   strings.Join(when, ", "),
+				}
+				if config.Hook.Timeout != nil {
+					hook.Timeout = *config.Hook.Timeout
+				}
+				hooks = append(hooks, hook)
+			}
+		}
+	}
+	return hooks
+}
+
+// PackageIndex maps the paths of the files owned by the installed packages
+// to the package files.
+type PackageIndex map[string]PackageFile
+
+// ReadPackageIndex returns the files owned by the dpkg and rpm packages in
+// the merged view of the layers. The image root of the evidence host is
+// read as a single layer.
+//
+// Only the package databases are read, so the files are not walked.
+func ReadPackageIndex(layers []string) PackageIndex {
+	index := make(PackageIndex)
+	add := func(files []PackageFile, source string) {
+		for _, f := range files {
+			f.Source = source
+			index[f.Path] = f
+		}
+	}
+
+	if entries, err := ReadLayerDir(layers, dpkgInfoDir); err == nil {
+		for _, e := range entries {
+			if !strings.HasSuffix(e.Path, ".md5sums") || !e.Info.Mode().IsRegular() {
+				continue
+			}
+			files, err := readDpkgMD5Sums(e.LayerPath)
+			if err != nil {
+				log.WithField("path", e.Path).Warn("reading package digests: ", err)
+				continue
+			}
+			add(files, e.Path)
+		}
+	}
+
+	for _, dbpath := range rpmDatabasePaths {
+		f, err := ResolveLayerPath(layers, dbpath)
+		if err != nil || !f.Info.Mode().IsRegular() {
+			continue
+		}
+		files, err := readRPMDatabase(f.LayerPath)
+		if err != nil {
+			log.WithField("path", dbpath).Warn("reading package digests: ", err)
+			continue
+		}
+		add(files, dbpath)
+	}
+	return index
+}
+
+// InspectHookFile locates the executable of a hook in the merged view of the
+// layers, hashes it, and verifies it against the package databases.
+//
+// The executable is looked up in the index using the hook path and the path
+// after resolving the symbolic links i.e. /bin linked to /usr/bin.
+func InspectHookFile(layers []string, p string, index PackageIndex) HookFile {
+	hf := HookFile{Path: p, Status: HookMissing}
+
+	f, err := ResolveLayerPath(layers, p)
+	if err != nil || !f.Info.Mode().IsRegular() {
+		return hf
+	}
+	hf.Path = f.Path
+	hf.DiskPath = f.LayerPath
+	hf.Size = f.Info.Size()
+	hf.ModTime = f.Info.ModTime().UTC()
+	hf.Interpreter = scriptInterpreter(f.LayerPath)
+	if hf.SHA256, err = hashPackageFile(f.LayerPath, "sha256"); err != nil {
+		log.WithField("path", p).Warn("hashing hook file: ", err)
+	}
+
+	pf, found := index[p]
+	if !found {
+		pf, found = index[f.Path]
+	}
+	if !found {
+		hf.Status = HookUnpackaged
+		return hf
+	}
+
+	hf.Package = pf.Package
+	hf.Status = HookPackaged
+	if pf.Digest == "" {
+		return hf
+	}
+	if actual, err := hashPackageFile(f.LayerPath, pf.Algorithm); err != nil || !strings.EqualFold(actual, pf.Digest) {
+		hf.Status = HookModified
+	}
+	return hf
+}
+
+// scriptInterpreter returns the interpreter line of a script i.e. /bin/sh -e
+// or an empty string.
+func scriptInterpreter(diskpath string) string {
+	f, err := os.Open(diskpath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	if !strings.HasPrefix(line, "#!") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "#!"))
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultContainerdConfig = "/etc/containerd/config.toml"
+	defaultDockerConfig     = "/etc/docker/daemon.json"
+)
+
+// systemdUnitDirs are the directories of the systemd unit files in lookup
+// order. NixOS links /etc/systemd/system to the unit files in /nix/store.
+var systemdUnitDirs = []string{
+	"/etc/systemd/system",
+	"/run/systemd/system",
+	"/usr/lib/systemd/system",
+	"/lib/systemd/system",
+}
+
+// ResolveHostPath returns the path of a file of the evidence host within the
+// image root.
+//
+// The symbolic links are resolved within the image root rather than on the
+// analysis host, i.e. /etc/os-release of NixOS linking to /etc/static and to
+// /nix/store is looked up in the image root.
+func ResolveHostPath(imageroot string, path string) (string, error) {
+	f, err := ResolveLayerPath([]string{imageroot}, path)
+	if err != nil {
+		return "", err
+	}
+	return f.LayerPath, nil
+}
+
+// ReadHostFile reads a file of the evidence host resolved using
+// ResolveHostPath.
+func ReadHostFile(imageroot string, path string) ([]byte, string, error) {
+	resolved, err := ResolveHostPath(imageroot, path)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(resolved)
+	return data, resolved, err
+}
+
+// ServiceCommand is the command line of a systemd service of the evidence
+// host.
+type ServiceCommand struct {
+	Unit string   // unit file within the image root
+	Args []string // ExecStart command line
+}
+
+// Flag returns the value of the first command line flag found, i.e. --root
+// or --root=/data/containerd.
+func (c ServiceCommand) Flag(names ...string) string {
+	for i, arg := range c.Args {
+		for _, name := range names {
+			if arg == name && i+1 < len(c.Args) {
+				return c.Args[i+1]
+			}
+			if strings.HasPrefix(arg, name+"=") {
+				return strings.TrimPrefix(arg, name+"=")
+			}
+		}
+	}
+	return ""
+}
+
+// ReadServiceCommand returns the ExecStart command line of a systemd service
+// of the evidence host.
+//
+// The drop-in files of the unit in <unit>.d override the command line of the
+// unit file. The runtime binary of an immutable OS is usually outside the
+// standard directories, i.e. /nix/store/<hash>-containerd-<version>/bin.
+func ReadServiceCommand(imageroot string, unit string) (ServiceCommand, bool) {
+	var cmd ServiceCommand
+	for _, dir := range systemdUnitDirs {
+		path, err := ResolveHostPath(imageroot, filepath.Join(dir, unit))
+		if err != nil {
+			continue
+		}
+		args := readExecStart(path)
+		if args == nil {
+			continue
+		}
+		cmd = ServiceCommand{Unit: path, Args: args}
+		break
+	}
+
+	for _, dir := range systemdUnitDirs {
+		dropindir, err := ResolveHostPath(imageroot, filepath.Join(dir, unit+".d"))
+		if err != nil {
+			continue
+		}
+		dropins, _ := filepath.Glob(filepath.Join(dropindir, "*.conf"))
+		sort.Strings(dropins)
+		for _, dropin := range dropins {
+			if args := readExecStart(dropin); len(args) > 0 {
+				cmd = ServiceCommand{Unit: dropin, Args: args}
+			}
+		}
+	}
+
+	if cmd.Args == nil {
+		return cmd, false
+	}
+	log.WithFields(log.Fields{
+		"unit":    cmd.Unit,
+		"command": cmd.Args,
+	}).Debug("read service command")
+	return cmd, true
+}
+
+// readExecStart returns the last ExecStart command line of a unit file. An
+// empty ExecStart resetting the command line of a drop-in file returns an
+// empty slice.
+func readExecStart(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var (
+		args []string
+		line string
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+		line += text
+		if strings.HasPrefix(line, "ExecStart=") {
+			args = strings.Fields(strings.TrimPrefix(line, "ExecStart="))
+			if len(args) > 0 {
+				// The executable prefixes, i.e. - ignore the exit status.
+				args[0] = strings.TrimLeft(args[0], "-@:+!")
+			}
+			for i, arg := range args {
+				if unquoted, err := strconv.Unquote(arg); err == nil {
+					args[i] = unquoted
+				}
+			}
+			if args == nil {
+				args = []string{}
+			}
+		}
+		line = ""
+	}
+	return args
+}
+
+// ContainerdConfigPath returns the containerd configuration file of the
+// evidence host within the image root or an empty string.
+//
+// The configuration file is set using --config in the containerd service,
+// i.e. NixOS generates the configuration file in /nix/store. Otherwise the
+// default /etc/containerd/config.toml is used.
+func ContainerdConfigPath(imageroot string) string {
+	if imageroot == "" {
+		return ""
+	}
+	config := defaultContainerdConfig
+	if cmd, found := ReadServiceCommand(imageroot, "containerd.service"); found {
+		if c := cmd.Flag("--config", "-c"); c != "" {
+			config = c
+		}
+	}
+	path, err := ResolveHostPath(imageroot, config)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// ConfiguredContainerdRoot returns the containerd root directory configured
+// on the evidence host within the image root or an empty string if the
+// default root directory is used.
+//
+// The root directory is set using --root in the containerd service or using
+// the top-level root key of the configuration file.
+func ConfiguredContainerdRoot(imageroot string, defaultRoot string) string {
+	if imageroot == "" {
+		return ""
+	}
+
+	root := ""
+	if cmd, found := ReadServiceCommand(imageroot, "containerd.service"); found {
+		root = cmd.Flag("--root")
+	}
+	if root == "" {
+		if data, err := os.ReadFile(ContainerdConfigPath(imageroot)); err == nil {
+			root = topLevelTOMLString(data, "root")
+		}
+	}
+	return resolveConfiguredRoot(imageroot, root, defaultRoot)
+}
+
+// DockerConfigPath returns the docker daemon configuration file of the
+// evidence host within the image root or an empty string.
+//
+// The configuration file is set using --config-file in the docker service.
+// Otherwise the default /etc/docker/daemon.json is used.
+func DockerConfigPath(imageroot string) string {
+	if imageroot == "" {
+		return ""
+	}
+	config := defaultDockerConfig
+	if cmd, found := ReadServiceCommand(imageroot, "docker.service"); found {
+		if c := cmd.Flag("--config-file"); c != "" {
+			config = c
+		}
+	}
+	path, err := ResolveHostPath(imageroot, config)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// ConfiguredDockerRoot returns the docker data root configured on the
+// evidence host within the image root or an empty string if the default
+// data root is used.
+//
+// The data root is set using --data-root in the docker service or using the
+// data-root key of the daemon configuration file. The deprecated --graph and
+// graph key are also read.
+func ConfiguredDockerRoot(imageroot string, defaultRoot string) string {
+	if imageroot == "" {
+		return ""
+	}
+
+	root := ""
+	if cmd, found := ReadServiceCommand(imageroot, "docker.service"); found {
+		root = cmd.Flag("--data-root", "--graph", "-g")
+	}
+	if root == "" {
+		if data, err := os.ReadFile(DockerConfigPath(imageroot)); err == nil {
+			var daemon struct {
+				DataRoot string `json:"data-root"`
+				Graph    string `json:"graph"`
+			}
+			if err := json.Unmarshal(data, &daemon); err == nil {
+				root = daemon.DataRoot
+				if root == "" {
+					root = daemon.Graph
+				}
+			}
+		}
+	}
+	return resolveConfiguredRoot(imageroot, root, defaultRoot)
+}
+
+// resolveConfiguredRoot returns the configured root directory within the
+// image root or an empty string if the root directory is not configured, is
+// the default root directory, or does not exist.
+func resolveConfiguredRoot(imageroot string, root string, defaultRoot string) string {
+	if root == "" || !filepath.IsAbs(root) || filepath.Clean(root) == filepath.Clean(defaultRoot) {
+		return ""
+	}
+	dir, err := ResolveHostPath(imageroot, root)
+	if err != nil {
+		log.WithField("root", root).Debug("configured runtime root not found: ", err)
+		return ""
+	}
+	log.WithFields(log.Fields{
+		"root": root,
+		"dir":  dir,
+	}).Debug("configured runtime root")
+	return dir
+}
+
+// topLevelTOMLString returns the string value of a key before the first
+// section of a TOML document.
+func topLevelTOMLString(data []byte, key string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			return ""
+		}
+		i := strings.Index(line, "=")
+		if i < 0 || strings.HasPrefix(line, "#") || strings.TrimSpace(line[:i]) != key {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+		return strings.Trim(value, `'`)
+	}
+	return ""
+}
@@ -18,6 +18,7 @@ package explorers
 
 import (
 	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
 )
 
 // Image provides information about a container image.
@@ -26,3 +27,41 @@ type Image struct {
 	SupportContainerImage bool
 	images.Image
 }
+
+// ImageDetail provides the configuration and layers of a container image.
+type ImageDetail struct {
+	Namespace  string       `json:"namespace,omitempty"`
+	Name       string       `json:"name"`
+	Target     string       `json:"target"`             // image target digest i.e. manifest or index
+	Manifest   string       `json:"manifest,omitempty"` // image manifest digest
+	Config     string       `json:"config"`             // image configuration digest
+	ConfigPath string       `json:"config_path,omitempty"`
+	Layers     []ImageLayer `json:"layers"`
+}
+
+// ImageLayer provides information about an image layer.
+type ImageLayer struct {
+	Digest   string `json:"digest,omitempty"` // compressed layer digest
+	DiffID   string `json:"diff_id"`          // uncompressed layer digest
+	BlobPath string `json:"blob_path,omitempty"`
+	Dir      string `json:"dir,omitempty"` // unpacked layer directory
+}
+
+// MatchImageName returns true if the image name matches the reference.
+//
+// The names are compared after normalization i.e. nginx matches
+// docker.io/library/nginx:latest.
+func MatchImageName(name string, ref string) bool {
+	if name == ref {
+		return true
+	}
+	n, err := docker.ParseDockerRef(name)
+	if err != nil {
+		return false
+	}
+	r, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return false
+	}
+	return n.String() == r.String()
+}
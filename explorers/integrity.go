@@ -0,0 +1,278 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dpkgInfoDir holds the md5sums files of the installed dpkg packages.
+const dpkgInfoDir = "/var/lib/dpkg/info"
+
+// File integrity states.
+const (
+	IntegrityOK       = "ok"
+	IntegrityModified = "modified"
+	IntegrityMissing  = "missing"
+	IntegrityReplaced = "replaced" // not a regular file i.e. replaced by a symlink
+)
+
+// PackageFile is a file owned by an installed package with the digest
+// recorded by the package manager.
+type PackageFile struct {
+	Package   string `json:"package"`
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	Algorithm string `json:"algorithm"`
+	Digest    string `json:"digest"`
+	Config    bool   `json:"config"` // configuration file expected to change
+	Source    string `json:"source"` // package database recording the digest
+}
+
+// FileIntegrity is the result of the verification of a package file.
+type FileIntegrity struct {
+	PackageFile
+	Status   string    `json:"status"`
+	Actual   string    `json:"actual,omitempty"`
+	Layer    int       `json:"layer"` // layer index where 0 is the upper layer
+	DiskPath string    `json:"disk_path,omitempty"`
+	ModTime  time.Time `json:"mod_time"`
+}
+
+// VerifyPackageFiles verifies the files owned by the installed packages in
+// the merged view of the layers against the digests recorded by dpkg and rpm.
+//
+// The dpkg digests are read from the md5sums files of the packages and do not
+// include the configuration files. The rpm digests are read from the sqlite
+// or Berkeley DB rpm database.
+func VerifyPackageFiles(layers []string) ([]FileIntegrity, error) {
+	files := make(map[string]LayerFile)
+	err := WalkLayers(layers, func(f LayerFile) error {
+		if !f.Info.IsDir() {
+			files[f.Path] = f
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgfiles []PackageFile
+	for p, f := range files {
+		if !f.Info.Mode().IsRegular() {
+			continue
+		}
+
+		var (
+			results []PackageFile
+			err     error
+		)
+		switch {
+		case path.Dir(p) == dpkgInfoDir && strings.HasSuffix(p, ".md5sums"):
+			results, err = readDpkgMD5Sums(f.LayerPath)
+		case isRPMDatabase(p):
+			results, err = readRPMDatabase(f.LayerPath)
+		default:
+			continue
+		}
+		if err != nil {
+			log.WithField("path", p).Warn("reading package digests: ", err)
+			continue
+		}
+		for i := range results {
+			results[i].Source = p
+		}
+		pkgfiles = append(pkgfiles, results...)
+	}
+
+	var results []FileIntegrity
+	for _, pf := range pkgfiles {
+		result := FileIntegrity{PackageFile: pf, Layer: -1}
+
+		f, found := lookupLayerFile(files, pf.Path)
+		switch {
+		case !found:
+			result.Status = IntegrityMissing
+		case !f.Info.Mode().IsRegular():
+			result.Status = IntegrityReplaced
+			result.Layer = f.Layer
+			result.DiskPath = f.LayerPath
+			result.ModTime = f.Info.ModTime().UTC()
+		default:
+			result.Layer = f.Layer
+			result.DiskPath = f.LayerPath
+			result.ModTime = f.Info.ModTime().UTC()
+			actual, err := hashPackageFile(f.LayerPath, pf.Algorithm)
+			if err != nil {
+				log.WithField("path", pf.Path).Warn("hashing file: ", err)
+				continue
+			}
+			result.Actual = actual
+			result.Status = IntegrityOK
+			if !strings.EqualFold(actual, pf.Digest) {
+				result.Status = IntegrityModified
+			}
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].Package < results[j].Package
+	})
+	return results, nil
+}
+
+// lookupLayerFile returns the file of a container path. The symbolic links
+// of the parent directories are followed i.e. /bin linked to /usr/bin in a
+// merged /usr filesystem.
+func lookupLayerFile(files map[string]LayerFile, p string) (LayerFile, bool) {
+	for depth := 0; depth < 16; depth++ {
+		if f, found := files[p]; found {
+			return f, true
+		}
+
+		resolved := false
+		for dir := path.Dir(p); dir != "/" && dir != "."; dir = path.Dir(dir) {
+			f, found := files[dir]
+			if !found || f.Info.Mode()&os.ModeSymlink == 0 {
+				continue
+			}
+			target, err := os.Readlink(f.LayerPath)
+			if err != nil {
+				return LayerFile{}, false
+			}
+			if !path.IsAbs(target) {
+				target = path.Join(path.Dir(dir), target)
+			}
+			p = path.Join(target, strings.TrimPrefix(p, dir))
+			resolved = true
+			break
+		}
+		if !resolved {
+			return LayerFile{}, false
+		}
+	}
+	return LayerFile{}, false
+}
+
+// isRPMDatabase returns true if the container path is an rpm database.
+func isRPMDatabase(p string) bool {
+	for _, dbpath := range rpmDatabasePaths {
+		if p == dbpath {
+			return true
+		}
+	}
+	return false
+}
+
+// readRPMDatabase returns the package files of an rpm database.
+func readRPMDatabase(diskpath string) ([]PackageFile, error) {
+	blobs, err := readRPMHeaders(diskpath)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []PackageFile
+	for _, blob := range blobs {
+		results, err := rpmPackageFiles(blob)
+		if err != nil {
+			log.WithField("path", diskpath).Debug("decoding rpm header: ", err)
+			continue
+		}
+		files = append(files, results...)
+	}
+	return files, nil
+}
+
+// readDpkgMD5Sums returns the package files of a dpkg md5sums file i.e.
+// /var/lib/dpkg/info/procps.md5sums.
+func readDpkgMD5Sums(diskpath string) ([]PackageFile, error) {
+	data, err := os.ReadFile(diskpath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Multi-arch packages are named package:arch.md5sums.
+	pkg := strings.TrimSuffix(filepath.Base(diskpath), ".md5sums")
+	if i := strings.Index(pkg, ":"); i > 0 {
+		pkg = pkg[:i]
+	}
+
+	var files []PackageFile
+	for _, line := range strings.Split(string(data), "\n") {
+		digest, p, found := cut(line, "  ")
+		if !found || digest == "" || p == "" {
+			continue
+		}
+		files = append(files, PackageFile{
+			Package:   pkg,
+			Type:      PackageTypeDEB,
+			Path:      "/" + strings.TrimPrefix(p, "/"),
+			Algorithm: "md5",
+			Digest:    digest,
+		})
+	}
+	return files, nil
+}
+
+// hashPackageFile returns the hex digest of a file.
+func hashPackageFile(diskpath string, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha384":
+		h = sha512.New384()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+
+	f, err := os.Open(diskpath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
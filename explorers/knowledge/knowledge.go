@@ -0,0 +1,273 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package knowledge provides the knowledge packs describing the artifact
+// locations of the Kubernetes distributions, container orchestrators, and
+// container engine forks.
+//
+// A knowledge pack is a YAML file. Adding support for a new distribution is
+// a data change i.e.
+//
+//	name: k3s
+//	runtime: containerd
+//	detect:
+//	  - /var/lib/rancher/k3s/agent/containerd
+//	containerd_root: /var/lib/rancher/k3s/agent/containerd
+//	log_paths:
+//	  - /var/lib/rancher/k3s/agent/containerd/containerd.log
+//	config_paths:
+//	  - /etc/rancher/k3s/config.yaml
+//	support_containers:
+//	  images:
+//	    - docker.io/rancher/mirrored-pause
+package knowledge
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Container runtimes of the knowledge packs.
+const (
+	RuntimeContainerd = "containerd"
+	RuntimeDocker     = "docker"
+)
+
+// Default artifact locations used when a pack does not specify them.
+const (
+	DefaultKubeletRoot = "/var/lib/kubelet"
+	DefaultPodLogDir   = "/var/log/pods"
+)
+
+// Pack describes the artifact locations of a distribution.
+//
+// The paths are absolute paths on the evidence host and are joined with the
+// image root.
+type Pack struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description"`
+
+	// Runtime is the container runtime of the distribution i.e.
+	// containerd or docker for the docker forks.
+	Runtime string `json:"runtime" yaml:"runtime"`
+
+	// Detect are the paths identifying the distribution. The distribution
+	// is detected if one of the paths exists.
+	Detect []string `json:"detect" yaml:"detect"`
+
+	ContainerdRoot string `json:"containerd_root,omitempty" yaml:"containerd_root"`
+
+	// DockerRoot is the data root of a docker runtime i.e.
+	// /var/lib/balena-engine.
+	DockerRoot string `json:"docker_root,omitempty" yaml:"docker_root"`
+
+	// ContainerdInstances are the other containerd instances of the
+	// distribution i.e. the Bottlerocket host-containerd.
+	ContainerdInstances []ContainerdInstance `json:"containerd_instances,omitempty" yaml:"containerd_instances"`
+
+	KubeletRoot string   `json:"kubelet_root,omitempty" yaml:"kubelet_root"`
+	PodLogDir   string   `json:"pod_log_dir,omitempty" yaml:"pod_log_dir"`
+	LogPaths    []string `json:"log_paths,omitempty" yaml:"log_paths"`
+	ConfigPaths []string `json:"config_paths,omitempty" yaml:"config_paths"`
+
+	// SupportContainers identifies the containers created by the
+	// distribution i.e. the control-plane and add-on containers.
+	SupportContainers explorers.SupportContainer `json:"support_containers" yaml:"support_containers"`
+}
+
+// ContainerdInstance describes a containerd instance running besides the
+// containerd of the orchestrated containers.
+type ContainerdInstance struct {
+	// Name qualifies the namespaces of the instance i.e.
+	// host-containerd/default.
+	Name string `json:"name" yaml:"name"`
+
+	// Roots are the candidate root directories of the instance. The first
+	// root directory found in the image root is used.
+	Roots []string `json:"roots" yaml:"roots"`
+}
+
+// Root returns the first root directory of the instance found in the image
+// root or an empty string.
+func (i ContainerdInstance) Root(imageroot string) string {
+	for _, root := range i.Roots {
+		if dir := filepath.Join(imageroot, root); explorers.PathExists(dir, false) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// Kubelet returns the kubelet root directory of the distribution.
+func (p Pack) Kubelet() string {
+	if p.KubeletRoot == "" {
+		return DefaultKubeletRoot
+	}
+	return p.KubeletRoot
+}
+
+// PodLogs returns the kubelet pod log directory of the distribution.
+func (p Pack) PodLogs() string {
+	if p.PodLogDir == "" {
+		return DefaultPodLogDir
+	}
+	return p.PodLogDir
+}
+
+// Detected returns true if one of the detection paths i.e. a file or a
+// directory exists within the image root.
+func (p Pack) Detected(imageroot string) bool {
+	for _, path := range p.Detect {
+		if _, err := os.Stat(filepath.Join(imageroot, path)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+//go:embed packs/*.yaml
+var builtin embed.FS
+
+var (
+	mu    sync.RWMutex
+	packs = make(map[string]Pack)
+)
+
+func init() {
+	entries, err := builtin.ReadDir("packs")
+	if err != nil {
+		panic(fmt.Sprintf("knowledge: reading built-in packs: %v", err))
+	}
+	for _, entry := range entries {
+		data, err := builtin.ReadFile("packs/" + entry.Name())
+		if err != nil {
+			panic(fmt.Sprintf("knowledge: reading built-in pack %s: %v", entry.Name(), err))
+		}
+		p, err := parse(data)
+		if err != nil {
+			panic(fmt.Sprintf("knowledge: parsing built-in pack %s: %v", entry.Name(), err))
+		}
+		if err := Register(p); err != nil {
+			panic(fmt.Sprintf("knowledge: registering built-in pack %s: %v", entry.Name(), err))
+		}
+	}
+}
+
+// parse parses a knowledge pack.
+func parse(data []byte) (Pack, error) {
+	var p Pack
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return Pack{}, err
+	}
+	return p, nil
+}
+
+// Register makes a knowledge pack available by name. A pack registered with
+// the name of an existing pack replaces the existing pack.
+func Register(p Pack) error {
+	if p.Name == "" {
+		return fmt.Errorf("knowledge pack name is empty")
+	}
+	if p.Runtime != RuntimeContainerd && p.Runtime != RuntimeDocker {
+		return fmt.Errorf("knowledge pack %s has unsupported runtime %q", p.Name, p.Runtime)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, found := packs[p.Name]; found {
+		log.WithField("pack", p.Name).Debug("replacing knowledge pack")
+	}
+	packs[p.Name] = p
+	return nil
+}
+
+// Load registers the knowledge packs in a YAML file or in the YAML files of
+// a directory.
+func Load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, _ = filepath.Glob(filepath.Join(path, "*.yaml"))
+		yml, _ := filepath.Glob(filepath.Join(path, "*.yml"))
+		files = append(files, yml...)
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("reading knowledge pack %s: %w", file, err)
+		}
+		p, err := parse(data)
+		if err != nil {
+			return fmt.Errorf("parsing knowledge pack %s: %w", file, err)
+		}
+		if err := Register(p); err != nil {
+			return fmt.Errorf("registering knowledge pack %s: %w", file, err)
+		}
+		log.WithFields(log.Fields{
+			"pack": p.Name,
+			"file": file,
+		}).Debug("loaded knowledge pack")
+	}
+	return nil
+}
+
+// Get returns the knowledge pack registered by name.
+func Get(name string) (Pack, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, found := packs[name]
+	return p, found
+}
+
+// Packs returns the registered knowledge packs ordered by name.
+func Packs() []Pack {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var list []Pack
+	for _, p := range packs {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Detect returns the knowledge packs detected within the image root ordered
+// by name.
+func Detect(imageroot string) []Pack {
+	var detected []Pack
+	for _, p := range Packs() {
+		if p.Detected(imageroot) {
+			detected = append(detected, p)
+		}
+	}
+	return detected
+}
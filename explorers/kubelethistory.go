@@ -0,0 +1,535 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// StatusRemoved is the status of a container removed from the container
+// runtime but referenced in the kubelet state.
+const StatusRemoved = "REMOVED"
+
+// Sources of the containers recovered from the kubelet state.
+const (
+	KubeletSourceLogSymlink    = "container log symlink"
+	KubeletSourcePodLog        = "pod log"
+	KubeletSourceCPUManager    = "cpu manager checkpoint"
+	KubeletSourceMemoryManager = "memory manager checkpoint"
+	KubeletSourceDeviceManager = "device manager checkpoint"
+	KubeletSourceAllocation    = "pod resource allocation checkpoint"
+	KubeletSourceCheckpoint    = "container checkpoint archive"
+	KubeletSourceCRIStatus     = "CRI status"
+)
+
+const (
+	// kubeletContainerLogDir contains the container log symlinks.
+	kubeletContainerLogDir = "/var/log/containers"
+
+	// kubeletDeviceCheckpointFile is the device manager checkpoint relative
+	// to the kubelet root directory.
+	kubeletDeviceCheckpointFile = "device-plugins/kubelet_internal_checkpoint"
+)
+
+// kubeletResourceCheckpoints are the kubelet resource manager checkpoint
+// files relative to the kubelet root directory. Each file maps the pod UID
+// and container name to the resources allocated to the container.
+var kubeletResourceCheckpoints = map[string]string{
+	"cpu_manager_state":        KubeletSourceCPUManager,
+	"memory_manager_state":     KubeletSourceMemoryManager,
+	"pod_status_manager_state": KubeletSourceAllocation,
+	"allocated_pods_state":     KubeletSourceAllocation,
+}
+
+// containerLogName matches the container log symlink name
+// <pod name>_<pod namespace>_<container name>-<container id>.log.
+var containerLogName = regexp.MustCompile(`^([^_]+)_([^_]+)_(.+)-([0-9a-f]{64})\.log$`)
+
+// KubeletContainer is a container referenced in the kubelet state.
+//
+// Kubelet removes the exited containers from the container runtime during
+// garbage collection. The log files, the resource manager checkpoints, and
+// the container checkpoint archives may still reference the containers.
+type KubeletContainer struct {
+	ID           string    `json:"id,omitempty"`
+	PodUID       string    `json:"pod_uid,omitempty"`
+	PodName      string    `json:"pod_name,omitempty"`
+	PodNamespace string    `json:"pod_namespace,omitempty"`
+	Name         string    `json:"name"`
+	Image        string    `json:"image,omitempty"`
+	RestartCount int       `json:"restart_count"`      // -1 if unknown
+	LogPath      string    `json:"log_path,omitempty"` // log file within the image root
+	CreatedAt    time.Time `json:"created_at"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	ExitCode     int       `json:"exit_code,omitempty"`
+	Sources      []string  `json:"sources"`
+}
+
+// MarshalJSON omits the times that are not recorded.
+func (k KubeletContainer) MarshalJSON() ([]byte, error) {
+	type container KubeletContainer
+	return json.Marshal(struct {
+		container
+		CreatedAt  *time.Time `json:"created_at,omitempty"`
+		StartedAt  *time.Time `json:"started_at,omitempty"`
+		FinishedAt *time.Time `json:"finished_at,omitempty"`
+	}{
+		container:  container(k),
+		CreatedAt:  OptionalTime(k.CreatedAt),
+		StartedAt:  OptionalTime(k.StartedAt),
+		FinishedAt: OptionalTime(k.FinishedAt),
+	})
+}
+
+// Container returns the container record of a container recovered from the
+// kubelet state.
+func (k KubeletContainer) Container() Container {
+	ctr := Container{
+		Status:     StatusRemoved,
+		StartedAt:  k.StartedAt,
+		FinishedAt: k.FinishedAt,
+		ExitCode:   k.ExitCode,
+		LogPath:    k.LogPath,
+	}
+	ctr.ID = k.ID
+	ctr.Image = k.Image
+	ctr.CreatedAt = k.CreatedAt
+	ctr.Labels = make(map[string]string)
+	for label, value := range map[string]string{
+		LabelContainerName: k.Name,
+		LabelPodUID:        k.PodUID,
+		LabelPodName:       k.PodName,
+		LabelPodNamespace:  k.PodNamespace,
+	} {
+		if value != "" {
+			ctr.Labels[label] = value
+		}
+	}
+	if k.RestartCount >= 0 {
+		ctr.Labels[LabelContainerRestartCount] = strconv.Itoa(k.RestartCount)
+	}
+	return ctr
+}
+
+// kubeletHistory merges the containers found in the kubelet state.
+type kubeletHistory struct {
+	containers []KubeletContainer
+}
+
+// add merges a container into the history.
+//
+// A container with an ID is merged with the container with the same ID, or
+// with the container of the same pod, name, and restart count without an
+// ID. A container without an ID is merged with any container of the same
+// pod and name.
+func (h *kubeletHistory) add(k KubeletContainer, source string) {
+	for i := range h.containers {
+		c := &h.containers[i]
+		switch {
+		case k.ID != "" && c.ID == k.ID:
+		case k.ID != "" && c.ID == "" && sameKubeletContainer(*c, k) && (c.RestartCount == k.RestartCount || c.RestartCount < 0):
+			c.ID = k.ID
+		case k.ID == "" && sameKubeletContainer(*c, k) && (k.RestartCount < 0 || c.RestartCount == k.RestartCount):
+		default:
+			continue
+		}
+		mergeKubeletContainer(c, k)
+		c.AddSource(source)
+		return
+	}
+	k.Sources = []string{source}
+	h.containers = append(h.containers, k)
+}
+
+// sameKubeletContainer returns true if the containers have the same pod and
+// container name.
+func sameKubeletContainer(a KubeletContainer, b KubeletContainer) bool {
+	if a.Name != b.Name {
+		return false
+	}
+	if a.PodUID != "" && b.PodUID != "" {
+		return a.PodUID == b.PodUID
+	}
+	return a.PodName == b.PodName && a.PodNamespace == b.PodNamespace
+}
+
+// mergeKubeletContainer sets the empty attributes of c from k.
+func mergeKubeletContainer(c *KubeletContainer, k KubeletContainer) {
+	if c.PodUID == "" {
+		c.PodUID = k.PodUID
+	}
+	if c.PodName == "" {
+		c.PodName = k.PodName
+	}
+	if c.PodNamespace == "" {
+		c.PodNamespace = k.PodNamespace
+	}
+	if c.Image == "" {
+		c.Image = k.Image
+	}
+	if c.RestartCount < 0 {
+		c.RestartCount = k.RestartCount
+	}
+	if c.LogPath == "" {
+		c.LogPath = k.LogPath
+	}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = k.CreatedAt
+	}
+	if c.StartedAt.IsZero() {
+		c.StartedAt = k.StartedAt
+	}
+	if c.FinishedAt.IsZero() {
+		c.FinishedAt = k.FinishedAt
+	}
+}
+
+// AddSource adds a source of the container.
+func (k *KubeletContainer) AddSource(source string) {
+	if !hasSource(k.Sources, source) {
+		k.Sources = append(k.Sources, source)
+	}
+}
+
+// hasSource returns true if the source is in the list.
+func hasSource(sources []string, source string) bool {
+	for _, s := range sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// KubeletContainers returns the containers referenced in the kubelet state
+// of a node.
+//
+// The container IDs are recovered from the /var/log/containers symlinks,
+// which are left dangling when the pod logs are rotated away, and from the
+// container checkpoint archives. The pod log files, the resource manager
+// checkpoints, and the device manager checkpoint reference the containers
+// by pod UID and container name. The start and exit times are estimated
+// from the first and last timestamps of the container log.
+func KubeletContainers(imageroot string, kubeletroot string, podlogdir string) []KubeletContainer {
+	var h kubeletHistory
+
+	readContainerLogSymlinks(&h, imageroot)
+	readPodLogs(&h, imageroot, podlogdir)
+	readResourceCheckpoints(&h, imageroot, kubeletroot)
+	readDeviceCheckpoint(&h, imageroot, kubeletroot)
+	readCheckpointArchives(&h, imageroot, kubeletroot)
+
+	sort.SliceStable(h.containers, func(i, j int) bool {
+		a, b := h.containers[i], h.containers[j]
+		if a.PodNamespace != b.PodNamespace {
+			return a.PodNamespace < b.PodNamespace
+		}
+		if a.PodName != b.PodName {
+			return a.PodName < b.PodName
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.RestartCount < b.RestartCount
+	})
+	return h.containers
+}
+
+// readContainerLogSymlinks reads the container IDs from the container log
+// symlinks /var/log/containers/<pod>_<namespace>_<container>-<id>.log
+// pointing to /var/log/pods/<namespace>_<pod>_<uid>/<container>/<restart>.log.
+func readContainerLogSymlinks(h *kubeletHistory, imageroot string) {
+	dir := filepath.Join(imageroot, kubeletContainerLogDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		m := containerLogName.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		k := KubeletContainer{
+			PodName:      m[1],
+			PodNamespace: m[2],
+			Name:         m[3],
+			ID:           m[4],
+			RestartCount: -1,
+		}
+		if info, err := os.Lstat(filepath.Join(dir, e.Name())); err == nil {
+			k.CreatedAt = info.ModTime().UTC()
+		}
+		if target, err := os.Readlink(filepath.Join(dir, e.Name())); err == nil {
+			if !path.IsAbs(target) {
+				target = path.Join(kubeletContainerLogDir, target)
+			}
+			k.LogPath = filepath.Join(imageroot, target)
+			if uid, restart, ok := parsePodLogPath(target); ok {
+				k.PodUID = uid
+				k.RestartCount = restart
+			}
+			k.StartedAt, k.FinishedAt = logTimeRange(k.LogPath)
+		}
+		h.add(k, KubeletSourceLogSymlink)
+	}
+}
+
+// parsePodLogPath returns the pod UID and restart count of the pod log path
+// /var/log/pods/<namespace>_<pod>_<uid>/<container>/<restart>.log.
+func parsePodLogPath(logpath string) (string, int, bool) {
+	restart, err := strconv.Atoi(strings.TrimSuffix(path.Base(logpath), ".log"))
+	if err != nil {
+		return "", 0, false
+	}
+	parts := strings.Split(path.Base(path.Dir(path.Dir(logpath))), "_")
+	if len(parts) != 3 {
+		return "", 0, false
+	}
+	return parts[2], restart, true
+}
+
+// readPodLogs reads the containers of the pod log files
+// /var/log/pods/<namespace>_<pod>_<uid>/<container>/<restart>.log.
+func readPodLogs(h *kubeletHistory, imageroot string, podlogdir string) {
+	logs, _ := filepath.Glob(filepath.Join(imageroot, podlogdir, "*_*_*", "*", "*.log"))
+	for _, logfile := range logs {
+		logpath := path.Join(podlogdir, filepath.ToSlash(strings.TrimPrefix(logfile, filepath.Join(imageroot, podlogdir))))
+		uid, restart, ok := parsePodLogPath(logpath)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(filepath.Base(filepath.Dir(filepath.Dir(logfile))), "_")
+		k := KubeletContainer{
+			PodNamespace: parts[0],
+			PodName:      parts[1],
+			PodUID:       uid,
+			Name:         filepath.Base(filepath.Dir(logfile)),
+			RestartCount: restart,
+			LogPath:      logfile,
+		}
+		k.StartedAt, k.FinishedAt = logTimeRange(logfile)
+		h.add(k, KubeletSourcePodLog)
+	}
+}
+
+// readResourceCheckpoints reads the containers of the kubelet CPU manager,
+// memory manager, and pod resource allocation checkpoints.
+func readResourceCheckpoints(h *kubeletHistory, imageroot string, kubeletroot string) {
+	names := make([]string, 0, len(kubeletResourceCheckpoints))
+	for name := range kubeletResourceCheckpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(imageroot, kubeletroot, name))
+		if err != nil {
+			continue
+		}
+		var checkpoint struct {
+			Entries               map[string]map[string]json.RawMessage `json:"entries"`
+			PodResourceAllocation map[string]map[string]json.RawMessage `json:"podResourceAllocation"`
+		}
+		if err := json.Unmarshal(data, &checkpoint); err != nil {
+			log.WithField("path", path.Join(kubeletroot, name)).Debug("unmarshalling kubelet checkpoint: ", err)
+			continue
+		}
+		for _, entries := range []map[string]map[string]json.RawMessage{checkpoint.Entries, checkpoint.PodResourceAllocation} {
+			for uid, ctrs := range entries {
+				for ctrname := range ctrs {
+					h.add(KubeletContainer{PodUID: uid, Name: ctrname, RestartCount: -1}, kubeletResourceCheckpoints[name])
+				}
+			}
+		}
+	}
+}
+
+// readDeviceCheckpoint reads the containers of the device manager
+// checkpoint device-plugins/kubelet_internal_checkpoint.
+func readDeviceCheckpoint(h *kubeletHistory, imageroot string, kubeletroot string) {
+	data, err := os.ReadFile(filepath.Join(imageroot, kubeletroot, kubeletDeviceCheckpointFile))
+	if err != nil {
+		return
+	}
+	var checkpoint struct {
+		Data struct {
+			PodDeviceEntries []struct {
+				PodUID        string
+				ContainerName string
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		log.WithField("path", path.Join(kubeletroot, kubeletDeviceCheckpointFile)).Debug("unmarshalling device manager checkpoint: ", err)
+		return
+	}
+	for _, e := range checkpoint.Data.PodDeviceEntries {
+		if e.PodUID != "" && e.ContainerName != "" {
+			h.add(KubeletContainer{PodUID: e.PodUID, Name: e.ContainerName, RestartCount: -1}, KubeletSourceDeviceManager)
+		}
+	}
+}
+
+// readCheckpointArchives reads the containers of the container checkpoint
+// archives /var/lib/kubelet/checkpoints/checkpoint-<pod>_<namespace>-<container>-<time>.tar
+// created using the kubelet checkpoint API.
+//
+// The archive contains the container configuration config.dump and the
+// runtime spec spec.dump with the pod annotations.
+func readCheckpointArchives(h *kubeletHistory, imageroot string, kubeletroot string) {
+	archives, _ := filepath.Glob(filepath.Join(imageroot, kubeletroot, "checkpoints", "checkpoint-*.tar"))
+	for _, archive := range archives {
+		k, err := readCheckpointArchive(archive)
+		if err != nil {
+			log.WithField("archive", archive).Debug("reading container checkpoint archive: ", err)
+			continue
+		}
+		if k.Name != "" {
+			h.add(k, KubeletSourceCheckpoint)
+		}
+	}
+}
+
+// readCheckpointArchive reads the container of a checkpoint archive.
+func readCheckpointArchive(archive string) (KubeletContainer, error) {
+	k := KubeletContainer{RestartCount: -1}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return k, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return k, err
+		}
+
+		switch strings.TrimPrefix(hdr.Name, "./") {
+		case "config.dump":
+			var config struct {
+				ID              string    `json:"id"`
+				Name            string    `json:"name"`
+				RootfsImageName string    `json:"rootfsImageName"`
+				CreatedTime     time.Time `json:"createdTime"`
+			}
+			if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&config); err != nil {
+				return k, err
+			}
+			k.ID = config.ID
+			k.Image = config.RootfsImageName
+			k.CreatedAt = config.CreatedTime
+			if k.Name == "" {
+				k.Name = config.Name
+			}
+		case "spec.dump":
+			var spec struct {
+				Annotations map[string]string `json:"annotations"`
+			}
+			if err := json.NewDecoder(io.LimitReader(tr, 4<<20)).Decode(&spec); err != nil {
+				return k, err
+			}
+			// CRI-O uses the kubelet labels and containerd uses the CRI
+			// annotations.
+			a := spec.Annotations
+			k.PodUID = firstNonEmpty(a[LabelPodUID], a["io.kubernetes.cri.sandbox-uid"])
+			k.PodName = firstNonEmpty(a[LabelPodName], a["io.kubernetes.cri.sandbox-name"])
+			k.PodNamespace = firstNonEmpty(a[LabelPodNamespace], a["io.kubernetes.cri.sandbox-namespace"])
+			if name := firstNonEmpty(a[LabelContainerName], a["io.kubernetes.cri.container-name"]); name != "" {
+				k.Name = name
+			}
+		}
+	}
+	return k, nil
+}
+
+// logTimeRange returns the first and last timestamps of a CRI container log
+// i.e. 2024-01-02T03:04:05.123456789Z stdout F message.
+func logTimeRange(logfile string) (time.Time, time.Time) {
+	f, err := os.Open(logfile)
+	if err != nil {
+		return time.Time{}, time.Time{}
+	}
+	defer f.Close()
+
+	var first, last time.Time
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if scanner.Scan() {
+		first = logLineTime(scanner.Text())
+	}
+
+	// The last line is read from the end of the log.
+	if info, err := f.Stat(); err == nil {
+		offset := info.Size() - 64*1024
+		if offset < 0 {
+			offset = 0
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err == nil {
+			scanner = bufio.NewScanner(f)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				if t := logLineTime(scanner.Text()); !t.IsZero() {
+					last = t
+				}
+			}
+		}
+	}
+	return first, last
+}
+
+// logLineTime returns the timestamp of a CRI container log line.
+func logLineTime(line string) time.Time {
+	i := strings.Index(line, " ")
+	if i <= 0 {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, line[:i])
+	if err != nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+// firstNonEmpty returns the first non-empty value.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
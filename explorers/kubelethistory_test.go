@@ -0,0 +1,57 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestKubeletContainerJSON checks that the times that are not recorded are
+// omitted and the recorded times are kept.
+func TestKubeletContainerJSON(t *testing.T) {
+	k := KubeletContainer{
+		Name:      "app",
+		StartedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Sources:   []string{KubeletSourceCPUManager},
+	}
+	b, err := json.Marshal(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, field := range []string{`"created_at"`, `"finished_at"`} {
+		if strings.Contains(got, field) {
+			t.Errorf("JSON %s contains %s, want the field omitted", got, field)
+		}
+	}
+	for _, field := range []string{`"name":"app"`, `"started_at":"2024-05-01T10:00:00Z"`, `"restart_count":0`} {
+		if !strings.Contains(got, field) {
+			t.Errorf("JSON %s does not contain %s", got, field)
+		}
+	}
+
+	var decoded KubeletContainer
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.StartedAt.Equal(k.StartedAt) || !decoded.CreatedAt.IsZero() {
+		t.Errorf("decoded times = %v and %v, want %v and zero", decoded.StartedAt, decoded.CreatedAt, k.StartedAt)
+	}
+}
@@ -0,0 +1,408 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/container-explorer/explorers/archive"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// whiteoutPrefix is the prefix of AUFS style whiteout files.
+	whiteoutPrefix = ".wh."
+
+	// whiteoutOpaqueDir marks a directory as opaque in AUFS style layers.
+	whiteoutOpaqueDir = ".wh..wh..opq"
+
+	// FileStatusPresent is the status of a file visible in the container.
+	FileStatusPresent = "present"
+
+	// FileStatusDeleted is the status of a file deleted in an upper layer
+	// that still exists in a lower layer.
+	FileStatusDeleted = "deleted-but-recoverable"
+)
+
+// LayerFile describes a file visible in the merged container filesystem.
+type LayerFile struct {
+	Path      string      // path relative to container root i.e. /etc/passwd
+	Layer     int         // layer index where 0 is the upper layer
+	LayerPath string      // absolute path of the file on disk
+	Info      os.FileInfo // file information
+	Deleted   bool        // deleted in an upper layer but recoverable
+
+	// DeletedAt is the modification time of the whiteout or the opaque
+	// directory hiding a deleted file i.e. close to the deletion time.
+	DeletedAt time.Time
+}
+
+// Status returns the file status i.e. present or deleted-but-recoverable.
+func (f LayerFile) Status() string {
+	if f.Deleted {
+		return FileStatusDeleted
+	}
+	return FileStatusPresent
+}
+
+// WalkLayers walks the merged view of the layers and calls fn for each
+// visible file or directory.
+//
+// The layers are ordered from top to bottom, i.e. the upper layer first.
+// A file in an upper layer hides the same file in the lower layers.
+// Whiteout files and opaque directories hide the deleted files in the lower
+// layers. The fn function returns filepath.SkipDir to skip a directory in
+// all layers.
+func WalkLayers(layers []string, fn func(LayerFile) error) error {
+	return walkLayers(layers, false, fn)
+}
+
+// WalkLayersWithDeleted walks the merged view of the layers like WalkLayers
+// and also calls fn for the most recent version of files deleted in an upper
+// layer. The deleted files have LayerFile.Deleted set to true.
+//
+// Attackers routinely delete their tooling which still exists in the lower
+// layers.
+func WalkLayersWithDeleted(layers []string, fn func(LayerFile) error) error {
+	return walkLayers(layers, true, fn)
+}
+
+func walkLayers(layers []string, includedeleted bool, fn func(LayerFile) error) error {
+	var (
+		seen    = make(map[string]bool)
+		deleted = make(map[string]time.Time) // whiteout times
+		opaque  = make(map[string]int)
+		skipped = make(map[string]bool)
+
+		// opaquetimes holds the modification times of the opaque markers.
+		opaquetimes = make(map[string]time.Time)
+	)
+
+	// visit calls fn and records the directories skipped by fn, so the
+	// directories are also skipped in the lower layers.
+	visit := func(f LayerFile) error {
+		err := fn(f)
+		if err == filepath.SkipDir && f.Info.IsDir() {
+			skipped[f.Path] = true
+		}
+		return err
+	}
+
+	// hidden returns true and the modification time of the whiteout or
+	// the opaque marker if the path or any of its parent directory is
+	// deleted or marked opaque by an upper layer.
+	hidden := func(path string, layer int) (time.Time, bool) {
+		if t, found := deleted[path]; found {
+			return t, true
+		}
+		for dir := filepath.Dir(path); dir != "/"; dir = filepath.Dir(dir) {
+			if t, found := deleted[dir]; found {
+				return t, true
+			}
+			if idx, found := opaque[dir]; found && idx < layer {
+				return opaquetimes[dir], true
+			}
+		}
+		if idx, found := opaque["/"]; found && idx < layer {
+			return opaquetimes["/"], true
+		}
+		return time.Time{}, false
+	}
+
+	for i, layer := range layers {
+		// Whiteouts found in this layer apply to the lower layers only.
+		layerdeleted := make(map[string]time.Time)
+
+		err := filepath.Walk(layer, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(layer, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.Join("/", rel)
+
+			name := info.Name()
+			if name == whiteoutOpaqueDir {
+				if _, found := opaque[filepath.Dir(rel)]; !found {
+					opaque[filepath.Dir(rel)] = i
+					opaquetimes[filepath.Dir(rel)] = info.ModTime()
+				}
+				return nil
+			}
+			if strings.HasPrefix(name, whiteoutPrefix) {
+				layerdeleted[filepath.Join(filepath.Dir(rel), strings.TrimPrefix(name, whiteoutPrefix))] = info.ModTime()
+				return nil
+			}
+			if isWhiteout(info) {
+				layerdeleted[rel] = info.ModTime()
+				return nil
+			}
+
+			// A directory is opaque to the layers below the highest
+			// layer marking it opaque, even if an upper layer also has
+			// the directory.
+			if info.IsDir() && isOpaqueDir(path) {
+				if _, found := opaque[rel]; !found {
+					opaque[rel] = i
+					opaquetimes[rel] = info.ModTime()
+				}
+			}
+
+			if deletedat, found := hidden(rel, i); rel != "/" && found {
+				if !includedeleted {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				// Report the most recent version of a deleted file.
+				if seen[rel] {
+					return nil
+				}
+				seen[rel] = true
+
+				return visit(LayerFile{
+					Path:      rel,
+					Layer:     i,
+					LayerPath: path,
+					Info:      info,
+					Deleted:   true,
+					DeletedAt: deletedat,
+				})
+			}
+
+			if seen[rel] {
+				if info.IsDir() && skipped[rel] {
+					return filepath.SkipDir
+				}
+				if info.IsDir() && !includedeleted {
+					if idx, found := opaque[rel]; found && idx < i {
+						return filepath.SkipDir
+					}
+				}
+				return nil
+			}
+			seen[rel] = true
+
+			if rel == "/" {
+				return nil
+			}
+
+			return visit(LayerFile{
+				Path:      rel,
+				Layer:     i,
+				LayerPath: path,
+				Info:      info,
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("walking layer %s: %w", layer, err)
+		}
+
+		for k, t := range layerdeleted {
+			deleted[k] = t
+		}
+	}
+	return nil
+}
+
+// CopyFile copies a regular file to dst and preserves the file mode
+// including the setuid, setgid, and sticky bits, the ownership, and the
+// modification time. The parent directories of dst are created as required.
+// The ownership is restored only when running as root.
+func CopyFile(src string, dst string, info os.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", dst, err)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if err := restoreOwnerMode(dst, info); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// restoreOwnerMode restores the ownership and the full file mode of a file
+// or a directory. The ownership is restored first because changing the owner
+// clears the setuid and setgid bits. A failure to change the owner i.e. when
+// not running as root is logged.
+func restoreOwnerMode(path string, info os.FileInfo) error {
+	if err := lchown(path, info); err != nil {
+		log.WithField("path", path).Debug("restoring ownership: ", err)
+	}
+	return os.Chmod(path, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+}
+
+// CopyDir recursively copies the directory src to dst. Regular files,
+// directories, and symbolic links are copied. Other file types and files
+// that cannot be read are skipped.
+func CopyDir(src string, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == src {
+				return err
+			}
+			log.WithField("path", path).Warn("skipping file copy: ", err)
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err := CopyFile(path, target, info); err != nil {
+				log.WithField("path", path).Warn("skipping file copy: ", err)
+			}
+		}
+		return nil
+	})
+}
+
+// FlattenLayers writes the merged view of the layers to the directory dst.
+//
+// Regular files, directories, and symbolic links are written. Other file
+// types and files that cannot be read are skipped. The file mode including
+// the setuid, setgid, and sticky bits and the ownership are preserved. The
+// directory mode and modification time are restored after the directory
+// content is written.
+func FlattenLayers(layers []string, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return fmt.Errorf("creating directory %s: %w", dst, err)
+	}
+
+	var dirs []LayerFile
+	err := WalkLayers(layers, func(f LayerFile) error {
+		target := filepath.Join(dst, f.Path)
+
+		switch {
+		case f.Info.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, f)
+		case f.Info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(f.LayerPath)
+			if err != nil {
+				log.WithField("path", f.LayerPath).Warn("skipping symbolic link: ", err)
+				return nil
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				log.WithField("path", f.LayerPath).Warn("skipping symbolic link: ", err)
+				return nil
+			}
+			if err := lchown(target, f.Info); err != nil {
+				log.WithField("path", target).Debug("restoring ownership: ", err)
+			}
+		case f.Info.Mode().IsRegular():
+			if err := CopyFile(f.LayerPath, target, f.Info); err != nil {
+				log.WithField("path", f.LayerPath).Warn("skipping file copy: ", err)
+			}
+		default:
+			log.WithField("path", f.LayerPath).Debug("skipping special file")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Restore the directories in reverse order so that a read-only parent
+	// directory is restored after its children.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target := filepath.Join(dst, dirs[i].Path)
+		if err := restoreOwnerMode(target, dirs[i].Info); err != nil {
+			log.WithField("path", target).Debug("restoring directory mode: ", err)
+		}
+		modtime := dirs[i].Info.ModTime()
+		if err := os.Chtimes(target, modtime, modtime); err != nil {
+			log.WithField("path", target).Debug("restoring directory time: ", err)
+		}
+	}
+	return nil
+}
+
+// FlattenLayersTar writes the merged view of the layers to a tar stream.
+//
+// The entry names are relative to the container root. The file mode,
+// ownership, timestamps, and symbolic link targets are preserved. Device
+// files and fifos are written as tar entries, the whiteouts are not. The
+// files that cannot be read are skipped.
+func FlattenLayersTar(layers []string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	err := WalkLayers(layers, func(f LayerFile) error {
+		name := strings.TrimPrefix(f.Path, "/")
+		err := archive.WriteTarFile(tw, name, f.LayerPath, f.Info)
+
+		// A file that cannot be opened is skipped before its entry is
+		// written. The other errors leave the tar stream incomplete.
+		var perr *os.PathError
+		if errors.As(err, &perr) && (perr.Op == "open" || perr.Op == "readlink") {
+			log.WithField("path", f.LayerPath).Warn("skipping file: ", err)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// TestWalkLayersOpaqueXattr checks that a directory with the overlayfs
+// opaque attribute in a middle layer hides the lower layer directory even
+// though the upper layer also has the directory.
+func TestWalkLayersOpaqueXattr(t *testing.T) {
+	layers := []string{
+		writeLayer(t, "d/upper"),
+		writeLayer(t, "d/mid"),
+		writeLayer(t, "d/old", "keep"),
+	}
+	if err := syscall.Setxattr(filepath.Join(layers[1], "d"), "user.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skipf("setting the opaque attribute: %v", err)
+	}
+
+	got := sortedKeys(walkPaths(t, layers, false))
+	want := []string{"/d/mid", "/d/upper", "/keep"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkLayers() = %v, want %v", got, want)
+	}
+
+	deleted := walkPaths(t, layers, true)
+	if layer, found := deleted["/d/old"]; !found || layer != 2 {
+		t.Errorf("WalkLayersWithDeleted() = %v, want /d/old in layer 2", deleted)
+	}
+}
+
+// TestFlattenLayersOwnerMode checks that the setuid, setgid, and sticky bits
+// and the ownership are preserved.
+func TestFlattenLayersOwnerMode(t *testing.T) {
+	layer := writeLayer(t, "usr/bin/su", "tmp/", "srv/shared/", "home/user/file")
+	modes := map[string]os.FileMode{
+		"usr/bin/su": 0755 | os.ModeSetuid,
+		"tmp":        0777 | os.ModeDir | os.ModeSticky,
+		"srv/shared": 0775 | os.ModeDir | os.ModeSetgid,
+		"home/user":  0700 | os.ModeDir,
+	}
+	root := os.Geteuid() == 0
+	if root {
+		for _, name := range []string{"usr/bin/su", "home/user", "home/user/file"} {
+			if err := os.Lchown(filepath.Join(layer, name), 1234, 5678); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink("file", filepath.Join(layer, "home/user/link")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Lchown(filepath.Join(layer, "home/user/link"), 1234, 5678); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The ownership is changed first because changing the owner clears
+	// the setuid and setgid bits.
+	for name, mode := range modes {
+		if err := os.Chmod(filepath.Join(layer, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := filepath.Join(t.TempDir(), "rootfs")
+	if err := FlattenLayers([]string{layer}, dst); err != nil {
+		t.Fatalf("FlattenLayers() returned error: %v", err)
+	}
+
+	for name, mode := range modes {
+		info, err := os.Lstat(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != mode {
+			t.Errorf("%s mode = %v, want %v", name, info.Mode(), mode)
+		}
+	}
+	if !root {
+		return
+	}
+	for _, name := range []string{"usr/bin/su", "home/user", "home/user/file", "home/user/link"} {
+		info, err := os.Lstat(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if stat.Uid != 1234 || stat.Gid != 5678 {
+			t.Errorf("%s owner = %d:%d, want 1234:5678", name, stat.Uid, stat.Gid)
+		}
+	}
+}
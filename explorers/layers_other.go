@@ -0,0 +1,46 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import "os"
+
+// isWhiteout returns true if the file is an overlayfs whiteout.
+//
+// Overlayfs whiteout is a character device with device number 0/0. Device
+// numbers are not available on this platform, so every character device is
+// treated as a whiteout.
+func isWhiteout(info os.FileInfo) bool {
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// isOpaqueDir returns true if the directory is marked opaque.
+//
+// Extended attributes are not read on this platform.
+func isOpaqueDir(path string) bool {
+	return false
+}
+
+// lchown sets the owner and the group of the file.
+//
+// The owner and the group are not available on this platform, so the
+// ownership is not restored.
+func lchown(path string, info os.FileInfo) error {
+	return nil
+}
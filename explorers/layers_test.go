@@ -0,0 +1,96 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeLayer creates the files of a layer in a temporary directory. A path
+// ending with a slash is a directory.
+func writeLayer(t *testing.T, paths ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, p := range paths {
+		path := filepath.Join(dir, p)
+		if p[len(p)-1] == '/' {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// walkPaths returns the paths visible in the merged view of the layers and
+// the layer of each path.
+func walkPaths(t *testing.T, layers []string, includedeleted bool) map[string]int {
+	t.Helper()
+	paths := make(map[string]int)
+	err := walkLayers(layers, includedeleted, func(f LayerFile) error {
+		if !f.Info.IsDir() {
+			paths[f.Path] = f.Layer
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func sortedKeys(m map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TestWalkLayersOpaqueMarker checks that a directory marked opaque in a
+// middle layer hides the lower layer directory even though the upper layer
+// also has the directory.
+func TestWalkLayersOpaqueMarker(t *testing.T) {
+	layers := []string{
+		writeLayer(t, "d/upper"),
+		writeLayer(t, "d/"+whiteoutOpaqueDir, "d/mid"),
+		writeLayer(t, "d/old", "keep"),
+	}
+
+	got := sortedKeys(walkPaths(t, layers, false))
+	want := []string{"/d/mid", "/d/upper", "/keep"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkLayers() = %v, want %v", got, want)
+	}
+
+	deleted := walkPaths(t, layers, true)
+	if layer, found := deleted["/d/old"]; !found || layer != 2 {
+		t.Errorf("WalkLayersWithDeleted() = %v, want /d/old in layer 2", deleted)
+	}
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import "time"
+
+// Lease provides information about a containerd lease.
+//
+// A lease protects the referenced content, snapshots, and ingests from
+// garbage collection i.e. while an image is pulled.
+type Lease struct {
+	Namespace string
+	ID        string
+	CreatedAt time.Time
+	Labels    map[string]string
+	Resources []LeaseResource
+}
+
+// LeaseResource is a resource referenced by a lease.
+//
+// Type is the resource type i.e. content, ingests, or
+// snapshots/<snapshotter>.
+type LeaseResource struct {
+	Type string
+	ID   string
+}
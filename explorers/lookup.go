@@ -0,0 +1,256 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxSymlinkHops is the maximum number of symbolic links followed when a
+// container path is resolved.
+const maxSymlinkHops = 40
+
+// LookupLayerPath returns the file at a container path in the merged view of
+// the layers without walking the layers.
+//
+// The layers are ordered from top to bottom, i.e. the upper layer first.
+// Only the directories on the path are read, so a file is found quickly on
+// slow evidence storage. The symbolic links of the parent directories are
+// resolved within the container, i.e. /var/run/docker.sock is looked up as
+// /run/docker.sock. The file itself is not followed.
+func LookupLayerPath(layers []string, path string) (LayerFile, error) {
+	if len(layers) == 0 {
+		return LayerFile{}, fmt.Errorf("no layer")
+	}
+
+	root, err := os.Lstat(layers[0])
+	if err != nil {
+		return LayerFile{}, err
+	}
+	f := LayerFile{Path: "/", LayerPath: layers[0], Info: root}
+
+	components := splitPath(path)
+	hops := 0
+	for len(components) > 0 {
+		name := components[0]
+		components = components[1:]
+
+		switch name {
+		case ".":
+			continue
+		case "..":
+			f.Path = filepath.Dir(f.Path)
+			continue
+		}
+
+		next, found := findLayerFile(layers, filepath.Join(f.Path, name))
+		if !found {
+			return LayerFile{}, fmt.Errorf("%s: %w", filepath.Join(f.Path, name), os.ErrNotExist)
+		}
+
+		if next.Info.Mode()&os.ModeSymlink != 0 && len(components) > 0 {
+			if hops++; hops > maxSymlinkHops {
+				return LayerFile{}, fmt.Errorf("%s: too many levels of symbolic links", path)
+			}
+			target, err := os.Readlink(next.LayerPath)
+			if err != nil {
+				return LayerFile{}, err
+			}
+			if filepath.IsAbs(target) {
+				f.Path = "/"
+			}
+			components = append(splitPath(target), components...)
+			continue
+		}
+		f = next
+	}
+
+	if f.Path == "/" {
+		return LayerFile{Path: "/", LayerPath: layers[0], Info: root}, nil
+	}
+
+	// The path may end with .. after the last file was looked up.
+	if f, found := findLayerFile(layers, f.Path); found {
+		return f, nil
+	}
+	return LayerFile{}, fmt.Errorf("%s: %w", f.Path, os.ErrNotExist)
+}
+
+// ResolveLayerPath returns the file at a container path in the merged view
+// of the layers. Unlike LookupLayerPath, a symbolic link at the path is
+// followed within the layers.
+func ResolveLayerPath(layers []string, path string) (LayerFile, error) {
+	for hops := 0; ; hops++ {
+		f, err := LookupLayerPath(layers, path)
+		if err != nil {
+			return LayerFile{}, err
+		}
+		if f.Info.Mode()&os.ModeSymlink == 0 {
+			return f, nil
+		}
+		if hops >= maxSymlinkHops {
+			return LayerFile{}, fmt.Errorf("%s: too many levels of symbolic links", path)
+		}
+		target, err := os.Readlink(f.LayerPath)
+		if err != nil {
+			return LayerFile{}, err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(f.Path), target)
+		}
+		path = target
+	}
+}
+
+// ReadLayerDir returns the files of a directory in the merged view of the
+// layers sorted by name.
+//
+// Only the directory is read in each layer. The files deleted by the
+// whiteouts of the upper layers are not returned.
+func ReadLayerDir(layers []string, path string) ([]LayerFile, error) {
+	dir, err := LookupLayerPath(layers, path)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.Info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir.Path)
+	}
+
+	var (
+		files   []LayerFile
+		seen    = make(map[string]bool)
+		deleted = make(map[string]bool)
+	)
+
+	for i := dir.Layer; i < len(layers); i++ {
+		layerdir := filepath.Join(layers[i], dir.Path)
+		entries, err := os.ReadDir(layerdir)
+		if err != nil && !os.IsNotExist(err) {
+			return files, fmt.Errorf("reading %s: %w", layerdir, err)
+		}
+
+		opaque := false
+		layerdeleted := make(map[string]bool)
+		for _, entry := range entries {
+			name := entry.Name()
+			if name == whiteoutOpaqueDir {
+				opaque = true
+				continue
+			}
+			if strings.HasPrefix(name, whiteoutPrefix) {
+				layerdeleted[strings.TrimPrefix(name, whiteoutPrefix)] = true
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			if isWhiteout(info) {
+				layerdeleted[name] = true
+				continue
+			}
+			if seen[name] || deleted[name] {
+				continue
+			}
+			seen[name] = true
+
+			files = append(files, LayerFile{
+				Path:      filepath.Join(dir.Path, name),
+				Layer:     i,
+				LayerPath: filepath.Join(layerdir, name),
+				Info:      info,
+			})
+		}
+
+		for name := range layerdeleted {
+			deleted[name] = true
+		}
+		if opaque || (entries != nil && isOpaqueDir(layerdir)) || layerHides(layers[i], dir.Path) {
+			break
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// findLayerFile returns the file at a container path without resolving
+// the symbolic links of the parent directories.
+func findLayerFile(layers []string, path string) (LayerFile, bool) {
+	for i, layer := range layers {
+		layerpath := filepath.Join(layer, path)
+		info, err := os.Lstat(layerpath)
+		if err == nil {
+			if isWhiteout(info) {
+				return LayerFile{}, false
+			}
+			return LayerFile{
+				Path:      path,
+				Layer:     i,
+				LayerPath: layerpath,
+				Info:      info,
+			}, true
+		}
+		if layerHides(layer, path) {
+			return LayerFile{}, false
+		}
+	}
+	return LayerFile{}, false
+}
+
+// layerHides returns true if a layer hides a container path in the lower
+// layers, i.e. the path or a parent directory is deleted, a parent
+// directory is opaque, or the path or a parent directory is not a directory.
+func layerHides(layer string, path string) bool {
+	current := layer
+	for _, name := range splitPath(path) {
+		if PathExists(filepath.Join(current, whiteoutPrefix+name), true) {
+			return true
+		}
+		if current != layer && (isOpaqueDir(current) || PathExists(filepath.Join(current, whiteoutOpaqueDir), true)) {
+			return true
+		}
+
+		current = filepath.Join(current, name)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return false
+		}
+		if isWhiteout(info) || !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPath returns the names of a slash separated path.
+func splitPath(path string) []string {
+	var names []string
+	for _, name := range strings.Split(filepath.ToSlash(path), "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
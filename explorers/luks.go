@@ -0,0 +1,839 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// luksKeyslotEnabled marks an active LUKS1 keyslot.
+	luksKeyslotEnabled = 0x00ac71f3
+
+	// luks1Keyslots is the number of LUKS1 keyslots.
+	luks1Keyslots = 8
+
+	// luks2BinaryHeaderSize is the size of the binary LUKS2 header before
+	// the JSON metadata.
+	luks2BinaryHeaderSize = 4096
+
+	// luks2MaxHeaderSize is the maximum size of a LUKS2 header including
+	// the JSON metadata.
+	luks2MaxHeaderSize = 4 * 1024 * 1024
+
+	// luksKeyAreaSector is the sector size of the keyslot areas and of
+	// the LUKS1 encrypted data.
+	luksKeyAreaSector = 512
+
+	// cryptsetupCommand recovers the volume key of a keyslot using a key
+	// derivation function not implemented i.e. argon2id.
+	cryptsetupCommand = "cryptsetup"
+)
+
+// luksVolume decrypts the data of an unlocked LUKS volume.
+type luksVolume struct {
+	cipher     sectorCipher
+	sectorSize int64
+	ivTweak    uint64
+}
+
+// luksKeyslot holds the volume key encrypted using a key derived from a
+// passphrase.
+type luksKeyslot struct {
+	name       string
+	kdf        string // pbkdf2, argon2i, or argon2id
+	hash       string // PBKDF2 hash
+	iterations int
+	salt       []byte
+	keySize    int
+	encryption string // cipher of the key material i.e. aes-xts-plain64
+	offset     int64  // key material offset
+	stripes    int
+	afHash     string // anti-forensic splitter hash
+}
+
+// luksHeader is the header of a LUKS1 or LUKS2 volume.
+type luksHeader struct {
+	version    int
+	uuid       string
+	encryption string // cipher of the volume data i.e. aes-xts-plain64
+	keySize    int
+	offset     int64 // volume data offset
+	size       int64 // volume data size or 0 if the data spans the partition
+	sectorSize int64
+	ivTweak    uint64
+
+	// The volume key digest is derived from the volume key using PBKDF2.
+	digestHash       string
+	digestIterations int
+	digestSalt       []byte
+	digest           []byte
+
+	keyslots []luksKeyslot
+}
+
+// UnlockVolumes returns the decrypted LUKS volumes of the partitions as
+// partitions. The keyslots are unlocked using the keys i.e. a passphrase or
+// the content of a key file. The filesystem of each volume is detected from
+// the decrypted superblock.
+//
+// The keyslots using PBKDF2 are unlocked directly. The volume key of a
+// keyslot using argon2 is recovered from a copy of the LUKS header using
+// cryptsetup.
+func UnlockVolumes(r io.ReaderAt, partitions []Partition, keys [][]byte) ([]Partition, error) {
+	index := 0
+	for _, p := range partitions {
+		if p.Index > index {
+			index = p.Index
+		}
+	}
+
+	var volumes []Partition
+	for _, p := range partitions {
+		if p.Filesystem != FilesystemLUKS {
+			continue
+		}
+		fields := log.Fields{"partition": p.Index}
+		if len(keys) == 0 {
+			log.WithFields(fields).Warn("partition is LUKS encrypted. Use --luks-key-file or --luks-passphrase to unlock it")
+			continue
+		}
+
+		volume, err := unlockLUKS(r, p, keys)
+		if err != nil {
+			log.WithFields(fields).Warn("unlocking LUKS volume: ", err)
+			continue
+		}
+		index++
+		volume.Index = index
+		volume.Filesystem = detectFilesystem(volume.Data(r), 0)
+		log.WithFields(fields).WithField("volume", volume.Index).Info("unlocked LUKS volume")
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
+
+// unlockLUKS returns the decrypted volume of a LUKS partition.
+func unlockLUKS(r io.ReaderAt, p Partition, keys [][]byte) (Partition, error) {
+	data := p.Data(r)
+	h, err := readLUKSHeader(data)
+	if err != nil {
+		return Partition{}, err
+	}
+
+	// The volume cipher is checked before the slow key derivation.
+	if _, err := newSectorCipher(h.encryption, make([]byte, h.keySize)); err != nil {
+		return Partition{}, err
+	}
+
+	var (
+		volumeKey  []byte
+		cryptsetup bool
+	)
+	for _, key := range keys {
+		for _, slot := range h.keyslots {
+			if slot.kdf != "pbkdf2" {
+				cryptsetup = true
+				continue
+			}
+			k, err := slot.unlock(data, key)
+			if err != nil {
+				return Partition{}, fmt.Errorf("keyslot %s: %w", slot.name, err)
+			}
+			if h.verify(k) {
+				log.WithFields(log.Fields{
+					"partition": p.Index,
+					"keyslot":   slot.name,
+				}).Debug("unlocked LUKS keyslot")
+				volumeKey = k
+				break
+			}
+		}
+		if volumeKey != nil {
+			break
+		}
+	}
+	if volumeKey == nil && cryptsetup {
+		if volumeKey, err = cryptsetupVolumeKey(data, h, keys); err != nil {
+			return Partition{}, err
+		}
+		if !h.verify(volumeKey) {
+			return Partition{}, fmt.Errorf("volume key recovered using %s does not match the digest", cryptsetupCommand)
+		}
+	}
+	if volumeKey == nil {
+		return Partition{}, fmt.Errorf("no keyslot can be unlocked using the key")
+	}
+
+	c, err := newSectorCipher(h.encryption, volumeKey)
+	if err != nil {
+		return Partition{}, err
+	}
+	size := h.size
+	if size == 0 {
+		size = p.Size - h.offset
+	}
+	if size <= 0 || h.offset+size > p.Size {
+		return Partition{}, fmt.Errorf("volume data at offset %d exceeds the partition", h.offset)
+	}
+
+	name := "luks-" + h.uuid
+	if p.Name != "" {
+		name = p.Name + " " + name
+	}
+	parent := p
+	return Partition{
+		Offset: h.offset,
+		Size:   size,
+		Type:   "LUKS",
+		Name:   name,
+		parent: &parent,
+		luks: &luksVolume{
+			cipher:     c,
+			sectorSize: h.sectorSize,
+			ivTweak:    h.ivTweak,
+		},
+	}, nil
+}
+
+// readLUKSHeader reads the LUKS1 or LUKS2 header at the start of the data.
+func readLUKSHeader(r io.ReaderAt) (luksHeader, error) {
+	hdr := make([]byte, 592)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return luksHeader{}, err
+	}
+	if !bytes.HasPrefix(hdr, []byte("LUKS\xba\xbe")) {
+		return luksHeader{}, fmt.Errorf("LUKS header not found")
+	}
+	switch version := binary.BigEndian.Uint16(hdr[6:]); version {
+	case 1:
+		return parseLUKS1Header(hdr)
+	case 2:
+		return readLUKS2Header(r)
+	default:
+		return luksHeader{}, fmt.Errorf("unsupported LUKS version %d", version)
+	}
+}
+
+// parseLUKS1Header parses the LUKS1 header and the keyslot table.
+func parseLUKS1Header(hdr []byte) (luksHeader, error) {
+	be := binary.BigEndian
+	h := luksHeader{
+		version:          1,
+		encryption:       cString(hdr[8:40]) + "-" + cString(hdr[40:72]),
+		digestHash:       cString(hdr[72:104]),
+		offset:           int64(be.Uint32(hdr[104:])) * luksKeyAreaSector,
+		keySize:          int(be.Uint32(hdr[108:])),
+		digest:           hdr[112:132],
+		digestSalt:       hdr[132:164],
+		digestIterations: int(be.Uint32(hdr[164:])),
+		uuid:             cString(hdr[168:208]),
+		sectorSize:       luksKeyAreaSector,
+	}
+	if h.keySize <= 0 || h.keySize > 256 {
+		return luksHeader{}, fmt.Errorf("invalid key size %d", h.keySize)
+	}
+
+	for i := 0; i < luks1Keyslots; i++ {
+		slot := hdr[208+i*48 : 208+(i+1)*48]
+		if be.Uint32(slot) != luksKeyslotEnabled {
+			continue
+		}
+		h.keyslots = append(h.keyslots, luksKeyslot{
+			name:       strconv.Itoa(i),
+			kdf:        "pbkdf2",
+			hash:       h.digestHash,
+			iterations: int(be.Uint32(slot[4:])),
+			salt:       slot[8:40],
+			keySize:    h.keySize,
+			encryption: h.encryption,
+			offset:     int64(be.Uint32(slot[40:])) * luksKeyAreaSector,
+			stripes:    int(be.Uint32(slot[44:])),
+			afHash:     h.digestHash,
+		})
+	}
+	if len(h.keyslots) == 0 {
+		return luksHeader{}, fmt.Errorf("no active keyslots")
+	}
+	return h, nil
+}
+
+// luks2Metadata is the JSON metadata of a LUKS2 header. The numbers stored
+// as strings are 64-bit values.
+type luks2Metadata struct {
+	Keyslots map[string]struct {
+		Type    string `json:"type"`
+		KeySize int    `json:"key_size"`
+		AF      struct {
+			Type    string `json:"type"`
+			Stripes int    `json:"stripes"`
+			Hash    string `json:"hash"`
+		} `json:"af"`
+		Area struct {
+			Type       string `json:"type"`
+			Offset     string `json:"offset"`
+			Encryption string `json:"encryption"`
+			KeySize    int    `json:"key_size"`
+		} `json:"area"`
+		KDF struct {
+			Type       string `json:"type"`
+			Hash       string `json:"hash"`
+			Iterations int    `json:"iterations"`
+			Salt       string `json:"salt"`
+		} `json:"kdf"`
+	} `json:"keyslots"`
+	Segments map[string]struct {
+		Type       string `json:"type"`
+		Offset     string `json:"offset"`
+		Size       string `json:"size"`
+		IVTweak    string `json:"iv_tweak"`
+		Encryption string `json:"encryption"`
+		SectorSize int64  `json:"sector_size"`
+	} `json:"segments"`
+	Digests map[string]struct {
+		Type       string   `json:"type"`
+		Keyslots   []string `json:"keyslots"`
+		Segments   []string `json:"segments"`
+		Hash       string   `json:"hash"`
+		Iterations int      `json:"iterations"`
+		Salt       string   `json:"salt"`
+		Digest     string   `json:"digest"`
+	} `json:"digests"`
+}
+
+// readLUKS2Header reads the LUKS2 header with the highest sequence ID. The
+// secondary header follows the primary header.
+func readLUKS2Header(r io.ReaderAt) (luksHeader, error) {
+	var (
+		metadata []byte
+		seqid    uint64
+		err      error
+	)
+	for offset := int64(0); ; {
+		data, id, size, herr := readLUKS2Binary(r, offset)
+		if herr != nil {
+			if offset == 0 {
+				log.Debug("reading primary LUKS2 header: ", herr)
+				// The secondary header is searched at the default
+				// offset.
+				offset = 16384
+				err = herr
+				continue
+			}
+			if metadata == nil {
+				return luksHeader{}, err
+			}
+			break
+		}
+		if metadata == nil || id > seqid {
+			metadata, seqid = data, id
+		}
+		if offset != 0 {
+			break
+		}
+		offset = size
+	}
+
+	var m luks2Metadata
+	if err := json.Unmarshal(bytes.TrimRight(metadata, "\x00"), &m); err != nil {
+		return luksHeader{}, fmt.Errorf("parsing LUKS2 metadata: %w", err)
+	}
+	return parseLUKS2Metadata(m)
+}
+
+// readLUKS2Binary reads the LUKS2 binary header at the offset and returns
+// the JSON metadata, the sequence ID, and the header size. The header
+// checksum is verified.
+func readLUKS2Binary(r io.ReaderAt, offset int64) ([]byte, uint64, int64, error) {
+	be := binary.BigEndian
+	hdr := make([]byte, luks2BinaryHeaderSize)
+	if _, err := r.ReadAt(hdr, offset); err != nil {
+		return nil, 0, 0, err
+	}
+	if !bytes.HasPrefix(hdr, []byte("LUKS\xba\xbe")) && !bytes.HasPrefix(hdr, []byte("SKUL\xba\xbe")) {
+		return nil, 0, 0, fmt.Errorf("LUKS2 header not found at offset %d", offset)
+	}
+	size := int64(be.Uint64(hdr[8:]))
+	if size <= luks2BinaryHeaderSize || size > luks2MaxHeaderSize {
+		return nil, 0, 0, fmt.Errorf("invalid LUKS2 header size %d", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, offset); err != nil {
+		return nil, 0, 0, err
+	}
+	if alg := cString(hdr[72:104]); alg == "sha256" {
+		csum := append([]byte(nil), data[448:480]...)
+		for i := 448; i < 512; i++ {
+			data[i] = 0
+		}
+		if sum := sha256.Sum256(data); !bytes.Equal(sum[:], csum) {
+			return nil, 0, 0, fmt.Errorf("LUKS2 header checksum mismatch at offset %d", offset)
+		}
+	}
+	return data[luks2BinaryHeaderSize:], be.Uint64(hdr[16:]), size, nil
+}
+
+// parseLUKS2Metadata returns the header of the first crypt segment and the
+// keyslots of the segment digest.
+func parseLUKS2Metadata(m luks2Metadata) (luksHeader, error) {
+	var names []string
+	for name, s := range m.Segments {
+		if s.Type == "crypt" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return luksHeader{}, fmt.Errorf("no crypt segment found")
+	}
+	sort.Strings(names)
+	segment := m.Segments[names[0]]
+	if len(names) > 1 {
+		log.WithField("segments", len(names)).Warn("LUKS2 volume has several segments i.e. reencryption in progress. Reading the first segment")
+	}
+
+	h := luksHeader{
+		version:    2,
+		encryption: segment.Encryption,
+		sectorSize: segment.SectorSize,
+	}
+	var err error
+	if h.offset, err = strconv.ParseInt(segment.Offset, 10, 64); err != nil {
+		return luksHeader{}, fmt.Errorf("invalid segment offset %q", segment.Offset)
+	}
+	if segment.Size != "dynamic" {
+		if h.size, err = strconv.ParseInt(segment.Size, 10, 64); err != nil {
+			return luksHeader{}, fmt.Errorf("invalid segment size %q", segment.Size)
+		}
+	}
+	if segment.IVTweak != "" {
+		if h.ivTweak, err = strconv.ParseUint(segment.IVTweak, 10, 64); err != nil {
+			return luksHeader{}, fmt.Errorf("invalid segment IV tweak %q", segment.IVTweak)
+		}
+	}
+	if h.sectorSize == 0 {
+		h.sectorSize = luksKeyAreaSector
+	}
+	if h.sectorSize%luksKeyAreaSector != 0 {
+		return luksHeader{}, fmt.Errorf("invalid sector size %d", h.sectorSize)
+	}
+
+	// The digest of the segment lists the keyslots holding the volume key.
+	var slots []string
+	for _, d := range m.Digests {
+		if d.Type != "pbkdf2" || !luksReferences(d.Segments, names[0]) {
+			continue
+		}
+		h.digestHash = d.Hash
+		h.digestIterations = d.Iterations
+		if h.digestSalt, err = base64.StdEncoding.DecodeString(d.Salt); err != nil {
+			return luksHeader{}, fmt.Errorf("invalid digest salt: %w", err)
+		}
+		if h.digest, err = base64.StdEncoding.DecodeString(d.Digest); err != nil {
+			return luksHeader{}, fmt.Errorf("invalid digest: %w", err)
+		}
+		slots = d.Keyslots
+		break
+	}
+	if h.digest == nil {
+		return luksHeader{}, fmt.Errorf("no volume key digest found")
+	}
+
+	sort.Strings(slots)
+	for _, name := range slots {
+		k, found := m.Keyslots[name]
+		if !found || k.Type != "luks2" || k.AF.Type != "luks1" || k.Area.Type != "raw" {
+			continue
+		}
+		slot := luksKeyslot{
+			name:       name,
+			kdf:        k.KDF.Type,
+			hash:       k.KDF.Hash,
+			iterations: k.KDF.Iterations,
+			keySize:    k.KeySize,
+			encryption: k.Area.Encryption,
+			stripes:    k.AF.Stripes,
+			afHash:     k.AF.Hash,
+		}
+		if slot.salt, err = base64.StdEncoding.DecodeString(k.KDF.Salt); err != nil {
+			return luksHeader{}, fmt.Errorf("keyslot %s: invalid salt: %w", name, err)
+		}
+		if slot.offset, err = strconv.ParseInt(k.Area.Offset, 10, 64); err != nil {
+			return luksHeader{}, fmt.Errorf("keyslot %s: invalid area offset %q", name, k.Area.Offset)
+		}
+		h.keySize = k.KeySize
+		h.keyslots = append(h.keyslots, slot)
+	}
+	if len(h.keyslots) == 0 {
+		return luksHeader{}, fmt.Errorf("no keyslots found for segment %s", names[0])
+	}
+	return h, nil
+}
+
+// unlock returns the volume key decrypted from the keyslot using the key.
+// The volume key is not verified.
+func (k luksKeyslot) unlock(r io.ReaderAt, key []byte) ([]byte, error) {
+	h, err := luksHash(k.hash)
+	if err != nil {
+		return nil, err
+	}
+	if k.iterations <= 0 || k.stripes <= 0 || k.keySize <= 0 {
+		return nil, fmt.Errorf("invalid keyslot parameters")
+	}
+
+	size := k.keySize * k.stripes
+	material := make([]byte, (size+luksKeyAreaSector-1)/luksKeyAreaSector*luksKeyAreaSector)
+	if _, err := r.ReadAt(material, k.offset); err != nil {
+		return nil, fmt.Errorf("reading key material: %w", err)
+	}
+
+	c, err := newSectorCipher(k.encryption, pbkdf2Key(h, key, k.salt, k.iterations, k.keySize))
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(material); i += luksKeyAreaSector {
+		c.decrypt(material[i:i+luksKeyAreaSector], uint64(i/luksKeyAreaSector))
+	}
+	return afMerge(material[:size], k.keySize, k.stripes, k.afHash)
+}
+
+// verify returns true if the volume key matches the digest.
+func (h luksHeader) verify(volumeKey []byte) bool {
+	hf, err := luksHash(h.digestHash)
+	if err != nil || len(volumeKey) != h.keySize {
+		return false
+	}
+	digest := pbkdf2Key(hf, volumeKey, h.digestSalt, h.digestIterations, len(h.digest))
+	return hmac.Equal(digest, h.digest)
+}
+
+// cryptsetupVolumeKey recovers the volume key using cryptsetup. The LUKS
+// header and the keyslot areas are copied to a temporary file, so the
+// evidence is not opened by cryptsetup.
+func cryptsetupVolumeKey(r io.ReaderAt, h luksHeader, keys [][]byte) ([]byte, error) {
+	path, err := exec.LookPath(cryptsetupCommand)
+	if err != nil {
+		return nil, fmt.Errorf("unlocking a keyslot using argon2 requires %s: %w", cryptsetupCommand, err)
+	}
+
+	header, err := os.CreateTemp("", "container-explorer-luks-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(header.Name())
+	_, err = io.Copy(header, io.NewSectionReader(r, 0, h.offset))
+	if cerr := header.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("copying LUKS header: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "container-explorer-luks-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	keyfile := dir + "/volume.key"
+
+	err = fmt.Errorf("no keys")
+	for _, key := range keys {
+		// cryptsetup older than 2.4 names the volume key master key.
+		for _, opts := range [][]string{
+			{"--dump-volume-key", "--volume-key-file", keyfile},
+			{"--dump-master-key", "--master-key-file", keyfile},
+		} {
+			args := append([]string{"luksDump", "--batch-mode", "--key-file", "-"}, opts...)
+			cmd := exec.Command(path, append(args, header.Name())...)
+			cmd.Stdin = bytes.NewReader(key)
+			out, cerr := cmd.CombinedOutput()
+			if cerr == nil {
+				return os.ReadFile(keyfile)
+			}
+			err = fmt.Errorf("running %s: %v %s", cryptsetupCommand, cerr, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil, err
+}
+
+// afMerge recovers the key split into stripes by the LUKS anti-forensic
+// splitter.
+func afMerge(material []byte, keySize int, stripes int, hashName string) ([]byte, error) {
+	h, err := luksHash(hashName)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, keySize)
+	for i := 0; i < stripes-1; i++ {
+		xorBytes(key, material[i*keySize:(i+1)*keySize])
+		key = afDiffuse(h, key)
+	}
+	xorBytes(key, material[(stripes-1)*keySize:])
+	return key, nil
+}
+
+// afDiffuse hashes each digest sized block of the data prefixed with the
+// block number.
+func afDiffuse(h func() hash.Hash, data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	size := h().Size()
+	for i := 0; i*size < len(data); i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		d := h()
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(i))
+		d.Write(n[:])
+		d.Write(data[i*size : end])
+		out = append(out, d.Sum(nil)[:end-i*size]...)
+	}
+	return out
+}
+
+// pbkdf2Key derives a key from the password using PBKDF2 (RFC 8018).
+func pbkdf2Key(h func() hash.Hash, password []byte, salt []byte, iterations int, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	size := prf.Size()
+	key := make([]byte, 0, keyLen+size)
+	u := make([]byte, size)
+	t := make([]byte, size)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], block)
+		prf.Write(n[:])
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			xorBytes(t, u)
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// luksHash returns the hash function of a LUKS hash name.
+func luksHash(name string) (func() hash.Hash, error) {
+	switch strings.ToLower(name) {
+	case "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported hash %q", name)
+	}
+}
+
+// sectorCipher decrypts the sectors of a LUKS volume or a keyslot area.
+type sectorCipher interface {
+	decrypt(sector []byte, iv uint64)
+}
+
+// newSectorCipher returns the cipher of a dm-crypt cipher specification
+// i.e. aes-xts-plain64 or aes-cbc-essiv:sha256.
+func newSectorCipher(spec string, key []byte) (sectorCipher, error) {
+	parts := strings.SplitN(spec, "-", 3)
+	if len(parts) != 3 || parts[0] != "aes" {
+		return nil, fmt.Errorf("unsupported cipher %q", spec)
+	}
+	mode, iv := parts[1], parts[2]
+	if iv != "plain64" && iv != "plain" && !(mode == "cbc" && iv == "essiv:sha256") {
+		return nil, fmt.Errorf("unsupported cipher %q", spec)
+	}
+
+	switch mode {
+	case "xts":
+		if len(key)%2 != 0 {
+			return nil, fmt.Errorf("invalid key size %d for %s", len(key), spec)
+		}
+		data, err := aes.NewCipher(key[:len(key)/2])
+		if err != nil {
+			return nil, err
+		}
+		tweak, err := aes.NewCipher(key[len(key)/2:])
+		if err != nil {
+			return nil, err
+		}
+		return xtsCipher{data: data, tweak: tweak, plain: iv == "plain"}, nil
+	case "cbc":
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		c := cbcCipher{block: block, plain: iv == "plain"}
+		if iv == "essiv:sha256" {
+			salt := sha256.Sum256(key)
+			if c.essiv, err = aes.NewCipher(salt[:]); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	default:
+		return nil, fmt.Errorf("unsupported cipher %q", spec)
+	}
+}
+
+// xtsCipher is AES in XTS mode (IEEE 1619) with the sector number as the
+// tweak.
+type xtsCipher struct {
+	data  cipher.Block
+	tweak cipher.Block
+	plain bool // 32-bit sector number
+}
+
+func (c xtsCipher) decrypt(sector []byte, iv uint64) {
+	if c.plain {
+		iv &= 0xffffffff
+	}
+	var t [aes.BlockSize]byte
+	binary.LittleEndian.PutUint64(t[:], iv)
+	c.tweak.Encrypt(t[:], t[:])
+
+	for i := 0; i+aes.BlockSize <= len(sector); i += aes.BlockSize {
+		b := sector[i : i+aes.BlockSize]
+		xorBytes(b, t[:])
+		c.data.Decrypt(b, b)
+		xorBytes(b, t[:])
+
+		// Multiply the tweak by the primitive element of GF(2^128).
+		carry := t[aes.BlockSize-1] >> 7
+		for j := aes.BlockSize - 1; j > 0; j-- {
+			t[j] = t[j]<<1 | t[j-1]>>7
+		}
+		t[0] <<= 1
+		if carry != 0 {
+			t[0] ^= 0x87
+		}
+	}
+}
+
+// cbcCipher is AES in CBC mode with the sector number or the encrypted
+// sector number (ESSIV) as the initialization vector.
+type cbcCipher struct {
+	block cipher.Block
+	essiv cipher.Block
+	plain bool // 32-bit sector number
+}
+
+func (c cbcCipher) decrypt(sector []byte, iv uint64) {
+	if c.plain {
+		iv &= 0xffffffff
+	}
+	v := make([]byte, aes.BlockSize)
+	binary.LittleEndian.PutUint64(v, iv)
+	if c.essiv != nil {
+		c.essiv.Encrypt(v, v)
+	}
+	cipher.NewCBCDecrypter(c.block, v).CryptBlocks(sector, sector)
+}
+
+// reader returns the reader of the decrypted volume data.
+func (v *luksVolume) reader(r io.ReaderAt) io.ReaderAt {
+	return luksReader{r: r, volume: v}
+}
+
+// luksReader decrypts the sectors of the volume data read from r.
+type luksReader struct {
+	r      io.ReaderAt
+	volume *luksVolume
+}
+
+// ReadAt reads the decrypted volume data at the offset.
+//
+// The initialization vector is the sector number in units of the volume
+// sector size.
+func (l luksReader) ReadAt(p []byte, off int64) (int, error) {
+	size := l.volume.sectorSize
+	start := off / size * size
+	end := (off + int64(len(p)) + size - 1) / size * size
+
+	buf := make([]byte, end-start)
+	n, err := l.r.ReadAt(buf, start)
+	for i := int64(0); i+size <= int64(n); i += size {
+		l.volume.cipher.decrypt(buf[i:i+size], uint64((start+i)/size)+l.volume.ivTweak)
+	}
+
+	// Only the complete sectors are decrypted.
+	available := int64(n) / size * size
+	if available <= off-start {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	copied := copy(p, buf[off-start:available])
+	if copied < len(p) {
+		if err == nil {
+			err = io.EOF
+		}
+		return copied, err
+	}
+	return copied, nil
+}
+
+// luksReferences returns true if the metadata object names include the
+// name.
+func luksReferences(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// xorBytes stores the exclusive or of dst and src in dst.
+func xorBytes(dst []byte, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// cString returns the string of a NUL terminated byte array.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The LUKS volumes in testdata/luks are written by generate.py using an
+// independent implementation of PBKDF2, AES, and the anti-forensic splitter.
+const luksTestPassphrase = "container-explorer"
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestPBKDF2Key checks the test vectors of RFC 6070 (HMAC-SHA1) and RFC 7914
+// section 11 (HMAC-SHA256).
+func TestPBKDF2Key(t *testing.T) {
+	tests := []struct {
+		hash       func() hash.Hash
+		password   string
+		salt       string
+		iterations int
+		key        string
+	}{
+		{sha1.New, "password", "salt", 1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{sha1.New, "password", "salt", 2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{sha1.New, "password", "salt", 4096, "4b007901b765489abead49d926f721d065a429c1"},
+		{sha1.New, "passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+		{sha1.New, "pass\x00word", "sa\x00lt", 4096, "56fa6aa75548099dcc37d7f03425e0c3"},
+		{sha256.New, "passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{sha256.New, "Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tc := range tests {
+		want := mustDecodeHex(t, tc.key)
+		got := pbkdf2Key(tc.hash, []byte(tc.password), []byte(tc.salt), tc.iterations, len(want))
+		if !bytes.Equal(got, want) {
+			t.Errorf("pbkdf2Key(%q, %q, %d) = %x, want %x", tc.password, tc.salt, tc.iterations, got, want)
+		}
+	}
+}
+
+// sequence returns the plaintext of the 512 byte IEEE 1619 vectors.
+func sequence() []byte {
+	b := make([]byte, 512)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+// TestXTSDecrypt checks the XTS-AES test vectors of IEEE 1619-2007 annex B.
+// The key is the data key followed by the tweak key as in dm-crypt.
+func TestXTSDecrypt(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		sector     uint64
+		plaintext  []byte
+		ciphertext string
+	}{
+		{
+			name:       "vector 1",
+			key:        "0000000000000000000000000000000000000000000000000000000000000000",
+			plaintext:  make([]byte, 32),
+			ciphertext: "917cf69ebd68b2ec9b9fe9a3eadda692cd43d2f59598ed858c02c2652fbf922e",
+		},
+		{
+			name:       "vector 2",
+			key:        "1111111111111111111111111111111122222222222222222222222222222222",
+			sector:     0x3333333333,
+			plaintext:  bytes.Repeat([]byte{0x44}, 32),
+			ciphertext: "c454185e6a16936e39334038acef838bfb186fff7480adc4289382ecd6d394f0",
+		},
+		{
+			name:       "vector 3",
+			key:        "fffefdfcfbfaf9f8f7f6f5f4f3f2f1f022222222222222222222222222222222",
+			sector:     0x3333333333,
+			plaintext:  bytes.Repeat([]byte{0x44}, 32),
+			ciphertext: "af85336b597afc1a900b2eb21ec949d292df4c047e0b21532186a5971a227a89",
+		},
+		{
+			name:      "vector 4",
+			key:       "2718281828459045235360287471352631415926535897932384626433832795",
+			plaintext: sequence(),
+			ciphertext: "27a7479befa1d476489f308cd4cfa6e2a96e4bbe3208ff25287dd3819616e89c" +
+				"c78cf7f5e543445f8333d8fa7f56000005279fa5d8b5e4ad40e736ddb4d35412" +
+				"328063fd2aab53e5ea1e0a9f332500a5df9487d07a5c92cc512c8866c7e860ce" +
+				"93fdf166a24912b422976146ae20ce846bb7dc9ba94a767aaef20c0d61ad0265" +
+				"5ea92dc4c4e41a8952c651d33174be51a10c421110e6d81588ede82103a252d8" +
+				"a750e8768defffed9122810aaeb99f9172af82b604dc4b8e51bcb08235a6f434" +
+				"1332e4ca60482a4ba1a03b3e65008fc5da76b70bf1690db4eae29c5f1badd03c" +
+				"5ccf2a55d705ddcd86d449511ceb7ec30bf12b1fa35b913f9f747a8afd1b130e" +
+				"94bff94effd01a91735ca1726acd0b197c4e5b03393697e126826fb6bbde8ecc" +
+				"1e08298516e2c9ed03ff3c1b7860f6de76d4cecd94c8119855ef5297ca67e9f3" +
+				"e7ff72b1e99785ca0a7e7720c5b36dc6d72cac9574c8cbbc2f801e23e56fd344" +
+				"b07f22154beba0f08ce8891e643ed995c94d9a69c9f1b5f499027a78572aeebd" +
+				"74d20cc39881c213ee770b1010e4bea718846977ae119f7a023ab58cca0ad752" +
+				"afe656bb3c17256a9f6e9bf19fdd5a38fc82bbe872c5539edb609ef4f79c203e" +
+				"bb140f2e583cb2ad15b4aa5b655016a8449277dbd477ef2c8d6c017db738b18d" +
+				"eb4a427d1923ce3ff262735779a418f20a282df920147beabe421ee5319d0568",
+		},
+		{
+			name: "vector 10",
+			key: "2718281828459045235360287471352662497757247093699959574966967627" +
+				"3141592653589793238462643383279502884197169399375105820974944592",
+			sector:    0xff,
+			plaintext: sequence(),
+			ciphertext: "1c3b3a102f770386e4836c99e370cf9bea00803f5e482357a4ae12d414a3e63b" +
+				"5d31e276f8fe4a8d66b317f9ac683f44680a86ac35adfc3345befecb4bb188fd" +
+				"5776926c49a3095eb108fd1098baec70aaa66999a72a82f27d848b21d4a741b0" +
+				"c5cd4d5fff9dac89aeba122961d03a757123e9870f8acf1000020887891429ca" +
+				"2a3e7a7d7df7b10355165c8b9a6d0a7de8b062c4500dc4cd120c0f7418dae3d0" +
+				"b5781c34803fa75421c790dfe1de1834f280d7667b327f6c8cd7557e12ac3a0f" +
+				"93ec05c52e0493ef31a12d3d9260f79a289d6a379bc70c50841473d1a8cc81ec" +
+				"583e9645e07b8d9670655ba5bbcfecc6dc3966380ad8fecb17b6ba02469a020a" +
+				"84e18e8f84252070c13e9f1f289be54fbc481457778f616015e1327a02b140f1" +
+				"505eb309326d68378f8374595c849d84f4c333ec4423885143cb47bd71c5edae" +
+				"9be69a2ffeceb1bec9de244fbe15992b11b77c040f12bd8f6a975a44a0f90c29" +
+				"a9abc3d4d893927284c58754cce294529f8614dcd2aba991925fedc4ae74ffac" +
+				"6e333b93eb4aff0479da9a410e4450e0dd7ae4c6e2910900575da401fc07059f" +
+				"645e8b7e9bfdef33943054ff84011493c27b3429eaedb4ed5376441a77ed4385" +
+				"1ad77f16f541dfd269d50d6a5f14fb0aab1cbb4c1550be97f7ab4066193c4caa" +
+				"773dad38014bd2092fa755c824bb5e54c4f36ffda9fcea70b9c6e693e148c151",
+		},
+	}
+	for _, tc := range tests {
+		c, err := newSectorCipher("aes-xts-plain64", mustDecodeHex(t, tc.key))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		sector := mustDecodeHex(t, tc.ciphertext)
+		c.decrypt(sector, tc.sector)
+		if !bytes.Equal(sector, tc.plaintext) {
+			t.Errorf("%s: decrypted %x, want %x", tc.name, sector, tc.plaintext)
+		}
+	}
+}
+
+// TestCBCDecrypt checks AES-CBC with the ESSIV initialization vector. The
+// ciphertexts are computed using openssl with the IV encrypted using the
+// SHA-256 digest of the key.
+func TestCBCDecrypt(t *testing.T) {
+	plaintext := []byte("container-explorer ESSIV sector.")
+	tests := []struct {
+		spec       string
+		key        string
+		sector     uint64
+		ciphertext string
+	}{
+		{"aes-cbc-essiv:sha256", "000102030405060708090a0b0c0d0e0f", 5, "c767b87113f338804c7b9edf9b3d3ee436c3fc020075953c4e6d6f1aff5c6101"},
+		{"aes-cbc-essiv:sha256", "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", 0x100000001, "65a25e98bdc45197485c58719354c044370eedfea3cec2ba6daaf3e59e484662"},
+	}
+	for _, tc := range tests {
+		c, err := newSectorCipher(tc.spec, mustDecodeHex(t, tc.key))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		sector := mustDecodeHex(t, tc.ciphertext)
+		c.decrypt(sector, tc.sector)
+		if !bytes.Equal(sector, plaintext) {
+			t.Errorf("%s sector %d: decrypted %q, want %q", tc.spec, tc.sector, sector, plaintext)
+		}
+	}
+}
+
+// TestPlainIV checks that the plain IV is the sector number truncated to 32
+// bits and the plain64 IV is the full sector number using vector 1 of IEEE
+// 1619 i.e. sector 0.
+func TestPlainIV(t *testing.T) {
+	key := make([]byte, 32)
+	ciphertext := mustDecodeHex(t, "917cf69ebd68b2ec9b9fe9a3eadda692cd43d2f59598ed858c02c2652fbf922e")
+	plaintext := make([]byte, 32)
+
+	for _, tc := range []struct {
+		spec  string
+		match bool
+	}{
+		{"aes-xts-plain", true},
+		{"aes-xts-plain64", false},
+	} {
+		c, err := newSectorCipher(tc.spec, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sector := append([]byte(nil), ciphertext...)
+		c.decrypt(sector, 1<<32)
+		if bytes.Equal(sector, plaintext) != tc.match {
+			t.Errorf("%s sector 1<<32: decrypted %x, sector 0 plaintext %x", tc.spec, sector, plaintext)
+		}
+	}
+}
+
+// TestAFMerge merges a 40 byte key split into 3 stripes using SHA-256, so
+// the last diffusion block is shorter than the digest.
+func TestAFMerge(t *testing.T) {
+	material := mustDecodeHex(t, "27b25d65383d4aefef8c481470f66e49113c73204503c08f20f45c8e96dc8683"+
+		"2264b6e4e057f70f7a1004c1ab0a3871bfc0a4d8880b1bc02123ec5a462b6736"+
+		"2e8d0e3bfba3af1c2bac3ef676d25bc9944ffdaf8df834b7d63418a0598f01ce"+
+		"2709b80aa5c442043c24fd931ce9c81e3456fb4f0aa94e7a")
+	want := make([]byte, 40)
+	for i := range want {
+		want[i] = byte(100 + i)
+	}
+
+	got, err := afMerge(material, len(want), 3, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("afMerge = %x, want %x", got, want)
+	}
+
+	if _, err := afMerge(material, len(want), 3, "md5"); err == nil {
+		t.Error("afMerge with an unsupported hash returned no error")
+	}
+}
+
+// TestUnlockLUKS unlocks the LUKS1 and LUKS2 volumes of testdata/luks and
+// reads the decrypted sectors.
+func TestUnlockLUKS(t *testing.T) {
+	tests := []struct {
+		file       string
+		version    int
+		encryption string
+		keySize    int
+		offset     int64
+	}{
+		{"luks1.img", 1, "aes-cbc-essiv:sha256", 16, 69632},
+		{"luks2.img", 2, "aes-xts-plain64", 32, 163840},
+	}
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "luks", tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := bytes.NewReader(data)
+
+			h, err := readLUKSHeader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.version != tc.version || h.encryption != tc.encryption || h.keySize != tc.keySize || h.offset != tc.offset {
+				t.Errorf("header version %d, encryption %s, key size %d, offset %d, want %d, %s, %d, %d",
+					h.version, h.encryption, h.keySize, h.offset, tc.version, tc.encryption, tc.keySize, tc.offset)
+			}
+			if len(h.keyslots) != 1 || h.keyslots[0].stripes != 4000 {
+				t.Errorf("keyslots %+v, want keyslot 0 with 4000 stripes", h.keyslots)
+			}
+
+			p := Partition{Index: 1, Size: int64(len(data)), Filesystem: FilesystemLUKS}
+			if _, err := unlockLUKS(r, p, [][]byte{[]byte("wrong passphrase")}); err == nil {
+				t.Error("unlocking using a wrong passphrase returned no error")
+			}
+			volume, err := unlockLUKS(r, p, [][]byte{[]byte("wrong passphrase"), []byte(luksTestPassphrase)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if volume.Size != int64(len(data))-tc.offset {
+				t.Errorf("volume size %d, want %d", volume.Size, int64(len(data))-tc.offset)
+			}
+
+			// The sectors are read across the sector boundaries.
+			decrypted := make([]byte, volume.Size-100)
+			if _, err := volume.Data(r).ReadAt(decrypted, 100); err != nil {
+				t.Fatal(err)
+			}
+			for i := int64(0); i < volume.Size/luksKeyAreaSector; i++ {
+				want := fmt.Sprintf("LUKS%d sector %d\n", tc.version, i)
+				start := i*luksKeyAreaSector - 100
+				if start < 0 {
+					continue
+				}
+				if got := string(decrypted[start : start+int64(len(want))]); got != want {
+					t.Errorf("sector %d: %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestLUKS2SecondaryHeader reads the LUKS2 metadata from the secondary
+// header if the primary header checksum does not match.
+func TestLUKS2SecondaryHeader(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "luks", "luks2.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[luks2BinaryHeaderSize] ^= 0xff
+
+	if _, _, _, err := readLUKS2Binary(bytes.NewReader(data), 0); err == nil {
+		t.Fatal("reading a corrupted primary header returned no error")
+	}
+	h, err := readLUKSHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.version != 2 || len(h.keyslots) != 1 {
+		t.Errorf("header version %d with %d keyslots, want version 2 with 1 keyslot", h.version, len(h.keyslots))
+	}
+}
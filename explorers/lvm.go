@@ -0,0 +1,470 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// lvmLabelSectors is the number of sectors at the start of a physical
+	// volume searched for the label.
+	lvmLabelSectors = 4
+
+	// lvmMDAHeaderSize is the size of the metadata area header. The
+	// metadata text is a circular buffer after the header.
+	lvmMDAHeaderSize = 512
+
+	// lvmMaxMetadataSize is the maximum size of the metadata text.
+	lvmMaxMetadataSize = 16 * 1024 * 1024
+)
+
+// lvmMDAMagic is the magic of a metadata area header.
+var lvmMDAMagic = []byte(" LVM2 x[5A%r0N*>")
+
+// physicalVolume is an LVM physical volume found in a partition.
+type physicalVolume struct {
+	uuid     string
+	offset   int64 // media offset of the partition
+	metadata string
+
+	// parent is the partition holding the physical volume data if the
+	// data is not contiguous in the media data. The offset is relative to
+	// the parent data.
+	parent *Partition
+}
+
+// volumeGroup is the metadata of an LVM volume group.
+type volumeGroup struct {
+	name  string
+	id    string
+	seqno int64
+	tree  map[string]interface{}
+}
+
+// ReadLogicalVolumes returns the LVM logical volumes of the physical volumes
+// in the partitions as partitions. The filesystem of each logical volume is
+// detected from the superblock.
+//
+// A logical volume stored contiguously has the media offset of the data. A
+// linear logical volume with several segments has the media data ranges of
+// the segments. The striped, mirrored, RAID, and thin logical volumes are
+// not supported.
+func ReadLogicalVolumes(r io.ReaderAt, partitions []Partition) ([]Partition, error) {
+	index := 0
+	pvs := make(map[string]physicalVolume)
+	for _, p := range partitions {
+		if p.Index > index {
+			index = p.Index
+		}
+		if p.Filesystem != FilesystemLVM {
+			continue
+		}
+		offset := p.Offset
+		if !p.Contiguous() {
+			offset = 0
+		}
+		pv, err := readPhysicalVolume(p.Data(r), offset)
+		if err != nil {
+			log.WithField("partition", p.Index).Warn("reading LVM physical volume: ", err)
+			continue
+		}
+		if !p.Contiguous() {
+			// The physical volume is in an unlocked LUKS volume.
+			parent := p
+			pv.parent = &parent
+		}
+		pvs[pv.uuid] = pv
+	}
+	if len(pvs) == 0 {
+		return nil, nil
+	}
+
+	// Every physical volume holds a copy of the volume group metadata. The
+	// copy with the highest sequence number is current.
+	vgs := make(map[string]volumeGroup)
+	for _, pv := range pvs {
+		vg, err := parseVolumeGroup(pv.metadata)
+		if err != nil {
+			log.WithField("pv", pv.uuid).Warn("parsing LVM metadata: ", err)
+			continue
+		}
+		if current, found := vgs[vg.id]; !found || vg.seqno > current.seqno {
+			vgs[vg.id] = vg
+		}
+	}
+
+	var ids []string
+	for id := range vgs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var volumes []Partition
+	for _, id := range ids {
+		for _, lv := range vgs[id].logicalVolumes(pvs) {
+			index++
+			lv.Index = index
+			lv.Filesystem = detectFilesystem(lv.Data(r), 0)
+			volumes = append(volumes, lv)
+		}
+	}
+	return volumes, nil
+}
+
+// readPhysicalVolume reads the label and the metadata text of a physical
+// volume.
+func readPhysicalVolume(r io.ReaderAt, offset int64) (physicalVolume, error) {
+	le := binary.LittleEndian
+	sector := make([]byte, sectorSize)
+
+	label := int64(-1)
+	for i := int64(0); i < lvmLabelSectors; i++ {
+		if _, err := r.ReadAt(sector, i*sectorSize); err != nil {
+			return physicalVolume{}, err
+		}
+		if bytes.HasPrefix(sector, []byte("LABELONE")) && bytes.HasPrefix(sector[24:], []byte("LVM2")) {
+			label = i * sectorSize
+			break
+		}
+	}
+	if label < 0 {
+		return physicalVolume{}, fmt.Errorf("LVM label not found")
+	}
+
+	// The physical volume header follows the label header and lists the
+	// data areas and the metadata areas terminated by an empty entry.
+	header := sector[le.Uint32(sector[20:]):]
+	if len(header) < 40 {
+		return physicalVolume{}, fmt.Errorf("invalid physical volume header offset")
+	}
+	pv := physicalVolume{
+		uuid:   string(header[:32]),
+		offset: offset,
+	}
+	locations := header[40:]
+	next := func() (int64, int64, bool) {
+		if len(locations) < 16 {
+			return 0, 0, false
+		}
+		off, size := int64(le.Uint64(locations)), int64(le.Uint64(locations[8:]))
+		locations = locations[16:]
+		return off, size, off != 0
+	}
+	for {
+		if _, _, ok := next(); !ok {
+			break
+		}
+	}
+
+	for {
+		mdaoffset, mdasize, ok := next()
+		if !ok {
+			break
+		}
+		metadata, err := readMetadataArea(r, mdaoffset, mdasize)
+		if err != nil {
+			log.WithField("pv", pv.uuid).Debug("reading LVM metadata area: ", err)
+			continue
+		}
+		pv.metadata = metadata
+		return pv, nil
+	}
+	return physicalVolume{}, fmt.Errorf("no LVM metadata found")
+}
+
+// readMetadataArea returns the current metadata text of a metadata area.
+func readMetadataArea(r io.ReaderAt, offset int64, size int64) (string, error) {
+	le := binary.LittleEndian
+	header := make([]byte, lvmMDAHeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return "", err
+	}
+	if !bytes.Equal(header[4:20], lvmMDAMagic) {
+		return "", fmt.Errorf("invalid metadata area magic")
+	}
+
+	// The first raw location is the current metadata text. The text wraps
+	// to the start of the circular buffer after the header.
+	textoffset := int64(le.Uint64(header[40:]))
+	textsize := int64(le.Uint64(header[48:]))
+	if textoffset == 0 || textsize == 0 || textsize > lvmMaxMetadataSize {
+		return "", fmt.Errorf("no metadata text")
+	}
+
+	text := make([]byte, textsize)
+	first := textsize
+	if textoffset+textsize > size {
+		first = size - textoffset
+	}
+	if _, err := r.ReadAt(text[:first], offset+textoffset); err != nil {
+		return "", err
+	}
+	if first < textsize {
+		if _, err := r.ReadAt(text[first:], offset+lvmMDAHeaderSize); err != nil {
+			return "", err
+		}
+	}
+	return string(bytes.TrimRight(text, "\x00")), nil
+}
+
+// parseVolumeGroup parses the volume group metadata text.
+func parseVolumeGroup(text string) (volumeGroup, error) {
+	tree, err := parseLVMConfig(text)
+	if err != nil {
+		return volumeGroup{}, err
+	}
+
+	// The volume group is the only section at the top level.
+	for name, v := range tree {
+		section, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		vg := volumeGroup{
+			name: name,
+			tree: section,
+		}
+		vg.id, _ = section["id"].(string)
+		vg.seqno, _ = section["seqno"].(int64)
+		return vg, nil
+	}
+	return volumeGroup{}, fmt.Errorf("no volume group found")
+}
+
+// logicalVolumes returns the visible linear logical volumes of the volume
+// group as partitions.
+func (vg volumeGroup) logicalVolumes(pvs map[string]physicalVolume) []Partition {
+	extentsize, _ := vg.tree["extent_size"].(int64)
+	extentsize *= sectorSize
+
+	// The physical volumes are named pv0, pv1 in the metadata.
+	pvnames := make(map[string]physicalVolume)
+	pestart := make(map[string]int64)
+	sections, _ := vg.tree["physical_volumes"].(map[string]interface{})
+	for name, v := range sections {
+		section, _ := v.(map[string]interface{})
+		id, _ := section["id"].(string)
+		pv, found := pvs[strings.ReplaceAll(id, "-", "")]
+		if !found {
+			continue
+		}
+		pvnames[name] = pv
+		pestart[name], _ = section["pe_start"].(int64)
+	}
+
+	var names []string
+	lvs, _ := vg.tree["logical_volumes"].(map[string]interface{})
+	for name := range lvs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var volumes []Partition
+	for _, name := range names {
+		lv, _ := lvs[name].(map[string]interface{})
+		fields := log.Fields{"vg": vg.name, "lv": name}
+		if !lvmFlag(lv, "status", "VISIBLE") && !lvmFlag(lv, "flags", "VISIBLE") {
+			// The hidden volumes are the internal volumes of the pools
+			// and the mirrors.
+			continue
+		}
+
+		var (
+			segments []PartitionSegment
+			parent   *Partition
+			err      error
+		)
+		count, _ := lv["segment_count"].(int64)
+		for i := int64(1); i <= count && err == nil; i++ {
+			segment, _ := lv[fmt.Sprintf("segment%d", i)].(map[string]interface{})
+			segtype, _ := segment["type"].(string)
+			stripes, _ := segment["stripes"].([]interface{})
+			extents, _ := segment["extent_count"].(int64)
+			if segtype != "striped" || len(stripes) != 2 {
+				err = fmt.Errorf("unsupported segment type %s with %d stripes", segtype, len(stripes)/2)
+				break
+			}
+			pvname, _ := stripes[0].(string)
+			pe, _ := stripes[1].(int64)
+			pv, found := pvnames[pvname]
+			if !found {
+				err = fmt.Errorf("physical volume %s is not in the disk image", pvname)
+				break
+			}
+			if i > 1 && pv.parent != parent {
+				err = fmt.Errorf("segments span physical volumes of several encrypted volumes")
+				break
+			}
+			parent = pv.parent
+
+			s := PartitionSegment{
+				Offset: pv.offset + pestart[pvname]*sectorSize + pe*extentsize,
+				Size:   extents * extentsize,
+			}
+			if n := len(segments); n > 0 && segments[n-1].Offset+segments[n-1].Size == s.Offset {
+				segments[n-1].Size += s.Size
+				continue
+			}
+			segments = append(segments, s)
+		}
+		if err == nil && len(segments) == 0 {
+			err = fmt.Errorf("no segments found")
+		}
+		if err != nil {
+			log.WithFields(fields).Warn("skipping logical volume: ", err)
+			continue
+		}
+
+		p := Partition{
+			Offset: segments[0].Offset,
+			Type:   "LVM",
+			Name:   vg.name + "/" + name,
+			parent: parent,
+		}
+		for _, s := range segments {
+			p.Size += s.Size
+		}
+		if len(segments) > 1 {
+			p.Segments = segments
+		}
+		volumes = append(volumes, p)
+	}
+	return volumes
+}
+
+// lvmFlag returns true if the flag is in the array value of the key.
+func lvmFlag(section map[string]interface{}, key string, flag string) bool {
+	values, _ := section[key].([]interface{})
+	for _, v := range values {
+		if v == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLVMConfig parses the LVM configuration syntax of the metadata text.
+// A section is a map, an array is a slice, a number is an int64, and a
+// string is a string.
+func parseLVMConfig(text string) (map[string]interface{}, error) {
+	var tokens []string
+	for _, line := range strings.Split(text, "\n") {
+		for i := 0; i < len(line); {
+			c := line[i]
+			switch {
+			case c == '#':
+				i = len(line)
+			case c == ' ' || c == '\t' || c == '\r' || c == ',':
+				i++
+			case c == '{' || c == '}' || c == '[' || c == ']' || c == '=':
+				tokens = append(tokens, string(c))
+				i++
+			case c == '"':
+				end := i + 1
+				for end < len(line) && line[end] != '"' {
+					if line[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(line) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				tokens = append(tokens, line[i:end+1])
+				i = end + 1
+			default:
+				end := i
+				for end < len(line) && !strings.ContainsRune(" \t\r,{}[]=#\"", rune(line[end])) {
+					end++
+				}
+				tokens = append(tokens, line[i:end])
+				i = end
+			}
+		}
+	}
+
+	pos := 0
+	value := func(token string) interface{} {
+		if strings.HasPrefix(token, "\"") {
+			s, err := strconv.Unquote(token)
+			if err != nil {
+				return strings.Trim(token, "\"")
+			}
+			return s
+		}
+		if n, err := strconv.ParseInt(token, 10, 64); err == nil {
+			return n
+		}
+		return token
+	}
+
+	var section func() (map[string]interface{}, error)
+	section = func() (map[string]interface{}, error) {
+		values := make(map[string]interface{})
+		for pos < len(tokens) {
+			name := tokens[pos]
+			pos++
+			if name == "}" {
+				return values, nil
+			}
+			if pos >= len(tokens) {
+				return nil, fmt.Errorf("unexpected end of metadata after %s", name)
+			}
+
+			switch tokens[pos] {
+			case "{":
+				pos++
+				child, err := section()
+				if err != nil {
+					return nil, err
+				}
+				values[name] = child
+			case "=":
+				pos++
+				if pos >= len(tokens) {
+					return nil, fmt.Errorf("missing value of %s", name)
+				}
+				if tokens[pos] != "[" {
+					values[name] = value(tokens[pos])
+					pos++
+					continue
+				}
+				pos++
+				var array []interface{}
+				for pos < len(tokens) && tokens[pos] != "]" {
+					array = append(array, value(tokens[pos]))
+					pos++
+				}
+				pos++
+				values[name] = array
+			default:
+				return nil, fmt.Errorf("unexpected token %s after %s", tokens[pos], name)
+			}
+		}
+		return values, nil
+	}
+	return section()
+}
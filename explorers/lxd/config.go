@@ -0,0 +1,115 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lxd
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BackupFile represents the backup.yaml file of an LXD instance.
+//
+// LXD writes backup.yaml to the instance volume on each configuration change
+// so the instance can be recovered without the LXD database.
+//
+// Reference to LXD source code
+// https://github.com/canonical/lxd/blob/main/lxd/backup/config/config.go
+type BackupFile struct {
+	Container *InstanceConfig    `json:"container,omitempty" yaml:"container"`
+	Instance  *InstanceConfig    `json:"instance,omitempty" yaml:"instance"`
+	Snapshots []InstanceConfig   `json:"snapshots,omitempty" yaml:"snapshots"`
+	Pool      *StoragePoolConfig `json:"pool,omitempty" yaml:"pool"`
+}
+
+// InstanceConfig represents the configuration of an LXD instance.
+type InstanceConfig struct {
+	Name            string                       `json:"name" yaml:"name"`
+	Type            string                       `json:"type" yaml:"type"`
+	Project         string                       `json:"project" yaml:"project"`
+	Architecture    string                       `json:"architecture" yaml:"architecture"`
+	Description     string                       `json:"description" yaml:"description"`
+	Status          string                       `json:"status" yaml:"status"`
+	Ephemeral       bool                         `json:"ephemeral" yaml:"ephemeral"`
+	Stateful        bool                         `json:"stateful" yaml:"stateful"`
+	Profiles        []string                     `json:"profiles" yaml:"profiles"`
+	Config          map[string]string            `json:"config" yaml:"config"`
+	Devices         map[string]map[string]string `json:"devices" yaml:"devices"`
+	ExpandedConfig  map[string]string            `json:"expanded_config" yaml:"expanded_config"`
+	ExpandedDevices map[string]map[string]string `json:"expanded_devices" yaml:"expanded_devices"`
+	CreatedAt       time.Time                    `json:"created_at" yaml:"created_at"`
+	LastUsedAt      time.Time                    `json:"last_used_at" yaml:"last_used_at"`
+}
+
+// StoragePoolConfig represents the storage pool of an LXD instance.
+type StoragePoolConfig struct {
+	Name        string            `json:"name" yaml:"name"`
+	Driver      string            `json:"driver" yaml:"driver"`
+	Description string            `json:"description" yaml:"description"`
+	Config      map[string]string `json:"config" yaml:"config"`
+}
+
+// instance returns the instance configuration. LXD 4.0 and later write the
+// instance key and older releases the container key.
+func (b BackupFile) instance() *InstanceConfig {
+	if b.Instance != nil {
+		return b.Instance
+	}
+	return b.Container
+}
+
+// readBackupFile reads the backup.yaml file of an instance.
+func readBackupFile(path string) (BackupFile, error) {
+	var b BackupFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return b, err
+	}
+	if err := yaml.Unmarshal(data, &b); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// readLXCConfig reads the key value pairs of a legacy LXC container
+// configuration file i.e. /var/lib/lxc/<name>/config. A repeated key keeps
+// the last value.
+func readLXCConfig(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		config[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	return config, scanner.Err()
+}
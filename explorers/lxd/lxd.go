@@ -0,0 +1,657 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package lxd explores the system containers managed using LXD and the
+// legacy LXC tools.
+package lxd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/google/container-explorer/explorers"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// debLXDDir is the LXD root of the distribution packages.
+	debLXDDir = "/var/lib/lxd"
+
+	// snapLXDDir is the LXD root of the LXD snap.
+	snapLXDDir = "/var/snap/lxd/common/lxd"
+
+	// lxcDir holds the containers created using the legacy LXC tools.
+	lxcDir = "/var/lib/lxc"
+
+	storagePoolsDirName = "storage-pools"
+	imagesDirName       = "images"
+	logsDirName         = "logs"
+	backupFilename      = "backup.yaml"
+	rootfsDirName       = "rootfs"
+	lxcConfigFilename   = "config"
+
+	// lxcNamespace is the namespace of the legacy LXC containers.
+	lxcNamespace = "lxc"
+
+	// defaultProject is the LXD project of the instances without a project.
+	defaultProject = "default"
+)
+
+// Container types of the LXD instances and LXC containers.
+const (
+	typeContainer      = "lxd"
+	typeVirtualMachine = "lxd-vm"
+	typeLXC            = "lxc"
+)
+
+// instanceDirs maps the instance directories of a storage pool to the
+// container types.
+var instanceDirs = map[string]string{
+	"containers":       typeContainer,
+	"virtual-machines": typeVirtualMachine,
+}
+
+// snapshotDirs are the instance snapshot directories of a storage pool.
+var snapshotDirs = []string{
+	"containers-snapshots",
+	"virtual-machines-snapshots",
+}
+
+// FindRoots returns the LXD root directories within the image root.
+func FindRoots(imageroot string) []string {
+	var roots []string
+	for _, dir := range []string{snapLXDDir, debLXDDir} {
+		root := filepath.Join(imageroot, dir)
+		if explorers.PathExists(filepath.Join(root, storagePoolsDirName), false) {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// HasLXCContainers returns true if the image root has legacy LXC containers.
+func HasLXCContainers(imageroot string) bool {
+	configs, _ := filepath.Glob(filepath.Join(imageroot, lxcDir, "*", lxcConfigFilename))
+	return len(configs) > 0
+}
+
+// instance holds an LXD instance or an LXC container.
+type instance struct {
+	explorers.Container
+	dir    string   // instance directory
+	layers []string // root filesystem layers ordered from top to bottom
+	backup BackupFile
+	config map[string]string // LXC configuration
+}
+
+type explorer struct {
+	imageroot string
+	roots     []string
+	sc        *explorers.SupportContainer
+}
+
+// NewExplorer returns a ContainerExplorer interface to explore LXD instances
+// in the LXD root directories and the legacy LXC containers within the image
+// root.
+//
+// The instance configuration is read from the backup.yaml file kept in each
+// instance directory of the storage pools. The LXD database is not read.
+func NewExplorer(imageroot string, roots []string, sc *explorers.SupportContainer) (explorers.ContainerExplorer, error) {
+	e := &explorer{
+		imageroot: imageroot,
+		roots:     roots,
+		sc:        sc,
+	}
+	if len(roots) == 0 && (imageroot == "" || !HasLXCContainers(imageroot)) {
+		return e, fmt.Errorf("no LXD root or LXC containers found")
+	}
+	return e, nil
+}
+
+// SnapshotRoot returns the storage pools directory of the first LXD root.
+func (e *explorer) SnapshotRoot(snapshotter string) string {
+	if len(e.roots) == 0 {
+		return ""
+	}
+	return filepath.Join(e.roots[0], storagePoolsDirName)
+}
+
+// ListNamespaces returns the LXD projects and the lxc namespace of the legacy
+// LXC containers.
+func (e *explorer) ListNamespaces(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	var nss []string
+	for _, i := range e.instances() {
+		if !seen[i.Namespace] {
+			seen[i.Namespace] = true
+			nss = append(nss, i.Namespace)
+		}
+	}
+	sort.Strings(nss)
+	return nss, nil
+}
+
+// ListContainers returns the LXD instances and the LXC containers.
+func (e *explorer) ListContainers(ctx context.Context) ([]explorers.Container, error) {
+	var cecontainers []explorers.Container
+	for _, i := range e.instances() {
+		cecontainers = append(cecontainers, i.Container)
+	}
+	return cecontainers, nil
+}
+
+// instances returns the LXD instances of the storage pools and the legacy
+// LXC containers.
+func (e *explorer) instances() []instance {
+	var results []instance
+	for _, root := range e.roots {
+		for dirname, ctype := range instanceDirs {
+			dirs, _ := filepath.Glob(filepath.Join(root, storagePoolsDirName, "*", dirname, "*"))
+			for _, dir := range dirs {
+				results = append(results, e.lxdInstance(root, dir, ctype))
+			}
+		}
+	}
+
+	if e.imageroot != "" {
+		configs, _ := filepath.Glob(filepath.Join(e.imageroot, lxcDir, "*", lxcConfigFilename))
+		for _, config := range configs {
+			i, err := e.lxcContainer(filepath.Dir(config))
+			if err != nil {
+				log.WithField("path", config).Warn("reading LXC container configuration: ", err)
+				continue
+			}
+			results = append(results, i)
+		}
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].Namespace != results[b].Namespace {
+			return results[a].Namespace < results[b].Namespace
+		}
+		return results[a].ID < results[b].ID
+	})
+	return results
+}
+
+// lxdInstance returns an LXD instance of a storage pool instance directory.
+//
+// The instance directory of a non-default project is named
+// <project>_<instance>. An instance without backup.yaml is listed with the
+// UNKNOWN status.
+func (e *explorer) lxdInstance(root string, dir string, ctype string) instance {
+	id := filepath.Base(dir)
+	pool := filepath.Base(filepath.Dir(filepath.Dir(dir)))
+
+	i := instance{dir: dir}
+	i.ID = id
+	i.Hostname = id
+	i.Namespace = defaultProject
+
+	// LXD instance names cannot contain an underscore.
+	if j := strings.Index(id, "_"); j > 0 {
+		i.Namespace = id[:j]
+		i.Hostname = id[j+1:]
+	}
+	i.ContainerType = ctype
+	i.Runtime.Name = "lxd"
+	i.Snapshotter = pool
+	i.Status = "UNKNOWN"
+	i.Labels = make(map[string]string)
+	if ctype == typeContainer {
+		i.layers = []string{filepath.Join(dir, rootfsDirName)}
+	}
+
+	if logfile := filepath.Join(root, logsDirName, id, "lxc.log"); explorers.PathExists(logfile, true) {
+		i.LogPath = logfile
+	}
+
+	backup, err := readBackupFile(filepath.Join(dir, backupFilename))
+	if err != nil {
+		log.WithField("instancedir", dir).Debug("reading instance backup file: ", err)
+		return i
+	}
+	i.backup = backup
+
+	config := backup.instance()
+	if config == nil {
+		return i
+	}
+	i.Hostname = config.Name
+	if config.Project != "" {
+		i.Namespace = config.Project
+	}
+	i.CreatedAt = config.CreatedAt
+	i.UpdatedAt = config.LastUsedAt
+	if backup.Pool != nil && backup.Pool.Driver != "" {
+		i.Snapshotter = backup.Pool.Driver
+	}
+
+	i.Image = config.Config["image.description"]
+	if i.Image == "" {
+		i.Image = config.Config["volatile.base_image"]
+	}
+	i.ImageBase = i.Image
+
+	for k, v := range config.Config {
+		if strings.HasPrefix(k, "user.") {
+			i.Labels[strings.TrimPrefix(k, "user.")] = v
+		}
+	}
+
+	// The last power state is recorded in the instance configuration. The
+	// status is the status when backup.yaml was written.
+	switch {
+	case config.Config["volatile.last_state.power"] != "":
+		i.Status = strings.ToUpper(config.Config["volatile.last_state.power"])
+	case config.Status != "":
+		i.Status = strings.ToUpper(config.Status)
+	}
+	i.Running = i.Status == "RUNNING"
+
+	i.SupportContainer = e.sc.IsSupportContainer(i.Container)
+	return i
+}
+
+// lxcContainer returns a legacy LXC container of a container directory i.e.
+// /var/lib/lxc/<name>.
+//
+// The root filesystem is a directory or an overlay of a base container
+// i.e. overlay:/var/lib/lxc/base/rootfs:/var/lib/lxc/clone/delta0.
+func (e *explorer) lxcContainer(dir string) (instance, error) {
+	config, err := readLXCConfig(filepath.Join(dir, lxcConfigFilename))
+	if err != nil {
+		return instance{}, err
+	}
+
+	i := instance{dir: dir, config: config}
+	i.ID = filepath.Base(dir)
+	i.Namespace = lxcNamespace
+	i.ContainerType = typeLXC
+	i.Runtime.Name = "lxc"
+	i.Status = "UNKNOWN"
+	i.Labels = make(map[string]string)
+
+	i.Hostname = config["lxc.uts.name"]
+	if i.Hostname == "" {
+		i.Hostname = config["lxc.utsname"]
+	}
+
+	rootfs := config["lxc.rootfs.path"]
+	if rootfs == "" {
+		rootfs = config["lxc.rootfs"]
+	}
+	switch {
+	case rootfs == "":
+		i.layers = []string{filepath.Join(dir, rootfsDirName)}
+	case strings.HasPrefix(rootfs, "overlay:"), strings.HasPrefix(rootfs, "overlayfs:"):
+		parts := strings.Split(rootfs, ":")[1:]
+		for j := len(parts) - 1; j >= 0; j-- {
+			i.layers = append(i.layers, filepath.Join(e.imageroot, parts[j]))
+		}
+		i.Snapshotter = "overlay"
+	case strings.HasPrefix(rootfs, "dir:"), strings.HasPrefix(rootfs, "/"):
+		i.layers = []string{filepath.Join(e.imageroot, strings.TrimPrefix(rootfs, "dir:"))}
+		i.Snapshotter = "dir"
+	default:
+		// btrfs, zfs, lvm, and loop backed root filesystems are not
+		// mounted in the image root.
+		i.Snapshotter = strings.Split(rootfs, ":")[0]
+		log.WithFields(log.Fields{
+			"containerid": i.ID,
+			"rootfs":      rootfs,
+		}).Debug("LXC root filesystem is not a directory")
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, lxcConfigFilename)); err == nil {
+		i.UpdatedAt = info.ModTime().UTC()
+	}
+
+	i.SupportContainer = e.sc.IsSupportContainer(i.Container)
+	return i, nil
+}
+
+// lookup returns an instance by ID. The instance in the namespace of the
+// context is preferred.
+func (e *explorer) lookup(ctx context.Context, containerid string) (instance, error) {
+	ns, _ := namespaces.Namespace(ctx)
+
+	var found *instance
+	for _, i := range e.instances() {
+		i := i
+		if i.ID != containerid {
+			continue
+		}
+		if i.Namespace == ns {
+			return i, nil
+		}
+		if found == nil {
+			found = &i
+		}
+	}
+	if found == nil {
+		return instance{}, fmt.Errorf("container %s does not exist", containerid)
+	}
+	return *found, nil
+}
+
+// ListImages returns the cached LXD images.
+//
+// The image aliases are kept in the LXD database. The image name is the
+// description of the instances created from the image or the fingerprint.
+func (e *explorer) ListImages(ctx context.Context) ([]explorers.Image, error) {
+	descriptions := make(map[string]string)
+	for _, i := range e.instances() {
+		if config := i.backup.instance(); config != nil && config.Config["volatile.base_image"] != "" {
+			descriptions[config.Config["volatile.base_image"]] = config.Config["image.description"]
+		}
+	}
+
+	var ceimages []explorers.Image
+	for _, root := range e.roots {
+		entries, err := os.ReadDir(filepath.Join(root, imagesDirName))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			// Split images are stored as <fingerprint> holding the metadata
+			// and <fingerprint>.rootfs holding the root filesystem.
+			if entry.IsDir() || strings.Contains(entry.Name(), ".") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+
+			fingerprint := entry.Name()
+			name := descriptions[fingerprint]
+			if name == "" {
+				name = fingerprint
+			}
+			size := info.Size()
+			if rootfs, err := os.Stat(filepath.Join(root, imagesDirName, fingerprint+".rootfs")); err == nil {
+				size += rootfs.Size()
+			}
+
+			ceimages = append(ceimages, explorers.Image{
+				Namespace:             defaultProject,
+				SupportContainerImage: e.sc.SupportContainerImage(name),
+				Image: images.Image{
+					Name: name,
+					Target: ocispec.Descriptor{
+						MediaType: "application/vnd.lxd.image",
+						Digest:    digest.NewDigestFromEncoded(digest.SHA256, fingerprint),
+						Size:      size,
+					},
+					CreatedAt: info.ModTime().UTC(),
+					UpdatedAt: info.ModTime().UTC(),
+				},
+			})
+		}
+	}
+	return ceimages, nil
+}
+
+// InspectImage returns the LXD image files.
+//
+// LXD images are tarballs or squashfs files rather than layered images.
+func (e *explorer) InspectImage(ctx context.Context, name string) (explorers.ImageDetail, error) {
+	ceimages, err := e.ListImages(ctx)
+	if err != nil {
+		return explorers.ImageDetail{}, err
+	}
+	for _, image := range ceimages {
+		fingerprint := image.Target.Digest.Encoded()
+		if image.Name != name && fingerprint != name && !strings.HasPrefix(fingerprint, name) {
+			continue
+		}
+
+		detail := explorers.ImageDetail{
+			Namespace: image.Namespace,
+			Name:      image.Name,
+			Target:    image.Target.Digest.String(),
+		}
+		for _, root := range e.roots {
+			path := filepath.Join(root, imagesDirName, fingerprint)
+			if !explorers.PathExists(path, true) {
+				continue
+			}
+			detail.ConfigPath = path
+			if rootfs := path + ".rootfs"; explorers.PathExists(rootfs, true) {
+				detail.Layers = append(detail.Layers, explorers.ImageLayer{BlobPath: rootfs})
+			}
+		}
+		return detail, nil
+	}
+	return explorers.ImageDetail{}, fmt.Errorf("image %s not found", name)
+}
+
+// ListSnapshots returns the LXD instance snapshots.
+func (e *explorer) ListSnapshots(ctx context.Context) ([]explorers.SnapshotKeyInfo, error) {
+	var ss []explorers.SnapshotKeyInfo
+	for _, root := range e.roots {
+		for _, dirname := range snapshotDirs {
+			dirs, _ := filepath.Glob(filepath.Join(root, storagePoolsDirName, "*", dirname, "*", "*"))
+			for _, dir := range dirs {
+				info, err := os.Stat(dir)
+				if err != nil || !info.IsDir() {
+					continue
+				}
+				parent := filepath.Base(filepath.Dir(dir))
+				ns := defaultProject
+				if j := strings.Index(parent, "_"); j > 0 {
+					ns = parent[:j]
+				}
+				ss = append(ss, explorers.SnapshotKeyInfo{
+					Namespace:   ns,
+					Snapshotter: filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(dir)))),
+					Key:         parent + "/" + filepath.Base(dir),
+					Name:        filepath.Base(dir),
+					Parent:      parent,
+					Kind:        snapshots.KindCommitted,
+					OverlayPath: dir,
+					CreatedAt:   info.ModTime().UTC(),
+					UpdatedAt:   info.ModTime().UTC(),
+				})
+			}
+		}
+	}
+	return ss, nil
+}
+
+// ListStoragePools returns the LXD storage pools.
+//
+// The pool driver and source are read from the backup.yaml files of the
+// instances in the pool.
+func (e *explorer) ListStoragePools(ctx context.Context) ([]explorers.StoragePool, error) {
+	var pools []explorers.StoragePool
+	index := make(map[string]int)
+
+	for _, root := range e.roots {
+		dirs, _ := filepath.Glob(filepath.Join(root, storagePoolsDirName, "*"))
+		for _, dir := range dirs {
+			if !explorers.PathExists(dir, false) {
+				continue
+			}
+			index[dir] = len(pools)
+			pool := explorers.StoragePool{
+				Name: filepath.Base(dir),
+				Path: dir,
+			}
+			images, _ := filepath.Glob(filepath.Join(dir, "images", "*"))
+			pool.Images = len(images)
+			pools = append(pools, pool)
+		}
+	}
+
+	for _, i := range e.instances() {
+		if i.ContainerType == typeLXC {
+			continue
+		}
+		idx, found := index[filepath.Dir(filepath.Dir(i.dir))]
+		if !found {
+			continue
+		}
+		pools[idx].Instances++
+		if p := i.backup.Pool; p != nil {
+			if p.Driver != "" {
+				pools[idx].Driver = p.Driver
+			}
+			if p.Config["source"] != "" {
+				pools[idx].Source = p.Config["source"]
+			}
+		}
+	}
+	return pools, nil
+}
+
+// ListContent returns information about content.
+//
+// LXD does not keep a content store.
+func (e *explorer) ListContent(ctx context.Context) ([]explorers.Content, error) {
+	log.Info("listing content is not supported for LXD")
+	return nil, nil
+}
+
+// ListTasks returns the last known instance status.
+func (e *explorer) ListTasks(ctx context.Context) ([]explorers.Task, error) {
+	var tasks []explorers.Task
+	for _, i := range e.instances() {
+		tasks = append(tasks, explorers.Task{
+			Namespace:     i.Namespace,
+			Name:          i.ID,
+			ContainerType: i.ContainerType,
+			Status:        i.Status,
+		})
+	}
+	return tasks, nil
+}
+
+// ListLeases returns the leases.
+//
+// LXD does not keep leases.
+func (e *explorer) ListLeases(ctx context.Context) ([]explorers.Lease, error) {
+	log.Info("listing leases is not supported for LXD")
+	return nil, nil
+}
+
+// InfoContainer returns the instance configuration.
+//
+// The expanded configuration and devices of an LXD instance are returned as
+// the spec. The LXC configuration is returned for an LXC container.
+func (e *explorer) InfoContainer(ctx context.Context, containerid string, spec bool) (interface{}, error) {
+	i, err := e.lookup(ctx, containerid)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.ContainerType == typeLXC {
+		return i.config, nil
+	}
+
+	config := i.backup.instance()
+	if config == nil {
+		return nil, fmt.Errorf("container %s does not have %s", containerid, backupFilename)
+	}
+	if spec {
+		return struct {
+			Config  map[string]string            `json:"config"`
+			Devices map[string]map[string]string `json:"devices"`
+		}{
+			Config:  config.ExpandedConfig,
+			Devices: config.ExpandedDevices,
+		}, nil
+	}
+	return i.backup, nil
+}
+
+// ContainerLayers returns the instance root filesystem directory.
+//
+// The root filesystem of an LXD container is a single directory. The upper
+// directory of an LXC overlay container is the container delta directory.
+func (e *explorer) ContainerLayers(ctx context.Context, containerid string) (string, []string, error) {
+	i, err := e.lookup(ctx, containerid)
+	if err != nil {
+		return "", nil, err
+	}
+	if i.ContainerType == typeVirtualMachine {
+		return "", nil, fmt.Errorf("virtual machine %s does not have a root filesystem directory. Use the disk image %s", containerid, filepath.Join(i.dir, "root.img"))
+	}
+	if len(i.layers) == 0 {
+		return "", nil, fmt.Errorf("container %s root filesystem on %s is not supported", containerid, i.Snapshotter)
+	}
+	if !explorers.PathExists(i.layers[0], false) {
+		return "", nil, fmt.Errorf("container %s root filesystem %s does not exist. The storage pool may not be mounted", containerid, i.layers[0])
+	}
+	return i.layers[0], i.layers[1:], nil
+}
+
+// MountContainer mounts an instance root filesystem to the specified path.
+func (e *explorer) MountContainer(ctx context.Context, containerid string, mountpoint string) error {
+	upperdir, lowerdirs, err := e.ContainerLayers(ctx, containerid)
+	if err != nil {
+		return err
+	}
+	return explorers.MountOverlay(append([]string{upperdir}, lowerdirs...), mountpoint)
+}
+
+// MountAllContainers mounts all containers to the specified path.
+func (e *explorer) MountAllContainers(ctx context.Context, mountpoint string, opts explorers.MountAllOptions) ([]explorers.MountIndexEntry, error) {
+	ctrs, err := e.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := explorers.NewMountBatch(mountpoint, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ctr := range ctrs {
+		if opts.SkipSupportContainers && ctr.SupportContainer {
+			log.WithField("containerid", ctr.ID).Info("skip mounting support container")
+			batch.Skip(ctr, "Kubernetes support container")
+			continue
+		}
+		if ctr.ContainerType == typeVirtualMachine {
+			log.WithField("containerid", ctr.ID).Info("skip mounting virtual machine")
+			batch.Skip(ctr, "virtual machine")
+			continue
+		}
+
+		nsctx := namespaces.WithNamespace(ctx, ctr.Namespace)
+		batch.Mount(ctr, func(ctrmountpoint string) error {
+			return e.MountContainer(nsctx, ctr.ID, ctrmountpoint)
+		})
+	}
+
+	return batch.Close()
+}
+
+// Close releases the internal resources.
+func (e *explorer) Close() error {
+	return nil
+}
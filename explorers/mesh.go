@@ -0,0 +1,524 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Service meshes of a sidecar proxy.
+const (
+	MeshIstio   = "istio"
+	MeshLinkerd = "linkerd"
+)
+
+// MTLS states of a sidecar proxy.
+const (
+	MTLSEnabled  = "enabled"  // the proxy is issued a workload certificate
+	MTLSRequired = "required" // the proxy accepts only authenticated peers
+	MTLSDisabled = "disabled"
+)
+
+// envoyMaxBootstrapSize is the maximum size of an envoy bootstrap file.
+const envoyMaxBootstrapSize = 4 * 1024 * 1024
+
+var (
+	// meshProxyNames are the Kubernetes container names of the sidecar
+	// proxies injected by the service meshes.
+	meshProxyNames = map[string]string{
+		"istio-proxy":   MeshIstio,
+		"linkerd-proxy": MeshLinkerd,
+	}
+
+	// meshInitNames are the Kubernetes container names of the init
+	// containers setting up the traffic interception.
+	meshInitNames = map[string]string{
+		"istio-init":                MeshIstio,
+		"istio-validation":          MeshIstio,
+		"linkerd-init":              MeshLinkerd,
+		"linkerd-network-validator": MeshLinkerd,
+	}
+
+	// meshSettingPrefixes are the prefixes of the environment variables
+	// configuring the sidecar proxy of a service mesh.
+	meshSettingPrefixes = map[string][]string{
+		MeshIstio: {
+			"ISTIO_", "PILOT_", "PROXY_CONFIG", "CA_ADDR", "TRUST_DOMAIN",
+			"SERVICE_ACCOUNT", "POD_NAME", "POD_NAMESPACE", "INSTANCE_IP",
+			"OUTPUT_CERTS", "XDS_",
+		},
+		MeshLinkerd: {"LINKERD2_PROXY_", "_pod_"},
+	}
+)
+
+// MeshSidecar is a service mesh sidecar proxy paired with the application
+// containers of its pod.
+//
+// The mesh configuration defines the network paths the workload had i.e.
+// the ports redirected to the proxy, the control plane, and the workload
+// identity used for mTLS.
+type MeshSidecar struct {
+	Mesh          string            `json:"mesh"`
+	Namespace     string            `json:"namespace"`
+	ContainerID   string            `json:"container_id"`
+	Name          string            `json:"name,omitempty"`
+	Image         string            `json:"image,omitempty"`
+	PodUID        string            `json:"pod_uid,omitempty"`
+	PodName       string            `json:"pod_name,omitempty"`
+	PodNamespace  string            `json:"pod_namespace,omitempty"`
+	InitContainer string            `json:"init_container,omitempty"` // container ID of the init container
+	Applications  []MeshApplication `json:"applications"`
+
+	Interception *MeshInterception `json:"interception,omitempty"`
+	ControlPlane string            `json:"control_plane,omitempty"`
+	Identity     string            `json:"identity,omitempty"`
+	MTLS         string            `json:"mtls,omitempty"`
+	Settings     map[string]string `json:"settings,omitempty"`
+	Bootstrap    []EnvoyBootstrap  `json:"bootstrap,omitempty"`
+}
+
+// MeshApplication is an application container paired with a sidecar proxy.
+type MeshApplication struct {
+	ContainerID string `json:"container_id"`
+	Name        string `json:"name,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// MeshInterception describes the traffic redirected to the sidecar proxy by
+// the iptables rules of the init container. A port list of * is all ports.
+type MeshInterception struct {
+	Mode                 string `json:"mode,omitempty"`
+	ProxyUID             string `json:"proxy_uid,omitempty"`
+	InboundPort          string `json:"inbound_port,omitempty"`
+	OutboundPort         string `json:"outbound_port,omitempty"`
+	InboundPorts         string `json:"inbound_ports,omitempty"`
+	ExcludeInboundPorts  string `json:"exclude_inbound_ports,omitempty"`
+	IncludeOutboundPorts string `json:"include_outbound_ports,omitempty"`
+	ExcludeOutboundPorts string `json:"exclude_outbound_ports,omitempty"`
+	IncludeOutboundCIDRs string `json:"include_outbound_cidrs,omitempty"`
+	ExcludeOutboundCIDRs string `json:"exclude_outbound_cidrs,omitempty"`
+}
+
+// EnvoyBootstrap is the static configuration of an envoy proxy i.e. the
+// Istio envoy-rev.json. The routes received from the control plane are
+// kept in memory and are not part of the bootstrap.
+type EnvoyBootstrap struct {
+	Path       string          `json:"path"`
+	Node       string          `json:"node,omitempty"`
+	Admin      string          `json:"admin,omitempty"`
+	ADSCluster string          `json:"ads_cluster,omitempty"`
+	Listeners  []EnvoyListener `json:"listeners,omitempty"`
+	Clusters   []EnvoyCluster  `json:"clusters,omitempty"`
+}
+
+// EnvoyListener is a static listener of an envoy proxy.
+type EnvoyListener struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// EnvoyCluster is a static upstream cluster of an envoy proxy.
+type EnvoyCluster struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+	TLS       bool     `json:"tls"`
+}
+
+// MeshSidecars returns the service mesh sidecar proxies of the containers
+// paired with the application containers and the init container of their
+// pod.
+//
+// The sidecars are identified by the Kubernetes container name i.e.
+// istio-proxy and linkerd-proxy, or by the proxy image when the container
+// is not created by kubelet.
+func MeshSidecars(ctrs []Container) []MeshSidecar {
+	var sidecars []MeshSidecar
+	for _, ctr := range ctrs {
+		mesh := meshProxy(ctr)
+		if mesh == "" {
+			continue
+		}
+
+		s := MeshSidecar{
+			Mesh:         mesh,
+			Namespace:    ctr.Namespace,
+			ContainerID:  ctr.ID,
+			Name:         ctr.Labels[LabelContainerName],
+			Image:        ctr.Image,
+			PodUID:       podID(ctr),
+			PodName:      ctr.Labels[LabelPodName],
+			PodNamespace: ctr.Labels[LabelPodNamespace],
+			Applications: []MeshApplication{},
+		}
+		if s.PodName == "" {
+			s.PodName = ctr.PodName
+		}
+
+		var initctr Container
+		for _, other := range ctrs {
+			if s.PodUID == "" || other.ID == ctr.ID || other.Namespace != ctr.Namespace || podID(other) != s.PodUID || isSandbox(other) {
+				continue
+			}
+			if meshInit(other) == mesh {
+				// Use the most recent init container of a restarted pod.
+				if initctr.ID == "" || other.CreatedAt.After(initctr.CreatedAt) {
+					initctr = other
+				}
+				continue
+			}
+			if meshProxy(other) != "" || meshInit(other) != "" {
+				continue
+			}
+			s.Applications = append(s.Applications, MeshApplication{
+				ContainerID: other.ID,
+				Name:        other.Labels[LabelContainerName],
+				Image:       other.Image,
+			})
+		}
+		s.InitContainer = initctr.ID
+
+		sidecars = append(sidecars, s)
+	}
+
+	sort.SliceStable(sidecars, func(i, j int) bool {
+		if sidecars[i].PodNamespace != sidecars[j].PodNamespace {
+			return sidecars[i].PodNamespace < sidecars[j].PodNamespace
+		}
+		return sidecars[i].PodName < sidecars[j].PodName
+	})
+	return sidecars
+}
+
+// meshProxy returns the service mesh of a sidecar proxy container or an
+// empty string.
+func meshProxy(ctr Container) string {
+	if name, found := ctr.Labels[LabelContainerName]; found {
+		return meshProxyNames[name]
+	}
+
+	image := strings.ToLower(ctr.Image)
+	switch {
+	case strings.Contains(image, "istio/proxyv2"):
+		return MeshIstio
+	case strings.Contains(image, "linkerd/proxy") && !strings.Contains(image, "linkerd/proxy-init"):
+		return MeshLinkerd
+	}
+	return ""
+}
+
+// meshInit returns the service mesh of an init container setting up the
+// traffic interception or an empty string.
+func meshInit(ctr Container) string {
+	return meshInitNames[ctr.Labels[LabelContainerName]]
+}
+
+// ParseMeshInterception returns the traffic interception configured by the
+// arguments of the init container i.e. istio-iptables -p 15001 -z 15006 or
+// proxy-init --incoming-proxy-port 4143.
+func ParseMeshInterception(mesh string, args []string) *MeshInterception {
+	ic := &MeshInterception{}
+
+	var flags map[string]*string
+	switch mesh {
+	case MeshIstio:
+		ic.Mode = "REDIRECT"
+		flags = map[string]*string{
+			"p": &ic.OutboundPort, "envoy-port": &ic.OutboundPort,
+			"z": &ic.InboundPort, "inbound-capture-port": &ic.InboundPort,
+			"u": &ic.ProxyUID, "proxy-uid": &ic.ProxyUID,
+			"m": &ic.Mode, "istio-inbound-interception-mode": &ic.Mode,
+			"b": &ic.InboundPorts, "istio-inbound-ports": &ic.InboundPorts,
+			"d": &ic.ExcludeInboundPorts, "istio-local-exclude-ports": &ic.ExcludeInboundPorts,
+			"q": &ic.IncludeOutboundPorts, "istio-include-outbound-ports": &ic.IncludeOutboundPorts,
+			"o": &ic.ExcludeOutboundPorts, "istio-exclude-outbound-ports": &ic.ExcludeOutboundPorts,
+			"i": &ic.IncludeOutboundCIDRs, "istio-service-cidr": &ic.IncludeOutboundCIDRs,
+			"x": &ic.ExcludeOutboundCIDRs, "istio-service-exclude-cidr": &ic.ExcludeOutboundCIDRs,
+		}
+	case MeshLinkerd:
+		ic.Mode = "REDIRECT"
+		ic.InboundPorts = "*"
+		ic.IncludeOutboundPorts = "*"
+		flags = map[string]*string{
+			"incoming-proxy-port":      &ic.InboundPort,
+			"outgoing-proxy-port":      &ic.OutboundPort,
+			"proxy-uid":                &ic.ProxyUID,
+			"ports-to-redirect":        &ic.InboundPorts,
+			"inbound-ports-to-ignore":  &ic.ExcludeInboundPorts,
+			"outbound-ports-to-ignore": &ic.ExcludeOutboundPorts,
+			"subnets-to-ignore":        &ic.ExcludeOutboundCIDRs,
+		}
+	default:
+		return nil
+	}
+
+	found := false
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			continue
+		}
+		name := strings.TrimLeft(args[i], "-")
+		value, inline := "", false
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value, inline = name[:j], name[j+1:], true
+		}
+		field, known := flags[name]
+		if !known {
+			continue
+		}
+		if !inline && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		*field = value
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return ic
+}
+
+// ApplyMeshSettings sets the control plane, workload identity, mTLS state,
+// and settings of a sidecar from the environment variables of the proxy
+// container.
+func (s *MeshSidecar) ApplyMeshSettings(env []string) {
+	vars := make(map[string]string)
+	for _, kv := range env {
+		k, v := kv, ""
+		if i := strings.Index(kv, "="); i >= 0 {
+			k, v = kv[:i], kv[i+1:]
+		}
+		for _, prefix := range meshSettingPrefixes[s.Mesh] {
+			if strings.HasPrefix(k, prefix) {
+				vars[k] = v
+				break
+			}
+		}
+	}
+	if len(vars) > 0 {
+		s.Settings = vars
+	}
+
+	switch s.Mesh {
+	case MeshIstio:
+		var proxyconfig struct {
+			DiscoveryAddress string `json:"discoveryAddress"`
+		}
+		if v := vars["PROXY_CONFIG"]; v != "" {
+			json.Unmarshal([]byte(v), &proxyconfig)
+		}
+		s.ControlPlane = proxyconfig.DiscoveryAddress
+		if s.ControlPlane == "" {
+			s.ControlPlane = vars["CA_ADDR"]
+		}
+
+		if sa, ns := vars["SERVICE_ACCOUNT"], vars["POD_NAMESPACE"]; sa != "" && ns != "" {
+			s.Identity = fmt.Sprintf("ns/%s/sa/%s", ns, sa)
+			if td := vars["TRUST_DOMAIN"]; td != "" {
+				s.Identity = fmt.Sprintf("spiffe://%s/%s", td, s.Identity)
+			}
+		}
+		if vars["CA_ADDR"] != "" || vars["PILOT_CERT_PROVIDER"] != "" {
+			s.MTLS = MTLSEnabled
+		}
+	case MeshLinkerd:
+		s.ControlPlane = vars["LINKERD2_PROXY_DESTINATION_SVC_ADDR"]
+		s.Identity = vars["LINKERD2_PROXY_IDENTITY_LOCAL_NAME"]
+
+		switch {
+		case vars["LINKERD2_PROXY_IDENTITY_DISABLED"] != "":
+			s.MTLS = MTLSDisabled
+		case vars["LINKERD2_PROXY_INBOUND_DEFAULT_POLICY"] == "all-authenticated",
+			vars["LINKERD2_PROXY_INBOUND_DEFAULT_POLICY"] == "cluster-authenticated":
+			s.MTLS = MTLSRequired
+		case s.Identity != "":
+			s.MTLS = MTLSEnabled
+		}
+	}
+}
+
+// ApplyEnvoyBootstrap adds an envoy bootstrap to the sidecar. An Istio proxy
+// is issued a workload certificate when the bootstrap has the SDS cluster.
+func (s *MeshSidecar) ApplyEnvoyBootstrap(b EnvoyBootstrap) {
+	s.Bootstrap = append(s.Bootstrap, b)
+	if s.Mesh != MeshIstio || s.MTLS != "" {
+		return
+	}
+	for _, c := range b.Clusters {
+		if c.Name == "sds-grpc" {
+			s.MTLS = MTLSEnabled
+		}
+	}
+}
+
+// FindEnvoyBootstrap returns the envoy bootstrap files in the directories
+// i.e. envoy-rev.json of the Istio proxy. A file hides the file with the
+// same name in the following directories.
+func FindEnvoyBootstrap(dirs []string) []string {
+	var (
+		files []string
+		seen  = make(map[string]bool)
+	)
+	for _, dir := range dirs {
+		var matches []string
+		for _, pattern := range []string{"envoy-rev*.json", "envoy-rev*.yaml"} {
+			m, _ := filepath.Glob(filepath.Join(dir, pattern))
+			matches = append(matches, m...)
+		}
+		for _, m := range matches {
+			if !seen[filepath.Base(m)] {
+				seen[filepath.Base(m)] = true
+				files = append(files, m)
+			}
+		}
+	}
+	return files
+}
+
+// envoyAddress is an envoy address i.e. a socket address or a unix socket.
+type envoyAddress struct {
+	SocketAddress struct {
+		Address   string `yaml:"address"`
+		PortValue int    `yaml:"port_value"`
+	} `yaml:"socket_address"`
+	Pipe struct {
+		Path string `yaml:"path"`
+	} `yaml:"pipe"`
+}
+
+// String returns the address as host:port or unix:path.
+func (a envoyAddress) String() string {
+	if a.Pipe.Path != "" {
+		return "unix:" + a.Pipe.Path
+	}
+	if a.SocketAddress.Address == "" {
+		return ""
+	}
+	if a.SocketAddress.PortValue == 0 {
+		return a.SocketAddress.Address
+	}
+	return fmt.Sprintf("%s:%d", a.SocketAddress.Address, a.SocketAddress.PortValue)
+}
+
+// ReadEnvoyBootstrap reads the static listeners and clusters of an envoy
+// bootstrap file in JSON or YAML.
+func ReadEnvoyBootstrap(path string) (EnvoyBootstrap, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return EnvoyBootstrap{}, err
+	}
+	if info.Size() > envoyMaxBootstrapSize {
+		return EnvoyBootstrap{}, fmt.Errorf("envoy bootstrap %s is larger than %d bytes", path, envoyMaxBootstrapSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return EnvoyBootstrap{}, err
+	}
+
+	type endpoint struct {
+		Endpoint struct {
+			Address envoyAddress `yaml:"address"`
+		} `yaml:"endpoint"`
+	}
+	var config struct {
+		Node struct {
+			ID      string `yaml:"id"`
+			Cluster string `yaml:"cluster"`
+		} `yaml:"node"`
+		Admin struct {
+			Address envoyAddress `yaml:"address"`
+		} `yaml:"admin"`
+		DynamicResources struct {
+			ADSConfig struct {
+				GRPCServices []struct {
+					EnvoyGRPC struct {
+						ClusterName string `yaml:"cluster_name"`
+					} `yaml:"envoy_grpc"`
+				} `yaml:"grpc_services"`
+			} `yaml:"ads_config"`
+		} `yaml:"dynamic_resources"`
+		StaticResources struct {
+			Listeners []struct {
+				Name    string       `yaml:"name"`
+				Address envoyAddress `yaml:"address"`
+			} `yaml:"listeners"`
+			Clusters []struct {
+				Name           string `yaml:"name"`
+				Type           string `yaml:"type"`
+				LoadAssignment struct {
+					Endpoints []struct {
+						LBEndpoints []endpoint `yaml:"lb_endpoints"`
+					} `yaml:"endpoints"`
+				} `yaml:"load_assignment"`
+				Hosts           []envoyAddress `yaml:"hosts"`
+				TransportSocket struct {
+					Name string `yaml:"name"`
+				} `yaml:"transport_socket"`
+			} `yaml:"clusters"`
+		} `yaml:"static_resources"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return EnvoyBootstrap{}, fmt.Errorf("parsing envoy bootstrap %s: %w", path, err)
+	}
+
+	b := EnvoyBootstrap{
+		Path:  path,
+		Node:  config.Node.ID,
+		Admin: config.Admin.Address.String(),
+	}
+	for _, svc := range config.DynamicResources.ADSConfig.GRPCServices {
+		if svc.EnvoyGRPC.ClusterName != "" {
+			b.ADSCluster = svc.EnvoyGRPC.ClusterName
+			break
+		}
+	}
+	for _, l := range config.StaticResources.Listeners {
+		b.Listeners = append(b.Listeners, EnvoyListener{
+			Name:    l.Name,
+			Address: l.Address.String(),
+		})
+	}
+	for _, c := range config.StaticResources.Clusters {
+		cluster := EnvoyCluster{
+			Name: c.Name,
+			Type: c.Type,
+			TLS:  strings.Contains(strings.ToLower(c.TransportSocket.Name), "tls"),
+		}
+		for _, e := range c.LoadAssignment.Endpoints {
+			for _, lb := range e.LBEndpoints {
+				if address := lb.Endpoint.Address.String(); address != "" {
+					cluster.Endpoints = append(cluster.Endpoints, address)
+				}
+			}
+		}
+		for _, h := range c.Hosts {
+			if address := h.String(); address != "" {
+				cluster.Endpoints = append(cluster.Endpoints, address)
+			}
+		}
+		b.Clusters = append(b.Clusters, cluster)
+	}
+	return b, nil
+}
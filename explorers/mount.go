@@ -0,0 +1,206 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// MountIndexFilename is the file written to the mount-all mount point
+	// that maps the mount directories to the containers.
+	MountIndexFilename = "index.json"
+
+	shortIDLength     = 12
+	maxHostnameLength = 63
+)
+
+// Mount statuses of the mount index entries.
+const (
+	MountStatusMounted = "mounted"
+	MountStatusFailed  = "failed"
+	MountStatusSkipped = "skipped"
+)
+
+// MountIndexEntry maps a mount-all directory to a container identity and
+// records the result of mounting the container.
+type MountIndexEntry struct {
+	Directory     string `json:"directory,omitempty"`
+	Namespace     string `json:"namespace"`
+	ContainerID   string `json:"container_id"`
+	Hostname      string `json:"hostname,omitempty"`
+	Image         string `json:"image,omitempty"`
+	ContainerType string `json:"container_type,omitempty"`
+	Status        string `json:"status"`
+	Reason        string `json:"reason,omitempty"` // reason of a failed or skipped mount
+	Attempts      int    `json:"attempts,omitempty"`
+}
+
+// MountNamer generates collision-free and filesystem-safe directory names
+// for mounting multiple containers within the same mount point.
+//
+// A directory name has the pattern <namespace>_<hostname>_<short id>. The
+// namespace and hostname are omitted when empty.
+type MountNamer struct {
+	used map[string]bool
+}
+
+// NewMountNamer returns a MountNamer.
+func NewMountNamer() *MountNamer {
+	return &MountNamer{
+		used: make(map[string]bool),
+	}
+}
+
+// Name returns a unique directory name for the container.
+func (n *MountNamer) Name(ctr Container) string {
+	var parts []string
+
+	if ctr.Namespace != "" {
+		parts = append(parts, SanitizeName(ctr.Namespace))
+	}
+
+	if ctr.Hostname != "" {
+		hostname := SanitizeName(ctr.Hostname)
+		if len(hostname) > maxHostnameLength {
+			hostname = hostname[:maxHostnameLength]
+		}
+		parts = append(parts, hostname)
+	}
+
+	id := SanitizeName(ctr.ID)
+	if len(id) > shortIDLength {
+		id = id[:shortIDLength]
+	}
+	parts = append(parts, id)
+
+	base := strings.Join(parts, "_")
+	name := base
+
+	// Short IDs may collide when the same container ID exists in multiple
+	// namespaces or the short ID prefixes match.
+	for i := 2; n.used[name]; i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	n.used[name] = true
+
+	return name
+}
+
+// SanitizeName returns a filesystem-safe name by replacing characters other
+// than letters, digits, dot, underscore, and hyphen with an underscore.
+func SanitizeName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.' || r == '_' || r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+
+	// Avoid hidden directories and the special names . and ..
+	sanitized = strings.TrimLeft(sanitized, ".")
+	if sanitized == "" {
+		return "_"
+	}
+	return sanitized
+}
+
+// NewMountIndexEntry returns the index entry for a container mounted at
+// directory.
+func NewMountIndexEntry(directory string, ctr Container) MountIndexEntry {
+	return MountIndexEntry{
+		Directory:     directory,
+		Namespace:     ctr.Namespace,
+		ContainerID:   ctr.ID,
+		Hostname:      ctr.Hostname,
+		Image:         ctr.Image,
+		ContainerType: ctr.ContainerType,
+		Status:        MountStatusMounted,
+	}
+}
+
+// ReadMountIndex reads index.json of the mount point.
+//
+// The entries of an index written before the mount status was recorded are
+// mounted.
+func ReadMountIndex(mountpoint string) ([]MountIndexEntry, error) {
+	indexfile := filepath.Join(mountpoint, MountIndexFilename)
+	data, err := os.ReadFile(indexfile)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []MountIndexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parsing mount index %s: %w", indexfile, err)
+	}
+	for i := range entries {
+		if entries[i].Status == "" {
+			entries[i].Status = MountStatusMounted
+		}
+	}
+	return entries, nil
+}
+
+// WriteMountIndex writes index.json to the mount point.
+//
+// The index is written to a temporary file renamed over index.json, so an
+// interrupted run leaves the previous index intact.
+func WriteMountIndex(mountpoint string, entries []MountIndexEntry) error {
+	if entries == nil {
+		entries = []MountIndexEntry{}
+	}
+
+	data, err := json.MarshalIndent(entries, "", " ")
+	if err != nil {
+		return fmt.Errorf("marshalling mount index: %w", err)
+	}
+
+	indexfile := filepath.Join(mountpoint, MountIndexFilename)
+	if err := os.WriteFile(indexfile+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("writing mount index %s: %w", indexfile, err)
+	}
+	if err := os.Rename(indexfile+".tmp", indexfile); err != nil {
+		os.Remove(indexfile + ".tmp")
+		return fmt.Errorf("writing mount index %s: %w", indexfile, err)
+	}
+	return nil
+}
+
+// MountOverlay mounts the layers as a read-only overlay filesystem.
+//
+// The layers are ordered from top to bottom. A single layer is bind mounted
+// read-only because overlayfs requires at least two lower directories when
+// the upper directory is not specified.
+func MountOverlay(layers []string, mountpoint string) error {
+	if len(layers) == 0 {
+		return fmt.Errorf("lowerdir is empty")
+	}
+	return mountLayers(layers, mountpoint)
+}
+
+// Unmount unmounts the mount point.
+func Unmount(mountpoint string) error {
+	return unmount(mountpoint)
+}
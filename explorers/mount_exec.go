@@ -0,0 +1,142 @@
+//go:build !static
+// +build !static
+
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mountLayers mounts the layers using the mount command.
+//
+// The layers are mounted using fuse-overlayfs if the mount command fails
+// and fuse-overlayfs is installed, or if the FUSE mount is enabled.
+func mountLayers(layers []string, mountpoint string) error {
+	if fuseMount {
+		return mountFuseOverlay(layers, mountpoint)
+	}
+
+	var mountargs []string
+	if len(layers) == 1 {
+		mountargs = []string{"-o", "bind,ro", layers[0], mountpoint}
+	} else {
+		mountopts := fmt.Sprintf("ro,lowerdir=%s", strings.Join(layers, ":"))
+		mountargs = []string{"-t", "overlay", "overlay", "-o", mountopts, mountpoint}
+	}
+	log.Debug("container mount command ", mountargs)
+
+	cmd := exec.Command("mount", mountargs...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		ferr := mountFuseOverlay(layers, mountpoint)
+		if ferr == nil {
+			log.WithField("mountpoint", mountpoint).Info("mounted container using fuse-overlayfs")
+			return nil
+		}
+		log.Debug("fuse-overlayfs fallback: ", ferr)
+
+		log.Errorf("running mount command %v", mountargs)
+
+		if strings.Contains(err.Error(), " 32") {
+			return fmt.Errorf("invalid lowerdir path %v. Use --debug to view lowerdir path", err)
+		}
+		return fmt.Errorf("executing mount command %v", err)
+	}
+
+	if string(out) != "" {
+		log.WithField("mount command", string(out)).Debug("container mount command")
+	}
+
+	return nil
+}
+
+// MountDevice mounts a block device read-only without replaying the
+// filesystem journal i.e. noload for ext4 and norecovery for xfs.
+func MountDevice(device string, mountpoint string) error {
+	var (
+		out []byte
+		err error
+	)
+	for _, opts := range []string{"ro,noload", "ro,norecovery", "ro"} {
+		out, err = exec.Command("mount", "-o", opts, device, mountpoint).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("mounting %s: %v %s", device, err, strings.TrimSpace(string(out)))
+}
+
+// MountFilesystem mounts a filesystem read-only.
+func MountFilesystem(source string, fstype string, mountpoint string) error {
+	out, err := exec.Command("mount", "-t", fstype, "-o", "ro", source, mountpoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mounting %s: %v %s", source, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// MountImagePartition mounts a partition of a raw disk image read-only using
+// a loop device. The partition starts at offset and is size bytes long.
+func MountImagePartition(image string, offset int64, size int64, fstype string, mountpoint string) error {
+	mountopts := fmt.Sprintf("ro,loop,offset=%d,sizelimit=%d", offset, size)
+	if opt := journalOption(fstype); opt != "" {
+		mountopts += "," + opt
+	}
+	log.WithField("options", mountopts).Debug("mounting disk image partition")
+
+	out, err := exec.Command("mount", "-t", fstype, "-o", mountopts, image, mountpoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mounting %s at offset %d: %v %s", image, offset, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// DetachMount lazily unmounts the mount point using umount -l. The mount
+// point is detached immediately and the filesystem is released when it is no
+// longer busy i.e. when the open databases are closed on exit.
+func DetachMount(mountpoint string) error {
+	out, err := exec.Command("umount", "-l", mountpoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unmounting %s: %v %s", mountpoint, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// unmount unmounts the mount point using the umount command. A FUSE mount
+// point is unmounted using fusermount if umount fails.
+func unmount(mountpoint string) error {
+	if fuseMount {
+		if err := unmountFuse(mountpoint); err == nil {
+			return nil
+		}
+	}
+
+	out, err := exec.Command("umount", mountpoint).CombinedOutput()
+	if err != nil {
+		if unmountFuse(mountpoint) == nil {
+			return nil
+		}
+		return fmt.Errorf("unmounting %s: %v %s", mountpoint, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
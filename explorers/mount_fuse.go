@@ -0,0 +1,85 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// fuseOverlayCommand mounts overlay filesystems in user space. It is used
+// when the kernel overlay filesystem cannot be mounted i.e. on an analysis
+// host without the overlay module or when running as a non-root user or
+// within a container.
+const fuseOverlayCommand = "fuse-overlayfs"
+
+// fuseMount selects fuse-overlayfs over the kernel overlay filesystem.
+var fuseMount bool
+
+// EnableFuseMount mounts the layers in user space using fuse-overlayfs
+// rather than the kernel overlay filesystem. The kernel overlay filesystem
+// requires CAP_SYS_ADMIN while a FUSE filesystem is mounted by a non-root
+// user using the setuid fusermount helper.
+func EnableFuseMount() {
+	fuseMount = true
+}
+
+// FuseMountEnabled returns true if the layers are mounted using
+// fuse-overlayfs.
+func FuseMountEnabled() bool {
+	return fuseMount
+}
+
+// mountFuseOverlay mounts the layers read-only using fuse-overlayfs.
+func mountFuseOverlay(layers []string, mountpoint string) error {
+	path, err := exec.LookPath(fuseOverlayCommand)
+	if err != nil {
+		return fmt.Errorf("%s is not installed: %w", fuseOverlayCommand, err)
+	}
+
+	mountopts := fmt.Sprintf("ro,lowerdir=%s", strings.Join(layers, ":"))
+	log.WithField("options", mountopts).Debug("mounting container using fuse-overlayfs")
+
+	out, err := exec.Command(path, "-o", mountopts, mountpoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running %s: %v %s", fuseOverlayCommand, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// unmountFuse unmounts a FUSE mount point using fusermount3 or fusermount.
+//
+// A non-root user cannot unmount a FUSE mount point using umount.
+func unmountFuse(mountpoint string) error {
+	err := fmt.Errorf("fusermount is not installed")
+	for _, name := range []string{"fusermount3", "fusermount"} {
+		path, lerr := exec.LookPath(name)
+		if lerr != nil {
+			continue
+		}
+
+		out, cerr := exec.Command(path, "-u", mountpoint).CombinedOutput()
+		if cerr == nil {
+			return nil
+		}
+		err = fmt.Errorf("running %s: %v %s", name, cerr, strings.TrimSpace(string(out)))
+	}
+	return err
+}
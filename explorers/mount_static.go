@@ -0,0 +1,223 @@
+//go:build static && linux
+// +build static,linux
+
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mountLayers mounts the layers using the mount system call.
+//
+// The static build does not depend on the mount command of the analysis
+// host. The layers are mounted using fuse-overlayfs if the mount system call
+// fails and fuse-overlayfs is installed, or if the FUSE mount is enabled.
+func mountLayers(layers []string, mountpoint string) error {
+	if fuseMount {
+		return mountFuseOverlay(layers, mountpoint)
+	}
+
+	err := mountLayersSyscall(layers, mountpoint)
+	if err == nil {
+		return nil
+	}
+	ferr := mountFuseOverlay(layers, mountpoint)
+	if ferr == nil {
+		log.WithField("mountpoint", mountpoint).Info("mounted container using fuse-overlayfs")
+		return nil
+	}
+	log.Debug("fuse-overlayfs fallback: ", ferr)
+	return err
+}
+
+// mountLayersSyscall mounts the layers using the mount system call.
+func mountLayersSyscall(layers []string, mountpoint string) error {
+	if len(layers) == 1 {
+		log.WithField("source", layers[0]).Debug("bind mounting container layer")
+		if err := syscall.Mount(layers[0], mountpoint, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("bind mounting %s: %w", layers[0], err)
+		}
+		// A bind mount is made read-only by remounting it.
+		if err := syscall.Mount("", mountpoint, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			syscall.Unmount(mountpoint, 0)
+			return fmt.Errorf("remounting %s read-only: %w", mountpoint, err)
+		}
+		return nil
+	}
+
+	data := fmt.Sprintf("lowerdir=%s", strings.Join(layers, ":"))
+	log.WithField("data", data).Debug("mounting container overlay")
+	if err := syscall.Mount("overlay", mountpoint, "overlay", syscall.MS_RDONLY, data); err != nil {
+		return fmt.Errorf("mounting overlay: %w. Use --debug to view lowerdir path", err)
+	}
+	return nil
+}
+
+// MountDevice mounts a block device read-only without replaying the
+// filesystem journal i.e. noload for ext4 and norecovery for xfs.
+func MountDevice(device string, mountpoint string) error {
+	var err error
+	for _, fs := range []struct{ fstype, data string }{
+		{"ext4", "noload"},
+		{"xfs", "norecovery"},
+	} {
+		if err = syscall.Mount(device, mountpoint, fs.fstype, syscall.MS_RDONLY, fs.data); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("mounting %s: %w", device, err)
+}
+
+// MountFilesystem mounts a filesystem read-only.
+func MountFilesystem(source string, fstype string, mountpoint string) error {
+	if err := syscall.Mount(source, mountpoint, fstype, syscall.MS_RDONLY, ""); err != nil {
+		return fmt.Errorf("mounting %s: %w", source, err)
+	}
+	return nil
+}
+
+// Loop device ioctl requests and flags from linux/loop.h.
+const (
+	loopSetFd         = 0x4c00
+	loopClrFd         = 0x4c01
+	loopSetStatus64   = 0x4c04
+	loopCtlGetFree    = 0x4c82
+	loopFlagReadOnly  = 1
+	loopFlagAutoClear = 4
+)
+
+// loopInfo64 is struct loop_info64 from linux/loop.h.
+type loopInfo64 struct {
+	device         uint64
+	inode          uint64
+	rdevice        uint64
+	offset         uint64
+	sizelimit      uint64
+	number         uint32
+	encryptType    uint32
+	encryptKeySize uint32
+	flags          uint32
+	fileName       [64]byte
+	cryptName      [64]byte
+	encryptKey     [32]byte
+	init           [2]uint64
+}
+
+// MountImagePartition mounts a partition of a raw disk image read-only using
+// a loop device. The partition starts at offset and is size bytes long.
+//
+// The loop device is detached automatically when the partition is unmounted.
+func MountImagePartition(image string, offset int64, size int64, fstype string, mountpoint string) error {
+	device, err := attachLoopDevice(image, offset, size)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"image":  image,
+		"offset": offset,
+		"device": device.Name(),
+	}).Debug("attached disk image partition to loop device")
+
+	// The loop device is detached when the last reference is released.
+	defer device.Close()
+
+	if err := syscall.Mount(device.Name(), mountpoint, fstype, syscall.MS_RDONLY, journalOption(fstype)); err != nil {
+		syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopClrFd, 0)
+		return fmt.Errorf("mounting %s at offset %d: %w", image, offset, err)
+	}
+	return nil
+}
+
+// attachLoopDevice attaches the range of the image to a free loop device
+// read-only.
+func attachLoopDevice(image string, offset int64, size int64) (*os.File, error) {
+	ctl, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening loop control device: %w", err)
+	}
+	defer ctl.Close()
+
+	index, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ctl.Fd(), loopCtlGetFree, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("finding free loop device: %w", errno)
+	}
+
+	file, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	device, err := os.OpenFile(fmt.Sprintf("/dev/loop%d", index), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening loop device: %w", err)
+	}
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopSetFd, file.Fd()); errno != 0 {
+		device.Close()
+		return nil, fmt.Errorf("attaching %s to %s: %w", image, device.Name(), errno)
+	}
+
+	info := loopInfo64{
+		offset:    uint64(offset),
+		sizelimit: uint64(size),
+		flags:     loopFlagReadOnly | loopFlagAutoClear,
+	}
+	copy(info.fileName[:], image)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopSetStatus64, uintptr(unsafe.Pointer(&info))); errno != 0 {
+		syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), loopClrFd, 0)
+		device.Close()
+		return nil, fmt.Errorf("setting loop device offset: %w", errno)
+	}
+	return device, nil
+}
+
+// DetachMount lazily unmounts the mount point using MNT_DETACH. The mount
+// point is detached immediately and the filesystem is released when it is no
+// longer busy i.e. when the open databases are closed on exit.
+func DetachMount(mountpoint string) error {
+	if err := syscall.Unmount(mountpoint, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmounting %s: %w", mountpoint, err)
+	}
+	return nil
+}
+
+// unmount unmounts the mount point using the umount system call. A FUSE
+// mount point is unmounted using fusermount if the system call fails.
+func unmount(mountpoint string) error {
+	if fuseMount {
+		if err := unmountFuse(mountpoint); err == nil {
+			return nil
+		}
+	}
+
+	if err := syscall.Unmount(mountpoint, 0); err != nil {
+		if unmountFuse(mountpoint) == nil {
+			return nil
+		}
+		return fmt.Errorf("unmounting %s: %w", mountpoint, err)
+	}
+	return nil
+}
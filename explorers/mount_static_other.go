@@ -0,0 +1,52 @@
+//go:build static && !linux
+// +build static,!linux
+
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import "fmt"
+
+// mountLayers is not supported by the static build on this platform.
+func mountLayers(layers []string, mountpoint string) error {
+	return fmt.Errorf("mounting containers is only supported on linux")
+}
+
+// MountDevice is not supported by the static build on this platform.
+func MountDevice(device string, mountpoint string) error {
+	return fmt.Errorf("mounting devices is only supported on linux")
+}
+
+// MountFilesystem is not supported by the static build on this platform.
+func MountFilesystem(source string, fstype string, mountpoint string) error {
+	return fmt.Errorf("mounting filesystems is only supported on linux")
+}
+
+// MountImagePartition is not supported by the static build on this platform.
+func MountImagePartition(image string, offset int64, size int64, fstype string, mountpoint string) error {
+	return fmt.Errorf("mounting disk images is only supported on linux")
+}
+
+// DetachMount is not supported by the static build on this platform.
+func DetachMount(mountpoint string) error {
+	return fmt.Errorf("unmounting is only supported on linux")
+}
+
+// unmount is not supported by the static build on this platform.
+func unmount(mountpoint string) error {
+	return fmt.Errorf("unmounting is only supported on linux")
+}
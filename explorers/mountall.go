@@ -0,0 +1,219 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mountInfoFile lists the mounts of the current process.
+const mountInfoFile = "/proc/self/mountinfo"
+
+// MountAllOptions configures MountAllContainers.
+type MountAllOptions struct {
+	// SkipSupportContainers skips the Kubernetes support containers.
+	SkipSupportContainers bool
+
+	// RetryFailed resumes the run recorded in the mount index of the mount
+	// point. The containers still mounted are kept and only the containers
+	// that failed or were not attempted are mounted.
+	RetryFailed bool
+}
+
+// MountBatch mounts the containers of a mount-all run.
+//
+// A container failing to mount is recorded in the mount index with the
+// reason, and the batch continues with the next container. The index is
+// written after each container so an interrupted run can be resumed. A
+// resumed run starts from the entries of the previous run and replaces the
+// entry of each container it records, so the entries not yet retried are
+// kept if the resumed run is interrupted too.
+type MountBatch struct {
+	mountpoint string
+	namer      *MountNamer
+	entries    []MountIndexEntry
+	positions  map[string]int // entry index by container key
+
+	// previous holds the entries of the resumed run by container key and
+	// mounted the mount points of the host. mounted is nil if the mounts
+	// cannot be listed.
+	previous map[string]MountIndexEntry
+	mounted  map[string]bool
+}
+
+// NewMountBatch returns a MountBatch mounting the containers to
+// subdirectories of the mount point.
+func NewMountBatch(mountpoint string, opts MountAllOptions) (*MountBatch, error) {
+	b := &MountBatch{
+		mountpoint: mountpoint,
+		namer:      NewMountNamer(),
+		positions:  make(map[string]int),
+		previous:   make(map[string]MountIndexEntry),
+	}
+	if !opts.RetryFailed {
+		return b, nil
+	}
+
+	entries, err := ReadMountIndex(mountpoint)
+	if err != nil {
+		return nil, fmt.Errorf("reading the mount index of the previous run: %w", err)
+	}
+	for _, entry := range entries {
+		key := mountKey(entry.Namespace, entry.ContainerID)
+		b.previous[key] = entry
+		b.positions[key] = len(b.entries)
+		b.entries = append(b.entries, entry)
+
+		// The directories of the previous run are kept for the same
+		// containers.
+		if entry.Directory != "" {
+			b.namer.used[entry.Directory] = true
+		}
+	}
+
+	if b.mounted, err = mountedPaths(); err != nil {
+		log.Debug("listing mounts. The mounts of the previous run are not verified: ", err)
+	}
+	return b, nil
+}
+
+// Mount mounts the container using the mount function and records the
+// result. The mount point directory is removed if the mount fails.
+func (b *MountBatch) Mount(ctr Container, mount func(mountpoint string) error) {
+	entry := NewMountIndexEntry("", ctr)
+	if previous, found := b.previous[mountKey(ctr.Namespace, ctr.ID)]; found {
+		if previous.Status == MountStatusMounted && b.isMounted(previous.Directory) {
+			log.WithField("containerid", ctr.ID).Debug("container mounted by the previous run")
+			b.record(previous)
+			return
+		}
+		entry.Directory = previous.Directory
+		entry.Attempts = previous.Attempts
+	}
+	if entry.Directory == "" {
+		entry.Directory = b.namer.Name(ctr)
+	}
+	entry.Attempts++
+
+	ctrmountpoint := filepath.Join(b.mountpoint, entry.Directory)
+	err := os.MkdirAll(ctrmountpoint, 0755)
+	if err != nil {
+		err = fmt.Errorf("creating mount point: %w", err)
+	} else if err = mount(ctrmountpoint); err != nil {
+		// The directory is only removed if empty.
+		os.Remove(ctrmountpoint)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"namespace":   ctr.Namespace,
+			"containerid": ctr.ID,
+			"mountpoint":  ctrmountpoint,
+		}).Warn("skipping container. Mounting failed: ", err)
+
+		entry.Status = MountStatusFailed
+		entry.Reason = err.Error()
+	}
+	b.record(entry)
+}
+
+// Skip records a container not mounted.
+func (b *MountBatch) Skip(ctr Container, reason string) {
+	entry := NewMountIndexEntry("", ctr)
+	entry.Status = MountStatusSkipped
+	entry.Reason = reason
+	b.record(entry)
+}
+
+// Fail records a container that cannot be mounted.
+func (b *MountBatch) Fail(ctr Container, err error) {
+	entry := NewMountIndexEntry("", ctr)
+	if previous, found := b.previous[mountKey(ctr.Namespace, ctr.ID)]; found {
+		entry.Attempts = previous.Attempts
+	}
+	entry.Attempts++
+	entry.Status = MountStatusFailed
+	entry.Reason = err.Error()
+	b.record(entry)
+}
+
+// Close writes the mount index and returns the entries of the run. The
+// entries of a resumed run include the entries of the previous run.
+func (b *MountBatch) Close() ([]MountIndexEntry, error) {
+	return b.entries, WriteMountIndex(b.mountpoint, b.entries)
+}
+
+// record adds or replaces the entry of the container and writes the mount
+// index.
+func (b *MountBatch) record(entry MountIndexEntry) {
+	key := mountKey(entry.Namespace, entry.ContainerID)
+	if i, found := b.positions[key]; found {
+		b.entries[i] = entry
+	} else {
+		b.positions[key] = len(b.entries)
+		b.entries = append(b.entries, entry)
+	}
+	if err := WriteMountIndex(b.mountpoint, b.entries); err != nil {
+		log.Warn(err)
+	}
+}
+
+// isMounted returns true if the directory of the mount point is mounted.
+func (b *MountBatch) isMounted(directory string) bool {
+	if directory == "" {
+		return false
+	}
+	if b.mounted == nil {
+		return true
+	}
+	path, err := filepath.Abs(filepath.Join(b.mountpoint, directory))
+	if err != nil {
+		return false
+	}
+	return b.mounted[path]
+}
+
+// mountKey returns the key of a container in the mount index.
+func mountKey(namespace string, containerid string) string {
+	return namespace + "/" + containerid
+}
+
+// mountedPaths returns the mount points of the current process.
+func mountedPaths() (map[string]bool, error) {
+	f, err := os.Open(mountInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// mountinfo fields: id parent major:minor root mountpoint options
+	paths := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		paths[strings.ReplaceAll(fields[4], `\040`, " ")] = true
+	}
+	return paths, scanner.Err()
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestMountBatchRetryInterrupted checks that an interrupted --retry-failed
+// run keeps the entries of the previous run it has not retried yet.
+func TestMountBatchRetryInterrupted(t *testing.T) {
+	mountpoint := t.TempDir()
+	previous := []MountIndexEntry{
+		{Directory: "a", Namespace: "default", ContainerID: "a", Status: MountStatusFailed, Attempts: 1, Reason: "missing layer"},
+		{Directory: "b", Namespace: "default", ContainerID: "b", Status: MountStatusFailed, Attempts: 1, Reason: "missing layer"},
+		{Namespace: "default", ContainerID: "c", Status: MountStatusSkipped, Reason: "Kubernetes support container"},
+	}
+	if err := WriteMountIndex(mountpoint, previous); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry mounts b and is interrupted before a and c are recorded.
+	b, err := NewMountBatch(mountpoint, MountAllOptions{RetryFailed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Mount(testContainer("b"), func(string) error { return nil })
+
+	entries, err := ReadMountIndex(mountpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.ContainerID+" "+e.Status)
+	}
+	want := []string{"a failed", "b mounted", "c skipped"}
+	if len(got) != len(want) {
+		t.Fatalf("mount index = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("mount index = %v, want %v", got, want)
+		}
+	}
+	if entries[1].Directory != "b" || entries[1].Attempts != 2 {
+		t.Errorf("entry of b = %+v, want directory b and 2 attempts", entries[1])
+	}
+
+	// A second retry still finds a and records its new failure in place.
+	b, err = NewMountBatch(mountpoint, MountAllOptions{RetryFailed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Fail(testContainer("a"), errors.New("missing layer"))
+	entries, err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].ContainerID != "a" || entries[0].Attempts != 2 {
+		t.Errorf("entries = %+v, want a with 2 attempts first of 3 entries", entries)
+	}
+}
+
+func testContainer(id string) Container {
+	ctr := Container{Namespace: "default"}
+	ctr.ID = id
+	return ctr
+}
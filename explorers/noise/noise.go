@@ -0,0 +1,182 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package noise provides the suppression profiles of the common noisy
+// workloads i.e. monitoring agents, service meshes, and log shippers.
+//
+// A noise profile is a YAML file identifying the containers of a workload
+// category by image, container or pod hostname, and label i.e.
+//
+//	name: log-shipping
+//	description: Log collectors and shippers running on every node
+//	images:
+//	  - fluent/fluentd
+//	labels:
+//	  - io.kubernetes.container.name=fluent-bit
+//
+// The images and names match as case-insensitive substrings and the labels
+// match as case-insensitive key=value pairs, as for the support containers.
+package noise
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Profile identifies the containers of a noisy workload category.
+type Profile struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description"`
+
+	explorers.SupportContainer `yaml:",inline"`
+}
+
+// Matches returns true if the container belongs to the profile.
+func (p Profile) Matches(ctr explorers.Container) bool {
+	return p.IsSupportContainer(ctr)
+}
+
+//go:embed profiles/*.yaml
+var builtin embed.FS
+
+var (
+	mu       sync.RWMutex
+	profiles = make(map[string]Profile)
+)
+
+func init() {
+	entries, err := builtin.ReadDir("profiles")
+	if err != nil {
+		panic(fmt.Sprintf("noise: reading built-in profiles: %v", err))
+	}
+	for _, entry := range entries {
+		data, err := builtin.ReadFile("profiles/" + entry.Name())
+		if err != nil {
+			panic(fmt.Sprintf("noise: reading built-in profile %s: %v", entry.Name(), err))
+		}
+		p, err := parse(data)
+		if err != nil {
+			panic(fmt.Sprintf("noise: parsing built-in profile %s: %v", entry.Name(), err))
+		}
+		if err := Register(p); err != nil {
+			panic(fmt.Sprintf("noise: registering built-in profile %s: %v", entry.Name(), err))
+		}
+	}
+}
+
+// parse parses a noise profile.
+func parse(data []byte) (Profile, error) {
+	var p Profile
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return Profile{}, err
+	}
+	return p, nil
+}
+
+// Register makes a noise profile available by name. A profile registered
+// with the name of an existing profile replaces the existing profile.
+func Register(p Profile) error {
+	if p.Name == "" {
+		return fmt.Errorf("noise profile name is empty")
+	}
+	if len(p.ContainerNames) == 0 && len(p.ImageNames) == 0 && len(p.Labels) == 0 {
+		return fmt.Errorf("noise profile %s has no names, images, or labels", p.Name)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, found := profiles[p.Name]; found {
+		log.WithField("profile", p.Name).Debug("replacing noise profile")
+	}
+	profiles[p.Name] = p
+	return nil
+}
+
+// Load registers the noise profiles in a YAML file or in the YAML files of
+// a directory.
+func Load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, _ = filepath.Glob(filepath.Join(path, "*.yaml"))
+		yml, _ := filepath.Glob(filepath.Join(path, "*.yml"))
+		files = append(files, yml...)
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("reading noise profile %s: %w", file, err)
+		}
+		p, err := parse(data)
+		if err != nil {
+			return fmt.Errorf("parsing noise profile %s: %w", file, err)
+		}
+		if err := Register(p); err != nil {
+			return fmt.Errorf("registering noise profile %s: %w", file, err)
+		}
+		log.WithFields(log.Fields{
+			"profile": p.Name,
+			"file":    file,
+		}).Debug("loaded noise profile")
+	}
+	return nil
+}
+
+// Get returns the noise profile registered by name.
+func Get(name string) (Profile, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, found := profiles[name]
+	return p, found
+}
+
+// Profiles returns the registered noise profiles ordered by name.
+func Profiles() []Profile {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var list []Profile
+	for _, p := range profiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Match returns the first profile the container belongs to.
+func Match(list []Profile, ctr explorers.Container) (Profile, bool) {
+	for _, p := range list {
+		if p.Matches(ctr) {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ociLayoutFilename is the marker file of an OCI image layout.
+	ociLayoutFilename = "oci-layout"
+
+	// ociIndexFilename is the image index of an OCI image layout.
+	ociIndexFilename = "index.json"
+
+	// AnnotationImageName is the full image name annotation used by
+	// containerd to import an OCI image layout.
+	AnnotationImageName = "io.containerd.image.name"
+)
+
+// ImageBlob is a blob of an image in the content store.
+type ImageBlob struct {
+	ocispec.Descriptor
+	Path string `json:"path,omitempty"` // blob file or empty if the blob is missing
+}
+
+// ImageBlobExplorer is implemented by the explorers keeping the image blobs
+// in a content store i.e. containerd.
+type ImageBlobExplorer interface {
+	// ImageBlobs returns the image name, the image target descriptor, and
+	// the blobs reachable from the target i.e. the index, manifests,
+	// configurations, and layers. The image is looked up in the namespace of
+	// the context or in all the namespaces.
+	ImageBlobs(ctx context.Context, name string) (string, ocispec.Descriptor, []ImageBlob, error)
+
+	// ContentBlob returns the path of a blob in the content store.
+	ContentBlob(ctx context.Context, dgst digest.Digest) (string, error)
+}
+
+// WriteOCILayout writes the blobs of an image to an OCI image layout
+// directory and adds the image target to the index of the layout.
+//
+// The blobs are verified against their digests while they are copied. The
+// blobs already in the layout are kept, so several images can be written to
+// the same layout. The image replaces an image of the same name in the
+// index.
+func WriteOCILayout(dir string, name string, target ocispec.Descriptor, blobs []ImageBlob) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating OCI layout directory %s: %w", dir, err)
+	}
+
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ociLayoutFilename), layout, 0644); err != nil {
+		return err
+	}
+
+	for _, blob := range blobs {
+		if blob.Path == "" {
+			continue
+		}
+		if err := copyBlob(blob.Path, dir, blob.Digest); err != nil {
+			return err
+		}
+	}
+
+	var index ocispec.Index
+	indexfile := filepath.Join(dir, ociIndexFilename)
+	if data, err := os.ReadFile(indexfile); err == nil {
+		if err := json.Unmarshal(data, &index); err != nil {
+			return fmt.Errorf("unmarshalling %s: %w", indexfile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	index.SchemaVersion = 2
+
+	desc := ocispec.Descriptor{
+		MediaType: target.MediaType,
+		Digest:    target.Digest,
+		Size:      target.Size,
+		Annotations: map[string]string{
+			AnnotationImageName:       name,
+			ocispec.AnnotationRefName: imageRefName(name),
+		},
+	}
+	manifests := []ocispec.Descriptor{desc}
+	for _, m := range index.Manifests {
+		if m.Annotations[AnnotationImageName] != name {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = manifests
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(indexfile, data, 0644)
+}
+
+// copyBlob copies a blob to the blobs directory of an OCI image layout and
+// verifies the blob digest.
+func copyBlob(src string, dir string, dgst digest.Digest) error {
+	if err := dgst.Validate(); err != nil {
+		return err
+	}
+	blobdir := filepath.Join(dir, "blobs", dgst.Algorithm().String())
+	dst := filepath.Join(blobdir, dgst.Encoded())
+	if PathExists(dst, true) {
+		return nil
+	}
+	if err := os.MkdirAll(blobdir, 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// The blob is written to a temporary file renamed after the digest
+	// is verified, so the layout never holds a blob not matching its name.
+	out, err := os.CreateTemp(blobdir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(io.MultiWriter(out, verifier), in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying blob %s: %w", dgst, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob %s does not match its digest. The content store may have been tampered with", src)
+	}
+
+	log.WithFields(log.Fields{
+		"digest": dgst,
+		"path":   src,
+	}).Debug("copied blob to OCI layout")
+	return os.Rename(out.Name(), dst)
+}
+
+// imageRefName returns the reference name annotation of an image i.e. the
+// tag of the image name.
+func imageRefName(name string) string {
+	ref, err := docker.ParseDockerRef(name)
+	if err != nil {
+		return name
+	}
+	if tagged, ok := ref.(docker.Tagged); ok {
+		return tagged.Tag()
+	}
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ContainerPager is implemented by the explorers that read only the
+// containers of the requested page i.e. containerd on a Kubernetes node with
+// tens of thousands of exited containers.
+type ContainerPager interface {
+	// ListContainersPage returns up to limit containers following the page
+	// token and the token of the next page. The next page token is empty on
+	// the last page.
+	ListContainersPage(ctx context.Context, limit int, token string) ([]Container, string, error)
+}
+
+// ContainerRecordLister is implemented by the explorers that list the
+// container records without decoding the container spec or reading the
+// container task and runtime state.
+type ContainerRecordLister interface {
+	// ListContainerRecords returns the containers in all the namespaces. The
+	// Hostname, ProcessID, and VolatileMounts are not set.
+	ListContainerRecords(ctx context.Context) ([]Container, error)
+}
+
+// PageToken returns the opaque token of the page following a container.
+//
+// The containers are paged in namespace and container ID order.
+func PageToken(ctr Container) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(ctr.Namespace + "/" + ctr.ID))
+}
+
+// ParsePageToken returns the namespace and container ID of the last
+// container of the previous page.
+func ParsePageToken(token string) (string, string, error) {
+	if token == "" {
+		return "", "", nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid page token %s: %w", token, err)
+	}
+	// The namespace of a containerd instance i.e. host-containerd/default
+	// contains a slash. The container ID does not.
+	i := strings.LastIndex(string(data), "/")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid page token %s", token)
+	}
+	return string(data[:i]), string(data[i+1:]), nil
+}
+
+// PageContainers returns up to limit containers following the page token and
+// the token of the next page.
+//
+// PageContainers is used for the explorers that do not implement
+// ContainerPager. A limit of 0 returns all the containers following the page
+// token.
+func PageContainers(ctrs []Container, limit int, token string) ([]Container, string, error) {
+	afterns, afterid, err := ParsePageToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.SliceStable(ctrs, func(i, j int) bool {
+		if ctrs[i].Namespace != ctrs[j].Namespace {
+			return ctrs[i].Namespace < ctrs[j].Namespace
+		}
+		return ctrs[i].ID < ctrs[j].ID
+	})
+
+	start := 0
+	if token != "" {
+		start = sort.Search(len(ctrs), func(i int) bool {
+			if ctrs[i].Namespace != afterns {
+				return ctrs[i].Namespace > afterns
+			}
+			return ctrs[i].ID > afterid
+		})
+	}
+	ctrs = ctrs[start:]
+
+	if limit <= 0 || len(ctrs) <= limit {
+		return ctrs, "", nil
+	}
+	return ctrs[:limit], PageToken(ctrs[limit-1]), nil
+}
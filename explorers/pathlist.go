@@ -0,0 +1,259 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// flsPattern matches an entry of the fls output i.e.
+	// r/r 1234-128-1:	etc/passwd
+	flsPattern = regexp.MustCompile(`^[-a-zA-Z]/[-a-zA-Z] (\* )?[^\t]*:\t(.*)$`)
+
+	// layerPathPattern matches the host path of a file within a layer
+	// directory of containerd, docker, CRI-O, or podman. The container path
+	// follows the layer directory.
+	layerPathPattern = regexp.MustCompile(`/(?:snapshots/[^/]+/fs|overlay2?/[^/]+/diff|aufs/diff/[^/]+|vfs/dir/[^/]+)(/.*)?$`)
+)
+
+// PathList is a list of container paths scoping the filesystem operations
+// i.e. the paths selected by a targeted collection.
+//
+// An entry matches the same container path. An entry ending with a slash
+// matches the files within the directory and an entry with a wildcard is a
+// path.Match pattern. A ** element of a pattern matches zero or more
+// directories i.e. /var/log/**/*.log.
+type PathList struct {
+	exact    map[string]bool
+	prefixes []string
+	patterns []string
+
+	// parents are the ancestor directories of the entries.
+	parents map[string]bool
+}
+
+// ReadPathList reads a file list. The paths are one per line as printed by
+// find, the fls output of The Sleuth Kit, or a bodyfile i.e. fls -m.
+//
+// The host paths of the files within the layer directories are converted to
+// container paths i.e.
+// /var/lib/containerd/io.containerd.snapshotter.v1.overlayfs/snapshots/12/fs/etc/passwd
+// is /etc/passwd. Blank lines and lines starting with # are ignored.
+func ReadPathList(listfile string) (*PathList, error) {
+	f, err := os.Open(listfile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := newPathList()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if entry := pathListEntry(scanner.Text()); entry != "" {
+			l.add(entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading file list %s: %w", listfile, err)
+	}
+	if l.Len() == 0 {
+		return nil, fmt.Errorf("no paths found in file list %s", listfile)
+	}
+	return l, nil
+}
+
+// NewPathList returns a list of container paths or patterns i.e. /etc/**.
+func NewPathList(entries []string) (*PathList, error) {
+	l := newPathList()
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		dir := strings.HasSuffix(entry, "/")
+		entry = path.Clean("/" + entry)
+		if _, err := path.Match(entry, ""); err != nil {
+			return nil, fmt.Errorf("invalid path pattern %s: %w", entry, err)
+		}
+		if dir && entry != "/" && !strings.ContainsAny(entry, "*?[") {
+			entry += "/"
+		}
+		l.add(entry)
+	}
+	if l.Len() == 0 {
+		return nil, fmt.Errorf("no paths specified")
+	}
+	return l, nil
+}
+
+func newPathList() *PathList {
+	return &PathList{
+		exact:   make(map[string]bool),
+		parents: make(map[string]bool),
+	}
+}
+
+// pathListEntry returns the container path of a file list line or an empty
+// string.
+func pathListEntry(line string) string {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+
+	// bodyfile: MD5|name|inode|mode_as_string|UID|GID|size|atime|mtime|ctime|crtime
+	if fields := strings.Split(line, "|"); len(fields) >= 11 {
+		line = fields[1]
+		if i := strings.Index(line, " -> "); i >= 0 {
+			line = line[:i]
+		}
+	} else if m := flsPattern.FindStringSubmatch(line); m != nil {
+		line = m[2]
+	}
+	line = strings.TrimSuffix(line, " (deleted)")
+	line = strings.TrimSuffix(line, " (deleted-realloc)")
+
+	dir := strings.HasSuffix(line, "/")
+	if m := layerPathPattern.FindStringSubmatch(line); m != nil {
+		line = m[1]
+	}
+	line = path.Clean("/" + strings.TrimPrefix(line, "./"))
+	if dir && line != "/" {
+		line += "/"
+	}
+	return line
+}
+
+// add adds an entry and the ancestor directories of the entry.
+func (l *PathList) add(entry string) {
+	literal := entry
+	switch {
+	case strings.ContainsAny(entry, "*?["):
+		l.patterns = append(l.patterns, entry)
+		literal = entry[:strings.IndexAny(entry, "*?[")]
+	case strings.HasSuffix(entry, "/"):
+		l.prefixes = append(l.prefixes, entry)
+	default:
+		l.exact[entry] = true
+	}
+
+	for dir := path.Dir(strings.TrimSuffix(literal, "/")); ; dir = path.Dir(dir) {
+		l.parents[dir] = true
+		if dir == "/" || dir == "." {
+			break
+		}
+	}
+}
+
+// Len returns the number of entries.
+func (l *PathList) Len() int {
+	return len(l.exact) + len(l.prefixes) + len(l.patterns)
+}
+
+// Contains returns true if the container path matches an entry.
+func (l *PathList) Contains(p string) bool {
+	if l.exact[p] {
+		return true
+	}
+	for _, prefix := range l.prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	for _, pattern := range l.patterns {
+		if matchPattern(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern returns true if the container path matches a pattern. A **
+// element matches zero or more path elements.
+func matchPattern(pattern string, p string) bool {
+	if !strings.Contains(pattern, "**") {
+		matched, _ := path.Match(pattern, p)
+		return matched
+	}
+	return matchElements(strings.Split(pattern, "/"), strings.Split(p, "/"))
+}
+
+func matchElements(pattern []string, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElements(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], elems[0]); !matched {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// Visit returns true if the container directory may contain a file matching
+// an entry.
+func (l *PathList) Visit(dir string) bool {
+	if l.parents[dir] || l.Contains(dir) || l.Contains(dir+"/") {
+		return true
+	}
+	for _, pattern := range l.patterns {
+		// The directories below the literal prefix of a pattern may
+		// contain a match i.e. /home/*/.bash_history.
+		literal := pattern[:strings.IndexAny(pattern, "*?[")]
+		if strings.HasPrefix(dir+"/", literal) || strings.HasPrefix(literal, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns a WalkLayers function calling fn for the files matching an
+// entry only. The directories without a match are skipped. A nil list does
+// not filter.
+func (l *PathList) Filter(fn func(LayerFile) error) func(LayerFile) error {
+	if l == nil {
+		return fn
+	}
+	return func(f LayerFile) error {
+		if f.Info.IsDir() {
+			if !l.Visit(f.Path) {
+				return filepath.SkipDir
+			}
+		}
+		if !l.Contains(f.Path) {
+			return nil
+		}
+		return fn(f)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package podman
+
+import (
+	"time"
+
+	spec "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// ContainerConfig represents the libpod container configuration.
+//
+// The structure only maps the required attributes.
+//
+// Reference to Podman source code
+// https://github.com/containers/podman/blob/main/libpod/container_config.go
+type ContainerConfig struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	Pod             string            `json:"pod,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	RootfsImageID   string            `json:"rootfsImageID,omitempty"`
+	RootfsImageName string            `json:"rootfsImageName,omitempty"`
+	RawImageName    string            `json:"rawImageName,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	CreatedTime     time.Time         `json:"createdTime"`
+	IsInfra         bool              `json:"pause"`
+	Spec            *spec.Spec        `json:"spec"`
+}
+
+// ContainerState represents the libpod container state.
+//
+// Reference to Podman source code
+// https://github.com/containers/podman/blob/main/libpod/container.go
+type ContainerState struct {
+	State        int       `json:"state"`
+	PID          int       `json:"pid,omitempty"`
+	StartedTime  time.Time `json:"startedTime"`
+	FinishedTime time.Time `json:"finishedTime"`
+	ExitCode     int32     `json:"exitCode,omitempty"`
+}
+
+// libpod container states.
+const (
+	stateUnknown = iota
+	stateConfigured
+	stateCreated
+	stateRunning
+	stateStopped
+	statePaused
+	stateExited
+	stateRemoving
+	stateStopping
+)
+
+// Status returns the container status used by container explorer.
+func (s ContainerState) Status() string {
+	switch s.State {
+	case stateConfigured, stateCreated:
+		return "CREATED"
+	case stateRunning, stateStopping:
+		return "RUNNING"
+	case statePaused:
+		return "PAUSED"
+	case stateStopped, stateExited, stateRemoving:
+		return "STOPPED"
+	}
+	return "UNKNOWN"
+}
+
+// PodConfig represents the libpod pod configuration.
+//
+// Reference to Podman source code
+// https://github.com/containers/podman/blob/main/libpod/pod.go
+type PodConfig struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	CreatedTime time.Time         `json:"created"`
+}
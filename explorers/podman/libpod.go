@@ -0,0 +1,201 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package podman
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// boltStateFilename is the libpod state database used before Podman 5
+	// i.e. <graph root>/libpod/bolt_state.db.
+	boltStateFilename = "bolt_state.db"
+
+	// sqliteStateFilename is the libpod state database used by Podman 5
+	// i.e. <graph root>/db.sql.
+	sqliteStateFilename = "db.sql"
+
+	libpodDirName = "libpod"
+)
+
+// libpod bolt_state.db buckets and keys.
+const (
+	ctrBucket = "ctr"
+	podBucket = "pod"
+	configKey = "config"
+	stateKey  = "state"
+)
+
+// libpodState holds the libpod containers and pods of a store.
+type libpodState struct {
+	configs map[string]ContainerConfig
+	states  map[string]ContainerState
+	pods    map[string]PodConfig
+}
+
+// readLibpodState reads the libpod state database of a containers storage
+// root.
+//
+// The bolt database is opened read-only. The SQLite database is read using
+// the sqlite3 command.
+func readLibpodState(root string) (libpodState, error) {
+	state := libpodState{
+		configs: make(map[string]ContainerConfig),
+		states:  make(map[string]ContainerState),
+		pods:    make(map[string]PodConfig),
+	}
+
+	boltpath := filepath.Join(root, libpodDirName, boltStateFilename)
+	if explorers.PathExists(boltpath, true) {
+		return state, state.readBolt(boltpath)
+	}
+
+	sqlitepath := filepath.Join(root, sqliteStateFilename)
+	if explorers.PathExists(sqlitepath, true) {
+		return state, state.readSQLite(sqlitepath)
+	}
+
+	return state, fmt.Errorf("libpod state database does not exist in %s", root)
+}
+
+// readBolt reads the container and pod configuration from bolt_state.db.
+func (s *libpodState) readBolt(path string) error {
+	db, err := explorers.OpenBolt(path, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("opening libpod database %s: %w", path, err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		if bkt := tx.Bucket([]byte(ctrBucket)); bkt != nil {
+			bkt.ForEach(func(k, v []byte) error {
+				ctrbkt := bkt.Bucket(k)
+				if ctrbkt == nil {
+					return nil
+				}
+
+				var config ContainerConfig
+				if err := json.Unmarshal(ctrbkt.Get([]byte(configKey)), &config); err != nil {
+					log.WithField("containerid", string(k)).Debug("unmarshalling libpod container config: ", err)
+					return nil
+				}
+				s.configs[config.ID] = config
+
+				var state ContainerState
+				if err := json.Unmarshal(ctrbkt.Get([]byte(stateKey)), &state); err == nil {
+					s.states[config.ID] = state
+				}
+				return nil
+			})
+		}
+
+		if bkt := tx.Bucket([]byte(podBucket)); bkt != nil {
+			bkt.ForEach(func(k, v []byte) error {
+				podbkt := bkt.Bucket(k)
+				if podbkt == nil {
+					return nil
+				}
+
+				var pod PodConfig
+				if err := json.Unmarshal(podbkt.Get([]byte(configKey)), &pod); err != nil {
+					log.WithField("podid", string(k)).Debug("unmarshalling libpod pod config: ", err)
+					return nil
+				}
+				s.pods[pod.ID] = pod
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// sqliteRow holds a row of the libpod SQLite tables.
+type sqliteRow struct {
+	ID   string
+	JSON string
+}
+
+// readSQLite reads the container and pod configuration from db.sql.
+func (s *libpodState) readSQLite(path string) error {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return fmt.Errorf("sqlite3 is required to read %s: %w", path, err)
+	}
+
+	configs, err := querySQLite(path, "SELECT ID, JSON FROM ContainerConfig")
+	if err != nil {
+		return err
+	}
+	for _, row := range configs {
+		var config ContainerConfig
+		if err := json.Unmarshal([]byte(row.JSON), &config); err != nil {
+			log.WithField("containerid", row.ID).Debug("unmarshalling libpod container config: ", err)
+			continue
+		}
+		s.configs[row.ID] = config
+	}
+
+	states, err := querySQLite(path, "SELECT ID, JSON FROM ContainerState")
+	if err != nil {
+		log.Warn("reading libpod container state: ", err)
+	}
+	for _, row := range states {
+		var state ContainerState
+		if err := json.Unmarshal([]byte(row.JSON), &state); err == nil {
+			s.states[row.ID] = state
+		}
+	}
+
+	pods, err := querySQLite(path, "SELECT ID, JSON FROM PodConfig")
+	if err != nil {
+		log.Warn("reading libpod pods: ", err)
+	}
+	for _, row := range pods {
+		var pod PodConfig
+		if err := json.Unmarshal([]byte(row.JSON), &pod); err == nil {
+			s.pods[row.ID] = pod
+		}
+	}
+	return nil
+}
+
+// querySQLite returns the rows of a query using the sqlite3 command.
+//
+// The database is opened as immutable to avoid modifying the evidence.
+func querySQLite(path string, query string) ([]sqliteRow, error) {
+	cmd := exec.Command("sqlite3", "-readonly", "-json", "file:"+path+"?immutable=1", query)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running sqlite3: %w", err)
+	}
+
+	var rows []sqliteRow
+	if len(out) == 0 {
+		return rows, nil
+	}
+	if err := json.Unmarshal(out, &rows); err != nil {
+		return nil, fmt.Errorf("unmarshalling sqlite3 output: %w", err)
+	}
+	return rows, nil
+}
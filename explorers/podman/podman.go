@@ -0,0 +1,399 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package podman
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	"github.com/google/container-explorer/explorers/crio"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// rootfulStorageDir is the containers storage root of rootful Podman.
+	rootfulStorageDir = "/var/lib/containers/storage"
+
+	// rootlessStorageDir is the containers storage root of rootless Podman
+	// relative to the user home directory.
+	rootlessStorageDir = ".local/share/containers/storage"
+)
+
+// Store describes a containers storage root used by Podman.
+//
+// Namespace is empty for the rootful store and the user name for a rootless
+// store.
+type Store struct {
+	Namespace string
+	Root      string
+}
+
+// FindStores returns the rootful and rootless containers storage roots
+// within the image root.
+func FindStores(imageroot string) []Store {
+	var stores []Store
+
+	rootful := filepath.Join(imageroot, rootfulStorageDir)
+	if explorers.PathExists(rootful, false) {
+		stores = append(stores, Store{Root: rootful})
+	}
+
+	homes, _ := filepath.Glob(filepath.Join(imageroot, "home", "*"))
+	homes = append(homes, filepath.Join(imageroot, "root"))
+	for _, home := range homes {
+		root := filepath.Join(home, rootlessStorageDir)
+		if explorers.PathExists(root, false) {
+			stores = append(stores, Store{
+				Namespace: filepath.Base(home),
+				Root:      root,
+			})
+		}
+	}
+	return stores
+}
+
+// store holds the storage explorer and the libpod state of a Store.
+type store struct {
+	Store
+	exp   explorers.ContainerExplorer // containers storage explorer
+	state libpodState
+}
+
+type explorer struct {
+	imageroot string
+	stores    []store
+	sc        *explorers.SupportContainer
+}
+
+// NewExplorer returns a ContainerExplorer interface to explore Podman
+// managed containers in one or more containers storage roots.
+//
+// Podman and CRI-O share the containers storage layout. The images, layers,
+// and container filesystems are read using the CRI-O explorer and the
+// container names, pods, and states are read from the libpod database.
+func NewExplorer(imageroot string, stores []Store, sc *explorers.SupportContainer) (explorers.ContainerExplorer, error) {
+	e := &explorer{
+		imageroot: imageroot,
+		sc:        sc,
+	}
+
+	for _, s := range stores {
+		exp, err := crio.NewExplorer(imageroot, s.Root, sc)
+		if err != nil {
+			log.WithField("root", s.Root).Warn("skipping containers storage: ", err)
+			continue
+		}
+
+		state, err := readLibpodState(s.Root)
+		if err != nil {
+			log.WithField("root", s.Root).Warn("reading libpod state: ", err)
+		}
+
+		log.WithFields(log.Fields{
+			"namespace":  s.Namespace,
+			"root":       s.Root,
+			"containers": len(state.configs),
+			"pods":       len(state.pods),
+		}).Debug("podman store")
+
+		e.stores = append(e.stores, store{
+			Store: s,
+			exp:   exp,
+			state: state,
+		})
+	}
+
+	if len(e.stores) == 0 {
+		return e, fmt.Errorf("no Podman containers storage found")
+	}
+	return e, nil
+}
+
+// SnapshotRoot returns the storage driver directory of the first store.
+func (e *explorer) SnapshotRoot(snapshotter string) string {
+	return e.stores[0].exp.SnapshotRoot(snapshotter)
+}
+
+// ListNamespaces returns the user names of the rootless stores.
+func (e *explorer) ListNamespaces(ctx context.Context) ([]string, error) {
+	var nss []string
+	for _, s := range e.stores {
+		if s.Namespace != "" {
+			nss = append(nss, s.Namespace)
+		}
+	}
+	return nss, nil
+}
+
+// ListContainers returns the information about containers.
+//
+// The containers read from containers storage are updated with the libpod
+// container name, labels, pod, and state.
+func (e *explorer) ListContainers(ctx context.Context) ([]explorers.Container, error) {
+	var cecontainers []explorers.Container
+
+	for _, s := range e.stores {
+		ctrs, err := s.exp.ListContainers(ctx)
+		if err != nil {
+			log.WithField("root", s.Root).Warn("listing containers: ", err)
+			continue
+		}
+
+		for _, ctr := range ctrs {
+			ctr.Namespace = s.Namespace
+			ctr.ContainerType = "podman"
+			ctr.Runtime.Name = "podman"
+
+			if config, found := s.state.configs[ctr.ID]; found {
+				if ctr.Hostname == "" {
+					ctr.Hostname = config.Name
+				}
+				if image := config.RootfsImageName; image != "" {
+					ctr.Image = image
+				} else if config.RawImageName != "" {
+					ctr.Image = config.RawImageName
+				}
+				for k, v := range config.Labels {
+					ctr.Labels[k] = v
+				}
+				if config.IsInfra {
+					ctr.ContainerType = "sandbox"
+				}
+				if pod, found := s.state.pods[config.Pod]; found {
+					ctr.PodID = pod.ID
+					ctr.PodName = pod.Name
+				}
+			}
+
+			if state, found := s.state.states[ctr.ID]; found {
+				ctr.Status = state.Status()
+				ctr.ProcessID = state.PID
+				ctr.StartedAt = state.StartedTime
+				ctr.FinishedAt = state.FinishedTime
+				ctr.ExitCode = int(state.ExitCode)
+			}
+
+			ctr.ImageBase = imageBasename(ctr.Image)
+			ctr.SupportContainer = e.sc.IsSupportContainer(ctr)
+			cecontainers = append(cecontainers, ctr)
+		}
+	}
+	return cecontainers, nil
+}
+
+// ListImages returns the information about images.
+func (e *explorer) ListImages(ctx context.Context) ([]explorers.Image, error) {
+	var ceimages []explorers.Image
+	for _, s := range e.stores {
+		images, err := s.exp.ListImages(ctx)
+		if err != nil {
+			log.WithField("root", s.Root).Warn("listing images: ", err)
+			continue
+		}
+		for _, image := range images {
+			image.Namespace = s.Namespace
+			ceimages = append(ceimages, image)
+		}
+	}
+	return ceimages, nil
+}
+
+// ListContent returns information about content.
+//
+// Containers storage does not keep a content store.
+func (e *explorer) ListContent(ctx context.Context) ([]explorers.Content, error) {
+	log.Info("listing content is not supported for Podman")
+	return nil, nil
+}
+
+// ListSnapshots returns the layers as snapshots.
+func (e *explorer) ListSnapshots(ctx context.Context) ([]explorers.SnapshotKeyInfo, error) {
+	var ss []explorers.SnapshotKeyInfo
+	for _, s := range e.stores {
+		results, err := s.exp.ListSnapshots(ctx)
+		if err != nil {
+			log.WithField("root", s.Root).Warn("listing snapshots: ", err)
+			continue
+		}
+		for _, r := range results {
+			r.Namespace = s.Namespace
+			ss = append(ss, r)
+		}
+	}
+	return ss, nil
+}
+
+// ListTasks returns the container task status.
+func (e *explorer) ListTasks(ctx context.Context) ([]explorers.Task, error) {
+	ctrs, err := e.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []explorers.Task
+	for _, ctr := range ctrs {
+		tasks = append(tasks, explorers.Task{
+			Namespace:     ctr.Namespace,
+			Name:          ctr.ID,
+			PID:           ctr.ProcessID,
+			ContainerType: ctr.ContainerType,
+			Status:        ctr.Status,
+		})
+	}
+	return tasks, nil
+}
+
+// InspectImage returns the configuration and layers of an image.
+//
+// The image is returned from the first store containing the image.
+func (e *explorer) InspectImage(ctx context.Context, name string) (explorers.ImageDetail, error) {
+	for _, s := range e.stores {
+		detail, err := s.exp.InspectImage(ctx, name)
+		if err != nil {
+			log.WithField("root", s.Root).Debug("inspecting image: ", err)
+			continue
+		}
+		detail.Namespace = s.Namespace
+		return detail, nil
+	}
+	return explorers.ImageDetail{}, fmt.Errorf("image %s not found", name)
+}
+
+// ListLeases returns the leases.
+//
+// Containers storage does not keep leases.
+func (e *explorer) ListLeases(ctx context.Context) ([]explorers.Lease, error) {
+	log.Info("listing leases is not supported for Podman")
+	return nil, nil
+}
+
+// InfoContainer returns container internal information.
+//
+// The libpod container configuration is returned if available.
+func (e *explorer) InfoContainer(ctx context.Context, containerid string, spec bool) (interface{}, error) {
+	for _, s := range e.stores {
+		config, found := s.state.configs[containerid]
+		if !found {
+			continue
+		}
+
+		// Only return spec
+		if spec {
+			return config.Spec, nil
+		}
+
+		// Return libpod configuration and state
+		return struct {
+			Config ContainerConfig
+			State  ContainerState
+		}{
+			Config: config,
+			State:  s.state.states[containerid],
+		}, nil
+	}
+
+	for _, s := range e.stores {
+		if info, err := s.exp.InfoContainer(ctx, containerid, spec); err == nil {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("container %s does not exist", containerid)
+}
+
+// ContainerLayers returns the container's upper layer directory and the
+// lower layer directories ordered from top to bottom.
+//
+// The container is looked up in each store.
+func (e *explorer) ContainerLayers(ctx context.Context, containerid string) (string, []string, error) {
+	for _, s := range e.stores {
+		upperdir, lowerdirs, err := s.exp.ContainerLayers(ctx, containerid)
+		if err == nil {
+			return upperdir, lowerdirs, nil
+		}
+	}
+	return "", nil, fmt.Errorf("container %s does not exist", containerid)
+}
+
+// MountContainer mounts a container to the specified path.
+func (e *explorer) MountContainer(ctx context.Context, containerid string, mountpoint string) error {
+	upperdir, lowerdirs, err := e.ContainerLayers(ctx, containerid)
+	if err != nil {
+		return err
+	}
+
+	return explorers.MountOverlay(append([]string{upperdir}, lowerdirs...), mountpoint)
+}
+
+// MountAllContainers mounts all containers to the specified path.
+func (e *explorer) MountAllContainers(ctx context.Context, mountpoint string, opts explorers.MountAllOptions) ([]explorers.MountIndexEntry, error) {
+	ctrs, err := e.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := explorers.NewMountBatch(mountpoint, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ctr := range ctrs {
+		if opts.SkipSupportContainers && ctr.SupportContainer {
+			log.WithField("containerid", ctr.ID).Info("skip mounting support container")
+			batch.Skip(ctr, "Kubernetes support container")
+			continue
+		}
+
+		// pod infra containers do not have a useful filesystem
+		if ctr.ContainerType == "sandbox" {
+			log.WithField("containerid", ctr.ID).Debug("skip mounting pod infra container")
+			batch.Skip(ctr, "pod infra container")
+			continue
+		}
+
+		batch.Mount(ctr, func(ctrmountpoint string) error {
+			return e.MountContainer(ctx, ctr.ID, ctrmountpoint)
+		})
+	}
+
+	return batch.Close()
+}
+
+// Close releases the internal resources.
+func (e *explorer) Close() error {
+	for _, s := range e.stores {
+		s.exp.Close()
+	}
+	return nil
+}
+
+// imageBasename returns the image base name without version information to
+// match with supportcontainer.yaml configuration.
+func imageBasename(image string) string {
+	imagebase := image
+
+	if strings.Contains(imagebase, "@") {
+		imagebase = strings.Split(imagebase, "@")[0]
+	}
+
+	if strings.Contains(imagebase, ":") {
+		imagebase = strings.Split(imagebase, ":")[0]
+	}
+	return imagebase
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import "context"
+
+// StoragePool describes a storage pool of a system container manager i.e.
+// LXD.
+type StoragePool struct {
+	Name      string `json:"name"`
+	Driver    string `json:"driver"`
+	Source    string `json:"source,omitempty"` // block device, dataset, or directory backing the pool
+	Path      string `json:"path"`             // pool directory within the image root
+	Instances int    `json:"instances"`
+	Images    int    `json:"images"`
+}
+
+// StoragePoolLister is implemented by the explorers of the runtimes keeping
+// the containers in storage pools.
+type StoragePoolLister interface {
+	// ListStoragePools returns the storage pools.
+	ListStoragePools(ctx context.Context) ([]StoragePool, error)
+}
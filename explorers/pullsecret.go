@@ -0,0 +1,580 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/reference/docker"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Kubernetes image pull policies.
+const (
+	PullAlways       = "Always"
+	PullIfNotPresent = "IfNotPresent"
+	PullNever        = "Never"
+)
+
+// Types of the registry credentials configured on a node.
+const (
+	CredentialAuth          = "auth"
+	CredentialIdentityToken = "identitytoken"
+	CredentialHelper        = "credential-helper"
+	CredentialStore         = "credential-store"
+	CredentialProvider      = "credential-provider"
+	CredentialHeader        = "authorization-header"
+)
+
+const (
+	// annotationLastApplied holds the object applied using kubectl apply.
+	annotationLastApplied = "kubectl.kubernetes.io/last-applied-configuration"
+
+	// extensionCRISandbox is the containerd container extension holding the
+	// CRI sandbox metadata.
+	extensionCRISandbox = "io.cri-containerd.sandbox.metadata"
+)
+
+// dockerConfigFiles are the docker configuration files read by kubelet,
+// containers/image, and docker for the registry credentials. The kubelet
+// root directory is searched as well.
+var dockerConfigFiles = []string{
+	"/.docker/config.json",
+	"/.dockercfg",
+	"/root/.docker/config.json",
+	"/root/.dockercfg",
+	"/etc/containers/auth.json",
+	"/root/.config/containers/auth.json",
+}
+
+// staticPodDirs are the kubelet static pod manifest directories of
+// kubeadm, RKE2, and the managed Kubernetes distributions.
+var staticPodDirs = []string{
+	"/etc/kubernetes/manifests",
+	"/var/lib/rancher/rke2/agent/pod-manifests",
+	"/var/lib/rancher/k3s/agent/pod-manifests",
+}
+
+// credentialProviderConfigs are the kubelet image credential provider
+// configuration files of the managed Kubernetes distributions.
+var credentialProviderConfigs = []string{
+	"/etc/eks/image-credential-provider/config.json",
+	"/etc/srv/kubernetes/cri_auth_config.yaml",
+	"/etc/kubernetes/*credential-provider*.yaml",
+	"/etc/kubernetes/*credential-provider*.json",
+	"/var/lib/kubelet/*credential-provider*.yaml",
+}
+
+// RegistryCredential is a registry credential configured on a node. The
+// credential is available to every pod pulling from the registry on the
+// node. The secret itself is not read.
+type RegistryCredential struct {
+	Source   string `json:"source"`   // host path of the configuration file
+	Registry string `json:"registry"` // registry host, a wildcard pattern, or * for all registries
+	Type     string `json:"type"`
+	Helper   string `json:"helper,omitempty"` // credential helper or provider name
+}
+
+// Matches returns true if the credential is used to pull the image.
+func (c RegistryCredential) Matches(image string) bool {
+	if c.Registry == "*" {
+		return true
+	}
+	return matchRegistry(c.Registry, ImageRegistry(image))
+}
+
+// Wildcard returns true if the credential is used for more than one
+// registry host.
+func (c RegistryCredential) Wildcard() bool {
+	return strings.Contains(c.Registry, "*")
+}
+
+// PodPullSpec holds the image pull configuration of a pod.
+type PodPullSpec struct {
+	Namespace      string          `json:"namespace"`
+	Name           string          `json:"name"`
+	UID            string          `json:"uid,omitempty"`
+	Node           string          `json:"node,omitempty"`
+	ServiceAccount string          `json:"service_account,omitempty"`
+	PullSecrets    []string        `json:"image_pull_secrets,omitempty"`
+	Containers     []PullContainer `json:"containers"`
+	Source         string          `json:"source"` // etcd, static pod manifest, last-applied annotation, or runtime labels
+}
+
+// PullContainer holds the image and image pull policy of a pod container.
+type PullContainer struct {
+	Name       string `json:"name"`
+	Image      string `json:"image"`
+	PullPolicy string `json:"image_pull_policy,omitempty"`
+}
+
+// ImageRegistry returns the registry host of an image i.e. docker.io for
+// nginx:latest.
+func ImageRegistry(image string) string {
+	named, err := docker.ParseDockerRef(image)
+	if err != nil {
+		return ""
+	}
+	return docker.Domain(named)
+}
+
+// DefaultPullPolicy returns the image pull policy set by the API server
+// when the pod does not specify it. The policy is Always for an image
+// without a tag or with the latest tag, and IfNotPresent otherwise.
+func DefaultPullPolicy(image string) string {
+	if strings.Contains(image, "@") {
+		return PullIfNotPresent
+	}
+	slash := strings.LastIndex(image, "/")
+	colon := strings.LastIndex(image, ":")
+	if colon <= slash || image[colon+1:] == "latest" {
+		return PullAlways
+	}
+	return PullIfNotPresent
+}
+
+// ParsePodManifest returns the image pull configuration of a pod manifest
+// in YAML or JSON i.e. a static pod manifest.
+func ParsePodManifest(data []byte) (PodPullSpec, bool) {
+	type container struct {
+		Name            string `yaml:"name"`
+		Image           string `yaml:"image"`
+		ImagePullPolicy string `yaml:"imagePullPolicy"`
+	}
+	var manifest struct {
+		Kind     string `yaml:"kind"`
+		Metadata struct {
+			Name      string `yaml:"name"`
+			Namespace string `yaml:"namespace"`
+			UID       string `yaml:"uid"`
+		} `yaml:"metadata"`
+		Spec struct {
+			NodeName           string `yaml:"nodeName"`
+			ServiceAccountName string `yaml:"serviceAccountName"`
+			ImagePullSecrets   []struct {
+				Name string `yaml:"name"`
+			} `yaml:"imagePullSecrets"`
+			InitContainers []container `yaml:"initContainers"`
+			Containers     []container `yaml:"containers"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(data, &manifest); err != nil || manifest.Kind != "Pod" {
+		return PodPullSpec{}, false
+	}
+
+	spec := PodPullSpec{
+		Namespace:      manifest.Metadata.Namespace,
+		Name:           manifest.Metadata.Name,
+		UID:            manifest.Metadata.UID,
+		Node:           manifest.Spec.NodeName,
+		ServiceAccount: manifest.Spec.ServiceAccountName,
+		Containers:     []PullContainer{},
+	}
+	if spec.Namespace == "" {
+		spec.Namespace = "default"
+	}
+	for _, s := range manifest.Spec.ImagePullSecrets {
+		if s.Name != "" {
+			spec.PullSecrets = append(spec.PullSecrets, s.Name)
+		}
+	}
+	for _, c := range append(manifest.Spec.InitContainers, manifest.Spec.Containers...) {
+		spec.Containers = append(spec.Containers, PullContainer{
+			Name:       c.Name,
+			Image:      c.Image,
+			PullPolicy: c.ImagePullPolicy,
+		})
+	}
+	return spec, true
+}
+
+// StaticPods returns the image pull configuration of the kubelet static
+// pods.
+func StaticPods(imageroot string) []PodPullSpec {
+	var pods []PodPullSpec
+	for _, dir := range staticPodDirs {
+		entries, err := os.ReadDir(filepath.Join(imageroot, dir))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(imageroot, dir, e.Name()))
+			if err != nil {
+				log.WithField("path", path.Join(dir, e.Name())).Debug("reading static pod manifest: ", err)
+				continue
+			}
+			if spec, ok := ParsePodManifest(data); ok {
+				spec.Source = "static pod manifest"
+				pods = append(pods, spec)
+			}
+		}
+	}
+	return pods
+}
+
+// NodePods returns the image pull configuration of the pods of the
+// node-local containers.
+//
+// The pod manifest is read from the kubectl last-applied-configuration
+// annotation of the sandbox. Otherwise, the pod containers and images are
+// read from the container labels and the image pull policy is unknown.
+func NodePods(ctrs []Container) []PodPullSpec {
+	var (
+		pods  []PodPullSpec
+		index = make(map[string]int)
+	)
+	for _, ctr := range ctrs {
+		uid := podID(ctr)
+		if uid == "" || ctr.Labels[LabelPodName] == "" {
+			continue
+		}
+		i, found := index[uid]
+		if !found {
+			i = len(pods)
+			index[uid] = i
+			pods = append(pods, PodPullSpec{
+				Namespace:  ctr.Labels[LabelPodNamespace],
+				Name:       ctr.Labels[LabelPodName],
+				UID:        uid,
+				Containers: []PullContainer{},
+				Source:     "runtime labels",
+			})
+		}
+
+		if isSandbox(ctr) {
+			manifest := LastAppliedPodManifest(ctr)
+			if manifest == nil {
+				continue
+			}
+			if spec, ok := ParsePodManifest(manifest); ok {
+				spec.UID = uid
+				spec.Source = "last-applied annotation"
+				pods[i] = spec
+			}
+			continue
+		}
+		if pods[i].Source != "runtime labels" || hasPullContainer(pods[i], ctr.Labels[LabelContainerName]) {
+			continue
+		}
+		pods[i].Containers = append(pods[i].Containers, PullContainer{
+			Name:  ctr.Labels[LabelContainerName],
+			Image: ctr.Image,
+		})
+	}
+	return pods
+}
+
+// hasPullContainer returns true if the pod has a container with the name.
+// The restarted containers have the same name.
+func hasPullContainer(pod PodPullSpec, name string) bool {
+	for _, c := range pod.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// LastAppliedPodManifest returns the pod manifest of the kubectl
+// last-applied-configuration annotation of a containerd CRI sandbox or nil.
+//
+// The pod annotations are passed to the sandbox. A pod created using
+// kubectl apply, not by a controller, has the annotation.
+func LastAppliedPodManifest(ctr Container) []byte {
+	ext, found := ctr.Extensions[extensionCRISandbox]
+	if !found {
+		return nil
+	}
+
+	var metadata struct {
+		Metadata struct {
+			Config struct {
+				Annotations map[string]string `json:"annotations"`
+			} `json:"Config"`
+		} `json:"Metadata"`
+	}
+	if err := json.Unmarshal(ext.Value, &metadata); err != nil {
+		log.WithField("containerid", ctr.ID).Debug("unmarshalling CRI sandbox metadata: ", err)
+		return nil
+	}
+	if manifest := metadata.Metadata.Config.Annotations[annotationLastApplied]; manifest != "" {
+		return []byte(manifest)
+	}
+	return nil
+}
+
+// NodeRegistryCredentials returns the registry credentials configured on a
+// node for kubelet and the container runtime i.e. the docker configuration
+// files, the containerd registry configuration, and the kubelet image
+// credential providers.
+func NodeRegistryCredentials(imageroot string, kubeletroot string) []RegistryCredential {
+	var creds []RegistryCredential
+
+	files := append([]string{
+		path.Join(kubeletroot, "config.json"),
+		path.Join(kubeletroot, ".dockercfg"),
+	}, dockerConfigFiles...)
+	for _, f := range files {
+		creds = append(creds, readDockerConfigCredentials(imageroot, f)...)
+	}
+
+	creds = append(creds, readContainerdCredentials(imageroot)...)
+
+	for _, pattern := range credentialProviderConfigs {
+		matches, _ := filepath.Glob(filepath.Join(imageroot, pattern))
+		for _, m := range matches {
+			creds = append(creds, readCredentialProviders(imageroot, m)...)
+		}
+	}
+	return creds
+}
+
+// readDockerConfigCredentials returns the credentials of a docker
+// config.json or a legacy .dockercfg file.
+func readDockerConfigCredentials(imageroot string, hostpath string) []RegistryCredential {
+	data, err := os.ReadFile(filepath.Join(imageroot, hostpath))
+	if err != nil {
+		return nil
+	}
+
+	type auth struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		IdentityToken string `json:"identitytoken"`
+	}
+	var config struct {
+		Auths       map[string]auth   `json:"auths"`
+		CredHelpers map[string]string `json:"credHelpers"`
+		CredsStore  string            `json:"credsStore"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		log.WithField("path", hostpath).Debug("unmarshalling docker configuration: ", err)
+		return nil
+	}
+
+	// The legacy .dockercfg format is the map of the auths.
+	if config.Auths == nil && config.CredHelpers == nil && config.CredsStore == "" {
+		json.Unmarshal(data, &config.Auths)
+	}
+
+	var creds []RegistryCredential
+	for registry, a := range config.Auths {
+		if a.Auth == "" && a.Username == "" && a.IdentityToken == "" {
+			continue
+		}
+		cred := RegistryCredential{
+			Source:   hostpath,
+			Registry: normalizeRegistry(registry),
+			Type:     CredentialAuth,
+		}
+		if a.IdentityToken != "" {
+			cred.Type = CredentialIdentityToken
+		}
+		creds = append(creds, cred)
+	}
+	for registry, helper := range config.CredHelpers {
+		creds = append(creds, RegistryCredential{
+			Source:   hostpath,
+			Registry: normalizeRegistry(registry),
+			Type:     CredentialHelper,
+			Helper:   helper,
+		})
+	}
+	if config.CredsStore != "" {
+		creds = append(creds, RegistryCredential{
+			Source:   hostpath,
+			Registry: "*",
+			Type:     CredentialStore,
+			Helper:   config.CredsStore,
+		})
+	}
+	sort.Slice(creds, func(i, j int) bool {
+		return creds[i].Registry < creds[j].Registry
+	})
+	return creds
+}
+
+// readContainerdCredentials returns the credentials of the CRI registry
+// configuration in /etc/containerd/config.toml and the Authorization
+// headers of the registry host configuration in /etc/containerd/certs.d.
+func readContainerdCredentials(imageroot string) []RegistryCredential {
+	var creds []RegistryCredential
+
+	configfile := ContainerdConfigPath(imageroot)
+	if data, err := os.ReadFile(configfile); err == nil {
+		rel, _ := filepath.Rel(imageroot, configfile)
+		source := "/" + filepath.ToSlash(rel)
+
+		// [plugins."io.containerd.grpc.v1.cri".registry.configs."gcr.io".auth]
+		tomlSections(data, func(section string, key string) {
+			if !strings.HasSuffix(section, ".auth") || !strings.Contains(section, "registry.configs.") {
+				return
+			}
+			registry := strings.TrimSuffix(section[strings.Index(section, "registry.configs.")+len("registry.configs."):], ".auth")
+			registry, _ = strconv.Unquote(registry)
+			if registry == "" {
+				return
+			}
+			typ := ""
+			switch key {
+			case "auth", "username", "password":
+				typ = CredentialAuth
+			case "identitytoken":
+				typ = CredentialIdentityToken
+			default:
+				return
+			}
+			for _, c := range creds {
+				if c.Registry == registry {
+					return
+				}
+			}
+			creds = append(creds, RegistryCredential{
+				Source:   source,
+				Registry: normalizeRegistry(registry),
+				Type:     typ,
+			})
+		})
+	}
+
+	hostsfiles, _ := filepath.Glob(filepath.Join(imageroot, "etc", "containerd", "certs.d", "*", "hosts.toml"))
+	for _, f := range hostsfiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		found := false
+		tomlSections(data, func(section string, key string) {
+			if strings.HasSuffix(section, "header") && strings.EqualFold(key, "authorization") {
+				found = true
+			}
+		})
+		if found {
+			rel, _ := filepath.Rel(imageroot, f)
+			creds = append(creds, RegistryCredential{
+				Source:   "/" + filepath.ToSlash(rel),
+				Registry: normalizeRegistry(filepath.Base(filepath.Dir(f))),
+				Type:     CredentialHeader,
+			})
+		}
+	}
+	return creds
+}
+
+// readCredentialProviders returns the images matched by the kubelet image
+// credential providers. A provider fetches the credentials on demand i.e.
+// from the cloud instance metadata.
+func readCredentialProviders(imageroot string, path string) []RegistryCredential {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var config struct {
+		Kind      string `yaml:"kind"`
+		Providers []struct {
+			Name        string   `yaml:"name"`
+			MatchImages []string `yaml:"matchImages"`
+		} `yaml:"providers"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil || config.Kind != "CredentialProviderConfig" {
+		return nil
+	}
+
+	rel, _ := filepath.Rel(imageroot, path)
+	var creds []RegistryCredential
+	for _, p := range config.Providers {
+		for _, m := range p.MatchImages {
+			creds = append(creds, RegistryCredential{
+				Source:   "/" + filepath.ToSlash(rel),
+				Registry: normalizeRegistry(m),
+				Type:     CredentialProvider,
+				Helper:   p.Name,
+			})
+		}
+	}
+	return creds
+}
+
+// tomlSections calls fn with the section and key of each key-value line of
+// a TOML document.
+func tomlSections(data []byte, fn func(section string, key string)) {
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[] ")
+			continue
+		}
+		if i := strings.Index(line, "="); i > 0 {
+			fn(section, strings.Trim(strings.TrimSpace(line[:i]), `"`))
+		}
+	}
+}
+
+// normalizeRegistry returns the registry host of a credential key i.e.
+// docker.io for https://index.docker.io/v1/.
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimSpace(registry)
+	if u, err := url.Parse(registry); err == nil && u.Host != "" {
+		registry = u.Host
+	}
+	if i := strings.Index(registry, "/"); i >= 0 {
+		registry = registry[:i]
+	}
+	switch registry {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return strings.ToLower(registry)
+}
+
+// matchRegistry returns true if the registry host matches the pattern.
+// A * matches one domain name component i.e. *.dkr.ecr.*.amazonaws.com.
+func matchRegistry(pattern string, registry string) bool {
+	if pattern == registry {
+		return true
+	}
+	pp := strings.Split(pattern, ".")
+	rp := strings.Split(registry, ".")
+	if len(pp) != len(rp) {
+		return false
+	}
+	for i := range pp {
+		if matched, _ := path.Match(pp[i], rp[i]); !matched {
+			return false
+		}
+	}
+	return true
+}
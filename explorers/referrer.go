@@ -0,0 +1,341 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Referrer kinds.
+const (
+	ReferrerSBOM          = "sbom"
+	ReferrerVulnerability = "vulnerability"
+	ReferrerProvenance    = "provenance"
+	ReferrerOther         = "other"
+)
+
+// Referrer sources.
+const (
+	// ReferrerSourceSubject is an artifact manifest with a subject i.e.
+	// pulled using the OCI referrers API.
+	ReferrerSourceSubject = "referrers"
+
+	// ReferrerSourceAttestation is a Docker BuildKit attestation manifest in
+	// the image index.
+	ReferrerSourceAttestation = "attestation-manifest"
+
+	// ReferrerSourceCosign is a cosign tag i.e. sha256-<digest>.att.
+	ReferrerSourceCosign = "cosign-tag"
+)
+
+// Media types of the referrer documents.
+const (
+	mediaTypeInToto = "application/vnd.in-toto+json"
+	mediaTypeDSSE   = "application/vnd.dsse.envelope.v1+json"
+)
+
+// Referrer is a document attached to an image manifest i.e. an SBOM or a
+// vulnerability attestation.
+type Referrer struct {
+	Subject       string `json:"subject"`  // digest of the image manifest or index
+	Manifest      string `json:"manifest"` // digest of the referrer manifest
+	Digest        string `json:"digest"`   // digest of the document
+	ArtifactType  string `json:"artifact_type,omitempty"`
+	MediaType     string `json:"media_type"`
+	PredicateType string `json:"predicate_type,omitempty"`
+	Kind          string `json:"kind"`
+	Attested      bool   `json:"attested"` // in-toto attestation
+	Source        string `json:"source"`
+	Path          string `json:"path"`
+}
+
+// ReferrerExplorer is implemented by the explorers keeping the referrers of
+// the images i.e. containerd caches the referrers in the content store.
+type ReferrerExplorer interface {
+	// ImageReferrers returns the referrers of an image in the namespace of
+	// the context.
+	ImageReferrers(ctx context.Context, name string) ([]Referrer, error)
+}
+
+// ReferrerDocument holds the decoded document of a referrer.
+type ReferrerDocument struct {
+	PredicateType string
+	Format        string // spdx, cyclonedx, or the predicate type
+	Packages      []Package
+	Scanner       string // vulnerability scanner
+}
+
+// NewReferrer returns a referrer of a document layer. The kind is computed
+// from the artifact type, the media type, and the predicate type.
+func NewReferrer(subject string, manifest string, artifacttype string, layermediatype string, layerdigest string, predicatetype string) Referrer {
+	r := Referrer{
+		Subject:       subject,
+		Manifest:      manifest,
+		Digest:        layerdigest,
+		ArtifactType:  artifacttype,
+		MediaType:     layermediatype,
+		PredicateType: predicatetype,
+		Attested:      layermediatype == mediaTypeInToto || layermediatype == mediaTypeDSSE || strings.Contains(artifacttype, "in-toto"),
+	}
+	r.Kind = referrerKind(strings.Join([]string{artifacttype, layermediatype, predicatetype}, " "))
+	return r
+}
+
+// referrerKind returns the kind of a referrer from the artifact type, media
+// type, or predicate type.
+func referrerKind(types string) string {
+	types = strings.ToLower(types)
+	switch {
+	case strings.Contains(types, "spdx"), strings.Contains(types, "cyclonedx"):
+		return ReferrerSBOM
+	case strings.Contains(types, "vuln"), strings.Contains(types, "openvex"), strings.Contains(types, "sarif"):
+		return ReferrerVulnerability
+	case strings.Contains(types, "slsa"), strings.Contains(types, "provenance"):
+		return ReferrerProvenance
+	}
+	return ReferrerOther
+}
+
+// inTotoStatement is an in-toto attestation statement.
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// dsseEnvelope is a DSSE envelope signing an in-toto statement.
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+}
+
+// spdxDocument holds the packages of an SPDX JSON document.
+type spdxDocument struct {
+	SPDXVersion string `json:"spdxVersion"`
+	Packages    []struct {
+		Name             string `json:"name"`
+		VersionInfo      string `json:"versionInfo"`
+		LicenseConcluded string `json:"licenseConcluded"`
+		LicenseDeclared  string `json:"licenseDeclared"`
+		ExternalRefs     []struct {
+			ReferenceType    string `json:"referenceType"`
+			ReferenceLocator string `json:"referenceLocator"`
+		} `json:"externalRefs"`
+	} `json:"packages"`
+}
+
+// cyclonedxDocument holds the components of a CycloneDX JSON document.
+type cyclonedxDocument struct {
+	BOMFormat  string `json:"bomFormat"`
+	Components []struct {
+		Name     string `json:"name"`
+		Version  string `json:"version"`
+		Purl     string `json:"purl"`
+		Licenses []struct {
+			License struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"license"`
+			Expression string `json:"expression"`
+		} `json:"licenses"`
+	} `json:"components"`
+}
+
+// vulnerabilityPredicate holds the scanner of a cosign vulnerability
+// attestation.
+type vulnerabilityPredicate struct {
+	Scanner struct {
+		URI     string `json:"uri"`
+		Version string `json:"version"`
+	} `json:"scanner"`
+}
+
+// ReadReferrerDocument decodes the document of a referrer.
+//
+// The in-toto statements are unwrapped from the DSSE envelopes. The packages
+// of the SPDX and CycloneDX documents with a package URL are returned.
+func ReadReferrerDocument(r Referrer) (ReferrerDocument, error) {
+	var doc ReferrerDocument
+
+	data, err := os.ReadFile(r.Path)
+	if err != nil {
+		return doc, err
+	}
+
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(data, &envelope); err == nil && envelope.Payload != "" {
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			return doc, fmt.Errorf("decoding DSSE payload: %w", err)
+		}
+		data = payload
+	}
+
+	var statement inTotoStatement
+	if err := json.Unmarshal(data, &statement); err == nil && statement.PredicateType != "" {
+		doc.PredicateType = statement.PredicateType
+		data = statement.Predicate
+	}
+
+	var spdx spdxDocument
+	var cdx cyclonedxDocument
+	var vuln vulnerabilityPredicate
+	switch {
+	case json.Unmarshal(data, &spdx) == nil && spdx.SPDXVersion != "":
+		doc.Format = "spdx"
+		for _, p := range spdx.Packages {
+			for _, ref := range p.ExternalRefs {
+				if ref.ReferenceType != "purl" {
+					continue
+				}
+				license := p.LicenseDeclared
+				if license == "" || license == "NOASSERTION" {
+					license = p.LicenseConcluded
+				}
+				doc.Packages = append(doc.Packages, Package{
+					Name:     p.Name,
+					Version:  p.VersionInfo,
+					Type:     purlType(ref.ReferenceLocator),
+					Licenses: splitLicenses(license),
+				})
+				break
+			}
+		}
+	case json.Unmarshal(data, &cdx) == nil && strings.EqualFold(cdx.BOMFormat, "CycloneDX"):
+		doc.Format = "cyclonedx"
+		for _, c := range cdx.Components {
+			if c.Purl == "" {
+				continue
+			}
+			var licenses []string
+			for _, l := range c.Licenses {
+				switch {
+				case l.Expression != "":
+					licenses = append(licenses, splitLicenses(l.Expression)...)
+				case l.License.ID != "":
+					licenses = append(licenses, l.License.ID)
+				case l.License.Name != "":
+					licenses = append(licenses, l.License.Name)
+				}
+			}
+			doc.Packages = append(doc.Packages, Package{
+				Name:     c.Name,
+				Version:  c.Version,
+				Type:     purlType(c.Purl),
+				Licenses: licenses,
+			})
+		}
+	case json.Unmarshal(data, &vuln) == nil && vuln.Scanner.URI != "":
+		doc.Format = doc.PredicateType
+		doc.Scanner = strings.TrimSuffix(vuln.Scanner.URI+"@"+vuln.Scanner.Version, "@")
+	default:
+		doc.Format = doc.PredicateType
+	}
+
+	for i := range doc.Packages {
+		doc.Packages[i].Path = r.Digest
+		doc.Packages[i].Category = LicenseCategory(doc.Packages[i].Licenses)
+	}
+	return doc, nil
+}
+
+// purlType returns the package type of a package URL i.e. pkg:deb/debian/bash.
+// The types of the packages found in a container filesystem are mapped to
+// the package types of ListPackages.
+func purlType(purl string) string {
+	t := strings.TrimPrefix(purl, "pkg:")
+	if i := strings.Index(t, "/"); i > 0 {
+		t = t[:i]
+	}
+	switch t {
+	case "pypi":
+		return PackageTypePython
+	case "apk", "deb", "npm":
+		return t
+	}
+	return t
+}
+
+// SBOMDiscrepancy is a difference between the packages of an SBOM and the
+// packages found in the container filesystem.
+type SBOMDiscrepancy struct {
+	Type              string `json:"type"`
+	Name              string `json:"name"`
+	SBOMVersion       string `json:"sbom_version,omitempty"`
+	FilesystemVersion string `json:"filesystem_version,omitempty"`
+	Change            string `json:"change"`                    // missing, unlisted, or version
+	FilesystemPath    string `json:"filesystem_path,omitempty"` // package database or manifest path
+}
+
+// CompareSBOM compares the packages of an SBOM with the packages found in the
+// container filesystem.
+//
+// A missing package is listed in the SBOM only and an unlisted package is
+// found in the filesystem only. Only the package types read by ListPackages
+// are compared.
+func CompareSBOM(sbom []Package, filesystem []Package) []SBOMDiscrepancy {
+	supported := map[string]bool{
+		PackageTypeAPK:    true,
+		PackageTypeDEB:    true,
+		PackageTypePython: true,
+		PackageTypeNPM:    true,
+	}
+	key := func(p Package) string {
+		return p.Type + "/" + strings.ToLower(p.Name)
+	}
+
+	listed := make(map[string]Package)
+	for _, p := range sbom {
+		if supported[p.Type] {
+			listed[key(p)] = p
+		}
+	}
+	found := make(map[string]Package)
+	for _, p := range filesystem {
+		found[key(p)] = p
+	}
+
+	var discrepancies []SBOMDiscrepancy
+	for k, p := range listed {
+		f, ok := found[k]
+		switch {
+		case !ok:
+			discrepancies = append(discrepancies, SBOMDiscrepancy{Type: p.Type, Name: p.Name, SBOMVersion: p.Version, Change: "missing"})
+		case f.Version != p.Version:
+			discrepancies = append(discrepancies, SBOMDiscrepancy{Type: p.Type, Name: p.Name, SBOMVersion: p.Version, FilesystemVersion: f.Version, Change: "version", FilesystemPath: f.Path})
+		}
+	}
+	for k, f := range found {
+		if _, ok := listed[k]; !ok {
+			discrepancies = append(discrepancies, SBOMDiscrepancy{Type: f.Type, Name: f.Name, FilesystemVersion: f.Version, Change: "unlisted", FilesystemPath: f.Path})
+		}
+	}
+
+	sort.Slice(discrepancies, func(i, j int) bool {
+		if discrepancies[i].Type != discrepancies[j].Type {
+			return discrepancies[i].Type < discrepancies[j].Type
+		}
+		return discrepancies[i].Name < discrepancies[j].Name
+	})
+	return discrepancies
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// extensionCRIContainer is the containerd container extension holding
+	// the CRI container metadata.
+	extensionCRIContainer = "io.cri-containerd.container.metadata"
+
+	// dockershimAnnotationPrefix is the prefix of the container annotations
+	// stored as docker labels by dockershim and cri-dockerd.
+	dockershimAnnotationPrefix = "annotation."
+)
+
+// RestartGroup is a logical Kubernetes container i.e. the container records
+// of the restart attempts of a container in a pod.
+type RestartGroup struct {
+	Namespace     string      `json:"namespace,omitempty"`
+	PodNamespace  string      `json:"pod_namespace,omitempty"`
+	PodName       string      `json:"pod_name,omitempty"`
+	ContainerName string      `json:"container_name,omitempty"`
+	Restarts      int         `json:"restarts"` // highest restart attempt or the number of prior records
+	Latest        Container   `json:"latest"`
+	Attempts      []Container `json:"attempts,omitempty"` // prior attempts, latest first
+}
+
+// ContainerAttempt returns the restart attempt of a container or -1 if it is
+// unknown.
+//
+// The attempt is read from the restart count annotation of kubelet copied to
+// the labels, the annotation labels of dockershim, or the CRI container
+// metadata of containerd.
+func ContainerAttempt(ctr Container) int {
+	for _, label := range []string{LabelContainerRestartCount, dockershimAnnotationPrefix + LabelContainerRestartCount} {
+		if v, found := ctr.Labels[label]; found {
+			if attempt, err := strconv.Atoi(v); err == nil && attempt >= 0 {
+				return attempt
+			}
+		}
+	}
+
+	ext, found := ctr.Extensions[extensionCRIContainer]
+	if !found {
+		return -1
+	}
+	var metadata struct {
+		Metadata struct {
+			Config struct {
+				Metadata struct {
+					Attempt int `json:"attempt"`
+				} `json:"metadata"`
+			} `json:"Config"`
+		} `json:"Metadata"`
+	}
+	if err := json.Unmarshal(ext.Value, &metadata); err != nil {
+		log.WithField("containerid", ctr.ID).Debug("unmarshalling CRI container metadata: ", err)
+		return -1
+	}
+	return metadata.Metadata.Config.Metadata.Attempt
+}
+
+// CollapseRestarts groups the container records of the restart attempts of
+// the Kubernetes containers by namespace, pod, and container name.
+//
+// The latest attempt of a group is the attempt with the highest restart
+// count, or the last created record if the restart count is unknown. The
+// containers without pod and container name are returned in their own
+// group. The groups are returned in the order of their first record.
+func CollapseRestarts(ctrs []Container) []RestartGroup {
+	type groupKey struct {
+		namespace    string
+		podnamespace string
+		podname      string
+		name         string
+	}
+
+	var (
+		groups  []RestartGroup
+		indexes = make(map[groupKey]int)
+	)
+	for _, ctr := range ctrs {
+		key := groupKey{
+			namespace:    ctr.Namespace,
+			podnamespace: ctr.Labels[LabelPodNamespace],
+			podname:      ctr.Labels[LabelPodName],
+			name:         ctr.Labels[LabelContainerName],
+		}
+		if key.podname == "" || key.name == "" {
+			groups = append(groups, RestartGroup{
+				Namespace: ctr.Namespace,
+				Latest:    ctr,
+			})
+			continue
+		}
+
+		i, found := indexes[key]
+		if !found {
+			i = len(groups)
+			indexes[key] = i
+			groups = append(groups, RestartGroup{
+				Namespace:     key.namespace,
+				PodNamespace:  key.podnamespace,
+				PodName:       key.podname,
+				ContainerName: key.name,
+			})
+		}
+		groups[i].Attempts = append(groups[i].Attempts, ctr)
+	}
+
+	for i := range groups {
+		g := &groups[i]
+		if len(g.Attempts) == 0 {
+			continue
+		}
+
+		sort.SliceStable(g.Attempts, func(a, b int) bool {
+			aa, ab := ContainerAttempt(g.Attempts[a]), ContainerAttempt(g.Attempts[b])
+			if aa != ab {
+				return aa > ab
+			}
+			return g.Attempts[a].CreatedAt.After(g.Attempts[b].CreatedAt)
+		})
+		g.Latest = g.Attempts[0]
+		g.Attempts = g.Attempts[1:]
+
+		// Kubelet removes the records of the old attempts, so the restart
+		// count may exceed the number of records.
+		g.Restarts = len(g.Attempts)
+		if attempt := ContainerAttempt(g.Latest); attempt > g.Restarts {
+			g.Restarts = attempt
+		}
+	}
+	return groups
+}
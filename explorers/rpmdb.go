@@ -0,0 +1,330 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// rpm database files relative to the container root. Recent distributions
+// keep the database in /usr/lib/sysimage/rpm.
+var rpmDatabasePaths = []string{
+	"/var/lib/rpm/rpmdb.sqlite",
+	"/usr/lib/sysimage/rpm/rpmdb.sqlite",
+	"/var/lib/rpm/Packages",
+	"/usr/lib/sysimage/rpm/Packages",
+}
+
+// sqliteCommand is used to read the sqlite rpm database.
+const sqliteCommand = "sqlite3"
+
+// rpm header tags.
+const (
+	rpmTagName           = 1000
+	rpmTagVersion        = 1001
+	rpmTagRelease        = 1002
+	rpmTagFileDigests    = 1035
+	rpmTagFileFlags      = 1037
+	rpmTagDirIndexes     = 1116
+	rpmTagBasenames      = 1117
+	rpmTagDirNames       = 1118
+	rpmTagFileDigestAlgo = 5011
+)
+
+// rpm header value types.
+const (
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+)
+
+// rpmFileConfig is the file flag of a configuration file.
+const rpmFileConfig = 1
+
+// rpmDigestAlgorithms maps the rpm file digest algorithms to the hash names.
+var rpmDigestAlgorithms = map[int32]string{
+	1:  "md5",
+	2:  "sha1",
+	8:  "sha256",
+	9:  "sha384",
+	10: "sha512",
+}
+
+// rpmHeader is the decoded index and data store of an rpm header.
+type rpmHeader struct {
+	entries map[int32]rpmHeaderEntry
+	data    []byte
+}
+
+type rpmHeaderEntry struct {
+	typ    int32
+	offset int32
+	count  int32
+}
+
+// parseRPMHeader decodes an rpm header blob as stored in the rpm database.
+func parseRPMHeader(blob []byte) (rpmHeader, error) {
+	h := rpmHeader{entries: make(map[int32]rpmHeaderEntry)}
+	if len(blob) < 8 {
+		return h, fmt.Errorf("header too short")
+	}
+
+	nindex := binary.BigEndian.Uint32(blob[0:4])
+	hsize := binary.BigEndian.Uint32(blob[4:8])
+	start := 8 + uint64(nindex)*16
+	if start+uint64(hsize) > uint64(len(blob)) {
+		return h, fmt.Errorf("header size %d exceeds blob size %d", start+uint64(hsize), len(blob))
+	}
+
+	for i := uint64(0); i < uint64(nindex); i++ {
+		entry := blob[8+i*16 : 8+(i+1)*16]
+		h.entries[int32(binary.BigEndian.Uint32(entry[0:4]))] = rpmHeaderEntry{
+			typ:    int32(binary.BigEndian.Uint32(entry[4:8])),
+			offset: int32(binary.BigEndian.Uint32(entry[8:12])),
+			count:  int32(binary.BigEndian.Uint32(entry[12:16])),
+		}
+	}
+	h.data = blob[start : start+uint64(hsize)]
+	return h, nil
+}
+
+// strings returns the values of a string or string array tag.
+func (h rpmHeader) strings(tag int32) []string {
+	e, found := h.entries[tag]
+	if !found || e.offset < 0 || int(e.offset) >= len(h.data) {
+		return nil
+	}
+	switch e.typ {
+	case rpmTypeString, rpmTypeStringArray, rpmTypeI18NString:
+	default:
+		return nil
+	}
+
+	var values []string
+	data := h.data[e.offset:]
+	for i := int32(0); i < e.count; i++ {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			break
+		}
+		values = append(values, string(data[:end]))
+		data = data[end+1:]
+		if e.typ != rpmTypeStringArray {
+			break
+		}
+	}
+	return values
+}
+
+// string returns the value of a string tag.
+func (h rpmHeader) string(tag int32) string {
+	if values := h.strings(tag); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// int32s returns the values of an int32 tag.
+func (h rpmHeader) int32s(tag int32) []int32 {
+	e, found := h.entries[tag]
+	if !found || e.typ != rpmTypeInt32 || e.offset < 0 || int(e.offset)+int(e.count)*4 > len(h.data) {
+		return nil
+	}
+	values := make([]int32, e.count)
+	for i := range values {
+		values[i] = int32(binary.BigEndian.Uint32(h.data[int(e.offset)+i*4:]))
+	}
+	return values
+}
+
+// rpmPackageFiles returns the files of an rpm header with a recorded digest.
+func rpmPackageFiles(blob []byte) ([]PackageFile, error) {
+	h, err := parseRPMHeader(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := h.string(rpmTagName)
+	if version := h.string(rpmTagVersion); version != "" {
+		pkg = fmt.Sprintf("%s-%s-%s", pkg, version, h.string(rpmTagRelease))
+	}
+
+	algorithm := "md5"
+	if algos := h.int32s(rpmTagFileDigestAlgo); len(algos) > 0 {
+		algorithm = rpmDigestAlgorithms[algos[0]]
+	}
+
+	basenames := h.strings(rpmTagBasenames)
+	dirnames := h.strings(rpmTagDirNames)
+	dirindexes := h.int32s(rpmTagDirIndexes)
+	digests := h.strings(rpmTagFileDigests)
+	flags := h.int32s(rpmTagFileFlags)
+	if len(dirindexes) != len(basenames) || len(digests) != len(basenames) {
+		return nil, nil
+	}
+
+	var files []PackageFile
+	for i, basename := range basenames {
+		if digests[i] == "" || int(dirindexes[i]) >= len(dirnames) {
+			continue
+		}
+		f := PackageFile{
+			Package:   pkg,
+			Type:      PackageTypeRPM,
+			Path:      path.Join(dirnames[dirindexes[i]], basename),
+			Algorithm: algorithm,
+			Digest:    digests[i],
+		}
+		if i < len(flags) {
+			f.Config = flags[i]&rpmFileConfig != 0
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// readRPMHeaders returns the package header blobs of an rpm database.
+//
+// The sqlite database is read using the sqlite3 command. The Berkeley DB
+// hash database is read directly.
+func readRPMHeaders(diskpath string) ([][]byte, error) {
+	if strings.HasSuffix(diskpath, ".sqlite") {
+		return readRPMSqlite(diskpath)
+	}
+	return readRPMBerkeleyDB(diskpath)
+}
+
+// readRPMSqlite returns the package header blobs of an sqlite rpm database.
+func readRPMSqlite(diskpath string) ([][]byte, error) {
+	sqlite, err := exec.LookPath(sqliteCommand)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not installed: %w", sqliteCommand, err)
+	}
+
+	out, err := exec.Command(sqlite, "-readonly", diskpath, "SELECT hex(blob) FROM Packages").Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s: %w", sqliteCommand, err)
+	}
+
+	var blobs [][]byte
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		blob, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, nil
+}
+
+// Berkeley DB hash database constants.
+const (
+	bdbHashMagic     = 0x061561
+	bdbPageHash      = 13 // P_HASH page type
+	bdbPageOverflow  = 7  // P_OVERFLOW page type
+	bdbItemOffPage   = 3  // H_OFFPAGE item type
+	bdbPageHeaderLen = 26
+)
+
+// readRPMBerkeleyDB returns the package header blobs of a Berkeley DB hash
+// rpm database i.e. /var/lib/rpm/Packages.
+//
+// The headers are larger than a page and stored in overflow pages referenced
+// by the hash page items.
+func readRPMBerkeleyDB(diskpath string) ([][]byte, error) {
+	db, err := os.ReadFile(diskpath)
+	if err != nil {
+		return nil, err
+	}
+	if len(db) < 512 {
+		return nil, fmt.Errorf("database too short")
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(db[12:16]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(db[12:16]) != bdbHashMagic {
+			return nil, fmt.Errorf("not a Berkeley DB hash database")
+		}
+	}
+	pagesize := int(order.Uint32(db[20:24]))
+	if pagesize < 512 {
+		return nil, fmt.Errorf("invalid page size %d", pagesize)
+	}
+
+	page := func(pgno uint32) []byte {
+		start := int(pgno) * pagesize
+		if start+pagesize > len(db) {
+			return nil
+		}
+		return db[start : start+pagesize]
+	}
+
+	// overflow returns the data of an overflow page chain.
+	overflow := func(pgno uint32, size uint32) []byte {
+		var data []byte
+		for seen := 0; pgno != 0 && uint32(len(data)) < size && seen < len(db)/pagesize; seen++ {
+			p := page(pgno)
+			if p == nil || p[25] != bdbPageOverflow {
+				return nil
+			}
+			n := int(order.Uint16(p[22:24]))
+			if bdbPageHeaderLen+n > len(p) {
+				return nil
+			}
+			data = append(data, p[bdbPageHeaderLen:bdbPageHeaderLen+n]...)
+			pgno = order.Uint32(p[16:20])
+		}
+		return data
+	}
+
+	var blobs [][]byte
+	for pgno := uint32(1); int(pgno+1)*pagesize <= len(db); pgno++ {
+		p := page(pgno)
+		if p[25] != bdbPageHash {
+			continue
+		}
+		entries := int(order.Uint16(p[20:22]))
+		// The items alternate between keys and values.
+		for i := 1; i < entries; i += 2 {
+			idx := bdbPageHeaderLen + i*2
+			if idx+2 > len(p) {
+				break
+			}
+			offset := int(order.Uint16(p[idx : idx+2]))
+			if offset+12 > len(p) || p[offset] != bdbItemOffPage {
+				continue
+			}
+			size := order.Uint32(p[offset+8 : offset+12])
+			if blob := overflow(order.Uint32(p[offset+4:offset+8]), size); uint32(len(blob)) >= size {
+				blobs = append(blobs, blob[:size])
+			}
+		}
+	}
+	return blobs, nil
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// SafeReadOptions configures the evidence access mode for failing source
+// media.
+//
+// In safe read mode, a bolt database is copied sequentially to a temporary
+// directory before it is opened. The I/O errors are retried with backoff
+// and the blocks that remain unreadable are zero filled and recorded.
+type SafeReadOptions struct {
+	BlockSize  int           // sequential read size in bytes
+	SectorSize int           // read size used to narrow an unreadable block
+	Retries    int           // retries on I/O error
+	Backoff    time.Duration // initial backoff doubled after each retry
+	RateLimit  int64         // maximum bytes read per second. 0 is unlimited
+	TempDir    string        // directory containing the copies
+}
+
+// UnreadableExtent is a region of a file that could not be read.
+type UnreadableExtent struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Error  string `json:"error"`
+}
+
+var (
+	safeReadMu  sync.Mutex
+	safeRead    *SafeReadOptions
+	safeCopies  = make(map[string]string)
+	unreadables []UnreadableExtent
+)
+
+// EnableSafeRead enables the safe read mode used by OpenBolt.
+func EnableSafeRead(opts SafeReadOptions) {
+	safeReadMu.Lock()
+	defer safeReadMu.Unlock()
+
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = 64 * 1024
+	}
+	if opts.SectorSize <= 0 || opts.SectorSize > opts.BlockSize {
+		opts.SectorSize = 512
+	}
+	safeRead = &opts
+}
+
+// UnreadableExtents returns the unreadable regions recorded in safe read
+// mode.
+func UnreadableExtents() []UnreadableExtent {
+	safeReadMu.Lock()
+	defer safeReadMu.Unlock()
+
+	extents := make([]UnreadableExtent, len(unreadables))
+	copy(extents, unreadables)
+	return extents
+}
+
+// OpenBolt opens a bolt database read-only.
+//
+// In safe read mode, the database is opened from a copy made using
+// SafeCopyFile. The copy is reused when the database is opened again.
+func OpenBolt(path string, timeout time.Duration) (*bolt.DB, error) {
+	opt := &bolt.Options{
+		ReadOnly: true,
+		Timeout:  timeout,
+	}
+
+	safeReadMu.Lock()
+	opts := safeRead
+	dbpath, copied := safeCopies[path]
+	safeReadMu.Unlock()
+
+	if opts == nil {
+		return bolt.Open(path, 0444, opt)
+	}
+
+	if !copied {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+
+		safeReadMu.Lock()
+		dbpath = filepath.Join(opts.TempDir, fmt.Sprintf("%d-%s", len(safeCopies), filepath.Base(path)))
+		safeReadMu.Unlock()
+
+		extents, err := SafeCopyFile(path, dbpath, *opts)
+		if err != nil {
+			return nil, err
+		}
+
+		safeReadMu.Lock()
+		safeCopies[path] = dbpath
+		unreadables = append(unreadables, extents...)
+		safeReadMu.Unlock()
+
+		log.WithFields(log.Fields{
+			"path":       path,
+			"copy":       dbpath,
+			"unreadable": len(extents),
+		}).Debug("copied database in safe read mode")
+	}
+
+	return bolt.Open(dbpath, 0444, opt)
+}
+
+// SafeCopyFile copies a file from failing media and returns the unreadable
+// extents.
+//
+// The file is read sequentially in blocks. A block is retried with backoff
+// on I/O error. A block that remains unreadable is read again in sectors to
+// narrow the unreadable region, and the unreadable sectors are zero filled.
+func SafeCopyFile(src string, dst string, opts SafeReadOptions) ([]UnreadableExtent, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	var (
+		extents []UnreadableExtent
+		size    = info.Size()
+		start   = time.Now()
+		read    int64
+	)
+
+	// addExtent records an unreadable region and merges adjacent regions.
+	addExtent := func(offset int64, length int64, err error) {
+		if n := len(extents); n > 0 && extents[n-1].Offset+extents[n-1].Length == offset {
+			extents[n-1].Length += length
+			return
+		}
+		extents = append(extents, UnreadableExtent{
+			Path:   src,
+			Offset: offset,
+			Length: length,
+			Error:  err.Error(),
+		})
+	}
+
+	buf := make([]byte, opts.BlockSize)
+	for offset := int64(0); offset < size; offset += int64(opts.BlockSize) {
+		length := int64(opts.BlockSize)
+		if offset+length > size {
+			length = size - offset
+		}
+		block := buf[:length]
+
+		if err := readAtWithRetry(in, block, offset, opts); err != nil {
+			log.WithFields(log.Fields{
+				"path":   src,
+				"offset": offset,
+			}).Warn("unreadable block. Reading sectors: ", err)
+
+			for soffset := offset; soffset < offset+length; soffset += int64(opts.SectorSize) {
+				slength := int64(opts.SectorSize)
+				if soffset+slength > offset+length {
+					slength = offset + length - soffset
+				}
+				sector := block[soffset-offset : soffset-offset+slength]
+				if err := readAtWithRetry(in, sector, soffset, opts); err != nil {
+					for i := range sector {
+						sector[i] = 0
+					}
+					addExtent(soffset, slength, err)
+				}
+			}
+		}
+
+		if _, err := out.WriteAt(block, offset); err != nil {
+			return extents, fmt.Errorf("writing %s: %w", dst, err)
+		}
+
+		// Limit the read rate to reduce the stress on the source media.
+		read += length
+		if opts.RateLimit > 0 {
+			expected := time.Duration(float64(read) / float64(opts.RateLimit) * float64(time.Second))
+			if elapsed := time.Since(start); elapsed < expected {
+				time.Sleep(expected - elapsed)
+			}
+		}
+	}
+
+	for _, extent := range extents {
+		log.WithFields(log.Fields{
+			"path":   extent.Path,
+			"offset": extent.Offset,
+			"length": extent.Length,
+		}).Warn("unreadable extent zero filled: ", extent.Error)
+	}
+
+	return extents, nil
+}
+
+// readAtWithRetry reads len(buf) bytes at offset and retries with backoff on
+// error.
+func readAtWithRetry(r io.ReaderAt, buf []byte, offset int64, opts SafeReadOptions) error {
+	backoff := opts.Backoff
+
+	var err error
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		if attempt > 0 && backoff > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var n int
+		n, err = r.ReadAt(buf, offset)
+		if n == len(buf) {
+			return nil
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return err
+}
@@ -0,0 +1,449 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Package types found in a container filesystem.
+const (
+	PackageTypeAPK    = "apk"
+	PackageTypeDEB    = "deb"
+	PackageTypePython = "python"
+	PackageTypeNPM    = "npm"
+	PackageTypeRPM    = "rpm"
+)
+
+// License categories.
+const (
+	LicensePermissive = "permissive"
+	LicenseCopyleft   = "copyleft"
+	LicenseUnknown    = "unknown"
+)
+
+const (
+	apkInstalledPath = "/lib/apk/db/installed"
+	dpkgStatusPath   = "/var/lib/dpkg/status"
+	dpkgDocDir       = "/usr/share/doc"
+)
+
+// Package describes a software package installed in a container filesystem.
+type Package struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Type     string   `json:"type"`
+	Licenses []string `json:"licenses"`
+	Category string   `json:"category"`
+	Path     string   `json:"path"` // package database or manifest path
+}
+
+// copyleftLicenses contains the prefixes of common copyleft license
+// identifiers.
+var copyleftLicenses = []string{
+	"AGPL",
+	"GPL",
+	"LGPL",
+	"MPL",
+	"EPL",
+	"EUPL",
+	"CDDL",
+	"OSL",
+	"CC-BY-SA",
+	"GNU",
+}
+
+// permissiveLicenses contains the prefixes of common permissive license
+// identifiers. A license that is neither copyleft nor permissive is unknown,
+// so a proprietary or unrecognized license is not reported as permissive.
+var permissiveLicenses = []string{
+	"MIT",
+	"EXPAT",
+	"X11",
+	"BSD",
+	"0BSD",
+	"APACHE",
+	"ISC",
+	"ZLIB",
+	"LIBPNG",
+	"PSF",
+	"PYTHON",
+	"BSL",
+	"BOOST",
+	"CC0",
+	"UNLICENSE",
+	"PUBLIC-DOMAIN",
+	"PUBLIC DOMAIN",
+	"WTFPL",
+	"OPENSSL",
+	"CURL",
+	"POSTGRESQL",
+	"NCSA",
+	"HPND",
+	"UNICODE",
+	"ARTISTIC",
+	"BLUEOAK",
+}
+
+// ListPackages returns the packages installed in the merged view of the
+// layers.
+//
+// The packages are read from the apk database, the dpkg status database and
+// copyright files, Python package metadata, and npm package manifests.
+func ListPackages(layers []string) ([]Package, error) {
+	// files maps the interesting container paths to the path on disk.
+	files := make(map[string]string)
+
+	err := WalkLayers(layers, func(f LayerFile) error {
+		if !f.Info.Mode().IsRegular() {
+			return nil
+		}
+
+		switch {
+		case f.Path == apkInstalledPath,
+			f.Path == dpkgStatusPath,
+			strings.HasPrefix(f.Path, dpkgDocDir+"/") && filepath.Base(f.Path) == "copyright",
+			strings.HasSuffix(filepath.Dir(f.Path), ".dist-info") && filepath.Base(f.Path) == "METADATA",
+			filepath.Base(f.Path) == "package.json" && filepath.Base(filepath.Dir(filepath.Dir(f.Path))) == "node_modules":
+			files[f.Path] = f.LayerPath
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []Package
+	for path, diskpath := range files {
+		var (
+			results []Package
+			err     error
+		)
+
+		switch {
+		case path == apkInstalledPath:
+			results, err = readAPKInstalled(diskpath)
+		case path == dpkgStatusPath:
+			results, err = readDpkgStatus(diskpath, files)
+		case filepath.Base(path) == "METADATA":
+			results, err = readPythonMetadata(diskpath)
+		case filepath.Base(path) == "package.json":
+			results, err = readNPMPackage(diskpath)
+		}
+		if err != nil {
+			log.WithField("path", path).Warn("reading package information: ", err)
+			continue
+		}
+
+		for i := range results {
+			results[i].Path = path
+			results[i].Category = LicenseCategory(results[i].Licenses)
+		}
+		pkgs = append(pkgs, results...)
+	}
+
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Type != pkgs[j].Type {
+			return pkgs[i].Type < pkgs[j].Type
+		}
+		return pkgs[i].Name < pkgs[j].Name
+	})
+	return pkgs, nil
+}
+
+// LicenseCategory returns copyleft if any license is a copyleft license,
+// permissive if every license is a known permissive license, otherwise
+// unknown.
+func LicenseCategory(licenses []string) string {
+	if len(licenses) == 0 {
+		return LicenseUnknown
+	}
+
+	category := LicensePermissive
+	for _, license := range licenses {
+		upper := strings.ToUpper(strings.TrimSpace(license))
+		if upper == "" || upper == "UNKNOWN" || upper == "NONE" || upper == "NOASSERTION" || strings.HasPrefix(upper, "CUSTOM") {
+			category = LicenseUnknown
+			continue
+		}
+		if hasAnyPrefix(upper, copyleftLicenses) {
+			return LicenseCopyleft
+		}
+		if !hasAnyPrefix(upper, permissiveLicenses) {
+			category = LicenseUnknown
+		}
+	}
+	return category
+}
+
+// readAPKInstalled parses the Alpine apk database.
+//
+// Each package record is separated by an empty line and contains single
+// letter keys i.e. P (name), V (version), and L (license).
+func readAPKInstalled(path string) ([]Package, error) {
+	records, err := readRecords(path, ":")
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []Package
+	for _, record := range records {
+		if record["P"] == "" {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Name:     record["P"],
+			Version:  record["V"],
+			Type:     PackageTypeAPK,
+			Licenses: splitLicenses(record["L"]),
+		})
+	}
+	return pkgs, nil
+}
+
+// readDpkgStatus parses the dpkg status database.
+//
+// The dpkg status database does not contain license information. The
+// licenses are read from the machine-readable copyright file
+// /usr/share/doc/<package>/copyright.
+func readDpkgStatus(path string, files map[string]string) ([]Package, error) {
+	records, err := readRecords(path, ":")
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []Package
+	for _, record := range records {
+		// The status is the selection, the error flag, and the package
+		// state i.e. "install ok installed" or "deinstall ok
+		// config-files". Only the installed state is reported.
+		status := strings.Fields(record["Status"])
+		if record["Package"] == "" || len(status) != 3 || status[2] != "installed" {
+			continue
+		}
+
+		var licenses []string
+		if copyright, found := files[filepath.Join(dpkgDocDir, record["Package"], "copyright")]; found {
+			licenses = readDebianCopyright(copyright)
+		}
+
+		pkgs = append(pkgs, Package{
+			Name:     record["Package"],
+			Version:  record["Version"],
+			Type:     PackageTypeDEB,
+			Licenses: licenses,
+		})
+	}
+	return pkgs, nil
+}
+
+// readDebianCopyright returns the unique licenses in a machine-readable
+// debian copyright file.
+func readDebianCopyright(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	var licenses []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "License:") {
+			continue
+		}
+		license := strings.TrimSpace(strings.TrimPrefix(line, "License:"))
+		if license != "" && !seen[license] {
+			seen[license] = true
+			licenses = append(licenses, license)
+		}
+	}
+	return licenses
+}
+
+// readPythonMetadata parses the Python package METADATA file.
+func readPythonMetadata(path string) ([]Package, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pkg := Package{
+		Type: PackageTypePython,
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Headers end at the first empty line
+		if line == "" {
+			break
+		}
+
+		k, v, found := cut(line, ":")
+		if !found {
+			continue
+		}
+		switch k {
+		case "Name":
+			pkg.Name = v
+		case "Version":
+			pkg.Version = v
+		case "License", "License-Expression":
+			if v != "" && !strings.Contains(v, "\n") && len(v) < 100 {
+				pkg.Licenses = append(pkg.Licenses, v)
+			}
+		case "Classifier":
+			if strings.HasPrefix(v, "License ::") {
+				parts := strings.Split(v, "::")
+				pkg.Licenses = append(pkg.Licenses, strings.TrimSpace(parts[len(parts)-1]))
+			}
+		}
+	}
+
+	if pkg.Name == "" {
+		return nil, nil
+	}
+	return []Package{pkg}, nil
+}
+
+// readNPMPackage parses the npm package.json file.
+func readNPMPackage(path string) ([]Package, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest struct {
+		Name    string      `json:"name"`
+		Version string      `json:"version"`
+		License interface{} `json:"license"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Name == "" {
+		return nil, nil
+	}
+
+	var licenses []string
+	switch v := manifest.License.(type) {
+	case string:
+		licenses = splitLicenses(v)
+	case map[string]interface{}:
+		if t, ok := v["type"].(string); ok {
+			licenses = splitLicenses(t)
+		}
+	}
+
+	return []Package{
+		{
+			Name:     manifest.Name,
+			Version:  manifest.Version,
+			Type:     PackageTypeNPM,
+			Licenses: licenses,
+		},
+	}, nil
+}
+
+// readRecords parses a file with records separated by an empty line. Each
+// record line contains a key and value separated by sep. Continuation lines
+// starting with a space are ignored.
+func readRecords(path string, sep string) ([]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []map[string]string
+	record := make(map[string]string)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(record) > 0 {
+				records = append(records, record)
+				record = make(map[string]string)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		if k, v, found := cut(line, sep); found {
+			record[k] = v
+		}
+	}
+	if len(record) > 0 {
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// splitLicenses splits a license expression i.e. "MIT AND GPL-2.0" into
+// individual licenses.
+func splitLicenses(expression string) []string {
+	expression = strings.NewReplacer("(", " ", ")", " ").Replace(expression)
+
+	var licenses []string
+	fields := strings.Fields(expression)
+	for i := 0; i < len(fields); i++ {
+		switch strings.ToUpper(fields[i]) {
+		case "AND", "OR":
+			continue
+		case "WITH":
+			// skip the license exception
+			i++
+			continue
+		}
+		licenses = append(licenses, fields[i])
+	}
+	return licenses
+}
+
+// hasAnyPrefix returns true if s starts with any of the prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// cut slices s around the first instance of sep and trims the spaces.
+func cut(s string, sep string) (string, string, bool) {
+	i := strings.Index(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len(sep):]), true
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLicenseCategory(t *testing.T) {
+	tests := []struct {
+		licenses []string
+		want     string
+	}{
+		{nil, LicenseUnknown},
+		{[]string{"MIT"}, LicensePermissive},
+		{[]string{"Apache-2.0", "BSD-3-Clause"}, LicensePermissive},
+		{[]string{"MIT", "GPL-2.0-or-later"}, LicenseCopyleft},
+		{[]string{"LGPL-2.1"}, LicenseCopyleft},
+		{[]string{"MIT", "NOASSERTION"}, LicenseUnknown},
+		{[]string{"Proprietary"}, LicenseUnknown},
+		{[]string{"SSPL-1.0"}, LicenseUnknown},
+		{[]string{"MIT", "Commercial"}, LicenseUnknown},
+		{[]string{"Commercial", "AGPL-3.0"}, LicenseCopyleft},
+	}
+	for _, tc := range tests {
+		if got := LicenseCategory(tc.licenses); got != tc.want {
+			t.Errorf("LicenseCategory(%v) = %s, want %s", tc.licenses, got, tc.want)
+		}
+	}
+}
+
+// TestReadDpkgStatus checks that only the packages in the installed state
+// are reported.
+func TestReadDpkgStatus(t *testing.T) {
+	status := `Package: bash
+Status: install ok installed
+Version: 5.1-2
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0
+
+Package: purged
+Status: purge ok not-installed
+Version: 1.0
+
+Package: half
+Status: install reinstreq half-installed
+Version: 1.0
+`
+	path := filepath.Join(t.TempDir(), "status")
+	if err := os.WriteFile(path, []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs, err := readDpkgStatus(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pkg := range pkgs {
+		names = append(names, pkg.Name)
+	}
+	if want := []string{"bash"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("readDpkgStatus() = %v, want %v", names, want)
+	}
+}
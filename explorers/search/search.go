@@ -0,0 +1,504 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package search provides a full-text index of the container metadata,
+// specs, and logs of a host stored in a bolt database.
+//
+// The index maps the lowercase tokens of each document to the document IDs.
+// A dotted or dashed token i.e. api.example.com is indexed as a whole and as
+// the parts api, example, and com.
+package search
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Sources of a document.
+const (
+	SourceMetadata   = "metadata"
+	SourceSpec       = "spec"
+	SourceEnv        = "env"
+	SourceAnnotation = "annotation"
+	SourceLog        = "log"
+)
+
+const (
+	// indexVersion is the version of the index layout.
+	indexVersion = "1"
+
+	// maxTokenLength is the maximum length of an indexed token. The longer
+	// tokens are encoded data i.e. base64 and are not searched by word.
+	maxTokenLength = 128
+
+	// maxTextLength is the maximum length of the indexed document text.
+	maxTextLength = 4096
+
+	// flushDocuments is the number of documents written in a transaction.
+	flushDocuments = 10000
+)
+
+var (
+	infoBucket      = []byte("info")
+	documentsBucket = []byte("documents")
+	postingsBucket  = []byte("postings")
+)
+
+// Document is an indexed field of the container metadata or spec, or a log
+// line.
+type Document struct {
+	Namespace   string `json:"namespace"`
+	ContainerID string `json:"container_id"`
+	Source      string `json:"source"`
+	Field       string `json:"field"` // JSON path or log file and line number
+	Text        string `json:"text"`
+}
+
+// Info describes an index.
+type Info struct {
+	Version   string    `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	ImageRoot string    `json:"image_root,omitempty"`
+	Documents uint64    `json:"documents"`
+}
+
+// Writer builds an index.
+//
+// The postings of the documents added since the last flush are written as a
+// chunk keyed by the term and the chunk number, so the postings are appended
+// without reading the previous chunks.
+type Writer struct {
+	db       *bolt.DB
+	info     Info
+	pending  []Document
+	chunk    uint64
+	postings map[string][]uint64
+}
+
+// Create creates the index at path. An existing file is not overwritten.
+func Create(path string, imageroot string) (*Writer, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("index %s already exists", path)
+	}
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{infoBucket, documentsBucket, postingsBucket} {
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Writer{
+		db: db,
+		info: Info{
+			Version:   indexVersion,
+			CreatedAt: time.Now().UTC(),
+			ImageRoot: imageroot,
+		},
+		postings: make(map[string][]uint64),
+	}, nil
+}
+
+// Add adds a document to the index.
+func (w *Writer) Add(doc Document) error {
+	if len(doc.Text) > maxTextLength {
+		doc.Text = doc.Text[:maxTextLength]
+	}
+
+	id := w.info.Documents + uint64(len(w.pending)) + 1
+	w.pending = append(w.pending, doc)
+	for _, token := range documentTokens(doc) {
+		ids := w.postings[token]
+		if n := len(ids); n > 0 && ids[n-1] == id {
+			continue
+		}
+		w.postings[token] = append(ids, id)
+	}
+
+	if len(w.pending) >= flushDocuments {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes the documents and the postings added since the last flush.
+func (w *Writer) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	err := w.db.Update(func(tx *bolt.Tx) error {
+		documents := tx.Bucket(documentsBucket)
+		for i, doc := range w.pending {
+			data, err := json.Marshal(doc)
+			if err != nil {
+				return err
+			}
+			if err := documents.Put(documentKey(w.info.Documents+uint64(i)+1), data); err != nil {
+				return err
+			}
+		}
+
+		postings := tx.Bucket(postingsBucket)
+		for token, ids := range w.postings {
+			if err := postings.Put(postingKey(token, w.chunk), encodePostings(ids)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.info.Documents += uint64(len(w.pending))
+	w.pending = nil
+	w.chunk++
+	w.postings = make(map[string][]uint64)
+	return nil
+}
+
+// Close flushes the pending documents and closes the index.
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if err == nil {
+		err = w.db.Update(func(tx *bolt.Tx) error {
+			data, err := json.Marshal(w.info)
+			if err != nil {
+				return err
+			}
+			return tx.Bucket(infoBucket).Put([]byte("info"), data)
+		})
+	}
+	if cerr := w.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Documents returns the number of documents added.
+func (w *Writer) Documents() uint64 {
+	return w.info.Documents + uint64(len(w.pending))
+}
+
+// Index is an index opened for searching.
+type Index struct {
+	db *bolt.DB
+}
+
+// Open opens the index at path read-only.
+func Open(path string) (*Index, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0444, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &Index{db: db}, nil
+}
+
+// Close closes the index.
+func (i *Index) Close() error {
+	return i.db.Close()
+}
+
+// Info returns the description of the index.
+func (i *Index) Info() (Info, error) {
+	var info Info
+	err := i.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(infoBucket)
+		if b == nil {
+			return fmt.Errorf("not a container-explorer index")
+		}
+		data := b.Get([]byte("info"))
+		if data == nil {
+			return fmt.Errorf("index is incomplete")
+		}
+		return json.Unmarshal(data, &info)
+	})
+	if err == nil && info.Version != indexVersion {
+		err = fmt.Errorf("unsupported index version %s", info.Version)
+	}
+	return info, err
+}
+
+// Query selects the documents of a search.
+type Query struct {
+	// Terms are the words searched. A term ending with * matches the tokens
+	// starting with the term. A term of several words i.e. "connection
+	// refused" matches the documents containing the words in this order.
+	Terms []string
+
+	Source      string // all sources if empty
+	ContainerID string // all containers if empty
+	Limit       int    // all documents if 0
+}
+
+// Search returns the documents matching every term of the query in the
+// order they were added.
+func (i *Index) Search(q Query) ([]Document, error) {
+	if _, err := i.Info(); err != nil {
+		return nil, err
+	}
+
+	var docs []Document
+	err := i.db.View(func(tx *bolt.Tx) error {
+		var ids []uint64
+		first := true
+		for _, term := range q.Terms {
+			for _, word := range strings.Fields(strings.ToLower(term)) {
+				prefix := strings.HasSuffix(word, "*")
+				for _, token := range queryTokens(strings.TrimSuffix(word, "*"), prefix) {
+					matches := lookup(tx.Bucket(postingsBucket), token, prefix)
+					if first {
+						ids, first = matches, false
+					} else {
+						ids = intersect(ids, matches)
+					}
+				}
+			}
+		}
+
+		if first {
+			return fmt.Errorf("query has no searchable words")
+		}
+
+		documents := tx.Bucket(documentsBucket)
+		for _, id := range ids {
+			var doc Document
+			if err := json.Unmarshal(documents.Get(documentKey(id)), &doc); err != nil {
+				return fmt.Errorf("reading document %d: %w", id, err)
+			}
+			if q.Source != "" && doc.Source != q.Source {
+				continue
+			}
+			if q.ContainerID != "" && doc.ContainerID != q.ContainerID {
+				continue
+			}
+			if !containsTerms(doc, q.Terms) {
+				continue
+			}
+			docs = append(docs, doc)
+			if q.Limit > 0 && len(docs) == q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return docs, err
+}
+
+// lookup returns the sorted IDs of the documents containing the token or a
+// token starting with the prefix.
+func lookup(b *bolt.Bucket, token string, prefix bool) []uint64 {
+	seek := []byte(token)
+	if !prefix {
+		seek = append(seek, 0)
+	}
+
+	var ids []uint64
+	c := b.Cursor()
+	for k, v := c.Seek(seek); k != nil && strings.HasPrefix(string(k), string(seek)); k, v = c.Next() {
+		ids = append(ids, decodePostings(v)...)
+	}
+	if prefix {
+		ids = unique(ids)
+	}
+	return ids
+}
+
+// containsTerms returns true if the document contains the terms without a
+// wildcard. The tokens match the words, so a term of several words is
+// verified against the text.
+func containsTerms(doc Document, terms []string) bool {
+	text := strings.ToLower(doc.Field + " " + doc.Text)
+	for _, term := range terms {
+		term = strings.ToLower(term)
+		if strings.Contains(term, "*") {
+			continue
+		}
+		if !strings.Contains(text, strings.Join(strings.Fields(term), " ")) {
+			return false
+		}
+	}
+	return true
+}
+
+// documentTokens returns the tokens of the document field and text. The
+// field of a log line is the log file and is not indexed.
+func documentTokens(doc Document) []string {
+	if doc.Source == SourceLog {
+		return Tokens(doc.Text)
+	}
+	return Tokens(doc.Field + " " + doc.Text)
+}
+
+// Tokens returns the lowercase tokens of the text. The tokens are runs of
+// letters and digits joined by dots, dashes, or underscores. A joined token
+// is also split into its parts.
+func Tokens(text string) []string {
+	var tokens []string
+	for _, word := range words(text) {
+		if len(word) > maxTokenLength {
+			continue
+		}
+		tokens = append(tokens, word)
+		if parts := wordParts(word); len(parts) > 1 {
+			tokens = append(tokens, parts...)
+		}
+	}
+	return tokens
+}
+
+// queryTokens returns the tokens looked up for a query word. The parts of a
+// joined word are looked up, so example.org matches db.example.org, and the
+// documents are verified to contain the word. A prefix is looked up as a
+// whole, so 10.0.0.* matches 10.0.0.7.
+func queryTokens(word string, prefix bool) []string {
+	var tokens []string
+	for _, w := range words(word) {
+		if len(w) > maxTokenLength {
+			continue
+		}
+		if prefix {
+			tokens = append(tokens, w)
+			continue
+		}
+		tokens = append(tokens, wordParts(w)...)
+	}
+	return tokens
+}
+
+// words returns the lowercase runs of letters, digits, and joining
+// characters of the text without the leading and trailing joining
+// characters.
+func words(text string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !isJoiner(r)
+	}) {
+		if w = strings.TrimFunc(w, isJoiner); w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// wordParts returns the runs of letters and digits of a word.
+func wordParts(word string) []string {
+	return strings.FieldsFunc(word, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// isJoiner returns true if the character joins the parts of a token i.e.
+// a host name or a package name.
+func isJoiner(r rune) bool {
+	return r == '.' || r == '-' || r == '_'
+}
+
+// documentKey returns the key of a document ID.
+func documentKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// postingKey returns the key of a postings chunk. The token does not
+// contain a NUL character.
+func postingKey(token string, chunk uint64) []byte {
+	key := make([]byte, len(token)+1+8)
+	copy(key, token)
+	binary.BigEndian.PutUint64(key[len(token)+1:], chunk)
+	return key
+}
+
+// encodePostings encodes the sorted document IDs as varint deltas.
+func encodePostings(ids []uint64) []byte {
+	buf := make([]byte, 0, len(ids)*2)
+	var prev uint64
+	for _, id := range ids {
+		var b [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(b[:], id-prev)
+		buf = append(buf, b[:n]...)
+		prev = id
+	}
+	return buf
+}
+
+// decodePostings decodes the document IDs encoded by encodePostings.
+func decodePostings(data []byte) []uint64 {
+	var ids []uint64
+	var prev uint64
+	for len(data) > 0 {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			break
+		}
+		prev += delta
+		ids = append(ids, prev)
+		data = data[n:]
+	}
+	return ids
+}
+
+// intersect returns the IDs in both sorted lists.
+func intersect(a []uint64, b []uint64) []uint64 {
+	var ids []uint64
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			ids = append(ids, a[i])
+			i++
+			j++
+		}
+	}
+	return ids
+}
+
+// unique returns the sorted unique IDs.
+func unique(ids []uint64) []uint64 {
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	var out []uint64
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,309 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Security profiles compared by the profile drift analysis.
+const (
+	ProfileSeccomp  = "seccomp"
+	ProfileAppArmor = "apparmor"
+
+	// ProfileUnconfined is the profile name disabling the confinement.
+	ProfileUnconfined = "unconfined"
+)
+
+// defaultSeccompProfile is the default seccomp profile of containerd and
+// docker converted to the profile file format. The rules depending on the
+// capabilities and the architecture are conditioned using includes and
+// excludes.
+//
+//go:embed profiles/seccomp-default.json
+var defaultSeccompProfile []byte
+
+// ProfileDrift is a security profile setting of a container differing from
+// the runtime default.
+type ProfileDrift struct {
+	Profile string `json:"profile"` // seccomp or apparmor
+	Setting string `json:"setting"` // i.e. default action or allowed syscalls
+	Value   string `json:"value"`
+	Default string `json:"default"`
+}
+
+// SecurityProfile is the seccomp and AppArmor confinement of a container.
+type SecurityProfile struct {
+	// Seccomp is the seccomp profile of the container. Nil is unconfined
+	// unless SeccompRuntimeDefault is set.
+	Seccomp *specs.LinuxSeccomp `json:"seccomp,omitempty"`
+
+	// SeccompRuntimeDefault is set when the runtime applies its configured
+	// default seccomp profile when the container starts i.e. docker.
+	SeccompRuntimeDefault bool `json:"seccomp_runtime_default,omitempty"`
+
+	AppArmor     string   `json:"apparmor,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"` // bounding set
+	Privileged   bool     `json:"privileged,omitempty"`
+	Source       string   `json:"source"` // file or record holding the profile
+}
+
+// SecurityProfileExplorer is implemented by the explorers of the runtimes
+// that do not store an OCI runtime spec i.e. docker.
+type SecurityProfileExplorer interface {
+	// ContainerSecurityProfile returns the security profile of a container.
+	ContainerSecurityProfile(ctx context.Context, containerid string) (SecurityProfile, error)
+}
+
+// SpecSecurityProfile returns the security profile of an OCI runtime spec.
+func SpecSecurityProfile(spec *specs.Spec) SecurityProfile {
+	var profile SecurityProfile
+	if spec.Linux != nil {
+		profile.Seccomp = spec.Linux.Seccomp
+	}
+	if spec.Process != nil {
+		profile.AppArmor = spec.Process.ApparmorProfile
+		if spec.Process.Capabilities != nil {
+			profile.Capabilities = spec.Process.Capabilities.Bounding
+		}
+	}
+	return profile
+}
+
+// seccompProfileFile is a seccomp profile in the docker and
+// containers-common format i.e. /usr/share/containers/seccomp.json. The
+// format is a superset of the seccomp section of an OCI runtime spec.
+type seccompProfileFile struct {
+	DefaultAction   specs.LinuxSeccompAction `json:"defaultAction"`
+	DefaultErrnoRet *uint                    `json:"defaultErrnoRet,omitempty"`
+	Architectures   []specs.Arch             `json:"architectures,omitempty"`
+	Syscalls        []seccompProfileSyscall  `json:"syscalls"`
+}
+
+// seccompProfileSyscall is a syscall rule of a seccomp profile file. The
+// rule applies when the container has the included capabilities and does
+// not have the excluded capabilities.
+type seccompProfileSyscall struct {
+	Name     string                   `json:"name,omitempty"`
+	Names    []string                 `json:"names,omitempty"`
+	Action   specs.LinuxSeccompAction `json:"action"`
+	ErrnoRet *uint                    `json:"errnoRet,omitempty"`
+	Args     []specs.LinuxSeccompArg  `json:"args,omitempty"`
+	Includes struct {
+		Caps []string `json:"caps,omitempty"`
+	} `json:"includes"`
+	Excludes struct {
+		Caps []string `json:"caps,omitempty"`
+	} `json:"excludes"`
+}
+
+// ParseSeccompProfile parses a seccomp profile file for a container with
+// the capabilities. ALL grants all the capabilities.
+//
+// The rules conditioned on the capabilities are resolved. The rules
+// conditioned on the architecture or the kernel version are kept, so the
+// profile allows at least the syscalls allowed on the host.
+func ParseSeccompProfile(data []byte, caps []string) (*specs.LinuxSeccomp, error) {
+	var file seccompProfileFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("unmarshalling seccomp profile: %w", err)
+	}
+	if file.DefaultAction == "" {
+		return nil, fmt.Errorf("seccomp profile has no default action")
+	}
+
+	hascap := make(map[string]bool)
+	for _, c := range caps {
+		hascap[c] = true
+	}
+	has := func(c string) bool {
+		return hascap[c] || hascap["ALL"]
+	}
+
+	profile := &specs.LinuxSeccomp{
+		DefaultAction: file.DefaultAction,
+		Architectures: file.Architectures,
+	}
+	for _, rule := range file.Syscalls {
+		applies := true
+		for _, c := range rule.Includes.Caps {
+			applies = applies && has(c)
+		}
+		for _, c := range rule.Excludes.Caps {
+			applies = applies && !has(c)
+		}
+		if !applies {
+			continue
+		}
+
+		names := rule.Names
+		if rule.Name != "" {
+			names = append(names, rule.Name)
+		}
+		profile.Syscalls = append(profile.Syscalls, specs.LinuxSyscall{
+			Names:    names,
+			Action:   rule.Action,
+			ErrnoRet: rule.ErrnoRet,
+			Args:     rule.Args,
+		})
+	}
+	return profile, nil
+}
+
+// DefaultSeccompProfile returns the default seccomp profile of containerd
+// and docker for a container with the capabilities.
+//
+// The default profile is compiled into the runtime binaries and cannot be
+// read from the evidence. The profile of containerd 1.5 is used.
+func DefaultSeccompProfile(caps []string) *specs.LinuxSeccomp {
+	profile, err := ParseSeccompProfile(defaultSeccompProfile, caps)
+	if err != nil {
+		panic(fmt.Sprintf("parsing the embedded default seccomp profile: %v", err))
+	}
+	return profile
+}
+
+// CompareSeccomp returns the settings of the effective seccomp profile of a
+// container loosened from the reference profile. A nil effective profile is
+// unconfined.
+//
+// A profile is loosened when the default action is more permissive, when
+// syscalls denied by the reference are allowed, or when the argument filters
+// of the reference are removed.
+func CompareSeccomp(effective *specs.LinuxSeccomp, reference *specs.LinuxSeccomp) []ProfileDrift {
+	if reference == nil {
+		return nil
+	}
+	if effective == nil {
+		return []ProfileDrift{{
+			Profile: ProfileSeccomp,
+			Setting: "profile",
+			Value:   ProfileUnconfined,
+			Default: "confined",
+		}}
+	}
+
+	var drifts []ProfileDrift
+	if seccompActionRank(effective.DefaultAction) > seccompActionRank(reference.DefaultAction) {
+		drifts = append(drifts, ProfileDrift{
+			Profile: ProfileSeccomp,
+			Setting: "default action",
+			Value:   string(effective.DefaultAction),
+			Default: string(reference.DefaultAction),
+		})
+		// The syscalls are allowed by default.
+		if seccompActionRank(effective.DefaultAction) > 0 {
+			return drifts
+		}
+	}
+
+	allowed, unfiltered := allowedSyscalls(effective)
+	refallowed, refunfiltered := allowedSyscalls(reference)
+
+	var added, removed []string
+	for name := range allowed {
+		if !refallowed[name] {
+			added = append(added, name)
+		} else if unfiltered[name] && !refunfiltered[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	if len(added) > 0 {
+		drifts = append(drifts, ProfileDrift{
+			Profile: ProfileSeccomp,
+			Setting: "allowed syscalls",
+			Value:   strings.Join(added, ","),
+			Default: "denied",
+		})
+	}
+	if len(removed) > 0 {
+		drifts = append(drifts, ProfileDrift{
+			Profile: ProfileSeccomp,
+			Setting: "argument filters removed",
+			Value:   strings.Join(removed, ","),
+			Default: "allowed with argument filters",
+		})
+	}
+	return drifts
+}
+
+// CompareAppArmor returns the AppArmor profile of a container if it differs
+// from the reference profile. An empty effective profile is unconfined.
+//
+// The reference profile is a profile name or prefix i.e.
+// containers-default matches containers-default-0.50.1.
+func CompareAppArmor(effective string, reference string) []ProfileDrift {
+	if reference == "" || (effective != "" && strings.HasPrefix(effective, reference)) {
+		return nil
+	}
+
+	drift := ProfileDrift{
+		Profile: ProfileAppArmor,
+		Setting: "profile",
+		Value:   effective,
+		Default: reference,
+	}
+	switch effective {
+	case "", ProfileUnconfined:
+		drift.Value = ProfileUnconfined
+	default:
+		// A custom profile may be stricter or looser than the default.
+		drift.Setting = "custom profile"
+	}
+	return []ProfileDrift{drift}
+}
+
+// allowedSyscalls returns the syscalls allowed by a profile and the syscalls
+// allowed without argument filters.
+func allowedSyscalls(profile *specs.LinuxSeccomp) (map[string]bool, map[string]bool) {
+	allowed := make(map[string]bool)
+	unfiltered := make(map[string]bool)
+	for _, rule := range profile.Syscalls {
+		if seccompActionRank(rule.Action) == 0 {
+			continue
+		}
+		for _, name := range rule.Names {
+			allowed[name] = true
+			if len(rule.Args) == 0 {
+				unfiltered[name] = true
+			}
+		}
+	}
+	return allowed, unfiltered
+}
+
+// seccompActionRank returns the permissiveness of a seccomp action. The
+// actions denying a syscall are 0, logging is 1, and allowing is 2.
+func seccompActionRank(action specs.LinuxSeccompAction) int {
+	switch action {
+	case specs.ActAllow:
+		return 2
+	case specs.ActLog:
+		return 1
+	}
+	return 0
+}
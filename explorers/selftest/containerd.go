@@ -0,0 +1,348 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/metadata/boltutil"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
+	snapshotstorage "github.com/containerd/containerd/snapshots/storage"
+	"github.com/gogo/protobuf/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	spec "github.com/opencontainers/runtime-spec/specs-go"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	containerdNamespace = "default"
+
+	// specTypeURL is the type URL of the OCI runtime spec in meta.db.
+	specTypeURL = "types.containerd.io/opencontainers/runtime-spec/1/Spec"
+
+	// remoteSnapshotLabel marks a snapshot lazily pulled by a remote
+	// snapshotter i.e. stargz.
+	remoteSnapshotLabel = "containerd.io/snapshot/remote"
+)
+
+// containerdSnapshotters are the snapshotters of the containerd reference
+// evidence of a Linux node. The image layer is unpacked in each snapshotter.
+//
+// The zfs and devmapper snapshotters are not covered. Their snapshots are
+// zfs datasets and thin devices that cannot be written without privileges.
+var containerdSnapshotters = []string{"overlayfs", "native", "btrfs", "stargz"}
+
+// windowsSnapshotters are the snapshotters of the containerd reference
+// evidence of a Windows node.
+var windowsSnapshotters = []string{"windows"}
+
+// proxySnapshotterDirs are the root directories of the proxy snapshotters
+// relative to the parent of the containerd root directory.
+var proxySnapshotterDirs = map[string]string{
+	"stargz": filepath.Join("containerd-stargz-grpc", "snapshotter"),
+}
+
+// containerdLayerKey is the snapshot key of the image layer i.e. the chain
+// ID of the layer.
+var containerdLayerKey = digest.FromString("selftest image layer").String()
+
+func containerdFixture() Fixture {
+	return newContainerdFixture("containerd", filepath.Join("var", "lib", "containerd"), containerdSnapshotters)
+}
+
+func containerdWindowsFixture() Fixture {
+	return newContainerdFixture("containerd-windows", filepath.Join("ProgramData", "containerd", "root"), windowsSnapshotters)
+}
+
+// newContainerdFixture returns the fixture of a containerd root directory
+// relative to the image root with a container per snapshotter.
+func newContainerdFixture(name string, rootdir string, snapshotters []string) Fixture {
+	f := Fixture{
+		Name:      name,
+		Runtime:   "containerd",
+		Images:    []string{ImageName},
+		Snapshots: true,
+		Info:      true,
+		Tasks:     true,
+		build: func(imageroot string) error {
+			return buildContainerd(filepath.Join(imageroot, rootdir), snapshotters)
+		},
+	}
+	for _, snapshotter := range snapshotters {
+		ctr := Container{
+			ID:    "selftest-" + snapshotter,
+			Image: ImageName,
+			Task:  "containerd UNKNOWN",
+		}
+		if snapshotter == "windows" {
+			ctr.Platform = "windows"
+			ctr.Task = "wcow UNKNOWN"
+		}
+		f.Containers = append(f.Containers, ctr)
+	}
+	return f
+}
+
+// buildContainerd writes the containerd metadata database meta.db, the
+// snapshot databases metadata.db, and the snapshot directories.
+func buildContainerd(root string, snapshotters []string) error {
+	metadir := filepath.Join(root, "io.containerd.metadata.v1.bolt")
+	if err := os.MkdirAll(metadir, 0700); err != nil {
+		return err
+	}
+
+	bdb, err := bolt.Open(filepath.Join(metadir, "meta.db"), 0644, nil)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), containerdNamespace)
+	db := metadata.NewDB(bdb, nil, nil)
+	if err := db.Init(ctx); err != nil {
+		return err
+	}
+
+	_, err = metadata.NewImageStore(db).Create(ctx, images.Image{
+		Name: ImageName,
+		Target: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromString("selftest manifest"),
+			Size:      1,
+		},
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	})
+	if err != nil {
+		return fmt.Errorf("creating image: %w", err)
+	}
+
+	ctrstore := metadata.NewContainerStore(db)
+	for i, snapshotter := range snapshotters {
+		id := "selftest-" + snapshotter
+
+		// The backend snapshot names are prefixed with the namespace and
+		// a sequence number as containerd does.
+		layername := fmt.Sprintf("%s/%d/%s", containerdNamespace, 2*i+1, containerdLayerKey)
+		ctrname := fmt.Sprintf("%s/%d/%s", containerdNamespace, 2*i+2, id)
+		if err := buildSnapshots(snapshotterDir(root, snapshotter), snapshotter, layername, ctrname); err != nil {
+			return fmt.Errorf("creating %s snapshots: %w", snapshotter, err)
+		}
+		if err := putSnapshotKeys(bdb, snapshotter, layername, id, ctrname); err != nil {
+			return fmt.Errorf("creating %s snapshot keys: %w", snapshotter, err)
+		}
+
+		specdata, err := json.Marshal(containerSpec(id, snapshotter))
+		if err != nil {
+			return err
+		}
+		_, err = ctrstore.Create(ctx, containers.Container{
+			ID:          id,
+			Image:       ImageName,
+			Labels:      map[string]string{"selftest": snapshotter},
+			Runtime:     containers.RuntimeInfo{Name: "io.containerd.runc.v2"},
+			Spec:        &types.Any{TypeUrl: specTypeURL, Value: specdata},
+			Snapshotter: snapshotter,
+			SnapshotKey: id,
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt,
+		})
+		if err != nil {
+			return fmt.Errorf("creating container %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// containerSpec returns the OCI runtime spec of a reference container.
+//
+// The Linux spec has the cgroups path containerd uses by default i.e.
+// /<namespace>/<container id>. The reference evidence has no cgroup
+// directories, so the task status is UNKNOWN.
+func containerSpec(id string, snapshotter string) spec.Spec {
+	if snapshotter == "windows" {
+		return spec.Spec{
+			Version:  spec.Version,
+			Hostname: "selftest",
+			Process:  &spec.Process{Args: []string{"cmd.exe"}, Cwd: `C:\`},
+			Windows:  &spec.Windows{},
+		}
+	}
+	return spec.Spec{
+		Version:  spec.Version,
+		Hostname: "selftest",
+		Process:  &spec.Process{Args: []string{"/bin/sh"}, Cwd: "/"},
+		Root:     &spec.Root{Path: "rootfs"},
+		Linux: &spec.Linux{
+			CgroupsPath: "/" + containerdNamespace + "/" + id,
+			Namespaces: []spec.LinuxNamespace{
+				{Type: spec.PIDNamespace},
+				{Type: spec.MountNamespace},
+			},
+		},
+	}
+}
+
+// snapshotterDir returns the root directory of a snapshotter. The proxy
+// snapshotters i.e. stargz use a directory outside the containerd root.
+func snapshotterDir(root string, snapshotter string) string {
+	if dir, found := proxySnapshotterDirs[snapshotter]; found {
+		return filepath.Join(filepath.Dir(root), dir)
+	}
+	return filepath.Join(root, "io.containerd.snapshotter.v1."+snapshotter)
+}
+
+// buildSnapshots writes the snapshot database metadata.db and the snapshot
+// directories of the image layer and of the container.
+func buildSnapshots(dir string, snapshotter string, layername string, ctrname string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "snapshots"), 0700); err != nil {
+		return err
+	}
+	ms, err := snapshotstorage.NewMetaStore(filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		return err
+	}
+	defer ms.Close()
+
+	ctx, t, err := ms.TransactionContext(context.Background(), true)
+	if err != nil {
+		return err
+	}
+
+	// The image layer is unpacked to an active snapshot committed using
+	// the chain ID. A lazily pulled layer is labeled as a remote snapshot.
+	var opts []snapshots.Opt
+	if _, found := proxySnapshotterDirs[snapshotter]; found {
+		opts = append(opts, snapshots.WithLabels(map[string]string{remoteSnapshotLabel: "remote snapshot"}))
+	}
+	extract := layername + "-extract"
+	layer, err := snapshotstorage.CreateSnapshot(ctx, snapshots.KindActive, extract, "", opts...)
+	if err != nil {
+		t.Rollback()
+		return err
+	}
+	if _, err := snapshotstorage.CommitActive(ctx, extract, layername, snapshots.Usage{}, opts...); err != nil {
+		t.Rollback()
+		return err
+	}
+	ctr, err := snapshotstorage.CreateSnapshot(ctx, snapshots.KindActive, ctrname, layername)
+	if err != nil {
+		t.Rollback()
+		return err
+	}
+	if err := t.Commit(); err != nil {
+		return err
+	}
+
+	switch snapshotter {
+	case "native":
+		if err := writeImageLayer(filepath.Join(dir, "snapshots", layer.ID)); err != nil {
+			return err
+		}
+		return writeFullCopy(filepath.Join(dir, "snapshots", ctr.ID))
+	case "btrfs":
+		// The active snapshot is a writable subvolume snapshot of the
+		// committed image layer subvolume.
+		if err := writeImageLayer(filepath.Join(dir, "snapshots", layer.ID)); err != nil {
+			return err
+		}
+		return writeFullCopy(filepath.Join(dir, "active", ctr.ID))
+	case "windows":
+		// A Windows layer holds the files in Files and the registry hives
+		// in Hives. The container scratch layer is a virtual disk.
+		if err := writeImageLayer(filepath.Join(dir, "snapshots", layer.ID, "Files")); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(dir, "snapshots", layer.ID, "Hives"), 0700); err != nil {
+			return err
+		}
+		return writeFile(filepath.Join(dir, "snapshots", ctr.ID, "sandbox.vhdx"), "")
+	}
+
+	if err := writeImageLayer(filepath.Join(dir, "snapshots", layer.ID, "fs")); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "snapshots", ctr.ID, "work"), 0700); err != nil {
+		return err
+	}
+	return writeUpperLayer(filepath.Join(dir, "snapshots", ctr.ID, "fs"))
+}
+
+// putSnapshotKeys writes the snapshot keys of a snapshotter to meta.db.
+//
+// The snapshot keys map the keys used by the clients to the backend
+// snapshot names in metadata.db.
+func putSnapshotKeys(bdb *bolt.DB, snapshotter string, layername string, ctrkey string, ctrname string) error {
+	return bdb.Update(func(tx *bolt.Tx) error {
+		bkt, err := createBuckets(tx, []byte("v1"), []byte(containerdNamespace), []byte("snapshots"), []byte(snapshotter))
+		if err != nil {
+			return err
+		}
+		if err := putSnapshotKey(bkt, containerdLayerKey, layername, ""); err != nil {
+			return err
+		}
+		return putSnapshotKey(bkt, ctrkey, ctrname, containerdLayerKey)
+	})
+}
+
+// putSnapshotKey writes a snapshot key to the snapshotter bucket of
+// meta.db and records the key as a child of its parent.
+func putSnapshotKey(bkt *bolt.Bucket, key string, name string, parent string) error {
+	sbkt, err := bkt.CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return err
+	}
+	if err := sbkt.Put([]byte("name"), []byte(name)); err != nil {
+		return err
+	}
+	if parent != "" {
+		if err := sbkt.Put([]byte("parent"), []byte(parent)); err != nil {
+			return err
+		}
+		pbkt, err := bkt.Bucket([]byte(parent)).CreateBucketIfNotExists([]byte("children"))
+		if err != nil {
+			return err
+		}
+		if err := pbkt.Put([]byte(key), nil); err != nil {
+			return err
+		}
+	}
+	return boltutil.WriteTimestamps(sbkt, createdAt, createdAt)
+}
+
+// createBuckets creates the nested buckets and returns the last bucket.
+func createBuckets(tx *bolt.Tx, keys ...[]byte) (*bolt.Bucket, error) {
+	bkt, err := tx.CreateBucketIfNotExists(keys[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys[1:] {
+		if bkt, err = bkt.CreateBucketIfNotExists(key); err != nil {
+			return nil, err
+		}
+	}
+	return bkt, nil
+}
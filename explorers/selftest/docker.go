@@ -0,0 +1,145 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selftest
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/google/container-explorer/explorers/docker"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// dockerImageName is the image name in the docker repositories.
+	dockerImageName = "selftest:1.0"
+
+	// dockerLayerLink is the shortened layer link of the image layer.
+	dockerLayerLink = "SELFTESTIMAGELAYER"
+)
+
+var (
+	dockerContainerID = digest.FromString("selftest docker container").Encoded()
+	dockerImageID     = digest.FromString("selftest docker image")
+	dockerLayerID     = digest.FromString("selftest docker layer").Encoded()
+	dockerMountID     = digest.FromString("selftest docker mount").Encoded()
+)
+
+// dockerFixture returns the fixture of a docker graph driver i.e. overlay2
+// or vfs. The devicemapper graph driver is not covered because the layers
+// are thin devices.
+func dockerFixture(driver string) Fixture {
+	name := "docker"
+	if driver != "overlay2" {
+		name += "-" + driver
+	}
+	return Fixture{
+		Name:    name,
+		Runtime: "docker",
+		Containers: []Container{
+			{ID: dockerContainerID, Image: dockerImageName},
+		},
+		Images: []string{dockerImageName},
+		build: func(imageroot string) error {
+			return buildDocker(imageroot, driver)
+		},
+	}
+}
+
+// buildDocker writes the docker container configuration, the image
+// repositories, the layer database, and the layer directories of the graph
+// driver.
+func buildDocker(imageroot string, driver string) error {
+	root := filepath.Join(imageroot, "var", "lib", "docker")
+	imagedir := filepath.Join(root, "image", driver)
+
+	config := docker.ConfigFile{
+		ID:      dockerContainerID,
+		Created: createdAt,
+		Path:    "/bin/sh",
+		Config: docker.Config{
+			Hostname: "selftest",
+			Image:    dockerImageName,
+			Cmd:      []string{"/bin/sh"},
+			Labels:   map[string]string{"selftest": "docker"},
+		},
+		Image:   dockerImageID.String(),
+		Name:    "/selftest",
+		Driver:  driver,
+		LogPath: filepath.Join("/var/lib/docker/containers", dockerContainerID, dockerContainerID+"-json.log"),
+	}
+	if err := writeJSON(filepath.Join(root, "containers", dockerContainerID, "config.v2.json"), config); err != nil {
+		return err
+	}
+	if err := writeJSON(filepath.Join(root, "containers", dockerContainerID, "hostconfig.json"), docker.HostConfig{}); err != nil {
+		return err
+	}
+
+	repositories := docker.ImageRepository{
+		Repositories: map[string]docker.ImageName{
+			"selftest": {dockerImageName: dockerImageID.String()},
+		},
+	}
+	if err := writeJSON(filepath.Join(imagedir, "repositories.json"), repositories); err != nil {
+		return err
+	}
+	imageconfig := map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"created":      createdAt,
+		"rootfs": map[string]interface{}{
+			"type":     "layers",
+			"diff_ids": []string{digest.FromString("selftest docker diff").String()},
+		},
+	}
+	if err := writeJSON(filepath.Join(imagedir, "imagedb", "content", "sha256", dockerImageID.Encoded()), imageconfig); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(imagedir, "layerdb", "mounts", dockerContainerID, "mount-id"), dockerMountID); err != nil {
+		return err
+	}
+
+	if driver == "vfs" {
+		// Each vfs layer is a full copy of its parent.
+		if err := writeImageLayer(filepath.Join(root, "vfs", "dir", dockerLayerID)); err != nil {
+			return err
+		}
+		return writeFullCopy(filepath.Join(root, "vfs", "dir", dockerMountID))
+	}
+
+	// The lower file lists the shortened links of the image layers.
+	overlaydir := filepath.Join(root, "overlay2")
+	if err := writeImageLayer(filepath.Join(overlaydir, dockerLayerID, "diff")); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(overlaydir, dockerLayerID, "link"), dockerLayerLink); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(overlaydir, "l"), 0700); err != nil {
+		return err
+	}
+	if err := os.Symlink(filepath.Join("..", dockerLayerID, "diff"), filepath.Join(overlaydir, "l", dockerLayerLink)); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(overlaydir, dockerMountID, "lower"), filepath.Join("l", dockerLayerLink)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(overlaydir, dockerMountID, "work"), 0700); err != nil {
+		return err
+	}
+	return writeUpperLayer(filepath.Join(overlaydir, dockerMountID, "diff"))
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selftest
+
+import (
+	"path/filepath"
+
+	"github.com/google/container-explorer/explorers/lxd"
+	"github.com/opencontainers/go-digest"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// lxdInstanceName is the name of the LXD reference instance.
+	lxdInstanceName = "selftest"
+
+	// lxdImageName is the description of the LXD image. LXD images are
+	// named using the description of the instances created from the image.
+	lxdImageName = "container-explorer self test"
+)
+
+var lxdImageFingerprint = digest.FromString("selftest lxd image").Encoded()
+
+func lxdFixture() Fixture {
+	return Fixture{
+		Name:    "lxd",
+		Runtime: "lxd",
+		Containers: []Container{
+			{ID: lxdInstanceName, Image: lxdImageName},
+		},
+		Images: []string{lxdImageName},
+		Info:   true,
+		build:  buildLXD,
+	}
+}
+
+// buildLXD writes the instance backup.yaml, the instance root filesystem of
+// a dir storage pool, and the split image files.
+func buildLXD(imageroot string) error {
+	root := filepath.Join(imageroot, "var", "lib", "lxd")
+	dir := filepath.Join(root, "storage-pools", "default", "containers", lxdInstanceName)
+
+	backup := lxd.BackupFile{
+		Instance: &lxd.InstanceConfig{
+			Name:         lxdInstanceName,
+			Type:         "container",
+			Project:      "default",
+			Architecture: "x86_64",
+			Status:       "Stopped",
+			Profiles:     []string{"default"},
+			Config: map[string]string{
+				"image.description":         lxdImageName,
+				"volatile.base_image":       lxdImageFingerprint,
+				"volatile.last_state.power": "STOPPED",
+				"user.selftest":             "lxd",
+			},
+			CreatedAt:  createdAt,
+			LastUsedAt: createdAt,
+		},
+		Pool: &lxd.StoragePoolConfig{
+			Name:   "default",
+			Driver: "dir",
+		},
+	}
+	data, err := yaml.Marshal(backup)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, "backup.yaml"), string(data)); err != nil {
+		return err
+	}
+
+	// The root filesystem of a dir storage pool is a full copy of the
+	// image.
+	if err := writeFullCopy(filepath.Join(dir, "rootfs")); err != nil {
+		return err
+	}
+
+	// A split image is stored as <fingerprint> holding the metadata and
+	// <fingerprint>.rootfs holding the root filesystem.
+	if err := writeFile(filepath.Join(root, "images", lxdImageFingerprint), "selftest metadata\n"); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(root, "images", lxdImageFingerprint+".rootfs"), "selftest rootfs\n")
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package selftest builds miniature reference evidence for each supported
+// container runtime and snapshotter.
+//
+// The reference evidence is written by the package rather than stored as
+// binary files, so the databases use the same schema and encoding as the
+// runtimes. The self test explores the reference evidence using the
+// explorers of the build and compares the results with the expected values.
+package selftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// ImageName is the image of the reference containers.
+	ImageName = "docker.io/library/selftest:1.0"
+
+	// osReleaseContent is the content of /etc/os-release in the image
+	// layer.
+	osReleaseContent = "ID=selftest\nNAME=\"container-explorer self test\"\n"
+
+	// evidenceContent is the content of the file created in the upper
+	// layer.
+	evidenceContent = "container-explorer self test evidence\n"
+
+	// whiteoutPrefix is the prefix of the AUFS style whiteout files.
+	whiteoutPrefix = ".wh."
+)
+
+var (
+	// PresentFiles are the files visible in the reference containers.
+	PresentFiles = []string{"/etc/os-release", "/selftest/evidence.txt"}
+
+	// DeletedFiles are the files of the image layer deleted in the upper
+	// layer of the reference containers.
+	DeletedFiles = []string{"/etc/selftest-deleted"}
+
+	// createdAt is the creation time of the reference objects.
+	createdAt = time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+)
+
+// Container is a container expected in the reference evidence.
+type Container struct {
+	ID       string `json:"id"`
+	Image    string `json:"image"`
+	Task     string `json:"task,omitempty"`     // container type and status of the task
+	Platform string `json:"platform,omitempty"` // platform of the layers that cannot be walked on Linux
+}
+
+// Fixture is the reference evidence of a container runtime.
+type Fixture struct {
+	Name       string      `json:"name"`
+	Runtime    string      `json:"runtime"` // runtime detected in the image root
+	Containers []Container `json:"containers"`
+	Images     []string    `json:"images"`
+	Snapshots  bool        `json:"snapshots"` // snapshots are listed
+	Info       bool        `json:"info"`      // container information is read
+	Tasks      bool        `json:"tasks"`     // container tasks are listed
+
+	build func(imageroot string) error
+}
+
+// Build writes the reference evidence to the image root.
+func (f Fixture) Build(imageroot string) error {
+	if err := f.build(imageroot); err != nil {
+		return fmt.Errorf("building %s reference evidence: %w", f.Name, err)
+	}
+	return nil
+}
+
+// Fixtures returns the reference evidence of the supported container
+// runtimes and snapshotters.
+//
+// The storage drivers keeping the layers on zfs datasets or device mapper
+// thin devices are not covered because the reference evidence is written
+// without privileges.
+func Fixtures() []Fixture {
+	return []Fixture{
+		containerdFixture(),
+		containerdWindowsFixture(),
+		dockerFixture("overlay2"),
+		dockerFixture("vfs"),
+		crioFixture("overlay"),
+		crioFixture("vfs"),
+		podmanFixture(),
+		lxdFixture(),
+	}
+}
+
+// writeImageLayer writes the files of the image layer.
+func writeImageLayer(dir string) error {
+	if err := writeFile(filepath.Join(dir, "etc", "os-release"), osReleaseContent); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, "etc", "selftest-deleted"), "deleted in the container\n")
+}
+
+// writeUpperLayer writes the files of the container upper layer. The file
+// deleted in the container is recorded using an AUFS style whiteout, so the
+// reference evidence is written without privileges.
+func writeUpperLayer(dir string) error {
+	if err := writeFile(filepath.Join(dir, "selftest", "evidence.txt"), evidenceContent); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, "etc", whiteoutPrefix+"selftest-deleted"), "")
+}
+
+// writeFullCopy writes the container files of a full copy snapshotter.
+func writeFullCopy(dir string) error {
+	if err := writeFile(filepath.Join(dir, "etc", "os-release"), osReleaseContent); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, "selftest", "evidence.txt"), evidenceContent)
+}
+
+// writeFile writes a file and creates the parent directories. The
+// modification time is the creation time of the reference objects, so the
+// copies of a file in a full copy snapshot are unchanged as they are when
+// copied by the runtime.
+func writeFile(path string, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Chtimes(path, createdAt, createdAt)
+}
+
+// writeJSON writes v as JSON to a file and creates the parent directories.
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, string(data))
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selftest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/google/container-explorer/explorers/crio"
+	"github.com/google/container-explorer/explorers/podman"
+	"github.com/opencontainers/go-digest"
+	spec "github.com/opencontainers/runtime-spec/specs-go"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	storageContainerID = digest.FromString("selftest storage container").Encoded()
+	storageImageID     = digest.FromString("selftest storage image").Encoded()
+	storageLayerID     = digest.FromString("selftest storage layer").Encoded()
+	storageCtrLayerID  = digest.FromString("selftest storage container layer").Encoded()
+)
+
+// crioFixture returns the fixture of a containers storage graph driver i.e.
+// overlay or vfs.
+func crioFixture(driver string) Fixture {
+	name := "crio"
+	if driver != "overlay" {
+		name += "-" + driver
+	}
+	return Fixture{
+		Name:    name,
+		Runtime: "crio",
+		Containers: []Container{
+			{ID: storageContainerID, Image: ImageName},
+		},
+		Images: []string{ImageName},
+		Info:   true,
+		build: func(imageroot string) error {
+			return buildContainersStorage(imageroot, driver, false)
+		},
+	}
+}
+
+func podmanFixture() Fixture {
+	return Fixture{
+		Name:    "podman",
+		Runtime: "podman",
+		Containers: []Container{
+			{ID: storageContainerID, Image: ImageName},
+		},
+		Images: []string{ImageName},
+		Info:   true,
+		build: func(imageroot string) error {
+			return buildContainersStorage(imageroot, "overlay", true)
+		},
+	}
+}
+
+// buildContainersStorage writes the containers storage records, the
+// container spec, and the layer directories of the graph driver shared by
+// CRI-O and Podman. The Podman reference evidence also has the libpod database.
+//
+// The image name of a CRI-O container is read from the container metadata
+// and the image name of a Podman container from the libpod database.
+func buildContainersStorage(imageroot string, driver string, libpod bool) error {
+	root := filepath.Join(imageroot, "var", "lib", "containers", "storage")
+
+	var metadata string
+	if !libpod {
+		data, err := json.Marshal(crio.ContainerMetadata{
+			PodName:       "selftest",
+			ImageName:     ImageName,
+			ImageID:       storageImageID,
+			ContainerName: "selftest",
+		})
+		if err != nil {
+			return err
+		}
+		metadata = string(data)
+	}
+
+	ctrs := []crio.Container{{
+		ID:       storageContainerID,
+		Names:    []string{"selftest"},
+		ImageID:  storageImageID,
+		LayerID:  storageCtrLayerID,
+		Metadata: metadata,
+		Created:  createdAt,
+	}}
+	if err := writeJSON(filepath.Join(root, driver+"-containers", "containers.json"), ctrs); err != nil {
+		return err
+	}
+	// The cgroups path uses the systemd cgroup driver notation
+	// <slice>:<prefix>:<container id>.
+	cgroupspath := "kubepods.slice:crio:" + storageContainerID
+	if libpod {
+		cgroupspath = "machine.slice:libpod:" + storageContainerID
+	}
+	ctrspec := spec.Spec{
+		Version:  spec.Version,
+		Hostname: "selftest",
+		Process:  &spec.Process{Args: []string{"/bin/sh"}, Cwd: "/"},
+		Root:     &spec.Root{Path: "rootfs"},
+		Linux:    &spec.Linux{CgroupsPath: cgroupspath},
+	}
+	if err := writeJSON(filepath.Join(root, driver+"-containers", storageContainerID, "userdata", "config.json"), ctrspec); err != nil {
+		return err
+	}
+
+	imgs := []crio.Image{{
+		ID:       storageImageID,
+		Digest:   digest.FromString("selftest storage manifest").String(),
+		Names:    []string{ImageName},
+		TopLayer: storageLayerID,
+		Created:  createdAt,
+	}}
+	if err := writeJSON(filepath.Join(root, driver+"-images", "images.json"), imgs); err != nil {
+		return err
+	}
+	layers := []crio.Layer{
+		{ID: storageLayerID, Created: createdAt},
+		{ID: storageCtrLayerID, Parent: storageLayerID, Created: createdAt},
+	}
+	if err := writeJSON(filepath.Join(root, driver+"-layers", "layers.json"), layers); err != nil {
+		return err
+	}
+
+	if driver == "vfs" {
+		// Each vfs layer is a full copy of its parent.
+		if err := writeImageLayer(filepath.Join(root, "vfs", "dir", storageLayerID)); err != nil {
+			return err
+		}
+		if err := writeFullCopy(filepath.Join(root, "vfs", "dir", storageCtrLayerID)); err != nil {
+			return err
+		}
+	} else {
+		if err := writeImageLayer(filepath.Join(root, "overlay", storageLayerID, "diff")); err != nil {
+			return err
+		}
+		if err := writeUpperLayer(filepath.Join(root, "overlay", storageCtrLayerID, "diff")); err != nil {
+			return err
+		}
+	}
+
+	if !libpod {
+		return nil
+	}
+	return buildLibpod(root, ctrspec)
+}
+
+// buildLibpod writes the libpod bolt database bolt_state.db.
+func buildLibpod(root string, ctrspec spec.Spec) error {
+	dir := filepath.Join(root, "libpod")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	db, err := bolt.Open(filepath.Join(dir, "bolt_state.db"), 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	config, err := json.Marshal(podman.ContainerConfig{
+		ID:              storageContainerID,
+		Name:            "selftest",
+		RootfsImageID:   storageImageID,
+		RootfsImageName: ImageName,
+		Labels:          map[string]string{"selftest": "podman"},
+		CreatedTime:     createdAt,
+		Spec:            &ctrspec,
+	})
+	if err != nil {
+		return err
+	}
+	state, err := json.Marshal(podman.ContainerState{State: 6}) // exited
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		bkt, err := createBuckets(tx, []byte("ctr"), []byte(storageContainerID))
+		if err != nil {
+			return err
+		}
+		if err := bkt.Put([]byte("config"), config); err != nil {
+			return err
+		}
+		return bkt.Put([]byte("state"), state)
+	})
+}
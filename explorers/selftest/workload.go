@@ -0,0 +1,250 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
+	snapshotstorage "github.com/containerd/containerd/snapshots/storage"
+	"github.com/gogo/protobuf/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	spec "github.com/opencontainers/runtime-spec/specs-go"
+	bolt "go.etcd.io/bbolt"
+)
+
+// WorkloadImage is the image of the benchmark containers.
+const WorkloadImage = "docker.io/library/bench:1.0"
+
+// Workload is the size of the synthetic containerd evidence of a benchmark.
+type Workload struct {
+	Containers int `json:"containers"` // containers of the image
+	Layers     int `json:"layers"`     // image layers
+	Files      int `json:"files"`      // files in each image layer and upper layer
+	FileSize   int `json:"file_size"`  // file size in bytes
+}
+
+// BuildWorkload writes containerd evidence with an image of w.Layers layers
+// and w.Containers containers of the image using the overlayfs snapshotter.
+//
+// Each layer and each container upper layer holds w.Files files, so a
+// container filesystem holds (w.Layers + 1) * w.Files files. The databases
+// are written without fsync.
+func BuildWorkload(imageroot string, w Workload) error {
+	if w.Containers < 1 || w.Layers < 1 || w.Files < 0 || w.FileSize < 0 {
+		return fmt.Errorf("invalid workload %+v", w)
+	}
+
+	root := filepath.Join(imageroot, "var", "lib", "containerd")
+	metadir := filepath.Join(root, "io.containerd.metadata.v1.bolt")
+	if err := os.MkdirAll(metadir, 0700); err != nil {
+		return err
+	}
+
+	bdb, err := bolt.Open(filepath.Join(metadir, "meta.db"), 0644, &bolt.Options{NoSync: true})
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), containerdNamespace)
+	db := metadata.NewDB(bdb, nil, nil)
+	if err := db.Init(ctx); err != nil {
+		return err
+	}
+
+	_, err = metadata.NewImageStore(db).Create(ctx, images.Image{
+		Name: WorkloadImage,
+		Target: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromString("bench manifest"),
+			Size:      1,
+		},
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	})
+	if err != nil {
+		return fmt.Errorf("creating image: %w", err)
+	}
+
+	// The layer keys are chain IDs and the backend snapshot names are
+	// prefixed with the namespace and a sequence number.
+	var (
+		layerkeys  []string
+		layernames []string
+		ctrids     []string
+		ctrnames   []string
+	)
+	for i := 0; i < w.Layers; i++ {
+		key := digest.FromString(fmt.Sprintf("bench layer %d", i)).String()
+		layerkeys = append(layerkeys, key)
+		layernames = append(layernames, fmt.Sprintf("%s/%d/%s", containerdNamespace, i+1, key))
+	}
+	for i := 0; i < w.Containers; i++ {
+		id := fmt.Sprintf("bench-%06d", i)
+		ctrids = append(ctrids, id)
+		ctrnames = append(ctrnames, fmt.Sprintf("%s/%d/%s", containerdNamespace, w.Layers+i+1, id))
+	}
+
+	dir := filepath.Join(root, "io.containerd.snapshotter.v1.overlayfs")
+	if err := buildWorkloadSnapshots(dir, w, layernames, ctrnames); err != nil {
+		return fmt.Errorf("creating overlayfs snapshots: %w", err)
+	}
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		bkt, err := createBuckets(tx, []byte("v1"), []byte(containerdNamespace), []byte("snapshots"), []byte("overlayfs"))
+		if err != nil {
+			return err
+		}
+		parent := ""
+		for i, key := range layerkeys {
+			if err := putSnapshotKey(bkt, key, layernames[i], parent); err != nil {
+				return err
+			}
+			parent = key
+		}
+		for i, id := range ctrids {
+			if err := putSnapshotKey(bkt, id, ctrnames[i], parent); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("creating snapshot keys: %w", err)
+	}
+
+	specdata, err := json.Marshal(spec.Spec{
+		Version:  spec.Version,
+		Hostname: "bench",
+		Process:  &spec.Process{Args: []string{"/bin/sh"}, Cwd: "/"},
+		Root:     &spec.Root{Path: "rootfs"},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctrstore := metadata.NewContainerStore(db)
+	for _, id := range ctrids {
+		_, err := ctrstore.Create(ctx, containers.Container{
+			ID:          id,
+			Image:       WorkloadImage,
+			Labels:      map[string]string{"bench": "true"},
+			Runtime:     containers.RuntimeInfo{Name: "io.containerd.runc.v2"},
+			Spec:        &types.Any{TypeUrl: specTypeURL, Value: specdata},
+			Snapshotter: "overlayfs",
+			SnapshotKey: id,
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt,
+		})
+		if err != nil {
+			return fmt.Errorf("creating container %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// buildWorkloadSnapshots writes the snapshot database metadata.db with the
+// chain of the image layers and an active snapshot per container, and the
+// snapshot directories.
+func buildWorkloadSnapshots(dir string, w Workload, layernames []string, ctrnames []string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "snapshots"), 0700); err != nil {
+		return err
+	}
+	ms, err := snapshotstorage.NewMetaStore(filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		return err
+	}
+	defer ms.Close()
+
+	ctx, t, err := ms.TransactionContext(context.Background(), true)
+	if err != nil {
+		return err
+	}
+
+	var layerids, ctrids []string
+	parent := ""
+	for _, name := range layernames {
+		extract := name + "-extract"
+		layer, err := snapshotstorage.CreateSnapshot(ctx, snapshots.KindActive, extract, parent)
+		if err != nil {
+			t.Rollback()
+			return err
+		}
+		if _, err := snapshotstorage.CommitActive(ctx, extract, name, snapshots.Usage{}); err != nil {
+			t.Rollback()
+			return err
+		}
+		layerids = append(layerids, layer.ID)
+		parent = name
+	}
+	for _, name := range ctrnames {
+		ctr, err := snapshotstorage.CreateSnapshot(ctx, snapshots.KindActive, name, parent)
+		if err != nil {
+			t.Rollback()
+			return err
+		}
+		ctrids = append(ctrids, ctr.ID)
+	}
+	if err := t.Commit(); err != nil {
+		return err
+	}
+
+	for i, id := range layerids {
+		if err := writeWorkloadFiles(filepath.Join(dir, "snapshots", id, "fs", fmt.Sprintf("layer%d", i)), w); err != nil {
+			return err
+		}
+	}
+	for _, id := range ctrids {
+		if err := os.MkdirAll(filepath.Join(dir, "snapshots", id, "work"), 0700); err != nil {
+			return err
+		}
+		if err := writeWorkloadFiles(filepath.Join(dir, "snapshots", id, "fs", "data"), w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeWorkloadFiles writes w.Files files of w.FileSize bytes to dir. The
+// content of each file differs, so the files have distinct digests.
+func writeWorkloadFiles(dir string, w Workload) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i := 0; i < w.Files; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%06d", i))
+		content := []byte(path)
+		if len(content) < w.FileSize {
+			content = append(content, bytes.Repeat([]byte{'x'}, w.FileSize-len(content))...)
+		}
+		if err := os.WriteFile(path, content[:w.FileSize], 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package explorers
 
 import (
+	"context"
 	"time"
 
 	"github.com/containerd/containerd/snapshots"
@@ -41,4 +42,27 @@ type SnapshotKeyInfo struct {
 	Children    []string          // array of <snapshot key>. Only in meta.db
 	CreatedAt   time.Time         // created timestamp
 	UpdatedAt   time.Time         // updated timestamp
+	Device      string            // thin device path. Only used by devmapper
+	DeviceID    uint32            // thin device ID. Only used by devmapper
+	Pool        string            // thin pool name. Only used by devmapper
+	Dataset     string            // ZFS dataset. Only used by zfs
+	Remote      string            // materialized, blob, or incomplete. Only used by remote snapshots i.e. stargz
+}
+
+// SnapshotLayerExplorer is implemented by the explorers of the runtimes
+// keeping the container layers in snapshots i.e. containerd.
+type SnapshotLayerExplorer interface {
+	// SnapshotLayers returns the directory of a snapshot and the
+	// directories of its parent snapshots ordered from the nearest parent.
+	// The snapshot is specified using the snapshot key or the snapshot name
+	// in the snapshot database.
+	SnapshotLayers(ctx context.Context, snapshotter string, key string) (string, []string, error)
+}
+
+// ImageCopyExplorer is implemented by the explorers of the runtimes
+// supporting full copy storage i.e. the containerd native snapshotter.
+type ImageCopyExplorer interface {
+	// ContainerImageCopy returns the directory of the image snapshot the
+	// full copy snapshot of a container was copied from.
+	ContainerImageCopy(ctx context.Context, containerid string) (string, error)
 }
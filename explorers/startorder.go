@@ -0,0 +1,198 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Labels used to relate containers.
+const (
+	labelCRIContainerdKind = "io.cri-containerd.kind"
+	labelDockershimType    = "io.kubernetes.docker.type"
+	labelComposeProject    = "com.docker.compose.project"
+	labelComposeService    = "com.docker.compose.service"
+	labelComposeDependsOn  = "com.docker.compose.depends_on"
+)
+
+// Start time sources of a StartEvent.
+const (
+	StartTimeStarted = "started"
+	StartTimeCreated = "created"
+)
+
+// Container roles of a StartEvent.
+const (
+	RoleSandbox   = "sandbox"
+	RoleContainer = "container"
+	RoleService   = "service"
+)
+
+// StartEvent describes the start of a container in the reconstructed start
+// order.
+type StartEvent struct {
+	Sequence    int       `json:"sequence"`
+	Time        time.Time `json:"time"`
+	TimeSource  string    `json:"time_source"`
+	Namespace   string    `json:"namespace"`
+	ContainerID string    `json:"container_id"`
+	Group       string    `json:"group,omitempty"` // pod or compose project
+	Name        string    `json:"name,omitempty"`
+	Role        string    `json:"role"`
+	DependsOn   []string  `json:"depends_on,omitempty"`   // container IDs
+	OutOfOrder  []string  `json:"out_of_order,omitempty"` // dependencies started later
+}
+
+// StartOrder returns the approximate start order of containers.
+//
+// The start time is used if the runtime recorded it, otherwise the created
+// time is used. A Kubernetes or Podman pod container depends on the pod
+// sandbox (infra container) and a Docker Compose service depends on the
+// containers of the services in the com.docker.compose.depends_on label. A
+// dependency starting after the container is reported in OutOfOrder.
+func StartOrder(ctrs []Container) []StartEvent {
+	events := make([]StartEvent, 0, len(ctrs))
+	for _, ctr := range ctrs {
+		event := StartEvent{
+			Time:        ctr.StartedAt,
+			TimeSource:  StartTimeStarted,
+			Namespace:   ctr.Namespace,
+			ContainerID: ctr.ID,
+			Role:        RoleContainer,
+			Name:        ctr.Labels[LabelContainerName],
+		}
+		if event.Time.IsZero() {
+			event.Time = ctr.CreatedAt
+			event.TimeSource = StartTimeCreated
+		}
+
+		if isSandbox(ctr) {
+			event.Role = RoleSandbox
+			event.Name = ctr.Labels[LabelPodName]
+		}
+		if ns, pod := ctr.Labels[LabelPodNamespace], ctr.Labels[LabelPodName]; pod != "" {
+			event.Group = ns + "/" + pod
+		} else if ctr.PodName != "" {
+			event.Group = ctr.PodName
+			if event.Role == RoleSandbox {
+				event.Name = ctr.PodName
+			}
+		} else if project := ctr.Labels[labelComposeProject]; project != "" {
+			event.Group = project
+			event.Name = ctr.Labels[labelComposeService]
+			event.Role = RoleService
+		}
+		if event.Name == "" {
+			event.Name = ctr.Hostname
+		}
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.Time.IsZero() != b.Time.IsZero() {
+			return !a.Time.IsZero()
+		}
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		if (a.Role == RoleSandbox) != (b.Role == RoleSandbox) {
+			return a.Role == RoleSandbox
+		}
+		return a.ContainerID < b.ContainerID
+	})
+
+	// sandboxes by namespace and pod UID, services by namespace, project,
+	// and service name
+	sandboxes := make(map[string][]string)
+	services := make(map[string][]string)
+	for _, ctr := range ctrs {
+		if uid := podID(ctr); uid != "" && isSandbox(ctr) {
+			key := ctr.Namespace + "/" + uid
+			sandboxes[key] = append(sandboxes[key], ctr.ID)
+		}
+		if project := ctr.Labels[labelComposeProject]; project != "" {
+			key := ctr.Namespace + "/" + project + "/" + ctr.Labels[labelComposeService]
+			services[key] = append(services[key], ctr.ID)
+		}
+	}
+
+	sequence := make(map[string]int)
+	for i := range events {
+		events[i].Sequence = i + 1
+		sequence[events[i].Namespace+"/"+events[i].ContainerID] = i + 1
+	}
+
+	byid := make(map[string]Container)
+	for _, ctr := range ctrs {
+		byid[ctr.Namespace+"/"+ctr.ID] = ctr
+	}
+
+	for i, event := range events {
+		ctr := byid[event.Namespace+"/"+event.ContainerID]
+		ctrlabels := ctr.Labels
+
+		var deps []string
+		if uid := podID(ctr); uid != "" && event.Role != RoleSandbox {
+			deps = append(deps, sandboxes[event.Namespace+"/"+uid]...)
+		}
+		if project := ctrlabels[labelComposeProject]; project != "" {
+			for _, service := range composeDependencies(ctrlabels[labelComposeDependsOn]) {
+				deps = append(deps, services[event.Namespace+"/"+project+"/"+service]...)
+			}
+		}
+
+		events[i].DependsOn = deps
+		for _, dep := range deps {
+			if sequence[event.Namespace+"/"+dep] > event.Sequence {
+				events[i].OutOfOrder = append(events[i].OutOfOrder, dep)
+			}
+		}
+	}
+	return events
+}
+
+// isSandbox returns true if the container is a Kubernetes pod sandbox
+// (pause) container.
+func isSandbox(ctr Container) bool {
+	return ctr.ContainerType == RoleSandbox ||
+		ctr.Labels[labelCRIContainerdKind] == RoleSandbox ||
+		ctr.Labels[labelDockershimType] == "podsandbox"
+}
+
+// podID returns the Kubernetes pod UID or the Podman pod ID of a container.
+func podID(ctr Container) string {
+	if uid := ctr.Labels[LabelPodUID]; uid != "" {
+		return uid
+	}
+	return ctr.PodID
+}
+
+// composeDependencies returns the service names in the Docker Compose
+// depends_on label i.e. db:service_started:false,cache:service_healthy:true.
+func composeDependencies(label string) []string {
+	var services []string
+	for _, dep := range strings.Split(label, ",") {
+		service := strings.TrimSpace(strings.Split(dep, ":")[0])
+		if service != "" {
+			services = append(services, service)
+		}
+	}
+	return services
+}
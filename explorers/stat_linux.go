@@ -0,0 +1,77 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// StatInfo returns the inode attributes of a file.
+func StatInfo(info os.FileInfo) FileStat {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileStat{}
+	}
+	return FileStat{
+		Inode: stat.Ino,
+		UID:   stat.Uid,
+		GID:   stat.Gid,
+		Atime: time.Unix(stat.Atim.Unix()),
+		Ctime: time.Unix(stat.Ctim.Unix()),
+	}
+}
+
+// fileXattrs returns the extended attributes of a file. Symbolic links are
+// not followed.
+func fileXattrs(path string) map[string]string {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		vsize, err := unix.Lgetxattr(path, string(name), nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, vsize)
+		if vsize > 0 {
+			if vsize, err = unix.Lgetxattr(path, string(name), value); err != nil {
+				continue
+			}
+		}
+		xattrs[string(name)] = string(value[:vsize])
+	}
+	return xattrs
+}
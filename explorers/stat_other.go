@@ -0,0 +1,36 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import "os"
+
+// StatInfo returns the inode attributes of a file.
+//
+// The inode attributes are only supported on Linux.
+func StatInfo(info os.FileInfo) FileStat {
+	return FileStat{}
+}
+
+// fileXattrs returns the extended attributes of a file.
+//
+// Extended attributes are not read on this platform.
+func fileXattrs(path string) map[string]string {
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/container-explorer/explorers"
+)
+
+func init() {
+	Register("btrfs", snapshotterBtrfs{})
+}
+
+// snapshotterBtrfs is the containerd btrfs snapshotter and the docker btrfs
+// graph driver.
+//
+// Each snapshot is a btrfs subvolume. The committed snapshots are read-only
+// subvolumes in <root>/snapshots/<id> and the active snapshots are writable
+// subvolume snapshots of their parent in <root>/active/<id>. A subvolume
+// snapshot contains all the files of its parent and the container root
+// filesystem is the active subvolume.
+//
+// Each docker layer is a subvolume snapshot of its parent in
+// <root>/subvolumes/<id> where root is /var/lib/docker/btrfs.
+type snapshotterBtrfs struct{}
+
+func (snapshotterBtrfs) LayerDir(root string, id string, active bool) (string, error) {
+	dir := filepath.Join("snapshots", id)
+	if active {
+		dir = filepath.Join("active", id)
+	}
+	if root != "" && explorers.PathExists(filepath.Join(root, "subvolumes"), false) {
+		dir = filepath.Join("subvolumes", id)
+	}
+	if root == "" {
+		return dir, nil
+	}
+
+	dir = filepath.Join(root, dir)
+	if _, err := os.Stat(dir); err != nil {
+		// The nested subvolumes are not visible if the filesystem is
+		// mounted using a subvolume other than the top-level subvolume.
+		return "", fmt.Errorf("btrfs subvolume %s not found. Mount the btrfs filesystem using subvolid=5: %w", dir, err)
+	}
+	return dir, nil
+}
+
+func (snapshotterBtrfs) FullCopy() bool {
+	return true
+}
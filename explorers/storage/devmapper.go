@@ -0,0 +1,173 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+func init() {
+	Register("devmapper", snapshotterDevmapper{})
+}
+
+const (
+	// devmapperDevicesBucket is the bucket of the thin devices in the pool
+	// metadata database <root>/<pool name>.db.
+	devmapperDevicesBucket = "devices"
+
+	// devmapperDir is the directory of the active device mapper devices.
+	devmapperDir = "/dev/mapper"
+)
+
+// ThinDevice is a thin device of a containerd devmapper snapshotter pool.
+//
+// Reference to containerd source code
+// https://github.com/containerd/containerd/blob/main/snapshots/devmapper/metadata.go
+type ThinDevice struct {
+	Pool       string `json:"-"`
+	DeviceID   uint32 `json:"device_id"`
+	Size       uint64 `json:"size"`
+	Name       string `json:"name"`
+	ParentName string `json:"parent_name"`
+	State      int    `json:"state"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Path returns the device path of an active thin device.
+func (d ThinDevice) Path() string {
+	return filepath.Join(devmapperDir, d.Name)
+}
+
+// ReadThinDevices returns the thin devices of the devmapper snapshotter
+// pools.
+//
+// The root is the snapshotter root directory i.e.
+// /var/lib/containerd/io.containerd.snapshotter.v1.devmapper. Each pool
+// keeps its metadata in <root>/<pool name>.db.
+func ReadThinDevices(root string) ([]ThinDevice, error) {
+	dbfiles, err := filepath.Glob(filepath.Join(root, "*.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []ThinDevice
+	for _, dbfile := range dbfiles {
+		if filepath.Base(dbfile) == "metadata.db" {
+			continue // snapshot database
+		}
+		pool := strings.TrimSuffix(filepath.Base(dbfile), ".db")
+
+		db, err := explorers.OpenBolt(dbfile, 5*time.Second)
+		if err != nil {
+			log.WithField("path", dbfile).Warn("opening devmapper pool metadata: ", err)
+			continue
+		}
+
+		db.View(func(tx *bolt.Tx) error {
+			bkt := tx.Bucket([]byte(devmapperDevicesBucket))
+			if bkt == nil {
+				return nil
+			}
+			return bkt.ForEach(func(k, v []byte) error {
+				device := ThinDevice{Pool: pool}
+				if err := json.Unmarshal(v, &device); err != nil {
+					log.WithField("device", string(k)).Debug("unmarshalling thin device: ", err)
+					return nil
+				}
+				if device.Name == "" {
+					device.Name = string(k)
+				}
+				devices = append(devices, device)
+				return nil
+			})
+		})
+		db.Close()
+	}
+	return devices, nil
+}
+
+// FindThinDevice returns the thin device of a snapshot.
+//
+// The devmapper snapshotter names the thin device of a snapshot
+// <pool name>-snap-<snapshot id>.
+func FindThinDevice(root string, id string) (ThinDevice, error) {
+	devices, err := ReadThinDevices(root)
+	if err != nil {
+		return ThinDevice{}, err
+	}
+	for _, device := range devices {
+		if device.Name == fmt.Sprintf("%s-snap-%s", device.Pool, id) {
+			return device, nil
+		}
+	}
+	return ThinDevice{}, fmt.Errorf("thin device of snapshot %s does not exist in %s", id, root)
+}
+
+// snapshotterDevmapper is the containerd devmapper snapshotter.
+//
+// Each snapshot is a thin device containing a full copy of the filesystem.
+// A thin device is mounted read-only if the thin pool is active on the
+// analysis host i.e. after activating the pool using dmsetup or LVM.
+type snapshotterDevmapper struct{}
+
+func (snapshotterDevmapper) LayerDir(root string, id string, active bool) (string, error) {
+	if root == "" {
+		return "", fmt.Errorf("devmapper snapshot %s is a thin device", id)
+	}
+
+	device, err := FindThinDevice(root, id)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(device.Path()); err != nil {
+		return "", fmt.Errorf("thin device %s (device id %d) of pool %s is not active. Activate the thin pool to mount the snapshot", device.Name, device.DeviceID, device.Pool)
+	}
+
+	mountpoint := filepath.Join(os.TempDir(), "container-explorer-devmapper", device.Name)
+	if err := os.MkdirAll(mountpoint, 0755); err != nil {
+		return "", err
+	}
+
+	// The device may already be mounted by a previous command.
+	if entries, err := os.ReadDir(mountpoint); err == nil && len(entries) > 0 {
+		return mountpoint, nil
+	}
+
+	// The filesystem journal is not replayed to avoid modifying the device.
+	if err := explorers.MountDevice(device.Path(), mountpoint); err != nil {
+		return "", fmt.Errorf("mounting thin device: %w", err)
+	}
+	log.WithFields(log.Fields{
+		"device":     device.Path(),
+		"mountpoint": mountpoint,
+	}).Info("mounted thin device")
+	return mountpoint, nil
+}
+
+func (snapshotterDevmapper) FullCopy() bool {
+	return true
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register("devicemapper", graphDevicemapper{})
+}
+
+// DockerDeviceInfo is the metadata of a thin device of the docker
+// devicemapper graph driver i.e. /var/lib/docker/devicemapper/metadata/<id>
+//
+// Reference to moby source code
+// https://github.com/moby/moby/blob/master/daemon/graphdriver/devmapper/deviceset.go
+type DockerDeviceInfo struct {
+	DeviceID      int    `json:"device_id"`
+	Size          uint64 `json:"size"`
+	TransactionID uint64 `json:"transaction_id"`
+	Initialized   bool   `json:"initialized"`
+	Deleted       bool   `json:"deleted"`
+}
+
+// ReadDockerDeviceInfo returns the thin device metadata of a docker layer.
+//
+// The root is the graph driver root directory i.e.
+// /var/lib/docker/devicemapper.
+func ReadDockerDeviceInfo(root string, id string) (DockerDeviceInfo, error) {
+	var info DockerDeviceInfo
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "metadata", id))
+	if err != nil {
+		return info, fmt.Errorf("reading thin device metadata of layer %s: %w", id, err)
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("unmarshalling thin device metadata of layer %s: %w", id, err)
+	}
+	return info, nil
+}
+
+// graphDevicemapper is the legacy docker devicemapper graph driver.
+//
+// Each layer is a thin device of the docker thin pool containing a full copy
+// of the filesystem. The thin device of a layer is named
+// docker-<major>:<minor>-<inode>-<id> and the layer files are in the rootfs
+// directory of the thin device filesystem.
+type graphDevicemapper struct{}
+
+// LayerDir returns the rootfs directory of the thin device of a layer
+// mounted on the analysis host.
+//
+// The thin device is not mounted by LayerDir. Use the mount command or
+// mount the device read-only.
+func (graphDevicemapper) LayerDir(root string, id string, active bool) (string, error) {
+	if root == "" {
+		return "", fmt.Errorf("devicemapper layer %s is a thin device", id)
+	}
+
+	device, err := dockerThinDevice(root, id)
+	if err != nil {
+		return "", err
+	}
+	mountpoint, err := deviceMountPoint(device)
+	if err != nil {
+		return "", fmt.Errorf("reading mounts: %w", err)
+	}
+	if mountpoint == "" {
+		return "", fmt.Errorf("thin device %s of layer %s is not mounted. Use the mount command or mount the device read-only i.e. mount -o ro,noload %s <dir>", device, id, device)
+	}
+	return filepath.Join(mountpoint, "rootfs"), nil
+}
+
+// MountLayer mounts the thin device of a layer read-only on a temporary
+// directory and returns the rootfs directory.
+//
+// The thin pool must be active on the analysis host and the thin device
+// must be created i.e. using dmsetup.
+func (graphDevicemapper) MountLayer(root string, id string, active bool) (string, func() error, error) {
+	device, err := dockerThinDevice(root, id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The device may be mounted by the examiner.
+	mountpoint, err := deviceMountPoint(device)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading mounts: %w", err)
+	}
+	if mountpoint != "" {
+		return filepath.Join(mountpoint, "rootfs"), func() error { return nil }, nil
+	}
+
+	mountpoint, err = os.MkdirTemp("", "container-explorer-devicemapper-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The filesystem journal is not replayed to avoid modifying the device.
+	if err := explorers.MountDevice(device, mountpoint); err != nil {
+		os.Remove(mountpoint)
+		return "", nil, fmt.Errorf("mounting thin device: %w", err)
+	}
+	log.WithFields(log.Fields{
+		"device":     device,
+		"mountpoint": mountpoint,
+	}).Debug("mounted thin device")
+
+	release := func() error {
+		if err := explorers.Unmount(mountpoint); err != nil {
+			return err
+		}
+		return os.Remove(mountpoint)
+	}
+	return filepath.Join(mountpoint, "rootfs"), release, nil
+}
+
+// dockerThinDevice returns the active thin device of a layer.
+func dockerThinDevice(root string, id string) (string, error) {
+	info, err := ReadDockerDeviceInfo(root, id)
+	if err != nil {
+		return "", err
+	}
+
+	devices, _ := filepath.Glob(filepath.Join(devmapperDir, "*-"+id))
+	if len(devices) == 0 {
+		return "", fmt.Errorf("thin device of layer %s (device id %d) is not active. Activate the thin pool and create the device i.e. dmsetup create docker-%s --table '0 %d thin <pool device> %d'", id, info.DeviceID, id, info.Size/512, info.DeviceID)
+	}
+	return devices[0], nil
+}
+
+func (graphDevicemapper) FullCopy() bool {
+	return true
+}
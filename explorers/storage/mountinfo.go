@@ -0,0 +1,63 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mountInfoFile lists the mounts of the analysis host.
+const mountInfoFile = "/proc/self/mountinfo"
+
+// deviceMountPoint returns the mount point of the filesystem root of a
+// device or an empty string if the device is not mounted.
+//
+// The device is matched using its path or the device node it links to i.e.
+// /dev/dm-3 for /dev/mapper/<name>. A bind mount of a directory within the
+// device is not the filesystem root and is ignored.
+func deviceMountPoint(device string) (string, error) {
+	sources := map[string]bool{device: true}
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		sources[resolved] = true
+	}
+
+	f, err := os.Open(mountInfoFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// mountinfo fields: id parent major:minor root mountpoint options
+	// [optional fields] - fstype source superoptions
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for i, field := range fields {
+			if field != "-" || i < 5 || i+2 >= len(fields) {
+				continue
+			}
+			if fields[3] == "/" && sources[fields[i+2]] {
+				return strings.ReplaceAll(fields[4], `\040`, " "), nil
+			}
+			break
+		}
+	}
+	return "", scanner.Err()
+}
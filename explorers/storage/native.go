@@ -0,0 +1,39 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import "path/filepath"
+
+func init() {
+	Register("native", snapshotterNative{})
+}
+
+// snapshotterNative is the containerd native snapshotter used when overlayfs
+// is not available.
+//
+// Each snapshot is a full copy of its parent in <root>/snapshots/<id>. The
+// active snapshot of a container is bind mounted as the container root
+// filesystem.
+type snapshotterNative struct{}
+
+func (snapshotterNative) LayerDir(root string, id string, active bool) (string, error) {
+	return filepath.Join(root, "snapshots", id), nil
+}
+
+func (snapshotterNative) FullCopy() bool {
+	return true
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"path/filepath"
+
+	"github.com/google/container-explorer/explorers"
+)
+
+func init() {
+	Register("overlayfs", snapshotterOverlay{})
+	Register("overlay2", graphOverlay{})
+	Register("overlay", graphOverlay{})
+	Register("fuse-overlayfs", fuseOverlay{})
+	Register("stargz", snapshotterOverlay{})
+}
+
+// snapshotterOverlay is the containerd overlayfs snapshotter and the stargz
+// snapshotter.
+//
+// The snapshot files are in <root>/snapshots/<id>/fs. The lazily pulled
+// stargz snapshots are mounted using FUSE in the same directory.
+type snapshotterOverlay struct{}
+
+func (snapshotterOverlay) LayerDir(root string, id string, active bool) (string, error) {
+	return filepath.Join(root, "snapshots", id, "fs"), nil
+}
+
+// graphOverlay is the docker overlay2 and containers storage overlay graph
+// driver.
+//
+// The layer files are in <root>/<id>/diff.
+type graphOverlay struct{}
+
+func (graphOverlay) LayerDir(root string, id string, active bool) (string, error) {
+	return filepath.Join(root, id, "diff"), nil
+}
+
+// fuseOverlay is the containerd fuse-overlayfs snapshotter and the docker
+// fuse-overlayfs graph driver used by rootless containers.
+//
+// The containerd snapshotter uses the overlayfs snapshotter layout and the
+// docker graph driver uses the overlay2 layout. The docker layout is
+// detected by the directory l containing the shortened layer links.
+type fuseOverlay struct{}
+
+func (fuseOverlay) LayerDir(root string, id string, active bool) (string, error) {
+	if root != "" && explorers.PathExists(filepath.Join(root, "l"), false) {
+		return graphOverlay{}.LayerDir(root, id, active)
+	}
+	return snapshotterOverlay{}.LayerDir(root, id, active)
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package storage provides the registry of storage drivers i.e. containerd
+// snapshotters and docker graph drivers.
+//
+// A storage driver maps a snapshot or layer to the directory containing its
+// files. External packages add storage drivers by calling Register in an
+// init function and are enabled using a blank import:
+//
+//	import _ "example.com/vendor/snapshotter"
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Driver maps the snapshots of a containerd snapshotter or the layers of a
+// graph driver to directories.
+type Driver interface {
+	// LayerDir returns the directory containing the files of a snapshot or
+	// layer.
+	//
+	// The root is the driver root directory i.e.
+	// /var/lib/containerd/io.containerd.snapshotter.v1.overlayfs and id is
+	// the snapshot ID or layer ID. The active is true for the writable
+	// layer of a container. A relative path is returned if root is empty.
+	LayerDir(root string, id string, active bool) (string, error)
+}
+
+// FullCopyDriver is implemented by the storage drivers keeping a full copy
+// of the files in each snapshot i.e. the containerd native snapshotter.
+//
+// The files of a container are in the writable layer only and the layers
+// are not stacked using overlay.
+type FullCopyDriver interface {
+	Driver
+	FullCopy() bool
+}
+
+// PlatformDriver is implemented by the storage drivers of another platform
+// i.e. the containerd snapshotter of Windows nodes.
+//
+// The snapshots of a platform driver are listed but cannot be mounted on
+// Linux.
+type PlatformDriver interface {
+	Driver
+	Platform() string
+}
+
+// DeviceDriver is implemented by the storage drivers keeping each layer on
+// a block device i.e. the docker devicemapper graph driver.
+//
+// LayerDir returns the layer directory only if the device is already
+// mounted on the analysis host, so listing and reading the layers has no
+// side effect. MountLayer is used by the explicit mount commands.
+type DeviceDriver interface {
+	Driver
+
+	// MountLayer mounts the device of a layer read-only on a temporary
+	// directory and returns the layer directory. The release function
+	// unmounts the device and removes the temporary directory. A device
+	// already mounted is used as is and not released.
+	MountLayer(root string, id string, active bool) (string, func() error, error)
+}
+
+var (
+	mu      sync.RWMutex
+	drivers = make(map[string]Driver)
+)
+
+// Register makes a storage driver available by name i.e. overlayfs.
+//
+// Register panics if the driver is nil or a driver with the same name is
+// already registered.
+func Register(name string, driver Driver) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if driver == nil {
+		panic("storage: Register driver is nil")
+	}
+	if _, found := drivers[name]; found {
+		panic(fmt.Sprintf("storage: Register called twice for driver %s", name))
+	}
+	drivers[name] = driver
+}
+
+// Get returns the storage driver registered by name.
+func Get(name string) (Driver, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	driver, found := drivers[name]
+	if !found {
+		return nil, fmt.Errorf("unsupported storage driver %s", name)
+	}
+	return driver, nil
+}
+
+// IsFullCopy returns true if the storage driver registered by name keeps a
+// full copy of the files in each snapshot.
+func IsFullCopy(name string) bool {
+	driver, err := Get(name)
+	if err != nil {
+		return false
+	}
+	fc, ok := driver.(FullCopyDriver)
+	return ok && fc.FullCopy()
+}
+
+// Platform returns the platform of the storage driver registered by name or
+// an empty string for a Linux storage driver.
+func Platform(name string) string {
+	driver, err := Get(name)
+	if err != nil {
+		return ""
+	}
+	if pd, ok := driver.(PlatformDriver); ok {
+		return pd.Platform()
+	}
+	return ""
+}
+
+// Names returns the sorted names of the registered storage drivers.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var names []string
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
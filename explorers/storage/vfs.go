@@ -0,0 +1,38 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import "path/filepath"
+
+func init() {
+	Register("vfs", graphVFS{})
+}
+
+// graphVFS is the docker and containers storage vfs graph driver used where
+// no copy-on-write filesystem is available.
+//
+// Each layer is a full copy of its parent in <root>/dir/<id>. The files of a
+// container are in the container layer only.
+type graphVFS struct{}
+
+func (graphVFS) LayerDir(root string, id string, active bool) (string, error) {
+	return filepath.Join(root, "dir", id), nil
+}
+
+func (graphVFS) FullCopy() bool {
+	return true
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import "path/filepath"
+
+func init() {
+	Register("windows", snapshotterWindows{})
+	Register("windows-lcow", snapshotterWindows{})
+}
+
+// snapshotterWindows is the containerd snapshotter of Windows nodes.
+//
+// Each snapshot is a directory in <root>/snapshots/<id>. A Windows container
+// (WCOW) layer contains the Files and Hives directories and the container
+// scratch layer is a sandbox.vhdx. A Linux container on Windows (LCOW)
+// layer is a layer.vhd. The layers cannot be mounted on Linux.
+type snapshotterWindows struct{}
+
+func (snapshotterWindows) LayerDir(root string, id string, active bool) (string, error) {
+	return filepath.Join(root, "snapshots", id), nil
+}
+
+func (snapshotterWindows) Platform() string {
+	return "windows"
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/container-explorer/explorers"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register("zfs", snapshotterZFS{})
+}
+
+// snapshotterZFS is the containerd zfs snapshotter and the docker zfs graph
+// driver.
+//
+// The containerd snapshotter root directory is the mount point of a ZFS
+// dataset. Each active snapshot is a dataset <root dataset>/<id> cloned from
+// its parent and each committed snapshot is the ZFS snapshot
+// <root dataset>/<id>@snapshot.
+//
+// Each docker layer is a dataset <dataset>/<id> cloned from its parent where
+// the dataset contains the docker root directory. The graph driver root
+// directory is /var/lib/docker/zfs.
+//
+// The datasets use legacy mount points and are not mounted when the pool is
+// imported.
+type snapshotterZFS struct{}
+
+// LayerDir mounts the dataset of a snapshot read-only and returns the mount
+// point.
+//
+// The pool must be imported read-only on the analysis host and the root
+// dataset must be mounted at the snapshotter root directory i.e. using
+// zpool import -o readonly=on -R /mnt/case.
+func (snapshotterZFS) LayerDir(root string, id string, active bool) (string, error) {
+	if root == "" {
+		return id, nil
+	}
+
+	var (
+		dataset string
+		err     error
+	)
+	if explorers.PathExists(filepath.Join(root, "graph"), false) {
+		// The docker zfs graph driver root directory i.e.
+		// /var/lib/docker/zfs. The layer datasets are in the dataset
+		// containing the docker root directory.
+		var base string
+		base, _, err = zfsMountedDataset(filepath.Dir(root))
+		dataset = base + "/" + id
+		active = true
+	} else {
+		dataset, err = ZFSDataset(root, id, active)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	name := id
+	if !active {
+		name = id + "-snapshot"
+	}
+	mountpoint := filepath.Join(os.TempDir(), "container-explorer-zfs", name)
+	if err := os.MkdirAll(mountpoint, 0755); err != nil {
+		return "", err
+	}
+
+	// The dataset may already be mounted by a previous command.
+	if entries, err := os.ReadDir(mountpoint); err == nil && len(entries) > 0 {
+		return mountpoint, nil
+	}
+
+	if err := explorers.MountFilesystem(dataset, "zfs", mountpoint); err != nil {
+		return "", fmt.Errorf("mounting zfs dataset: %w", err)
+	}
+	log.WithFields(log.Fields{
+		"dataset":    dataset,
+		"mountpoint": mountpoint,
+	}).Info("mounted zfs dataset")
+	return mountpoint, nil
+}
+
+func (snapshotterZFS) FullCopy() bool {
+	return true
+}
+
+// ZFSDataset returns the dataset of a zfs snapshotter snapshot.
+func ZFSDataset(root string, id string, active bool) (string, error) {
+	rootdataset, err := zfsRootDataset(root)
+	if err != nil {
+		return "", err
+	}
+
+	dataset := rootdataset + "/" + id
+	if !active {
+		dataset += "@snapshot"
+	}
+	return dataset, nil
+}
+
+// zfsRootDataset returns the ZFS dataset mounted at the snapshotter root
+// directory.
+func zfsRootDataset(root string) (string, error) {
+	dataset, mountpoint, err := zfsMountedDataset(root)
+	if err != nil {
+		return "", err
+	}
+	if mountpoint != filepath.Clean(root) {
+		return "", fmt.Errorf("no zfs dataset mounted at %s. Import the pool read-only i.e. zpool import -o readonly=on -R <image root> <pool>", root)
+	}
+	return dataset, nil
+}
+
+// zfsMountedDataset returns the ZFS dataset containing a path and its mount
+// point.
+func zfsMountedDataset(path string) (string, string, error) {
+	f, err := os.Open(mountInfoFile)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	path = filepath.Clean(path)
+
+	var dataset, datasetmountpoint string
+
+	// mountinfo fields: id parent major:minor root mountpoint options
+	// [optional fields] - fstype source superoptions
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for i, field := range fields {
+			if field != "-" || i < 5 || i+2 >= len(fields) {
+				continue
+			}
+			mountpoint := strings.ReplaceAll(fields[4], `\040`, " ")
+			if fields[i+1] != "zfs" || len(mountpoint) <= len(datasetmountpoint) {
+				break
+			}
+			if mountpoint == path || strings.HasPrefix(path, strings.TrimSuffix(mountpoint, "/")+"/") {
+				dataset = fields[i+2]
+				datasetmountpoint = mountpoint
+			}
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if dataset == "" {
+		return "", "", fmt.Errorf("no zfs dataset mounted at %s. Import the pool read-only i.e. zpool import -o readonly=on -R <image root> <pool>", path)
+	}
+	return dataset, datasetmountpoint, nil
+}
@@ -0,0 +1,238 @@
+#!/usr/bin/env python3
+# Copyright 2021 Google LLC
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     https://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Writes the LUKS1 and LUKS2 volumes used by luks_test.go.
+
+The volumes follow the on-disk format of cryptsetup with the key derivation
+iterations lowered, so the tests run quickly. The implementation is
+independent of the Go code: PBKDF2 is hashlib.pbkdf2_hmac and the AES block
+cipher is the openssl command.
+
+  luks1.img  aes-cbc-essiv:sha256, 128-bit key, sha256, keyslot 0
+  luks2.img  aes-xts-plain64, 256-bit key, sha256, keyslot 0, primary and
+             secondary headers
+
+The passphrase is "container-explorer" and the volume data holds
+DATA_SECTORS sectors of 512 bytes starting with "LUKS<n> sector <i>".
+"""
+
+import base64
+import hashlib
+import json
+import os
+import struct
+import subprocess
+
+PASSPHRASE = b"container-explorer"
+ITERATIONS = 1000
+STRIPES = 4000
+SECTOR = 512
+DATA_SECTORS = 4
+
+
+def random_bytes(label, size):
+    """Returns deterministic bytes, so the volumes are reproducible."""
+    out = b""
+    counter = 0
+    while len(out) < size:
+        out += hashlib.sha256(b"%s %d" % (label, counter)).digest()
+        counter += 1
+    return out[:size]
+
+
+def ecb(key, data):
+    args = ["openssl", "enc", "-aes-%d-ecb" % (len(key) * 8), "-K", key.hex(), "-nopad"]
+    return subprocess.run(args, input=data, capture_output=True, check=True).stdout
+
+
+def xor(a, b):
+    return bytes(x ^ y for x, y in zip(a, b))
+
+
+def xts_encrypt(key, sector, data):
+    k1, k2 = key[: len(key) // 2], key[len(key) // 2 :]
+    t = ecb(k2, sector.to_bytes(16, "little"))
+    tweaks = []
+    for _ in range(len(data) // 16):
+        tweaks.append(t)
+        t = bytearray(t)
+        carry = t[15] >> 7
+        for j in range(15, 0, -1):
+            t[j] = ((t[j] << 1) & 0xFF) | (t[j - 1] >> 7)
+        t[0] = (t[0] << 1) & 0xFF
+        if carry:
+            t[0] ^= 0x87
+        t = bytes(t)
+    tweak = b"".join(tweaks)
+    return xor(ecb(k1, xor(data, tweak)), tweak)
+
+
+def cbc_essiv_encrypt(key, sector, data):
+    iv = ecb(hashlib.sha256(key).digest(), sector.to_bytes(16, "little"))
+    args = ["openssl", "enc", "-aes-%d-cbc" % (len(key) * 8), "-K", key.hex(), "-iv", iv.hex(), "-nopad"]
+    return subprocess.run(args, input=data, capture_output=True, check=True).stdout
+
+
+def encrypt_sectors(encrypt, key, data):
+    return b"".join(
+        encrypt(key, i // SECTOR, data[i : i + SECTOR]) for i in range(0, len(data), SECTOR)
+    )
+
+
+def af_split(key, stripes, label):
+    """Splits the key using the LUKS anti-forensic splitter with sha256."""
+
+    def diffuse(data):
+        out = b""
+        for i in range(0, len(data), 32):
+            block = data[i : i + 32]
+            out += hashlib.sha256(struct.pack(">I", i // 32) + block).digest()[: len(block)]
+        return out
+
+    material = random_bytes(label, len(key) * (stripes - 1))
+    d = bytes(len(key))
+    for i in range(stripes - 1):
+        d = diffuse(xor(d, material[i * len(key) : (i + 1) * len(key)]))
+    return material + xor(d, key)
+
+
+def pad(data, size):
+    return data + bytes(-len(data) % size)
+
+
+def volume_data(version):
+    return b"".join(
+        pad(b"LUKS%d sector %d\n" % (version, i), SECTOR) for i in range(DATA_SECTORS)
+    )
+
+
+def luks1():
+    volume_key = random_bytes(b"luks1 volume key", 16)
+    digest_salt = random_bytes(b"luks1 digest salt", 32)
+    slot_salt = random_bytes(b"luks1 keyslot salt", 32)
+    digest = hashlib.pbkdf2_hmac("sha256", volume_key, digest_salt, ITERATIONS, 20)
+
+    material = af_split(volume_key, STRIPES, b"luks1 stripes")
+    slot_key = hashlib.pbkdf2_hmac("sha256", PASSPHRASE, slot_salt, ITERATIONS, len(volume_key))
+    material = encrypt_sectors(cbc_essiv_encrypt, slot_key, pad(material, SECTOR))
+
+    material_offset = 8  # sectors
+    payload_offset = (material_offset + len(material) // SECTOR + 7) // 8 * 8
+
+    hdr = b"LUKS\xba\xbe" + struct.pack(">H", 1)
+    hdr += b"aes".ljust(32, b"\0") + b"cbc-essiv:sha256".ljust(32, b"\0")
+    hdr += b"sha256".ljust(32, b"\0")
+    hdr += struct.pack(">II", payload_offset, len(volume_key))
+    hdr += digest + digest_salt + struct.pack(">I", ITERATIONS)
+    hdr += b"6f1a5c43-1d2e-4b44-9d4e-2b7b5f0c1e01".ljust(40, b"\0")
+    hdr += struct.pack(">II", 0x00AC71F3, ITERATIONS) + slot_salt
+    hdr += struct.pack(">II", material_offset, STRIPES)
+    for i in range(1, 8):
+        hdr += struct.pack(">II", 0x0000DEAD, 0) + bytes(32)
+        hdr += struct.pack(">II", material_offset + i * 128, STRIPES)
+
+    image = pad(hdr, material_offset * SECTOR) + material
+    image = pad(image, payload_offset * SECTOR)
+    image += encrypt_sectors(cbc_essiv_encrypt, volume_key, volume_data(1))
+    return image
+
+
+def luks2_binary(magic, offset, metadata):
+    hdr_size = 16384
+    hdr = magic + struct.pack(">HQQ", 2, hdr_size, 1)
+    hdr += b"selftest".ljust(48, b"\0") + b"sha256".ljust(32, b"\0")
+    hdr += random_bytes(b"luks2 header salt %d" % offset, 64)
+    hdr += b"0c1f3b2a-5d6e-4f70-8a9b-0c1d2e3f4a5b".ljust(40, b"\0")
+    hdr += bytes(48) + struct.pack(">Q", offset)
+    hdr = pad(hdr, 4096) + metadata.ljust(hdr_size - 4096, b"\0")
+    csum = hashlib.sha256(hdr).digest()
+    return hdr[:448] + csum.ljust(64, b"\0") + hdr[512:]
+
+
+def luks2():
+    volume_key = random_bytes(b"luks2 volume key", 32)
+    digest_salt = random_bytes(b"luks2 digest salt", 32)
+    slot_salt = random_bytes(b"luks2 keyslot salt", 32)
+    digest = hashlib.pbkdf2_hmac("sha256", volume_key, digest_salt, ITERATIONS, 32)
+
+    material = af_split(volume_key, STRIPES, b"luks2 stripes")
+    slot_key = hashlib.pbkdf2_hmac("sha256", PASSPHRASE, slot_salt, ITERATIONS, len(volume_key))
+    material = encrypt_sectors(xts_encrypt, slot_key, pad(material, SECTOR))
+
+    area_offset = 32768
+    area_size = (len(material) + 4095) // 4096 * 4096
+    segment_offset = area_offset + area_size
+
+    b64 = lambda b: base64.b64encode(b).decode()
+    metadata = {
+        "keyslots": {
+            "0": {
+                "type": "luks2",
+                "key_size": len(volume_key),
+                "af": {"type": "luks1", "stripes": STRIPES, "hash": "sha256"},
+                "area": {
+                    "type": "raw",
+                    "offset": str(area_offset),
+                    "size": str(area_size),
+                    "encryption": "aes-xts-plain64",
+                    "key_size": len(volume_key),
+                },
+                "kdf": {
+                    "type": "pbkdf2",
+                    "hash": "sha256",
+                    "iterations": ITERATIONS,
+                    "salt": b64(slot_salt),
+                },
+            }
+        },
+        "tokens": {},
+        "segments": {
+            "0": {
+                "type": "crypt",
+                "offset": str(segment_offset),
+                "size": "dynamic",
+                "iv_tweak": "0",
+                "encryption": "aes-xts-plain64",
+                "sector_size": SECTOR,
+            }
+        },
+        "digests": {
+            "0": {
+                "type": "pbkdf2",
+                "keyslots": ["0"],
+                "segments": ["0"],
+                "hash": "sha256",
+                "iterations": ITERATIONS,
+                "salt": b64(digest_salt),
+                "digest": b64(digest),
+            }
+        },
+        "config": {"json_size": "12288", "keyslots_size": str(area_size)},
+    }
+    data = json.dumps(metadata).encode()
+
+    image = luks2_binary(b"LUKS\xba\xbe", 0, data)
+    image += luks2_binary(b"SKUL\xba\xbe", 16384, data)
+    image = pad(image, area_offset) + material
+    image = pad(image, segment_offset)
+    image += encrypt_sectors(xts_encrypt, volume_key, volume_data(2))
+    return image
+
+
+if __name__ == "__main__":
+    here = os.path.dirname(os.path.abspath(__file__))
+    for name, build in (("luks1.img", luks1), ("luks2.img", luks2)):
+        with open(os.path.join(here, name), "wb") as f:
+            f.write(build())
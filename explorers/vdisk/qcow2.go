@@ -0,0 +1,318 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+)
+
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+const (
+	// qcow2MaxBacking is the maximum depth of the backing file chain.
+	qcow2MaxBacking = 16
+
+	// qcow2OffsetMask is the host cluster offset of the L1 and the L2 table
+	// entries.
+	qcow2OffsetMask = 0x00fffffffffffe00
+
+	qcow2Compressed  = uint64(1) << 62
+	qcow2ZeroCluster = uint64(1)
+
+	// Incompatible features.
+	qcow2DirtyFeature       = uint64(1) << 0
+	qcow2ExternalData       = uint64(1) << 2
+	qcow2ExtendedL2Feature  = uint64(1) << 4
+	qcow2CompressionFeature = uint64(1) << 3
+
+	qcow2CompressionZstd = 1
+)
+
+// qcow2 is a QEMU copy-on-write disk image i.e. an OpenStack image.
+type qcow2 struct {
+	f           *os.File
+	size        int64
+	clusterBits uint32
+	clustersize int64
+	l1          []uint64
+	zstd        bool
+	backing     Disk
+
+	mu      sync.Mutex
+	l2      map[uint64][]uint64
+	cluster int64
+	data    []byte
+}
+
+// openQcow2 opens a qcow2 image and the backing file chain.
+func openQcow2(path string, depth int) (*qcow2, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	q, err := readQcow2(f, path, depth)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading qcow2 image %s: %w", path, err)
+	}
+	return q, nil
+}
+
+func readQcow2(f *os.File, path string, depth int) (*qcow2, error) {
+	header := make([]byte, 112)
+	if err := readFull(f, header, 0); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:4], qcow2Magic) {
+		return nil, fmt.Errorf("invalid qcow2 magic")
+	}
+
+	be := binary.BigEndian
+	version := be.Uint32(header[4:])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported qcow2 version %d", version)
+	}
+
+	q := &qcow2{
+		f:           f,
+		clusterBits: be.Uint32(header[20:]),
+		size:        int64(be.Uint64(header[24:])),
+		l2:          make(map[uint64][]uint64),
+		cluster:     -1,
+	}
+	if q.clusterBits < 9 || q.clusterBits > 21 {
+		return nil, fmt.Errorf("invalid cluster bits %d", q.clusterBits)
+	}
+	q.clustersize = int64(1) << q.clusterBits
+
+	if method := be.Uint32(header[32:]); method != 0 {
+		return nil, fmt.Errorf("encrypted qcow2 images are not supported")
+	}
+
+	if version == 3 {
+		incompatible := be.Uint64(header[72:])
+		if incompatible&qcow2DirtyFeature != 0 {
+			log.WithField("path", path).Warn("qcow2 image was not closed cleanly. The refcounts may be inconsistent")
+		}
+		if incompatible&qcow2ExternalData != 0 {
+			return nil, fmt.Errorf("qcow2 images with an external data file are not supported")
+		}
+		if incompatible&qcow2ExtendedL2Feature != 0 {
+			return nil, fmt.Errorf("qcow2 images with extended L2 entries are not supported")
+		}
+		if incompatible&qcow2CompressionFeature != 0 && be.Uint32(header[100:]) > 104 {
+			q.zstd = header[104] == qcow2CompressionZstd
+		}
+	}
+
+	if q.size < 0 {
+		return nil, fmt.Errorf("invalid virtual size %d", q.size)
+	}
+
+	// Like qemu, the L1 table must cover the virtual size. The entries
+	// after the virtual size are not used.
+	l1size := int64(be.Uint32(header[36:]))
+	l1offset := int64(be.Uint64(header[40:]))
+	l2size := q.clustersize * (q.clustersize / 8)
+	if needed := (q.size + l2size - 1) / l2size; l1size < needed {
+		return nil, fmt.Errorf("L1 table of %d entries is too small for the virtual size %d", l1size, q.size)
+	} else if l1size > needed {
+		l1size = needed
+	}
+	if err := checkTable(f, l1offset, l1size*8); err != nil {
+		return nil, fmt.Errorf("reading L1 table: %w", err)
+	}
+	table := make([]byte, l1size*8)
+	if err := readFull(f, table, l1offset); err != nil {
+		return nil, fmt.Errorf("reading L1 table: %w", err)
+	}
+	q.l1 = make([]uint64, l1size)
+	for i := range q.l1 {
+		q.l1[i] = be.Uint64(table[i*8:])
+	}
+
+	if offset, size := be.Uint64(header[8:]), be.Uint32(header[16:]); offset != 0 && size > 0 {
+		name := make([]byte, size)
+		if err := readFull(f, name, int64(offset)); err != nil {
+			return nil, fmt.Errorf("reading backing file name: %w", err)
+		}
+		backing, err := q.openBacking(path, string(name), depth)
+		if err != nil {
+			return nil, err
+		}
+		q.backing = backing
+	}
+	return q, nil
+}
+
+// openBacking opens the backing file of a qcow2 image. A relative backing
+// file name is relative to the directory of the image.
+func (q *qcow2) openBacking(path string, name string, depth int) (Disk, error) {
+	if depth >= qcow2MaxBacking {
+		return nil, fmt.Errorf("backing file chain is deeper than %d", qcow2MaxBacking)
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(path), name)
+	}
+	if _, err := os.Stat(name); err != nil {
+		// The backing file is usually the base image of the cloud
+		// provider. Reading the overlay only shows the changed clusters.
+		log.WithFields(log.Fields{
+			"path":    path,
+			"backing": name,
+		}).Warn("qcow2 backing file not found. Reading unallocated clusters as zero")
+		return nil, nil
+	}
+
+	if Detect(name) == FormatQcow2 {
+		backing, err := openQcow2(name, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return backing, nil
+	}
+	return OpenRaw(name)
+}
+
+// Size returns the virtual disk size.
+func (q *qcow2) Size() int64 {
+	return q.size
+}
+
+// Close closes the image and the backing files.
+func (q *qcow2) Close() error {
+	if q.backing != nil {
+		q.backing.Close()
+	}
+	return q.f.Close()
+}
+
+// ReadAt reads the media data at the offset.
+func (q *qcow2) ReadAt(p []byte, off int64) (int, error) {
+	return readBlocks(p, off, q.size, q.clustersize, q.readCluster)
+}
+
+// readCluster reads the guest cluster data.
+func (q *qcow2) readCluster(p []byte, cluster int64, within int64) error {
+	entry, err := q.l2Entry(cluster)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case entry&qcow2Compressed != 0:
+		data, err := q.compressedCluster(cluster, entry)
+		if err != nil {
+			return err
+		}
+		copy(p, data[within:])
+		return nil
+	case entry&qcow2ZeroCluster != 0:
+		zero(p)
+		return nil
+	case entry&qcow2OffsetMask != 0:
+		return readFull(q.f, p, int64(entry&qcow2OffsetMask)+within)
+	case q.backing != nil:
+		return readFull(q.backing, p, cluster*q.clustersize+within)
+	}
+	zero(p)
+	return nil
+}
+
+// l2Entry returns the L2 table entry of a guest cluster or zero for an
+// unallocated cluster.
+func (q *qcow2) l2Entry(cluster int64) (uint64, error) {
+	entries := q.clustersize / 8
+	index := cluster / entries
+	if index >= int64(len(q.l1)) {
+		return 0, nil
+	}
+	offset := q.l1[index] & qcow2OffsetMask
+	if offset == 0 {
+		return 0, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	table, found := q.l2[offset]
+	if !found {
+		buf := make([]byte, q.clustersize)
+		if err := readFull(q.f, buf, int64(offset)); err != nil {
+			return 0, fmt.Errorf("reading L2 table at offset %d: %w", offset, err)
+		}
+		table = make([]uint64, entries)
+		for i := range table {
+			table[i] = binary.BigEndian.Uint64(buf[i*8:])
+		}
+		q.l2[offset] = table
+	}
+	return table[cluster%entries], nil
+}
+
+// compressedCluster returns the decompressed data of a compressed cluster.
+//
+// The compressed cluster descriptor stores the host offset in the low bits
+// and the number of additional 512 byte sectors in the high bits.
+func (q *qcow2) compressedCluster(cluster int64, entry uint64) ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.cluster == cluster {
+		return q.data, nil
+	}
+
+	shift := 62 - (q.clusterBits - 8)
+	offset := entry & (uint64(1)<<shift - 1)
+	sectors := (entry&^qcow2Compressed)>>shift + 1
+	size := sectors*512 - offset%512
+
+	compressed := make([]byte, size)
+	if err := readFull(q.f, compressed, int64(offset)); err != nil {
+		return nil, fmt.Errorf("reading compressed cluster at offset %d: %w", offset, err)
+	}
+
+	var r io.Reader
+	if q.zstd {
+		d, err := zstd.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		r = d
+	} else {
+		r = flate.NewReader(bytes.NewReader(compressed))
+	}
+
+	data := make([]byte, q.clustersize)
+	if _, err := io.ReadFull(r, data); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("decompressing cluster at offset %d: %w", offset, err)
+	}
+	q.cluster = cluster
+	q.data = data
+	return data, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+const qcow2Cluster = 4096
+
+// qcow2Media returns the media data of testdata/qcow2.qcow2. The clusters
+// are allocated, zero, unallocated, deflate compressed, compressed,
+// allocated, unallocated, and preallocated zero. The unallocated clusters
+// are read from the backing file or are zero without it.
+func qcow2Media(backing string) []byte {
+	c := int64(qcow2Cluster)
+	return bytes.Join([][]byte{
+		sectors("qcow2", 0, c),
+		sectors("", 0, c),
+		sectors(backing, 2*c/512, c),
+		sectors("qcow2", 3*c/512, 2*c),
+		sectors("qcow2", 5*c/512, c),
+		sectors(backing, 6*c/512, c),
+		sectors("", 0, c),
+	}, nil)
+}
+
+func TestQcow2(t *testing.T) {
+	d := openDisk(t, filepath.Join("testdata", "qcow2.qcow2"), FormatQcow2)
+	checkMedia(t, d, qcow2Media("base"))
+
+	q := d.(*qcow2)
+	if _, ok := q.backing.(*rawDisk); !ok {
+		t.Errorf("backing file is %T, want a raw image", q.backing)
+	}
+}
+
+// TestQcow2MissingBacking reads the unallocated clusters as zero when the
+// backing file is not found.
+func TestQcow2MissingBacking(t *testing.T) {
+	dir := copyFixture(t, "qcow2.qcow2")
+	d := openDisk(t, filepath.Join(dir, "qcow2.qcow2"), FormatQcow2)
+	checkMedia(t, d, qcow2Media(""))
+}
+
+func TestQcow2Zstd(t *testing.T) {
+	d := openDisk(t, filepath.Join("testdata", "zstd.qcow2"), FormatQcow2)
+	if !d.(*qcow2).zstd {
+		t.Fatalf("zstd compression type not detected")
+	}
+	checkMedia(t, d, bytes.Join([][]byte{
+		sectors("zstd", 0, 3*qcow2Cluster),
+		sectors("", 0, qcow2Cluster),
+	}, nil))
+}
+
+// TestQcow2CorruptL1 checks that the L1 table size and offset of a corrupt
+// header are checked against the virtual size and the file size before the
+// table is allocated.
+func TestQcow2CorruptL1(t *testing.T) {
+	be32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, v)
+		return b
+	}
+	be64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v)
+		return b
+	}
+
+	for _, tc := range []struct {
+		name   string
+		offset int
+		value  []byte
+		want   string
+	}{
+		{"negative size", 24, be64(1 << 63), "invalid virtual size"},
+		{"empty L1 table", 36, be32(0), "too small for the virtual size"},
+		{"L1 table after the end of the file", 40, be64(1 << 40), "outside the file"},
+		// 1 PiB with 4 KiB clusters needs an L1 table of 4 GiB.
+		{"L1 table larger than the file", 24, append(be64(1<<50), append(be32(0), be32(1<<29)...)...), "outside the file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			checkOpenError(t, corruptFixture(t, "qcow2.qcow2", tc.offset, tc.value), tc.want)
+		})
+	}
+}
@@ -0,0 +1,414 @@
+#!/usr/bin/env python3
+# Copyright 2021 Google LLC
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     https://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Writes the virtual disks used by the vdisk tests.
+
+The virtual disks follow the on-disk formats of the qcow2, VMDK, VHD, and
+VHDX specifications with the block sizes lowered where the format allows it,
+so the files stay small. The implementation is independent of the Go code:
+deflate and zlib are the zlib module and zstd is the zstd command.
+
+Every 512 byte sector of the media data that is stored in a virtual disk
+holds "<label> sector <n>" where n is the sector number of the virtual disk.
+The other sectors are zero.
+
+  base.raw           backing file of qcow2.qcow2, label "base"
+  qcow2.qcow2        qcow2 v3, 4 KiB clusters, raw backing file, allocated,
+                     zero, preallocated zero, unallocated, and deflate
+                     compressed clusters, label "qcow2"
+  zstd.qcow2         qcow2 v3, 4 KiB clusters, zstd compressed clusters, no
+                     backing file, label "zstd"
+  sparse.vmdk        monolithic sparse, 8 KiB grains, allocated, zeroed, and
+                     unallocated grains, a partial last grain, label "sparse"
+  stream.vmdk        stream-optimized, 8 KiB compressed grains, label "stream"
+  flat.vmdk          monolithic flat descriptor of flat-flat.vmdk, label
+                     "flat"
+  split.vmdk         descriptor of the sparse extents split-s001.vmdk and
+                     split-s002.vmdk, label "split"
+  device.vmdk        descriptor of a flat extent at an offset of
+                     flat-flat.vmdk and a zero extent
+  fixed.vhd          fixed VHD, label "fixed"
+  dynamic.vhd        dynamic VHD, 4 KiB blocks, a partial last block, label
+                     "dynamic"
+  vhdx.vhdx.gz       VHDX, 1 MiB blocks, fully present, not present, and zero
+                     blocks, a partial last block, label "vhdx"
+"""
+
+import gzip
+import struct
+import subprocess
+import os
+import uuid
+import zlib
+
+SECTOR = 512
+
+
+def pad(data, size, fill=b"\0"):
+    return data + fill * (-len(data) % size)
+
+
+def sectors(label, offset, size):
+    """Returns the media data of the virtual disk at the offset."""
+    return b"".join(
+        pad(b"%s sector %d\n" % (label, n), SECTOR)
+        for n in range(offset // SECTOR, (offset + size) // SECTOR)
+    )
+
+
+def deflate(data):
+    c = zlib.compressobj(9, zlib.DEFLATED, -12)
+    return c.compress(data) + c.flush()
+
+
+def zstd(data):
+    return subprocess.run(["zstd", "-q", "-c", "--no-check"], input=data, capture_output=True, check=True).stdout
+
+
+# qcow2
+
+QCOW2_CLUSTER_BITS = 12
+QCOW2_CLUSTER = 1 << QCOW2_CLUSTER_BITS
+QCOW2_COPIED = 1 << 63
+QCOW2_COMPRESSED = 1 << 62
+QCOW2_ZERO = 1
+
+
+def qcow2(label, layout, backing, compression):
+    """Returns a qcow2 v3 image.
+
+    The layout lists the kind of every guest cluster: "data", "zero",
+    "prealloc" (allocated and zero), "compressed", or None (unallocated).
+    """
+    size = len(layout) * QCOW2_CLUSTER
+
+    # Cluster 0 is the header, 1 the refcount table, 2 the refcount block,
+    # 3 the L1 table, and 4 the L2 table. The data clusters follow.
+    refcount_table, refcount_block, l1_table, l2_table = 1, 2, 3, 4
+    clusters = [bytes(QCOW2_CLUSTER)] * 5
+    l2 = [0] * (QCOW2_CLUSTER // 8)
+
+    compressed = b""
+    compressed_at = []
+    for index, kind in enumerate(layout):
+        data = sectors(label, index * QCOW2_CLUSTER, QCOW2_CLUSTER)
+        if kind in ("data", "prealloc"):
+            l2[index] = len(clusters) * QCOW2_CLUSTER | QCOW2_COPIED
+            if kind == "prealloc":
+                l2[index] |= QCOW2_ZERO
+                data = bytes(QCOW2_CLUSTER)
+            clusters.append(data)
+        elif kind == "zero":
+            l2[index] = QCOW2_ZERO
+        elif kind == "compressed":
+            compressed_at.append((index, len(compressed)))
+            compressed += zstd(data) if compression == "zstd" else deflate(data)
+
+    # The compressed clusters are packed after the data clusters and do
+    # not start on a sector boundary.
+    start = len(clusters) * QCOW2_CLUSTER
+    csize_shift = 62 - (QCOW2_CLUSTER_BITS - 8)
+    ends = [offset for _, offset in compressed_at[1:]] + [len(compressed)]
+    for (index, offset), end in zip(compressed_at, ends):
+        host = start + offset
+        nb_sectors = (start + end + SECTOR - 1) // SECTOR - host // SECTOR
+        l2[index] = QCOW2_COMPRESSED | (nb_sectors - 1) << csize_shift | host
+    clusters.append(pad(compressed, QCOW2_CLUSTER))
+
+    # A host cluster holding compressed data is referenced once for every
+    # compressed cluster stored in it.
+    refcounts = [1] * (start // QCOW2_CLUSTER)
+    refcounts += [0] * (len(b"".join(clusters)) // QCOW2_CLUSTER - len(refcounts))
+    for (_, offset), end in zip(compressed_at, ends):
+        for cluster in range((start + offset) // QCOW2_CLUSTER, (start + end - 1) // QCOW2_CLUSTER + 1):
+            refcounts[cluster] += 1
+    clusters[refcount_table] = pad(struct.pack(">Q", refcount_block * QCOW2_CLUSTER), QCOW2_CLUSTER)
+    clusters[refcount_block] = pad(b"".join(struct.pack(">H", r) for r in refcounts), QCOW2_CLUSTER)
+    clusters[l1_table] = pad(struct.pack(">Q", l2_table * QCOW2_CLUSTER | QCOW2_COPIED), QCOW2_CLUSTER)
+    clusters[l2_table] = b"".join(struct.pack(">Q", e) for e in l2)
+
+    incompatible = 0
+    compression_type = 0
+    if compression == "zstd":
+        incompatible |= 1 << 3
+        compression_type = 1
+
+    extensions = b""
+    if backing:
+        extensions += struct.pack(">II", 0xE2792ACA, 3) + pad(b"raw", 8)
+    extensions += struct.pack(">II", 0, 0)
+    backing_offset = 112 + len(extensions) if backing else 0
+
+    header = b"QFI\xfb" + struct.pack(">IQIIQIIQQIIQ", 3, backing_offset, len(backing), QCOW2_CLUSTER_BITS, size, 0, 1, l1_table * QCOW2_CLUSTER, refcount_table * QCOW2_CLUSTER, 1, 0, 0)
+    header += struct.pack(">QQQII", incompatible, 0, 0, 4, 112) + pad(bytes([compression_type]), 8)
+    clusters[0] = pad(header + extensions + backing, QCOW2_CLUSTER)
+    return b"".join(clusters)
+
+
+# VMDK
+
+VMDK_GRAIN_SECTORS = 16
+VMDK_GRAIN = VMDK_GRAIN_SECTORS * SECTOR
+VMDK_GTES = 512
+
+
+def vmdk_descriptor(create_type, extents):
+    lines = [
+        "# Disk DescriptorFile",
+        "version=1",
+        "CID=7c1ba3e5",
+        "parentCID=ffffffff",
+        'createType="%s"' % create_type,
+        "",
+        "# Extent description",
+    ] + extents + [
+        "",
+        "# The Disk Data Base",
+        "#DDB",
+        "",
+        'ddb.adapterType = "lsilogic"',
+        'ddb.virtualHWVersion = "4"',
+    ]
+    return ("\n".join(lines) + "\n").encode()
+
+
+def vmdk_header(version, flags, capacity, descriptor, descriptor_size, rgd, gd, overhead, compress):
+    header = b"KDMV" + struct.pack("<IIQQQQIQQQ", version, flags, capacity, VMDK_GRAIN_SECTORS, descriptor, descriptor_size, VMDK_GTES, rgd, gd, overhead)
+    header += b"\0\n \r\n" + struct.pack("<H", compress)
+    return pad(header, SECTOR)
+
+
+def vmdk_sparse(label, capacity, layout, descriptor=b"", start=0):
+    """Returns a hosted sparse extent.
+
+    The layout lists the kind of every grain: "data", "zeroed", or None
+    (unallocated). The capacity and the start of the extent in the virtual
+    disk are in sectors.
+    """
+    grains = (capacity + VMDK_GRAIN_SECTORS - 1) // VMDK_GRAIN_SECTORS
+    gd_entries = (grains + VMDK_GTES - 1) // VMDK_GTES
+    gt_sectors = VMDK_GTES * 4 // SECTOR
+    gd_sectors = (gd_entries * 4 + SECTOR - 1) // SECTOR
+
+    descriptor_offset, descriptor_size = (1, 20) if descriptor else (0, 0)
+    rgd = 1 + descriptor_size
+    gd = rgd + gd_sectors + gd_entries * gt_sectors
+    overhead = gd + gd_sectors + gd_entries * gt_sectors
+    overhead = (overhead + VMDK_GRAIN_SECTORS - 1) // VMDK_GRAIN_SECTORS * VMDK_GRAIN_SECTORS
+
+    gt = [0] * (gd_entries * VMDK_GTES)
+    data = b""
+    for index, kind in enumerate(layout):
+        if kind == "data":
+            gt[index] = overhead + len(data) // SECTOR
+            data += sectors(label, start * SECTOR + index * VMDK_GRAIN, VMDK_GRAIN)
+        elif kind == "zeroed":
+            gt[index] = 1
+
+    def directory(start):
+        entries = [start + gd_sectors + i * gt_sectors for i in range(gd_entries)]
+        return pad(b"".join(struct.pack("<I", e) for e in entries), SECTOR) + b"".join(struct.pack("<I", e) for e in gt)
+
+    flags = 1 | 1 << 1 | 1 << 2
+    image = vmdk_header(1, flags, capacity, descriptor_offset, descriptor_size, rgd, gd, overhead, 0)
+    image += pad(descriptor, SECTOR * descriptor_size) if descriptor else b""
+    image += directory(rgd) + directory(gd)
+    return pad(image, overhead * SECTOR) + data
+
+
+def vmdk_stream(label, capacity, layout):
+    """Returns a stream-optimized extent with compressed grains."""
+    descriptor = vmdk_descriptor("streamOptimized", ['RW %d SPARSE "stream.vmdk"' % capacity])
+    flags = 1 | 1 << 16 | 1 << 17
+    gd_at_end = 0xFFFFFFFFFFFFFFFF
+    image = vmdk_header(3, flags, capacity, 1, 20, 0, gd_at_end, 32, 1)
+    image += pad(descriptor, SECTOR * 20)
+    image = pad(image, SECTOR * 32)
+
+    def marker(sectors, kind):
+        return pad(struct.pack("<QII", sectors, 0, kind), SECTOR)
+
+    gt = [0] * VMDK_GTES
+    for index, kind in enumerate(layout):
+        if kind == "data":
+            gt[index] = len(image) // SECTOR
+            grain = zlib.compress(sectors(label, index * VMDK_GRAIN, VMDK_GRAIN))
+            image += pad(struct.pack("<QI", index * VMDK_GRAIN_SECTORS, len(grain)) + grain, SECTOR)
+
+    image += marker(4, 1)
+    gt_sector = len(image) // SECTOR
+    image += b"".join(struct.pack("<I", e) for e in gt)
+    image += marker(1, 2)
+    gd = len(image) // SECTOR
+    image += pad(struct.pack("<I", gt_sector), SECTOR)
+    image += marker(1, 3)
+    image += vmdk_header(3, flags, capacity, 1, 20, 0, gd, 32, 1)
+    image += bytes(SECTOR)
+    return image
+
+
+# VHD
+
+VHD_FIXED = 2
+VHD_DYNAMIC = 3
+
+
+def vhd_checksum(data):
+    return ~sum(data) & 0xFFFFFFFF
+
+
+def vhd_footer(size, disk_type, data_offset):
+    cylinders, heads, spt = 1, 4, 17
+    footer = b"conectix" + struct.pack(">IIQI", 2, 0x00010000, data_offset, 0x2B8A2E00)
+    footer += b"ctrx" + struct.pack(">I", 0x00010000) + b"Wi2k"
+    footer += struct.pack(">QQHBBI", size, size, cylinders, heads, spt, disk_type)
+    footer += struct.pack(">I", 0) + uuid.UUID("5bb7cb6e-7c4b-4b8e-9b1f-3a6b2d1e4f50").bytes + b"\0"
+    footer = pad(footer, SECTOR)
+    return footer[:64] + struct.pack(">I", vhd_checksum(footer)) + footer[68:]
+
+
+def vhd_fixed(label, size):
+    return sectors(label, 0, size) + vhd_footer(size, VHD_FIXED, 0xFFFFFFFFFFFFFFFF)
+
+
+def vhd_dynamic(label, size, blocksize, layout):
+    """Returns a dynamic VHD. The layout lists the allocated blocks."""
+    entries = (size + blocksize - 1) // blocksize
+    bat_offset = 3 * SECTOR
+    bat_sectors = (entries * 4 + SECTOR - 1) // SECTOR
+    bitmap = pad(b"\xff" * (blocksize // SECTOR // 8), SECTOR)
+
+    bat = [0xFFFFFFFF] * entries
+    blocks = b""
+    first = (bat_offset + bat_sectors * SECTOR) // SECTOR
+    for index, allocated in enumerate(layout):
+        if allocated:
+            bat[index] = first + len(blocks) // SECTOR
+            blocks += bitmap + sectors(label, index * blocksize, blocksize)
+
+    header = b"cxsparse" + struct.pack(">QQIII", 0xFFFFFFFFFFFFFFFF, bat_offset, 0x00010000, entries, blocksize)
+    header += struct.pack(">I", 0) + bytes(16) + struct.pack(">II", 0, 0)
+    header = pad(header, 1024)
+    header = header[:36] + struct.pack(">I", vhd_checksum(header)) + header[40:]
+
+    footer = vhd_footer(size, VHD_DYNAMIC, SECTOR)
+    table = pad(b"".join(struct.pack(">I", e) for e in bat), SECTOR, b"\xff")
+    return footer + header + table + blocks + footer
+
+
+# VHDX
+
+VHDX_KB = 1024
+VHDX_MB = 1024 * 1024
+
+
+def crc32c(data):
+    crc = 0xFFFFFFFF
+    for b in data:
+        crc ^= b
+        for _ in range(8):
+            crc = crc >> 1 ^ 0x82F63B78 if crc & 1 else crc >> 1
+    return crc ^ 0xFFFFFFFF
+
+
+def guid(s):
+    return uuid.UUID(s).bytes_le
+
+
+def vhdx_checksum(data):
+    return data[:4] + struct.pack("<I", crc32c(data[:4] + bytes(4) + data[8:])) + data[8:]
+
+
+def vhdx(label, size, blocksize, layout):
+    """Returns a VHDX. The layout lists the state of every payload block:
+    "present", "zero", or None (not present)."""
+    log_offset, metadata_offset, bat_offset, payload_offset = VHDX_MB, 2 * VHDX_MB, 3 * VHDX_MB, 4 * VHDX_MB
+
+    identifier = b"vhdxfile" + "container-explorer".encode("utf-16-le")
+
+    def header(sequence):
+        h = b"head" + bytes(4) + struct.pack("<Q", sequence)
+        h += guid("1e0b4f3a-5c2d-4e6f-8a7b-9c0d1e2f3a4b") + guid("2f1c5a4b-6d3e-4f70-9b8c-0d1e2f3a4b5c") + bytes(16)
+        h += struct.pack("<HHIQ", 0, 1, VHDX_MB, log_offset)
+        return vhdx_checksum(pad(h, 4 * VHDX_KB))
+
+    def region_table():
+        t = b"regi" + bytes(4) + struct.pack("<II", 2, 0)
+        t += guid("2dc27766-f623-4200-9d64-115e9bfd4a08") + struct.pack("<QII", bat_offset, VHDX_MB, 1)
+        t += guid("8b7ca206-4790-4b9a-b8fe-575f050f886e") + struct.pack("<QII", metadata_offset, VHDX_MB, 1)
+        return vhdx_checksum(pad(t, 64 * VHDX_KB))
+
+    items = [
+        ("caa16737-fa36-4d43-b3b6-33f0aa44e76b", struct.pack("<II", blocksize, 0), 4),
+        ("2fa54224-cd1b-4876-b211-5dbed83bf4b8", struct.pack("<Q", size), 6),
+        ("beca12ab-b2e6-4523-93ef-c309e000c746", guid("3a4b5c6d-7e8f-4a0b-9c1d-2e3f4a5b6c7d"), 6),
+        ("8141bf1d-a96f-4709-ba47-f233a8faab5f", struct.pack("<I", SECTOR), 6),
+        ("cda348c7-445d-4471-9cc9-e9885251c556", struct.pack("<I", 4096), 6),
+    ]
+    table = b"metadata" + struct.pack("<HH", 0, len(items)) + bytes(20)
+    values = b""
+    for item, value, flags in items:
+        table += guid(item) + struct.pack("<IIII", 64 * VHDX_KB + len(values), len(value), flags, 0)
+        values += pad(value, 8)
+    metadata = pad(table, 64 * VHDX_KB) + values
+
+    bat = b""
+    payload = b""
+    for index, state in enumerate(layout):
+        if state == "present":
+            offset = payload_offset + len(payload)
+            bat += struct.pack("<Q", offset // VHDX_MB << 20 | 6)
+            payload += sectors(label, index * blocksize, blocksize)
+        elif state == "zero":
+            bat += struct.pack("<Q", 2)
+        else:
+            bat += struct.pack("<Q", 0)
+
+    image = pad(identifier, 64 * VHDX_KB)
+    image += pad(header(1), 64 * VHDX_KB) + pad(header(2), 64 * VHDX_KB)
+    image += region_table() + region_table()
+    image = pad(image, log_offset)
+    image = pad(image + bytes(VHDX_MB), metadata_offset)
+    image = pad(image + metadata, bat_offset)
+    image = pad(image + bat, payload_offset)
+    return image + payload
+
+
+def write(name, data):
+    with open(os.path.join(os.path.dirname(os.path.abspath(__file__)), name), "wb") as f:
+        f.write(data)
+
+
+if __name__ == "__main__":
+    write("base.raw", sectors(b"base", 0, 8 * QCOW2_CLUSTER))
+    write("qcow2.qcow2", qcow2(b"qcow2", ["data", "zero", None, "compressed", "compressed", "data", None, "prealloc"], b"base.raw", "deflate"))
+    write("zstd.qcow2", qcow2(b"zstd", ["data", "compressed", "compressed", None], b"", "zstd"))
+
+    sparse_descriptor = vmdk_descriptor("monolithicSparse", ['RW 120 SPARSE "sparse.vmdk"'])
+    write("sparse.vmdk", vmdk_sparse(b"sparse", 120, ["data", None, "zeroed", "data", None, None, "data", "data"], sparse_descriptor))
+    write("stream.vmdk", vmdk_stream(b"stream", 64, ["data", None, "data", "data"]))
+
+    write("flat-flat.vmdk", sectors(b"flat", 0, 64 * SECTOR))
+    write("flat.vmdk", vmdk_descriptor("monolithicFlat", ['RW 64 FLAT "flat-flat.vmdk" 0']))
+    write("split-s001.vmdk", vmdk_sparse(b"split", 32, ["data", "data"]))
+    write("split-s002.vmdk", vmdk_sparse(b"split", 32, [None, "data"], start=32))
+    write("split.vmdk", vmdk_descriptor("twoGbMaxExtentSparse", ['RW 32 SPARSE "split-s001.vmdk"', 'RW 32 SPARSE "split-s002.vmdk"']))
+    write("device.vmdk", vmdk_descriptor("partitionedDevice", ['RW 32 FLAT "flat-flat.vmdk" 16', "RW 16 ZERO"]))
+
+    write("fixed.vhd", vhd_fixed(b"fixed", 16 * SECTOR))
+    write("dynamic.vhd", vhd_dynamic(b"dynamic", 14 * VHDX_KB, 4 * VHDX_KB, [True, False, True, True]))
+
+    data = vhdx(b"vhdx", 3 * VHDX_MB + 64 * VHDX_KB, VHDX_MB, ["present", None, "zero", "present"])
+    write("vhdx.vhdx.gz", gzip.compress(data, mtime=0))
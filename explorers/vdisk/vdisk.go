@@ -0,0 +1,201 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vdisk provides pure Go readers of the virtual disk formats of
+// cloud and hypervisor VM exports i.e. qcow2, VMDK, VHDX, and VHD.
+//
+// A reader exposes the virtual disk media data using io.ReaderAt, so the
+// partition table and the filesystems are read without converting the
+// virtual disk to a raw image.
+package vdisk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Virtual disk formats.
+const (
+	FormatQcow2 = "qcow2"
+	FormatVMDK  = "vmdk"
+	FormatVHDX  = "vhdx"
+	FormatVHD   = "vhd"
+)
+
+// Disk is the media data of a virtual disk.
+type Disk interface {
+	io.ReaderAt
+	io.Closer
+
+	// Size returns the virtual disk size in bytes.
+	Size() int64
+}
+
+// FlatDisk is implemented by the virtual disks that may store the media
+// data contiguously in a single file i.e. a fixed VHD or a VMDK with a
+// single flat extent. The media data can be read in place using a loop
+// device.
+type FlatDisk interface {
+	Disk
+
+	// Flat returns the path of the file and the offset of the media data
+	// or an empty path if the media data is not contiguous.
+	Flat() (string, int64)
+}
+
+// Detect returns the virtual disk format of the file or an empty string.
+func Detect(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(f, header)
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, qcow2Magic):
+		return FormatQcow2
+	case bytes.HasPrefix(header, vmdkMagic), bytes.HasPrefix(header, vmdkDescriptorSignature):
+		return FormatVMDK
+	case bytes.HasPrefix(header, vhdxSignature):
+		return FormatVHDX
+	}
+
+	// The VHD footer is at the end of the file. A dynamic VHD also has a
+	// copy of the footer at the start of the file.
+	if bytes.HasPrefix(header, vhdCookie) {
+		return FormatVHD
+	}
+	if info, err := f.Stat(); err == nil && info.Size() >= vhdFooterSize {
+		footer := make([]byte, len(vhdCookie))
+		if _, err := f.ReadAt(footer, info.Size()-vhdFooterSize); err == nil && bytes.Equal(footer, vhdCookie) {
+			return FormatVHD
+		}
+	}
+	return ""
+}
+
+// Open opens a virtual disk.
+func Open(path string) (Disk, error) {
+	switch format := Detect(path); format {
+	case FormatQcow2:
+		return openQcow2(path, 0)
+	case FormatVMDK:
+		return openVMDK(path)
+	case FormatVHDX:
+		return openVHDX(path)
+	case FormatVHD:
+		return openVHD(path)
+	}
+	return nil, fmt.Errorf("%s is not a supported virtual disk", path)
+}
+
+// rawDisk is a raw image i.e. the backing file of a qcow2 image.
+type rawDisk struct {
+	*os.File
+	size int64
+}
+
+// OpenRaw opens a raw image. The media data of a raw image is the file.
+func OpenRaw(path string) (Disk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &rawDisk{File: f, size: info.Size()}, nil
+}
+
+// Size returns the raw image size.
+func (r *rawDisk) Size() int64 {
+	return r.size
+}
+
+// readFull reads the data at the offset. The data after the end of the file
+// is zero.
+func readFull(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if err == io.EOF {
+		for i := n; i < len(p); i++ {
+			p[i] = 0
+		}
+		return nil
+	}
+	return err
+}
+
+// checkTable returns an error if a table of length bytes at the offset is
+// not within the file. The tables are allocated from the header values, so
+// a corrupt header must not allocate more than the file holds.
+func checkTable(f *os.File, offset int64, length int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if offset < 0 || length < 0 || offset > info.Size() || length > info.Size()-offset {
+		return fmt.Errorf("table of %d bytes at offset %d is outside the file of %d bytes", length, offset, info.Size())
+	}
+	return nil
+}
+
+// zero fills the buffer with zero bytes.
+func zero(p []byte) {
+	for i := range p {
+		p[i] = 0
+	}
+}
+
+// readBlocks reads the media data at the offset one block at a time.
+//
+// The readBlock function reads the block data into p starting at the offset
+// within the block. The data after the media size is not read.
+func readBlocks(p []byte, off int64, size int64, blocksize int64, readBlock func(p []byte, block int64, offset int64) error) (int, error) {
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	var n int
+	for n < len(p) && off < size {
+		block := off / blocksize
+		within := off % blocksize
+		count := blocksize - within
+		if remaining := int64(len(p) - n); count > remaining {
+			count = remaining
+		}
+		if remaining := size - off; count > remaining {
+			count = remaining
+		}
+
+		if err := readBlock(p[n:n+int(count)], block, within); err != nil {
+			return n, err
+		}
+		n += int(count)
+		off += count
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
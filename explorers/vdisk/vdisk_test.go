@@ -0,0 +1,225 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The virtual disks in testdata are written by generate.py using an
+// independent implementation of the virtual disk formats. Every sector of the
+// media data stored in a virtual disk holds "<label> sector <n>".
+
+// sectors returns the media data of the sectors starting at the sector
+// number or zero for an empty label.
+func sectors(label string, sector int64, size int64) []byte {
+	b := make([]byte, size)
+	if label == "" {
+		return b
+	}
+	for off := int64(0); off < size; off += 512 {
+		copy(b[off:], fmt.Sprintf("%s sector %d\n", label, sector+off/512))
+	}
+	return b
+}
+
+// fixture returns the path of a virtual disk in testdata. A gzip compressed
+// virtual disk is decompressed to a temporary directory.
+func fixture(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if !strings.HasSuffix(name, ".gz") {
+		return path
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path = filepath.Join(t.TempDir(), strings.TrimSuffix(name, ".gz"))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// copyFixture copies the files of testdata to a temporary directory and
+// returns the directory.
+func copyFixture(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// corruptFixture copies a file of testdata to a temporary directory and
+// overwrites the bytes at the offset with the value.
+func corruptFixture(t *testing.T, name string, offset int, value []byte) string {
+	t.Helper()
+	path := filepath.Join(copyFixture(t, name), name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data[offset:], value)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkOpenError checks that opening the virtual disk fails with an error
+// containing want.
+func checkOpenError(t *testing.T, path string, want string) {
+	t.Helper()
+	d, err := Open(path)
+	if err == nil {
+		d.Close()
+		t.Fatalf("Open(%s) returned no error, want %q", path, want)
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("Open(%s) returned error %q, want %q", path, err, want)
+	}
+}
+
+// openDisk opens a virtual disk and checks the detected format.
+func openDisk(t *testing.T, path string, format string) Disk {
+	t.Helper()
+	if got := Detect(path); got != format {
+		t.Fatalf("Detect(%s) = %q, want %q", path, got, format)
+	}
+	d, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(%s) returned error: %v", path, err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// checkMedia compares the media data of the virtual disk with the expected
+// media data. The reads are not aligned to the blocks, so every read
+// crosses block boundaries.
+func checkMedia(t *testing.T, d Disk, want []byte) {
+	t.Helper()
+	size := int64(len(want))
+	if d.Size() != size {
+		t.Fatalf("Size() = %d, want %d", d.Size(), size)
+	}
+
+	got := make([]byte, size)
+	if n, err := d.ReadAt(got, 0); err != nil || n != len(got) {
+		t.Fatalf("ReadAt(0) = %d, %v, want %d, nil", n, err, len(got))
+	}
+	if !bytes.Equal(got, want) {
+		for i := int64(0); i < size; i += 512 {
+			if !bytes.Equal(got[i:i+512], want[i:i+512]) {
+				t.Fatalf("sector %d = %q, want %q", i/512, bytes.TrimRight(got[i:i+512], "\x00"), bytes.TrimRight(want[i:i+512], "\x00"))
+			}
+		}
+	}
+
+	buf := make([]byte, 1536)
+	for off := int64(0); off < size; off += 1000 {
+		end := off + int64(len(buf))
+		if end > size {
+			end = size
+		}
+		n, err := d.ReadAt(buf, off)
+		if int64(n) != end-off {
+			t.Fatalf("ReadAt(%d) read %d bytes, want %d", off, n, end-off)
+		}
+		if end == size && err != io.EOF {
+			t.Fatalf("ReadAt(%d) returned %v at the end of the media, want io.EOF", off, err)
+		}
+		if end < size && err != nil {
+			t.Fatalf("ReadAt(%d) returned error: %v", off, err)
+		}
+		if !bytes.Equal(buf[:n], want[off:end]) {
+			t.Fatalf("ReadAt(%d) returned wrong data", off)
+		}
+	}
+
+	if n, err := d.ReadAt(buf, size); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt(%d) = %d, %v, want 0, io.EOF", size, n, err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{"qcow2.qcow2", FormatQcow2},
+		{"sparse.vmdk", FormatVMDK},
+		{"stream.vmdk", FormatVMDK},
+		{"split.vmdk", FormatVMDK},
+		{"split-s002.vmdk", FormatVMDK},
+		{"fixed.vhd", FormatVHD},
+		{"dynamic.vhd", FormatVHD},
+		{"vhdx.vhdx.gz", FormatVHDX},
+		{"base.raw", ""},
+		{"flat-flat.vmdk", ""},
+		{"missing.raw", ""},
+	}
+	for _, tc := range tests {
+		path := filepath.Join("testdata", tc.name)
+		if tc.format == FormatVHDX {
+			path = fixture(t, tc.name)
+		}
+		if got := Detect(path); got != tc.format {
+			t.Errorf("Detect(%s) = %q, want %q", tc.name, got, tc.format)
+		}
+	}
+
+	if _, err := Open(filepath.Join("testdata", "base.raw")); err == nil {
+		t.Errorf("Open(base.raw) returned no error for a raw image")
+	}
+}
+
+func TestOpenRaw(t *testing.T) {
+	d, err := OpenRaw(filepath.Join("testdata", "base.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	checkMedia(t, d, sectors("base", 0, 32768))
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// vhdCookie starts the footer of a Virtual PC VHD i.e. an Azure page blob.
+var vhdCookie = []byte("conectix")
+
+const (
+	vhdFooterSize = 512
+	vhdSectorSize = 512
+
+	// Disk types.
+	vhdFixed        = 2
+	vhdDynamic      = 3
+	vhdDifferencing = 4
+
+	vhdUnusedBlock = 0xffffffff
+)
+
+// vhd is a fixed, dynamic, or differencing VHD.
+type vhd struct {
+	f          *os.File
+	path       string
+	size       int64
+	fixed      bool
+	blocksize  int64
+	bitmapsize int64
+	bat        []uint32
+}
+
+// openVHD opens a VHD and reads the block allocation table of a dynamic
+// VHD.
+func openVHD(path string) (*vhd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := readVHD(f, path)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading VHD %s: %w", path, err)
+	}
+	return v, nil
+}
+
+func readVHD(f *os.File, path string) (*vhd, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < vhdFooterSize {
+		return nil, fmt.Errorf("file is smaller than the footer")
+	}
+
+	// The footer is at the end of the file. A dynamic VHD also has a copy
+	// at the start of the file that is used if the end was truncated.
+	footer := make([]byte, vhdFooterSize)
+	if err := readFull(f, footer, info.Size()-vhdFooterSize); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(footer, vhdCookie) {
+		if err := readFull(f, footer, 0); err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(footer, vhdCookie) {
+			return nil, fmt.Errorf("footer not found")
+		}
+		log.WithField("path", path).Warn("VHD footer is missing at the end of the file. Using the footer copy")
+	}
+
+	be := binary.BigEndian
+	v := &vhd{
+		f:    f,
+		path: path,
+		size: int64(be.Uint64(footer[48:])),
+	}
+
+	switch disktype := be.Uint32(footer[60:]); disktype {
+	case vhdFixed:
+		v.fixed = true
+		return v, nil
+	case vhdDifferencing:
+		log.WithField("path", path).Warn("VHD is a differencing disk. Reading blocks of the parent disk as zero")
+	case vhdDynamic:
+	default:
+		return nil, fmt.Errorf("unsupported disk type %d", disktype)
+	}
+
+	header := make([]byte, 1024)
+	if err := readFull(f, header, int64(be.Uint64(footer[16:]))); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, []byte("cxsparse")) {
+		return nil, fmt.Errorf("invalid dynamic disk header")
+	}
+	v.blocksize = int64(be.Uint32(header[32:]))
+	if v.blocksize == 0 || v.blocksize%vhdSectorSize != 0 {
+		return nil, fmt.Errorf("invalid block size %d", v.blocksize)
+	}
+
+	// Every block starts with the sector bitmap padded to a sector.
+	bitmap := v.blocksize / vhdSectorSize / 8
+	v.bitmapsize = (bitmap + vhdSectorSize - 1) / vhdSectorSize * vhdSectorSize
+
+	entries := be.Uint32(header[28:])
+	batoffset := int64(be.Uint64(header[16:]))
+	if err := checkTable(f, batoffset, int64(entries)*4); err != nil {
+		return nil, fmt.Errorf("reading block allocation table: %w", err)
+	}
+	bat := make([]byte, int64(entries)*4)
+	if err := readFull(f, bat, batoffset); err != nil {
+		return nil, fmt.Errorf("reading block allocation table: %w", err)
+	}
+	v.bat = make([]uint32, entries)
+	for i := range v.bat {
+		v.bat[i] = be.Uint32(bat[i*4:])
+	}
+	return v, nil
+}
+
+// Size returns the virtual disk size.
+func (v *vhd) Size() int64 {
+	return v.size
+}
+
+// Flat returns the path of a fixed VHD. The media data of a fixed VHD starts
+// at the start of the file.
+func (v *vhd) Flat() (string, int64) {
+	if !v.fixed {
+		return "", 0
+	}
+	return v.path, 0
+}
+
+// Close closes the VHD.
+func (v *vhd) Close() error {
+	return v.f.Close()
+}
+
+// ReadAt reads the media data at the offset.
+func (v *vhd) ReadAt(p []byte, off int64) (int, error) {
+	if v.fixed {
+		return readBlocks(p, off, v.size, v.size, func(p []byte, _ int64, within int64) error {
+			return readFull(v.f, p, within)
+		})
+	}
+	return readBlocks(p, off, v.size, v.blocksize, v.readBlock)
+}
+
+// readBlock reads the dynamic disk block data.
+func (v *vhd) readBlock(p []byte, block int64, within int64) error {
+	if block >= int64(len(v.bat)) || v.bat[block] == vhdUnusedBlock {
+		zero(p)
+		return nil
+	}
+	return readFull(v.f, p, int64(v.bat[block])*vhdSectorSize+v.bitmapsize+within)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVHDFixed(t *testing.T) {
+	d := openDisk(t, filepath.Join("testdata", "fixed.vhd"), FormatVHD)
+	checkMedia(t, d, sectors("fixed", 0, 8192))
+
+	path, offset := d.(FlatDisk).Flat()
+	if want := filepath.Join("testdata", "fixed.vhd"); path != want || offset != 0 {
+		t.Errorf("Flat() = %s, %d, want %s, 0", path, offset, want)
+	}
+}
+
+// dynamicVHDMedia returns the media data of testdata/dynamic.vhd. The second
+// block is not allocated and the virtual disk ends in the middle of the last
+// block.
+func dynamicVHDMedia() []byte {
+	return bytes.Join([][]byte{
+		sectors("dynamic", 0, 4096),
+		sectors("", 0, 4096),
+		sectors("dynamic", 16, 6144),
+	}, nil)
+}
+
+func TestVHDDynamic(t *testing.T) {
+	d := openDisk(t, filepath.Join("testdata", "dynamic.vhd"), FormatVHD)
+	checkMedia(t, d, dynamicVHDMedia())
+
+	if path, _ := d.(FlatDisk).Flat(); path != "" {
+		t.Errorf("Flat() = %s for a dynamic VHD", path)
+	}
+}
+
+// TestVHDFooterCopy reads a dynamic VHD with the footer at the end of the
+// file truncated using the footer copy at the start of the file.
+func TestVHDFooterCopy(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "dynamic.vhd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "truncated.vhd")
+	if err := os.WriteFile(path, data[:len(data)-vhdFooterSize], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := openDisk(t, path, FormatVHD)
+	checkMedia(t, d, dynamicVHDMedia())
+}
+
+// TestVHDCorruptBAT checks that the block allocation table of a corrupt
+// dynamic disk header is checked against the file size before it is
+// allocated.
+func TestVHDCorruptBAT(t *testing.T) {
+	// The dynamic disk header of testdata/dynamic.vhd is at offset 512.
+	path := corruptFixture(t, "dynamic.vhd", 512+28, []byte{0xff, 0xff, 0xff, 0xff})
+	checkOpenError(t, path, "outside the file")
+}
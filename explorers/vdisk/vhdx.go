@@ -0,0 +1,237 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// vhdxSignature is the file type identifier of a Hyper-V or Azure VHDX.
+var vhdxSignature = []byte("vhdxfile")
+
+const (
+	vhdxHeaderOffset      = 64 * 1024
+	vhdxRegionTableOffset = 192 * 1024
+	vhdxMB                = 1024 * 1024
+
+	// Region and metadata item GUIDs.
+	vhdxBATRegion       = "2dc27766-f623-4200-9d64-115e9bfd4a08"
+	vhdxMetadataRegion  = "8b7ca206-4790-4b9a-b8fe-575f050f886e"
+	vhdxFileParameters  = "caa16737-fa36-4d43-b3b6-33f0aa44e76b"
+	vhdxVirtualDiskSize = "2fa54224-cd1b-4876-b211-5dbed83bf4b8"
+	vhdxLogicalSector   = "8141bf1d-a96f-4709-ba47-f233a8faab5f"
+
+	vhdxHasParentFlag = uint32(1) << 1
+
+	// Payload block states. The other states i.e. zero or not present
+	// are read as zero.
+	vhdxBlockUnmapped         = 3
+	vhdxBlockFullyPresent     = 6
+	vhdxBlockPartiallyPresent = 7
+)
+
+// vhdx is a Hyper-V virtual disk i.e. a Hyper-V or an Azure VM export.
+type vhdx struct {
+	f          *os.File
+	size       int64
+	blocksize  int64
+	chunkRatio int64
+	bat        []uint64
+}
+
+// openVHDX opens a VHDX and reads the block allocation table.
+func openVHDX(path string) (*vhdx, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := readVHDX(f, path)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading VHDX %s: %w", path, err)
+	}
+	return v, nil
+}
+
+func readVHDX(f *os.File, path string) (*vhdx, error) {
+	le := binary.LittleEndian
+
+	// The current header is the valid header with the highest sequence
+	// number.
+	var current []byte
+	for _, offset := range []int64{vhdxHeaderOffset, 2 * vhdxHeaderOffset} {
+		header := make([]byte, 80)
+		if err := readFull(f, header, offset); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(header[:4], []byte("head")) {
+			continue
+		}
+		if current == nil || le.Uint64(header[8:]) > le.Uint64(current[8:]) {
+			current = header
+		}
+	}
+	if current == nil {
+		return nil, fmt.Errorf("no valid header found")
+	}
+	if !bytes.Equal(current[48:64], make([]byte, 16)) {
+		log.WithField("path", path).Warn("VHDX log was not replayed. The latest writes may be missing")
+	}
+
+	table := make([]byte, 64*1024)
+	if err := readFull(f, table, vhdxRegionTableOffset); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(table[:4], []byte("regi")) {
+		return nil, fmt.Errorf("invalid region table signature")
+	}
+	regions := make(map[string][2]int64)
+	count := int(le.Uint32(table[8:]))
+	for i := 0; i < count && 16+(i+1)*32 <= len(table); i++ {
+		entry := table[16+i*32:]
+		regions[guidString(entry[:16])] = [2]int64{
+			int64(le.Uint64(entry[16:])),
+			int64(le.Uint32(entry[24:])),
+		}
+	}
+	metadata, found := regions[vhdxMetadataRegion]
+	if !found {
+		return nil, fmt.Errorf("metadata region not found")
+	}
+	batRegion, found := regions[vhdxBATRegion]
+	if !found {
+		return nil, fmt.Errorf("block allocation table region not found")
+	}
+
+	items, err := vhdxMetadata(f, metadata[0], metadata[1])
+	if err != nil {
+		return nil, err
+	}
+	parameters, sizeItem, sectorItem := items[vhdxFileParameters], items[vhdxVirtualDiskSize], items[vhdxLogicalSector]
+	if len(parameters) < 8 || len(sizeItem) < 8 || len(sectorItem) < 4 {
+		return nil, fmt.Errorf("required metadata items not found")
+	}
+
+	v := &vhdx{
+		f:         f,
+		blocksize: int64(le.Uint32(parameters)),
+		size:      int64(le.Uint64(sizeItem)),
+	}
+	sectorsize := int64(le.Uint32(sectorItem))
+	if v.blocksize == 0 || sectorsize == 0 {
+		return nil, fmt.Errorf("invalid block size %d or sector size %d", v.blocksize, sectorsize)
+	}
+	if le.Uint32(parameters[4:])&vhdxHasParentFlag != 0 {
+		log.WithField("path", path).Warn("VHDX is a differencing disk. Reading blocks of the parent disk as zero")
+	}
+
+	// A sector bitmap block entry follows every chunk ratio payload block
+	// entries.
+	v.chunkRatio = (int64(1) << 23) * sectorsize / v.blocksize
+	if v.chunkRatio == 0 {
+		return nil, fmt.Errorf("invalid chunk ratio")
+	}
+	blocks := (v.size + v.blocksize - 1) / v.blocksize
+	entries := blocks + (blocks-1)/v.chunkRatio
+	if entries*8 > batRegion[1] {
+		return nil, fmt.Errorf("block allocation table is smaller than the virtual disk")
+	}
+	if err := checkTable(f, batRegion[0], entries*8); err != nil {
+		return nil, fmt.Errorf("reading block allocation table: %w", err)
+	}
+	bat := make([]byte, entries*8)
+	if err := readFull(f, bat, batRegion[0]); err != nil {
+		return nil, fmt.Errorf("reading block allocation table: %w", err)
+	}
+	v.bat = make([]uint64, entries)
+	for i := range v.bat {
+		v.bat[i] = le.Uint64(bat[i*8:])
+	}
+	return v, nil
+}
+
+// vhdxMetadata returns the metadata items keyed by GUID.
+func vhdxMetadata(f *os.File, offset int64, length int64) (map[string][]byte, error) {
+	if err := checkTable(f, offset, length); err != nil {
+		return nil, fmt.Errorf("reading metadata region: %w", err)
+	}
+	region := make([]byte, length)
+	if err := readFull(f, region, offset); err != nil {
+		return nil, fmt.Errorf("reading metadata region: %w", err)
+	}
+	if !bytes.Equal(region[:8], []byte("metadata")) {
+		return nil, fmt.Errorf("invalid metadata table signature")
+	}
+
+	le := binary.LittleEndian
+	items := make(map[string][]byte)
+	count := int(le.Uint16(region[10:]))
+	for i := 0; i < count && 32+(i+1)*32 <= len(region); i++ {
+		entry := region[32+i*32:]
+		start := int64(le.Uint32(entry[16:]))
+		end := start + int64(le.Uint32(entry[20:]))
+		if end > int64(len(region)) {
+			continue
+		}
+		items[guidString(entry[:16])] = region[start:end]
+	}
+	return items, nil
+}
+
+// Size returns the virtual disk size.
+func (v *vhdx) Size() int64 {
+	return v.size
+}
+
+// Close closes the VHDX.
+func (v *vhdx) Close() error {
+	return v.f.Close()
+}
+
+// ReadAt reads the media data at the offset.
+func (v *vhdx) ReadAt(p []byte, off int64) (int, error) {
+	return readBlocks(p, off, v.size, v.blocksize, v.readBlock)
+}
+
+// readBlock reads the payload block data.
+func (v *vhdx) readBlock(p []byte, block int64, within int64) error {
+	index := block + block/v.chunkRatio
+	if index >= int64(len(v.bat)) {
+		return fmt.Errorf("block %d is outside the block allocation table", block)
+	}
+
+	entry := v.bat[index]
+	switch entry & 0x7 {
+	case vhdxBlockFullyPresent, vhdxBlockPartiallyPresent, vhdxBlockUnmapped:
+		if offset := int64(entry>>20) * vhdxMB; offset != 0 {
+			return readFull(v.f, p, offset+within)
+		}
+	}
+	zero(p)
+	return nil
+}
+
+// guidString returns the string form of a mixed-endian GUID.
+func guidString(b []byte) string {
+	le := binary.LittleEndian
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x", le.Uint32(b), le.Uint16(b[4:]), le.Uint16(b[6:]), b[8:10], b[10:16])
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestVHDX reads a VHDX with fully present, not present, and zero payload
+// blocks. The virtual disk ends in the middle of the last block.
+func TestVHDX(t *testing.T) {
+	d := openDisk(t, fixture(t, "vhdx.vhdx.gz"), FormatVHDX)
+
+	v := d.(*vhdx)
+	if v.blocksize != vhdxMB || v.chunkRatio != 4096 {
+		t.Errorf("block size %d and chunk ratio %d, want %d and 4096", v.blocksize, v.chunkRatio, vhdxMB)
+	}
+	checkMedia(t, d, bytes.Join([][]byte{
+		sectors("vhdx", 0, vhdxMB),
+		sectors("", 0, 2*vhdxMB),
+		sectors("vhdx", 3*vhdxMB/512, 64*1024),
+	}, nil))
+}
+
+// TestGUIDString checks the mixed-endian GUID of the metadata region in the
+// VHDX specification.
+func TestGUIDString(t *testing.T) {
+	b := []byte{0x06, 0xa2, 0x7c, 0x8b, 0x90, 0x47, 0x9a, 0x4b, 0xb8, 0xfe, 0x57, 0x5f, 0x05, 0x0f, 0x88, 0x6e}
+	if got := guidString(b); got != vhdxMetadataRegion {
+		t.Errorf("guidString(%x) = %s, want %s", b, got, vhdxMetadataRegion)
+	}
+}
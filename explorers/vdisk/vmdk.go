@@ -0,0 +1,462 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// vmdkMagic is the magic of a hosted sparse extent i.e. a monolithic
+	// sparse or a stream-optimized VMDK exported from vSphere.
+	vmdkMagic = []byte("KDMV")
+
+	// vmdkDescriptorSignature starts a descriptor file.
+	vmdkDescriptorSignature = []byte("# Disk DescriptorFile")
+)
+
+const (
+	vmdkSectorSize = 512
+
+	// vmdkGDAtEnd is the grain directory offset of a stream-optimized
+	// extent. The grain directory offset is in the footer at the end of the
+	// file.
+	vmdkGDAtEnd = 0xffffffffffffffff
+
+	vmdkZeroedGrainFlag = uint32(1) << 2
+	vmdkCompressedFlag  = uint32(1) << 16
+
+	// vmdkMaxDescriptorSize is the maximum size of a descriptor file.
+	vmdkMaxDescriptorSize = 1024 * 1024
+
+	// vmdkMaxGTEs is the maximum number of grain table entries. VMware
+	// always writes 512 entries and qemu rejects larger grain tables.
+	vmdkMaxGTEs = 512
+)
+
+// vmdkExtent is an extent of a VMDK disk.
+type vmdkExtent struct {
+	start  int64
+	size   int64
+	kind   string
+	path   string
+	offset int64
+	r      io.ReaderAt
+	closer io.Closer
+}
+
+// vmdk is a VMware virtual disk made of extents.
+type vmdk struct {
+	size    int64
+	extents []vmdkExtent
+}
+
+// openVMDK opens a monolithic sparse VMDK or a descriptor file and the
+// extent files.
+func openVMDK(path string) (*vmdk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, len(vmdkMagic))
+	if err := readFull(f, magic, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if bytes.Equal(magic, vmdkMagic) {
+		sparse, err := readVMDKSparse(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reading VMDK sparse extent %s: %w", path, err)
+		}
+		if descriptor, err := sparse.descriptor(); err == nil {
+			warnVMDKParent(path, descriptor)
+		}
+		return &vmdk{
+			size: sparse.size,
+			extents: []vmdkExtent{
+				{size: sparse.size, kind: "SPARSE", path: path, r: sparse, closer: f},
+			},
+		}, nil
+	}
+
+	descriptor, err := io.ReadAll(io.LimitReader(f, vmdkMaxDescriptorSize))
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	warnVMDKParent(path, descriptor)
+
+	v := &vmdk{}
+	if err := v.openExtents(filepath.Dir(path), descriptor); err != nil {
+		v.Close()
+		return nil, fmt.Errorf("reading VMDK descriptor %s: %w", path, err)
+	}
+	return v, nil
+}
+
+// warnVMDKParent warns about a delta disk. The grains not written since the
+// snapshot was taken are in the parent disk and are read as zero.
+func warnVMDKParent(path string, descriptor []byte) {
+	for _, line := range strings.Split(string(descriptor), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "parentCID") {
+			continue
+		}
+		if value := strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "parentCID")), "= \""); value != "ffffffff" {
+			log.WithField("path", path).Warn("VMDK is a snapshot delta disk. Reading grains of the parent disk as zero")
+		}
+	}
+}
+
+// openExtents opens the extents listed in the descriptor. An extent line is
+// i.e. RW 41943040 FLAT "disk-flat.vmdk" 0
+func (v *vmdk) openExtents(dir string, descriptor []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(descriptor))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		switch fields[0] {
+		case "RW", "RDONLY", "NOACCESS":
+		default:
+			continue
+		}
+
+		sectors, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid extent size %q", fields[1])
+		}
+		extent := vmdkExtent{
+			start: v.size,
+			size:  sectors * vmdkSectorSize,
+			kind:  fields[2],
+		}
+
+		if extent.kind != "ZERO" {
+			line := scanner.Text()
+			first := strings.Index(line, "\"")
+			last := strings.LastIndex(line, "\"")
+			if first < 0 || last <= first {
+				return fmt.Errorf("extent file name missing in %q", line)
+			}
+			extent.path = line[first+1 : last]
+			if !filepath.IsAbs(extent.path) {
+				extent.path = filepath.Join(dir, extent.path)
+			}
+			if rest := strings.Fields(line[last+1:]); len(rest) > 0 {
+				offset, err := strconv.ParseInt(rest[0], 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid extent offset %q", rest[0])
+				}
+				extent.offset = offset * vmdkSectorSize
+			}
+		}
+
+		switch extent.kind {
+		case "ZERO":
+		case "FLAT", "VMFS":
+			f, err := os.Open(extent.path)
+			if err != nil {
+				return err
+			}
+			extent.r = f
+			extent.closer = f
+		case "SPARSE":
+			f, err := os.Open(extent.path)
+			if err != nil {
+				return err
+			}
+			extent.closer = f
+			sparse, err := readVMDKSparse(f)
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("reading VMDK sparse extent %s: %w", extent.path, err)
+			}
+			extent.r = sparse
+		default:
+			return fmt.Errorf("unsupported VMDK extent type %s", extent.kind)
+		}
+
+		v.extents = append(v.extents, extent)
+		v.size += extent.size
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(v.extents) == 0 {
+		return fmt.Errorf("no extents found")
+	}
+	return nil
+}
+
+// Size returns the virtual disk size.
+func (v *vmdk) Size() int64 {
+	return v.size
+}
+
+// Flat returns the extent file of a disk with a single flat extent.
+func (v *vmdk) Flat() (string, int64) {
+	if len(v.extents) != 1 {
+		return "", 0
+	}
+	switch e := v.extents[0]; e.kind {
+	case "FLAT", "VMFS":
+		return e.path, e.offset
+	}
+	return "", 0
+}
+
+// Close closes the extent files.
+func (v *vmdk) Close() error {
+	for _, e := range v.extents {
+		if e.closer != nil {
+			e.closer.Close()
+		}
+	}
+	return nil
+}
+
+// ReadAt reads the media data at the offset.
+func (v *vmdk) ReadAt(p []byte, off int64) (int, error) {
+	if off >= v.size {
+		return 0, io.EOF
+	}
+
+	var n int
+	for _, e := range v.extents {
+		if n == len(p) || off >= v.size {
+			break
+		}
+		if off >= e.start+e.size {
+			continue
+		}
+
+		count := e.start + e.size - off
+		if remaining := int64(len(p) - n); count > remaining {
+			count = remaining
+		}
+		buf := p[n : n+int(count)]
+		if e.r == nil {
+			zero(buf)
+		} else if err := readFull(e.r, buf, e.offset+off-e.start); err != nil {
+			return n, err
+		}
+		n += int(count)
+		off += count
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// vmdkSparse is a hosted sparse extent. The grains are located using the
+// grain directory and the grain tables.
+type vmdkSparse struct {
+	f             *os.File
+	size          int64
+	flags         uint32
+	grainsize     int64
+	gtes          int64
+	gd            []uint32
+	descriptorOff int64
+	descriptorLen int64
+
+	mu    sync.Mutex
+	gts   map[uint32][]uint32
+	grain int64
+	data  []byte
+}
+
+// readVMDKSparse reads the header and the grain directory of a hosted
+// sparse extent.
+func readVMDKSparse(f *os.File) (*vmdkSparse, error) {
+	header := make([]byte, vmdkSectorSize)
+	if err := readFull(f, header, 0); err != nil {
+		return nil, err
+	}
+
+	le := binary.LittleEndian
+	if le.Uint64(header[56:]) == vmdkGDAtEnd {
+		// A stream-optimized extent ends with the footer marker, the
+		// footer, and the end-of-stream marker.
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if err := readFull(f, header, info.Size()-2*vmdkSectorSize); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(header[:4], vmdkMagic) {
+			return nil, fmt.Errorf("stream-optimized footer not found")
+		}
+	}
+
+	s := &vmdkSparse{
+		f:             f,
+		flags:         le.Uint32(header[8:]),
+		size:          int64(le.Uint64(header[12:])) * vmdkSectorSize,
+		grainsize:     int64(le.Uint64(header[20:])) * vmdkSectorSize,
+		descriptorOff: int64(le.Uint64(header[28:])) * vmdkSectorSize,
+		descriptorLen: int64(le.Uint64(header[36:])) * vmdkSectorSize,
+		gtes:          int64(le.Uint32(header[44:])),
+		gts:           make(map[uint32][]uint32),
+		grain:         -1,
+	}
+	if s.grainsize <= 0 || s.gtes == 0 || s.gtes > vmdkMaxGTEs {
+		return nil, fmt.Errorf("invalid grain size %d or grain table size %d", s.grainsize, s.gtes)
+	}
+	if s.size < 0 {
+		return nil, fmt.Errorf("invalid capacity %d", s.size)
+	}
+
+	gdoffset := int64(le.Uint64(header[56:])) * vmdkSectorSize
+	grains := (s.size + s.grainsize - 1) / s.grainsize
+	count := (grains + s.gtes - 1) / s.gtes
+	if err := checkTable(f, gdoffset, count*4); err != nil {
+		return nil, fmt.Errorf("reading grain directory: %w", err)
+	}
+	gd := make([]byte, count*4)
+	if err := readFull(f, gd, gdoffset); err != nil {
+		return nil, fmt.Errorf("reading grain directory: %w", err)
+	}
+	s.gd = make([]uint32, count)
+	for i := range s.gd {
+		s.gd[i] = le.Uint32(gd[i*4:])
+	}
+	return s, nil
+}
+
+// descriptor returns the embedded descriptor.
+func (s *vmdkSparse) descriptor() ([]byte, error) {
+	if s.descriptorOff == 0 || s.descriptorLen == 0 || s.descriptorLen > vmdkMaxDescriptorSize {
+		return nil, fmt.Errorf("no embedded descriptor")
+	}
+	descriptor := make([]byte, s.descriptorLen)
+	if err := readFull(s.f, descriptor, s.descriptorOff); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(descriptor, "\x00"), nil
+}
+
+// ReadAt reads the extent data at the offset.
+func (s *vmdkSparse) ReadAt(p []byte, off int64) (int, error) {
+	return readBlocks(p, off, s.size, s.grainsize, s.readGrain)
+}
+
+// readGrain reads the grain data.
+func (s *vmdkSparse) readGrain(p []byte, grain int64, within int64) error {
+	gte, err := s.gte(grain)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case gte == 0:
+		zero(p)
+		return nil
+	case gte == 1 && s.flags&vmdkZeroedGrainFlag != 0:
+		zero(p)
+		return nil
+	case s.flags&vmdkCompressedFlag != 0:
+		data, err := s.compressedGrain(grain, gte)
+		if err != nil {
+			return err
+		}
+		copy(p, data[within:])
+		return nil
+	}
+	return readFull(s.f, p, int64(gte)*vmdkSectorSize+within)
+}
+
+// gte returns the grain table entry of a grain i.e. the sector of the grain
+// data or zero for an unallocated grain.
+func (s *vmdkSparse) gte(grain int64) (uint32, error) {
+	index := grain / s.gtes
+	if index >= int64(len(s.gd)) || s.gd[index] == 0 {
+		return 0, nil
+	}
+	sector := s.gd[index]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gt, found := s.gts[sector]
+	if !found {
+		buf := make([]byte, s.gtes*4)
+		if err := readFull(s.f, buf, int64(sector)*vmdkSectorSize); err != nil {
+			return 0, fmt.Errorf("reading grain table at sector %d: %w", sector, err)
+		}
+		gt = make([]uint32, s.gtes)
+		for i := range gt {
+			gt[i] = binary.LittleEndian.Uint32(buf[i*4:])
+		}
+		s.gts[sector] = gt
+	}
+	return gt[grain%s.gtes], nil
+}
+
+// compressedGrain returns the decompressed data of a compressed grain. The
+// grain starts with a marker holding the grain LBA and the compressed size.
+func (s *vmdkSparse) compressedGrain(grain int64, sector uint32) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.grain == grain {
+		return s.data, nil
+	}
+
+	offset := int64(sector) * vmdkSectorSize
+	marker := make([]byte, 12)
+	if err := readFull(s.f, marker, offset); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(marker[8:])
+	compressed := make([]byte, size)
+	if err := readFull(s.f, compressed, offset+12); err != nil {
+		return nil, err
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing grain at sector %d: %w", sector, err)
+	}
+	defer r.Close()
+
+	data := make([]byte, s.grainsize)
+	if _, err := io.ReadFull(r, data); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("decompressing grain at sector %d: %w", sector, err)
+	}
+	s.grain = grain
+	s.data = data
+	return data, nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+const vmdkGrain = 8192
+
+// TestVMDKSparse reads a monolithic sparse VMDK with allocated, unallocated,
+// and zeroed grains. The virtual disk ends in the middle of the last grain.
+func TestVMDKSparse(t *testing.T) {
+	g := int64(vmdkGrain)
+	d := openDisk(t, filepath.Join("testdata", "sparse.vmdk"), FormatVMDK)
+	checkMedia(t, d, bytes.Join([][]byte{
+		sectors("sparse", 0, g),
+		sectors("", 0, 2*g),
+		sectors("sparse", 3*g/512, g),
+		sectors("", 0, 2*g),
+		sectors("sparse", 6*g/512, g+g/2),
+	}, nil))
+
+	if path, _ := d.(FlatDisk).Flat(); path != "" {
+		t.Errorf("Flat() = %s for a sparse extent", path)
+	}
+}
+
+func TestVMDKStreamOptimized(t *testing.T) {
+	g := int64(vmdkGrain)
+	d := openDisk(t, filepath.Join("testdata", "stream.vmdk"), FormatVMDK)
+	checkMedia(t, d, bytes.Join([][]byte{
+		sectors("stream", 0, g),
+		sectors("", 0, g),
+		sectors("stream", 2*g/512, 2*g),
+	}, nil))
+}
+
+func TestVMDKFlat(t *testing.T) {
+	d := openDisk(t, filepath.Join("testdata", "flat.vmdk"), FormatVMDK)
+	checkMedia(t, d, sectors("flat", 0, 32768))
+
+	path, offset := d.(FlatDisk).Flat()
+	if want := filepath.Join("testdata", "flat-flat.vmdk"); path != want || offset != 0 {
+		t.Errorf("Flat() = %s, %d, want %s, 0", path, offset, want)
+	}
+}
+
+// TestVMDKExtents reads the descriptor files with several extents.
+func TestVMDKExtents(t *testing.T) {
+	g := int64(vmdkGrain)
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{"split.vmdk", bytes.Join([][]byte{
+			sectors("split", 0, 2*g),
+			sectors("", 0, g),
+			sectors("split", 3*g/512, g),
+		}, nil)},
+		// The flat extent starts at sector 16 of the extent file.
+		{"device.vmdk", bytes.Join([][]byte{
+			sectors("flat", 16, 16384),
+			sectors("", 0, 8192),
+		}, nil)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := openDisk(t, filepath.Join("testdata", tc.name), FormatVMDK)
+			checkMedia(t, d, tc.want)
+			if path, _ := d.(FlatDisk).Flat(); path != "" {
+				t.Errorf("Flat() = %s for a disk with several extents", path)
+			}
+		})
+	}
+}
+
+// TestVMDKCorruptGrainDirectory checks that the grain directory of a corrupt
+// header is checked against the file size before it is allocated.
+func TestVMDKCorruptGrainDirectory(t *testing.T) {
+	le32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, v)
+		return b
+	}
+	le64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, v)
+		return b
+	}
+
+	for _, tc := range []struct {
+		name   string
+		offset int
+		value  []byte
+		want   string
+	}{
+		{"negative capacity", 12, le64(1 << 54), "invalid capacity"},
+		{"grain table larger than 512 entries", 44, le32(1 << 20), "invalid grain size"},
+		{"grain directory after the end of the file", 56, le64(1 << 40), "outside the file"},
+		// 1 PiB with 8 KiB grains needs a grain directory of 1 GiB.
+		{"grain directory larger than the file", 12, le64(1 << 41), "outside the file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			checkOpenError(t, corruptFixture(t, "sparse.vmdk", tc.offset, tc.value), tc.want)
+		})
+	}
+}
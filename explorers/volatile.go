@@ -0,0 +1,105 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	spec "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Volatile mount types.
+const (
+	VolatileTmpfs          = "tmpfs"
+	VolatileEmptyDirMemory = "emptydir-memory"
+	VolatileKubeletTmpfs   = "kubelet-tmpfs"
+)
+
+// Kubernetes volume plugin directories within the kubelet pod directory
+// /var/lib/kubelet/pods/<pod uid>/volumes.
+const (
+	kubeletEmptyDirVolume  = "/volumes/kubernetes.io~empty-dir/"
+	kubeletSecretVolume    = "/volumes/kubernetes.io~secret/"
+	kubeletProjectedVolume = "/volumes/kubernetes.io~projected/"
+)
+
+// VolatileMount describes a container mount that existed only in memory.
+//
+// The content written to a volatile mount never touched the disk and is not
+// recoverable from a disk image.
+type VolatileMount struct {
+	Destination string `json:"destination"`
+	Source      string `json:"source,omitempty"`
+	Type        string `json:"type"`
+	Note        string `json:"note"`
+}
+
+// VolatileMounts returns the mounts that existed only in memory.
+//
+// The tmpfs mounts, Kubernetes secret and projected volumes, and emptyDir
+// volumes with medium Memory are volatile. The spec does not record the
+// emptyDir medium. An emptyDir volume is reported as memory backed if the
+// volume directory within imageroot is empty or missing. The emptyDir check
+// is skipped if imageroot is empty.
+func VolatileMounts(imageroot string, mounts []spec.Mount) []VolatileMount {
+	var volatiles []VolatileMount
+
+	for _, m := range mounts {
+		switch {
+		case m.Type == "tmpfs":
+			// /dev is a tmpfs containing only the device nodes
+			if m.Destination == "/dev" {
+				continue
+			}
+			volatiles = append(volatiles, VolatileMount{
+				Destination: m.Destination,
+				Source:      m.Source,
+				Type:        VolatileTmpfs,
+				Note:        "tmpfs mount existed only in memory and is not recoverable from disk",
+			})
+		case strings.Contains(m.Source, kubeletSecretVolume), strings.Contains(m.Source, kubeletProjectedVolume):
+			volatiles = append(volatiles, VolatileMount{
+				Destination: m.Destination,
+				Source:      m.Source,
+				Type:        VolatileKubeletTmpfs,
+				Note:        "kubelet secret or projected volume is backed by tmpfs and is not recoverable from disk",
+			})
+		case strings.Contains(m.Source, kubeletEmptyDirVolume):
+			if imageroot == "" || !isEmptyDir(filepath.Join(imageroot, m.Source)) {
+				continue
+			}
+			volatiles = append(volatiles, VolatileMount{
+				Destination: m.Destination,
+				Source:      m.Source,
+				Type:        VolatileEmptyDirMemory,
+				Note:        "emptyDir volume directory is empty on disk and was likely backed by memory (medium: Memory)",
+			})
+		}
+	}
+	return volatiles
+}
+
+// isEmptyDir returns true if the directory is empty or does not exist.
+func isEmptyDir(path string) bool {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	return len(entries) == 0
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"strings"
+
+	spec "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Windows container isolation modes.
+const (
+	WindowsIsolationProcess = "process"
+	WindowsIsolationHyperV  = "hyperv"
+)
+
+// WindowsContainer holds the Windows container (WCOW) specific fields of a
+// container spec.
+//
+// The layers of a Windows container are NTFS directories with registry
+// hives and cannot be mounted on Linux. The fields are recorded for
+// analysis of a Windows node image.
+type WindowsContainer struct {
+	Isolation        string   `json:"isolation"`
+	LayerFolders     []string `json:"layer_folders,omitempty"`
+	NetworkNamespace string   `json:"network_namespace,omitempty"`
+	Endpoints        []string `json:"endpoints,omitempty"`
+	CredentialSpec   bool     `json:"credential_spec"` // gMSA credential spec is set
+	CommandLine      string   `json:"command_line,omitempty"`
+	User             string   `json:"user,omitempty"`
+}
+
+// NewWindowsContainer returns the Windows specific fields of a container
+// spec or nil if the spec is not a Windows spec.
+func NewWindowsContainer(s *spec.Spec) *WindowsContainer {
+	if s == nil || s.Windows == nil {
+		return nil
+	}
+
+	wc := &WindowsContainer{
+		Isolation:      WindowsIsolationProcess,
+		LayerFolders:   s.Windows.LayerFolders,
+		CredentialSpec: s.Windows.CredentialSpec != nil,
+	}
+	if s.Windows.HyperV != nil {
+		wc.Isolation = WindowsIsolationHyperV
+	}
+	if s.Windows.Network != nil {
+		wc.NetworkNamespace = s.Windows.Network.NetworkNamespace
+		wc.Endpoints = s.Windows.Network.EndpointList
+	}
+	if s.Process != nil {
+		wc.CommandLine = s.Process.CommandLine
+		if wc.CommandLine == "" {
+			wc.CommandLine = strings.Join(s.Process.Args, " ")
+		}
+		wc.User = s.Process.User.Username
+	}
+	return wc
+}
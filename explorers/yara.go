@@ -0,0 +1,221 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package explorers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// yaraCommand is used to scan files with YARA rules.
+const yaraCommand = "yara"
+
+// yaraCompiledMagic is the signature of the rules compiled using yarac.
+var yaraCompiledMagic = []byte("YARA")
+
+// yaraRuleExtensions are the file extensions of the YARA source rules in a
+// rules directory.
+var yaraRuleExtensions = map[string]bool{
+	".yar":  true,
+	".yara": true,
+}
+
+// YaraString is a string of a rule matched in a file.
+type YaraString struct {
+	Offset     int64  `json:"offset"`
+	Identifier string `json:"identifier"`
+	Data       string `json:"data"` // matched data as escaped by yara
+}
+
+// YaraMatch is a rule matching a file.
+type YaraMatch struct {
+	Rule    string       `json:"rule"`
+	Path    string       `json:"path"` // path of the scanned file on disk
+	Strings []YaraString `json:"strings,omitempty"`
+}
+
+// YaraScanner scans files with YARA rules using the yara command.
+type YaraScanner struct {
+	command  string
+	rules    []string // source rule files or a single compiled rules file
+	compiled bool
+	timeout  int // scan timeout in seconds per file. 0 is the yara default
+}
+
+// NewYaraScanner returns a scanner of the rules in a file or a directory.
+//
+// A directory is searched recursively for .yar and .yara source rule files.
+// A file is a source rule file or rules compiled using yarac.
+func NewYaraScanner(rules string, timeout int) (*YaraScanner, error) {
+	command, err := exec.LookPath(yaraCommand)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not installed: %w", yaraCommand, err)
+	}
+	s := &YaraScanner{
+		command: command,
+		timeout: timeout,
+	}
+
+	info, err := os.Stat(rules)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		s.rules = []string{rules}
+		s.compiled = isCompiledYaraRules(rules)
+		return s, nil
+	}
+
+	err = filepath.Walk(rules, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && yaraRuleExtensions[strings.ToLower(filepath.Ext(path))] {
+			s.rules = append(s.rules, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading rules directory %s: %w", rules, err)
+	}
+	if len(s.rules) == 0 {
+		return nil, fmt.Errorf("no .yar or .yara rule file found in %s", rules)
+	}
+	sort.Strings(s.rules)
+
+	log.WithFields(log.Fields{
+		"dir":   rules,
+		"files": len(s.rules),
+	}).Debug("read yara rules")
+	return s, nil
+}
+
+// isCompiledYaraRules returns true if the file holds rules compiled using
+// yarac.
+func isCompiledYaraRules(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(yaraCompiledMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, yaraCompiledMagic)
+}
+
+// Scan scans the files and returns the matching rules.
+//
+// The files are passed to a single yara process using a scan list, so the
+// rules are compiled once. The files that yara cannot read are logged by
+// yara and skipped.
+func (s *YaraScanner) Scan(paths []string) ([]YaraMatch, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	list, err := os.CreateTemp("", "container-explorer-yara-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(list.Name())
+	for _, path := range paths {
+		fmt.Fprintln(list, path)
+	}
+	if err := list.Close(); err != nil {
+		return nil, err
+	}
+
+	args := []string{"--print-strings", "--no-warnings"}
+	if s.timeout > 0 {
+		args = append(args, "--timeout", strconv.Itoa(s.timeout))
+	}
+	if s.compiled {
+		args = append(args, "--compiled-rules")
+	}
+	args = append(args, "--scan-list")
+	args = append(args, s.rules...)
+	args = append(args, list.Name())
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(s.command, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s: %v %s", yaraCommand, err, strings.TrimSpace(stderr.String()))
+	}
+	if stderr.Len() > 0 {
+		log.Debug("yara: ", strings.TrimSpace(stderr.String()))
+	}
+	return parseYaraOutput(bytes.NewReader(out)), nil
+}
+
+// parseYaraOutput parses the output of yara --print-strings i.e.
+//
+//	rule_name /path/to/file
+//	0x1a:$s1: matched data
+func parseYaraOutput(r io.Reader) []YaraMatch {
+	var matches []YaraMatch
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "0x") && len(matches) > 0 {
+			fields := strings.SplitN(line, ":", 3)
+			if len(fields) == 3 {
+				offset, err := strconv.ParseInt(strings.TrimPrefix(fields[0], "0x"), 16, 64)
+				if err == nil {
+					m := &matches[len(matches)-1]
+					m.Strings = append(m.Strings, YaraString{
+						Offset:     offset,
+						Identifier: fields[1],
+						Data:       strings.TrimPrefix(fields[2], " "),
+					})
+					continue
+				}
+			}
+		}
+
+		// The file path may contain spaces, the rule name cannot.
+		i := strings.Index(line, " ")
+		if i < 0 {
+			continue
+		}
+		matches = append(matches, YaraMatch{
+			Rule: line[:i],
+			Path: line[i+1:],
+		})
+	}
+	return matches
+}